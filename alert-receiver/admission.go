@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Admission modes for a job arriving at a full queue (QUEUE_ADMISSION).
+const (
+	// admitReject answers 503 with Retry-After so Grafana retries later.
+	admitReject = "reject"
+	// admitDegrade stores the alerts without enrichment or LLM analysis.
+	admitDegrade = "degrade"
+	// admitBlock waits up to QUEUE_BLOCK_TIMEOUT for room, then rejects.
+	admitBlock = "block"
+	// admitDropBySeverity drops alerts of QUEUE_DROP_SEVERITIES and blocks
+	// like admitBlock for the rest.
+	admitDropBySeverity = "drop-by-severity"
+)
+
+var admissionModes = []string{admitReject, admitDegrade, admitBlock, admitDropBySeverity}
+
+// admit queues job, applying the admission mode when the queue is full.
+// It answers the webhook itself, and reports whether the job was taken:
+// queued, stored degraded or dropped on purpose, rather than rejected.
+func (s *server) admit(w http.ResponseWriter, r *http.Request, job analysisJob) bool {
+	if s.queue.tryPush(job) {
+		s.queued(w, job, "queued")
+		return true
+	}
+
+	switch s.cfg.QueueAdmission {
+	case admitDegrade:
+		s.storeDegraded(job)
+		admissionTotal.WithLabelValues("degraded").Inc()
+		jobResultsTotal.WithLabelValues("degraded").Inc()
+		writeJSON(w, http.StatusAccepted, map[string]any{
+			"job_id": job.ID,
+			"status": "degraded",
+			"alerts": len(job.Payload.Alerts),
+		})
+		return true
+	case admitDropBySeverity:
+		if severity := payloadSeverity(job.Payload); slices.Contains(s.cfg.QueueDropSeverities, severity) {
+			admissionTotal.WithLabelValues("dropped").Inc()
+			jobResultsTotal.WithLabelValues("dropped").Inc()
+			slog.Warn("alert dropped on full queue", "job_id", job.ID, "severity", severity)
+			// 200, not 503: Grafana should not retry what was dropped on
+			// purpose.
+			writeJSON(w, http.StatusOK, map[string]any{
+				"job_id":   job.ID,
+				"status":   "dropped",
+				"severity": severity,
+			})
+			return true
+		}
+		fallthrough
+	case admitBlock:
+		ctx, cancel := context.WithTimeout(r.Context(), s.cfg.QueueBlockTimeout)
+		defer cancel()
+		if s.queue.push(ctx, job) {
+			s.queued(w, job, "queued_after_wait")
+			return true
+		}
+	}
+
+	admissionTotal.WithLabelValues("rejected").Inc()
+	jobResultsTotal.WithLabelValues("queue_full").Inc()
+	w.Header().Set("Retry-After", fmt.Sprint(int(s.cfg.QueueRetryAfter.Seconds())))
+	http.Error(w, "queue full", http.StatusServiceUnavailable)
+	return false
+}
+
+func (s *server) queued(w http.ResponseWriter, job analysisJob, decision string) {
+	admissionTotal.WithLabelValues(decision).Inc()
+	slog.Info("alert queued",
+		"job_id", job.ID,
+		"receiver", job.Payload.Receiver,
+		"status", job.Payload.Status,
+		"alerts", len(job.Payload.Alerts),
+	)
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"job_id":   job.ID,
+		"status":   "queued",
+		"alerts":   len(job.Payload.Alerts),
+		"backends": providerNames(s.providers),
+	})
+}
+
+// storeDegraded records the alerts as received, without metrics, external
+// context or analysis, so nothing is lost while the workers catch up.
+func (s *server) storeDegraded(job analysisJob) {
+	record := analysisRecord{
+		SchemaVersion:  analysisSchemaVersion,
+		ID:             job.ID,
+		ReceivedAt:     job.ReceivedAt,
+		CompletedAt:    time.Now().UTC(),
+		AlertStatus:    job.Payload.Status,
+		Receiver:       job.Payload.Receiver,
+		GroupKey:       job.Payload.GroupKey,
+		CommonLabels:   job.Payload.CommonLabels,
+		CommonAnnots:   job.Payload.CommonAnnotations,
+		AlertSummaries: summarizeAlerts(job.Payload.Alerts),
+		Policy:         s.policyFor(job.Payload).Name,
+		LLMSkipped:     true,
+		Degraded:       true,
+	}
+	switch job.Payload.Status {
+	case "firing":
+		s.incidents.firing(job)
+	case "resolved":
+		if res, ok := s.incidents.resolve(job); ok {
+			record.Resolution = &res
+		}
+	}
+	s.incidents.add(record)
+	slog.Warn("alert stored without analysis on full queue", "job_id", job.ID)
+}
+
+// payloadSeverity is the severity label of the group, or of its first
+// alert that has one, lower-cased; "none" without one.
+func payloadSeverity(payload GrafanaWebhookPayload) string {
+	if s := payload.CommonLabels["severity"]; s != "" {
+		return strings.ToLower(s)
+	}
+	for _, alert := range payload.Alerts {
+		if s := alert.Labels["severity"]; s != "" {
+			return strings.ToLower(s)
+		}
+	}
+	return "none"
+}
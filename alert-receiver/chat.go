@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Limits of the chat formats. Discord rejects a message over its limits;
+// Teams rejects payloads over about 28 KB, which the per-field limits keep
+// well clear of.
+const (
+	discordTitleMax       = 256
+	discordDescriptionMax = 4096
+	discordFieldValueMax  = 1024
+	discordEmbedMax       = 6000 // title, description and fields together
+	teamsTextMax          = 2000
+)
+
+// severityStyle is how a severity is colored in chat cards: an RGB color
+// for Discord embeds and an Adaptive Card container style for Teams.
+type severityStyle struct {
+	color int
+	style string
+}
+
+var (
+	styleCritical = severityStyle{color: 0xD13438, style: "attention"}
+	styleWarning  = severityStyle{color: 0xFFB900, style: "warning"}
+	styleInfo     = severityStyle{color: 0x0078D4, style: "accent"}
+	styleResolved = severityStyle{color: 0x107C10, style: "good"}
+)
+
+// recordSeverity is the severity label of the alert group: the common label,
+// else the first alert's.
+func recordSeverity(record analysisRecord) string {
+	if s := record.CommonLabels["severity"]; s != "" {
+		return s
+	}
+	for _, a := range record.AlertSummaries {
+		if s := a.Labels["severity"]; s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// recordStyle colors resolved alerts green and firing ones by severity;
+// an unknown severity counts as a warning.
+func recordStyle(record analysisRecord) severityStyle {
+	if strings.EqualFold(record.AlertStatus, "resolved") {
+		return styleResolved
+	}
+	switch strings.ToLower(recordSeverity(record)) {
+	case "critical", "page", "error":
+		return styleCritical
+	case "info", "none":
+		return styleInfo
+	}
+	return styleWarning
+}
+
+// truncate shortens s to at most n characters, ending with an ellipsis
+// when anything was cut.
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	r := []rune(s)
+	return string(r[:n-1]) + "…"
+}
+
+// chatFact is one labelled line of a chat card.
+type chatFact struct {
+	name  string
+	value string
+}
+
+// chatFacts lists what a card shows under the summary, from the first
+// parsed provider analysis.
+func chatFacts(record analysisRecord) (summary string, facts []chatFact) {
+	if sev := recordSeverity(record); sev != "" {
+		facts = append(facts, chatFact{"Severity", sev})
+	}
+	if h := record.Heuristic; h != nil {
+		facts = append(facts, chatFact{"Rule verdict", fmt.Sprintf("%s (%s)", h.Verdict, h.Rule)})
+	}
+	p := primaryAnalysis(record)
+	if p == nil {
+		if record.Error != "" {
+			return "Analysis failed: " + record.Error, facts
+		}
+		return "", facts
+	}
+	if p.Parsed.LikelyIssue != "" {
+		facts = append(facts, chatFact{"Likely issue", p.Parsed.LikelyIssue})
+	}
+	facts = append(facts, chatFact{"Confidence", fmt.Sprintf("%.0f%% (%s)", p.Parsed.Confidence*100, p.Provider)})
+	if len(p.Parsed.PotentialFix) > 0 {
+		facts = append(facts, chatFact{"Try", strings.Join(p.Parsed.PotentialFix, "\n")})
+	}
+	if len(p.Parsed.NextChecks) > 0 {
+		facts = append(facts, chatFact{"Next checks", strings.Join(p.Parsed.NextChecks, "\n")})
+	}
+	return p.Parsed.Summary, facts
+}
+
+// discordNotifier posts an embed colored by severity to a Discord webhook.
+type discordNotifier struct {
+	name   string
+	url    string
+	client *http.Client
+}
+
+func (n *discordNotifier) Name() string { return n.name }
+
+func (n *discordNotifier) Notify(ctx context.Context, record analysisRecord) error {
+	summary, facts := chatFacts(record)
+	title := truncate(recordHeading(record), discordTitleMax)
+	footer := "analysis " + record.ID
+	budget := discordEmbedMax - utf8.RuneCountInString(title) - utf8.RuneCountInString(footer)
+	fields := make([]map[string]any, 0, len(facts))
+	for _, f := range facts {
+		value := truncate(f.value, discordFieldValueMax)
+		budget -= utf8.RuneCountInString(f.name) + utf8.RuneCountInString(value)
+		fields = append(fields, map[string]any{
+			"name":   f.name,
+			"value":  value,
+			"inline": !strings.Contains(f.value, "\n"),
+		})
+	}
+	embed := map[string]any{
+		"title":  title,
+		"color":  recordStyle(record).color,
+		"fields": fields,
+		"footer": map[string]string{"text": footer},
+	}
+	if !record.ReceivedAt.IsZero() {
+		embed["timestamp"] = record.ReceivedAt.UTC().Format(time.RFC3339)
+	}
+	// The description gets what the title and fields leave of the embed
+	// limit.
+	if summary != "" && budget > 1 {
+		embed["description"] = truncate(summary, min(budget, discordDescriptionMax))
+	}
+	return postJSON(ctx, n.client, n.url, map[string]any{"embeds": []any{embed}})
+}
+
+// teamsNotifier posts an Adaptive Card to a Microsoft Teams workflow
+// (Power Automate) webhook.
+type teamsNotifier struct {
+	name   string
+	url    string
+	client *http.Client
+}
+
+func (n *teamsNotifier) Name() string { return n.name }
+
+func (n *teamsNotifier) Notify(ctx context.Context, record analysisRecord) error {
+	summary, facts := chatFacts(record)
+	factSet := make([]map[string]string, 0, len(facts))
+	for _, f := range facts {
+		factSet = append(factSet, map[string]string{"title": f.name, "value": truncate(f.value, teamsTextMax)})
+	}
+
+	header := map[string]any{
+		"type":  "Container",
+		"style": recordStyle(record).style,
+		"bleed": true,
+		"items": []any{map[string]any{
+			"type":   "TextBlock",
+			"text":   truncate(recordHeading(record), teamsTextMax),
+			"weight": "Bolder",
+			"size":   "Medium",
+			"wrap":   true,
+		}},
+	}
+	body := []any{header}
+	if summary != "" {
+		body = append(body, map[string]any{"type": "TextBlock", "text": truncate(summary, teamsTextMax), "wrap": true})
+	}
+	if len(factSet) > 0 {
+		body = append(body, map[string]any{"type": "FactSet", "facts": factSet})
+	}
+	body = append(body, map[string]any{
+		"type":     "TextBlock",
+		"text":     "analysis " + record.ID,
+		"isSubtle": true,
+		"size":     "Small",
+	})
+
+	return postJSON(ctx, n.client, n.url, map[string]any{
+		"type": "message",
+		"attachments": []any{map[string]any{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content": map[string]any{
+				"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+				"type":    "AdaptiveCard",
+				"version": "1.4",
+				"msteams": map[string]string{"width": "Full"},
+				"body":    body,
+			},
+		}},
+	})
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CloudEventMapping says where in a CloudEvent the pipeline finds each
+// alert field. Values are dot paths into the event's JSON form, such as
+// "type", "subject" or "data.host"; in binary mode the data is the body.
+type CloudEventMapping struct {
+	AlertName   string            `json:"alertname"`
+	Status      string            `json:"status"`
+	StartsAt    string            `json:"starts_at"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	// ResolvedValues are the status values, compared case-insensitively,
+	// that mean the incident is over; any other status is firing.
+	ResolvedValues []string `json:"resolved_values"`
+}
+
+func defaultCloudEventMapping() CloudEventMapping {
+	return CloudEventMapping{
+		AlertName: "type",
+		Status:    "data.status",
+		StartsAt:  "time",
+		Labels: map[string]string{
+			"source":   "source",
+			"subject":  "subject",
+			"severity": "data.severity",
+		},
+		Annotations: map[string]string{
+			"summary":     "data.summary",
+			"description": "data.message",
+		},
+		ResolvedValues: []string{"resolved", "ok", "clear", "cleared", "up"},
+	}
+}
+
+// parseCloudEventMapping reads CLOUDEVENTS_MAPPING_JSON over the defaults:
+// fields it sets replace the default, and an empty label or annotation
+// path drops that default entry.
+func parseCloudEventMapping(raw string) (CloudEventMapping, error) {
+	m := defaultCloudEventMapping()
+	if strings.TrimSpace(raw) == "" {
+		return m, nil
+	}
+	var override CloudEventMapping
+	if err := json.Unmarshal([]byte(raw), &override); err != nil {
+		return CloudEventMapping{}, fmt.Errorf("parse CLOUDEVENTS_MAPPING_JSON: %w", err)
+	}
+	if override.AlertName != "" {
+		m.AlertName = override.AlertName
+	}
+	if override.Status != "" {
+		m.Status = override.Status
+	}
+	if override.StartsAt != "" {
+		m.StartsAt = override.StartsAt
+	}
+	if override.ResolvedValues != nil {
+		m.ResolvedValues = override.ResolvedValues
+	}
+	merge := func(dst, src map[string]string) {
+		for k, v := range src {
+			if v == "" {
+				delete(dst, k)
+			} else {
+				dst[k] = v
+			}
+		}
+	}
+	merge(m.Labels, override.Labels)
+	merge(m.Annotations, override.Annotations)
+	return m, nil
+}
+
+// toGrafanaPayload maps one event into the pipeline's payload as a single
+// alert.
+func (m CloudEventMapping) toGrafanaPayload(event map[string]any) GrafanaWebhookPayload {
+	status := "firing"
+	if s := lookupPath(event, m.Status); s != "" {
+		for _, v := range m.ResolvedValues {
+			if strings.EqualFold(s, v) {
+				status = "resolved"
+			}
+		}
+	}
+	labels := map[string]string{"alertname": lookupPath(event, m.AlertName)}
+	for k, path := range m.Labels {
+		if v := lookupPath(event, path); v != "" {
+			labels[k] = v
+		}
+	}
+	annotations := map[string]string{}
+	for k, path := range m.Annotations {
+		if v := lookupPath(event, path); v != "" {
+			annotations[k] = v
+		}
+	}
+	startsAt, _ := time.Parse(time.RFC3339Nano, lookupPath(event, m.StartsAt))
+
+	source, _ := event["source"].(string)
+	key, _ := event["subject"].(string)
+	if key == "" {
+		key, _ = event["id"].(string)
+	}
+	return GrafanaWebhookPayload{
+		Receiver:          "cloudevents",
+		Status:            status,
+		GroupKey:          "cloudevents/" + strings.TrimPrefix(source, "/") + "/" + key,
+		CommonLabels:      labels,
+		CommonAnnotations: annotations,
+		Alerts: []GrafanaAlert{{
+			Status:      status,
+			Labels:      labels,
+			Annotations: annotations,
+			StartsAt:    startsAt,
+		}},
+	}
+}
+
+// lookupPath follows a dot path through decoded JSON objects and formats
+// the scalar it ends on; anything else reads as "".
+func lookupPath(v any, path string) string {
+	if path == "" {
+		return ""
+	}
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return ""
+		}
+		v = obj[key]
+	}
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number, bool:
+		return fmt.Sprint(v)
+	}
+	return ""
+}
+
+// readCloudEvent reads a CloudEvent in structured mode
+// (application/cloudevents+json) or binary mode (ce-* headers, the body
+// as data) into its JSON form.
+func readCloudEvent(r *http.Request) (map[string]any, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var event map[string]any
+	switch {
+	case mediaType == "application/cloudevents-batch+json":
+		return nil, fmt.Errorf("batched CloudEvents are not supported; send one event per request")
+	case mediaType == "application/cloudevents+json":
+		if err := decodeJSON(body, &event); err != nil {
+			return nil, fmt.Errorf("invalid event: %w", err)
+		}
+	default:
+		event = make(map[string]any)
+		for name, values := range r.Header {
+			if attr, ok := strings.CutPrefix(strings.ToLower(name), "ce-"); ok && len(values) > 0 {
+				event[attr] = values[0]
+			}
+		}
+		if len(body) > 0 {
+			event["datacontenttype"] = r.Header.Get("Content-Type")
+			var data any
+			if err := decodeJSON(body, &data); err == nil {
+				event["data"] = data
+			} else {
+				event["data"] = string(body)
+			}
+		}
+	}
+
+	if v, _ := event["specversion"].(string); !strings.HasPrefix(v, "1.") {
+		return nil, fmt.Errorf("unsupported specversion %q", v)
+	}
+	for _, attr := range []string{"id", "source", "type"} {
+		if v, _ := event[attr].(string); v == "" {
+			return nil, fmt.Errorf("missing required attribute %q", attr)
+		}
+	}
+	return event, nil
+}
+
+func decodeJSON(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+func (s *server) handleCloudEvent(w http.ResponseWriter, r *http.Request) {
+	if !s.acceptWebhook(w, r) {
+		return
+	}
+	defer r.Body.Close()
+
+	event, err := readCloudEvent(r)
+	if err != nil {
+		rejectBody(w, r, err, err.Error())
+		return
+	}
+	s.enqueue(w, r, s.cfg.CloudEventMapping.toGrafanaPayload(event))
+}
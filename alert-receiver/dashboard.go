@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// dashboardMetric is one metric family to chart.
+type dashboardMetric struct {
+	name string
+	kind string // counter, gauge, histogram, summary
+	help string
+}
+
+// handleDashboard serves a Grafana dashboard with a row per service and a
+// panel per metric, so it follows the metrics actually exported: the
+// services' from what Prometheus has scraped for their jobs, and
+// alert-receiver's from its own registry as well. A labelled metric shows
+// up once it has a series.
+func (s *server) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	metadata, err := s.prom.metadata(r.Context())
+	if err != nil {
+		slog.Warn("dashboard: Prometheus metadata unavailable", "error", err)
+	}
+	var sections []dashboardSection
+	for _, job := range append([]string{"alert-receiver"}, s.cfg.DashboardJobs...) {
+		section := dashboardSection{job: job}
+		if err == nil {
+			names, jobErr := s.prom.metricNames(r.Context(), job)
+			if jobErr != nil {
+				section.err = jobErr.Error()
+			}
+			section.metrics = families(names, metadata)
+		} else {
+			section.err = err.Error()
+		}
+		if job == "alert-receiver" {
+			section.metrics = mergeMetrics(localMetrics(), section.metrics)
+		}
+		sections = append(sections, section)
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="edge-monitor-dashboard.json"`)
+	writeJSON(w, http.StatusOK, buildDashboard(sections))
+}
+
+type dashboardSection struct {
+	job     string
+	metrics []dashboardMetric
+	err     string
+}
+
+// localMetrics lists the families registered in this process.
+func localMetrics() []dashboardMetric {
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		slog.Warn("dashboard: gather local metrics", "error", err)
+	}
+	out := make([]dashboardMetric, 0, len(mfs))
+	for _, mf := range mfs {
+		if runtimeMetric(mf.GetName()) {
+			continue
+		}
+		out = append(out, dashboardMetric{
+			name: mf.GetName(),
+			kind: strings.ToLower(mf.GetType().String()),
+			help: mf.GetHelp(),
+		})
+	}
+	return out
+}
+
+// mergeMetrics adds the metrics of b missing from a, keeping the result
+// sorted by name.
+func mergeMetrics(a, b []dashboardMetric) []dashboardMetric {
+	seen := make(map[string]bool, len(a))
+	for _, m := range a {
+		seen[m.name] = true
+	}
+	for _, m := range b {
+		if !seen[m.name] {
+			a = append(a, m)
+		}
+	}
+	sort.Slice(a, func(i, j int) bool { return a[i].name < a[j].name })
+	return a
+}
+
+// runtimeMetric reports the Go runtime, process and scrape series every
+// job has; they would crowd out the services' own panels.
+func runtimeMetric(name string) bool {
+	for _, prefix := range []string{"go_", "process_", "promhttp_", "scrape_"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return name == "up"
+}
+
+// families folds sample names (m_bucket, m_sum, m_count) into metric
+// families typed by Prometheus metadata, falling back to the naming
+// conventions where metadata is missing.
+func families(names []string, metadata map[string]promMetadata) []dashboardMetric {
+	present := make(map[string]bool, len(names))
+	for _, name := range names {
+		present[name] = true
+	}
+	byName := make(map[string]dashboardMetric)
+	for _, name := range names {
+		if runtimeMetric(name) {
+			continue
+		}
+		m := dashboardMetric{name: name}
+		for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+			base, ok := strings.CutSuffix(name, suffix)
+			if !ok {
+				continue
+			}
+			if md := metadata[base]; md.Type == "histogram" || md.Type == "summary" {
+				m = dashboardMetric{name: base, kind: md.Type, help: md.Help}
+			} else if present[base+"_bucket"] {
+				m = dashboardMetric{name: base, kind: "histogram"}
+			}
+		}
+		if m.kind == "" {
+			md, ok := metadata[name]
+			if !ok {
+				md = metadata[strings.TrimSuffix(name, "_total")]
+			}
+			m.kind, m.help = md.Type, md.Help
+			if m.kind != "counter" && m.kind != "gauge" {
+				m.kind = "gauge"
+				if strings.HasSuffix(name, "_total") {
+					m.kind = "counter"
+				}
+			}
+		}
+		byName[m.name] = m
+	}
+	out := make([]dashboardMetric, 0, len(byName))
+	for _, m := range byName {
+		out = append(out, m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].name < out[j].name })
+	return out
+}
+
+// panelQuery is the PromQL a panel charts for a metric of job.
+func panelQuery(m dashboardMetric, job string) string {
+	sel := fmt.Sprintf(`{job=%q}`, job)
+	switch m.kind {
+	case "counter":
+		return fmt.Sprintf("sum without (instance, pod) (rate(%s%s[$__rate_interval]))", m.name, sel)
+	case "histogram":
+		return fmt.Sprintf("histogram_quantile(0.95, sum by (le) (rate(%s_bucket%s[$__rate_interval])))", m.name, sel)
+	case "summary":
+		return fmt.Sprintf("%s%s", m.name, strings.Replace(sel, "}", `,quantile="0.95"}`, 1))
+	}
+	return m.name + sel
+}
+
+// buildDashboard lays the sections out as collapsed-open rows of two
+// panels each.
+func buildDashboard(sections []dashboardSection) map[string]any {
+	datasource := map[string]string{"type": "prometheus", "uid": "${datasource}"}
+	var panels []map[string]any
+	id, y := 1, 0
+	for _, section := range sections {
+		panels = append(panels, map[string]any{
+			"id": id, "type": "row", "title": section.job, "collapsed": false,
+			"gridPos": map[string]int{"h": 1, "w": 24, "x": 0, "y": y},
+		})
+		id++
+		y++
+		if len(section.metrics) == 0 {
+			text := fmt.Sprintf("No metrics found for job %q.", section.job)
+			if section.err != "" {
+				text += " " + section.err
+			}
+			panels = append(panels, map[string]any{
+				"id": id, "type": "text", "title": section.job,
+				"options": map[string]string{"mode": "markdown", "content": text},
+				"gridPos": map[string]int{"h": 3, "w": 24, "x": 0, "y": y},
+			})
+			id++
+			y += 3
+			continue
+		}
+		for i, m := range section.metrics {
+			unit := "short"
+			switch {
+			case m.kind == "counter":
+				unit = "ops"
+			case strings.HasSuffix(m.name, "_seconds"):
+				unit = "s"
+			case strings.HasSuffix(m.name, "_ms"):
+				unit = "ms"
+			case strings.HasSuffix(m.name, "_bytes"):
+				unit = "bytes"
+			case strings.HasSuffix(m.name, "_dbm"):
+				unit = "dBm"
+			}
+			panels = append(panels, map[string]any{
+				"id":          id,
+				"type":        "timeseries",
+				"title":       m.name,
+				"description": m.help,
+				"datasource":  datasource,
+				"fieldConfig": map[string]any{"defaults": map[string]string{"unit": unit}, "overrides": []any{}},
+				"targets": []map[string]any{{
+					"refId":        "A",
+					"datasource":   datasource,
+					"expr":         panelQuery(m, section.job),
+					"legendFormat": "__auto",
+				}},
+				"gridPos": map[string]int{"h": 8, "w": 12, "x": (i % 2) * 12, "y": y + (i/2)*8},
+			})
+			id++
+		}
+		y += (len(section.metrics) + 1) / 2 * 8
+	}
+
+	return map[string]any{
+		"title":         "Edge Monitor",
+		"uid":           "edge-monitor",
+		"tags":          []string{"edge-monitor"},
+		"timezone":      "browser",
+		"schemaVersion": 39,
+		"refresh":       "30s",
+		"time":          map[string]string{"from": "now-6h", "to": "now"},
+		"templating": map[string]any{"list": []map[string]any{{
+			"name":  "datasource",
+			"label": "Prometheus",
+			"type":  "datasource",
+			"query": "prometheus",
+		}}},
+		"panels": panels,
+	}
+}
+
+type promMetadata struct {
+	Type string `json:"type"`
+	Help string `json:"help"`
+}
+
+// metadata returns the type and help of every metric Prometheus knows.
+func (p *PrometheusClient) metadata(ctx context.Context) (map[string]promMetadata, error) {
+	var data map[string][]promMetadata
+	if err := p.apiGet(ctx, "/api/v1/metadata", url.Values{}, &data); err != nil {
+		return nil, err
+	}
+	out := make(map[string]promMetadata, len(data))
+	for name, entries := range data {
+		if len(entries) > 0 {
+			out[name] = entries[0]
+		}
+	}
+	return out, nil
+}
+
+// metricNames returns the sample names Prometheus has for job.
+func (p *PrometheusClient) metricNames(ctx context.Context, job string) ([]string, error) {
+	return p.labelValues(ctx, "__name__", fmt.Sprintf(`{job=%q}`, job))
+}
+
+// apiGet decodes the data field of a successful Prometheus API response.
+func (p *PrometheusClient) apiGet(ctx context.Context, path string, params url.Values, data any) error {
+	resp, err := p.get(ctx, path, params)
+	if err != nil {
+		return fmt.Errorf("query Prometheus: %w", err)
+	}
+	defer resp.Body.Close()
+	var apiResp struct {
+		Status string          `json:"status"`
+		Data   json.RawMessage `json:"data"`
+		Error  string          `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		return fmt.Errorf("decode Prometheus response (status %d): %w", resp.StatusCode, err)
+	}
+	if apiResp.Status != "success" {
+		return fmt.Errorf("Prometheus %s: %s", path, apiResp.Error)
+	}
+	return json.Unmarshal(apiResp.Data, data)
+}
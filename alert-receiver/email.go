@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// SMTP_TLS modes.
+const (
+	smtpStartTLS    = "starttls" // upgrade a plain connection, usually port 587
+	smtpImplicitTLS = "tls"      // TLS from the start, usually port 465
+	smtpNoTLS       = "none"
+)
+
+var smtpTLSModes = []string{smtpStartTLS, smtpImplicitTLS, smtpNoTLS}
+
+// maxDigestRecords bounds the analyses an email sink holds for its next
+// digest; beyond it the oldest are dropped and only counted.
+const maxDigestRecords = 500
+
+// smtpConfig is the mail server email sinks send through.
+type smtpConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	TLS      string
+}
+
+// digester is a notifier that also sends a periodic digest.
+type digester interface {
+	runDigest(ctx context.Context) error
+}
+
+// emailNotifier mails analyses of the immediate severities as they finish
+// and collects every analysis it is given into a daily digest.
+type emailNotifier struct {
+	name      string
+	to        []string
+	smtp      smtpConfig
+	timeout   time.Duration
+	immediate []string
+	// digestAt is the time of day of the digest in loc, DISPLAY_TIMEZONE;
+	// negative disables it.
+	digestAt time.Duration
+	loc      *time.Location
+
+	mu      sync.Mutex
+	pending []analysisRecord
+	dropped int
+}
+
+func newEmailNotifier(sink NotifySinkConfig, smtpCfg smtpConfig, timeout time.Duration, loc *time.Location) (*emailNotifier, error) {
+	if smtpCfg.Host == "" || smtpCfg.From == "" {
+		return nil, fmt.Errorf("notify sink %q: email needs SMTP_HOST and SMTP_FROM", sink.Name)
+	}
+	if len(sink.To) == 0 {
+		return nil, fmt.Errorf("notify sink %q: email needs at least one address in to", sink.Name)
+	}
+	n := &emailNotifier{name: sink.Name, to: sink.To, smtp: smtpCfg, timeout: timeout, loc: loc, immediate: []string{"critical"}}
+	if sink.Immediate != nil {
+		n.immediate = make([]string, len(sink.Immediate))
+		for i, s := range sink.Immediate {
+			n.immediate[i] = strings.ToLower(s)
+		}
+	}
+	switch sink.DigestAt {
+	case "off":
+		n.digestAt = -1
+	case "":
+		n.digestAt = 8 * time.Hour
+	default:
+		t, err := time.Parse("15:04", sink.DigestAt)
+		if err != nil {
+			return nil, fmt.Errorf("notify sink %q: digest_at %q: want HH:MM or off", sink.Name, sink.DigestAt)
+		}
+		n.digestAt = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	return n, nil
+}
+
+func (n *emailNotifier) Name() string { return n.name }
+
+// Notify queues the record for the digest and mails it at once when its
+// severity is one of the immediate ones.
+func (n *emailNotifier) Notify(ctx context.Context, record analysisRecord) error {
+	if n.digestAt >= 0 && !record.Preliminary {
+		// The digest only shows summaries; drop the bulky parts now
+		// rather than hold them until the next digest.
+		compactRecord(&record)
+		n.mu.Lock()
+		n.pending = append(n.pending, record)
+		if over := len(n.pending) - maxDigestRecords; over > 0 {
+			n.pending = slices.Delete(n.pending, 0, over)
+			n.dropped += over
+		}
+		n.mu.Unlock()
+	}
+	if !slices.Contains(n.immediate, strings.ToLower(recordSeverity(record))) {
+		return nil
+	}
+
+	item := newEmailItem(record)
+	subject := fmt.Sprintf("[%s] %s", strings.ToUpper(item.Status), item.Title)
+	if item.Severity != "" {
+		subject = fmt.Sprintf("[%s %s] %s", strings.ToUpper(item.Status), item.Severity, item.Title)
+	}
+	switch {
+	case item.Preliminary:
+		subject += " (preliminary): " + truncate(item.Verdict, 80)
+	case item.Summary != "":
+		subject += ": " + truncate(item.Summary, 80)
+	}
+	return n.send(ctx, subject, "immediate", item)
+}
+
+// runDigest sends the digest at digestAt every day until ctx is cancelled.
+// A digest with nothing in it is not sent.
+func (n *emailNotifier) runDigest(ctx context.Context) error {
+	if n.digestAt < 0 {
+		return nil
+	}
+	for {
+		timer := time.NewTimer(time.Until(nextDigest(time.Now().In(n.loc), n.digestAt)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case now := <-timer.C:
+			n.sendDigest(ctx, now.In(n.loc))
+		}
+	}
+}
+
+// nextDigest is the first time of day at after now, in now's location.
+func nextDigest(now time.Time, at time.Duration) time.Time {
+	y, m, d := now.Date()
+	next := time.Date(y, m, d, 0, 0, 0, 0, now.Location()).Add(at)
+	if !next.After(now) {
+		next = time.Date(y, m, d+1, 0, 0, 0, 0, now.Location()).Add(at)
+	}
+	return next
+}
+
+// emailDigest is the data of the digest templates.
+type emailDigest struct {
+	Date    string
+	Total   int
+	Firing  int
+	Dropped int
+	Items   []emailItem
+}
+
+func (n *emailNotifier) sendDigest(ctx context.Context, now time.Time) {
+	n.mu.Lock()
+	records, dropped := n.pending, n.dropped
+	n.pending, n.dropped = nil, 0
+	n.mu.Unlock()
+	if len(records) == 0 {
+		return
+	}
+
+	digest := emailDigest{Date: now.Format("Mon 2 Jan 2006"), Total: len(records) + dropped, Dropped: dropped}
+	for _, record := range records {
+		item := newEmailItem(record)
+		if item.Status == "firing" {
+			digest.Firing++
+		}
+		digest.Items = append(digest.Items, item)
+	}
+	subject := fmt.Sprintf("Network analysis digest for %s: %d analyses, %d firing", digest.Date, digest.Total, digest.Firing)
+
+	ctx, cancel := context.WithTimeout(ctx, n.timeout)
+	defer cancel()
+	if err := n.send(ctx, subject, "digest", digest); err != nil {
+		notificationsTotal.WithLabelValues(n.name, "digest_error").Inc()
+		slog.Warn("email digest failed", "sink", n.name, "analyses", len(records), "error", err)
+		// Keep them for the next digest, behind anything that arrived since.
+		n.mu.Lock()
+		n.pending = append(records, n.pending...)
+		if over := len(n.pending) - maxDigestRecords; over > 0 {
+			n.pending = slices.Delete(n.pending, 0, over)
+			n.dropped += over
+		}
+		n.dropped += dropped
+		n.mu.Unlock()
+		return
+	}
+	notificationsTotal.WithLabelValues(n.name, "digest").Inc()
+	slog.Info("sent email digest", "sink", n.name, "analyses", digest.Total)
+}
+
+// send renders the plain-text and HTML templates named kind with data and
+// mails both as one multipart/alternative message.
+func (n *emailNotifier) send(ctx context.Context, subject, kind string, data any) error {
+	var text, html bytes.Buffer
+	if err := emailTextTemplates.ExecuteTemplate(&text, kind, data); err != nil {
+		return fmt.Errorf("render %s text: %w", kind, err)
+	}
+	if err := emailHTMLTemplates.ExecuteTemplate(&html, kind, data); err != nil {
+		return fmt.Errorf("render %s html: %w", kind, err)
+	}
+	msg, err := buildEmail(n.smtp.From, n.to, subject, text.Bytes(), html.Bytes())
+	if err != nil {
+		return err
+	}
+	return sendMail(ctx, n.smtp, n.to, msg)
+}
+
+// emailItem is one analysis as the templates show it.
+type emailItem struct {
+	ID          string
+	Title       string
+	Status      string
+	Severity    string
+	Color       string // severity color, as in the chat cards
+	CompletedAt string
+	Summary     string
+	LikelyIssue string
+	Confidence  string
+	Fixes       []string
+	NextChecks  []string
+	Error       string
+	Verdict     string // the heuristic verdict and its rule
+	Preliminary bool
+}
+
+func newEmailItem(record analysisRecord) emailItem {
+	item := emailItem{
+		ID:          record.ID,
+		Title:       recordTitle(record),
+		Status:      strings.ToLower(record.AlertStatus),
+		Severity:    recordSeverity(record),
+		Color:       fmt.Sprintf("#%06X", recordStyle(record).color),
+		CompletedAt: record.CompletedAt.Format("2006-01-02 15:04 MST"),
+		Error:       record.Error,
+		Preliminary: record.Preliminary,
+	}
+	if h := record.Heuristic; h != nil {
+		item.Verdict = fmt.Sprintf("%s (%s)", h.Verdict, h.Rule)
+	}
+	if p := primaryAnalysis(record); p != nil {
+		item.Summary = p.Parsed.Summary
+		item.LikelyIssue = p.Parsed.LikelyIssue
+		item.Confidence = fmt.Sprintf("%.0f%% (%s)", p.Parsed.Confidence*100, p.Provider)
+		item.Fixes = p.Parsed.PotentialFix
+		item.NextChecks = p.Parsed.NextChecks
+	}
+	return item
+}
+
+var emailTextTemplates = template.Must(template.New("email").Parse(`
+{{- define "item" -}}
+[{{.Status}}{{with .Severity}} {{.}}{{end}}] {{.Title}} ({{if .Preliminary}}preliminary, analysis pending{{else}}{{.CompletedAt}}{{end}})
+{{- with .Verdict}}
+Rule verdict: {{.}}{{end}}
+{{- with .Summary}}
+{{.}}{{end}}
+{{- with .LikelyIssue}}
+Likely issue: {{.}}{{end}}
+{{- with .Confidence}}
+Confidence: {{.}}{{end}}
+{{- range .Fixes}}
+  - try: {{.}}{{end}}
+{{- range .NextChecks}}
+  - check: {{.}}{{end}}
+{{- with .Error}}
+Analysis error: {{.}}{{end}}
+Analysis {{.ID}}
+{{end -}}
+
+{{- define "immediate"}}{{template "item" .}}{{end -}}
+
+{{- define "digest" -}}
+Network analysis digest for {{.Date}}: {{.Total}} analyses, {{.Firing}} firing.
+{{- if .Dropped}}
+The {{.Dropped}} oldest are not listed.{{end}}
+{{range .Items}}
+{{template "item" .}}{{end -}}
+{{end}}`))
+
+var emailHTMLTemplates = htmltemplate.Must(htmltemplate.New("email").Parse(`
+{{- define "item" -}}
+<div style="border-left:6px solid {{.Color}};padding:8px 12px;margin:12px 0;font-family:sans-serif">
+<div style="font-weight:bold">[{{.Status}}{{with .Severity}} {{.}}{{end}}] {{.Title}}</div>
+<div style="color:#666;font-size:small">{{if .Preliminary}}preliminary, analysis pending{{else}}{{.CompletedAt}}{{end}} &middot; analysis {{.ID}}</div>
+{{- with .Summary}}<p>{{.}}</p>{{end}}
+<table style="border-collapse:collapse">
+{{- with .Verdict}}<tr><td style="padding-right:12px"><b>Rule verdict</b></td><td>{{.}}</td></tr>{{end}}
+{{- with .LikelyIssue}}<tr><td style="padding-right:12px"><b>Likely issue</b></td><td>{{.}}</td></tr>{{end}}
+{{- with .Confidence}}<tr><td style="padding-right:12px"><b>Confidence</b></td><td>{{.}}</td></tr>{{end}}
+</table>
+{{- with .Fixes}}<p><b>Try</b></p><ul>{{range .}}<li>{{.}}</li>{{end}}</ul>{{end}}
+{{- with .NextChecks}}<p><b>Next checks</b></p><ul>{{range .}}<li>{{.}}</li>{{end}}</ul>{{end}}
+{{- with .Error}}<p style="color:#D13438">Analysis error: {{.}}</p>{{end}}
+</div>
+{{- end -}}
+
+{{- define "immediate"}}<html><body>{{template "item" .}}</body></html>{{end -}}
+
+{{- define "digest" -}}
+<html><body>
+<h2 style="font-family:sans-serif">Network analysis digest for {{.Date}}</h2>
+<p style="font-family:sans-serif">{{.Total}} analyses, {{.Firing}} firing.{{if .Dropped}} The {{.Dropped}} oldest are not listed.{{end}}</p>
+{{range .Items}}{{template "item" .}}{{end}}
+</body></html>
+{{- end}}`))
+
+// buildEmail assembles a multipart/alternative message with a plain-text
+// and an HTML part.
+func buildEmail(from string, to []string, subject string, text, html []byte) ([]byte, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		contentType string
+		content     []byte
+	}{{"text/plain; charset=utf-8", text}, {"text/html; charset=utf-8", html}} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write(part.content); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 12)
+	_, _ = rand.Read(id)
+	domain := "alert-receiver"
+	if _, d, ok := strings.Cut(from, "@"); ok {
+		domain = strings.Trim(d, "> ")
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", mw.Boundary())
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+// sendMail delivers msg through the SMTP server within ctx's deadline.
+// Credentials are only sent over TLS, or to a server on localhost.
+func sendMail(ctx context.Context, cfg smtpConfig, to []string, msg []byte) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)))
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if cfg.TLS == smtpImplicitTLS {
+		conn = tls.Client(conn, &tls.Config{ServerName: cfg.Host})
+	}
+	c, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if cfg.TLS == smtpStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("smtp server does not offer STARTTLS (set SMTP_TLS=none to send in the clear)")
+		}
+		if err := c.StartTLS(&tls.Config{ServerName: cfg.Host}); err != nil {
+			return fmt.Errorf("starttls: %w", err)
+		}
+	}
+	if cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)); err != nil {
+			return fmt.Errorf("smtp auth: %w", err)
+		}
+	}
+	if err := c.Mail(emailAddress(cfg.From)); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(emailAddress(addr)); err != nil {
+			return fmt.Errorf("rcpt %s: %w", addr, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// emailAddress returns the bare address of "Name <addr>".
+func emailAddress(s string) string {
+	if i := strings.LastIndex(s, "<"); i >= 0 {
+		return strings.TrimSuffix(s[i+1:], ">")
+	}
+	return strings.TrimSpace(s)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Experiment arms, stored in ProviderResult.Arm.
+const (
+	armControl   = "control"
+	armCandidate = "candidate"
+)
+
+// Experiment is one entry of EXPERIMENTS_JSON: an A/B comparison that
+// sends Percent of the jobs analyzed by the Control backend to a candidate
+// instead, a different backend, a different system prompt or both.
+type Experiment struct {
+	Name    string `json:"name"`
+	Control string `json:"control"`
+	// Candidate is the backend of the candidate arm; empty keeps Control.
+	// A candidate backend only ever runs in its candidate arm.
+	Candidate string `json:"candidate,omitempty"`
+	// SystemPrompt replaces the system prompt in the candidate arm.
+	SystemPrompt string  `json:"system_prompt,omitempty"`
+	Percent      float64 `json:"percent"`
+}
+
+// AnalysisFeedback is a person's rating of an analysis, from POST
+// /analyses/feedback.
+type AnalysisFeedback struct {
+	// Provider is the result rated; empty rates the analysis as a whole.
+	Provider string    `json:"provider,omitempty"`
+	Score    int       `json:"score"`
+	Comment  string    `json:"comment,omitempty"`
+	At       time.Time `json:"at"`
+}
+
+// Feedback scores run from minFeedbackScore (useless) to maxFeedbackScore
+// (spot on).
+const (
+	minFeedbackScore = 1
+	maxFeedbackScore = 5
+)
+
+// parseExperiments reads EXPERIMENTS_JSON and checks it against the
+// backends: each backend is the control of at most one experiment, and is
+// not also a candidate.
+func parseExperiments(raw string, backends []BackendConfig) ([]Experiment, error) {
+	var experiments []Experiment
+	if err := json.Unmarshal([]byte(raw), &experiments); err != nil {
+		return nil, fmt.Errorf("parse EXPERIMENTS_JSON: %w", err)
+	}
+	known := func(name string) bool {
+		return slices.ContainsFunc(backends, func(b BackendConfig) bool { return b.Name == name })
+	}
+	names := make(map[string]bool)
+	controls := make(map[string]bool)
+	candidates := make(map[string]bool)
+	for i := range experiments {
+		e := &experiments[i]
+		if e.Name == "" {
+			e.Name = fmt.Sprintf("experiment-%d", i+1)
+		}
+		if names[e.Name] {
+			return nil, fmt.Errorf("experiment %q: duplicate name", e.Name)
+		}
+		names[e.Name] = true
+		if !known(e.Control) {
+			return nil, fmt.Errorf("experiment %q: control %q is not in LLM_BACKENDS_JSON", e.Name, e.Control)
+		}
+		if e.Candidate == e.Control {
+			e.Candidate = ""
+		}
+		if e.Candidate != "" && !known(e.Candidate) {
+			return nil, fmt.Errorf("experiment %q: candidate %q is not in LLM_BACKENDS_JSON", e.Name, e.Candidate)
+		}
+		if e.Candidate == "" && e.SystemPrompt == "" {
+			return nil, fmt.Errorf("experiment %q: set a candidate backend, a system_prompt or both", e.Name)
+		}
+		if e.Percent <= 0 || e.Percent > 100 {
+			return nil, fmt.Errorf("experiment %q: percent must be above 0 and at most 100", e.Name)
+		}
+		if controls[e.Control] {
+			return nil, fmt.Errorf("experiment %q: backend %q is already the control of another experiment", e.Name, e.Control)
+		}
+		controls[e.Control] = true
+		if e.Candidate != "" {
+			candidates[e.Candidate] = true
+		}
+	}
+	for name := range candidates {
+		if controls[name] {
+			return nil, fmt.Errorf("EXPERIMENTS_JSON: backend %q is both a control and a candidate", name)
+		}
+	}
+	return experiments, nil
+}
+
+// experimentArm is the arm of an experiment a provider runs in for a job.
+type experimentArm struct {
+	experiment   string
+	arm          string
+	systemPrompt string
+}
+
+// candidateArm reports whether the job falls in e's candidate arm. The
+// split hashes the job ID, so it is stable for a job and independent
+// between experiments.
+func (e Experiment) candidateArm(jobID string) bool {
+	h := fnv.New32a()
+	h.Write([]byte(e.Name + "/" + jobID))
+	return float64(h.Sum32()%10000) < e.Percent*100
+}
+
+// assignArms applies the experiments to the providers chosen for a job:
+// candidate backends are dropped, and each experiment's control is kept
+// or swapped for its candidate. It returns the providers to run and the
+// arm of each experiment's provider, by provider name.
+func (s *server) assignArms(jobID string, providers []LLMProvider) ([]LLMProvider, map[string]experimentArm) {
+	if len(s.cfg.Experiments) == 0 {
+		return providers, nil
+	}
+	candidates := make(map[string]bool)
+	for _, e := range s.cfg.Experiments {
+		if e.Candidate != "" {
+			candidates[e.Candidate] = true
+		}
+	}
+	out := make([]LLMProvider, 0, len(providers))
+	for _, p := range providers {
+		if !candidates[p.Name()] {
+			out = append(out, p)
+		}
+	}
+
+	arms := make(map[string]experimentArm)
+	for _, e := range s.cfg.Experiments {
+		i := slices.IndexFunc(out, func(p LLMProvider) bool { return p.Name() == e.Control })
+		if i < 0 {
+			continue
+		}
+		if !e.candidateArm(jobID) {
+			arms[e.Control] = experimentArm{experiment: e.Name, arm: armControl}
+			continue
+		}
+		name := e.Control
+		if e.Candidate != "" {
+			j := slices.IndexFunc(s.providers, func(p LLMProvider) bool { return p.Name() == e.Candidate })
+			out[i], name = s.providers[j], e.Candidate
+		}
+		arms[name] = experimentArm{experiment: e.Name, arm: armCandidate, systemPrompt: e.SystemPrompt}
+	}
+	return out, arms
+}
+
+// observeExperiment counts a tagged provider result by outcome: parsed,
+// unparsed (a reply that was not the requested JSON) or error.
+func observeExperiment(result ProviderResult) {
+	if result.Experiment == "" {
+		return
+	}
+	outcome := "parsed"
+	switch {
+	case result.Error != "":
+		outcome = "error"
+	case result.Parsed == nil:
+		outcome = "unparsed"
+	}
+	experimentResultsTotal.WithLabelValues(result.Experiment, result.Arm, outcome).Inc()
+	experimentDurationSeconds.WithLabelValues(result.Experiment, result.Arm).Observe(float64(result.DurationMS) / 1000)
+}
+
+// handleFeedback serves POST /analyses/feedback, rating a stored analysis
+// or one provider's result in it:
+// {"id": "<job id>", "provider": "<name>", "score": 1-5, "comment": "..."}.
+// Ratings of experiment results feed the experiment's score histogram.
+func (s *server) handleFeedback(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID       string `json:"id"`
+		Provider string `json:"provider"`
+		Score    int    `json:"score"`
+		Comment  string `json:"comment"`
+	}
+	if !s.decodeWebhook(w, r, &req) {
+		return
+	}
+	if req.Score < minFeedbackScore || req.Score > maxFeedbackScore {
+		http.Error(w, fmt.Sprintf("score must be %d to %d", minFeedbackScore, maxFeedbackScore), http.StatusBadRequest)
+		return
+	}
+	feedback := AnalysisFeedback{
+		Provider: req.Provider,
+		Score:    req.Score,
+		Comment:  strings.TrimSpace(req.Comment),
+		At:       time.Now().UTC(),
+	}
+
+	var rated []ProviderResult
+	found := s.store.update(req.ID, func(record *analysisRecord) bool {
+		rated = rated[:0]
+		for _, p := range record.Providers {
+			if req.Provider == "" || p.Provider == req.Provider {
+				rated = append(rated, p)
+			}
+		}
+		if len(rated) == 0 && req.Provider != "" {
+			return false
+		}
+		record.Feedback = append(slices.Clone(record.Feedback), feedback)
+		return true
+	})
+	switch {
+	case !found:
+		http.Error(w, "analysis not found", http.StatusNotFound)
+		return
+	case len(rated) == 0 && req.Provider != "":
+		http.Error(w, "provider not in analysis", http.StatusNotFound)
+		return
+	}
+	for _, p := range rated {
+		if p.Experiment != "" {
+			experimentFeedbackScore.WithLabelValues(p.Experiment, p.Arm).Observe(float64(req.Score))
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"id": req.ID, "feedback": feedback})
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"net/http"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// exportReport is an analysis as the export templates show it.
+type exportReport struct {
+	analysisRecord
+	Title      string
+	Severity   string
+	Color      string
+	Events     []exportEvent
+	ExportedAt time.Time
+}
+
+// exportEvent is one probe state transition from a record's probe_events.
+type exportEvent struct {
+	Source string
+	Time   string
+	Probe  string
+	Target string
+	State  string
+	Error  string
+}
+
+func newExportReport(record analysisRecord, loc *time.Location) exportReport {
+	record = localizeRecord(record, loc)
+	report := exportReport{
+		analysisRecord: record,
+		Title:          recordTitle(record),
+		Severity:       recordSeverity(record),
+		Color:          fmt.Sprintf("#%06X", recordStyle(record).color),
+		ExportedAt:     inLocation(time.Now().UTC(), loc),
+	}
+	for _, log := range record.ProbeEvents {
+		for _, raw := range log.Events {
+			var e struct {
+				Time   string `json:"time"`
+				Probe  string `json:"probe"`
+				Target string `json:"target"`
+				State  string `json:"state"`
+				Error  string `json:"error"`
+			}
+			if json.Unmarshal(raw, &e) == nil {
+				report.Events = append(report.Events, exportEvent{log.Source, e.Time, e.Probe, e.Target, e.State, e.Error})
+			}
+		}
+	}
+	slices.SortStableFunc(report.Events, func(a, b exportEvent) int { return strings.Compare(a.Time, b.Time) })
+	return report
+}
+
+// handleExport serves GET /analyses/{id}/export?format=markdown|html, a
+// stored analysis as an incident report for tickets and wikis.
+func (s *server) handleExport(w http.ResponseWriter, r *http.Request) {
+	record, ok := s.store.get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "analysis not found", http.StatusNotFound)
+		return
+	}
+	report := newExportReport(record, s.cfg.DisplayLocation)
+	var err error
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "markdown", "md":
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		err = markdownReport.Execute(w, report)
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err = htmlReport.Execute(w, report)
+	default:
+		http.Error(w, "format must be markdown or html", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "render report: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// mdCell makes s safe inside a Markdown table cell.
+func mdCell(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func labelList(labels map[string]string) string {
+	return strings.ReplaceAll(labelString(labels), ",", ", ")
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02 15:04:05 MST")
+}
+
+// seconds formats a duration in seconds rounded to the second.
+func seconds(f float64) string {
+	return (time.Duration(f) * time.Second).String()
+}
+
+func percent(f float64) string {
+	return fmt.Sprintf("%.0f%%", f*100)
+}
+
+var exportFuncs = map[string]any{
+	"cell":    mdCell,
+	"labels":  labelList,
+	"time":    formatTime,
+	"percent": percent,
+	"seconds": seconds,
+	"upper":   strings.ToUpper,
+}
+
+var markdownReport = template.Must(template.New("markdown").Funcs(exportFuncs).Parse(`# Incident report: {{.Title}}
+
+| | |
+|---|---|
+| Status | {{upper .AlertStatus}} |
+{{- with .Severity}}
+| Severity | {{.}} |{{end}}
+| Received | {{time .ReceivedAt}} |
+| Analyzed | {{time .CompletedAt}} |
+{{- with .Receiver}}
+| Receiver | {{cell .}} |{{end}}
+{{- with .Policy}}
+| Policy | {{cell .}} |{{end}}
+{{- with .Maintenance}}
+| Maintenance window | {{cell .}} |{{end}}
+{{- with .Resolution}}
+| Resolved | {{time .ResolvedAt}} (after {{seconds .DurationSeconds}}) |{{end}}
+| Analysis ID | ` + "`{{.ID}}`" + ` |
+{{- with .Error}}
+
+> **Enrichment error:** {{.}}{{end}}
+
+## Alerts
+
+| Status | Labels | Started | Ended | Summary |
+|---|---|---|---|---|
+{{- range .AlertSummaries}}
+| {{.Status}} | {{cell (labels .Labels)}} | {{time .StartsAt}} | {{time .EndsAt}} | {{cell (index .Annotations "summary")}} |
+{{- end}}
+{{with .Heuristic}}
+## Rule verdict
+
+**{{.Verdict}}** (rule ` + "`{{.Rule}}`" + `{{if .Confidence}}, confidence {{percent .Confidence}}{{end}})
+{{range .Evidence}}
+- {{.}}{{end}}
+{{end}}
+## Findings
+{{if .LLMSkipped}}
+No LLM was called for this analysis.
+{{end}}
+{{- range .Providers}}
+### {{.Provider}}{{with .Model}} ({{.}}){{end}}{{with .Arm}}, {{.}} arm{{end}}
+{{with .Parsed}}
+{{.Summary}}
+
+- **Likely issue:** {{.LikelyIssue}}
+- **Confidence:** {{percent .Confidence}}
+{{- if .Evidence}}
+
+**Evidence**
+{{range .Evidence}}
+- {{.}}{{end}}{{end}}
+{{- if .PotentialFix}}
+
+**Potential fixes**
+{{range .PotentialFix}}
+1. {{.}}{{end}}{{end}}
+{{- if .NextChecks}}
+
+**Next checks**
+{{range .NextChecks}}
+- {{.}}{{end}}{{end}}
+{{else}}{{with .Error}}
+> **Failed:** {{.}}
+{{else}}
+{{.Response}}
+{{end}}{{end}}
+{{- end}}
+{{- if .Metrics}}
+## Metrics
+
+| Metric | Value | Description |
+|---|---|---|
+{{- range .Metrics}}
+| ` + "`{{.Name}}`" + `{{with .Instance}} ({{.}}){{end}} | {{if .Error}}error: {{cell .Error}}{{else}}{{cell .Summary}}{{end}} | {{cell .Description}} |
+{{- end}}
+{{end}}
+{{- if .External}}
+## External context
+{{range .External}}
+- **{{.Source}}** ({{.Type}}){{with .Status}}: {{.}}{{end}}{{with .Error}}: error: {{.}}{{end}}
+{{- range .Incidents}}
+  - {{.Title}}{{with .Status}} ({{.}}){{end}}{{end}}
+{{- end}}
+{{end}}
+{{- if .Events}}
+## Probe events
+
+| Time | Source | Probe | Target | State | Error |
+|---|---|---|---|---|---|
+{{- range .Events}}
+| {{.Time}} | {{cell .Source}} | {{.Probe}} | {{cell .Target}} | {{.State}} | {{cell .Error}} |
+{{- end}}
+{{end}}
+{{- if .Feedback}}
+## Feedback
+{{range .Feedback}}
+- {{.Score}}/5{{with .Provider}} for {{.}}{{end}}{{with .Comment}}: {{.}}{{end}}{{end}}
+{{end}}
+---
+Exported {{time .ExportedAt}} by alert-receiver.
+`))
+
+var htmlReport = htmltemplate.Must(htmltemplate.New("html").Funcs(exportFuncs).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Incident report: {{.Title}}</title>
+<style>
+body{font-family:sans-serif;max-width:960px;margin:2em auto;color:#222}
+table{border-collapse:collapse;margin:1em 0}
+th,td{border:1px solid #ddd;padding:4px 8px;text-align:left;vertical-align:top}
+th{background:#f4f4f4}
+.banner{border-left:8px solid {{.Color}};padding:4px 12px}
+.error{color:#D13438}
+code{background:#f4f4f4;padding:0 3px}
+</style></head>
+<body>
+<div class="banner"><h1>Incident report: {{.Title}}</h1></div>
+<table>
+<tr><th>Status</th><td>{{upper .AlertStatus}}</td></tr>
+{{- with .Severity}}<tr><th>Severity</th><td>{{.}}</td></tr>{{end}}
+<tr><th>Received</th><td>{{time .ReceivedAt}}</td></tr>
+<tr><th>Analyzed</th><td>{{time .CompletedAt}}</td></tr>
+{{- with .Receiver}}<tr><th>Receiver</th><td>{{.}}</td></tr>{{end}}
+{{- with .Policy}}<tr><th>Policy</th><td>{{.}}</td></tr>{{end}}
+{{- with .Maintenance}}<tr><th>Maintenance window</th><td>{{.}}</td></tr>{{end}}
+{{- with .Resolution}}<tr><th>Resolved</th><td>{{time .ResolvedAt}} (after {{seconds .DurationSeconds}})</td></tr>{{end}}
+<tr><th>Analysis ID</th><td><code>{{.ID}}</code></td></tr>
+</table>
+{{- with .Error}}<p class="error"><b>Enrichment error:</b> {{.}}</p>{{end}}
+
+<h2>Alerts</h2>
+<table><tr><th>Status</th><th>Labels</th><th>Started</th><th>Ended</th><th>Summary</th></tr>
+{{- range .AlertSummaries}}
+<tr><td>{{.Status}}</td><td>{{labels .Labels}}</td><td>{{time .StartsAt}}</td><td>{{time .EndsAt}}</td><td>{{index .Annotations "summary"}}</td></tr>
+{{- end}}
+</table>
+{{with .Heuristic}}
+<h2>Rule verdict</h2>
+<p><b>{{.Verdict}}</b> (rule <code>{{.Rule}}</code>{{if .Confidence}}, confidence {{percent .Confidence}}{{end}})</p>
+<ul>{{range .Evidence}}<li>{{.}}</li>{{end}}</ul>
+{{end}}
+<h2>Findings</h2>
+{{- if .LLMSkipped}}<p>No LLM was called for this analysis.</p>{{end}}
+{{- range .Providers}}
+<h3>{{.Provider}}{{with .Model}} ({{.}}){{end}}{{with .Arm}}, {{.}} arm{{end}}</h3>
+{{- with .Parsed}}
+<p>{{.Summary}}</p>
+<p><b>Likely issue:</b> {{.LikelyIssue}}<br><b>Confidence:</b> {{percent .Confidence}}</p>
+{{- with .Evidence}}<p><b>Evidence</b></p><ul>{{range .}}<li>{{.}}</li>{{end}}</ul>{{end}}
+{{- with .PotentialFix}}<p><b>Potential fixes</b></p><ol>{{range .}}<li>{{.}}</li>{{end}}</ol>{{end}}
+{{- with .NextChecks}}<p><b>Next checks</b></p><ul>{{range .}}<li>{{.}}</li>{{end}}</ul>{{end}}
+{{- else}}{{with .Error}}<p class="error"><b>Failed:</b> {{.}}</p>{{else}}<pre>{{.Response}}</pre>{{end}}{{end}}
+{{- end}}
+{{- if .Metrics}}
+<h2>Metrics</h2>
+<table><tr><th>Metric</th><th>Value</th><th>Description</th></tr>
+{{- range .Metrics}}
+<tr><td><code>{{.Name}}</code>{{with .Instance}} ({{.}}){{end}}</td><td>{{if .Error}}<span class="error">{{.Error}}</span>{{else}}{{.Summary}}{{end}}</td><td>{{.Description}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- if .External}}
+<h2>External context</h2>
+<ul>
+{{- range .External}}
+<li><b>{{.Source}}</b> ({{.Type}}){{with .Status}}: {{.}}{{end}}{{with .Error}}: <span class="error">{{.}}</span>{{end}}
+{{- with .Incidents}}<ul>{{range .}}<li>{{.Title}}{{with .Status}} ({{.}}){{end}}</li>{{end}}</ul>{{end}}</li>
+{{- end}}
+</ul>
+{{- end}}
+{{- if .Events}}
+<h2>Probe events</h2>
+<table><tr><th>Time</th><th>Source</th><th>Probe</th><th>Target</th><th>State</th><th>Error</th></tr>
+{{- range .Events}}
+<tr><td>{{.Time}}</td><td>{{.Source}}</td><td>{{.Probe}}</td><td>{{.Target}}</td><td>{{.State}}</td><td>{{.Error}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- if .Feedback}}
+<h2>Feedback</h2>
+<ul>{{range .Feedback}}<li>{{.Score}}/5{{with .Provider}} for {{.}}{{end}}{{with .Comment}}: {{.}}{{end}}</li>{{end}}</ul>
+{{- end}}
+<hr><p><small>Exported {{time .ExportedAt}} by alert-receiver.</small></p>
+</body></html>
+`))
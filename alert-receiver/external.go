@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxExternalBody bounds how much of a status page or feed is read.
+	maxExternalBody = 1 << 20
+	// maxExternalItems bounds the incidents or feed items kept per source.
+	maxExternalItems = 10
+	// externalCacheTTL lets a burst of alerts share one fetch per source.
+	externalCacheTTL = time.Minute
+)
+
+// ExternalSourceConfig is one entry of EXTERNAL_CONTEXT_JSON.
+type ExternalSourceConfig struct {
+	Name string `json:"name"`
+	// Type is statuspage (an Atlassian Statuspage /api/v2/summary.json),
+	// rss (an RSS or Atom feed, such as a Downdetector-style outage feed) or
+	// weather (Open-Meteo conditions at the site).
+	Type string `json:"type"`
+	URL  string `json:"url"`
+	// Match, for rss, keeps only items whose title or summary matches this
+	// regular expression (e.g. the ISP's name in a shared outage feed).
+	Match string `json:"match,omitempty"`
+	// Alerts selects the firing alerts, by alertname or failure_domain,
+	// that query this source. It defaults to EXTERNAL_CONTEXT_MATCH, and for
+	// weather to every alert.
+	Alerts string `json:"alerts,omitempty"`
+	// Latitude and Longitude locate the site, for weather.
+	Latitude  float64 `json:"latitude,omitempty"`
+	Longitude float64 `json:"longitude,omitempty"`
+}
+
+// ExternalContext is what one source said around the time of an alert.
+type ExternalContext struct {
+	Source    string             `json:"source"`
+	Type      string             `json:"type"`
+	Status    string             `json:"status,omitempty"`
+	Incidents []ExternalIncident `json:"incidents,omitempty"`
+	Weather   *WeatherConditions `json:"weather,omitempty"`
+	Error     string             `json:"error,omitempty"`
+}
+
+// ExternalIncident is an unresolved status page incident or a recent feed
+// item.
+type ExternalIncident struct {
+	Title     string     `json:"title"`
+	Status    string     `json:"status,omitempty"`
+	Impact    string     `json:"impact,omitempty"`
+	URL       string     `json:"url,omitempty"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// ContextFetcher reads one external source. since is the start of the
+// alert's analysis window; older feed items are left out.
+type ContextFetcher interface {
+	Name() string
+	Type() string
+	Fetch(ctx context.Context, since time.Time) (ExternalContext, error)
+}
+
+func parseExternalSources(raw string) ([]ExternalSourceConfig, error) {
+	var sources []ExternalSourceConfig
+	if err := json.Unmarshal([]byte(raw), &sources); err != nil {
+		return nil, fmt.Errorf("parse EXTERNAL_CONTEXT_JSON: %w", err)
+	}
+	for i := range sources {
+		sources[i].Type = strings.ToLower(strings.TrimSpace(sources[i].Type))
+		if sources[i].Name == "" {
+			sources[i].Name = sources[i].Type
+		}
+	}
+	return sources, nil
+}
+
+func buildFetchers(sources []ExternalSourceConfig, match *regexp.Regexp, timeout time.Duration) ([]*cachedFetcher, error) {
+	client := &http.Client{Timeout: timeout}
+	fetchers := make([]*cachedFetcher, 0, len(sources))
+	for _, src := range sources {
+		if src.Type == "weather" && src.URL == "" {
+			src.URL = openMeteoURL
+		}
+		if !strings.HasPrefix(src.URL, "http://") && !strings.HasPrefix(src.URL, "https://") {
+			return nil, fmt.Errorf("external context source %q: url must be http(s)", src.Name)
+		}
+		alerts := match
+		if src.Type == "weather" {
+			alerts = nil
+		}
+		if src.Alerts != "" {
+			var err error
+			if alerts, err = regexp.Compile(src.Alerts); err != nil {
+				return nil, fmt.Errorf("external context source %q: alerts: %w", src.Name, err)
+			}
+		}
+		var f ContextFetcher
+		switch src.Type {
+		case "statuspage":
+			f = &statuspageFetcher{name: src.Name, url: statuspageSummaryURL(src.URL), client: client}
+		case "rss":
+			var match *regexp.Regexp
+			if src.Match != "" {
+				var err error
+				if match, err = regexp.Compile(src.Match); err != nil {
+					return nil, fmt.Errorf("external context source %q: match: %w", src.Name, err)
+				}
+			}
+			f = &feedFetcher{name: src.Name, url: src.URL, match: match, client: client}
+		case "weather":
+			if src.Latitude == 0 && src.Longitude == 0 {
+				return nil, fmt.Errorf("external context source %q: weather needs latitude and longitude", src.Name)
+			}
+			f = &weatherFetcher{name: src.Name, url: src.URL, latitude: src.Latitude, longitude: src.Longitude, client: client}
+		default:
+			return nil, fmt.Errorf("external context source %q: unsupported type %q (want statuspage, rss or weather)", src.Name, src.Type)
+		}
+		fetchers = append(fetchers, &cachedFetcher{ContextFetcher: f, alerts: alerts})
+	}
+	return fetchers, nil
+}
+
+// matchesAlerts reports whether any firing alert in the payload matches
+// pattern on its alertname or failure_domain label. A nil pattern matches
+// any firing alert.
+func matchesAlerts(payload GrafanaWebhookPayload, pattern *regexp.Regexp) bool {
+	for _, alert := range payload.Alerts {
+		if alert.Status != "" && alert.Status != "firing" {
+			continue
+		}
+		if pattern == nil || pattern.MatchString(alert.Labels["alertname"]) || pattern.MatchString(alert.Labels["failure_domain"]) {
+			return true
+		}
+	}
+	return false
+}
+
+// collectExternalContext queries, in parallel, every source whose alerts
+// pattern matches the job. A failed source is reported in its entry rather
+// than failing the job.
+func (s *server) collectExternalContext(job analysisJob) []ExternalContext {
+	var fetchers []*cachedFetcher
+	for _, f := range s.fetchers {
+		if matchesAlerts(job.Payload, f.alerts) {
+			fetchers = append(fetchers, f)
+		}
+	}
+	if len(fetchers) == 0 {
+		return nil
+	}
+	since := earliestAlertTime(job.Payload, job.ReceivedAt).Add(-s.cfg.PrometheusLookback)
+
+	results := make([]ExternalContext, len(fetchers))
+	var wg sync.WaitGroup
+	for i, f := range fetchers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), s.cfg.ExternalContextTimeout)
+			defer cancel()
+			result, err := f.Fetch(ctx, since)
+			if err != nil {
+				externalFetchesTotal.WithLabelValues(f.Name(), "error").Inc()
+				result = ExternalContext{Source: f.Name(), Type: f.Type(), Error: err.Error()}
+			} else {
+				externalFetchesTotal.WithLabelValues(f.Name(), "success").Inc()
+			}
+			results[i] = result
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// cachedFetcher reuses a source's last successful answer for
+// externalCacheTTL, so a burst of alerts during one outage does not hit
+// the status page once per alert. alerts selects the jobs that query it.
+type cachedFetcher struct {
+	ContextFetcher
+	alerts *regexp.Regexp
+
+	mu      sync.Mutex
+	at      time.Time
+	since   time.Time
+	context ExternalContext
+}
+
+func (c *cachedFetcher) Fetch(ctx context.Context, since time.Time) (ExternalContext, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.at.IsZero() && time.Since(c.at) < externalCacheTTL && !since.Before(c.since) {
+		return c.context, nil
+	}
+	result, err := c.ContextFetcher.Fetch(ctx, since)
+	if err != nil {
+		return ExternalContext{}, err
+	}
+	c.at, c.since, c.context = time.Now(), since, result
+	return result, nil
+}
+
+func fetchBody(ctx context.Context, client *http.Client, url, accept string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	req.Header.Set("User-Agent", "edge-monitor-app alert-receiver")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxExternalBody))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+	return body, nil
+}
+
+// statuspageFetcher reads an Atlassian Statuspage summary: the overall
+// status and the unresolved incidents. Many providers' public status pages
+// (status.<provider>.com) are Statuspage sites.
+type statuspageFetcher struct {
+	name   string
+	url    string
+	client *http.Client
+}
+
+// statuspageSummaryURL accepts either the status page's address or the
+// summary endpoint itself.
+func statuspageSummaryURL(u string) string {
+	if strings.HasSuffix(u, ".json") {
+		return u
+	}
+	return strings.TrimRight(u, "/") + "/api/v2/summary.json"
+}
+
+func (f *statuspageFetcher) Name() string { return f.name }
+func (f *statuspageFetcher) Type() string { return "statuspage" }
+
+func (f *statuspageFetcher) Fetch(ctx context.Context, _ time.Time) (ExternalContext, error) {
+	body, err := fetchBody(ctx, f.client, f.url, "application/json")
+	if err != nil {
+		return ExternalContext{}, err
+	}
+	var summary struct {
+		Status struct {
+			Description string `json:"description"`
+		} `json:"status"`
+		Incidents []struct {
+			Name      string    `json:"name"`
+			Status    string    `json:"status"`
+			Impact    string    `json:"impact"`
+			Shortlink string    `json:"shortlink"`
+			CreatedAt time.Time `json:"created_at"`
+			UpdatedAt time.Time `json:"updated_at"`
+		} `json:"incidents"`
+	}
+	if err := json.Unmarshal(body, &summary); err != nil {
+		return ExternalContext{}, fmt.Errorf("decode status page summary: %w", err)
+	}
+
+	out := ExternalContext{Source: f.name, Type: f.Type(), Status: summary.Status.Description}
+	for _, inc := range summary.Incidents {
+		out.Incidents = append(out.Incidents, ExternalIncident{
+			Title:     inc.Name,
+			Status:    inc.Status,
+			Impact:    inc.Impact,
+			URL:       inc.Shortlink,
+			StartedAt: optionalTime(inc.CreatedAt),
+			UpdatedAt: optionalTime(inc.UpdatedAt),
+		})
+	}
+	if len(out.Incidents) > maxExternalItems {
+		out.Incidents = out.Incidents[:maxExternalItems]
+	}
+	return out, nil
+}
+
+// feedFetcher reads an RSS 2.0 or Atom feed and keeps the items published
+// since the start of the analysis window.
+type feedFetcher struct {
+	name   string
+	url    string
+	match  *regexp.Regexp
+	client *http.Client
+}
+
+func (f *feedFetcher) Name() string { return f.name }
+func (f *feedFetcher) Type() string { return "rss" }
+
+func (f *feedFetcher) Fetch(ctx context.Context, since time.Time) (ExternalContext, error) {
+	body, err := fetchBody(ctx, f.client, f.url, "application/rss+xml, application/atom+xml, application/xml")
+	if err != nil {
+		return ExternalContext{}, err
+	}
+	items, err := parseFeed(body)
+	if err != nil {
+		return ExternalContext{}, err
+	}
+
+	out := ExternalContext{Source: f.name, Type: f.Type()}
+	for _, item := range items {
+		if !item.published.IsZero() && item.published.Before(since) {
+			continue
+		}
+		if f.match != nil && !f.match.MatchString(item.title) && !f.match.MatchString(item.summary) {
+			continue
+		}
+		out.Incidents = append(out.Incidents, ExternalIncident{
+			Title:     item.title,
+			URL:       item.link,
+			StartedAt: optionalTime(item.published),
+		})
+	}
+	// Newest first; undated items last.
+	sort.SliceStable(out.Incidents, func(i, j int) bool {
+		a, b := out.Incidents[i].StartedAt, out.Incidents[j].StartedAt
+		return a != nil && (b == nil || a.After(*b))
+	})
+	if len(out.Incidents) > maxExternalItems {
+		out.Incidents = out.Incidents[:maxExternalItems]
+	}
+	return out, nil
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+type feedItem struct {
+	title     string
+	summary   string
+	link      string
+	published time.Time
+}
+
+// parseFeed reads the items of an RSS 2.0 document or the entries of an
+// Atom feed.
+func parseFeed(body []byte) ([]feedItem, error) {
+	var doc struct {
+		Items []struct {
+			Title       string `xml:"title"`
+			Description string `xml:"description"`
+			Link        string `xml:"link"`
+			PubDate     string `xml:"pubDate"`
+		} `xml:"channel>item"`
+		Entries []struct {
+			Title   string `xml:"title"`
+			Summary string `xml:"summary"`
+			Link    struct {
+				Href string `xml:"href,attr"`
+			} `xml:"link"`
+			Published string `xml:"published"`
+			Updated   string `xml:"updated"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("decode feed: %w", err)
+	}
+
+	var items []feedItem
+	for _, it := range doc.Items {
+		items = append(items, feedItem{
+			title:     strings.TrimSpace(it.Title),
+			summary:   strings.TrimSpace(it.Description),
+			link:      strings.TrimSpace(it.Link),
+			published: parseFeedTime(it.PubDate),
+		})
+	}
+	for _, e := range doc.Entries {
+		published := parseFeedTime(e.Published)
+		if published.IsZero() {
+			published = parseFeedTime(e.Updated)
+		}
+		items = append(items, feedItem{
+			title:     strings.TrimSpace(e.Title),
+			summary:   strings.TrimSpace(e.Summary),
+			link:      e.Link.Href,
+			published: published,
+		})
+	}
+	return items, nil
+}
+
+// parseFeedTime parses RSS (RFC 1123) and Atom (RFC 3339) timestamps; an
+// unparseable one is zero and the item is kept.
+func parseFeedTime(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{time.RFC1123Z, time.RFC1123, time.RFC3339, "Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
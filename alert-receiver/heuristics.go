@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// HeuristicRule is one entry of HEURISTICS_JSON: a verdict reached when a
+// condition over the collected metric snapshots holds.
+type HeuristicRule struct {
+	Name string `json:"name"`
+	// When is conditions of the form "<metric> <op> <number>" joined by
+	// AND and OR, AND binding tighter. A metric is a snapshot name, which
+	// uses the average of its series, or min(), max(), sum() or count() of
+	// one; op is <, <=, >, >=, == or !=. Apart from count(), conditions on
+	// a snapshot that failed or has no series do not hold.
+	When       string  `json:"when"`
+	Verdict    string  `json:"verdict"`
+	Confidence float64 `json:"confidence,omitempty"`
+
+	clauses [][]heuristicCondition // OR of ANDs
+}
+
+type heuristicCondition struct {
+	text   string
+	agg    string
+	metric string
+	op     string
+	value  float64
+}
+
+// HeuristicVerdict is the first heuristic rule that held for an analysis,
+// with the values that made it hold.
+type HeuristicVerdict struct {
+	Rule       string   `json:"rule"`
+	Verdict    string   `json:"verdict"`
+	Confidence float64  `json:"confidence,omitempty"`
+	Evidence   []string `json:"evidence"`
+}
+
+// defaultHeuristics apply when HEURISTICS_JSON is unset. They use the
+// names of the built-in metric queries.
+const defaultHeuristics = `[
+  {"name":"wan-outage","when":"wan_reachable_avg < 0.2 AND gateway_reachable_avg > 0.9","verdict":"WAN outage: the gateway answers but the internet does not","confidence":0.8},
+  {"name":"gateway-down","when":"gateway_reachable_avg < 0.5","verdict":"Gateway or LAN failure: the gateway itself is unreachable","confidence":0.7},
+  {"name":"weak-wifi","when":"min(wifi_signal_min_dbm) < -75 OR min(wifi_snr_min_db) < 15","verdict":"Weak Wi-Fi signal at the probe","confidence":0.6},
+  {"name":"dns","when":"sum(dns_timeouts) > 5 AND wan_reachable_avg > 0.9","verdict":"DNS resolver problem while the WAN is up","confidence":0.6}
+]`
+
+var heuristicConditionRE = regexp.MustCompile(`^(?:(min|max|avg|sum|count)\(\s*([A-Za-z_:][A-Za-z0-9_:]*)\s*\)|([A-Za-z_:][A-Za-z0-9_:]*))\s*(<=|>=|==|!=|<|>)\s*(\S+)$`)
+
+var (
+	heuristicOr  = regexp.MustCompile(`(?i)\s+OR\s+`)
+	heuristicAnd = regexp.MustCompile(`(?i)\s+AND\s+`)
+)
+
+// parseHeuristics reads HEURISTICS_JSON, or the defaults when raw is "".
+func parseHeuristics(raw string) ([]HeuristicRule, error) {
+	if strings.TrimSpace(raw) == "" {
+		raw = defaultHeuristics
+	}
+	var rules []HeuristicRule
+	if err := json.Unmarshal([]byte(raw), &rules); err != nil {
+		return nil, fmt.Errorf("parse HEURISTICS_JSON: %w", err)
+	}
+	for i := range rules {
+		r := &rules[i]
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("heuristic-%d", i+1)
+		}
+		if r.Verdict == "" {
+			return nil, fmt.Errorf("heuristic %q: missing verdict", r.Name)
+		}
+		for _, or := range heuristicOr.Split(strings.TrimSpace(r.When), -1) {
+			var clause []heuristicCondition
+			for _, text := range heuristicAnd.Split(or, -1) {
+				c, err := parseHeuristicCondition(strings.TrimSpace(text))
+				if err != nil {
+					return nil, fmt.Errorf("heuristic %q: %w", r.Name, err)
+				}
+				clause = append(clause, c)
+			}
+			r.clauses = append(r.clauses, clause)
+		}
+	}
+	return rules, nil
+}
+
+func parseHeuristicCondition(text string) (heuristicCondition, error) {
+	m := heuristicConditionRE.FindStringSubmatch(text)
+	if m == nil {
+		return heuristicCondition{}, fmt.Errorf("condition %q: want <metric> <op> <number>", text)
+	}
+	value, err := strconv.ParseFloat(m[5], 64)
+	if err != nil {
+		return heuristicCondition{}, fmt.Errorf("condition %q: %q is not a number", text, m[5])
+	}
+	c := heuristicCondition{text: text, agg: m[1], metric: m[2], op: m[4], value: value}
+	if c.agg == "" {
+		c.agg, c.metric = "avg", m[3]
+	}
+	return c, nil
+}
+
+// evaluateHeuristics returns the verdict of the first rule that holds for
+// the snapshots, or nil. The per-instance snapshots of a sharded query are
+// taken together, so max and min still find the worst instance.
+func evaluateHeuristics(rules []HeuristicRule, snapshots []MetricSnapshot) *HeuristicVerdict {
+	byName := make(map[string]MetricSnapshot, len(snapshots))
+	for _, s := range snapshots {
+		merged, ok := byName[s.Name]
+		switch {
+		case !ok || merged.Error != "":
+			byName[s.Name] = s
+		case s.Error == "":
+			merged.Series = append(slices.Clip(merged.Series), s.Series...)
+			byName[s.Name] = merged
+		}
+	}
+	for _, r := range rules {
+		for _, clause := range r.clauses {
+			evidence, ok := evaluateClause(clause, byName)
+			if !ok {
+				continue
+			}
+			heuristicVerdictsTotal.WithLabelValues(r.Name).Inc()
+			return &HeuristicVerdict{Rule: r.Name, Verdict: r.Verdict, Confidence: r.Confidence, Evidence: evidence}
+		}
+	}
+	return nil
+}
+
+// evaluateClause reports whether every condition holds, and the value each
+// condition saw.
+func evaluateClause(clause []heuristicCondition, snapshots map[string]MetricSnapshot) ([]string, bool) {
+	evidence := make([]string, 0, len(clause))
+	for _, c := range clause {
+		v, ok := aggregateSnapshot(snapshots[c.metric], c.agg)
+		if !ok || !compare(v, c.op, c.value) {
+			return nil, false
+		}
+		evidence = append(evidence, fmt.Sprintf("%s (%s = %g)", c.text, c.metric, v))
+	}
+	return evidence, true
+}
+
+// aggregateSnapshot reduces a snapshot's series to one value, skipping
+// values that are not numbers.
+func aggregateSnapshot(s MetricSnapshot, agg string) (float64, bool) {
+	if s.Error != "" {
+		return 0, false
+	}
+	var values []float64
+	for _, series := range s.Series {
+		if v, err := strconv.ParseFloat(series.Value, 64); err == nil && !math.IsNaN(v) {
+			values = append(values, v)
+		}
+	}
+	if agg == "count" {
+		return float64(len(values)), true
+	}
+	if len(values) == 0 {
+		return 0, false
+	}
+	switch agg {
+	case "min":
+		return slices.Min(values), true
+	case "max":
+		return slices.Max(values), true
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	if agg == "avg" {
+		return sum / float64(len(values)), true
+	}
+	return sum, true
+}
+
+func compare(a float64, op string, b float64) bool {
+	switch op {
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	case "==":
+		return a == b
+	case "!=":
+		return a != b
+	}
+	return false
+}
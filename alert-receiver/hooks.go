@@ -0,0 +1,412 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// HookRule is one entry of HOOKS_JSON: actions taken on a finished
+// analysis when an expression over it holds, for routing that analysis
+// policies cannot express.
+type HookRule struct {
+	Name string `json:"name"`
+	// When is an expression over the analysis, such as
+	// `confidence < 0.3 and severity == critical`. Comparisons take a
+	// field on the left and a number, a quoted string or a bare word on
+	// the right, with ==, != (case-insensitive for strings), <, <=, >, >=
+	// (numbers only), =~ and !~ (a regular expression matching the whole
+	// value). A field alone holds when it is set and not false or 0. They
+	// combine with and, or, not and parentheses. Comparisons on a field
+	// the analysis does not have do not hold.
+	When string `json:"when"`
+	// Then is the actions, in any order: notify(<sink>) sends the analysis
+	// to that NOTIFY_SINKS_JSON sink, even when its policy does not notify
+	// or it is in a maintenance window; suppress keeps it from the sinks
+	// it would otherwise go to.
+	Then []string `json:"then"`
+
+	expr     hookExpr
+	sinks    []string
+	suppress bool
+}
+
+// hookFieldNames are the fields hook expressions can use, besides
+// labels.<name> and annotations.<name>.
+var hookFieldNames = []string{
+	"status", "severity", "receiver", "group_key", "policy", "maintenance",
+	"alerts", "error", "degraded", "llm_skipped", "post_mortem",
+	"duration_seconds", "heuristic", "heuristic_confidence",
+	"provider", "summary", "likely_issue", "category", "confidence",
+}
+
+var hookNotifyRE = regexp.MustCompile(`^notify\(\s*([^()\s]+)\s*\)$`)
+
+// parseHooks reads HOOKS_JSON. Sinks named by notify() must be in sinks.
+func parseHooks(raw string, sinks []NotifySinkConfig) ([]HookRule, error) {
+	var hooks []HookRule
+	if err := json.Unmarshal([]byte(raw), &hooks); err != nil {
+		return nil, fmt.Errorf("parse HOOKS_JSON: %w", err)
+	}
+	sinkNames := make(map[string]bool, len(sinks))
+	for _, sink := range sinks {
+		name := sink.Name
+		if name == "" {
+			name = sink.Type
+		}
+		sinkNames[name] = true
+	}
+	for i := range hooks {
+		h := &hooks[i]
+		if h.Name == "" {
+			h.Name = fmt.Sprintf("hook-%d", i+1)
+		}
+		expr, err := parseHookExpr(h.When)
+		if err != nil {
+			return nil, fmt.Errorf("hook %q: %w", h.Name, err)
+		}
+		h.expr = expr
+		if len(h.Then) == 0 {
+			return nil, fmt.Errorf("hook %q: missing then", h.Name)
+		}
+		for _, action := range h.Then {
+			action = strings.TrimSpace(action)
+			if action == "suppress" {
+				h.suppress = true
+				continue
+			}
+			m := hookNotifyRE.FindStringSubmatch(action)
+			if m == nil {
+				return nil, fmt.Errorf("hook %q: action %q: want notify(<sink>) or suppress", h.Name, action)
+			}
+			if !sinkNames[m[1]] {
+				return nil, fmt.Errorf("hook %q: notify(%s): no such NOTIFY_SINKS_JSON sink", h.Name, m[1])
+			}
+			h.sinks = append(h.sinks, m[1])
+		}
+	}
+	return hooks, nil
+}
+
+// hookRouting is what the hooks that held for an analysis decided.
+type hookRouting struct {
+	matched  []string
+	sinks    []string
+	suppress bool
+}
+
+// evaluateHooks runs every hook against the finished record.
+func evaluateHooks(hooks []HookRule, record analysisRecord) hookRouting {
+	var routing hookRouting
+	if len(hooks) == 0 {
+		return routing
+	}
+	fields := hookFields(record)
+	for _, h := range hooks {
+		if !h.expr.eval(fields) {
+			continue
+		}
+		hookMatchesTotal.WithLabelValues(h.Name).Inc()
+		routing.matched = append(routing.matched, h.Name)
+		routing.suppress = routing.suppress || h.suppress
+		for _, sink := range h.sinks {
+			if !slices.Contains(routing.sinks, sink) {
+				routing.sinks = append(routing.sinks, sink)
+			}
+		}
+	}
+	return routing
+}
+
+// hookFields flattens the record into the fields hook expressions see.
+// The provider fields come from the first provider result that parsed.
+func hookFields(record analysisRecord) map[string]string {
+	f := map[string]string{
+		"status":      record.AlertStatus,
+		"severity":    recordSeverity(record),
+		"receiver":    record.Receiver,
+		"group_key":   record.GroupKey,
+		"policy":      record.Policy,
+		"maintenance": record.Maintenance,
+		"alerts":      strconv.Itoa(len(record.AlertSummaries)),
+		"error":       record.Error,
+		"degraded":    strconv.FormatBool(record.Degraded),
+		"llm_skipped": strconv.FormatBool(record.LLMSkipped),
+		"post_mortem": strconv.FormatBool(record.PostMortem),
+	}
+	if record.Resolution != nil {
+		f["duration_seconds"] = strconv.FormatFloat(record.Resolution.DurationSeconds, 'f', -1, 64)
+	}
+	if record.Heuristic != nil {
+		f["heuristic"] = record.Heuristic.Rule
+		f["heuristic_confidence"] = strconv.FormatFloat(record.Heuristic.Confidence, 'f', -1, 64)
+	}
+	for _, p := range record.Providers {
+		if p.Parsed == nil {
+			continue
+		}
+		f["provider"] = p.Provider
+		f["summary"] = p.Parsed.Summary
+		f["likely_issue"] = p.Parsed.LikelyIssue
+		f["category"] = p.IssueCategory
+		f["confidence"] = strconv.FormatFloat(p.Parsed.Confidence, 'f', -1, 64)
+		break
+	}
+	for i := len(record.AlertSummaries) - 1; i >= 0; i-- {
+		a := record.AlertSummaries[i]
+		for k, v := range a.Labels {
+			f["labels."+k] = v
+		}
+		for k, v := range a.Annotations {
+			f["annotations."+k] = v
+		}
+	}
+	for k, v := range record.CommonLabels {
+		f["labels."+k] = v
+	}
+	for k, v := range record.CommonAnnots {
+		f["annotations."+k] = v
+	}
+	return f
+}
+
+// hookExpr is a parsed hook condition.
+type hookExpr interface {
+	eval(fields map[string]string) bool
+}
+
+type hookAnd struct{ a, b hookExpr }
+
+func (e hookAnd) eval(f map[string]string) bool { return e.a.eval(f) && e.b.eval(f) }
+
+type hookOr struct{ a, b hookExpr }
+
+func (e hookOr) eval(f map[string]string) bool { return e.a.eval(f) || e.b.eval(f) }
+
+type hookNot struct{ e hookExpr }
+
+func (e hookNot) eval(f map[string]string) bool { return !e.e.eval(f) }
+
+// hookSet holds when the field is set and not false or zero.
+type hookSet struct{ field string }
+
+func (e hookSet) eval(f map[string]string) bool {
+	v, ok := f[e.field]
+	if !ok || v == "" || strings.EqualFold(v, "false") {
+		return false
+	}
+	if n, err := strconv.ParseFloat(v, 64); err == nil {
+		return n != 0
+	}
+	return true
+}
+
+type hookCompare struct {
+	field string
+	op    string
+	value string
+	num   float64
+	isNum bool
+	re    *regexp.Regexp
+}
+
+func (e hookCompare) eval(f map[string]string) bool {
+	v, ok := f[e.field]
+	if !ok {
+		return false
+	}
+	switch e.op {
+	case "=~":
+		return e.re.MatchString(v)
+	case "!~":
+		return !e.re.MatchString(v)
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if e.isNum && err == nil {
+		return compare(n, e.op, e.num)
+	}
+	switch e.op {
+	case "==":
+		return strings.EqualFold(v, e.value)
+	case "!=":
+		return !strings.EqualFold(v, e.value)
+	}
+	return false
+}
+
+// hookToken is a lexeme of a hook expression: a word (field, keyword or
+// bare value), a quoted string, an operator or a parenthesis.
+type hookToken struct {
+	text   string
+	quoted bool
+}
+
+var hookTokenRE = regexp.MustCompile(`\s*(?:("(?:[^"\\]|\\.)*"|'[^']*')|(==|!=|<=|>=|=~|!~|<|>|\(|\))|([^\s()=!<>"']+))`)
+
+func lexHook(s string) ([]hookToken, error) {
+	var tokens []hookToken
+	for rest := strings.TrimSpace(s); rest != ""; rest = strings.TrimSpace(rest) {
+		m := hookTokenRE.FindStringSubmatchIndex(rest)
+		if m == nil || m[0] != 0 {
+			return nil, fmt.Errorf("unexpected %q", rest)
+		}
+		switch {
+		case m[2] >= 0:
+			lit := rest[m[2]:m[3]]
+			if lit[0] == '"' {
+				unq, err := strconv.Unquote(lit)
+				if err != nil {
+					return nil, fmt.Errorf("string %s: %w", lit, err)
+				}
+				lit = unq
+			} else {
+				lit = lit[1 : len(lit)-1]
+			}
+			tokens = append(tokens, hookToken{text: lit, quoted: true})
+		case m[4] >= 0:
+			tokens = append(tokens, hookToken{text: rest[m[4]:m[5]]})
+		default:
+			tokens = append(tokens, hookToken{text: rest[m[6]:m[7]]})
+		}
+		rest = rest[m[1]:]
+	}
+	return tokens, nil
+}
+
+// hookParser is a recursive descent parser over the tokens:
+//
+//	or      = and { "or" and }
+//	and     = unary { "and" unary }
+//	unary   = "not" unary | "(" or ")" | field [ op value ]
+type hookParser struct {
+	tokens []hookToken
+	pos    int
+}
+
+func parseHookExpr(text string) (hookExpr, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, fmt.Errorf("missing when")
+	}
+	tokens, err := lexHook(text)
+	if err != nil {
+		return nil, fmt.Errorf("when %q: %w", text, err)
+	}
+	p := &hookParser{tokens: tokens}
+	expr, err := p.or()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("when %q: %w", text, err)
+	}
+	return expr, nil
+}
+
+func (p *hookParser) peek() (hookToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return hookToken{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+// keyword consumes the next token when it is the unquoted keyword kw.
+func (p *hookParser) keyword(kw string) bool {
+	t, ok := p.peek()
+	if ok && !t.quoted && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *hookParser) or() (hookExpr, error) {
+	left, err := p.and()
+	for err == nil && p.keyword("or") {
+		var right hookExpr
+		right, err = p.and()
+		left = hookOr{left, right}
+	}
+	return left, err
+}
+
+func (p *hookParser) and() (hookExpr, error) {
+	left, err := p.unary()
+	for err == nil && p.keyword("and") {
+		var right hookExpr
+		right, err = p.unary()
+		left = hookAnd{left, right}
+	}
+	return left, err
+}
+
+func (p *hookParser) unary() (hookExpr, error) {
+	if p.keyword("not") {
+		e, err := p.unary()
+		return hookNot{e}, err
+	}
+	if p.keyword("(") {
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.keyword(")") {
+			return nil, fmt.Errorf("missing )")
+		}
+		return e, nil
+	}
+
+	t, ok := p.peek()
+	if !ok {
+		return nil, fmt.Errorf("unexpected end")
+	}
+	if t.quoted || !validHookField(t.text) {
+		return nil, fmt.Errorf("unknown field %q (want one of %s, labels.<name> or annotations.<name>)", t.text, strings.Join(hookFieldNames, ", "))
+	}
+	p.pos++
+	field := t.text
+
+	op, ok := p.peek()
+	if !ok || op.quoted || !slices.Contains([]string{"==", "!=", "<", "<=", ">", ">=", "=~", "!~"}, op.text) {
+		return hookSet{field}, nil
+	}
+	p.pos++
+	value, ok := p.peek()
+	if !ok || !value.quoted && slices.Contains([]string{"(", ")"}, value.text) {
+		return nil, fmt.Errorf("%s %s: missing value", field, op.text)
+	}
+	p.pos++
+
+	c := hookCompare{field: field, op: op.text, value: value.text}
+	switch c.op {
+	case "=~", "!~":
+		re, err := regexp.Compile("^(?:" + c.value + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("%s %s %q: %w", field, c.op, c.value, err)
+		}
+		c.re = re
+	case "<", "<=", ">", ">=":
+		n, err := strconv.ParseFloat(c.value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s %q: want a number", field, c.op, c.value)
+		}
+		c.num, c.isNum = n, true
+	default:
+		if n, err := strconv.ParseFloat(c.value, 64); err == nil && !value.quoted {
+			c.num, c.isNum = n, true
+		}
+	}
+	return c, nil
+}
+
+func validHookField(name string) bool {
+	if k, ok := strings.CutPrefix(name, "labels."); ok {
+		return k != ""
+	}
+	if k, ok := strings.CutPrefix(name, "annotations."); ok {
+		return k != ""
+	}
+	return slices.Contains(hookFieldNames, name)
+}
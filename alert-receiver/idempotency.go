@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// idempotencyHeader lets a sender name its retries explicitly; without it
+// the key is derived from the payload.
+const idempotencyHeader = "Idempotency-Key"
+
+// idempotencyPruneInterval is how often expired keys are swept.
+const idempotencyPruneInterval = time.Minute
+
+// idempotencyClaimer deduplicates webhook deliveries by key: an
+// idempotencyCache in this replica, or redisIdempotency across replicas.
+type idempotencyClaimer interface {
+	claim(key, jobID string, now time.Time) (string, bool)
+	release(key, jobID string)
+}
+
+// idempotencyCache remembers the job each webhook key was accepted as, so
+// a retried delivery (Grafana retries on a timeout even when the first
+// attempt got through) returns that job instead of queuing a duplicate.
+type idempotencyCache struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	jobs      map[string]idempotentJob
+	lastPrune time.Time
+}
+
+type idempotentJob struct {
+	id      string
+	expires time.Time
+}
+
+// newIdempotencyCache returns nil, which never deduplicates, for a ttl of
+// 0.
+func newIdempotencyCache(ttl time.Duration) *idempotencyCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &idempotencyCache{ttl: ttl, jobs: make(map[string]idempotentJob)}
+}
+
+// claim records key as accepted as jobID. When the key was already
+// claimed within the ttl it returns that job's ID and true instead.
+func (c *idempotencyCache) claim(key, jobID string, now time.Time) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Sub(c.lastPrune) >= idempotencyPruneInterval {
+		for k, job := range c.jobs {
+			if now.After(job.expires) {
+				delete(c.jobs, k)
+			}
+		}
+		c.lastPrune = now
+	}
+	if job, ok := c.jobs[key]; ok && !now.After(job.expires) {
+		return job.id, true
+	}
+	c.jobs[key] = idempotentJob{id: jobID, expires: now.Add(c.ttl)}
+	return "", false
+}
+
+// release forgets a claim whose job was not accepted, so the sender's
+// retry is admitted rather than answered as a duplicate.
+func (c *idempotencyCache) release(key, jobID string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.jobs[key].id == jobID {
+		delete(c.jobs, key)
+	}
+}
+
+// idempotencyKey returns the request's Idempotency-Key header, or a key
+// derived from the group key, status and each alert's identity and
+// timestamps; the source is "header" or "derived". A retry of one
+// notification derives the same key, while the next notification for the
+// group differs in status, alerts or their start and end times.
+func idempotencyKey(r *http.Request, payload GrafanaWebhookPayload) (key, source string) {
+	if h := strings.TrimSpace(r.Header.Get(idempotencyHeader)); h != "" {
+		return "header:" + h, "header"
+	}
+	alerts := make([]string, 0, len(payload.Alerts))
+	for _, a := range payload.Alerts {
+		id := a.Fingerprint
+		if id == "" {
+			id = labelString(a.Labels)
+		}
+		alerts = append(alerts, strings.Join([]string{
+			id, a.Status, a.StartsAt.UTC().Format(time.RFC3339Nano), a.EndsAt.UTC().Format(time.RFC3339Nano),
+		}, "|"))
+	}
+	slices.Sort(alerts)
+
+	h := sha256.New()
+	h.Write([]byte(payload.GroupKey + "\n" + payload.Status + "\n"))
+	for _, a := range alerts {
+		h.Write([]byte(a + "\n"))
+	}
+	return "derived:" + hex.EncodeToString(h.Sum(nil)), "derived"
+}
+
+// labelString is a label set in a stable order.
+func labelString(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, ",")
+}
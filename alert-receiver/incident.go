@@ -0,0 +1,233 @@
+package main
+
+import (
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// incidentStaleAfter closes an open incident without a resolution when no
+// firing notification has arrived for it this long, in case its resolved
+// notification was lost. Grafana and Alertmanager repeat firing
+// notifications every repeat_interval (4h by default).
+const incidentStaleAfter = 24 * time.Hour
+
+// maxPostMortemAnalyses bounds the firing analyses summarized in a
+// post-mortem prompt to the latest ones.
+const maxPostMortemAnalyses = 5
+
+// IncidentResolution is how an alert group's incident ended. It is set on
+// the analyses of the group's firing notifications and on the analysis of
+// the resolved notification that closed it.
+type IncidentResolution struct {
+	StartedAt       time.Time `json:"started_at"`
+	ResolvedAt      time.Time `json:"resolved_at"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	// ResolvedBy is the analysis of the resolved notification.
+	ResolvedBy string `json:"resolved_by"`
+	// Analyses are the analyses of the firing notifications, oldest first.
+	Analyses []string `json:"analyses"`
+}
+
+// openIncident is an alert group that has fired and not resolved yet.
+type openIncident struct {
+	startedAt time.Time
+	lastSeen  time.Time
+	analyses  []string
+}
+
+// incidentTracker follows the open incident of each alert group, by group
+// key, so a resolved notification closes the analyses of the firing ones.
+// It lives in one replica's memory and is rebuilt from the store at start.
+type incidentTracker struct {
+	store analysisStorage
+
+	mu   sync.Mutex
+	open map[string]*openIncident
+	// pending holds the resolutions of analyses that were still being
+	// processed when their incident resolved, applied when they are stored.
+	pending map[string]IncidentResolution
+}
+
+// newIncidentTracker returns a tracker over store, reopening the incidents
+// whose latest stored analyses are firing ones without a resolution.
+func newIncidentTracker(store analysisStorage) *incidentTracker {
+	t := &incidentTracker{
+		store:   store,
+		open:    make(map[string]*openIncident),
+		pending: make(map[string]IncidentResolution),
+	}
+	records := store.list()
+	sort.Slice(records, func(i, j int) bool { return records[i].ReceivedAt.Before(records[j].ReceivedAt) })
+	for _, r := range records {
+		switch {
+		case r.GroupKey == "":
+		case r.AlertStatus != "firing" || r.Resolution != nil:
+			delete(t.open, r.GroupKey)
+		default:
+			t.observe(r.GroupKey, r.ID, earliestStart(r.AlertSummaries, r.ReceivedAt), r.ReceivedAt)
+		}
+	}
+	t.prune(time.Now())
+	if len(t.open) > 0 {
+		slog.Info("open incidents restored", "incidents", len(t.open))
+	}
+	return t
+}
+
+// firing adds a firing notification's analysis to its group's incident,
+// opening one if the group has none.
+func (t *incidentTracker) firing(job analysisJob) {
+	if job.Payload.GroupKey == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.observe(job.Payload.GroupKey, job.ID, earliestAlertTime(job.Payload, job.ReceivedAt), job.ReceivedAt)
+	t.prune(job.ReceivedAt)
+}
+
+func (t *incidentTracker) observe(groupKey, id string, startedAt, at time.Time) {
+	inc, ok := t.open[groupKey]
+	if !ok {
+		inc = &openIncident{startedAt: startedAt}
+		t.open[groupKey] = inc
+	}
+	if startedAt.Before(inc.startedAt) {
+		inc.startedAt = startedAt
+	}
+	inc.lastSeen = at
+	inc.analyses = append(inc.analyses, id)
+}
+
+// resolve closes the incident of a resolved notification's group and marks
+// its firing analyses resolved. It returns the resolution, for the
+// resolved notification's own analysis, and false when the group had no
+// open incident.
+func (t *incidentTracker) resolve(job analysisJob) (IncidentResolution, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	inc, ok := t.open[job.Payload.GroupKey]
+	if !ok || job.Payload.GroupKey == "" {
+		return IncidentResolution{}, false
+	}
+	delete(t.open, job.Payload.GroupKey)
+
+	res := IncidentResolution{
+		StartedAt:  inc.startedAt.UTC(),
+		ResolvedAt: resolvedAt(job.Payload, job.ReceivedAt).UTC(),
+		ResolvedBy: job.ID,
+		Analyses:   inc.analyses,
+	}
+	if start := earliestAlertTime(job.Payload, res.StartedAt); start.Before(res.StartedAt) {
+		res.StartedAt = start.UTC()
+	}
+	res.DurationSeconds = max(res.ResolvedAt.Sub(res.StartedAt), 0).Seconds()
+	for _, id := range inc.analyses {
+		if !t.store.update(id, func(r *analysisRecord) bool {
+			r.Resolution = &res
+			return true
+		}) {
+			t.pending[id] = res
+		}
+	}
+
+	incidentsResolvedTotal.Inc()
+	incidentDurationSeconds.Observe(res.DurationSeconds)
+	openIncidents.Set(float64(len(t.open)))
+	slog.Info("incident resolved",
+		"job_id", job.ID,
+		"group_key", job.Payload.GroupKey,
+		"duration", time.Duration(res.DurationSeconds*float64(time.Second)).Round(time.Second).String(),
+		"analyses", len(res.Analyses),
+	)
+	return res, true
+}
+
+// add stores record with the resolution of its incident if that resolved
+// while it was being processed. Stores go through the tracker's lock so a
+// resolution cannot fall between a failed update and the store.
+func (t *incidentTracker) add(record analysisRecord) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if res, ok := t.pending[record.ID]; ok {
+		delete(t.pending, record.ID)
+		record.Resolution = &res
+	}
+	t.store.add(record)
+}
+
+// prune drops incidents with no firing notification for incidentStaleAfter
+// and resolutions of analyses that were never stored (dropped jobs), and
+// updates the open incident gauge.
+func (t *incidentTracker) prune(now time.Time) {
+	for key, inc := range t.open {
+		if now.Sub(inc.lastSeen) > incidentStaleAfter {
+			slog.Warn("open incident expired without a resolved notification", "group_key", key, "started_at", inc.startedAt)
+			delete(t.open, key)
+		}
+	}
+	for id, res := range t.pending {
+		if now.Sub(res.ResolvedAt) > incidentStaleAfter {
+			delete(t.pending, id)
+		}
+	}
+	openIncidents.Set(float64(len(t.open)))
+}
+
+// resolvedAt is when the last alert of a resolved group ended, or the
+// notification's arrival when the alerts carry no end.
+func resolvedAt(payload GrafanaWebhookPayload, fallback time.Time) time.Time {
+	var last time.Time
+	for _, alert := range payload.Alerts {
+		if alert.EndsAt.After(last) {
+			last = alert.EndsAt
+		}
+	}
+	if last.IsZero() || last.After(fallback) {
+		return fallback
+	}
+	return last
+}
+
+// earliestStart is the earliest start among stored alert summaries.
+func earliestStart(alerts []alertSummary, fallback time.Time) time.Time {
+	earliest := fallback
+	for _, a := range alerts {
+		if !a.StartsAt.IsZero() && a.StartsAt.Before(earliest) {
+			earliest = a.StartsAt
+		}
+	}
+	return earliest
+}
+
+// firingAnalyses returns the summaries of the latest analyses made while an
+// incident was firing, for its post-mortem.
+func (s *server) firingAnalyses(res *IncidentResolution) []map[string]any {
+	ids := res.Analyses
+	if len(ids) > maxPostMortemAnalyses {
+		ids = ids[len(ids)-maxPostMortemAnalyses:]
+	}
+	var out []map[string]any
+	for _, id := range ids {
+		record, ok := s.store.get(id)
+		if !ok {
+			continue
+		}
+		entry := map[string]any{"id": record.ID, "received_at": inLocation(record.ReceivedAt, s.cfg.DisplayLocation)}
+		for _, p := range record.Providers {
+			if p.Parsed != nil {
+				entry["summary"] = p.Parsed.Summary
+				entry["likely_issue"] = p.Parsed.LikelyIssue
+				entry["confidence"] = p.Parsed.Confidence
+				break
+			}
+		}
+		if record.Heuristic != nil {
+			entry["heuristic_verdict"] = record.Heuristic
+		}
+		out = append(out, entry)
+	}
+	return out
+}
@@ -7,10 +7,12 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"os"
+	"net/url"
 	"strings"
 	"time"
 
+	"edge-monitor-app/internal/config"
+
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	bedrockruntime "github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
@@ -37,9 +39,21 @@ type ProviderResult struct {
 	Type       string              `json:"type"`
 	Model      string              `json:"model"`
 	DurationMS int64               `json:"duration_ms"`
+	Prompt     *StoredPrompt       `json:"prompt,omitempty"`
 	Response   string              `json:"response,omitempty"`
 	Parsed     *StructuredAnalysis `json:"parsed,omitempty"`
-	Error      string              `json:"error,omitempty"`
+	// IssueCategory is Parsed.LikelyIssue sorted into ISSUE_TAXONOMY_JSON.
+	IssueCategory string `json:"issue_category,omitempty"`
+	Error         string `json:"error,omitempty"`
+	// ErrorClass sorts Error into auth, rate_limit, timeout,
+	// context_length, server or other; ErrorBody is the provider's raw
+	// HTTP error body, truncated to 2 KiB.
+	ErrorClass string `json:"error_class,omitempty"`
+	ErrorBody  string `json:"error_body,omitempty"`
+	// Experiment and Arm (control or candidate) tag a result produced
+	// under EXPERIMENTS_JSON.
+	Experiment string `json:"experiment,omitempty"`
+	Arm        string `json:"arm,omitempty"`
 }
 
 type LLMProvider interface {
@@ -62,6 +76,26 @@ func buildProviders(backends []BackendConfig) ([]LLMProvider, error) {
 	return providers, nil
 }
 
+// providerHTTPClient returns the client a backend calls its API with. It
+// follows HTTPS_PROXY and NO_PROXY unless the backend sets its own proxy.
+func providerHTTPClient(cfg BackendConfig) (*http.Client, error) {
+	proxy := http.ProxyFromEnvironment
+	switch cfg.Proxy {
+	case "":
+	case "direct":
+		proxy = nil
+	default:
+		u, err := url.Parse(cfg.Proxy)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") {
+			return nil, fmt.Errorf("backend %q: proxy must be \"direct\" or an http, https or socks5 URL", cfg.Name)
+		}
+		proxy = http.ProxyURL(u)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	return &http.Client{Timeout: 60 * time.Second, Transport: transport}, nil
+}
+
 func buildProvider(cfg BackendConfig) (LLMProvider, error) {
 	switch cfg.Type {
 	case "", "openai":
@@ -93,7 +127,7 @@ func newOpenAIProvider(cfg BackendConfig) (LLMProvider, error) {
 
 	apiKey := ""
 	if cfg.APIKeyEnv != "" {
-		apiKey = strings.TrimSpace(os.Getenv(cfg.APIKeyEnv))
+		apiKey = config.Secret(cfg.APIKeyEnv)
 	}
 	if apiKey == "" {
 		return nil, fmt.Errorf("openai backend %q is missing API key env %q", cfg.Name, cfg.APIKeyEnv)
@@ -103,6 +137,10 @@ func newOpenAIProvider(cfg BackendConfig) (LLMProvider, error) {
 	if baseURL == "" {
 		baseURL = "https://api.openai.com/v1"
 	}
+	client, err := providerHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
 
 	return &openAIProvider{
 		name:         cfg.Name,
@@ -112,7 +150,7 @@ func newOpenAIProvider(cfg BackendConfig) (LLMProvider, error) {
 		systemPrompt: cfg.SystemPrompt,
 		maxTokens:    cfg.MaxTokens,
 		temperature:  cfg.Temperature,
-		httpClient:   &http.Client{Timeout: 60 * time.Second},
+		httpClient:   client,
 	}, nil
 }
 
@@ -148,7 +186,7 @@ func (p *openAIProvider) Complete(ctx context.Context, req LLMRequest) (string,
 
 	resp, err := p.httpClient.Do(httpReq)
 	if err != nil {
-		return "", fmt.Errorf("openai request failed: %w", err)
+		return "", requestError("openai request failed", err)
 	}
 	defer resp.Body.Close()
 
@@ -157,7 +195,7 @@ func (p *openAIProvider) Complete(ctx context.Context, req LLMRequest) (string,
 		return "", fmt.Errorf("read openai response: %w", err)
 	}
 	if resp.StatusCode >= 300 {
-		return "", fmt.Errorf("openai status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+		return "", statusError("openai status", resp.StatusCode, respBody)
 	}
 
 	var parsed struct {
@@ -194,6 +232,10 @@ func newOllamaProvider(cfg BackendConfig) (LLMProvider, error) {
 	if baseURL == "" {
 		baseURL = "http://ollama:11434"
 	}
+	client, err := providerHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
 	return &ollamaProvider{
 		name:         cfg.Name,
 		model:        cfg.Model,
@@ -201,7 +243,7 @@ func newOllamaProvider(cfg BackendConfig) (LLMProvider, error) {
 		systemPrompt: cfg.SystemPrompt,
 		maxTokens:    cfg.MaxTokens,
 		temperature:  cfg.Temperature,
-		httpClient:   &http.Client{Timeout: 60 * time.Second},
+		httpClient:   client,
 	}, nil
 }
 
@@ -239,7 +281,7 @@ func (p *ollamaProvider) Complete(ctx context.Context, req LLMRequest) (string,
 
 	resp, err := p.httpClient.Do(httpReq)
 	if err != nil {
-		return "", fmt.Errorf("ollama request failed: %w", err)
+		return "", requestError("ollama request failed", err)
 	}
 	defer resp.Body.Close()
 
@@ -248,7 +290,7 @@ func (p *ollamaProvider) Complete(ctx context.Context, req LLMRequest) (string,
 		return "", fmt.Errorf("read ollama response: %w", err)
 	}
 	if resp.StatusCode >= 300 {
-		return "", fmt.Errorf("ollama status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+		return "", statusError("ollama status", resp.StatusCode, respBody)
 	}
 
 	var parsed struct {
@@ -269,6 +311,7 @@ type bedrockProvider struct {
 	systemPrompt string
 	maxTokens    int
 	temperature  float64
+	httpClient   *http.Client
 }
 
 func newBedrockProvider(cfg BackendConfig) (LLMProvider, error) {
@@ -277,11 +320,15 @@ func newBedrockProvider(cfg BackendConfig) (LLMProvider, error) {
 	}
 	region := cfg.Region
 	if region == "" {
-		region = strings.TrimSpace(os.Getenv("AWS_REGION"))
+		region = config.String("AWS_REGION", "")
 	}
 	if region == "" {
 		return nil, fmt.Errorf("bedrock backend %q is missing region", cfg.Name)
 	}
+	client, err := providerHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
 	return &bedrockProvider{
 		name:         cfg.Name,
 		model:        cfg.Model,
@@ -289,6 +336,7 @@ func newBedrockProvider(cfg BackendConfig) (LLMProvider, error) {
 		systemPrompt: cfg.SystemPrompt,
 		maxTokens:    cfg.MaxTokens,
 		temperature:  cfg.Temperature,
+		httpClient:   client,
 	}, nil
 }
 
@@ -300,7 +348,7 @@ func (p *bedrockProvider) PrepareRequest(req LLMRequest) LLMRequest {
 }
 
 func (p *bedrockProvider) Complete(ctx context.Context, req LLMRequest) (string, error) {
-	cfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(p.region))
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(p.region), awsconfig.WithHTTPClient(p.httpClient))
 	if err != nil {
 		return "", fmt.Errorf("load AWS config: %w", err)
 	}
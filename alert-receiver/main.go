@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -13,6 +14,13 @@ import (
 	"sync"
 	"time"
 
+	"edge-monitor-app/internal/buildinfo"
+	"edge-monitor-app/internal/config"
+	"edge-monitor-app/internal/lifecycle"
+	"edge-monitor-app/internal/logging"
+	"edge-monitor-app/internal/otlp"
+	"edge-monitor-app/internal/profiling"
+
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
@@ -22,7 +30,10 @@ type analysisJob struct {
 	Payload    GrafanaWebhookPayload
 }
 
+// analysisRecord is one stored analysis. Its JSON is versioned by
+// SchemaVersion; see analysisSchemaVersion for the compatibility rules.
 type analysisRecord struct {
+	SchemaVersion  int               `json:"schema_version"`
 	ID             string            `json:"id"`
 	ReceivedAt     time.Time         `json:"received_at"`
 	CompletedAt    time.Time         `json:"completed_at"`
@@ -33,8 +44,41 @@ type analysisRecord struct {
 	CommonAnnots   map[string]string `json:"common_annotations"`
 	AlertSummaries []alertSummary    `json:"alerts"`
 	Metrics        []MetricSnapshot  `json:"metrics,omitempty"`
-	Providers      []ProviderResult  `json:"providers,omitempty"`
-	Error          string            `json:"error,omitempty"`
+	External       []ExternalContext `json:"external_context,omitempty"`
+	// ProbeEvents is the probe services' state transitions during the
+	// incident, from PROBE_EVENTS_JSON.
+	ProbeEvents []ProbeEventLog  `json:"probe_events,omitempty"`
+	Providers   []ProviderResult `json:"providers,omitempty"`
+	Error       string           `json:"error,omitempty"`
+	// Heuristic is the verdict of the first HEURISTICS_JSON rule that held
+	// for the metrics, given to the providers as a hypothesis.
+	Heuristic *HeuristicVerdict `json:"heuristic,omitempty"`
+	// Preliminary marks the copy of a record sent to the notification
+	// sinks with only the heuristic verdict, before the providers answer.
+	// Stored records never have it.
+	Preliminary bool `json:"preliminary,omitempty"`
+	// Policy is the analysis policy the alerts matched, and LLMSkipped is
+	// set when it called no provider.
+	Policy     string `json:"policy,omitempty"`
+	LLMSkipped bool   `json:"llm_skipped,omitempty"`
+	// Maintenance names the policy maintenance window the alerts arrived
+	// in; such analyses are not sent to the notification sinks.
+	Maintenance string `json:"maintenance,omitempty"`
+	// Degraded is set when the alerts arrived at a full queue and were
+	// stored without enrichment (QUEUE_ADMISSION=degrade).
+	Degraded bool `json:"degraded,omitempty"`
+	// Feedback is people's ratings of the analysis.
+	Feedback []AnalysisFeedback `json:"feedback,omitempty"`
+	// Compacted is set once retention has dropped the raw responses,
+	// prompts and metric series.
+	Compacted bool `json:"compacted,omitempty"`
+	// Resolution is set once the alert group's incident has resolved, and
+	// PostMortem when this is the resolved notification's analysis and
+	// the providers wrote a post-mortem (POSTMORTEM_ANALYSIS).
+	Resolution *IncidentResolution `json:"resolution,omitempty"`
+	PostMortem bool                `json:"post_mortem,omitempty"`
+	// Hooks names the HOOKS_JSON hooks that held for the analysis.
+	Hooks []string `json:"hooks,omitempty"`
 }
 
 type alertSummary struct {
@@ -45,43 +89,36 @@ type alertSummary struct {
 	EndsAt      time.Time         `json:"ends_at"`
 }
 
-type analysisStore struct {
-	max   int
-	items []analysisRecord
-	mu    sync.RWMutex
-}
-
-func newAnalysisStore(max int) *analysisStore {
-	return &analysisStore{max: max}
-}
-
-func (s *analysisStore) add(record analysisRecord) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.items = append([]analysisRecord{record}, s.items...)
-	if len(s.items) > s.max {
-		s.items = s.items[:s.max]
-	}
-}
-
-func (s *analysisStore) list() []analysisRecord {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	out := make([]analysisRecord, len(s.items))
-	copy(out, s.items)
-	return out
-}
-
 type server struct {
 	cfg       Config
 	prom      *PrometheusClient
 	providers []LLMProvider
-	queue     chan analysisJob
-	store     *analysisStore
+	fetchers  []*cachedFetcher
+	notifiers []Notifier
+	queue     jobQueue
+	store     analysisStorage
+	incidents *incidentTracker
+	// idempotency answers retried webhook deliveries with their job.
+	idempotency idempotencyClaimer
+
+	proxyAllow   queryAllowList
+	proxyLimiter *rateLimiter
 }
 
 func main() {
-	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+	opts := config.RegisterFlags(flag.CommandLine)
+	selftest := flag.Bool("selftest", false, "check the configuration, Prometheus and every LLM backend, print a JSON report and exit")
+	flag.Parse()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(opts.LogOutput(), nil)))
+	if err := config.Load(opts.File); err != nil {
+		slog.Error("failed to load config file", "error", err)
+		os.Exit(1)
+	}
+	logging.Setup(opts.LogOutput())
+
+	if *selftest {
+		os.Exit(runSelftest(context.Background(), os.Stdout))
+	}
 
 	cfg, err := loadConfig()
 	if err != nil {
@@ -90,41 +127,120 @@ func main() {
 	}
 
 	registerMetrics()
+	buildinfo.Register("alert-receiver", map[string]bool{
+		"llm":              !cfg.SkipLLM,
+		"heuristics":       len(cfg.Heuristics) > 0,
+		"experiments":      len(cfg.Experiments) > 0,
+		"notify_sinks":     len(cfg.NotifySinks) > 0,
+		"external_context": len(cfg.ExternalSources) > 0,
+		"probe_events":     len(cfg.ProbeEventSources) > 0,
+		"redis_queue":      cfg.QueueBackend == queueRedis,
+		"webhook_auth":     cfg.WebhookToken != "",
+		"hooks":            len(cfg.Hooks) > 0,
+	})
+	app := lifecycle.New("alert-receiver")
+
+	ex, err := otlp.New("alert-receiver")
+	if err != nil {
+		slog.Error("failed to configure otlp export", "error", err)
+		os.Exit(1)
+	}
+	if ex != nil {
+		app.Go("otlp export", ex.Run)
+	}
 
 	providers, err := buildProviders(cfg.Backends)
 	if err != nil {
 		slog.Error("failed to build providers", "error", err)
 		os.Exit(1)
 	}
+	fetchers, err := buildFetchers(cfg.ExternalSources, cfg.ExternalContextMatch, cfg.ExternalContextTimeout)
+	if err != nil {
+		slog.Error("failed to build external context sources", "error", err)
+		os.Exit(1)
+	}
+	notifiers, err := buildNotifiers(cfg.NotifySinks, cfg.NotifyTimeout, cfg.SMTP, cfg.DisplayLocation)
+	if err != nil {
+		slog.Error("failed to build notification sinks", "error", err)
+		os.Exit(1)
+	}
+	if err := config.Err(); err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	if opts.Print {
+		config.Print(os.Stdout)
+		return
+	}
+
+	redisClient, err := openRedis(cfg)
+	if err != nil {
+		slog.Error("failed to connect to redis", "error", err)
+		os.Exit(1)
+	}
+	store, idempotency, err := openStorage(cfg, redisClient)
+	if err != nil {
+		slog.Error("failed to open analysis store", "backend", cfg.AnalysisStore, "error", err)
+		os.Exit(1)
+	}
+	var queue jobQueue = newChanQueue(cfg.JobQueueSize)
+	if cfg.QueueBackend == queueRedis {
+		rq := newRedisQueue(redisClient, cfg.Redis.KeyPrefix, cfg.JobQueueSize)
+		if err := rq.start(context.Background()); err != nil {
+			slog.Error("failed to register with the redis queue", "error", err)
+			os.Exit(1)
+		}
+		app.Go("redis queue heartbeat", rq.runHeartbeat)
+		queue = rq
+	}
 
-	promClient := NewPrometheusClient(cfg.PrometheusURL, cfg.PrometheusTimeout)
+	promClient := NewPrometheusClient(cfg.PrometheusURL, cfg.PrometheusTimeout, cfg.PrometheusAuth)
 	srv := &server{
 		cfg:       cfg,
 		prom:      promClient,
 		providers: providers,
-		queue:     make(chan analysisJob, cfg.JobQueueSize),
-		store:     newAnalysisStore(cfg.MaxStoredAnalyses),
+		fetchers:  fetchers,
+		notifiers: notifiers,
+		queue:     queue,
+		store:     store,
+		incidents: newIncidentTracker(store),
+
+		idempotency: idempotency,
+
+		proxyAllow:   cfg.ProxyAllow,
+		proxyLimiter: newRateLimiter(cfg.ProxyRateLimit, cfg.ProxyBurst),
 	}
 
 	for i := 0; i < cfg.WorkerCount; i++ {
-		go srv.worker(i + 1)
+		id := i + 1
+		app.Go(fmt.Sprintf("worker %d", id), func(ctx context.Context) error {
+			srv.worker(ctx, id)
+			return nil
+		})
 	}
-
-	httpServer := &http.Server{
-		Addr:              fmt.Sprintf(":%d", cfg.Port),
-		Handler:           srv.routes(),
-		ReadHeaderTimeout: 5 * time.Second,
+	app.Go("analysis retention", store.runRetention)
+	for _, n := range notifiers {
+		if d, ok := n.(digester); ok {
+			app.Go("digest "+n.Name(), d.runDigest)
+		}
+	}
+	app.OnShutdown(srv.dropQueued)
+	if ex != nil {
+		app.OnShutdown(ex.Flush)
 	}
+	app.Serve(fmt.Sprintf(":%d", cfg.Port), srv.routes())
 
 	slog.Info("starting alert-receiver",
 		"port", cfg.Port,
 		"prometheus_url", cfg.PrometheusURL,
 		"backends", providerNames(providers),
 		"workers", cfg.WorkerCount,
+		"skip_llm", cfg.SkipLLM,
+		"analysis_store", cfg.AnalysisStore,
+		"queue_backend", cfg.QueueBackend,
 	)
 
-	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		slog.Error("server failed", "error", err)
+	if err := app.Run(); err != nil {
 		os.Exit(1)
 	}
 }
@@ -134,42 +250,106 @@ func (s *server) routes() http.Handler {
 	mux.Handle("/metrics", promhttp.Handler())
 	mux.HandleFunc("/healthz", s.handleHealthz)
 	mux.HandleFunc("/readyz", s.handleHealthz)
+	logging.Register(mux)
+	profiling.Register(mux)
 	mux.HandleFunc("/alerts/grafana", s.handleGrafanaWebhook)
+	mux.HandleFunc("/alerts/oncall", s.handleOnCallWebhook)
+	mux.HandleFunc("/alerts/incident", s.handleIncidentWebhook)
+	mux.HandleFunc("/events/cloudevents", s.handleCloudEvent)
 	mux.HandleFunc("/analyses/latest", s.handleLatestAnalyses)
+	mux.HandleFunc("/analyses/feedback", s.handleFeedback)
+	mux.HandleFunc("GET /analyses/search", s.handleSearch)
+	mux.HandleFunc("GET /analyses/{id}/export", s.handleExport)
+	mux.HandleFunc("/proxy/query", s.handleProxyQuery)
+	mux.HandleFunc("/grafana/dashboard", s.handleDashboard)
+	mux.HandleFunc("/prometheus/rules", s.handleRules)
 	return mux
 }
 
 func (s *server) handleHealthz(w http.ResponseWriter, _ *http.Request) {
+	info := buildinfo.Get()
 	writeJSON(w, http.StatusOK, map[string]any{
 		"status":          "ok",
+		"version":         info.Version,
+		"commit":          info.Commit,
 		"providers":       providerNames(s.providers),
 		"prometheus_url":  s.cfg.PrometheusURL,
-		"queue_depth":     len(s.queue),
+		"queue_depth":     s.queue.depth(),
 		"worker_count":    s.cfg.WorkerCount,
-		"stored_analyses": len(s.store.list()),
+		"stored_analyses": s.store.count(),
 	})
 }
 
 func (s *server) handleLatestAnalyses(w http.ResponseWriter, _ *http.Request) {
+	items := s.store.list()
+	for i := range items {
+		items[i] = localizeRecord(items[i], s.cfg.DisplayLocation)
+	}
 	writeJSON(w, http.StatusOK, map[string]any{
-		"items": s.store.list(),
+		"items": items,
 	})
 }
 
 func (s *server) handleGrafanaWebhook(w http.ResponseWriter, r *http.Request) {
+	var payload GrafanaWebhookPayload
+	if !s.decodeWebhook(w, r, &payload) {
+		return
+	}
+	s.enqueue(w, r, payload)
+}
+
+// acceptWebhook checks a webhook request's method and, when WEBHOOK_TOKEN
+// is set, its bearer token, and bounds its body to WEBHOOK_MAX_BYTES. It
+// answers the request itself when it is not accepted.
+func (s *server) acceptWebhook(w http.ResponseWriter, r *http.Request) bool {
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	if s.cfg.WebhookToken != "" && !bearerAuthorized(r, s.cfg.WebhookToken) {
+		webhookRejectedTotal.WithLabelValues(r.URL.Path, "unauthorized").Inc()
+		slog.Warn("unauthorized webhook", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="webhook"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, s.cfg.WebhookMaxBytes)
+	return true
+}
+
+// rejectBody answers a webhook whose body could not be read or parsed:
+// 413 when it exceeded WEBHOOK_MAX_BYTES, 400 with msg otherwise.
+func rejectBody(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		webhookRejectedTotal.WithLabelValues(r.URL.Path, "oversized").Inc()
+		slog.Warn("oversized webhook", "path", r.URL.Path, "limit_bytes", tooLarge.Limit, "remote_addr", r.RemoteAddr)
+		http.Error(w, fmt.Sprintf("body larger than %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
 		return
 	}
+	webhookRejectedTotal.WithLabelValues(r.URL.Path, "malformed").Inc()
+	slog.Warn("malformed webhook", "path", r.URL.Path, "error", err, "remote_addr", r.RemoteAddr)
+	http.Error(w, msg, http.StatusBadRequest)
+}
+
+// decodeWebhook reads a POSTed JSON body into v, answering the request
+// itself when it cannot.
+func (s *server) decodeWebhook(w http.ResponseWriter, r *http.Request, v any) bool {
+	if !s.acceptWebhook(w, r) {
+		return false
+	}
 
 	defer r.Body.Close()
 
-	var payload GrafanaWebhookPayload
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		http.Error(w, "invalid json body", http.StatusBadRequest)
-		return
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		rejectBody(w, r, err, "invalid json body")
+		return false
 	}
+	return true
+}
 
+// enqueue queues payload for analysis and answers the webhook.
+func (s *server) enqueue(w http.ResponseWriter, r *http.Request, payload GrafanaWebhookPayload) {
 	alertsReceivedTotal.WithLabelValues(payload.Status).Inc()
 
 	job := analysisJob{
@@ -178,37 +358,48 @@ func (s *server) handleGrafanaWebhook(w http.ResponseWriter, r *http.Request) {
 		Payload:    payload,
 	}
 
-	select {
-	case s.queue <- job:
-		queueDepthGauge.Inc()
-		slog.Info("alert queued",
-			"job_id", job.ID,
-			"receiver", payload.Receiver,
-			"status", payload.Status,
-			"alerts", len(payload.Alerts),
-		)
-		writeJSON(w, http.StatusAccepted, map[string]any{
-			"job_id":   job.ID,
-			"status":   "queued",
-			"alerts":   len(payload.Alerts),
-			"backends": providerNames(s.providers),
+	key, source := idempotencyKey(r, payload)
+	if existing, dup := s.idempotency.claim(key, job.ID, job.ReceivedAt); dup {
+		duplicateWebhooksTotal.WithLabelValues(source).Inc()
+		slog.Info("duplicate webhook delivery", "job_id", existing, "key_source", source, "group_key", payload.GroupKey)
+		writeJSON(w, http.StatusOK, map[string]any{
+			"job_id": existing,
+			"status": "duplicate",
 		})
-	default:
-		jobResultsTotal.WithLabelValues("queue_full").Inc()
-		http.Error(w, "queue full", http.StatusServiceUnavailable)
+		return
+	}
+	if !s.admit(w, r, job) {
+		s.idempotency.release(key, job.ID)
 	}
 }
 
-func (s *server) worker(id int) {
-	for job := range s.queue {
-		queueDepthGauge.Dec()
+// worker processes queued jobs until ctx is cancelled. A job already being
+// analysed is finished first.
+func (s *server) worker(ctx context.Context, id int) {
+	for {
+		job, done, ok := s.queue.pop(ctx)
+		if !ok {
+			return
+		}
 		s.processJob(id, job)
+		done()
+	}
+}
+
+// dropQueued counts the jobs still queued in memory at shutdown. They are
+// lost: Grafana does not resend a notification it saw accepted. A Redis
+// queue keeps them for the next replica.
+func (s *server) dropQueued(context.Context) {
+	if dropped := s.queue.discard(); dropped > 0 {
+		jobResultsTotal.WithLabelValues("dropped").Add(float64(dropped))
+		slog.Warn("queued alert jobs dropped at shutdown", "jobs", dropped)
 	}
 }
 
 func (s *server) processJob(workerID int, job analysisJob) {
 	start := time.Now()
 	record := analysisRecord{
+		SchemaVersion:  analysisSchemaVersion,
 		ID:             job.ID,
 		ReceivedAt:     job.ReceivedAt,
 		AlertStatus:    job.Payload.Status,
@@ -218,34 +409,80 @@ func (s *server) processJob(workerID int, job analysisJob) {
 		CommonAnnots:   job.Payload.CommonAnnotations,
 		AlertSummaries: summarizeAlerts(job.Payload.Alerts),
 	}
+	switch job.Payload.Status {
+	case "firing":
+		s.incidents.firing(job)
+	case "resolved":
+		if res, ok := s.incidents.resolve(job); ok {
+			record.Resolution = &res
+		}
+	}
+	policy := s.policyFor(job.Payload)
+	record.Policy = policy.Name
+	record.Maintenance = policy.inMaintenance(job.ReceivedAt)
+	policyJobsTotal.WithLabelValues(policy.Name).Inc()
 
 	slog.Info("processing alert job",
 		"job_id", job.ID,
 		"worker", workerID,
 		"alerts", len(job.Payload.Alerts),
+		"policy", policy.Name,
+		"maintenance", record.Maintenance,
 	)
 
-	metrics, err := s.collectMetrics(job)
+	metrics, err := s.collectMetrics(job, s.queriesFor(policy))
 	if err != nil {
 		record.Error = err.Error()
 		slog.Warn("metric collection failed", "job_id", job.ID, "error", err)
 	}
 	record.Metrics = metrics
-
-	if len(s.providers) == 0 {
+	record.External = s.collectExternalContext(job)
+	record.ProbeEvents = s.collectProbeEvents(job)
+	record.Heuristic = evaluateHeuristics(s.cfg.Heuristics, metrics)
+
+	providers, arms := s.assignArms(job.ID, s.providersFor(policy))
+	skipLLM := policy.skipsLLM(s.cfg.SkipLLM)
+	record.PostMortem = s.cfg.PostMortem && record.Resolution != nil && !skipLLM && len(providers) > 0
+	if record.Heuristic != nil && !record.PostMortem && !skipLLM && len(providers) > 0 && record.Maintenance == "" && policy.notifies() {
+		// Tell people what the rules already see; the analysis follows.
+		preliminary := record
+		preliminary.Preliminary = true
+		go s.notify(preliminary)
+	}
+	switch {
+	case skipLLM:
+		record.LLMSkipped = true
+	case len(providers) == 0:
 		record.Providers = []ProviderResult{{
 			Provider: "none",
 			Type:     "none",
 			Error:    "no LLM backends configured",
 		}}
-	} else {
-		record.Providers = s.runProviders(job, metrics)
+	default:
+		record.Providers = s.runProviders(job, providers, arms, record)
 	}
 
 	record.CompletedAt = time.Now().UTC()
+	hooks := evaluateHooks(s.cfg.Hooks, record)
+	record.Hooks = hooks.matched
 	jobDurationSeconds.Observe(time.Since(start).Seconds())
 	jobResultsTotal.WithLabelValues("processed").Inc()
-	s.store.add(record)
+	s.incidents.add(record)
+	notified := false
+	switch {
+	case hooks.suppress:
+		slog.Info("notifications suppressed by hook", "job_id", job.ID, "hooks", hooks.matched)
+	case record.Maintenance != "":
+		for _, n := range s.notifiers {
+			notificationsTotal.WithLabelValues(n.Name(), "maintenance").Inc()
+		}
+	case policy.notifies():
+		s.notify(record)
+		notified = true
+	}
+	if len(hooks.sinks) > 0 && !notified {
+		s.notifySinks(record, hooks.sinks)
+	}
 
 	slog.Info("alert job completed",
 		"job_id", job.ID,
@@ -254,7 +491,7 @@ func (s *server) processJob(workerID int, job analysisJob) {
 	)
 }
 
-func (s *server) collectMetrics(job analysisJob) ([]MetricSnapshot, error) {
+func (s *server) collectMetrics(job analysisJob, queries []MetricQuery) ([]MetricSnapshot, error) {
 	if strings.TrimSpace(s.cfg.PrometheusURL) == "" {
 		return nil, nil
 	}
@@ -268,28 +505,64 @@ func (s *server) collectMetrics(job analysisJob) ([]MetricSnapshot, error) {
 		}
 	}
 
-	snapshots := make([]MetricSnapshot, 0, len(s.cfg.MetricQueries))
-	for _, query := range s.cfg.MetricQueries {
-		snapshot, err := s.prom.InstantQuery(context.Background(), query, queryTime)
-		if err != nil {
-			prometheusQueriesTotal.WithLabelValues(query.Name, "error").Inc()
-			snapshots = append(snapshots, MetricSnapshot{
-				Name:        query.Name,
-				Description: query.Description,
-				Query:       query.Query,
-				Error:       err.Error(),
-			})
+	var instances []string
+	if s.cfg.MetricShardLabel != "" {
+		instances = alertInstances(job.Payload, s.cfg.MetricShardLabel)
+		if len(instances) > s.cfg.MetricShardMax {
+			slog.Info("too many instances to shard metric queries", "job_id", job.ID, "instances", len(instances), "max", s.cfg.MetricShardMax)
+			instances = nil
+		}
+	}
+
+	snapshots := make([]MetricSnapshot, 0, len(queries))
+	for _, query := range queries {
+		_, scopable := scopeQuery(query.Query, s.cfg.MetricShardLabel, "")
+		if len(instances) < 2 || query.Scope == scopeGlobal || !scopable {
+			snapshots = append(snapshots, s.queryMetric(query, queryTime, ""))
 			continue
 		}
-		prometheusQueriesTotal.WithLabelValues(query.Name, "success").Inc()
-		snapshots = append(snapshots, snapshot)
+		for _, instance := range instances {
+			scoped := query
+			scoped.Query, _ = scopeQuery(query.Query, s.cfg.MetricShardLabel, instance)
+			snapshots = append(snapshots, s.queryMetric(scoped, queryTime, instance))
+		}
 	}
 
 	return snapshots, nil
 }
 
-func (s *server) runProviders(job analysisJob, metrics []MetricSnapshot) []ProviderResult {
-	request, err := buildLLMRequest(job, metrics, s.cfg.PrometheusLookback)
+// queryMetric runs one enrichment query, recording a failure in the
+// snapshot. instance is set on queries scoped to one instance.
+func (s *server) queryMetric(query MetricQuery, queryTime time.Time, instance string) MetricSnapshot {
+	snapshot, err := s.prom.InstantQuery(context.Background(), query, queryTime)
+	if err != nil {
+		prometheusQueriesTotal.WithLabelValues(query.Name, "error").Inc()
+		snapshot = MetricSnapshot{
+			Name:        query.Name,
+			Description: query.Description,
+			Query:       query.Query,
+			Unit:        queryUnit(query),
+			Error:       err.Error(),
+		}
+	} else {
+		prometheusQueriesTotal.WithLabelValues(query.Name, "success").Inc()
+	}
+	snapshot.Instance = instance
+	return snapshot
+}
+
+func (s *server) runProviders(job analysisJob, providers []LLMProvider, arms map[string]experimentArm, record analysisRecord) []ProviderResult {
+	var (
+		request LLMRequest
+		err     error
+	)
+	if record.PostMortem {
+		request, err = buildPostMortemRequest(job, record, s.firingAnalyses(record.Resolution), s.cfg.DisplayLocation, s.cfg.PromptMetricsFormat)
+		// Experiments compare analyses; a post-mortem uses its own prompt.
+		arms = nil
+	} else {
+		request, err = buildLLMRequest(job, record, s.cfg.PrometheusLookback, s.cfg.DisplayLocation, s.cfg.PromptMetricsFormat, s.cfg.MetricShardLabel)
+	}
 	if err != nil {
 		return []ProviderResult{{
 			Provider: "prompt-builder",
@@ -298,9 +571,9 @@ func (s *server) runProviders(job analysisJob, metrics []MetricSnapshot) []Provi
 		}}
 	}
 
-	results := make([]ProviderResult, len(s.providers))
+	results := make([]ProviderResult, len(providers))
 	var wg sync.WaitGroup
-	for i, provider := range s.providers {
+	for i, provider := range providers {
 		wg.Add(1)
 		go func(idx int, provider LLMProvider) {
 			defer wg.Done()
@@ -308,7 +581,16 @@ func (s *server) runProviders(job analysisJob, metrics []MetricSnapshot) []Provi
 			ctx, cancel := context.WithTimeout(context.Background(), s.cfg.LLMTimeout)
 			defer cancel()
 
-			response, err := provider.Complete(ctx, provider.PrepareRequest(request))
+			prepared := provider.PrepareRequest(request)
+			if record.PostMortem {
+				// A backend's system_prompt is written for analyses.
+				prepared.SystemPrompt = request.SystemPrompt
+			}
+			arm, inExperiment := arms[provider.Name()]
+			if arm.systemPrompt != "" {
+				prepared.SystemPrompt = arm.systemPrompt
+			}
+			response, err := provider.Complete(ctx, prepared)
 			durationMS := time.Since(start).Milliseconds()
 
 			result := ProviderResult{
@@ -317,20 +599,35 @@ func (s *server) runProviders(job analysisJob, metrics []MetricSnapshot) []Provi
 				Model:      provider.Model(),
 				DurationMS: durationMS,
 			}
+			if inExperiment {
+				result.Experiment, result.Arm = arm.experiment, arm.arm
+				defer func() { observeExperiment(results[idx]) }()
+			}
+			if s.cfg.StorePrompts {
+				result.Prompt = storePrompt(prepared, s.cfg.PromptCompressBytes)
+			}
 
 			if err != nil {
-				providerRequestsTotal.WithLabelValues(provider.Name(), "error").Inc()
 				result.Error = err.Error()
+				result.ErrorClass = classifyProviderError(err)
+				var perr *providerError
+				if errors.As(err, &perr) {
+					result.ErrorBody = perr.Body
+				}
+				providerRequestsTotal.WithLabelValues(provider.Name(), "error", result.ErrorClass).Inc()
+				slog.Warn("provider request failed", "provider", provider.Name(), "error_class", result.ErrorClass, "error", err)
 				results[idx] = result
 				return
 			}
 
-			providerRequestsTotal.WithLabelValues(provider.Name(), "success").Inc()
+			providerRequestsTotal.WithLabelValues(provider.Name(), "success", "").Inc()
 			result.Response = response
 
 			var parsed StructuredAnalysis
 			if err := json.Unmarshal([]byte(response), &parsed); err == nil && parsed.Summary != "" {
 				result.Parsed = &parsed
+				result.IssueCategory = classifyIssue(s.cfg.IssueTaxonomy, parsed.LikelyIssue)
+				observeAnalysis(result)
 			}
 
 			results[idx] = result
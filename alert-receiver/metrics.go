@@ -37,9 +37,9 @@ var (
 	providerRequestsTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "alert_receiver_provider_requests_total",
-			Help: "Total LLM provider requests by provider and result",
+			Help: "Total LLM provider requests by provider, result and error class (auth, rate_limit, timeout, context_length, server, other)",
 		},
-		[]string{"provider", "result"},
+		[]string{"provider", "result", "error_class"},
 	)
 
 	prometheusQueriesTotal = prometheus.NewCounterVec(
@@ -49,6 +49,174 @@ var (
 		},
 		[]string{"query", "result"},
 	)
+
+	externalFetchesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "alert_receiver_external_context_fetches_total",
+			Help: "Total external context (ISP status page or feed) fetches by source and result",
+		},
+		[]string{"source", "result"},
+	)
+
+	analysisRetentionTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "alert_receiver_analysis_retention_total",
+			Help: "Total stored analyses expired or compacted by the retention policy",
+		},
+		[]string{"action"},
+	)
+
+	proxyQueriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "alert_receiver_proxy_queries_total",
+			Help: "Total /proxy/query requests by result",
+		},
+		[]string{"result"},
+	)
+
+	policyJobsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "alert_receiver_policy_jobs_total",
+			Help: "Total alert analysis jobs by matched analysis policy",
+		},
+		[]string{"policy"},
+	)
+
+	analysisConfidence = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "alert_receiver_analysis_confidence",
+			Help:    "Confidence of parsed LLM analyses by provider",
+			Buckets: prometheus.LinearBuckets(0.1, 0.1, 10),
+		},
+		[]string{"provider"},
+	)
+
+	analysisIssuesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "alert_receiver_analysis_issues_total",
+			Help: "Total parsed LLM analyses by likely issue category and provider",
+		},
+		[]string{"category", "provider"},
+	)
+
+	admissionTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "alert_receiver_admission_decisions_total",
+			Help: "Total webhook admission decisions (queued, queued_after_wait, degraded, dropped, rejected)",
+		},
+		[]string{"decision"},
+	)
+
+	probeEventFetchesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "alert_receiver_probe_event_fetches_total",
+			Help: "Total probe /events fetches by source and result",
+		},
+		[]string{"source", "result"},
+	)
+
+	experimentResultsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "alert_receiver_experiment_results_total",
+			Help: "Total provider results of A/B experiments by experiment, arm and outcome (parsed, unparsed, error)",
+		},
+		[]string{"experiment", "arm", "outcome"},
+	)
+
+	experimentDurationSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "alert_receiver_experiment_duration_seconds",
+			Help:    "Provider latency of A/B experiment results by experiment and arm",
+			Buckets: []float64{0.5, 1, 2, 5, 10, 20, 30, 60, 120},
+		},
+		[]string{"experiment", "arm"},
+	)
+
+	experimentFeedbackScore = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "alert_receiver_experiment_feedback_score",
+			Help:    "Feedback scores (1-5) given to A/B experiment results by experiment and arm",
+			Buckets: []float64{1, 2, 3, 4, 5},
+		},
+		[]string{"experiment", "arm"},
+	)
+
+	heuristicVerdictsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "alert_receiver_heuristic_verdicts_total",
+			Help: "Total analyses a heuristic rule reached a verdict for, by rule",
+		},
+		[]string{"rule"},
+	)
+
+	webhookRejectedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "alert_receiver_webhook_rejected_total",
+			Help: "Total webhook requests rejected before admission by path and reason (malformed, oversized, unauthorized)",
+		},
+		[]string{"path", "reason"},
+	)
+
+	hookMatchesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "alert_receiver_hook_matches_total",
+			Help: "Total finished analyses a HOOKS_JSON hook held for, by hook",
+		},
+		[]string{"hook"},
+	)
+
+	duplicateWebhooksTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "alert_receiver_duplicate_webhooks_total",
+			Help: "Total webhook deliveries answered with an existing job, by idempotency key source (header or derived)",
+		},
+		[]string{"key_source"},
+	)
+
+	storeErrorsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "alert_receiver_store_errors_total",
+			Help: "Total failed Redis operations of the shared store and queue (ANALYSIS_STORE, QUEUE_BACKEND) by operation",
+		},
+		[]string{"op"},
+	)
+
+	queueRequeuedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "alert_receiver_queue_requeued_total",
+			Help: "Total Redis queue jobs moved back to the queue from a replica that stopped before finishing them",
+		},
+	)
+
+	notificationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "alert_receiver_notifications_total",
+			Help: "Total analysis notifications by sink and result",
+		},
+		[]string{"sink", "result"},
+	)
+
+	openIncidents = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "alert_receiver_open_incidents",
+			Help: "Alert groups that have fired and not resolved yet",
+		},
+	)
+
+	incidentsResolvedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "alert_receiver_incidents_resolved_total",
+			Help: "Incidents closed by a resolved notification",
+		},
+	)
+
+	incidentDurationSeconds = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "alert_receiver_incident_duration_seconds",
+			Help:    "Duration of resolved incidents, from the earliest alert start to the last alert end",
+			Buckets: []float64{60, 300, 900, 1800, 3600, 7200, 14400, 43200, 86400},
+		},
+	)
 )
 
 func registerMetrics() {
@@ -59,5 +227,26 @@ func registerMetrics() {
 		jobDurationSeconds,
 		providerRequestsTotal,
 		prometheusQueriesTotal,
+		externalFetchesTotal,
+		probeEventFetchesTotal,
+		analysisRetentionTotal,
+		proxyQueriesTotal,
+		policyJobsTotal,
+		analysisConfidence,
+		analysisIssuesTotal,
+		admissionTotal,
+		duplicateWebhooksTotal,
+		webhookRejectedTotal,
+		storeErrorsTotal,
+		queueRequeuedTotal,
+		heuristicVerdictsTotal,
+		hookMatchesTotal,
+		experimentResultsTotal,
+		experimentDurationSeconds,
+		experimentFeedbackScore,
+		notificationsTotal,
+		openIncidents,
+		incidentsResolvedTotal,
+		incidentDurationSeconds,
 	)
 }
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Metric units, set per query with "unit" in METRIC_QUERIES_JSON and query
+// packs. They only change how values are shown to the providers.
+var metricUnits = []string{
+	"",      // a plain number
+	"ratio", // 0..1, shown as a percentage
+	"%",     // already a percentage
+	"ms",
+	"s", // shown in ms below one second
+	"bps",
+	"Bps", // bytes per second, shown as bits per second
+	"dBm",
+	"dB",
+	"count",
+	"/s",
+}
+
+// Prompt metric formats, PROMPT_METRICS_FORMAT.
+const (
+	promptMetricsTable = "table"
+	promptMetricsJSON  = "json"
+)
+
+var promptMetricsFormats = []string{promptMetricsTable, promptMetricsJSON}
+
+// validateMetricUnits checks the units of queries.
+func validateMetricUnits(queries []MetricQuery) error {
+	for _, q := range queries {
+		if !slices.Contains(metricUnits, q.Unit) {
+			return fmt.Errorf("metric query %q: unknown unit %q", q.Name, q.Unit)
+		}
+	}
+	return nil
+}
+
+// queryUnit is the query's unit, else one inferred from its name suffix.
+func queryUnit(q MetricQuery) string {
+	if q.Unit != "" {
+		return q.Unit
+	}
+	switch {
+	case strings.HasSuffix(q.Name, "_ms"):
+		return "ms"
+	case strings.HasSuffix(q.Name, "_seconds"):
+		return "s"
+	case strings.HasSuffix(q.Name, "_dbm"):
+		return "dBm"
+	case strings.HasSuffix(q.Name, "_db"):
+		return "dB"
+	case strings.HasSuffix(q.Name, "_bps"):
+		return "bps"
+	case strings.HasSuffix(q.Name, "_ratio"):
+		return "ratio"
+	}
+	return ""
+}
+
+// formatMetricValue renders a raw Prometheus sample value rounded, with its
+// unit: "3.3333333e+01" in ms becomes "33.3 ms".
+func formatMetricValue(raw, unit string) string {
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return raw
+	}
+	switch unit {
+	case "ratio":
+		return roundValue(v*100) + "%"
+	case "%":
+		return roundValue(v) + "%"
+	case "s":
+		if math.Abs(v) < 1 {
+			return roundValue(v*1000) + " ms"
+		}
+		return roundValue(v) + " s"
+	case "Bps":
+		return formatBitRate(v * 8)
+	case "bps":
+		return formatBitRate(v)
+	case "", "count":
+		return roundValue(v)
+	}
+	return roundValue(v) + " " + unit
+}
+
+func formatBitRate(v float64) string {
+	for _, p := range []struct {
+		unit string
+		size float64
+	}{{"Gbps", 1e9}, {"Mbps", 1e6}, {"kbps", 1e3}} {
+		if math.Abs(v) >= p.size {
+			return roundValue(v/p.size) + " " + p.unit
+		}
+	}
+	return roundValue(v) + " bps"
+}
+
+// roundValue keeps about three significant digits, without exponents or
+// trailing zeros.
+func roundValue(v float64) string {
+	decimals := 2
+	switch a := math.Abs(v); {
+	case a >= 100:
+		decimals = 0
+	case a >= 10:
+		decimals = 1
+	case a > 0 && a < 0.01:
+		return strconv.FormatFloat(v, 'g', 3, 64)
+	}
+	s := strconv.FormatFloat(v, 'f', decimals, 64)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	if s == "-0" {
+		s = "0"
+	}
+	return s
+}
+
+// metricTable renders snapshots as one line per series, far shorter than
+// their JSON and with values the models read reliably.
+func metricTable(snapshots []MetricSnapshot) string {
+	var b strings.Builder
+	b.WriteString("metric | labels | value | meaning\n")
+	for _, s := range snapshots {
+		switch {
+		case s.Error != "":
+			fmt.Fprintf(&b, "%s | | query failed: %s | %s\n", s.Name, s.Error, s.Description)
+		case len(s.Series) == 0:
+			fmt.Fprintf(&b, "%s | | no data | %s\n", s.Name, s.Description)
+		}
+		for i, series := range s.Series {
+			description := s.Description
+			if i > 0 {
+				description = ""
+			}
+			fmt.Fprintf(&b, "%s | %s | %s | %s\n", s.Name, seriesLabels(series.Labels), formatMetricValue(series.Value, s.Unit), description)
+		}
+	}
+	return b.String()
+}
+
+// seriesLabels is a series' labels as k=v pairs, leaving out the metric
+// name and job, which the query already fixes.
+func seriesLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		if k == "__name__" || k == "job" {
+			continue
+		}
+		pairs = append(pairs, k+"="+v)
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, ",")
+}
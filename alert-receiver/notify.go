@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// NotifySinkConfig is one entry of NOTIFY_SINKS_JSON.
+type NotifySinkConfig struct {
+	Name string `json:"name"`
+	// Type is webhook (the analysis record as JSON), slack (an incoming
+	// webhook message), discord (an embed colored by severity) or teams
+	// (an Adaptive Card for a Teams workflow webhook).
+	Type string `json:"type"`
+	URL  string `json:"url"`
+
+	// Email sinks mail through SMTP_HOST to To. Analyses whose severity
+	// is in Immediate (default critical) are mailed as they finish, and
+	// all of them go into a digest sent daily at DigestAt, a local
+	// HH:MM (default 08:00) or "off".
+	To        []string `json:"to,omitempty"`
+	Immediate []string `json:"immediate,omitempty"`
+	DigestAt  string   `json:"digest_at,omitempty"`
+}
+
+// Notifier delivers a finished analysis somewhere people will see it.
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, record analysisRecord) error
+}
+
+func buildNotifiers(sinks []NotifySinkConfig, timeout time.Duration, smtpCfg smtpConfig, loc *time.Location) ([]Notifier, error) {
+	client := &http.Client{Timeout: timeout}
+	notifiers := make([]Notifier, 0, len(sinks))
+	for _, sink := range sinks {
+		if sink.Name == "" {
+			sink.Name = sink.Type
+		}
+		if sink.Type == "email" {
+			n, err := newEmailNotifier(sink, smtpCfg, timeout, loc)
+			if err != nil {
+				return nil, err
+			}
+			notifiers = append(notifiers, n)
+			continue
+		}
+		if !strings.HasPrefix(sink.URL, "http://") && !strings.HasPrefix(sink.URL, "https://") {
+			return nil, fmt.Errorf("notify sink %q: url must be http(s)", sink.Name)
+		}
+		switch sink.Type {
+		case "webhook":
+			notifiers = append(notifiers, &webhookNotifier{name: sink.Name, url: sink.URL, client: client})
+		case "slack":
+			notifiers = append(notifiers, &slackNotifier{name: sink.Name, url: sink.URL, client: client})
+		case "discord":
+			notifiers = append(notifiers, &discordNotifier{name: sink.Name, url: sink.URL, client: client})
+		case "teams":
+			notifiers = append(notifiers, &teamsNotifier{name: sink.Name, url: sink.URL, client: client})
+		default:
+			return nil, fmt.Errorf("notify sink %q: unsupported type %q (want webhook, slack, discord, teams or email)", sink.Name, sink.Type)
+		}
+	}
+	return notifiers, nil
+}
+
+func parseNotifySinks(raw string) ([]NotifySinkConfig, error) {
+	var sinks []NotifySinkConfig
+	if err := json.Unmarshal([]byte(raw), &sinks); err != nil {
+		return nil, fmt.Errorf("parse NOTIFY_SINKS_JSON: %w", err)
+	}
+	return sinks, nil
+}
+
+// notify sends the record to every sink. A failing sink is logged and
+// counted; it does not affect the others or the stored record.
+func (s *server) notify(record analysisRecord) {
+	s.notifyVia(record, s.notifiers)
+}
+
+// notifySinks sends the record to the named sinks only, for hooks.
+func (s *server) notifySinks(record analysisRecord, names []string) {
+	var notifiers []Notifier
+	for _, n := range s.notifiers {
+		if slices.Contains(names, n.Name()) {
+			notifiers = append(notifiers, n)
+		}
+	}
+	s.notifyVia(record, notifiers)
+}
+
+func (s *server) notifyVia(record analysisRecord, notifiers []Notifier) {
+	record = localizeRecord(record, s.cfg.DisplayLocation)
+	for _, n := range notifiers {
+		ctx, cancel := context.WithTimeout(context.Background(), s.cfg.NotifyTimeout)
+		err := n.Notify(ctx, record)
+		cancel()
+		if err != nil {
+			notificationsTotal.WithLabelValues(n.Name(), "error").Inc()
+			slog.Warn("notification failed", "sink", n.Name(), "job_id", record.ID, "error", err)
+			continue
+		}
+		notificationsTotal.WithLabelValues(n.Name(), "success").Inc()
+	}
+}
+
+// postJSON posts payload to url and fails on a non-2xx response.
+func postJSON(ctx context.Context, client *http.Client, url string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// webhookNotifier posts the whole analysis record.
+type webhookNotifier struct {
+	name   string
+	url    string
+	client *http.Client
+}
+
+func (n *webhookNotifier) Name() string { return n.name }
+
+func (n *webhookNotifier) Notify(ctx context.Context, record analysisRecord) error {
+	return postJSON(ctx, n.client, n.url, record)
+}
+
+// slackNotifier posts a short text summary to a Slack incoming webhook.
+type slackNotifier struct {
+	name   string
+	url    string
+	client *http.Client
+}
+
+func (n *slackNotifier) Name() string { return n.name }
+
+func (n *slackNotifier) Notify(ctx context.Context, record analysisRecord) error {
+	return postJSON(ctx, n.client, n.url, map[string]string{"text": notificationText(record)})
+}
+
+// notificationText is a plain-text summary of an analysis: the alert, then
+// the first parsed provider analysis.
+func notificationText(record analysisRecord) string {
+	var b strings.Builder
+	b.WriteString(recordHeading(record))
+	if h := record.Heuristic; h != nil {
+		fmt.Fprintf(&b, "\nRule verdict: %s (%s)", h.Verdict, h.Rule)
+	}
+	if p := primaryAnalysis(record); p != nil {
+		fmt.Fprintf(&b, "\n%s", p.Parsed.Summary)
+		if p.Parsed.LikelyIssue != "" {
+			fmt.Fprintf(&b, "\nLikely issue: %s (confidence %.0f%%, %s)", p.Parsed.LikelyIssue, p.Parsed.Confidence*100, p.Provider)
+		}
+		if len(p.Parsed.PotentialFix) > 0 {
+			fmt.Fprintf(&b, "\nTry: %s", p.Parsed.PotentialFix[0])
+		}
+	}
+	return b.String()
+}
+
+// primaryAnalysis is the first provider result with a parsed analysis, or
+// nil.
+func primaryAnalysis(record analysisRecord) *ProviderResult {
+	for i := range record.Providers {
+		if record.Providers[i].Parsed != nil {
+			return &record.Providers[i]
+		}
+	}
+	return nil
+}
+
+// recordHeading is the first line of a notification: status and title,
+// marked when the analysis is still pending.
+func recordHeading(record analysisRecord) string {
+	heading := fmt.Sprintf("[%s] %s", strings.ToUpper(record.AlertStatus), recordTitle(record))
+	if record.Preliminary {
+		heading += " (preliminary, analysis pending)"
+	}
+	return heading
+}
+
+// recordTitle names the alert group an analysis is about.
+func recordTitle(record analysisRecord) string {
+	if name := record.CommonLabels["alertname"]; name != "" {
+		return name
+	}
+	var names []string
+	for _, a := range record.AlertSummaries {
+		if name := a.Labels["alertname"]; name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return record.GroupKey
+	}
+	return strings.Join(names, ", ")
+}
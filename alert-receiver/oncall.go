@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OnCallWebhookPayload is a Grafana OnCall outgoing webhook using the
+// default payload template.
+type OnCallWebhookPayload struct {
+	Event struct {
+		Type string    `json:"type"`
+		Time time.Time `json:"time"`
+	} `json:"event"`
+	AlertGroup struct {
+		ID          string     `json:"id"`
+		State       string     `json:"state"`
+		Title       string     `json:"title"`
+		AlertsCount int        `json:"alerts_count"`
+		CreatedAt   time.Time  `json:"created_at"`
+		ResolvedAt  *time.Time `json:"resolved_at"`
+		Permalinks  struct {
+			Web string `json:"web"`
+		} `json:"permalinks"`
+	} `json:"alert_group"`
+	AlertGroupID string `json:"alert_group_id"`
+	// AlertPayload is what the integration received, for a Grafana
+	// Alerting or Alertmanager integration the usual webhook body.
+	AlertPayload json.RawMessage `json:"alert_payload"`
+	Integration  struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+		Team string `json:"team"`
+	} `json:"integration"`
+}
+
+// onCallAnalyzedEvents are the OnCall events worth an analysis; the rest
+// (acknowledge, silence and their reversals) change who is looking, not
+// what is wrong.
+var onCallAnalyzedEvents = map[string]bool{
+	"escalation": true,
+	"resolve":    true,
+	"unresolve":  true,
+}
+
+// toGrafanaPayload maps an OnCall event into the pipeline's payload. The
+// original alerts are used when the integration received Grafana or
+// Alertmanager alerts; otherwise the alert group becomes one alert.
+func (p OnCallWebhookPayload) toGrafanaPayload() GrafanaWebhookPayload {
+	id := p.AlertGroup.ID
+	if id == "" {
+		id = p.AlertGroupID
+	}
+	status := "firing"
+	if p.Event.Type == "resolve" || p.AlertGroup.State == "resolved" {
+		status = "resolved"
+	}
+
+	var payload GrafanaWebhookPayload
+	if err := json.Unmarshal(p.AlertPayload, &payload); err != nil || len(payload.Alerts) == 0 {
+		alert := GrafanaAlert{
+			Status:      status,
+			Labels:      map[string]string{"alertname": p.AlertGroup.Title},
+			Annotations: map[string]string{},
+			StartsAt:    p.AlertGroup.CreatedAt,
+		}
+		if p.AlertGroup.ResolvedAt != nil {
+			alert.EndsAt = *p.AlertGroup.ResolvedAt
+		}
+		payload = GrafanaWebhookPayload{Alerts: []GrafanaAlert{alert}}
+	}
+	payload.Status = status
+	payload.Receiver = "oncall/" + p.Integration.Name
+	payload.GroupKey = "oncall/" + id
+	if payload.CommonLabels == nil {
+		payload.CommonLabels = map[string]string{}
+	}
+	payload.CommonLabels["oncall_alert_group"] = id
+	if p.Integration.Team != "" {
+		payload.CommonLabels["oncall_team"] = p.Integration.Team
+	}
+	if payload.CommonAnnotations == nil {
+		payload.CommonAnnotations = map[string]string{}
+	}
+	if p.AlertGroup.Permalinks.Web != "" {
+		payload.CommonAnnotations["oncall_url"] = p.AlertGroup.Permalinks.Web
+	}
+	return payload
+}
+
+// IncidentWebhookPayload is a Grafana Incident outgoing webhook.
+type IncidentWebhookPayload struct {
+	Event    string `json:"event"`
+	Incident struct {
+		IncidentID string `json:"incidentID"`
+		Title      string `json:"title"`
+		Summary    string `json:"summary"`
+		Status     string `json:"status"`
+		Severity   string `json:"severity"`
+		IsDrill    bool   `json:"isDrill"`
+		Labels     []struct {
+			Key   string `json:"key"`
+			Label string `json:"label"`
+		} `json:"labels"`
+		IncidentStart time.Time `json:"incidentStart"`
+		IncidentEnd   time.Time `json:"incidentEnd"`
+		OverviewURL   string    `json:"overviewURL"`
+	} `json:"incident"`
+}
+
+// analyzed reports whether the event (such as grafana.incident.created)
+// opens, closes or changes the status of the incident; other updates
+// (roles, activity, tasks) do not warrant a new analysis.
+func (p IncidentWebhookPayload) analyzed() bool {
+	e := p.Event
+	return e == "" || strings.HasSuffix(e, ".created") || strings.HasSuffix(e, ".closed") ||
+		strings.HasSuffix(e, ".resolved") || strings.Contains(e, "status")
+}
+
+// toGrafanaPayload maps an incident into the pipeline's payload as one
+// alert carrying its severity and labels.
+func (p IncidentWebhookPayload) toGrafanaPayload() GrafanaWebhookPayload {
+	inc := p.Incident
+	status := "firing"
+	if inc.Status == "resolved" {
+		status = "resolved"
+	}
+	labels := map[string]string{"alertname": inc.Title, "incident_id": inc.IncidentID}
+	if inc.Severity != "" {
+		labels["severity"] = strings.ToLower(inc.Severity)
+	}
+	if inc.IsDrill {
+		labels["drill"] = "true"
+	}
+	for _, l := range inc.Labels {
+		if l.Key != "" {
+			labels[l.Key] = l.Label
+		} else if l.Label != "" {
+			labels[l.Label] = "true"
+		}
+	}
+	annotations := map[string]string{}
+	if inc.Summary != "" {
+		annotations["summary"] = inc.Summary
+	}
+	if inc.OverviewURL != "" {
+		annotations["incident_url"] = inc.OverviewURL
+	}
+	return GrafanaWebhookPayload{
+		Receiver:          "grafana-incident",
+		Status:            status,
+		GroupKey:          "incident/" + inc.IncidentID,
+		CommonLabels:      labels,
+		CommonAnnotations: annotations,
+		Alerts: []GrafanaAlert{{
+			Status:      status,
+			Labels:      labels,
+			Annotations: annotations,
+			StartsAt:    inc.IncidentStart,
+			EndsAt:      inc.IncidentEnd,
+		}},
+	}
+}
+
+func (s *server) handleOnCallWebhook(w http.ResponseWriter, r *http.Request) {
+	var event OnCallWebhookPayload
+	if !s.decodeWebhook(w, r, &event) {
+		return
+	}
+	if !onCallAnalyzedEvents[event.Event.Type] {
+		writeJSON(w, http.StatusOK, map[string]any{"status": "ignored", "event": event.Event.Type})
+		return
+	}
+	s.enqueue(w, r, event.toGrafanaPayload())
+}
+
+func (s *server) handleIncidentWebhook(w http.ResponseWriter, r *http.Request) {
+	var event IncidentWebhookPayload
+	if !s.decodeWebhook(w, r, &event) {
+		return
+	}
+	if !event.analyzed() {
+		writeJSON(w, http.StatusOK, map[string]any{"status": "ignored", "event": event.Event})
+		return
+	}
+	s.enqueue(w, r, event.toGrafanaPayload())
+}
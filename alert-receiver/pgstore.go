@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// pgSchema creates the Postgres store's tables and indexes. Every
+// statement is idempotent, so it runs at each start.
+var pgSchema = []string{
+	`CREATE TABLE IF NOT EXISTS analyses (
+		id           text PRIMARY KEY,
+		received_at  timestamptz NOT NULL,
+		completed_at timestamptz NOT NULL,
+		alert_status text NOT NULL,
+		severity     text NOT NULL DEFAULT '',
+		labels       jsonb NOT NULL DEFAULT '{}',
+		expires_at   timestamptz,
+		compacted    boolean NOT NULL DEFAULT false,
+		search       tsvector NOT NULL,
+		record       jsonb NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS analyses_completed_at_idx ON analyses (completed_at DESC)`,
+	`CREATE INDEX IF NOT EXISTS analyses_status_idx ON analyses (alert_status, completed_at DESC)`,
+	`CREATE INDEX IF NOT EXISTS analyses_labels_idx ON analyses USING gin (labels jsonb_path_ops)`,
+	`CREATE INDEX IF NOT EXISTS analyses_search_idx ON analyses USING gin (search)`,
+	`CREATE INDEX IF NOT EXISTS analyses_expires_at_idx ON analyses (expires_at) WHERE expires_at IS NOT NULL`,
+	`CREATE TABLE IF NOT EXISTS idempotency_keys (
+		key        text PRIMARY KEY,
+		job_id     text NOT NULL,
+		expires_at timestamptz NOT NULL
+	)`,
+}
+
+// pgOpTimeout bounds a single store operation.
+const pgOpTimeout = 5 * time.Second
+
+// pgRetentionLock is the advisory lock id the replicas take turns on for
+// the retention pass.
+const pgRetentionLock = 0x616c6572 // "aler"
+
+// pgStore keeps every analysis in Postgres, indexed by status, labels and
+// time and searchable in full text, for installations that outgrow
+// MAX_STORED_ANALYSES. Records expire by ANALYSIS_RETENTION as it was when
+// they were stored.
+type pgStore struct {
+	pool      *pgxpool.Pool
+	max       int
+	retention retentionPolicy
+}
+
+// openPgStore connects to url and creates the schema.
+func openPgStore(ctx context.Context, url string, max int, retention retentionPolicy) (*pgStore, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	pool, err := pgxpool.New(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("POSTGRES_URL: %w", err)
+	}
+	for _, stmt := range pgSchema {
+		if _, err := pool.Exec(ctx, stmt); err != nil {
+			pool.Close()
+			return nil, fmt.Errorf("create schema: %w", err)
+		}
+	}
+	return &pgStore{pool: pool, max: max, retention: retention}, nil
+}
+
+func (s *pgStore) add(record analysisRecord) {
+	ctx, cancel := context.WithTimeout(context.Background(), pgOpTimeout)
+	defer cancel()
+	if err := s.write(ctx, s.pool, record, true); err != nil {
+		storeErrorsTotal.WithLabelValues("add").Inc()
+		slog.Warn("failed to store analysis in postgres", "job_id", record.ID, "error", err)
+	}
+}
+
+// jsonLabels encodes labels for a jsonb column, nil as an empty object
+// so that containment still works.
+func jsonLabels(labels map[string]string) ([]byte, error) {
+	if labels == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(labels)
+}
+
+// pgExecer is a pool or a transaction.
+type pgExecer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// write upserts record with its indexed columns. insert also sets the
+// expiry, which updates keep.
+func (s *pgStore) write(ctx context.Context, db pgExecer, record analysisRecord, insert bool) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	labels, err := jsonLabels(record.CommonLabels)
+	if err != nil {
+		return err
+	}
+	if !insert {
+		_, err = db.Exec(ctx, `UPDATE analyses SET compacted = $2, search = to_tsvector('english', $3), record = $4 WHERE id = $1`,
+			record.ID, record.Compacted, searchText(record), data)
+		return err
+	}
+	var expires *time.Time
+	if keep := s.retention.retention(record); keep > 0 {
+		t := record.CompletedAt.Add(keep)
+		expires = &t
+	}
+	_, err = db.Exec(ctx, `
+		INSERT INTO analyses (id, received_at, completed_at, alert_status, severity, labels, expires_at, compacted, search, record)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, to_tsvector('english', $9), $10)
+		ON CONFLICT (id) DO UPDATE SET
+			completed_at = EXCLUDED.completed_at, alert_status = EXCLUDED.alert_status,
+			severity = EXCLUDED.severity, labels = EXCLUDED.labels, expires_at = EXCLUDED.expires_at,
+			compacted = EXCLUDED.compacted, search = EXCLUDED.search, record = EXCLUDED.record`,
+		record.ID, record.ReceivedAt, record.CompletedAt, record.AlertStatus, recordSeverity(record),
+		labels, expires, record.Compacted, searchText(record), data)
+	return err
+}
+
+// update applies fn to the record with id under a row lock, so concurrent
+// updates from other replicas wait rather than overwrite each other.
+func (s *pgStore) update(id string, fn func(*analysisRecord) bool) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), pgOpTimeout)
+	defer cancel()
+	found := false
+	err := pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		var data []byte
+		err := tx.QueryRow(ctx, `SELECT record FROM analyses WHERE id = $1 FOR UPDATE`, id).Scan(&data)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		record, err := decodeAnalysisRecord(data)
+		if err != nil {
+			return err
+		}
+		found = true
+		if !fn(&record) {
+			return nil
+		}
+		return s.write(ctx, tx, record, false)
+	})
+	if err != nil {
+		storeErrorsTotal.WithLabelValues("update").Inc()
+		slog.Warn("failed to update analysis in postgres", "job_id", id, "error", err)
+	}
+	return found
+}
+
+func (s *pgStore) get(id string) (analysisRecord, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), pgOpTimeout)
+	defer cancel()
+	var data []byte
+	err := s.pool.QueryRow(ctx, `SELECT record FROM analyses WHERE id = $1`, id).Scan(&data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return analysisRecord{}, false
+	}
+	if err == nil {
+		var record analysisRecord
+		if record, err = decodeAnalysisRecord(data); err == nil {
+			return record, true
+		}
+	}
+	storeErrorsTotal.WithLabelValues("get").Inc()
+	slog.Warn("failed to read analysis from postgres", "job_id", id, "error", err)
+	return analysisRecord{}, false
+}
+
+// list returns the newest MAX_STORED_ANALYSES records; older ones stay
+// reachable by ID and through search.
+func (s *pgStore) list() []analysisRecord {
+	records, err := s.query(context.Background(), `SELECT record FROM analyses ORDER BY completed_at DESC LIMIT $1`, s.max)
+	if err != nil {
+		storeErrorsTotal.WithLabelValues("list").Inc()
+		slog.Warn("failed to list analyses from postgres", "error", err)
+	}
+	return records
+}
+
+func (s *pgStore) count() int {
+	ctx, cancel := context.WithTimeout(context.Background(), pgOpTimeout)
+	defer cancel()
+	var n int
+	if err := s.pool.QueryRow(ctx, `SELECT count(*) FROM analyses`).Scan(&n); err != nil {
+		storeErrorsTotal.WithLabelValues("count").Inc()
+		slog.Warn("failed to count analyses in postgres", "error", err)
+	}
+	return n
+}
+
+// search matches q.Text with websearch_to_tsquery syntax: words, "quoted
+// phrases", OR and -excluded words. Matches are ranked, newest first among
+// equals; without text the newest matching records are returned.
+func (s *pgStore) search(q searchQuery) ([]analysisRecord, error) {
+	labels, err := jsonLabels(q.Labels)
+	if err != nil {
+		return nil, err
+	}
+	var since *time.Time
+	if !q.Since.IsZero() {
+		since = &q.Since
+	}
+	records, err := s.query(context.Background(), `
+		SELECT record FROM analyses
+		WHERE ($1::text = '' OR search @@ websearch_to_tsquery('english', $1))
+		  AND ($2::text = '' OR alert_status = $2)
+		  AND labels @> $3::jsonb
+		  AND ($4::timestamptz IS NULL OR completed_at >= $4)
+		ORDER BY CASE WHEN $1 = '' THEN 0 ELSE ts_rank(search, websearch_to_tsquery('english', $1)) END DESC,
+		  completed_at DESC
+		LIMIT $5`,
+		q.Text, q.Status, labels, since, q.Limit)
+	if err != nil {
+		storeErrorsTotal.WithLabelValues("search").Inc()
+		if records == nil {
+			return nil, err
+		}
+		slog.Warn("skipped undecodable analyses in postgres search", "error", err)
+	}
+	return records, nil
+}
+
+func (s *pgStore) query(ctx context.Context, sql string, args ...any) ([]analysisRecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, pgOpTimeout)
+	defer cancel()
+	rows, err := s.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := pgx.CollectRows(rows, pgx.RowTo[[]byte])
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	records := make([]analysisRecord, 0, len(raw))
+	for _, data := range raw {
+		record, err := decodeAnalysisRecord(data)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, errors.Join(errs...)
+}
+
+// runRetention applies the retention policy every retentionInterval until
+// ctx is cancelled. An advisory lock makes the replicas skip a pass
+// another one is running.
+func (s *pgStore) runRetention(ctx context.Context) error {
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			if err := s.applyRetention(ctx, now); err != nil {
+				storeErrorsTotal.WithLabelValues("retention").Inc()
+				slog.Warn("failed to apply analysis retention in postgres", "error", err)
+			}
+		}
+	}
+}
+
+// applyRetention deletes expired records and idempotency keys, and
+// compacts records older than the compaction age.
+func (s *pgStore) applyRetention(ctx context.Context, now time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		var locked bool
+		if err := tx.QueryRow(ctx, `SELECT pg_try_advisory_xact_lock($1)`, pgRetentionLock).Scan(&locked); err != nil || !locked {
+			return err
+		}
+		tag, err := tx.Exec(ctx, `DELETE FROM analyses WHERE expires_at < $1`, now)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM idempotency_keys WHERE expires_at < $1`, now); err != nil {
+			return err
+		}
+		compacted := 0
+		if s.retention.compactAfter > 0 {
+			rows, err := tx.Query(ctx, `SELECT record FROM analyses WHERE NOT compacted AND completed_at < $1 FOR UPDATE`, now.Add(-s.retention.compactAfter))
+			if err != nil {
+				return err
+			}
+			raw, err := pgx.CollectRows(rows, pgx.RowTo[[]byte])
+			if err != nil {
+				return err
+			}
+			for _, data := range raw {
+				record, err := decodeAnalysisRecord(data)
+				if err != nil {
+					return err
+				}
+				compactRecord(&record)
+				if err := s.write(ctx, tx, record, false); err != nil {
+					return err
+				}
+				compacted++
+			}
+		}
+		expired := int(tag.RowsAffected())
+		if expired == 0 && compacted == 0 {
+			return nil
+		}
+		analysisRetentionTotal.WithLabelValues("expired").Add(float64(expired))
+		analysisRetentionTotal.WithLabelValues("compacted").Add(float64(compacted))
+		slog.Info("applied analysis retention", "backend", storePostgres, "expired", expired, "compacted", compacted)
+		return nil
+	})
+}
+
+// pgIdempotency is the idempotency cache in Postgres, shared by the
+// replicas like the records.
+type pgIdempotency struct {
+	pool *pgxpool.Pool
+	ttl  time.Duration
+}
+
+// claim inserts key for jobID, taking over an expired claim. A failed
+// query admits the delivery.
+func (c *pgIdempotency) claim(key, jobID string, now time.Time) (string, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), pgOpTimeout)
+	defer cancel()
+	var holder string
+	err := c.pool.QueryRow(ctx, `
+		WITH claimed AS (
+			INSERT INTO idempotency_keys (key, job_id, expires_at) VALUES ($1, $2, $3)
+			ON CONFLICT (key) DO UPDATE SET job_id = EXCLUDED.job_id, expires_at = EXCLUDED.expires_at
+				WHERE idempotency_keys.expires_at < $4
+			RETURNING job_id
+		)
+		SELECT job_id FROM claimed
+		UNION ALL
+		SELECT job_id FROM idempotency_keys WHERE key = $1 AND NOT EXISTS (SELECT 1 FROM claimed)`,
+		key, jobID, now.Add(c.ttl), now).Scan(&holder)
+	if err != nil {
+		storeErrorsTotal.WithLabelValues("idempotency").Inc()
+		slog.Warn("failed to claim idempotency key in postgres", "job_id", jobID, "error", err)
+		return "", false
+	}
+	if holder == jobID {
+		return "", false
+	}
+	return holder, true
+}
+
+func (c *pgIdempotency) release(key, jobID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), pgOpTimeout)
+	defer cancel()
+	if _, err := c.pool.Exec(ctx, `DELETE FROM idempotency_keys WHERE key = $1 AND job_id = $2`, key, jobID); err != nil {
+		storeErrorsTotal.WithLabelValues("idempotency").Inc()
+		slog.Warn("failed to release idempotency key in postgres", "job_id", jobID, "error", err)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+
+	"edge-monitor-app/internal/maintenance"
+)
+
+// AnalysisPolicy is one entry of ANALYSIS_POLICIES_JSON: what to do with
+// alerts whose labels match.
+type AnalysisPolicy struct {
+	Name string `json:"name"`
+	// Match maps label names to regular expressions that must match the
+	// whole label value, such as {"alertname":"HighJitter","severity":"info"}.
+	// An alert matches when every pattern matches its labels or, failing
+	// that, the group's common labels.
+	Match map[string]string `json:"match"`
+	// Providers limits the analysis to these LLM backends by name; empty
+	// uses every backend.
+	Providers []string `json:"providers,omitempty"`
+	// QueryPack names the QUERY_PACKS_JSON entry to enrich with; empty uses
+	// METRIC_QUERIES_JSON (or the built-in queries).
+	QueryPack string `json:"query_pack,omitempty"`
+	// Notify sends the finished analysis to the notification sinks; it
+	// defaults to true.
+	Notify *bool `json:"notify,omitempty"`
+	// SkipLLM stores the enrichment without calling any provider; unset
+	// follows SKIP_LLM, so a policy can also opt back in to analysis.
+	SkipLLM *bool `json:"skip_llm,omitempty"`
+	// Maintenance lists windows (cron and duration, or start and end)
+	// during which matching alerts are still analyzed and stored, flagged
+	// with the window, but not sent to the notification sinks.
+	Maintenance []maintenance.WindowConfig `json:"maintenance,omitempty"`
+
+	match   map[string]*regexp.Regexp
+	windows []*maintenance.Window
+}
+
+// defaultPolicy applies when no policy matches.
+var defaultPolicy = AnalysisPolicy{Name: "default"}
+
+func (p AnalysisPolicy) notifies() bool {
+	return p.Notify == nil || *p.Notify
+}
+
+// skipsLLM reports whether matching alerts are stored without calling a
+// provider: the policy's skip_llm, else the global SKIP_LLM.
+func (p AnalysisPolicy) skipsLLM(global bool) bool {
+	if p.SkipLLM != nil {
+		return *p.SkipLLM
+	}
+	return global
+}
+
+// inMaintenance returns the name of the policy's maintenance window active
+// at t, or "" outside every window.
+func (p AnalysisPolicy) inMaintenance(t time.Time) string {
+	for i, w := range p.windows {
+		if w.Active(t) {
+			if w.Name() != "" {
+				return w.Name()
+			}
+			return fmt.Sprintf("%s-maintenance-%d", p.Name, i+1)
+		}
+	}
+	return ""
+}
+
+// parsePolicies reads ANALYSIS_POLICIES_JSON and checks that the
+// providers and query packs it names exist.
+func parsePolicies(raw string, backends []BackendConfig, packs map[string][]MetricQuery) ([]AnalysisPolicy, error) {
+	var policies []AnalysisPolicy
+	if err := json.Unmarshal([]byte(raw), &policies); err != nil {
+		return nil, fmt.Errorf("parse ANALYSIS_POLICIES_JSON: %w", err)
+	}
+	for i := range policies {
+		p := &policies[i]
+		if p.Name == "" {
+			p.Name = fmt.Sprintf("policy-%d", i+1)
+		}
+		p.match = make(map[string]*regexp.Regexp, len(p.Match))
+		for label, expr := range p.Match {
+			re, err := regexp.Compile("^(?:" + expr + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("analysis policy %q: match %s: %w", p.Name, label, err)
+			}
+			p.match[label] = re
+		}
+		for _, name := range p.Providers {
+			if !slices.ContainsFunc(backends, func(b BackendConfig) bool { return b.Name == name }) {
+				return nil, fmt.Errorf("analysis policy %q: unknown provider %q", p.Name, name)
+			}
+		}
+		if _, ok := packs[p.QueryPack]; p.QueryPack != "" && !ok {
+			return nil, fmt.Errorf("analysis policy %q: unknown query pack %q", p.Name, p.QueryPack)
+		}
+		for _, w := range p.Maintenance {
+			if len(w.Targets) > 0 {
+				return nil, fmt.Errorf("analysis policy %q: maintenance windows select alerts by the policy's match, not targets", p.Name)
+			}
+		}
+		windows, err := maintenance.ParseWindows(p.Maintenance)
+		if err != nil {
+			return nil, fmt.Errorf("analysis policy %q: %w", p.Name, err)
+		}
+		p.windows = windows
+	}
+	return policies, nil
+}
+
+// parseQueryPacks reads QUERY_PACKS_JSON, named sets of enrichment queries
+// policies can choose instead of the default ones.
+func parseQueryPacks(raw string) (map[string][]MetricQuery, error) {
+	packs := make(map[string][]MetricQuery)
+	if err := json.Unmarshal([]byte(raw), &packs); err != nil {
+		return nil, fmt.Errorf("parse QUERY_PACKS_JSON: %w", err)
+	}
+	for name, queries := range packs {
+		if err := validateMetricUnits(queries); err != nil {
+			return nil, fmt.Errorf("query pack %q: %w", name, err)
+		}
+		if err := validateMetricScopes(queries); err != nil {
+			return nil, fmt.Errorf("query pack %q: %w", name, err)
+		}
+	}
+	return packs, nil
+}
+
+func (p AnalysisPolicy) matches(payload GrafanaWebhookPayload) bool {
+	if len(payload.Alerts) == 0 {
+		return p.matchesLabels(nil, payload.CommonLabels)
+	}
+	for _, alert := range payload.Alerts {
+		if p.matchesLabels(alert.Labels, payload.CommonLabels) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p AnalysisPolicy) matchesLabels(labels, common map[string]string) bool {
+	for label, re := range p.match {
+		v, ok := labels[label]
+		if !ok {
+			v = common[label]
+		}
+		if !re.MatchString(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// policyFor returns the first policy matching the payload.
+func (s *server) policyFor(payload GrafanaWebhookPayload) AnalysisPolicy {
+	for _, p := range s.cfg.Policies {
+		if p.matches(payload) {
+			return p
+		}
+	}
+	return defaultPolicy
+}
+
+// queriesFor returns the enrichment queries of the policy's query pack.
+func (s *server) queriesFor(policy AnalysisPolicy) []MetricQuery {
+	if policy.QueryPack != "" {
+		return s.cfg.QueryPacks[policy.QueryPack]
+	}
+	return s.cfg.MetricQueries
+}
+
+// providersFor returns the backends the policy analyzes with.
+func (s *server) providersFor(policy AnalysisPolicy) []LLMProvider {
+	if len(policy.Providers) == 0 {
+		return s.providers
+	}
+	var out []LLMProvider
+	for _, p := range s.providers {
+		if slices.Contains(policy.Providers, p.Name()) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
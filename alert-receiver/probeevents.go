@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultProbeEventsMax bounds the events kept per source when
+// PROBE_EVENTS_MAX is unset.
+const defaultProbeEventsMax = 200
+
+// ProbeEventSourceConfig is one entry of PROBE_EVENTS_JSON: a probe
+// service's GET /events endpoint.
+type ProbeEventSourceConfig struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// ProbeEventLog is the state transitions one probe service logged during
+// an incident, as it served them. Events holds the most recent ones when
+// there were more than PROBE_EVENTS_MAX; Omitted counts the rest.
+type ProbeEventLog struct {
+	Source  string            `json:"source"`
+	Since   time.Time         `json:"since"`
+	Until   time.Time         `json:"until"`
+	Events  []json.RawMessage `json:"events,omitempty"`
+	Omitted int               `json:"omitted,omitempty"`
+	Error   string            `json:"error,omitempty"`
+}
+
+func parseProbeEventSources(raw string) ([]ProbeEventSourceConfig, error) {
+	var sources []ProbeEventSourceConfig
+	if err := json.Unmarshal([]byte(raw), &sources); err != nil {
+		return nil, fmt.Errorf("parse PROBE_EVENTS_JSON: %w", err)
+	}
+	for i, src := range sources {
+		if !strings.HasPrefix(src.URL, "http://") && !strings.HasPrefix(src.URL, "https://") {
+			return nil, fmt.Errorf("probe events source %q: url must be http(s)", src.Name)
+		}
+		if src.Name == "" {
+			u, _ := url.Parse(src.URL)
+			sources[i].Name = u.Hostname()
+		}
+	}
+	return sources, nil
+}
+
+// probeEventWindow is the incident's time range: from the analysis
+// lookback before the earliest alert to the last alert's end when the
+// group resolved, else to now.
+func probeEventWindow(job analysisJob, lookback time.Duration, now time.Time) (since, until time.Time) {
+	since = earliestAlertTime(job.Payload, job.ReceivedAt).Add(-lookback)
+	until = now
+	if job.Payload.Status == "resolved" {
+		var last time.Time
+		for _, alert := range job.Payload.Alerts {
+			if alert.EndsAt.After(last) {
+				last = alert.EndsAt
+			}
+		}
+		if !last.IsZero() && last.Before(now) {
+			until = last
+		}
+	}
+	return since.UTC().Truncate(time.Second), until.UTC().Add(time.Second).Truncate(time.Second)
+}
+
+// collectProbeEvents fetches, in parallel, every probe service's events for
+// the incident window. A failed source is reported in its entry rather
+// than failing the job.
+func (s *server) collectProbeEvents(job analysisJob) []ProbeEventLog {
+	if len(s.cfg.ProbeEventSources) == 0 {
+		return nil
+	}
+	since, until := probeEventWindow(job, s.cfg.PrometheusLookback, time.Now())
+	client := &http.Client{Timeout: s.cfg.ProbeEventsTimeout}
+
+	logs := make([]ProbeEventLog, len(s.cfg.ProbeEventSources))
+	var wg sync.WaitGroup
+	for i, src := range s.cfg.ProbeEventSources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), s.cfg.ProbeEventsTimeout)
+			defer cancel()
+			log := ProbeEventLog{Source: src.Name, Since: since, Until: until}
+			events, err := fetchProbeEvents(ctx, client, src.URL, since, until)
+			if err != nil {
+				probeEventFetchesTotal.WithLabelValues(src.Name, "error").Inc()
+				log.Error = err.Error()
+				logs[i] = log
+				return
+			}
+			probeEventFetchesTotal.WithLabelValues(src.Name, "success").Inc()
+			if over := len(events) - s.cfg.ProbeEventsMax; over > 0 {
+				events, log.Omitted = events[over:], over
+			}
+			log.Events = events
+			logs[i] = log
+		}()
+	}
+	wg.Wait()
+	return logs
+}
+
+// fetchProbeEvents reads a probe service's /events for [since, until].
+func fetchProbeEvents(ctx context.Context, client *http.Client, endpoint string, since, until time.Time) ([]json.RawMessage, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("since", since.Format(time.RFC3339))
+	q.Set("until", until.Format(time.RFC3339))
+	u.RawQuery = q.Encode()
+
+	body, err := fetchBody(ctx, client, u.String(), "application/json")
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Events []json.RawMessage `json:"events"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("decode events: %w", err)
+	}
+	return resp.Events, nil
+}
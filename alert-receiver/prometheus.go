@@ -14,17 +14,30 @@ import (
 
 type PrometheusClient struct {
 	baseURL    string
+	auth       PrometheusAuth
 	httpClient *http.Client
 }
 
+// PrometheusAuth is what the receiver presents to Prometheus; a bearer
+// token wins over basic auth.
+type PrometheusAuth struct {
+	Username string
+	Password string
+	Bearer   string
+}
+
 type MetricSnapshot struct {
-	Name        string         `json:"name"`
-	Description string         `json:"description,omitempty"`
-	Query       string         `json:"query"`
-	ResultType  string         `json:"result_type,omitempty"`
-	Summary     string         `json:"summary,omitempty"`
-	Series      []MetricSeries `json:"series,omitempty"`
-	Error       string         `json:"error,omitempty"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Query       string `json:"query"`
+	Unit        string `json:"unit,omitempty"`
+	// Instance is set on a query scoped to one instance of the alert
+	// group; Query is then the scoped query.
+	Instance   string         `json:"instance,omitempty"`
+	ResultType string         `json:"result_type,omitempty"`
+	Summary    string         `json:"summary,omitempty"`
+	Series     []MetricSeries `json:"series,omitempty"`
+	Error      string         `json:"error,omitempty"`
 }
 
 type MetricSeries struct {
@@ -32,27 +45,38 @@ type MetricSeries struct {
 	Value  string            `json:"value"`
 }
 
-func NewPrometheusClient(baseURL string, timeout time.Duration) *PrometheusClient {
+func NewPrometheusClient(baseURL string, timeout time.Duration, auth PrometheusAuth) *PrometheusClient {
 	return &PrometheusClient{
 		baseURL: strings.TrimRight(baseURL, "/"),
+		auth:    auth,
 		httpClient: &http.Client{
 			Timeout: timeout,
 		},
 	}
 }
 
+// get sends an authenticated GET for an API path; the caller closes the
+// response body.
+func (p *PrometheusClient) get(ctx context.Context, path string, params url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+path+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("build Prometheus request: %w", err)
+	}
+	switch {
+	case p.auth.Bearer != "":
+		req.Header.Set("Authorization", "Bearer "+p.auth.Bearer)
+	case p.auth.Username != "":
+		req.SetBasicAuth(p.auth.Username, p.auth.Password)
+	}
+	return p.httpClient.Do(req)
+}
+
 func (p *PrometheusClient) InstantQuery(ctx context.Context, query MetricQuery, queryTime time.Time) (MetricSnapshot, error) {
 	params := url.Values{}
 	params.Set("query", query.Query)
 	params.Set("time", queryTime.Format(time.RFC3339))
 
-	endpoint := p.baseURL + "/api/v1/query?" + params.Encode()
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
-	if err != nil {
-		return MetricSnapshot{}, fmt.Errorf("build Prometheus request: %w", err)
-	}
-
-	resp, err := p.httpClient.Do(req)
+	resp, err := p.get(ctx, "/api/v1/query", params)
 	if err != nil {
 		return MetricSnapshot{}, fmt.Errorf("query Prometheus: %w", err)
 	}
@@ -86,6 +110,7 @@ func (p *PrometheusClient) InstantQuery(ctx context.Context, query MetricQuery,
 		Name:        query.Name,
 		Description: query.Description,
 		Query:       query.Query,
+		Unit:        queryUnit(query),
 		ResultType:  apiResp.Data.ResultType,
 	}
 
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 )
 
@@ -16,31 +20,194 @@ Return strict JSON with this shape:
   "potential_fix": ["ordered remediation ideas"],
   "next_checks": ["additional checks if evidence is insufficient"]
 }
-Do not invent radio-level evidence if it is not present in the metrics.`
+Do not invent radio-level evidence if it is not present in the metrics.
+If external_context lists an ISP incident whose timing overlaps the alert, say so and weigh it as evidence of an upstream cause; otherwise do not assume one.
+If external_context includes weather at the site, heavy precipitation or strong wind can explain degradation of DSL, cable or fixed-wireless links; cite it only when the timing matches.
+If heuristic_verdict is present, it is a hypothesis from fixed rules over the metric snapshots: confirm or refute it explicitly, citing evidence, rather than repeating it.
+If probe_events lists probe state transitions, use their exact times for the timeline (what failed first, what followed, how long each was down); they are more precise than the averaged metric snapshots.
+If timezone is present, every timestamp is local time in that zone; give times in it, as the people reading the analysis will.
+If metrics are given per instance, compare the instances: name the ones that are broken and the ones that look healthy instead of reasoning about an average across them.`
 
-func buildLLMRequest(job analysisJob, metrics []MetricSnapshot, lookbackDuration time.Duration) (LLMRequest, error) {
+// buildLLMRequest renders the prompt for a job, with timestamps in loc so
+// the model reasons in the site's local time. metricsFormat "table" puts
+// the metric snapshots in a rounded table after the JSON; "json" leaves
+// them in it as Prometheus returned them. Snapshots scoped to an instance
+// are grouped per instance, with the alerts whose shardLabel names it.
+func buildLLMRequest(job analysisJob, record analysisRecord, lookbackDuration time.Duration, loc *time.Location, metricsFormat, shardLabel string) (LLMRequest, error) {
+	record = localizeRecord(record, loc)
+	global, instances := groupByInstance(record.Metrics, record.AlertSummaries, shardLabel)
 	payload := map[string]any{
-		"received_at":        job.ReceivedAt,
+		"received_at":        record.ReceivedAt,
 		"alert_status":       job.Payload.Status,
 		"receiver":           job.Payload.Receiver,
 		"group_key":          job.Payload.GroupKey,
 		"group_labels":       job.Payload.GroupLabels,
 		"common_labels":      job.Payload.CommonLabels,
 		"common_annotations": job.Payload.CommonAnnotations,
-		"alerts":             summarizeAlerts(job.Payload.Alerts),
-		"metric_snapshots":   metrics,
+		"alerts":             record.AlertSummaries,
 		"analysis_window":    fmt.Sprint(lookbackDuration),
 	}
+	if metricsFormat == promptMetricsJSON {
+		payload["metric_snapshots"] = global
+		if len(instances) > 0 {
+			payload["instances"] = instances
+		}
+	}
+	if loc != nil && loc != time.UTC {
+		payload["timezone"] = loc.String()
+	}
+	if len(record.External) > 0 {
+		payload["external_context"] = record.External
+	}
+	if len(record.ProbeEvents) > 0 {
+		payload["probe_events"] = record.ProbeEvents
+	}
+	if record.Heuristic != nil {
+		payload["heuristic_verdict"] = record.Heuristic
+	}
 
 	body, err := json.MarshalIndent(payload, "", "  ")
 	if err != nil {
 		return LLMRequest{}, fmt.Errorf("marshal prompt payload: %w", err)
 	}
 
+	userPrompt := "Evaluate this Grafana alert incident and summarize the issue, likely cause, and potential fix using only the evidence below.\n\n" + string(body)
+	if metricsFormat != promptMetricsJSON {
+		if len(global) > 0 {
+			userPrompt += "\n\nMetric snapshots over the analysis window:\n" + metricTable(global)
+		}
+		for _, m := range instances {
+			userPrompt += fmt.Sprintf("\n\nMetric snapshots for %s=%s", shardLabel, m.Instance)
+			if len(m.Alerts) > 0 {
+				userPrompt += " (alerts: " + strings.Join(m.Alerts, ", ") + ")"
+			}
+			userPrompt += ":\n" + metricTable(m.Metrics)
+		}
+	}
+
 	return LLMRequest{
 		SystemPrompt: defaultSystemPrompt,
-		UserPrompt:   "Evaluate this Grafana alert incident and summarize the issue, likely cause, and potential fix using only the evidence below.\n\n" + string(body),
+		UserPrompt:   userPrompt,
 		MaxTokens:    900,
 		Temperature:  0.2,
 	}, nil
 }
+
+const postMortemSystemPrompt = `You write short post-mortems of resolved edge network incidents using only the provided evidence.
+Return strict JSON with this shape:
+{
+  "summary": "the total impact: what was affected, from when to when and for how long",
+  "likely_issue": "the cause the evidence best supports",
+  "confidence": 0.0,
+  "evidence": ["the few facts that establish the impact and the cause"],
+  "potential_fix": ["what would prevent a recurrence"],
+  "next_checks": ["what to look at if the cause is still unclear"]
+}
+Keep the summary to one or two sentences.
+firing_analyses are what was concluded while the incident was firing; confirm or correct them in the light of how it ended, and say when they disagree.
+If probe_events lists probe state transitions, use their exact times for the impact; they are more precise than the averaged metric snapshots.
+If timezone is present, every timestamp is local time in that zone; give times in it, as the people reading the post-mortem will.`
+
+// buildPostMortemRequest renders the prompt for the post-mortem of the
+// incident a resolved notification closed: its span, the analyses made
+// while it fired and the evidence collected for the resolved notification.
+func buildPostMortemRequest(job analysisJob, record analysisRecord, firing []map[string]any, loc *time.Location, metricsFormat string) (LLMRequest, error) {
+	record = localizeRecord(record, loc)
+	res := record.Resolution
+	payload := map[string]any{
+		"incident": map[string]any{
+			"started_at":  res.StartedAt,
+			"resolved_at": res.ResolvedAt,
+			"duration":    (time.Duration(res.DurationSeconds) * time.Second).String(),
+		},
+		"receiver":           job.Payload.Receiver,
+		"group_key":          job.Payload.GroupKey,
+		"group_labels":       job.Payload.GroupLabels,
+		"common_labels":      job.Payload.CommonLabels,
+		"common_annotations": job.Payload.CommonAnnotations,
+		"alerts":             record.AlertSummaries,
+	}
+	if len(firing) > 0 {
+		payload["firing_analyses"] = firing
+	}
+	if metricsFormat == promptMetricsJSON && len(record.Metrics) > 0 {
+		payload["metric_snapshots"] = record.Metrics
+	}
+	if loc != nil && loc != time.UTC {
+		payload["timezone"] = loc.String()
+	}
+	if len(record.External) > 0 {
+		payload["external_context"] = record.External
+	}
+	if len(record.ProbeEvents) > 0 {
+		payload["probe_events"] = record.ProbeEvents
+	}
+
+	body, err := json.MarshalIndent(payload, "", "  ")
+	if err != nil {
+		return LLMRequest{}, fmt.Errorf("marshal post-mortem payload: %w", err)
+	}
+	userPrompt := "This Grafana alert incident has resolved. Write its post-mortem using only the evidence below.\n\n" + string(body)
+	if metricsFormat != promptMetricsJSON && len(record.Metrics) > 0 {
+		userPrompt += "\n\nMetric snapshots:\n" + metricTable(record.Metrics)
+	}
+
+	return LLMRequest{
+		SystemPrompt: postMortemSystemPrompt,
+		UserPrompt:   userPrompt,
+		MaxTokens:    500,
+		Temperature:  0.2,
+	}, nil
+}
+
+// StoredPrompt is the exact request a provider was sent, kept with its
+// result so an analysis can be reproduced after the prompt template or the
+// metric queries change. When the prompts together exceed
+// PROMPT_COMPRESS_BYTES, each is gzip-compressed and base64-encoded and
+// Encoding is "gzip+base64".
+type StoredPrompt struct {
+	Encoding     string  `json:"encoding,omitempty"`
+	SystemPrompt string  `json:"system_prompt"`
+	UserPrompt   string  `json:"user_prompt"`
+	MaxTokens    int     `json:"max_tokens"`
+	Temperature  float64 `json:"temperature"`
+	// Bytes is the uncompressed size of both prompts.
+	Bytes int `json:"bytes"`
+}
+
+// storePrompt records req, compressing it when it is larger than
+// compressAbove bytes; 0 never compresses.
+func storePrompt(req LLMRequest, compressAbove int) *StoredPrompt {
+	p := &StoredPrompt{
+		SystemPrompt: req.SystemPrompt,
+		UserPrompt:   req.UserPrompt,
+		MaxTokens:    req.MaxTokens,
+		Temperature:  req.Temperature,
+		Bytes:        len(req.SystemPrompt) + len(req.UserPrompt),
+	}
+	if compressAbove <= 0 || p.Bytes <= compressAbove {
+		return p
+	}
+	system, err := gzipBase64(req.SystemPrompt)
+	if err != nil {
+		return p
+	}
+	user, err := gzipBase64(req.UserPrompt)
+	if err != nil {
+		return p
+	}
+	p.Encoding, p.SystemPrompt, p.UserPrompt = "gzip+base64", system, user
+	return p
+}
+
+func gzipBase64(s string) (string, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Provider error classes, stored in ProviderResult.ErrorClass and used as
+// the error_class label of alert_receiver_provider_requests_total.
+const (
+	errorClassAuth          = "auth"           // rejected credentials or permissions
+	errorClassRateLimit     = "rate_limit"     // throttled or out of quota
+	errorClassTimeout       = "timeout"        // LLM_TIMEOUT or a gateway timeout
+	errorClassContextLength = "context_length" // prompt exceeds the model's context
+	errorClassServer        = "server"         // the provider failed (5xx)
+	errorClassOther         = "other"
+)
+
+// maxErrorBodyBytes bounds the raw error body kept in a ProviderResult.
+const maxErrorBodyBytes = 2048
+
+// providerError is a failed provider call. Body is the raw HTTP error
+// body, truncated, when the provider answered with one.
+type providerError struct {
+	Op         string // "openai request failed", "ollama status", ...
+	StatusCode int
+	Body       string
+	Class      string
+	Err        error
+}
+
+func (e *providerError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("%s %d: %s", e.Op, e.StatusCode, e.Body)
+	}
+	return fmt.Sprintf("%s: %v", e.Op, e.Err)
+}
+
+func (e *providerError) Unwrap() error { return e.Err }
+
+// statusError builds the error for a non-2xx provider response.
+func statusError(op string, status int, body []byte) *providerError {
+	text := strings.TrimSpace(string(body))
+	if len(text) > maxErrorBodyBytes {
+		text = text[:maxErrorBodyBytes] + "…"
+	}
+	return &providerError{Op: op, StatusCode: status, Body: text, Class: classifyStatus(status, text)}
+}
+
+// requestError builds the error for a provider call that got no response.
+func requestError(op string, err error) *providerError {
+	return &providerError{Op: op, Class: classifyProviderError(err), Err: err}
+}
+
+// classifyStatus sorts an HTTP error response. Providers report context
+// overflows and exhausted quotas with generic statuses, so the body is
+// checked too.
+func classifyStatus(status int, body string) string {
+	lower := strings.ToLower(body)
+	switch {
+	case strings.Contains(lower, "context_length") || strings.Contains(lower, "context length") ||
+		strings.Contains(lower, "context window") || strings.Contains(lower, "too many tokens") ||
+		strings.Contains(lower, "prompt is too long"):
+		return errorClassContextLength
+	case status == http.StatusTooManyRequests || strings.Contains(lower, "insufficient_quota") ||
+		strings.Contains(lower, "rate limit") || strings.Contains(lower, "rate_limit"):
+		return errorClassRateLimit
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return errorClassAuth
+	case status == http.StatusRequestTimeout || status == http.StatusGatewayTimeout:
+		return errorClassTimeout
+	case status >= 500:
+		return errorClassServer
+	}
+	return errorClassOther
+}
+
+// awsErrorCodes maps Bedrock API error codes to classes.
+var awsErrorCodes = map[string]string{
+	"AccessDeniedException":         errorClassAuth,
+	"UnrecognizedClientException":   errorClassAuth,
+	"ExpiredTokenException":         errorClassAuth,
+	"ThrottlingException":           errorClassRateLimit,
+	"ServiceQuotaExceededException": errorClassRateLimit,
+	"ModelTimeoutException":         errorClassTimeout,
+	"InternalServerException":       errorClassServer,
+	"ServiceUnavailableException":   errorClassServer,
+	"ModelNotReadyException":        errorClassServer,
+	"ModelErrorException":           errorClassServer,
+	"ModelStreamErrorException":     errorClassServer,
+}
+
+// classifyProviderError returns the class of any provider error: the
+// class a providerError carries, a timeout, or a Bedrock API error code.
+func classifyProviderError(err error) string {
+	if err == nil {
+		return ""
+	}
+	var perr *providerError
+	if errors.As(err, &perr) && perr.Class != "" {
+		return perr.Class
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return errorClassTimeout
+	}
+	// Bedrock errors are smithy API errors; match their methods rather
+	// than importing smithy-go.
+	var apiErr interface {
+		ErrorCode() string
+		ErrorMessage() string
+	}
+	if errors.As(err, &apiErr) {
+		class, ok := awsErrorCodes[apiErr.ErrorCode()]
+		if !ok {
+			class = errorClassOther
+		}
+		if apiErr.ErrorCode() == "ValidationException" {
+			if c := classifyStatus(http.StatusBadRequest, apiErr.ErrorMessage()); c == errorClassContextLength {
+				class = c
+			}
+		}
+		return class
+	}
+	return errorClassOther
+}
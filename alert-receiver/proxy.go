@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxProxyQueryBytes bounds a proxied PromQL expression.
+const maxProxyQueryBytes = 4096
+
+// queryAllowList decides which PromQL expressions /proxy/query forwards:
+// the configured enrichment queries (including query packs) verbatim, and
+// anything matching one of the PROXY_QUERY_ALLOW_JSON patterns in full.
+type queryAllowList struct {
+	exact    map[string]bool
+	patterns []*regexp.Regexp
+}
+
+func parseQueryAllowList(raw string, queries []MetricQuery) (queryAllowList, error) {
+	var exprs []string
+	if err := json.Unmarshal([]byte(raw), &exprs); err != nil {
+		return queryAllowList{}, fmt.Errorf("parse PROXY_QUERY_ALLOW_JSON: %w", err)
+	}
+	allow := queryAllowList{exact: make(map[string]bool, len(queries))}
+	for _, q := range queries {
+		allow.exact[q.Query] = true
+	}
+	for _, expr := range exprs {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return queryAllowList{}, fmt.Errorf("PROXY_QUERY_ALLOW_JSON pattern %q: %w", expr, err)
+		}
+		allow.patterns = append(allow.patterns, re)
+	}
+	return allow, nil
+}
+
+func (a queryAllowList) allows(query string) bool {
+	if a.exact[query] {
+		return true
+	}
+	for _, re := range a.patterns {
+		if re.MatchString(query) {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimiter is a token bucket shared by every proxy caller: the limit
+// protects Prometheus, not fairness between clients.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(perSecond float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: perSecond, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+func (l *rateLimiter) allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// handleProxyQuery forwards an allowed instant query to Prometheus with
+// the receiver's own credentials and returns Prometheus' response as is,
+// so callers need neither network access to Prometheus nor its
+// credentials.
+func (s *server) handleProxyQuery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.cfg.ProxyToken != "" && !bearerAuthorized(r, s.cfg.ProxyToken) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="proxy"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("query"))
+	switch {
+	case query == "":
+		http.Error(w, "missing query parameter", http.StatusBadRequest)
+		return
+	case len(query) > maxProxyQueryBytes:
+		http.Error(w, "query too long", http.StatusBadRequest)
+		return
+	case !s.proxyAllow.allows(query):
+		proxyQueriesTotal.WithLabelValues("denied").Inc()
+		http.Error(w, "query not in allow-list", http.StatusForbidden)
+		return
+	case !s.proxyLimiter.allow():
+		proxyQueriesTotal.WithLabelValues("rate_limited").Inc()
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+
+	params := url.Values{}
+	params.Set("query", query)
+	for _, key := range []string{"time", "timeout"} {
+		if v := r.URL.Query().Get(key); v != "" {
+			params.Set(key, v)
+		}
+	}
+	resp, err := s.prom.get(r.Context(), "/api/v1/query", params)
+	if err != nil {
+		proxyQueriesTotal.WithLabelValues("error").Inc()
+		slog.Warn("proxy query failed", "query", query, "error", err)
+		http.Error(w, "query Prometheus failed", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	proxyQueriesTotal.WithLabelValues("forwarded").Inc()
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(w, resp.Body)
+}
+
+func bearerAuthorized(r *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
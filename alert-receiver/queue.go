@@ -0,0 +1,78 @@
+package main
+
+import "context"
+
+// Job queue backends, QUEUE_BACKEND.
+const (
+	queueMemory = "memory"
+	queueRedis  = "redis"
+)
+
+var queueBackends = []string{queueMemory, queueRedis}
+
+// jobQueue holds accepted jobs until a worker takes them: a channel in
+// this process, or a Redis list every replica pushes to and pops from.
+type jobQueue interface {
+	// tryPush queues job unless the queue is full.
+	tryPush(job analysisJob) bool
+	// push waits until there is room for job or ctx is done.
+	push(ctx context.Context, job analysisJob) bool
+	// pop waits for the next job until ctx is done. done must be called
+	// once the job has been processed.
+	pop(ctx context.Context) (job analysisJob, done func(), ok bool)
+	// depth is the number of queued jobs.
+	depth() int
+	// discard empties the queue at shutdown and returns how many jobs
+	// were lost; a queue that outlives the process keeps them.
+	discard() int
+}
+
+// chanQueue is the in-process queue, lost on restart.
+type chanQueue chan analysisJob
+
+func newChanQueue(size int) chanQueue { return make(chanQueue, size) }
+
+func (q chanQueue) tryPush(job analysisJob) bool {
+	select {
+	case q <- job:
+		queueDepthGauge.Inc()
+		return true
+	default:
+		return false
+	}
+}
+
+func (q chanQueue) push(ctx context.Context, job analysisJob) bool {
+	select {
+	case q <- job:
+		queueDepthGauge.Inc()
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (q chanQueue) pop(ctx context.Context) (analysisJob, func(), bool) {
+	select {
+	case <-ctx.Done():
+		return analysisJob{}, nil, false
+	case job := <-q:
+		queueDepthGauge.Dec()
+		return job, func() {}, true
+	}
+}
+
+func (q chanQueue) depth() int { return len(q) }
+
+func (q chanQueue) discard() int {
+	dropped := 0
+	for {
+		select {
+		case <-q:
+			queueDepthGauge.Dec()
+			dropped++
+		default:
+			return dropped
+		}
+	}
+}
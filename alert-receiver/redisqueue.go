@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// redisPopWait is how long a pop blocks in Redis before checking
+	// whether the worker is shutting down.
+	redisPopWait = time.Second
+	// redisPushPoll is how often a blocked push retries a full queue.
+	redisPushPoll = 100 * time.Millisecond
+	// redisHeartbeatInterval is how often a replica renews its heartbeat
+	// and looks for jobs orphaned by replicas whose heartbeat expired
+	// after redisHeartbeatTTL.
+	redisHeartbeatInterval = 10 * time.Second
+	redisHeartbeatTTL      = 3 * redisHeartbeatInterval
+)
+
+// pushIfRoom pushes ARGV[2] onto KEYS[1] unless it already holds ARGV[1]
+// jobs, so JOB_QUEUE_SIZE bounds the queue across replicas.
+var pushIfRoom = redis.NewScript(`
+if redis.call("LLEN", KEYS[1]) >= tonumber(ARGV[1]) then
+  return 0
+end
+return redis.call("LPUSH", KEYS[1], ARGV[2])`)
+
+// redisQueue is a job queue in the Redis list <prefix>queue, so accepted
+// jobs survive a restart and any replica's workers can take them. A popped
+// job moves to the replica's <prefix>processing:<replica> list until it is
+// done; when a replica stops renewing <prefix>replica:<replica>, the others
+// move its unfinished jobs back to the queue.
+type redisQueue struct {
+	client *redis.Client
+	prefix string
+	size   int
+	owner  string
+}
+
+func newRedisQueue(client *redis.Client, prefix string, size int) *redisQueue {
+	return &redisQueue{client: client, prefix: prefix, size: size, owner: replicaID()}
+}
+
+func (q *redisQueue) queueKey() string                  { return q.prefix + "queue" }
+func (q *redisQueue) processingKey(owner string) string { return q.prefix + "processing:" + owner }
+func (q *redisQueue) heartbeatKey(owner string) string  { return q.prefix + "replica:" + owner }
+
+// tryPush queues job unless the queue is full. When Redis cannot be
+// reached the job is refused, so the admission mode decides its fate.
+func (q *redisQueue) tryPush(job analysisJob) bool {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+	n, err := pushIfRoom.Run(ctx, q.client, []string{q.queueKey()}, q.size, data).Int()
+	if err != nil {
+		storeErrorsTotal.WithLabelValues("queue_push").Inc()
+		slog.Warn("failed to queue job in redis", "job_id", job.ID, "error", err)
+		return false
+	}
+	if n > 0 {
+		queueDepthGauge.Set(float64(n))
+	}
+	return n > 0
+}
+
+func (q *redisQueue) push(ctx context.Context, job analysisJob) bool {
+	ticker := time.NewTicker(redisPushPoll)
+	defer ticker.Stop()
+	for {
+		if q.tryPush(job) {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+// pop moves the oldest job to this replica's processing list.
+func (q *redisQueue) pop(ctx context.Context) (analysisJob, func(), bool) {
+	for ctx.Err() == nil {
+		data, err := q.client.BLMove(context.Background(), q.queueKey(), q.processingKey(q.owner), "RIGHT", "LEFT", redisPopWait).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			storeErrorsTotal.WithLabelValues("queue_pop").Inc()
+			slog.Warn("failed to take job from redis", "error", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(redisPopWait):
+			}
+			continue
+		}
+		q.updateDepth()
+		done := func() { q.ack(data) }
+		var job analysisJob
+		if err := json.Unmarshal([]byte(data), &job); err != nil {
+			slog.Error("dropping undecodable job from redis queue", "error", err)
+			done()
+			continue
+		}
+		return job, done, true
+	}
+	return analysisJob{}, nil, false
+}
+
+func (q *redisQueue) ack(data string) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+	if err := q.client.LRem(ctx, q.processingKey(q.owner), 1, data).Err(); err != nil {
+		storeErrorsTotal.WithLabelValues("queue_ack").Inc()
+		slog.Warn("failed to finish job in redis", "error", err)
+	}
+}
+
+func (q *redisQueue) updateDepth() {
+	if n := q.depth(); n >= 0 {
+		queueDepthGauge.Set(float64(n))
+	}
+}
+
+// depth is the length of the shared queue, or -1 when Redis cannot say.
+func (q *redisQueue) depth() int {
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+	n, err := q.client.LLen(ctx, q.queueKey()).Result()
+	if err != nil {
+		return -1
+	}
+	return int(n)
+}
+
+// discard keeps the queued jobs: they are in Redis for the next replica.
+func (q *redisQueue) discard() int { return 0 }
+
+// start renews this replica's heartbeat and requeues the jobs of replicas
+// that stopped, including this one's from before a restart under the same
+// name. main calls it before any worker runs: a worker's job must not be
+// requeued as this replica's leftover, and other replicas must see the
+// heartbeat before it holds a job.
+func (q *redisQueue) start(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, redisOpTimeout)
+	defer cancel()
+	if err := q.beat(ctx); err != nil {
+		return err
+	}
+	q.requeueOrphans(ctx, true)
+	return nil
+}
+
+// runHeartbeat renews this replica's heartbeat and requeues the jobs of
+// replicas that stopped every redisHeartbeatInterval until ctx is
+// cancelled.
+func (q *redisQueue) runHeartbeat(ctx context.Context) error {
+	ticker := time.NewTicker(redisHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			bctx, cancel := context.WithTimeout(ctx, redisOpTimeout)
+			if err := q.beat(bctx); err != nil {
+				storeErrorsTotal.WithLabelValues("queue_heartbeat").Inc()
+				slog.Warn("failed to renew redis queue heartbeat", "error", err)
+			} else {
+				q.requeueOrphans(bctx, false)
+			}
+			cancel()
+		}
+	}
+}
+
+// beat sets this replica's heartbeat for redisHeartbeatTTL.
+func (q *redisQueue) beat(ctx context.Context) error {
+	return q.client.Set(ctx, q.heartbeatKey(q.owner), time.Now().UTC().Format(time.RFC3339), redisHeartbeatTTL).Err()
+}
+
+// requeueOrphans moves the jobs of replicas whose heartbeat expired back
+// to the queue; when starting, this replica's own leftovers as well.
+func (q *redisQueue) requeueOrphans(ctx context.Context, starting bool) {
+	q.updateDepth()
+
+	iter := q.client.Scan(ctx, 0, q.processingKey("*"), 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		owner := strings.TrimPrefix(key, q.processingKey(""))
+		if owner == q.owner && !starting {
+			continue
+		}
+		if owner != q.owner {
+			alive, err := q.client.Exists(ctx, q.heartbeatKey(owner)).Result()
+			if err != nil || alive > 0 {
+				continue
+			}
+		}
+		moved := 0
+		for {
+			err := q.client.LMove(ctx, key, q.queueKey(), "RIGHT", "RIGHT").Err()
+			if err != nil {
+				break
+			}
+			moved++
+		}
+		if moved > 0 {
+			queueRequeuedTotal.Add(float64(moved))
+			slog.Warn("requeued unfinished jobs of a stopped replica", "replica", owner, "jobs", moved)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		storeErrorsTotal.WithLabelValues("queue_heartbeat").Inc()
+		slog.Warn("failed to scan redis processing lists", "error", err)
+	}
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Analysis store backends, ANALYSIS_STORE.
+const (
+	storeLocal    = "local"
+	storeRedis    = "redis"
+	storePostgres = "postgres"
+)
+
+var storeBackends = []string{storeLocal, storeRedis, storePostgres}
+
+// redisConfig is the Redis server replicas share with ANALYSIS_STORE=redis
+// or QUEUE_BACKEND=redis.
+type redisConfig struct {
+	URL       string
+	Password  string
+	KeyPrefix string
+}
+
+// redisTxRetries bounds how often an update is retried after another
+// replica changed the record under it.
+const redisTxRetries = 5
+
+// redisOpTimeout bounds a single store operation.
+const redisOpTimeout = 5 * time.Second
+
+// releaseIfOwner deletes KEYS[1] only while it still holds ARGV[1], so a
+// replica never drops a claim or lock another one has taken since.
+var releaseIfOwner = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+  return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// newRedisClient connects to cfg.URL (redis://[user:password@]host:port/db
+// or rediss:// for TLS) and checks the server answers.
+func newRedisClient(ctx context.Context, cfg redisConfig) (*redis.Client, error) {
+	opts, err := redis.ParseURL(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("REDIS_URL: %w", err)
+	}
+	if cfg.Password != "" {
+		opts.Password = cfg.Password
+	}
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(ctx, redisOpTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("ping %s: %w", opts.Addr, err)
+	}
+	return client, nil
+}
+
+// openRedis connects to Redis when the store or the queue uses it, and
+// returns nil otherwise.
+func openRedis(cfg Config) (*redis.Client, error) {
+	if cfg.AnalysisStore != storeRedis && cfg.QueueBackend != queueRedis {
+		return nil, nil
+	}
+	return newRedisClient(context.Background(), cfg.Redis)
+}
+
+// replicaID names this process in the locks it holds.
+func replicaID() string {
+	host, _ := os.Hostname()
+	return host + "/" + strconv.Itoa(os.Getpid())
+}
+
+// redisStore keeps analyses in Redis so every replica serves and updates
+// the same ones: each record as JSON under <prefix>analysis:<id>, and
+// their IDs in the sorted set <prefix>analyses by completion time.
+type redisStore struct {
+	client    *redis.Client
+	prefix    string
+	max       int
+	retention retentionPolicy
+	owner     string
+}
+
+func newRedisStore(client *redis.Client, prefix string, max int, retention retentionPolicy) *redisStore {
+	return &redisStore{client: client, prefix: prefix, max: max, retention: retention, owner: replicaID()}
+}
+
+func (s *redisStore) recordKey(id string) string { return s.prefix + "analysis:" + id }
+func (s *redisStore) indexKey() string           { return s.prefix + "analyses" }
+
+func (s *redisStore) add(record analysisRecord) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+	data, err := json.Marshal(record)
+	if err == nil {
+		_, err = s.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			p.Set(ctx, s.recordKey(record.ID), data, 0)
+			p.ZAdd(ctx, s.indexKey(), redis.Z{Score: float64(record.CompletedAt.UnixMilli()), Member: record.ID})
+			return nil
+		})
+	}
+	if err == nil {
+		err = s.trim(ctx)
+	}
+	if err != nil {
+		storeErrorsTotal.WithLabelValues("add").Inc()
+		slog.Warn("failed to store analysis in redis", "job_id", record.ID, "error", err)
+	}
+}
+
+// trim drops the oldest records beyond max.
+func (s *redisStore) trim(ctx context.Context) error {
+	n, err := s.client.ZCard(ctx, s.indexKey()).Result()
+	if err != nil || n <= int64(s.max) {
+		return err
+	}
+	ids, err := s.client.ZRange(ctx, s.indexKey(), 0, n-int64(s.max)-1).Result()
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+	return s.remove(ctx, ids)
+}
+
+func (s *redisStore) remove(ctx context.Context, ids []string) error {
+	keys := make([]string, len(ids))
+	members := make([]any, len(ids))
+	for i, id := range ids {
+		keys[i], members[i] = s.recordKey(id), id
+	}
+	_, err := s.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Del(ctx, keys...)
+		p.ZRem(ctx, s.indexKey(), members...)
+		return nil
+	})
+	return err
+}
+
+// update applies fn to the record with id in a WATCH transaction, retrying
+// when another replica changes it first. fn may run more than once.
+func (s *redisStore) update(id string, fn func(*analysisRecord) bool) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+	key := s.recordKey(id)
+	found := false
+	var err error
+	for range redisTxRetries {
+		err = s.client.Watch(ctx, func(tx *redis.Tx) error {
+			data, err := tx.Get(ctx, key).Bytes()
+			if errors.Is(err, redis.Nil) {
+				found = false
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			record, err := decodeAnalysisRecord(data)
+			if err != nil {
+				return err
+			}
+			found = true
+			if !fn(&record) {
+				return nil
+			}
+			if data, err = json.Marshal(record); err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+				p.Set(ctx, key, data, 0)
+				return nil
+			})
+			return err
+		}, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			break
+		}
+	}
+	if err != nil {
+		storeErrorsTotal.WithLabelValues("update").Inc()
+		slog.Warn("failed to update analysis in redis", "job_id", id, "error", err)
+	}
+	return found
+}
+
+func (s *redisStore) get(id string) (analysisRecord, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+	data, err := s.client.Get(ctx, s.recordKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return analysisRecord{}, false
+	}
+	if err == nil {
+		var record analysisRecord
+		if record, err = decodeAnalysisRecord(data); err == nil {
+			return record, true
+		}
+	}
+	storeErrorsTotal.WithLabelValues("get").Inc()
+	slog.Warn("failed to read analysis from redis", "job_id", id, "error", err)
+	return analysisRecord{}, false
+}
+
+// list returns the newest max records, newest first.
+func (s *redisStore) list() []analysisRecord {
+	records, err := s.records(context.Background(), int64(s.max))
+	if err != nil {
+		storeErrorsTotal.WithLabelValues("list").Inc()
+		slog.Warn("failed to list analyses from redis", "error", err)
+	}
+	return records
+}
+
+func (s *redisStore) count() int {
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+	n, err := s.client.ZCard(ctx, s.indexKey()).Result()
+	if err != nil {
+		storeErrorsTotal.WithLabelValues("count").Inc()
+		slog.Warn("failed to count analyses in redis", "error", err)
+	}
+	return int(n)
+}
+
+// search scans the stored records, which trimming keeps to
+// MAX_STORED_ANALYSES.
+func (s *redisStore) search(q searchQuery) ([]analysisRecord, error) {
+	records, err := s.records(context.Background(), -1)
+	if err != nil {
+		storeErrorsTotal.WithLabelValues("search").Inc()
+		if records == nil {
+			return nil, err
+		}
+		slog.Warn("skipped undecodable analyses in redis search", "error", err)
+	}
+	return searchRecords(records, q), nil
+}
+
+// records reads up to n records, newest first; n < 0 reads them all.
+// Records that fail to decode are skipped and reported in the error.
+func (s *redisStore) records(ctx context.Context, n int64) ([]analysisRecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, redisOpTimeout)
+	defer cancel()
+	stop := n - 1
+	if n < 0 {
+		stop = -1
+	}
+	ids, err := s.client.ZRevRange(ctx, s.indexKey(), 0, stop).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = s.recordKey(id)
+	}
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	records := make([]analysisRecord, 0, len(values))
+	for i, v := range values {
+		data, ok := v.(string)
+		if !ok {
+			continue // removed since the range was read
+		}
+		record, err := decodeAnalysisRecord([]byte(data))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("record %s: %w", ids[i], err))
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, errors.Join(errs...)
+}
+
+// tryLock takes the named lock for ttl unless another replica holds it.
+func (s *redisStore) tryLock(ctx context.Context, name string, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, s.prefix+"lock:"+name, s.owner, ttl).Result()
+}
+
+// runRetention applies the retention policy every retentionInterval until
+// ctx is cancelled. The replicas take turns through a lock, so each
+// interval's pass runs once.
+func (s *redisStore) runRetention(ctx context.Context) error {
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			ok, err := s.tryLock(ctx, "retention", retentionInterval/2)
+			if err != nil {
+				storeErrorsTotal.WithLabelValues("retention").Inc()
+				slog.Warn("failed to take the redis retention lock", "error", err)
+				continue
+			}
+			if ok {
+				s.applyRetention(ctx, now)
+			}
+		}
+	}
+}
+
+// applyRetention expires and compacts records by age.
+func (s *redisStore) applyRetention(ctx context.Context, now time.Time) {
+	records, err := s.records(ctx, -1)
+	if err != nil {
+		storeErrorsTotal.WithLabelValues("retention").Inc()
+		slog.Warn("failed to read analyses for retention", "error", err)
+	}
+	var expired []string
+	compacted := 0
+	for _, record := range records {
+		age := now.Sub(record.CompletedAt)
+		if keep := s.retention.retention(record); keep > 0 && age > keep {
+			expired = append(expired, record.ID)
+			continue
+		}
+		if s.retention.compactAfter > 0 && age > s.retention.compactAfter && !record.Compacted {
+			s.update(record.ID, compactRecord)
+			compacted++
+		}
+	}
+	if len(expired) > 0 {
+		rctx, cancel := context.WithTimeout(ctx, redisOpTimeout)
+		defer cancel()
+		if err := s.remove(rctx, expired); err != nil {
+			storeErrorsTotal.WithLabelValues("retention").Inc()
+			slog.Warn("failed to expire analyses in redis", "error", err)
+			return
+		}
+	}
+	if len(expired) == 0 && compacted == 0 {
+		return
+	}
+	analysisRetentionTotal.WithLabelValues("expired").Add(float64(len(expired)))
+	analysisRetentionTotal.WithLabelValues("compacted").Add(float64(compacted))
+	slog.Info("applied analysis retention", "backend", storeRedis, "expired", len(expired), "compacted", compacted)
+}
+
+// redisIdempotency is the idempotency cache shared by every replica: a
+// retried delivery is recognized whichever replica it reaches.
+type redisIdempotency struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+}
+
+func (c *redisIdempotency) key(key string) string { return c.prefix + "idempotency:" + key }
+
+// claim takes key for jobID with SET NX. A failed Redis call admits the
+// delivery: a possible duplicate analysis beats a dropped alert.
+func (c *redisIdempotency) claim(key, jobID string, _ time.Time) (string, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+	for range 2 {
+		ok, err := c.client.SetNX(ctx, c.key(key), jobID, c.ttl).Result()
+		if err != nil {
+			storeErrorsTotal.WithLabelValues("idempotency").Inc()
+			slog.Warn("failed to claim idempotency key in redis", "job_id", jobID, "error", err)
+			return "", false
+		}
+		if ok {
+			return "", false
+		}
+		existing, err := c.client.Get(ctx, c.key(key)).Result()
+		if err == nil {
+			return existing, true
+		}
+		if !errors.Is(err, redis.Nil) {
+			storeErrorsTotal.WithLabelValues("idempotency").Inc()
+			return "", false
+		}
+		// Expired between SET and GET: claim it again.
+	}
+	return "", false
+}
+
+func (c *redisIdempotency) release(key, jobID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+	if err := releaseIfOwner.Run(ctx, c.client, []string{c.key(key)}, jobID).Err(); err != nil {
+		storeErrorsTotal.WithLabelValues("idempotency").Inc()
+		slog.Warn("failed to release idempotency key in redis", "job_id", jobID, "error", err)
+	}
+}
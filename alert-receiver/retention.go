@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// retentionInterval is how often the store expires and compacts records.
+const retentionInterval = 10 * time.Minute
+
+// retentionPolicy decides how long a stored analysis is kept, from the
+// severity label of its alerts, and when its bulky parts are dropped.
+type retentionPolicy struct {
+	bySeverity map[string]time.Duration
+	// fallback applies to severities not listed, and to alerts without one.
+	fallback time.Duration
+	// compactAfter is the age after which raw responses, stored prompts
+	// and metric series are dropped; 0 never compacts.
+	compactAfter time.Duration
+}
+
+// parseRetention parses ANALYSIS_RETENTION: comma-separated severity=age
+// pairs, where age is a number of days ("90d") or a Go duration, and the
+// severity "default" covers everything else.
+func parseRetention(raw string) (retentionPolicy, error) {
+	p := retentionPolicy{bySeverity: make(map[string]time.Duration)}
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		severity, age, ok := strings.Cut(part, "=")
+		if !ok {
+			return retentionPolicy{}, fmt.Errorf("%q: want severity=age", part)
+		}
+		d, err := parseAge(strings.TrimSpace(age))
+		if err != nil {
+			return retentionPolicy{}, fmt.Errorf("%q: %w", part, err)
+		}
+		severity = strings.ToLower(strings.TrimSpace(severity))
+		if severity == "default" {
+			p.fallback = d
+		} else {
+			p.bySeverity[severity] = d
+		}
+	}
+	return p, nil
+}
+
+// parseAge reads "7d" as days and anything else as a Go duration.
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("want a positive number of days")
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("want an age such as 7d or 12h")
+	}
+	return d, nil
+}
+
+// retention returns how long record is kept: the longest retention of the
+// severities among its alerts, so a group is kept as long as its most
+// serious alert. 0 means forever.
+func (p retentionPolicy) retention(record analysisRecord) time.Duration {
+	severities := make(map[string]bool)
+	if s := record.CommonLabels["severity"]; s != "" {
+		severities[strings.ToLower(s)] = true
+	}
+	for _, alert := range record.AlertSummaries {
+		if s := alert.Labels["severity"]; s != "" {
+			severities[strings.ToLower(s)] = true
+		}
+	}
+	var keep time.Duration
+	matched := false
+	for s := range severities {
+		if d, ok := p.bySeverity[s]; ok {
+			keep, matched = max(keep, d), true
+		}
+	}
+	if !matched {
+		return p.fallback
+	}
+	return keep
+}
+
+// compactRecord drops what an old record no longer needs: raw responses
+// that were parsed into a structured analysis, stored prompts and metric
+// series. Summaries, parsed analyses and errors stay. It reports whether
+// anything changed. The slices are copied first: list() callers may still
+// be encoding the old ones.
+func compactRecord(record *analysisRecord) bool {
+	if record.Compacted {
+		return false
+	}
+	record.Providers = slices.Clone(record.Providers)
+	record.Metrics = slices.Clone(record.Metrics)
+	for i := range record.Providers {
+		if record.Providers[i].Parsed != nil {
+			record.Providers[i].Response = ""
+		}
+		record.Providers[i].Prompt = nil
+	}
+	for i := range record.Metrics {
+		record.Metrics[i].Series = nil
+	}
+	record.Compacted = true
+	return true
+}
+
+// applyRetention expires and compacts records by age.
+func (s *analysisStore) applyRetention(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expired, compacted := 0, 0
+	kept := s.items[:0]
+	for _, record := range s.items {
+		age := now.Sub(record.CompletedAt)
+		if keep := s.retention.retention(record); keep > 0 && age > keep {
+			expired++
+			continue
+		}
+		if s.retention.compactAfter > 0 && age > s.retention.compactAfter && compactRecord(&record) {
+			compacted++
+		}
+		kept = append(kept, record)
+	}
+	clear(s.items[len(kept):])
+	s.items = kept
+	if expired == 0 && compacted == 0 {
+		return
+	}
+
+	analysisRetentionTotal.WithLabelValues("expired").Add(float64(expired))
+	analysisRetentionTotal.WithLabelValues("compacted").Add(float64(compacted))
+	slog.Info("applied analysis retention", "expired", expired, "compacted", compacted, "remaining", len(s.items))
+	if err := s.save(); err != nil {
+		slog.Warn("failed to persist analyses", "path", s.path, "error", err)
+	}
+}
+
+// runRetention applies the retention policy every retentionInterval until
+// ctx is cancelled.
+func (s *analysisStore) runRetention(ctx context.Context) error {
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			s.applyRetention(now)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RuleThresholds are the limits the generated alert rules fire at.
+type RuleThresholds struct {
+	JitterMS   float64 `json:"jitter_ms,omitempty"`
+	LossRatio  float64 `json:"loss_ratio,omitempty"`
+	LossBursts float64 `json:"loss_bursts,omitempty"`
+}
+
+// alertRule is one Prometheus alerting rule.
+type alertRule struct {
+	alert       string
+	expr        string
+	forDuration time.Duration
+	labels      map[string]string
+	annotations map[string]string
+}
+
+// handleRules serves a Prometheus rule file covering WAN and gateway
+// outages, jitter, loss and loss bursts, DNS failures and failing probe
+// targets. Thresholds come from RULES_* settings, with per-target
+// overrides; targets are the ones Prometheus has series for. Grafana
+// imports the same file as data-source-managed rules.
+func (s *server) handleRules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	targets := func(metric string) []string {
+		values, err := s.prom.labelValues(r.Context(), "target", metric)
+		if err != nil {
+			slog.Warn("rules: target discovery failed", "metric", metric, "error", err)
+		}
+		return values
+	}
+	rules := buildAlertRules(s.cfg.RuleThresholds, s.cfg.RuleTargetThresholds, targets(`network_jitter_ms{job="jitter-probe"}`))
+
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Header().Set("Content-Disposition", `attachment; filename="edge-monitor-rules.yaml"`)
+	fmt.Fprint(w, renderRules("edge-monitor", rules))
+}
+
+// buildAlertRules returns the rule pack. Jitter and loss get one rule for
+// the targets on the defaults and one per target with its own thresholds.
+func buildAlertRules(defaults RuleThresholds, perTarget map[string]RuleThresholds, jitterTargets []string) []alertRule {
+	rules := []alertRule{
+		{
+			alert:       "GatewayDown",
+			expr:        `gateway_reachable{job="gateway-monitor"} == 0`,
+			forDuration: time.Minute,
+			labels:      map[string]string{"severity": "critical", "failure_domain": "lan"},
+			annotations: map[string]string{
+				"summary":     "The router does not answer",
+				"description": "gateway-monitor has not reached the default gateway for a minute; the LAN side or the router itself is down.",
+			},
+		},
+		{
+			alert:       "WANDown",
+			expr:        `wan_reachable{job="gateway-monitor"} == 0 and on() gateway_reachable{job="gateway-monitor"} == 1`,
+			forDuration: time.Minute,
+			labels:      map[string]string{"severity": "critical", "failure_domain": "wan"},
+			annotations: map[string]string{
+				"summary":     "Internet unreachable while the router is up",
+				"description": "The gateway answers but no WAN target does: the ISP link or upstream is down.",
+			},
+		},
+	}
+
+	var overridden []string
+	for target := range perTarget {
+		if slices.Contains(jitterTargets, target) || len(jitterTargets) == 0 {
+			overridden = append(overridden, target)
+		}
+	}
+	sort.Strings(overridden)
+	rules = append(rules, jitterRules("", defaults, overridden)...)
+	for _, target := range overridden {
+		rules = append(rules, jitterRules(target, mergeThresholds(defaults, perTarget[target]), nil)...)
+	}
+
+	rules = append(rules,
+		alertRule{
+			alert:       "DNSFailure",
+			expr:        unlessMaintenance(`min by (target, resolver) (dns_probe_up{job="dns-probe"}) == 0`, "dns-probe"),
+			forDuration: 2 * time.Minute,
+			labels:      map[string]string{"severity": "warning", "failure_domain": "dns"},
+			annotations: map[string]string{
+				"summary":     "DNS lookups of {{ $labels.target }} via {{ $labels.resolver }} failing",
+				"description": "Every DNS probe of {{ $labels.target }} through {{ $labels.resolver }} has failed for 2 minutes.",
+			},
+		},
+		alertRule{
+			alert:       "ProbeTargetDown",
+			expr:        unlessMaintenance(`max by (target) (wifi_probe_up{job="wifi-probe"}) == 0`, "wifi-probe"),
+			forDuration: 5 * time.Minute,
+			labels:      map[string]string{"severity": "warning"},
+			annotations: map[string]string{
+				"summary":     "{{ $labels.target }} unreachable",
+				"description": "No wifi-probe check of {{ $labels.target }} has succeeded for 5 minutes.",
+			},
+		},
+	)
+	return rules
+}
+
+// jitterRules returns the jitter, loss and loss burst rules for target, or
+// for every target except the excluded ones when target is "".
+func jitterRules(target string, t RuleThresholds, exclude []string) []alertRule {
+	sel := `job="jitter-probe"`
+	suffix := ""
+	switch {
+	case target != "":
+		sel += fmt.Sprintf(`,target=%q`, target)
+		suffix = " (" + target + ")"
+	case len(exclude) > 0:
+		quoted := make([]string, len(exclude))
+		for i, e := range exclude {
+			quoted[i] = regexp.QuoteMeta(e)
+		}
+		sel += fmt.Sprintf(`,target!~%q`, strings.Join(quoted, "|"))
+	}
+	format := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	return []alertRule{
+		{
+			alert:       "HighJitter",
+			expr:        unlessMaintenance(fmt.Sprintf("avg_over_time(network_jitter_ms{%s}[5m]) > %s", sel, format(t.JitterMS)), "jitter-probe"),
+			forDuration: 5 * time.Minute,
+			labels:      map[string]string{"severity": "warning"},
+			annotations: map[string]string{
+				"summary":     "Jitter to {{ $labels.target }} above " + format(t.JitterMS) + " ms" + suffix,
+				"description": "Average jitter to {{ $labels.target }} over 5 minutes is {{ $value | printf \"%.1f\" }} ms; calls and games suffer.",
+			},
+		},
+		{
+			alert:       "PacketLoss",
+			expr:        unlessMaintenance(fmt.Sprintf("packet_loss_ratio{%s} > %s", sel, format(t.LossRatio)), "jitter-probe"),
+			forDuration: 5 * time.Minute,
+			labels:      map[string]string{"severity": "warning"},
+			annotations: map[string]string{
+				"summary":     "Packet loss to {{ $labels.target }} above " + format(t.LossRatio*100) + "%" + suffix,
+				"description": "{{ $value | humanizePercentage }} of probes to {{ $labels.target }} are lost.",
+			},
+		},
+		{
+			alert:  "PacketLossBursts",
+			expr:   unlessMaintenance(fmt.Sprintf("increase(packet_loss_burst_total{%s}[10m]) > %s", sel, format(t.LossBursts)), "jitter-probe"),
+			labels: map[string]string{"severity": "warning"},
+			annotations: map[string]string{
+				"summary":     "Repeated loss bursts to {{ $labels.target }}" + suffix,
+				"description": "{{ $value | printf \"%.0f\" }} loss bursts to {{ $labels.target }} in 10 minutes: the link drops out rather than degrading evenly.",
+			},
+		},
+	}
+}
+
+// unlessMaintenance keeps expr from firing for targets service reports in
+// a maintenance window (MAINTENANCE_WINDOWS_JSON).
+func unlessMaintenance(expr, service string) string {
+	return fmt.Sprintf(`%s unless on(target) maintenance_active{service=%q} == 1`, expr, service)
+}
+
+// mergeThresholds fills the thresholds a target override leaves unset.
+func mergeThresholds(defaults, override RuleThresholds) RuleThresholds {
+	if override.JitterMS == 0 {
+		override.JitterMS = defaults.JitterMS
+	}
+	if override.LossRatio == 0 {
+		override.LossRatio = defaults.LossRatio
+	}
+	if override.LossBursts == 0 {
+		override.LossBursts = defaults.LossBursts
+	}
+	return override
+}
+
+func parseRuleTargetThresholds(raw string) (map[string]RuleThresholds, error) {
+	thresholds := make(map[string]RuleThresholds)
+	if err := json.Unmarshal([]byte(raw), &thresholds); err != nil {
+		return nil, fmt.Errorf("parse RULES_TARGET_THRESHOLDS_JSON: %w", err)
+	}
+	return thresholds, nil
+}
+
+// renderRules writes rules as a Prometheus rule file. Strings are YAML
+// double-quoted scalars, which strconv.Quote produces for this content.
+func renderRules(group string, rules []alertRule) string {
+	var b strings.Builder
+	b.WriteString("# Generated by alert-receiver (GET /prometheus/rules).\n")
+	b.WriteString("groups:\n")
+	fmt.Fprintf(&b, "  - name: %s\n    rules:\n", strconv.Quote(group))
+	for _, r := range rules {
+		fmt.Fprintf(&b, "      - alert: %s\n", r.alert)
+		fmt.Fprintf(&b, "        expr: %s\n", strconv.Quote(r.expr))
+		if r.forDuration > 0 {
+			fmt.Fprintf(&b, "        for: %s\n", promDuration(r.forDuration))
+		}
+		writeMap(&b, "labels", r.labels)
+		writeMap(&b, "annotations", r.annotations)
+	}
+	return b.String()
+}
+
+func writeMap(b *strings.Builder, name string, m map[string]string) {
+	if len(m) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	fmt.Fprintf(b, "        %s:\n", name)
+	for _, k := range keys {
+		fmt.Fprintf(b, "          %s: %s\n", k, strconv.Quote(m[k]))
+	}
+}
+
+// labelValues returns the values of label on the series matching match.
+func (p *PrometheusClient) labelValues(ctx context.Context, label, match string) ([]string, error) {
+	params := url.Values{}
+	params.Set("match[]", match)
+	var values []string
+	err := p.apiGet(ctx, "/api/v1/label/"+label+"/values", params, &values)
+	return values, err
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// searchQuery filters GET /analyses/search. Every set field must match.
+type searchQuery struct {
+	// Text is matched against searchText: with the Postgres store as a
+	// websearch_to_tsquery, otherwise as words that must all appear.
+	Text   string
+	Status string
+	// Labels must all be among the record's common labels.
+	Labels map[string]string
+	Since  time.Time
+	Limit  int
+}
+
+// handleSearch answers GET /analyses/search?q=...&status=...&label=k=v&since=...&limit=...
+// since is an RFC 3339 time or a duration back from now.
+func (s *server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q := searchQuery{
+		Text:   strings.TrimSpace(params.Get("q")),
+		Status: params.Get("status"),
+		Labels: map[string]string{},
+		Limit:  defaultSearchLimit,
+	}
+	for _, l := range params["label"] {
+		name, value, ok := strings.Cut(l, "=")
+		if !ok || name == "" {
+			http.Error(w, "label must be name=value", http.StatusBadRequest)
+			return
+		}
+		q.Labels[name] = value
+	}
+	if since := params.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			d, derr := time.ParseDuration(since)
+			if derr != nil || d <= 0 {
+				http.Error(w, "since must be an RFC 3339 time or a positive duration", http.StatusBadRequest)
+				return
+			}
+			t = time.Now().Add(-d)
+		}
+		q.Since = t.UTC()
+	}
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			http.Error(w, "limit must be 1 to "+strconv.Itoa(maxSearchLimit), http.StatusBadRequest)
+			return
+		}
+		q.Limit = n
+	}
+
+	items, err := s.store.search(q)
+	if err != nil {
+		http.Error(w, "search analyses: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for i := range items {
+		items[i] = localizeRecord(items[i], s.cfg.DisplayLocation)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"items": items,
+	})
+}
+
+// searchRecords filters records, newest first, for the stores that hold
+// no more than MAX_STORED_ANALYSES and so can scan them all.
+func searchRecords(records []analysisRecord, q searchQuery) []analysisRecord {
+	terms := strings.Fields(strings.ToLower(q.Text))
+	out := []analysisRecord{}
+	for _, record := range records {
+		if len(out) == q.Limit {
+			break
+		}
+		if q.Status != "" && record.AlertStatus != q.Status {
+			continue
+		}
+		if !q.Since.IsZero() && record.CompletedAt.Before(q.Since) {
+			continue
+		}
+		if !labelsMatch(record.CommonLabels, q.Labels) {
+			continue
+		}
+		if len(terms) > 0 {
+			text := strings.ToLower(searchText(record))
+			if !slices.ContainsFunc(terms, func(t string) bool { return !strings.Contains(text, t) }) {
+				out = append(out, record)
+			}
+			continue
+		}
+		out = append(out, record)
+	}
+	return out
+}
+
+func labelsMatch(labels, want map[string]string) bool {
+	for name, value := range want {
+		if v, ok := labels[name]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// searchText is what full-text search matches a record on: its title and
+// alerts, the providers' analyses and raw responses, the rule verdict and
+// errors.
+func searchText(record analysisRecord) string {
+	parts := []string{recordTitle(record), record.CommonAnnots["summary"], record.CommonAnnots["description"], record.Error}
+	for _, a := range record.AlertSummaries {
+		parts = append(parts, a.Labels["alertname"], a.Annotations["summary"], a.Annotations["description"])
+	}
+	if h := record.Heuristic; h != nil {
+		parts = append(parts, h.Verdict)
+	}
+	for _, p := range record.Providers {
+		parts = append(parts, p.Response, p.Error)
+		if a := p.Parsed; a != nil {
+			parts = append(parts, a.Summary, a.LikelyIssue)
+			parts = append(parts, a.Evidence...)
+			parts = append(parts, a.PotentialFix...)
+			parts = append(parts, a.NextChecks...)
+		}
+	}
+	return strings.Join(slices.DeleteFunc(parts, func(s string) bool { return s == "" }), "\n")
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"edge-monitor-app/internal/config"
+)
+
+// selftestMaxTokens keeps the provider check to a tiny completion.
+const selftestMaxTokens = 16
+
+// selftestCheck is one line of the -selftest report.
+type selftestCheck struct {
+	Name       string `json:"name"`
+	OK         bool   `json:"ok"`
+	DurationMS int64  `json:"duration_ms"`
+	Detail     string `json:"detail,omitempty"`
+	Error      string `json:"error,omitempty"`
+	ErrorClass string `json:"error_class,omitempty"`
+}
+
+// selftestReport is what -selftest prints as JSON.
+type selftestReport struct {
+	OK          bool            `json:"ok"`
+	GeneratedAt time.Time       `json:"generated_at"`
+	Checks      []selftestCheck `json:"checks"`
+}
+
+// runSelftest validates the configuration, queries Prometheus and asks
+// every LLM backend for a tiny completion, then writes the report to w. It
+// returns the process exit code: 1 when any check failed. Notification
+// sinks are only validated, never sent to.
+func runSelftest(ctx context.Context, w io.Writer) int {
+	report := selftestReport{GeneratedAt: time.Now().UTC()}
+
+	start := time.Now()
+	cfg, providers, err := selftestConfig()
+	check := selftestCheck{Name: "config", OK: err == nil, DurationMS: time.Since(start).Milliseconds()}
+	if err != nil {
+		check.Error = err.Error()
+	}
+	report.Checks = append(report.Checks, check)
+
+	if err == nil {
+		report.Checks = append(report.Checks, selftestPrometheus(ctx, cfg))
+		report.Checks = append(report.Checks, selftestProviders(ctx, cfg, providers)...)
+	}
+
+	report.OK = true
+	for _, c := range report.Checks {
+		report.OK = report.OK && c.OK
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(report)
+	if !report.OK {
+		return 1
+	}
+	return 0
+}
+
+// selftestConfig loads the configuration the way main does and builds
+// everything that can fail on it.
+func selftestConfig() (Config, []LLMProvider, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return Config{}, nil, err
+	}
+	providers, err := buildProviders(cfg.Backends)
+	if err != nil {
+		return Config{}, nil, err
+	}
+	if _, err := buildFetchers(cfg.ExternalSources, cfg.ExternalContextMatch, cfg.ExternalContextTimeout); err != nil {
+		return Config{}, nil, err
+	}
+	if _, err := buildNotifiers(cfg.NotifySinks, cfg.NotifyTimeout, cfg.SMTP, cfg.DisplayLocation); err != nil {
+		return Config{}, nil, err
+	}
+	if err := config.Err(); err != nil {
+		return Config{}, nil, err
+	}
+	return cfg, providers, nil
+}
+
+func selftestPrometheus(ctx context.Context, cfg Config) selftestCheck {
+	check := selftestCheck{Name: "prometheus", Detail: cfg.PrometheusURL}
+	prom := NewPrometheusClient(cfg.PrometheusURL, cfg.PrometheusTimeout, cfg.PrometheusAuth)
+	start := time.Now()
+	snapshot, err := prom.InstantQuery(ctx, MetricQuery{Name: "selftest", Query: "vector(1)"}, time.Now())
+	check.DurationMS = time.Since(start).Milliseconds()
+	if err == nil && len(snapshot.Series) == 0 {
+		err = errors.New("query vector(1) returned no series")
+	}
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	check.OK = true
+	return check
+}
+
+// selftestProviders asks every backend, in parallel, for a short reply
+// within LLM_TIMEOUT.
+func selftestProviders(ctx context.Context, cfg Config, providers []LLMProvider) []selftestCheck {
+	if len(providers) == 0 {
+		return []selftestCheck{{Name: "providers", OK: true, Detail: "no LLM backends configured"}}
+	}
+	checks := make([]selftestCheck, len(providers))
+	var wg sync.WaitGroup
+	for i, provider := range providers {
+		wg.Add(1)
+		go func(idx int, provider LLMProvider) {
+			defer wg.Done()
+			check := selftestCheck{Name: "provider " + provider.Name(), Detail: provider.Type() + " " + provider.Model()}
+			req := provider.PrepareRequest(LLMRequest{})
+			req.SystemPrompt = "You are a health check. Reply with the single word OK."
+			req.UserPrompt = "ping"
+			req.MaxTokens = selftestMaxTokens
+
+			pctx, cancel := context.WithTimeout(ctx, cfg.LLMTimeout)
+			defer cancel()
+			start := time.Now()
+			response, err := provider.Complete(pctx, req)
+			check.DurationMS = time.Since(start).Milliseconds()
+			switch {
+			case err != nil:
+				check.Error = err.Error()
+				check.ErrorClass = classifyProviderError(err)
+			case response == "":
+				check.Error = "empty completion"
+			default:
+				check.OK = true
+			}
+			checks[idx] = check
+		}(i, provider)
+	}
+	wg.Wait()
+	return checks
+}
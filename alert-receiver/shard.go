@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Metric query scopes, MetricQuery.Scope.
+const (
+	// scopeInstance queries run once per affected instance when an alert
+	// group covers several.
+	scopeInstance = "instance"
+	// scopeGlobal queries always run once, for metrics whose instance
+	// label does not name the alerting host (node-exporter's, say).
+	scopeGlobal = "global"
+)
+
+var metricScopes = []string{"", scopeInstance, scopeGlobal}
+
+func validateMetricScopes(queries []MetricQuery) error {
+	for _, q := range queries {
+		if !slices.Contains(metricScopes, q.Scope) {
+			return fmt.Errorf("metric query %q: scope must be %s or %s, not %q", q.Name, scopeInstance, scopeGlobal, q.Scope)
+		}
+	}
+	return nil
+}
+
+// alertInstances returns the distinct values of label among the alerts,
+// sorted.
+func alertInstances(payload GrafanaWebhookPayload, label string) []string {
+	var instances []string
+	for _, alert := range payload.Alerts {
+		if v := alert.Labels[label]; v != "" && !slices.Contains(instances, v) {
+			instances = append(instances, v)
+		}
+	}
+	slices.Sort(instances)
+	return instances
+}
+
+// scopeQuery adds the matcher label="value" to every selector in query
+// that has braces. It reports false when there is none, since a bare
+// metric name cannot be scoped without parsing PromQL.
+func scopeQuery(query, label, value string) (string, bool) {
+	matcher := label + "=" + strconv.Quote(value)
+	var b strings.Builder
+	scoped := false
+	var quote rune
+	escaped := false
+	runes := []rune(query)
+	for i, r := range runes {
+		b.WriteRune(r)
+		switch {
+		case quote != 0:
+			switch {
+			case escaped:
+				escaped = false
+			case r == '\\' && quote != '`':
+				escaped = true
+			case r == quote:
+				quote = 0
+			}
+		case r == '"' || r == '\'' || r == '`':
+			quote = r
+		case r == '{':
+			b.WriteString(matcher)
+			if next := nextNonSpace(runes[i+1:]); next != '}' {
+				b.WriteByte(',')
+			}
+			scoped = true
+		}
+	}
+	return b.String(), scoped
+}
+
+func nextNonSpace(runes []rune) rune {
+	for _, r := range runes {
+		if r != ' ' && r != '\t' && r != '\n' {
+			return r
+		}
+	}
+	return 0
+}
+
+// instanceAlerts returns the names of the alerts for instance.
+func instanceAlerts(alerts []alertSummary, label, instance string) []string {
+	var names []string
+	for _, a := range alerts {
+		if a.Labels[label] == instance && !slices.Contains(names, a.Labels["alertname"]) {
+			names = append(names, a.Labels["alertname"])
+		}
+	}
+	return names
+}
+
+// instanceMetrics is the part of a prompt about one instance of a sharded
+// alert group.
+type instanceMetrics struct {
+	Instance string           `json:"instance"`
+	Alerts   []string         `json:"alerts,omitempty"`
+	Metrics  []MetricSnapshot `json:"metric_snapshots"`
+}
+
+// groupByInstance splits snapshots into those of every instance and those
+// scoped to one, per instance in the order they were collected.
+func groupByInstance(snapshots []MetricSnapshot, alerts []alertSummary, label string) ([]MetricSnapshot, []instanceMetrics) {
+	var global []MetricSnapshot
+	var instances []instanceMetrics
+	for _, s := range snapshots {
+		if s.Instance == "" {
+			global = append(global, s)
+			continue
+		}
+		i := slices.IndexFunc(instances, func(m instanceMetrics) bool { return m.Instance == s.Instance })
+		if i < 0 {
+			instances = append(instances, instanceMetrics{Instance: s.Instance, Alerts: instanceAlerts(alerts, label, s.Instance)})
+			i = len(instances) - 1
+		}
+		instances[i].Metrics = append(instances[i].Metrics, s)
+	}
+	return global, instances
+}
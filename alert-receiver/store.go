@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// analysisSchemaVersion is the version of analysisRecord's JSON, written
+// into every record as schema_version.
+//
+// Within a version, fields are only ever added, and only as optional
+// fields that older consumers can ignore; nothing is renamed, removed or
+// given a new meaning. Any such change bumps the version and adds a
+// migration from the previous one, so records persisted by an older
+// alert-receiver still load. Consumers should ignore fields they do not
+// know and check schema_version before relying on a field's meaning.
+const analysisSchemaVersion = 1
+
+// recordMigrations[v] upgrades a stored record from schema version v to
+// v+1. Records are migrated in their raw JSON form, so a migration can
+// still read a field the current analysisRecord no longer has.
+var recordMigrations = map[int]func(map[string]json.RawMessage) error{
+	// Version 0 is every record written before schema_version existed.
+	// Version 1 only introduced the field itself.
+	0: func(map[string]json.RawMessage) error { return nil },
+}
+
+// decodeAnalysisRecord reads a stored record, migrating it to the current
+// schema version. A record from a newer version is rejected rather than
+// read with fields this build would silently drop on the next write.
+func decodeAnalysisRecord(data []byte) (analysisRecord, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return analysisRecord{}, err
+	}
+	version := 0
+	if v, ok := raw["schema_version"]; ok {
+		if err := json.Unmarshal(v, &version); err != nil {
+			return analysisRecord{}, fmt.Errorf("schema_version: %w", err)
+		}
+	}
+	if version > analysisSchemaVersion {
+		return analysisRecord{}, fmt.Errorf("schema version %d is newer than this build's %d", version, analysisSchemaVersion)
+	}
+	for ; version < analysisSchemaVersion; version++ {
+		migrate, ok := recordMigrations[version]
+		if !ok {
+			return analysisRecord{}, fmt.Errorf("no migration from schema version %d", version)
+		}
+		if err := migrate(raw); err != nil {
+			return analysisRecord{}, fmt.Errorf("migrate from schema version %d: %w", version, err)
+		}
+	}
+	raw["schema_version"], _ = json.Marshal(analysisSchemaVersion)
+
+	migrated, err := json.Marshal(raw)
+	if err != nil {
+		return analysisRecord{}, err
+	}
+	var record analysisRecord
+	if err := json.Unmarshal(migrated, &record); err != nil {
+		return analysisRecord{}, err
+	}
+	return record, nil
+}
+
+// analysisStorage is where analyses are kept: an analysisStore local to
+// this replica, or a redisStore or pgStore shared by all of them
+// (ANALYSIS_STORE).
+type analysisStorage interface {
+	add(record analysisRecord)
+	// update applies fn to the record with id and saves it when fn
+	// reports a change. It returns false when no record has id.
+	update(id string, fn func(*analysisRecord) bool) bool
+	get(id string) (analysisRecord, bool)
+	// list returns the stored records, newest first.
+	list() []analysisRecord
+	// count returns how many records are stored, without reading them.
+	count() int
+	// search returns up to q.Limit records matching q, best match first.
+	search(q searchQuery) ([]analysisRecord, error)
+	runRetention(ctx context.Context) error
+}
+
+// analysisStore keeps the most recent analyses, newest first, up to max
+// and within its retention policy. With a path it also persists them, so
+// they survive a restart.
+type analysisStore struct {
+	max       int
+	path      string
+	retention retentionPolicy
+	items     []analysisRecord
+	mu        sync.RWMutex
+}
+
+func newAnalysisStore(max int) *analysisStore {
+	return &analysisStore{max: max}
+}
+
+// openAnalysisStore returns a store persisted to path, loading and
+// migrating the records already there. An empty path keeps analyses in
+// memory only.
+func openAnalysisStore(max int, path string, retention retentionPolicy) (*analysisStore, error) {
+	s := newAnalysisStore(max)
+	s.retention = retention
+	if path == "" {
+		return s, nil
+	}
+	s.path = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for i, r := range raw {
+		record, err := decodeAnalysisRecord(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: record %d: %w", path, i, err)
+		}
+		s.items = append(s.items, record)
+	}
+	if len(s.items) > s.max {
+		s.items = s.items[:s.max]
+	}
+	slog.Info("loaded stored analyses", "path", path, "records", len(s.items))
+	s.applyRetention(time.Now())
+	return s, nil
+}
+
+// openStorage opens the ANALYSIS_STORE backend and the idempotency cache
+// that goes with it: with Redis, retried deliveries are recognized by
+// whichever replica they reach.
+func openStorage(cfg Config, client *redis.Client) (analysisStorage, idempotencyClaimer, error) {
+	if cfg.AnalysisStore == storePostgres {
+		store, err := openPgStore(context.Background(), cfg.PostgresURL, cfg.MaxStoredAnalyses, cfg.Retention)
+		if err != nil {
+			return nil, nil, err
+		}
+		var idempotency idempotencyClaimer = newIdempotencyCache(0)
+		if cfg.IdempotencyTTL > 0 {
+			idempotency = &pgIdempotency{pool: store.pool, ttl: cfg.IdempotencyTTL}
+		}
+		return store, idempotency, nil
+	}
+	if cfg.AnalysisStore != storeRedis {
+		store, err := openAnalysisStore(cfg.MaxStoredAnalyses, cfg.AnalysisStoreFile, cfg.Retention)
+		if err != nil {
+			return nil, nil, err
+		}
+		return store, newIdempotencyCache(cfg.IdempotencyTTL), nil
+	}
+	var idempotency idempotencyClaimer = newIdempotencyCache(0)
+	if cfg.IdempotencyTTL > 0 {
+		idempotency = &redisIdempotency{client: client, prefix: cfg.Redis.KeyPrefix, ttl: cfg.IdempotencyTTL}
+	}
+	return newRedisStore(client, cfg.Redis.KeyPrefix, cfg.MaxStoredAnalyses, cfg.Retention), idempotency, nil
+}
+
+func (s *analysisStore) add(record analysisRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items = append([]analysisRecord{record}, s.items...)
+	if len(s.items) > s.max {
+		s.items = s.items[:s.max]
+	}
+	if err := s.save(); err != nil {
+		slog.Warn("failed to persist analyses", "path", s.path, "error", err)
+	}
+}
+
+// update applies fn to the stored record with id and persists the store
+// when fn reports a change. It returns false when no record has id.
+func (s *analysisStore) update(id string, fn func(*analysisRecord) bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.items, func(r analysisRecord) bool { return r.ID == id })
+	if i < 0 {
+		return false
+	}
+	if fn(&s.items[i]) {
+		if err := s.save(); err != nil {
+			slog.Warn("failed to persist analyses", "path", s.path, "error", err)
+		}
+	}
+	return true
+}
+
+func (s *analysisStore) get(id string) (analysisRecord, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i := slices.IndexFunc(s.items, func(r analysisRecord) bool { return r.ID == id })
+	if i < 0 {
+		return analysisRecord{}, false
+	}
+	return s.items[i], true
+}
+
+func (s *analysisStore) list() []analysisRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]analysisRecord, len(s.items))
+	copy(out, s.items)
+	return out
+}
+
+func (s *analysisStore) count() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.items)
+}
+
+func (s *analysisStore) search(q searchQuery) ([]analysisRecord, error) {
+	return searchRecords(s.list(), q), nil
+}
+
+// save writes the records to a temporary file and renames it over the
+// store, so a crash mid-write leaves the previous contents. The caller
+// holds s.mu.
+func (s *analysisStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(s.items)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// IssueCategory is one entry of ISSUE_TAXONOMY_JSON. A likely_issue falls
+// into the first category whose pattern matches it, and into "other" when
+// none does.
+type IssueCategory struct {
+	Category string `json:"category"`
+	Match    string `json:"match"`
+
+	re *regexp.Regexp
+}
+
+// defaultIssueTaxonomy sorts free-text diagnoses into the failure domains
+// the probes measure; the order breaks ties ("DNS timeouts from the ISP
+// resolver" is dns).
+var defaultIssueTaxonomy = []IssueCategory{
+	{Category: "dns", Match: `(?i)\bdns\b|resolver|name resolution`},
+	{Category: "wifi", Match: `(?i)wi-?fi|wireless|rssi|signal|snr|roam|interference|channel|access point|\bap\b`},
+	{Category: "isp", Match: `(?i)\bisp\b|\bwan\b|upstream|provider|internet outage|modem|\bont\b|dsl|docsis`},
+	{Category: "gateway", Match: `(?i)gateway|router|\blan\b|switch|ethernet|cable|link flap|carrier`},
+	{Category: "congestion", Match: `(?i)congest|bufferbloat|saturat|bandwidth|throughput|queue`},
+	{Category: "host", Match: `(?i)\bhost\b|\bcpu\b|softnet|driver|kernel|\bnic\b`},
+}
+
+func parseIssueTaxonomy(raw string) ([]IssueCategory, error) {
+	taxonomy := defaultIssueTaxonomy
+	if raw != "" {
+		taxonomy = nil
+		if err := json.Unmarshal([]byte(raw), &taxonomy); err != nil {
+			return nil, fmt.Errorf("parse ISSUE_TAXONOMY_JSON: %w", err)
+		}
+	}
+	out := make([]IssueCategory, len(taxonomy))
+	for i, c := range taxonomy {
+		re, err := regexp.Compile(c.Match)
+		if err != nil {
+			return nil, fmt.Errorf("issue category %q: %w", c.Category, err)
+		}
+		c.re = re
+		out[i] = c
+	}
+	return out, nil
+}
+
+// classifyIssue returns the taxonomy category of a likely_issue.
+func classifyIssue(taxonomy []IssueCategory, issue string) string {
+	if issue == "" {
+		return "unknown"
+	}
+	for _, c := range taxonomy {
+		if c.re.MatchString(issue) {
+			return c.Category
+		}
+	}
+	return "other"
+}
+
+// observeAnalysis exports what a parsed analysis concluded.
+func observeAnalysis(result ProviderResult) {
+	analysisConfidence.WithLabelValues(result.Provider).Observe(result.Parsed.Confidence)
+	analysisIssuesTotal.WithLabelValues(result.IssueCategory, result.Provider).Inc()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+)
+
+// localizeRecord returns a copy of record with its timestamps in loc, for
+// the API, prompts and notifications. Stored records stay in UTC. Probe
+// event times are rewritten too when they are RFC 3339 strings in a "time"
+// field.
+func localizeRecord(record analysisRecord, loc *time.Location) analysisRecord {
+	if loc == nil || loc == time.UTC {
+		return record
+	}
+	record.ReceivedAt = inLocation(record.ReceivedAt, loc)
+	record.CompletedAt = inLocation(record.CompletedAt, loc)
+
+	record.AlertSummaries = slices.Clone(record.AlertSummaries)
+	for i := range record.AlertSummaries {
+		a := &record.AlertSummaries[i]
+		a.StartsAt = inLocation(a.StartsAt, loc)
+		a.EndsAt = inLocation(a.EndsAt, loc)
+	}
+
+	record.External = slices.Clone(record.External)
+	for i := range record.External {
+		e := &record.External[i]
+		e.Incidents = slices.Clone(e.Incidents)
+		for j := range e.Incidents {
+			e.Incidents[j].StartedAt = inLocationPtr(e.Incidents[j].StartedAt, loc)
+			e.Incidents[j].UpdatedAt = inLocationPtr(e.Incidents[j].UpdatedAt, loc)
+		}
+	}
+
+	record.ProbeEvents = slices.Clone(record.ProbeEvents)
+	for i := range record.ProbeEvents {
+		l := &record.ProbeEvents[i]
+		l.Since = inLocation(l.Since, loc)
+		l.Until = inLocation(l.Until, loc)
+		l.Events = slices.Clone(l.Events)
+		for j, raw := range l.Events {
+			l.Events[j] = localizeEvent(raw, loc)
+		}
+	}
+
+	if record.Resolution != nil {
+		res := *record.Resolution
+		res.StartedAt = inLocation(res.StartedAt, loc)
+		res.ResolvedAt = inLocation(res.ResolvedAt, loc)
+		record.Resolution = &res
+	}
+
+	record.Feedback = slices.Clone(record.Feedback)
+	for i := range record.Feedback {
+		record.Feedback[i].At = inLocation(record.Feedback[i].At, loc)
+	}
+	return record
+}
+
+// inLocation is t in loc, leaving the zero time alone so it still
+// marshals as "0001-01-01T00:00:00Z".
+func inLocation(t time.Time, loc *time.Location) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	return t.In(loc)
+}
+
+func inLocationPtr(t *time.Time, loc *time.Location) *time.Time {
+	if t == nil {
+		return nil
+	}
+	local := inLocation(*t, loc)
+	return &local
+}
+
+// localizeEvent rewrites a probe event's "time" field in loc, returning the
+// event unchanged when it has no such field.
+func localizeEvent(raw json.RawMessage, loc *time.Location) json.RawMessage {
+	var event map[string]json.RawMessage
+	if json.Unmarshal(raw, &event) != nil {
+		return raw
+	}
+	var at time.Time
+	if json.Unmarshal(event["time"], &at) != nil || at.IsZero() {
+		return raw
+	}
+	event["time"], _ = json.Marshal(at.In(loc))
+	out, err := json.Marshal(event)
+	if err != nil {
+		return raw
+	}
+	return out
+}
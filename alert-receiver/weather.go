@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// openMeteoURL is the default weather API: free, keyless, and global.
+const openMeteoURL = "https://api.open-meteo.com/v1/forecast"
+
+// minWeatherWindow is the least history summarized: wet cable plant and
+// ice keep degrading a line for hours after the weather that caused it.
+const minWeatherWindow = 3 * time.Hour
+
+// WeatherConditions summarizes the weather at the site over the analysis
+// window (at least minWeatherWindow), in the units that matter for line
+// conditions.
+type WeatherConditions struct {
+	Conditions             string  `json:"conditions,omitempty"`
+	PrecipitationMM        float64 `json:"precipitation_mm"`
+	MaxPrecipitationMMHour float64 `json:"max_precipitation_mm_per_hour"`
+	MaxWindSpeedKMH        float64 `json:"max_wind_speed_kmh"`
+	MaxWindGustKMH         float64 `json:"max_wind_gust_kmh"`
+	CurrentPrecipitationMM float64 `json:"current_precipitation_mm"`
+	CurrentWindSpeedKMH    float64 `json:"current_wind_speed_kmh"`
+	CurrentWindGustKMH     float64 `json:"current_wind_gust_kmh"`
+	CurrentTemperatureC    float64 `json:"current_temperature_c"`
+	Hours                  int     `json:"hours"`
+}
+
+// weatherFetcher reads current and hourly conditions for one location from
+// an Open-Meteo compatible API.
+type weatherFetcher struct {
+	name      string
+	url       string
+	latitude  float64
+	longitude float64
+	client    *http.Client
+}
+
+func (f *weatherFetcher) Name() string { return f.name }
+func (f *weatherFetcher) Type() string { return "weather" }
+
+func (f *weatherFetcher) Fetch(ctx context.Context, since time.Time) (ExternalContext, error) {
+	params := url.Values{}
+	params.Set("latitude", strconv.FormatFloat(f.latitude, 'f', -1, 64))
+	params.Set("longitude", strconv.FormatFloat(f.longitude, 'f', -1, 64))
+	params.Set("current", "temperature_2m,precipitation,weather_code,wind_speed_10m,wind_gusts_10m")
+	params.Set("hourly", "precipitation,wind_speed_10m,wind_gusts_10m")
+	params.Set("past_days", "1")
+	params.Set("forecast_days", "1")
+	params.Set("timezone", "UTC")
+
+	body, err := fetchBody(ctx, f.client, f.url+"?"+params.Encode(), "application/json")
+	if err != nil {
+		return ExternalContext{}, err
+	}
+	var resp struct {
+		Current struct {
+			Temperature   float64 `json:"temperature_2m"`
+			Precipitation float64 `json:"precipitation"`
+			WeatherCode   int     `json:"weather_code"`
+			WindSpeed     float64 `json:"wind_speed_10m"`
+			WindGusts     float64 `json:"wind_gusts_10m"`
+		} `json:"current"`
+		Hourly struct {
+			Time          []string   `json:"time"`
+			Precipitation []*float64 `json:"precipitation"`
+			WindSpeed     []*float64 `json:"wind_speed_10m"`
+			WindGusts     []*float64 `json:"wind_gusts_10m"`
+		} `json:"hourly"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return ExternalContext{}, fmt.Errorf("decode weather response: %w", err)
+	}
+
+	w := &WeatherConditions{
+		Conditions:             weatherCodeText(resp.Current.WeatherCode),
+		CurrentPrecipitationMM: resp.Current.Precipitation,
+		CurrentWindSpeedKMH:    resp.Current.WindSpeed,
+		CurrentWindGustKMH:     resp.Current.WindGusts,
+		CurrentTemperatureC:    resp.Current.Temperature,
+	}
+	now := time.Now()
+	if floor := now.Add(-minWeatherWindow); floor.Before(since) {
+		since = floor
+	}
+	// An hourly value covers the hour before its timestamp.
+	for i, ts := range resp.Hourly.Time {
+		t, err := time.Parse("2006-01-02T15:04", ts)
+		if err != nil || !t.After(since) || t.After(now.Add(time.Hour)) {
+			continue
+		}
+		w.Hours++
+		if v := valueAt(resp.Hourly.Precipitation, i); v > 0 {
+			w.PrecipitationMM += v
+			w.MaxPrecipitationMMHour = max(w.MaxPrecipitationMMHour, v)
+		}
+		w.MaxWindSpeedKMH = max(w.MaxWindSpeedKMH, valueAt(resp.Hourly.WindSpeed, i))
+		w.MaxWindGustKMH = max(w.MaxWindGustKMH, valueAt(resp.Hourly.WindGusts, i))
+	}
+	return ExternalContext{Source: f.name, Type: f.Type(), Status: w.Conditions, Weather: w}, nil
+}
+
+// valueAt returns values[i], or 0 where the API has no value.
+func valueAt(values []*float64, i int) float64 {
+	if i < len(values) && values[i] != nil {
+		return *values[i]
+	}
+	return 0
+}
+
+// weatherCodeText describes a WMO weather interpretation code.
+func weatherCodeText(code int) string {
+	switch {
+	case code == 0:
+		return "clear"
+	case code <= 3:
+		return "cloudy"
+	case code == 45 || code == 48:
+		return "fog"
+	case code >= 51 && code <= 57:
+		return "drizzle"
+	case code >= 61 && code <= 67:
+		return "rain"
+	case code >= 71 && code <= 77:
+		return "snow"
+	case code >= 80 && code <= 82:
+		return "rain showers"
+	case code == 85 || code == 86:
+		return "snow showers"
+	case code >= 95:
+		return "thunderstorm"
+	}
+	return ""
+}
@@ -0,0 +1,147 @@
+package dnsprobe
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+
+	"edge-monitor-app/internal/metrics"
+)
+
+// compareFreshness is how many intervals a resolver's latest answer counts
+// in a comparison; a resolver that has since stopped answering, or now
+// answers without records, drops out of it.
+const compareFreshness = 3
+
+// compareState is what a compare=true target's comparisons carry over to
+// the next one. It is guarded by Service.mu.
+type compareState struct {
+	// firstSeen is when any resolver first returned each answer currently
+	// returned by one, keyed by answerSet. Answers already returned before
+	// every resolver had answered once have the zero time: which of them is
+	// newer is unknown.
+	firstSeen map[string]time.Time
+	baselined bool
+	// behind is set for resolvers whose answer differs from the reference.
+	behind map[string]bool
+}
+
+func newCompareState() *compareState {
+	return &compareState{firstSeen: make(map[string]time.Time), behind: make(map[string]bool)}
+}
+
+// answerSet is the comparable form of a sorted answer.
+func answerSet(data []string) string { return strings.Join(data, " ") }
+
+// compareAnswers compares every resolver's latest answer for a compare=true
+// target. The reference answer is the one that appeared last, which after a
+// record changes is the new record set; without a known order it is the
+// one most resolvers return. A resolver returning anything else disagrees,
+// and once the reference is known to be the newer answer it lags behind by
+// the time since that answer first appeared: a router or ISP cache still
+// serving the old records, possibly past their TTL.
+func (s *Service) compareAnswers(t dnsTarget, now time.Time) {
+	qtype := t.qtype.String()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cs := s.compare[t.key()]
+
+	current := make(map[string]string, len(s.servers))
+	counts := make(map[string]int)
+	for _, srv := range s.servers {
+		obs, ok := s.answers[answerKey{resolver: srv.label, target: t.key()}]
+		if !ok || now.Sub(obs.at) > compareFreshness*s.interval {
+			continue
+		}
+		set := answerSet(obs.data)
+		current[srv.label] = set
+		counts[set]++
+		if _, ok := cs.firstSeen[set]; !ok {
+			var at time.Time
+			if cs.baselined {
+				at = now
+			}
+			cs.firstSeen[set] = at
+		}
+	}
+	if !cs.baselined && len(current) == len(s.servers) {
+		cs.baselined = true
+	}
+	for set := range cs.firstSeen {
+		if counts[set] == 0 {
+			delete(cs.firstSeen, set)
+		}
+	}
+	answerSets.WithLabelValues(t.name, qtype).Set(float64(len(counts)))
+
+	ref, newest := referenceAnswer(cs.firstSeen, counts)
+	for _, srv := range s.servers {
+		set, ok := current[srv.label]
+		if !ok {
+			continue
+		}
+		labels := []string{t.name, qtype, srv.label, srv.transport()}
+		behind := ref != "" && set != ref
+		var lag time.Duration
+		if behind && newest {
+			lag = now.Sub(cs.firstSeen[ref])
+		}
+		resolverDisagrees.WithLabelValues(labels...).Set(metrics.BoolToFloat(behind))
+		propagationLag.WithLabelValues(labels...).Set(lag.Seconds())
+
+		switch {
+		case behind && !cs.behind[srv.label]:
+			answerDisagreements.WithLabelValues(labels...).Inc()
+			slog.Warn("dns resolvers disagree", "target", t.name, "type", qtype, "resolver", srv.label,
+				"answer", set, "reference_answer", ref, "newer", newest, "resolvers", len(current))
+		case !behind && cs.behind[srv.label]:
+			attrs := []any{"target", t.name, "type", qtype, "resolver", srv.label, "answer", set}
+			if newest {
+				attrs = append(attrs, "propagated_after", now.Sub(cs.firstSeen[ref]).Round(time.Second).String())
+			}
+			slog.Info("dns resolver agrees again", attrs...)
+		}
+		cs.behind[srv.label] = behind
+	}
+}
+
+// referenceAnswer returns the answer set the others are compared with and
+// whether it is known to be the newest: the only one to appear last, or
+// otherwise the only one most resolvers return. It returns "" when
+// neither is unique, e.g. two resolvers with different baseline answers.
+func referenceAnswer(firstSeen map[string]time.Time, counts map[string]int) (string, bool) {
+	var (
+		latest    time.Time
+		newest    string
+		ambiguous bool
+	)
+	for set, at := range firstSeen {
+		switch {
+		case newest == "" || at.After(latest):
+			newest, latest, ambiguous = set, at, false
+		case at.Equal(latest):
+			ambiguous = true
+		}
+	}
+	if newest != "" && !ambiguous && !latest.IsZero() {
+		return newest, true
+	}
+
+	var (
+		majority string
+		most     int
+	)
+	ambiguous = false
+	for set, n := range counts {
+		switch {
+		case n > most:
+			majority, most, ambiguous = set, n, false
+		case n == most:
+			ambiguous = true
+		}
+	}
+	if ambiguous {
+		return "", false
+	}
+	return majority, false
+}
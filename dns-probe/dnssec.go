@@ -0,0 +1,101 @@
+package dnsprobe
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"edge-monitor-app/internal/metrics"
+	"edge-monitor-app/internal/pause"
+	"edge-monitor-app/internal/probe"
+)
+
+// Default DNSSEC test domains: a signed zone and one whose signatures are
+// deliberately broken, so a validating resolver answers SERVFAIL for it.
+const (
+	defaultDNSSECSignedDomain = "ietf.org"
+	defaultDNSSECBrokenDomain = "dnssec-failed.org"
+)
+
+// dnssecResult is the outcome of one DNSSEC check against a resolver.
+type dnssecResult struct {
+	// signatures: RRSIG records came back for the signed domain with the
+	// DO bit set. A resolver (or forwarder) that strips them cannot pass
+	// DNSSEC on to validating clients.
+	signatures bool
+	// validating: the signed answer carried the AD flag and the broken
+	// domain was rejected with SERVFAIL.
+	validating bool
+}
+
+// runDNSSEC checks every resolver at start and then every dnssecInterval
+// until ctx is cancelled. It runs apart from the resolution loop so the
+// slower signed lookups do not delay it.
+func (s *Service) runDNSSEC(ctx context.Context) {
+	ticker := time.NewTicker(s.dnssecInterval)
+	defer ticker.Stop()
+
+	for {
+		if !pause.Active("dns-probe") {
+			for _, srv := range s.servers {
+				s.checkDNSSEC(ctx, srv)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) checkDNSSEC(ctx context.Context, srv dnsServer) {
+	r := srv.resolver
+	r.DNSSEC = true
+
+	fail := func(domain string, err error) {
+		if ctx.Err() == nil {
+			dnssecErrors.WithLabelValues(srv.label, srv.transport()).Inc()
+			slog.Warn("dnssec check failed", "resolver", srv.label, "domain", domain, "error", err, "error_class", probe.Classify(err))
+		}
+	}
+
+	pctx, cancel := context.WithTimeout(ctx, dnsTimeout)
+	signed, _, err := r.Query(pctx, s.dnssecSigned, probe.TypeA)
+	cancel()
+	if err != nil {
+		fail(s.dnssecSigned, err)
+		return
+	}
+
+	pctx, cancel = context.WithTimeout(ctx, dnsTimeout)
+	broken, _, err := r.Query(pctx, s.dnssecBroken, probe.TypeA)
+	cancel()
+	if broken == nil {
+		fail(s.dnssecBroken, err)
+		return
+	}
+
+	res := dnssecResult{
+		signatures: len(signed.Records(probe.TypeRRSIG)) > 0,
+		validating: signed.AuthenticatedData && broken.Rcode == probe.RcodeServFail,
+	}
+	dnssecSignatures.WithLabelValues(srv.label, srv.transport()).Set(metrics.BoolToFloat(res.signatures))
+	dnssecValidating.WithLabelValues(srv.label, srv.transport()).Set(metrics.BoolToFloat(res.validating))
+
+	prev, seen := s.lastDNSSEC[srv.label]
+	s.lastDNSSEC[srv.label] = res
+	if !seen || prev != res {
+		level := slog.LevelInfo
+		if !res.validating {
+			level = slog.LevelWarn
+		}
+		slog.Log(ctx, level, "dnssec status",
+			"resolver", srv.label,
+			"validating", res.validating,
+			"signatures", res.signatures,
+			"signed_ad", signed.AuthenticatedData,
+			"broken_rcode", probe.RcodeName(broken.Rcode),
+		)
+	}
+}
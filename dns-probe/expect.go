@@ -0,0 +1,123 @@
+package dnsprobe
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"edge-monitor-app/internal/probe"
+)
+
+// Unexpected answer reasons, used as the reason label.
+const (
+	reasonCIDR     = "cidr"
+	reasonMismatch = "mismatch"
+	reasonRcode    = "rcode"
+)
+
+// answerExpect describes the answers a target should get. The zero value
+// accepts any answer.
+type answerExpect struct {
+	// cidrs bound A/AAAA answers. Addresses of a family without a CIDR of
+	// that family are not checked.
+	cidrs []*net.IPNet
+	// match names a DNS_RESOLVERS entry whose latest answer must share at
+	// least one record with this resolver's answer.
+	match string
+	// rcode, when set, is the only accepted response code, e.g. NXDOMAIN
+	// for a name that must not exist.
+	rcode    int
+	hasRcode bool
+}
+
+func (e answerExpect) isZero() bool {
+	return len(e.cidrs) == 0 && e.match == "" && !e.hasRcode
+}
+
+// parseOption applies one "key=value" option of a DNS_TARGETS entry.
+func (e *answerExpect) parseOption(key, value string) error {
+	switch key {
+	case "expect_cidr":
+		_, cidr, err := net.ParseCIDR(value)
+		if err != nil {
+			return fmt.Errorf("invalid expect_cidr %q", value)
+		}
+		e.cidrs = append(e.cidrs, cidr)
+	case "expect_match":
+		e.match = value
+	case "expect_rcode":
+		rcode, ok := probe.ParseRcode(value)
+		if !ok {
+			return fmt.Errorf("invalid expect_rcode %q", value)
+		}
+		e.rcode, e.hasRcode = rcode, true
+	default:
+		return fmt.Errorf("unknown option %q", key)
+	}
+	return nil
+}
+
+// checkRcode reports whether rcode is acceptable. Without expect_rcode
+// only NOERROR is.
+func (e answerExpect) checkRcode(rcode int) bool {
+	if e.hasRcode {
+		return rcode == e.rcode
+	}
+	return rcode == probe.RcodeSuccess
+}
+
+// checkCIDR returns the first answer address outside the expected CIDRs.
+func (e answerExpect) checkCIDR(answers []string) (string, bool) {
+	if len(e.cidrs) == 0 {
+		return "", true
+	}
+	for _, a := range answers {
+		ip := net.ParseIP(a)
+		if ip == nil {
+			continue
+		}
+		isV4 := ip.To4() != nil
+		checked, inside := false, false
+		for _, c := range e.cidrs {
+			if (c.IP.To4() != nil) != isV4 {
+				continue
+			}
+			checked = true
+			if c.Contains(ip) {
+				inside = true
+				break
+			}
+		}
+		if checked && !inside {
+			return a, false
+		}
+	}
+	return "", true
+}
+
+// overlaps reports whether two answers share a record. CDNs hand out
+// different addresses per resolver, so a single shared record counts as
+// agreement.
+func overlaps(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// answerData returns the data of the records of type t, for comparison.
+func answerData(resp *probe.DNSResponse, t probe.DNSType) []string {
+	if resp == nil {
+		return nil
+	}
+	records := resp.Records(t)
+	out := make([]string, 0, len(records))
+	for _, rr := range records {
+		out = append(out, strings.ToLower(rr.Data))
+	}
+	return out
+}
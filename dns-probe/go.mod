@@ -1,8 +1,11 @@
-module dns-probe
+module edge-monitor-app/dns-probe
 
 go 1.22
 
-require github.com/prometheus/client_golang v1.19.0
+require (
+	edge-monitor-app/internal v0.0.0
+	github.com/prometheus/client_golang v1.19.0
+)
 
 require (
 	github.com/beorn7/perks v1.0.1 // indirect
@@ -13,3 +16,5 @@ require (
 	golang.org/x/sys v0.16.0 // indirect
 	google.golang.org/protobuf v1.32.0 // indirect
 )
+
+replace edge-monitor-app/internal => ../internal
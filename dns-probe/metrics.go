@@ -1,4 +1,4 @@
-package main
+package dnsprobe
 
 import "github.com/prometheus/client_golang/prometheus"
 
@@ -8,7 +8,7 @@ var (
 			Name: "dns_probe_up",
 			Help: "DNS probe success (1) or failure (0)",
 		},
-		[]string{"target"},
+		[]string{"target", "type", "resolver", "transport"},
 	)
 
 	probeLatency = prometheus.NewGaugeVec(
@@ -16,7 +16,105 @@ var (
 			Name: "dns_probe_latency_seconds",
 			Help: "DNS probe latency in seconds",
 		},
-		[]string{"target"},
+		[]string{"target", "type", "resolver", "transport"},
+	)
+
+	probeDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "dns_probe_duration_seconds",
+			Help:    "Latency of answered DNS probes in seconds",
+			Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2},
+		},
+		[]string{"target", "type", "resolver", "transport"},
+	)
+
+	probeFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dns_probe_failures_total",
+			Help: "Failed DNS probes by response code (empty without a response) and error class",
+		},
+		[]string{"target", "type", "resolver", "transport", "rcode", "error_class"},
+	)
+
+	answerTTL = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_answer_min_ttl_seconds",
+			Help: "Lowest TTL in the latest successful answer",
+		},
+		[]string{"target", "type", "resolver", "transport"},
+	)
+
+	negativeTTLSeconds = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_negative_ttl_seconds",
+			Help: "Negative-cache TTL (SOA) of the latest NXDOMAIN or NODATA answer",
+		},
+		[]string{"target", "type", "resolver", "transport"},
+	)
+
+	answerFlipFlops = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dns_answer_flipflop_total",
+			Help: "Answers that changed records (kind=answer) or a TTL that did not count down (kind=ttl) before the previous answer expired",
+		},
+		[]string{"target", "type", "resolver", "transport", "kind"},
+	)
+
+	cacheLatency = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_cache_latency_seconds",
+			Help: "Latency of the latest unique-name query (cache=miss) and its repeat (cache=hit)",
+		},
+		[]string{"resolver", "transport", "cache"},
+	)
+
+	cacheDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "dns_cache_duration_seconds",
+			Help:    "Latency of unique-name queries (cache=miss) and their repeats (cache=hit) in seconds",
+			Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2},
+		},
+		[]string{"resolver", "transport", "cache"},
+	)
+
+	uncachedErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dns_uncached_probe_errors_total",
+			Help: "Unique-name queries that got no response",
+		},
+		[]string{"resolver", "transport"},
+	)
+
+	unexpectedAnswers = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dns_unexpected_answer_total",
+			Help: "DNS answers that broke the target's expectations, by reason (cidr, mismatch, rcode)",
+		},
+		[]string{"target", "type", "resolver", "transport", "reason"},
+	)
+
+	dnssecValidating = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_dnssec_validating",
+			Help: "1 if the resolver validates DNSSEC (AD on the signed domain, SERVFAIL on the broken one)",
+		},
+		[]string{"resolver", "transport"},
+	)
+
+	dnssecSignatures = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_dnssec_signatures",
+			Help: "1 if the resolver returned RRSIG records for the signed domain when asked with the DO bit",
+		},
+		[]string{"resolver", "transport"},
+	)
+
+	dnssecErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dns_dnssec_check_errors_total",
+			Help: "DNSSEC checks that could not complete",
+		},
+		[]string{"resolver", "transport"},
 	)
 
 	probeTimeouts = prometheus.NewCounterVec(
@@ -24,7 +122,39 @@ var (
 			Name: "dns_probe_timeouts_total",
 			Help: "Total number of DNS probe timeouts",
 		},
-		[]string{"target"},
+		[]string{"target", "type", "resolver", "transport"},
+	)
+
+	answerSets = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_answer_sets",
+			Help: "Distinct answers the resolvers currently return for a compare=true target (1 when they agree)",
+		},
+		[]string{"target", "type"},
+	)
+
+	resolverDisagrees = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_resolver_disagrees",
+			Help: "Whether the resolver's answer for a compare=true target differs from the reference answer (1) or not (0)",
+		},
+		[]string{"target", "type", "resolver", "transport"},
+	)
+
+	propagationLag = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_propagation_lag_seconds",
+			Help: "Seconds the resolver has been returning an older answer than the newest one for a compare=true target (0 when current)",
+		},
+		[]string{"target", "type", "resolver", "transport"},
+	)
+
+	answerDisagreements = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dns_answer_disagreements_total",
+			Help: "Times the resolver's answer for a compare=true target started to differ from the reference answer",
+		},
+		[]string{"target", "type", "resolver", "transport"},
 	)
 )
 
@@ -33,5 +163,28 @@ func registerMetrics() {
 		probeUp,
 		probeLatency,
 		probeTimeouts,
+		probeDuration,
+		probeFailures,
+		answerTTL,
+		negativeTTLSeconds,
+		answerFlipFlops,
+		cacheLatency,
+		cacheDuration,
+		uncachedErrors,
+		unexpectedAnswers,
+		dnssecValidating,
+		dnssecSignatures,
+		dnssecErrors,
+	)
+}
+
+// registerCompareMetrics registers the answer comparison metrics, only
+// when a target has compare=true.
+func registerCompareMetrics() {
+	prometheus.MustRegister(
+		answerSets,
+		resolverDisagrees,
+		propagationLag,
+		answerDisagreements,
 	)
 }
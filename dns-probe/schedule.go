@@ -0,0 +1,111 @@
+package dnsprobe
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"edge-monitor-app/internal/health"
+	"edge-monitor-app/internal/pause"
+)
+
+// worker is one independently scheduled probe: a target against a
+// resolver, or a resolver's unique-name queries. Each runs on its own
+// ticker, so one slow or timing-out query cannot delay the others or skew
+// their latency.
+type worker struct {
+	probe func(ctx context.Context)
+	done  atomic.Int64 // unix nanoseconds of the last completed probe
+}
+
+// workers returns one worker per target and resolver, plus one per
+// resolver for unique-name queries.
+func (s *Service) workers() []*worker {
+	var out []*worker
+	for _, srv := range s.servers {
+		for _, t := range s.targets {
+			out = append(out, &worker{probe: func(ctx context.Context) { s.probeTarget(ctx, srv, t) }})
+		}
+		if s.uncachedZone != "" {
+			out = append(out, &worker{probe: func(ctx context.Context) { s.probeUncached(ctx, srv) }})
+		}
+	}
+	return out
+}
+
+// run probes every interval until ctx is cancelled. The first probe waits
+// a random fraction of the interval so workers spread over it instead of
+// all querying at once. Each probe's duration and drift go to tracker.
+func (w *worker) run(ctx context.Context, interval time.Duration, tracker *health.Tracker) {
+	phase := time.NewTimer(rand.N(interval))
+	select {
+	case <-ctx.Done():
+		phase.Stop()
+		return
+	case <-phase.C:
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var prev time.Time
+	for {
+		start := time.Now()
+		var drift time.Duration
+		if !prev.IsZero() {
+			drift = start.Sub(prev) - interval
+		}
+		prev = start
+		if !pause.Active("dns-probe") {
+			w.probe(ctx)
+		}
+		tracker.ObserveCycle(time.Since(start), drift, interval)
+		w.done.Store(time.Now().UnixNano())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runWorkers starts the workers and beats the health tracker whenever all
+// of them have completed a probe since the previous beat, so a single
+// wedged worker fails liveness. It returns once ctx is cancelled and every
+// worker has stopped.
+func (s *Service) runWorkers(ctx context.Context, workers []*worker) {
+	var wg sync.WaitGroup
+	for _, w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.run(ctx, s.interval, s.health)
+		}()
+	}
+	defer wg.Wait()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	var lastBeat int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if allDoneSince(workers, lastBeat) {
+			lastBeat = time.Now().UnixNano()
+			s.health.Beat()
+		}
+	}
+}
+
+func allDoneSince(workers []*worker, since int64) bool {
+	for _, w := range workers {
+		if w.done.Load() <= since {
+			return false
+		}
+	}
+	return true
+}
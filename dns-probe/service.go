@@ -0,0 +1,362 @@
+// Package dnsprobe implements the dns-probe DNS resolution prober. It runs
+// standalone via cmd/dns-probe or inside the combined edge-monitor binary.
+package dnsprobe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"edge-monitor-app/internal/buildinfo"
+	"edge-monitor-app/internal/config"
+	"edge-monitor-app/internal/health"
+	"edge-monitor-app/internal/inventory"
+	"edge-monitor-app/internal/maintenance"
+	"edge-monitor-app/internal/pause"
+	"edge-monitor-app/internal/probe"
+	"edge-monitor-app/internal/selfcheck"
+	"edge-monitor-app/internal/statebus"
+)
+
+// DefaultAddr is the standalone metrics listen address.
+const DefaultAddr = ":9091"
+
+const dnsTimeout = 2 * time.Second
+
+// Service is a configured dns-probe instance.
+type Service struct {
+	interval time.Duration
+	targets  []dnsTarget
+	servers  []dnsServer
+
+	// answers holds each resolver's latest successful answer per target,
+	// for TTL tracking and as the reference for expect_match. Workers
+	// share it, so it is guarded by mu.
+	mu      sync.Mutex
+	answers map[answerKey]answerObs
+	// up is each resolver's last known state per target, for publishing
+	// state changes; also guarded by mu.
+	up map[answerKey]bool
+	// compare is the comparison state of compare=true targets; also
+	// guarded by mu.
+	compare map[targetKey]*compareState
+
+	// uncachedZone is a wildcard zone for cache-bypassing unique-name
+	// queries; empty disables them.
+	uncachedZone string
+
+	dnssec         bool
+	dnssecSigned   string
+	dnssecBroken   string
+	dnssecInterval time.Duration
+	lastDNSSEC     map[string]dnssecResult
+
+	// maintenance covers query names and resolver labels.
+	maintenance *maintenance.Schedule
+	selfcheck   *selfcheck.Checker
+
+	health *health.Tracker
+}
+
+// New reads configuration from the environment and registers metrics with
+// the default Prometheus registry.
+func New() (*Service, error) {
+	registerMetrics()
+
+	s := &Service{
+		interval: config.Seconds("INTERVAL_SECONDS", 2*time.Second),
+		answers:  make(map[answerKey]answerObs),
+		up:       make(map[answerKey]bool),
+		compare:  make(map[targetKey]*compareState),
+
+		uncachedZone: strings.Trim(config.String("DNS_UNCACHED_ZONE", ""), "."),
+
+		dnssec:         config.Bool("DNSSEC_CHECK", false),
+		dnssecSigned:   config.String("DNSSEC_SIGNED_DOMAIN", defaultDNSSECSignedDomain),
+		dnssecBroken:   config.String("DNSSEC_BROKEN_DOMAIN", defaultDNSSECBrokenDomain),
+		dnssecInterval: config.Seconds("DNSSEC_INTERVAL_SECONDS", 5*time.Minute),
+		lastDNSSEC:     make(map[string]dnssecResult),
+	}
+
+	types, err := parseTypes(config.List("DNS_RECORD_TYPES"))
+	if err != nil {
+		return nil, fmt.Errorf("DNS_RECORD_TYPES: %w", err)
+	}
+	targets, errs := parseTargets(config.List("DNS_TARGETS"), types)
+	for _, err := range errs {
+		slog.Warn("ignoring DNS target", "error", err)
+	}
+	s.targets = targets
+
+	resolvers, err := inventory.List("DNS_RESOLVERS", inventory.RoleDNS)
+	if err != nil {
+		return nil, err
+	}
+	servers, errs := parseResolvers(resolvers)
+	for _, err := range errs {
+		slog.Warn("ignoring DNS resolver", "error", err)
+	}
+	if len(servers) == 0 {
+		return nil, errors.New("no valid DNS resolvers")
+	}
+	s.servers = servers
+
+	if s.maintenance, err = maintenance.Load("dns-probe"); err != nil {
+		return nil, err
+	}
+	if s.selfcheck, err = selfcheck.Load("dns-probe", s.canaryProbe); err != nil {
+		return nil, err
+	}
+
+	for i, t := range s.targets {
+		if t.expect.match != "" && !s.hasResolver(t.expect.match) {
+			slog.Warn("ignoring expect_match: resolver is not in DNS_RESOLVERS", "target", t.name, "resolver", t.expect.match)
+			s.targets[i].expect.match = ""
+		}
+		if t.compare {
+			if len(s.servers) < 2 {
+				slog.Warn("ignoring compare: it needs more than one resolver in DNS_RESOLVERS", "target", t.name)
+				s.targets[i].compare = false
+				continue
+			}
+			s.compare[t.key()] = newCompareState()
+		}
+	}
+	if len(s.compare) > 0 {
+		registerCompareMetrics()
+	}
+
+	// Pre-initialize per-target series so zero-value counters appear in Prometheus
+	// before the first timeout event.
+	for _, srv := range s.servers {
+		for _, t := range s.targets {
+			qtype := t.qtype.String()
+			probeUp.WithLabelValues(t.name, qtype, srv.label, srv.transport()).Set(0)
+			probeLatency.WithLabelValues(t.name, qtype, srv.label, srv.transport()).Set(0)
+			probeTimeouts.WithLabelValues(t.name, qtype, srv.label, srv.transport()).Add(0)
+			for _, kind := range []string{flipAnswer, flipTTL} {
+				answerFlipFlops.WithLabelValues(t.name, qtype, srv.label, srv.transport(), kind).Add(0)
+			}
+			if !t.expect.isZero() {
+				for _, reason := range []string{reasonCIDR, reasonMismatch, reasonRcode} {
+					unexpectedAnswers.WithLabelValues(t.name, qtype, srv.label, srv.transport(), reason).Add(0)
+				}
+			}
+			if t.compare {
+				answerDisagreements.WithLabelValues(t.name, qtype, srv.label, srv.transport()).Add(0)
+			}
+		}
+		if s.uncachedZone != "" {
+			uncachedErrors.WithLabelValues(srv.label, srv.transport()).Add(0)
+		}
+		if s.dnssec {
+			dnssecErrors.WithLabelValues(srv.label, srv.transport()).Add(0)
+		}
+	}
+	expectations := false
+	for _, t := range s.targets {
+		expectations = expectations || !t.expect.isZero()
+	}
+	pause.Track("dns-probe")
+	buildinfo.Register("dns-probe", map[string]bool{
+		"answer_expectations": expectations,
+		"compare":             len(s.compare) > 0,
+		"uncached_queries":    s.uncachedZone != "",
+		"dnssec_check":        s.dnssec,
+		"selfcheck":           s.selfcheck != nil,
+	})
+	s.health = health.NewTracker("dns-probe", s.interval)
+	return s, nil
+}
+
+// answerKey identifies one resolver's answer for a target.
+type answerKey struct {
+	resolver string
+	target   targetKey
+}
+
+func (s *Service) hasResolver(label string) bool {
+	for _, srv := range s.servers {
+		if srv.label == label {
+			return true
+		}
+	}
+	return false
+}
+
+// canaryProbe queries a CANARY_TARGETS_JSON target, "name[@resolver]",
+// for A records through the resolver (in DNS_RESOLVERS form) or else the
+// first of DNS_RESOLVERS, without recording it as a target. A .invalid
+// name makes a canary that must fail.
+func (s *Service) canaryProbe(raw string) (selfcheck.Probe, error) {
+	name, resolver, ok := strings.Cut(raw, "@")
+	srv := s.servers[0]
+	if ok {
+		var err error
+		if srv, err = parseResolver(resolver); err != nil {
+			return nil, fmt.Errorf("resolver %q: %w", resolver, err)
+		}
+	}
+	return func(ctx context.Context) error {
+		_, _, err := srv.resolver.Query(ctx, name, probe.TypeA)
+		return err
+	}, nil
+}
+
+// Register adds the service's HTTP handlers (other than /metrics) to mux.
+func (s *Service) Register(mux *http.ServeMux) {}
+
+// Health returns the probe loop tracker behind /healthz and /readyz.
+func (s *Service) Health() *health.Tracker { return s.health }
+
+// Run resolves every target against every resolver each interval until ctx
+// is cancelled. Each pair runs on its own schedule (see worker).
+func (s *Service) Run(ctx context.Context) error {
+	targets := make([]string, 0, len(s.targets))
+	for _, t := range s.targets {
+		targets = append(targets, t.name+"/"+t.qtype.String())
+	}
+	resolvers := make([]string, 0, len(s.servers))
+	for _, srv := range s.servers {
+		resolvers = append(resolvers, srv.label)
+	}
+	slog.Info("starting dns-probe",
+		"dns_targets", targets,
+		"dns_resolvers", resolvers,
+		"interval", s.interval.String(),
+		"uncached_zone", s.uncachedZone,
+		"dnssec_check", s.dnssec,
+	)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	if s.dnssec {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.runDNSSEC(ctx)
+		}()
+	}
+	if s.selfcheck != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.selfcheck.Run(ctx)
+		}()
+	}
+
+	s.runWorkers(ctx, s.workers())
+	return nil
+}
+
+// publishState reports a change in whether a resolver answers for a target
+// to in-process subscribers. A first result is only reported if it failed.
+func (s *Service) publishState(key answerKey, t dnsTarget, srv dnsServer, err error, inMaintenance bool, now time.Time) {
+	up := err == nil
+	s.mu.Lock()
+	prev, known := s.up[key]
+	s.up[key] = up
+	s.mu.Unlock()
+	if (known && prev == up) || (!known && up) {
+		return
+	}
+	statebus.Publish(statebus.Change{
+		Time:        now,
+		Service:     "dns-probe",
+		Probe:       "dns",
+		Target:      t.name,
+		Via:         srv.label,
+		Up:          up,
+		ErrorClass:  string(probe.Classify(err)),
+		Maintenance: inMaintenance,
+	})
+}
+
+// probeTarget queries one target against one resolver.
+func (s *Service) probeTarget(ctx context.Context, srv dnsServer, t dnsTarget) {
+	pctx, cancel := context.WithTimeout(ctx, dnsTimeout)
+	resp, latency, err := srv.resolver.Query(pctx, t.name, t.qtype)
+	cancel()
+
+	qtype := t.qtype.String()
+	labels := []string{t.name, qtype, srv.label, srv.transport()}
+	key := answerKey{resolver: srv.label, target: t.key()}
+	if resp != nil {
+		// Only answered queries: timeouts would pile up at dnsTimeout.
+		probeDuration.WithLabelValues(labels...).Observe(latency.Seconds())
+		if flip := s.observeTTL(key, labels, resp, t.qtype, time.Now()); flip != "" {
+			slog.Info("dns answer changed within its ttl", "target", t.name, "type", qtype, "resolver", srv.label, "kind", flip)
+		}
+	}
+	// An expected error rcode (expect_rcode=NXDOMAIN) is a success, and a
+	// NOERROR answer in its place is a redirection rather than a failure.
+	answered := err == nil || (resp != nil && t.expect.hasRcode &&
+		(resp.Rcode == t.expect.rcode || resp.Rcode == probe.RcodeSuccess))
+	now := time.Now()
+	targetMaint := s.maintenance.Active(t.name, now)
+	resolverMaint := s.maintenance.Active(srv.label, now)
+	if !answered {
+		level := slog.LevelWarn
+		if targetMaint || resolverMaint {
+			level = slog.LevelInfo
+			if targetMaint {
+				s.maintenance.Failure(t.name)
+			}
+			if resolverMaint {
+				s.maintenance.Failure(srv.label)
+			}
+		}
+		probeUp.WithLabelValues(labels...).Set(0)
+		rcode := ""
+		if resp != nil {
+			rcode = probe.RcodeName(resp.Rcode)
+		}
+		probeFailures.WithLabelValues(append(labels, rcode, string(probe.Classify(err)))...).Inc()
+		if probe.IsTimeout(err) {
+			probeTimeouts.WithLabelValues(labels...).Inc()
+			slog.Log(ctx, level, "dns probe timed out", "target", t.name, "type", qtype, "resolver", srv.label, "transport", srv.transport(), "error", err)
+		} else {
+			slog.Log(ctx, level, "dns probe failed", "target", t.name, "type", qtype, "resolver", srv.label, "transport", srv.transport(), "error", err, "error_class", probe.Classify(err))
+		}
+		s.publishState(key, t, srv, err, targetMaint || resolverMaint, now)
+		return
+	}
+	s.publishState(key, t, srv, nil, targetMaint || resolverMaint, now)
+	probeUp.WithLabelValues(labels...).Set(1)
+	probeLatency.WithLabelValues(labels...).Set(latency.Seconds())
+	slog.Debug("dns probe answered", "target", t.name, "type", qtype, "resolver", srv.label, "rcode", probe.RcodeName(resp.Rcode), "latency", latency.String())
+
+	answer := answerData(resp, t.qtype)
+	unexpected := func(reason string, attrs ...any) {
+		unexpectedAnswers.WithLabelValues(append(labels, reason)...).Inc()
+		slog.Warn("unexpected dns answer", append([]any{"target", t.name, "type", qtype, "resolver", srv.label, "reason", reason, "answer", answer}, attrs...)...)
+	}
+
+	if !t.expect.checkRcode(resp.Rcode) {
+		unexpected(reasonRcode, "rcode", probe.RcodeName(resp.Rcode), "expected_rcode", probe.RcodeName(t.expect.rcode))
+		return
+	}
+	if resp.Rcode != probe.RcodeSuccess {
+		return
+	}
+	if addr, ok := t.expect.checkCIDR(answer); !ok {
+		unexpected(reasonCIDR, "address", addr)
+	}
+	if t.compare && len(answer) > 0 {
+		s.compareAnswers(t, now)
+	}
+
+	if t.expect.match != "" && t.expect.match != srv.label {
+		s.mu.Lock()
+		ref, ok := s.answers[answerKey{resolver: t.expect.match, target: t.key()}]
+		s.mu.Unlock()
+		if ok && !overlaps(answer, ref.data) {
+			unexpected(reasonMismatch, "reference_resolver", t.expect.match, "reference_answer", ref.data)
+		}
+	}
+}
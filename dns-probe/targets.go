@@ -0,0 +1,237 @@
+package dnsprobe
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"edge-monitor-app/internal/probe"
+)
+
+// dnsTarget is one name and record type to query.
+type dnsTarget struct {
+	name   string
+	qtype  probe.DNSType
+	expect answerExpect
+	// compare compares the resolvers' answers with each other (see
+	// compareAnswers).
+	compare bool
+}
+
+// targetKey identifies a target in maps and for de-duplication.
+type targetKey struct {
+	name  string
+	qtype probe.DNSType
+}
+
+func (t dnsTarget) key() targetKey { return targetKey{name: t.name, qtype: t.qtype} }
+
+// parseTarget parses a DNS_TARGETS entry of the form
+//
+//	DOMAIN[/TYPE] [expect_cidr=CIDR]... [expect_match=RESOLVER] [expect_rcode=RCODE] [compare=true]
+//
+// e.g. "example.com/AAAA". Without a type the entry expands to one target
+// per default type, all sharing the options.
+func parseTarget(entry string, defaultTypes []probe.DNSType) ([]dnsTarget, error) {
+	fields := strings.Fields(entry)
+	if len(fields) == 0 {
+		return nil, errors.New("empty target")
+	}
+	name, typ, hasType := strings.Cut(fields[0], "/")
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return nil, errors.New("missing domain")
+	}
+
+	var (
+		expect  answerExpect
+		compare bool
+	)
+	for _, opt := range fields[1:] {
+		key, value, ok := strings.Cut(opt, "=")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("invalid option %q", opt)
+		}
+		if key == "compare" {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid compare %q", value)
+			}
+			compare = b
+			continue
+		}
+		if err := expect.parseOption(key, value); err != nil {
+			return nil, err
+		}
+	}
+
+	types := defaultTypes
+	if hasType {
+		qtype, err := probe.ParseDNSType(typ)
+		if err != nil {
+			return nil, err
+		}
+		types = []probe.DNSType{qtype}
+	}
+	out := make([]dnsTarget, 0, len(types))
+	for _, t := range types {
+		if len(expect.cidrs) > 0 && t != probe.TypeA && t != probe.TypeAAAA {
+			return nil, fmt.Errorf("expect_cidr needs an A or AAAA query, not %s", t)
+		}
+		out = append(out, dnsTarget{name: name, qtype: t, expect: expect, compare: compare})
+	}
+	return out, nil
+}
+
+// parseTargets expands DNS_TARGETS entries, skipping invalid ones and
+// duplicates.
+func parseTargets(entries []string, defaultTypes []probe.DNSType) ([]dnsTarget, []error) {
+	var (
+		out  []dnsTarget
+		errs []error
+	)
+	seen := make(map[targetKey]bool)
+	for _, entry := range entries {
+		targets, err := parseTarget(entry, defaultTypes)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("DNS_TARGETS entry %q: %w", entry, err))
+			continue
+		}
+		for _, t := range targets {
+			if !seen[t.key()] {
+				seen[t.key()] = true
+				out = append(out, t)
+			}
+		}
+	}
+	return out, errs
+}
+
+// parseTypes parses DNS_RECORD_TYPES, falling back to A.
+func parseTypes(names []string) ([]probe.DNSType, error) {
+	if len(names) == 0 {
+		return []probe.DNSType{probe.TypeA}, nil
+	}
+	out := make([]probe.DNSType, 0, len(names))
+	for _, n := range names {
+		t, err := probe.ParseDNSType(n)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+// systemResolver is the DNS_RESOLVERS entry for the resolv.conf nameserver.
+const systemResolver = "system"
+
+// dnsServer is one resolver from DNS_RESOLVERS. label is the entry as
+// configured and becomes the resolver metric label.
+type dnsServer struct {
+	label    string
+	resolver probe.DNSResolver
+}
+
+// transport is the transport metric label.
+func (srv dnsServer) transport() string {
+	if srv.resolver.Transport == "" {
+		return string(probe.DNSOverUDP)
+	}
+	return string(srv.resolver.Transport)
+}
+
+// resolverSchemes maps DNS_RESOLVERS URL schemes to transports.
+var resolverSchemes = map[string]probe.DNSTransport{
+	"udp":   probe.DNSOverUDP,
+	"tcp":   probe.DNSOverTCP,
+	"tls":   probe.DNSOverTLS,
+	"https": probe.DNSOverHTTPS,
+}
+
+// parseResolver parses a DNS_RESOLVERS entry: "system", an IP address with
+// an optional port ("192.168.1.1", "[2606:4700::1111]:53"), the same with
+// a udp:// or tcp:// scheme, "tls://host[:port]" for DNS over TLS or an
+// https:// URL for DNS over HTTPS.
+func parseResolver(entry string) (dnsServer, error) {
+	if entry == systemResolver {
+		return dnsServer{label: entry}, nil
+	}
+	scheme, addr, hasScheme := strings.Cut(entry, "://")
+	if !hasScheme {
+		scheme, addr = "udp", entry
+	}
+	transport, ok := resolverSchemes[scheme]
+	if !ok {
+		return dnsServer{}, fmt.Errorf("unsupported scheme %q", scheme)
+	}
+	srv := dnsServer{label: entry, resolver: probe.DNSResolver{Transport: transport, Server: addr}}
+
+	switch transport {
+	case probe.DNSOverHTTPS:
+		u, err := url.Parse(entry)
+		if err != nil || u.Host == "" {
+			return dnsServer{}, errors.New("invalid DoH URL")
+		}
+		srv.resolver.Server = entry
+		return srv, nil
+	case probe.DNSOverTLS:
+		// DoT servers may be named; the name is also checked against the
+		// certificate.
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			host, port = addr, ""
+		}
+		if host == "" {
+			return dnsServer{}, errors.New("missing DoT server")
+		}
+		return srv, validPort(port)
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = addr, ""
+	}
+	if net.ParseIP(host) == nil {
+		return dnsServer{}, errors.New("resolver must be an IP address or \"system\"")
+	}
+	return srv, validPort(port)
+}
+
+func validPort(port string) error {
+	if port == "" {
+		return nil
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+// parseResolvers parses DNS_RESOLVERS, skipping invalid entries and
+// duplicates. An empty list means the system resolver only.
+func parseResolvers(entries []string) ([]dnsServer, []error) {
+	if len(entries) == 0 {
+		entries = []string{systemResolver}
+	}
+	var (
+		out  []dnsServer
+		errs []error
+	)
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		srv, err := parseResolver(entry)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("DNS_RESOLVERS entry %q: %w", entry, err))
+			continue
+		}
+		if !seen[srv.label] {
+			seen[srv.label] = true
+			out = append(out, srv)
+		}
+	}
+	return out, errs
+}
@@ -0,0 +1,98 @@
+package dnsprobe
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"edge-monitor-app/internal/probe"
+)
+
+// ttlSlack absorbs TTL rounding to whole seconds and query latency when
+// comparing a TTL with the one expected from the previous answer.
+const ttlSlack = 1
+
+// Flip-flop kinds, used as the kind label.
+const (
+	flipAnswer = "answer"
+	flipTTL    = "ttl"
+)
+
+// answerObs is a resolver's latest successful answer for a target.
+type answerObs struct {
+	data []string // sorted record data
+	ttl  uint32   // lowest TTL in the answer section
+	at   time.Time
+}
+
+// observeTTL records the TTL of a response and compares the answer with
+// the previous one from the same resolver. While that previous answer is
+// still within its TTL, a caching resolver must return the same records
+// with a TTL that has counted down by the elapsed time; anything else means
+// the answer came from a different or flushed cache.
+func (s *Service) observeTTL(key answerKey, labels []string, resp *probe.DNSResponse, qtype probe.DNSType, now time.Time) (flip string) {
+	if resp.Rcode != probe.RcodeSuccess || len(resp.Records(qtype)) == 0 {
+		if ttl, ok := negativeTTL(resp); ok {
+			negativeTTLSeconds.WithLabelValues(labels...).Set(float64(ttl))
+		}
+		return ""
+	}
+
+	obs := answerObs{data: answerData(resp, qtype), ttl: minTTL(resp.Answers), at: now}
+	slices.Sort(obs.data)
+	answerTTL.WithLabelValues(labels...).Set(float64(obs.ttl))
+
+	s.mu.Lock()
+	prev, seen := s.answers[key]
+	s.answers[key] = obs
+	s.mu.Unlock()
+	if !seen {
+		return ""
+	}
+	remaining := float64(prev.ttl) - now.Sub(prev.at).Seconds()
+	switch {
+	case remaining <= ttlSlack:
+		return ""
+	case !slices.Equal(prev.data, obs.data):
+		flip = flipAnswer
+	case math.Abs(float64(obs.ttl)-remaining) > ttlSlack:
+		flip = flipTTL
+	default:
+		return ""
+	}
+	answerFlipFlops.WithLabelValues(append(labels, flip)...).Inc()
+	return flip
+}
+
+func minTTL(records []probe.DNSRecord) uint32 {
+	var ttl uint32
+	for i, rr := range records {
+		if i == 0 || rr.TTL < ttl {
+			ttl = rr.TTL
+		}
+	}
+	return ttl
+}
+
+// negativeTTL returns how long a resolver may cache a negative answer
+// (NXDOMAIN or NODATA): the lower of the SOA record's TTL and its minimum
+// field (RFC 2308).
+func negativeTTL(resp *probe.DNSResponse) (uint32, bool) {
+	for _, rr := range resp.Authority {
+		if rr.Type != probe.TypeSOA {
+			continue
+		}
+		fields := strings.Fields(rr.Data)
+		if len(fields) != 7 {
+			continue
+		}
+		minimum, err := strconv.ParseUint(fields[6], 10, 32)
+		if err != nil {
+			continue
+		}
+		return min(rr.TTL, uint32(minimum)), true
+	}
+	return 0, false
+}
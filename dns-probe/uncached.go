@@ -0,0 +1,46 @@
+package dnsprobe
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+
+	"edge-monitor-app/internal/probe"
+)
+
+// Cache outcomes, used as the cache label.
+const (
+	cacheMiss = "miss"
+	cacheHit  = "hit"
+)
+
+// uniqueName returns a fresh name under zone that no resolver can have
+// cached.
+func uniqueName(zone string) string {
+	return fmt.Sprintf("em-%016x.%s", rand.Uint64(), zone)
+}
+
+// probeUncached queries a fresh name under the wildcard zone, which forces
+// the resolver through the full recursive lookup, then repeats the query
+// so the same resolver answers it from its cache. Comparing the two
+// separates resolver-to-authoritative latency from local resolver latency.
+func (s *Service) probeUncached(ctx context.Context, srv dnsServer) {
+	name := uniqueName(s.uncachedZone)
+	for _, cache := range []string{cacheMiss, cacheHit} {
+		pctx, cancel := context.WithTimeout(ctx, dnsTimeout)
+		resp, latency, err := srv.resolver.Query(pctx, name, probe.TypeA)
+		cancel()
+		// Any response counts, so a zone without a wildcard (NXDOMAIN) still
+		// measures the recursive path.
+		if resp == nil {
+			if ctx.Err() == nil {
+				uncachedErrors.WithLabelValues(srv.label, srv.transport()).Inc()
+				slog.Warn("uncached dns probe failed", "resolver", srv.label, "name", name, "cache", cache, "error", err, "error_class", probe.Classify(err))
+			}
+			return
+		}
+		cacheLatency.WithLabelValues(srv.label, srv.transport(), cache).Set(latency.Seconds())
+		cacheDuration.WithLabelValues(srv.label, srv.transport(), cache).Observe(latency.Seconds())
+	}
+}
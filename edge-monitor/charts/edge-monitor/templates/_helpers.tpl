@@ -0,0 +1,7 @@
+{{- define "edge-monitor.name" -}}
+edge-monitor
+{{- end -}}
+
+{{- define "edge-monitor.fullname" -}}
+{{ include "edge-monitor.name" . }}
+{{- end -}}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"edge-monitor-app/internal/statebus"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Outage classes, from the most to the least local cause.
+const (
+	classWiFi  = "wifi"  // the wireless link itself dropped
+	classLAN   = "lan"   // the gateway is unreachable
+	classWAN   = "wan"   // the gateway answers but the internet does not
+	classDNS   = "dns"   // only name resolution fails
+	classOther = "other" // a single destination or no clear pattern
+)
+
+var outageClasses = []string{classWiFi, classLAN, classWAN, classDNS, classOther}
+
+var (
+	correlatedOutages = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "edge_monitor_correlated_outages_total",
+			Help: "Outages classified across the local probes by class (wifi, lan, wan, dns, other)",
+		},
+		[]string{"class"},
+	)
+
+	correlatedOutageActive = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "edge_monitor_correlated_outage_active",
+			Help: "Whether a correlated outage of the class is ongoing (1) or not (0)",
+		},
+		[]string{"class"},
+	)
+)
+
+// outage is one classified event. Evidence is the set of failing targets
+// when it was last classified.
+type outage struct {
+	ID              uint64            `json:"id"`
+	Class           string            `json:"class"`
+	StartedAt       time.Time         `json:"started_at"`
+	EndedAt         *time.Time        `json:"ended_at,omitempty"`
+	DurationSeconds float64           `json:"duration_seconds,omitempty"`
+	Evidence        []statebus.Change `json:"evidence"`
+}
+
+// correlator subscribes to the state changes of the probes in this process
+// and turns them into one classified outage at a time. Changes are
+// classified settle after the first of them, so failures one outage causes
+// in several probes land in the same event.
+type correlator struct {
+	settle      time.Duration
+	size        int
+	changes     <-chan statebus.Change
+	unsubscribe func()
+
+	mu      sync.Mutex
+	down    map[string]statebus.Change
+	current *outage
+	outages []outage // oldest first, at most size
+	seq     uint64
+}
+
+func newCorrelator(settle time.Duration, size int) *correlator {
+	prometheus.MustRegister(correlatedOutages, correlatedOutageActive)
+	for _, class := range outageClasses {
+		correlatedOutages.WithLabelValues(class).Add(0)
+		correlatedOutageActive.WithLabelValues(class).Set(0)
+	}
+	if size <= 0 {
+		size = 256
+	}
+	// Subscribe before the probes start so no early change is missed.
+	changes, unsubscribe := statebus.Subscribe(256)
+	return &correlator{
+		settle:      settle,
+		size:        size,
+		changes:     changes,
+		unsubscribe: unsubscribe,
+		down:        make(map[string]statebus.Change),
+	}
+}
+
+// Run consumes state changes until ctx is cancelled.
+func (c *correlator) Run(ctx context.Context) error {
+	defer c.unsubscribe()
+
+	timer := time.NewTimer(c.settle)
+	timer.Stop()
+	defer timer.Stop()
+	pending := false
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ch := <-c.changes:
+			c.observe(ch)
+			if !pending {
+				timer.Reset(c.settle)
+				pending = true
+			}
+		case now := <-timer.C:
+			pending = false
+			c.classify(now)
+		}
+	}
+}
+
+// observe updates the set of failing targets. Failures inside a
+// maintenance window do not count.
+func (c *correlator) observe(ch statebus.Change) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ch.Up || ch.Maintenance {
+		delete(c.down, ch.Key())
+		return
+	}
+	c.down[ch.Key()] = ch
+}
+
+// classify closes the current outage when the failing set no longer has
+// its class and opens one for the new class, if any.
+func (c *correlator) classify(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	evidence := make([]statebus.Change, 0, len(c.down))
+	for _, ch := range c.down {
+		evidence = append(evidence, ch)
+	}
+	sort.Slice(evidence, func(i, j int) bool { return evidence[i].Time.Before(evidence[j].Time) })
+	class := outageClass(evidence)
+
+	if c.current != nil && c.current.Class == class {
+		c.current.Evidence = evidence
+		c.update(*c.current)
+		return
+	}
+	if c.current != nil {
+		ended := now.UTC()
+		c.current.EndedAt = &ended
+		c.current.DurationSeconds = ended.Sub(c.current.StartedAt).Seconds()
+		correlatedOutageActive.WithLabelValues(c.current.Class).Set(0)
+		slog.Info("correlated outage ended", "id", c.current.ID, "class", c.current.Class,
+			"duration_seconds", c.current.DurationSeconds)
+		c.update(*c.current)
+		c.current = nil
+	}
+	if class == "" {
+		return
+	}
+
+	c.seq++
+	o := outage{ID: c.seq, Class: class, StartedAt: evidence[0].Time.UTC(), Evidence: evidence}
+	c.current = &o
+	c.outages = append(c.outages, o)
+	if len(c.outages) > c.size {
+		c.outages = c.outages[len(c.outages)-c.size:]
+	}
+	correlatedOutages.WithLabelValues(class).Inc()
+	correlatedOutageActive.WithLabelValues(class).Set(1)
+	slog.Warn("correlated outage", "id", o.ID, "class", class, "started_at", o.StartedAt, "failing", len(evidence))
+}
+
+// update replaces the logged copy of o.
+func (c *correlator) update(o outage) {
+	for i := len(c.outages) - 1; i >= 0; i-- {
+		if c.outages[i].ID == o.ID {
+			c.outages[i] = o
+			return
+		}
+	}
+}
+
+// outageClass picks the most local explanation for the failing targets:
+// a dropped WiFi link, then an unreachable gateway, then a WAN outage (the
+// WAN target, or two or more destinations failing), then DNS when nothing
+// but DNS fails. Anything else is "other"; nothing failing is "".
+func outageClass(down []statebus.Change) string {
+	if len(down) == 0 {
+		return ""
+	}
+	var wifi, gateway, wan bool
+	destinations := make(map[string]bool)
+	dnsOnly := true
+	for _, ch := range down {
+		switch ch.Probe {
+		case "wifi_link":
+			wifi = true
+		case "gateway":
+			gateway = true
+		case "wan":
+			wan = true
+		case "dns":
+			continue
+		default:
+			destinations[ch.Target] = true
+		}
+		dnsOnly = false
+	}
+	switch {
+	case wifi:
+		return classWiFi
+	case gateway:
+		return classLAN
+	case wan || len(destinations) >= 2:
+		return classWAN
+	case dnsOnly:
+		return classDNS
+	}
+	return classOther
+}
+
+// list returns the outages started in [since, until] (zero bounds are
+// open), oldest first.
+func (c *correlator) list(since, until time.Time) []outage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]outage, 0, len(c.outages))
+	for _, o := range c.outages {
+		if (!since.IsZero() && o.StartedAt.Before(since)) || (!until.IsZero() && o.StartedAt.After(until)) {
+			continue
+		}
+		out = append(out, o)
+	}
+	return out
+}
+
+// handleCorrelations serves GET /correlations, optionally limited to the
+// RFC 3339 "since" and "until" query parameters.
+func (c *correlator) handleCorrelations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var bounds [2]time.Time
+	for i, key := range []string{"since", "until"} {
+		v := r.URL.Query().Get(key)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, key+": expected RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+		bounds[i] = t
+	}
+
+	c.mu.Lock()
+	var current *outage
+	if c.current != nil {
+		o := *c.current
+		current = &o
+	}
+	c.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"generated_at": time.Now().UTC(),
+		"current":      current,
+		"outages":      c.list(bounds[0], bounds[1]),
+	})
+}
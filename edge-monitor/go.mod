@@ -0,0 +1,41 @@
+module edge-monitor-app/edge-monitor
+
+go 1.22
+
+require (
+	edge-monitor-app/dns-probe v0.0.0
+	edge-monitor-app/gateway-monitor v0.0.0
+	edge-monitor-app/internal v0.0.0
+	edge-monitor-app/jitter-probe v0.0.0
+	edge-monitor-app/modem-collector v0.0.0
+	edge-monitor-app/path-monitor v0.0.0
+	edge-monitor-app/snmp-collector v0.0.0
+	edge-monitor-app/starlink-collector v0.0.0
+	edge-monitor-app/wifi-probe v0.0.0
+	github.com/prometheus/client_golang v1.19.0
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/godbus/dbus/v5 v5.1.0 // indirect
+	github.com/prometheus/client_model v0.5.0 // indirect
+	github.com/prometheus/common v0.48.0 // indirect
+	github.com/prometheus/procfs v0.12.0 // indirect
+	golang.org/x/net v0.20.0 // indirect
+	golang.org/x/sys v0.16.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
+	google.golang.org/protobuf v1.32.0 // indirect
+)
+
+replace (
+	edge-monitor-app/dns-probe => ../dns-probe
+	edge-monitor-app/gateway-monitor => ../gateway-monitor
+	edge-monitor-app/internal => ../internal
+	edge-monitor-app/jitter-probe => ../jitter-probe
+	edge-monitor-app/modem-collector => ../modem-collector
+	edge-monitor-app/path-monitor => ../path-monitor
+	edge-monitor-app/snmp-collector => ../snmp-collector
+	edge-monitor-app/starlink-collector => ../starlink-collector
+	edge-monitor-app/wifi-probe => ../wifi-probe
+)
@@ -0,0 +1,219 @@
+// Command edge-monitor runs several probe services in one process behind a
+// single /metrics, /healthz and /readyz endpoint, from one configuration. It
+// is meant for small edge boxes where several separate deployments are too
+// heavy; each probe still ships standalone. Each service is supervised: one
+// that fails or panics is restarted with backoff while the others keep
+// probing.
+//
+// Usage:
+//
+//	edge-monitor all
+//	edge-monitor wifi-probe jitter-probe
+//	edge-monitor -config /etc/edge-monitor.yaml -print-config all
+//
+// With no arguments the selection is read from EDGE_MONITOR_SERVICES
+// (comma-separated, default "all").
+//
+// A correlator follows the probes' state changes and classifies each outage
+// once (wifi, lan, wan, dns or other), served at /correlations.
+//
+// With INVENTORY_FILE set, edge-monitor serves the target inventory at
+// /inventory; its own services read it in process and standalone probes
+// through INVENTORY_URL.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	dnsprobe "edge-monitor-app/dns-probe"
+	gatewaymonitor "edge-monitor-app/gateway-monitor"
+	"edge-monitor-app/internal/buildinfo"
+	"edge-monitor-app/internal/config"
+	"edge-monitor-app/internal/health"
+	"edge-monitor-app/internal/inventory"
+	"edge-monitor-app/internal/lifecycle"
+	"edge-monitor-app/internal/logging"
+	"edge-monitor-app/internal/otlp"
+	"edge-monitor-app/internal/pause"
+	"edge-monitor-app/internal/profiling"
+	"edge-monitor-app/internal/remotewrite"
+	jitterprobe "edge-monitor-app/jitter-probe"
+	modemcollector "edge-monitor-app/modem-collector"
+	pathmonitor "edge-monitor-app/path-monitor"
+	snmpcollector "edge-monitor-app/snmp-collector"
+	starlinkcollector "edge-monitor-app/starlink-collector"
+	wifiprobe "edge-monitor-app/wifi-probe"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const defaultAddr = ":9095"
+
+// service is the surface every probe package exposes.
+type service interface {
+	Register(mux *http.ServeMux)
+	Health() *health.Tracker
+	Run(ctx context.Context) error
+}
+
+// constructors is the module registry: it maps service names to their
+// package constructors. A new probe (a speedtest, say) joins edge-monitor
+// with one entry here and its package in the Dockerfile.
+var constructors = map[string]func() (service, error){
+	"wifi-probe":         func() (service, error) { return wifiprobe.New() },
+	"dns-probe":          func() (service, error) { return dnsprobe.New() },
+	"jitter-probe":       func() (service, error) { return jitterprobe.New() },
+	"gateway-monitor":    func() (service, error) { return gatewaymonitor.New() },
+	"path-monitor":       func() (service, error) { return pathmonitor.New() },
+	"snmp-collector":     func() (service, error) { return snmpcollector.New() },
+	"modem-collector":    func() (service, error) { return modemcollector.New() },
+	"starlink-collector": func() (service, error) { return starlinkcollector.New() },
+}
+
+func serviceNames() []string {
+	names := make([]string, 0, len(constructors))
+	for name := range constructors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// selectServices resolves the requested names, expanding "all" and
+// dropping duplicates while keeping the requested order.
+func selectServices(requested []string) ([]string, error) {
+	var out []string
+	seen := make(map[string]bool)
+	for _, name := range requested {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		expanded := []string{name}
+		if name == "all" {
+			expanded = serviceNames()
+		} else if _, ok := constructors[name]; !ok {
+			return nil, fmt.Errorf("unknown service %q (valid: all, %s)", name, strings.Join(serviceNames(), ", "))
+		}
+		for _, n := range expanded {
+			if !seen[n] {
+				seen[n] = true
+				out = append(out, n)
+			}
+		}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no services selected")
+	}
+	return out, nil
+}
+
+func main() {
+	opts := config.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(opts.LogOutput(), nil)))
+	if err := config.Load(opts.File); err != nil {
+		slog.Error("failed to load config file", "error", err)
+		os.Exit(1)
+	}
+	logging.Setup(opts.LogOutput())
+
+	requested := flag.Args()
+	if len(requested) == 0 {
+		requested = config.List("EDGE_MONITOR_SERVICES")
+		if len(requested) == 0 {
+			requested = []string{"all"}
+		}
+	}
+	names, err := selectServices(requested)
+	if err != nil {
+		slog.Error("invalid service selection", "error", err)
+		os.Exit(2)
+	}
+
+	addr := config.String("EDGE_MONITOR_ADDR", defaultAddr)
+
+	registerMetrics()
+	app := lifecycle.New("edge-monitor")
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	correlate := config.Bool("CORRELATOR", true)
+	inventoryFile := config.String("INVENTORY_FILE", "")
+	buildinfo.Register("edge-monitor", map[string]bool{"correlator": correlate, "inventory": inventoryFile != ""})
+	if inventoryFile != "" {
+		inv, err := inventory.Open(inventoryFile)
+		if err != nil {
+			slog.Error("failed to load inventory", "error", err)
+			os.Exit(1)
+		}
+		inventory.SetLocal(inv)
+		inv.Register(mux)
+		slog.Info("serving target inventory", "path", "/inventory", "file", inventoryFile, "targets", len(inv.List("")))
+	}
+	if correlate {
+		c := newCorrelator(config.Duration("CORRELATION_SETTLE", 10*time.Second), config.Int("CORRELATION_LOG_SIZE", 256))
+		mux.HandleFunc("/correlations", c.handleCorrelations)
+		app.Go("correlator", c.Run)
+	}
+
+	var trackers []*health.Tracker
+	for _, name := range names {
+		svc, err := constructors[name]()
+		if err != nil {
+			slog.Error("failed to configure service", "service", name, "error", err)
+			os.Exit(1)
+		}
+		svc.Register(mux)
+		trackers = append(trackers, svc.Health())
+		app.Go(name, supervise(name, svc.Run))
+	}
+
+	health.Register(mux, trackers...)
+	logging.Register(mux)
+	pause.Register(mux)
+	profiling.Register(mux)
+
+	rw, err := remotewrite.New("edge-monitor")
+	if err != nil {
+		slog.Error("failed to configure remote write", "error", err)
+		os.Exit(1)
+	}
+	if rw != nil {
+		app.Go("remote write", rw.Run)
+		app.OnShutdown(rw.Flush)
+	}
+
+	ex, err := otlp.New("edge-monitor")
+	if err != nil {
+		slog.Error("failed to configure otlp export", "error", err)
+		os.Exit(1)
+	}
+	if ex != nil {
+		app.Go("otlp export", ex.Run)
+		app.OnShutdown(ex.Flush)
+	}
+
+	if err := config.Err(); err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	if opts.Print {
+		config.Print(os.Stdout)
+		return
+	}
+
+	app.Serve(addr, mux)
+	slog.Info("edge-monitor listening", "addr", addr, "path", "/metrics", "services", names)
+	if err := app.Run(); err != nil {
+		os.Exit(1)
+	}
+}
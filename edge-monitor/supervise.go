@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// A failed service is restarted after minBackoff, doubling on every
+	// consecutive failure up to maxBackoff. A run that lasted maxBackoff
+	// counts as healthy and resets the backoff.
+	minBackoff = time.Second
+	maxBackoff = 2 * time.Minute
+)
+
+var (
+	serviceUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "edge_monitor_service_up",
+			Help: "Whether a selected service is running (1) or waiting to restart (0)",
+		},
+		[]string{"service"},
+	)
+
+	serviceRestarts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "edge_monitor_service_restarts_total",
+			Help: "Restarts of a selected service after it failed or panicked",
+		},
+		[]string{"service"},
+	)
+)
+
+func registerMetrics() {
+	prometheus.MustRegister(serviceUp, serviceRestarts)
+}
+
+// supervise wraps a service's Run so that a failure or panic restarts that
+// service with exponential backoff instead of stopping the other probes.
+// The returned loop only returns once ctx is cancelled. A service that
+// stays down shows in edge_monitor_service_up and, once its cycles are
+// overdue, fails /healthz.
+func supervise(name string, run func(context.Context) error) func(context.Context) error {
+	serviceUp.WithLabelValues(name).Set(0)
+	serviceRestarts.WithLabelValues(name).Add(0)
+
+	return func(ctx context.Context) error {
+		backoff := minBackoff
+		for {
+			start := time.Now()
+			serviceUp.WithLabelValues(name).Set(1)
+			err := runSafely(ctx, run)
+			serviceUp.WithLabelValues(name).Set(0)
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err == nil {
+				err = errors.New("returned before shutdown")
+			}
+			if time.Since(start) >= maxBackoff {
+				backoff = minBackoff
+			}
+			slog.Error("service failed, restarting", "service", name, "error", err, "backoff", backoff.String())
+
+			timer := time.NewTimer(backoff)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil
+			case <-timer.C:
+			}
+			backoff = min(2*backoff, maxBackoff)
+			serviceRestarts.WithLabelValues(name).Inc()
+		}
+	}
+}
+
+// runSafely runs run, turning a panic into an error so one misbehaving
+// probe cannot take the process down.
+func runSafely(ctx context.Context, run func(context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+			slog.Error("service panicked", "panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+		}
+	}()
+	return run(ctx)
+}
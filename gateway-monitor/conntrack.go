@@ -0,0 +1,247 @@
+package gatewaymonitor
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Netfilter connection tracking files. The count and statistics are per
+// network namespace, so they describe the host only with host networking.
+const (
+	conntrackCountPath = "/proc/sys/net/netfilter/nf_conntrack_count"
+	conntrackMaxPath   = "/proc/sys/net/netfilter/nf_conntrack_max"
+	conntrackStatPath  = "/proc/net/stat/nf_conntrack"
+	conntrackTablePath = "/proc/net/nf_conntrack"
+)
+
+const (
+	// conntrackWarnRatio logs once the table is this full, and
+	// conntrackClearRatio logs recovery, so a table hovering at the
+	// threshold does not flood the log.
+	conntrackWarnRatio  = 0.9
+	conntrackClearRatio = 0.8
+)
+
+// conntrackStatReasons maps /proc/net/stat/nf_conntrack columns to the
+// reason label of conntrack_drops_total.
+var conntrackStatReasons = map[string]string{
+	"drop":          "table_full",    // new connection dropped, table full
+	"early_drop":    "early_drop",    // unassured entry evicted to make room
+	"insert_failed": "insert_failed", // entry lost an insertion race
+	"invalid":       "invalid",       // packet could not be tracked
+}
+
+// conntrackAvailable reports whether the nf_conntrack module is loaded.
+func conntrackAvailable() bool {
+	_, err := os.Stat(conntrackCountPath)
+	return err == nil
+}
+
+// runConntrack reads the connection tracking table's fill level and drop
+// counters at start and then every conntrackInterval until ctx is
+// cancelled. A full table drops new connections at random, which looks
+// exactly like the flaky network the other probes chase.
+func (s *Service) runConntrack(ctx context.Context) {
+	ticker := time.NewTicker(s.conntrackInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.collectConntrack(); err != nil {
+			conntrackErrors.Inc()
+			slog.Warn("conntrack collection failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) collectConntrack() error {
+	count, err := readProcUint(conntrackCountPath)
+	if err != nil {
+		return err
+	}
+	limit, err := readProcUint(conntrackMaxPath)
+	if err != nil {
+		return err
+	}
+	conntrackEntries.Set(float64(count))
+	conntrackLimit.Set(float64(limit))
+	if limit > 0 {
+		ratio := float64(count) / float64(limit)
+		conntrackUsage.Set(ratio)
+		switch {
+		case !s.conntrackFull && ratio >= conntrackWarnRatio:
+			s.conntrackFull = true
+			slog.Warn("conntrack table nearly full", "entries", count, "limit", limit, "usage", ratio)
+		case s.conntrackFull && ratio < conntrackClearRatio:
+			s.conntrackFull = false
+			slog.Info("conntrack table usage recovered", "entries", count, "limit", limit, "usage", ratio)
+		}
+	}
+
+	stats, err := readConntrackStats()
+	if err != nil {
+		return err
+	}
+	for col, reason := range conntrackStatReasons {
+		cur, ok := stats[col]
+		if !ok {
+			continue
+		}
+		prev, seen := s.conntrackStats[col]
+		s.conntrackStats[col] = cur
+		switch {
+		case !seen:
+			conntrackDrops.WithLabelValues(reason).Add(0)
+		case cur > prev:
+			conntrackDrops.WithLabelValues(reason).Add(float64(cur - prev))
+			if reason == "table_full" {
+				slog.Warn("conntrack table full: new connections dropped", "dropped", cur-prev, "entries", count, "limit", limit)
+			}
+		}
+	}
+
+	// The entry listing needs CONFIG_NF_CONNTRACK_PROCFS; without it the
+	// NAT count is not exported and no traffic is accounted.
+	nat, err := s.readConntrackTable(time.Now())
+	switch {
+	case err == nil:
+		conntrackNATEntries.Set(float64(nat))
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+	return nil
+}
+
+func readProcUint(path string) (uint64, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+}
+
+// readConntrackStats sums the per-CPU rows of /proc/net/stat/nf_conntrack,
+// which hold hexadecimal counters under a header naming the columns (the
+// set of columns varies between kernel versions).
+func readConntrackStats() (map[string]uint64, error) {
+	f, err := os.Open(conntrackStatPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	if !sc.Scan() {
+		return nil, errors.New(conntrackStatPath + ": empty")
+	}
+	header := strings.Fields(sc.Text())
+	out := make(map[string]uint64)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		for i, name := range header {
+			if _, ok := conntrackStatReasons[name]; !ok || i >= len(fields) {
+				continue
+			}
+			if v, err := strconv.ParseUint(fields[i], 16, 64); err == nil {
+				out[name] += v
+			}
+		}
+	}
+	return out, sc.Err()
+}
+
+// readConntrackTable walks the entry listing once: it returns the number
+// of NAT'd entries and feeds every entry to the traffic accounting.
+func (s *Service) readConntrackTable(now time.Time) (int, error) {
+	f, err := os.Open(conntrackTablePath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	if s.traffic != nil {
+		s.traffic.begin()
+	}
+	n := 0
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		e, ok := parseConntrackEntry(sc.Text())
+		if !ok {
+			continue
+		}
+		if e.nat() {
+			n++
+		}
+		if s.traffic != nil {
+			s.traffic.add(e)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return 0, err
+	}
+	if s.traffic != nil {
+		s.traffic.end(now)
+	}
+	return n, nil
+}
+
+// conntrackEntry is one line of the entry listing, e.g. "ipv4 2 tcp 6
+// 431999 ESTABLISHED src=A dst=B sport=1 dport=2 packets=3 bytes=180
+// src=B dst=C sport=2 dport=1 packets=2 bytes=120 [ASSURED] mark=0 use=2".
+// The packets and bytes fields are only there with nf_conntrack_acct=1.
+type conntrackEntry struct {
+	// key identifies the connection: its protocol and original tuple.
+	key         string
+	orig, reply map[string]string
+}
+
+func parseConntrackEntry(line string) (conntrackEntry, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return conntrackEntry{}, false
+	}
+	var tuples [2]map[string]string
+	key := []string{fields[2]}
+	i := -1
+	for _, f := range fields {
+		k, value, ok := strings.Cut(f, "=")
+		if !ok {
+			continue
+		}
+		if k == "src" {
+			if i++; i > 1 {
+				break
+			}
+			tuples[i] = make(map[string]string, 6)
+		}
+		if i < 0 {
+			continue
+		}
+		tuples[i][k] = value
+		if i == 0 && k != "packets" && k != "bytes" {
+			key = append(key, f)
+		}
+	}
+	if i < 1 {
+		return conntrackEntry{}, false
+	}
+	return conntrackEntry{key: strings.Join(key, " "), orig: tuples[0], reply: tuples[1]}, true
+}
+
+// nat reports whether source or destination NAT was applied: without NAT
+// the reply tuple is the original one reversed.
+func (e conntrackEntry) nat() bool {
+	orig, reply := e.orig, e.reply
+	return reply["src"] != orig["dst"] || reply["dst"] != orig["src"] ||
+		reply["sport"] != orig["dport"] || reply["dport"] != orig["sport"]
+}
@@ -0,0 +1,126 @@
+package gatewaymonitor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"edge-monitor-app/internal/metrics"
+	"edge-monitor-app/internal/pause"
+	"edge-monitor-app/internal/probe"
+)
+
+// uplink is a FAILOVER_UPLINKS entry: a WAN line of a multi-WAN router
+// reached through its own policy routing table, selected by a firewall
+// mark that an ip rule maps to the table.
+type uplink struct {
+	name  string
+	table uint32
+	bind  probe.Binding
+
+	up   bool
+	seen bool
+}
+
+// parseUplinks parses FAILOVER_UPLINKS entries of the form
+// "name=table[/mark]", e.g. "fiber=100,lte=200/0x200". The mark defaults to
+// the table ID, matching "ip rule add fwmark 200 lookup 200".
+func parseUplinks(entries []string) ([]*uplink, error) {
+	out := make([]*uplink, 0, len(entries))
+	seen := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		name, spec, ok := strings.Cut(e, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("FAILOVER_UPLINKS: %q: want name=table[/mark]", e)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("FAILOVER_UPLINKS: duplicate uplink %q", name)
+		}
+		seen[name] = true
+
+		tableStr, markStr, hasMark := strings.Cut(strings.TrimSpace(spec), "/")
+		table, err := strconv.ParseUint(tableStr, 10, 32)
+		if err != nil || table == 0 {
+			return nil, fmt.Errorf("FAILOVER_UPLINKS: %s: invalid routing table %q", name, tableStr)
+		}
+		mark := table
+		if hasMark {
+			if mark, err = strconv.ParseUint(markStr, 0, 32); err != nil || mark == 0 {
+				return nil, fmt.Errorf("FAILOVER_UPLINKS: %s: invalid firewall mark %q", name, markStr)
+			}
+		}
+		u := &uplink{name: name, table: uint32(table), bind: probe.Binding{Mark: uint32(mark)}}
+		if err := u.bind.Apply(&net.Dialer{}); err != nil {
+			return nil, fmt.Errorf("FAILOVER_UPLINKS: %w", err)
+		}
+		out = append(out, u)
+	}
+	return out, nil
+}
+
+// runFailover probes the FAILOVER_TARGETS through every uplink at start
+// and then every failoverInterval until ctx is cancelled. The router sends
+// all traffic over the primary line, so without this a dead backup line is
+// only found out when the primary fails and the failover goes nowhere.
+func (s *Service) runFailover(ctx context.Context) {
+	ticker := time.NewTicker(s.failoverInterval)
+	defer ticker.Stop()
+
+	for {
+		if !pause.Active("gateway-monitor") {
+			for _, u := range s.uplinks {
+				s.probeUplink(ctx, u)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probeUplink probes every failover target through u. The uplink is up
+// while any of them answers.
+func (s *Service) probeUplink(ctx context.Context, u *uplink) {
+	dialer := &net.Dialer{}
+	if err := u.bind.Apply(dialer); err != nil {
+		slog.Warn("uplink probe not possible", "uplink", u.name, "error", err)
+		return
+	}
+
+	up := false
+	var lastErr error
+	for _, target := range s.failoverTargets {
+		latency, err := s.tcpProbe(ctx, dialer, target)
+		if ctx.Err() != nil {
+			return
+		}
+		failoverReachable.WithLabelValues(u.name, target).Set(metrics.BoolToFloat(err == nil))
+		if err != nil {
+			lastErr = err
+			slog.Debug("uplink probe failed", "uplink", u.name, "target", target, "error", err, "error_class", probe.Classify(err))
+			continue
+		}
+		up = true
+		failoverLatency.WithLabelValues(u.name, target).Set(latency.Seconds())
+	}
+	table := strconv.FormatUint(uint64(u.table), 10)
+	failoverUplinkUp.WithLabelValues(u.name, table).Set(metrics.BoolToFloat(up))
+
+	prev, seen := u.up, u.seen
+	u.up, u.seen = up, true
+	switch {
+	case !up && (!seen || prev):
+		slog.Warn("uplink cannot reach the WAN targets; failing over to it would not restore service",
+			"uplink", u.name, "table", u.table, "targets", s.failoverTargets,
+			"error", lastErr, "error_class", probe.Classify(lastErr))
+	case up && seen && !prev:
+		slog.Info("uplink reaches the WAN targets again", "uplink", u.name, "table", u.table)
+	}
+}
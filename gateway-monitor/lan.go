@@ -0,0 +1,343 @@
+package gatewaymonitor
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"edge-monitor-app/internal/pause"
+)
+
+const (
+	// lanMinBits caps LAN_SUBNET at a /22 (1024 addresses).
+	lanMinBits = 22
+	// lanMaxDevices bounds the tracked devices, and so the per-device series.
+	lanMaxDevices = 512
+	// lanPokeGap paces the datagrams that make the kernel resolve every
+	// address, so a /24 takes half a second rather than one burst.
+	lanPokeGap = 2 * time.Millisecond
+	// lanSettle is how long the neighbour table gets after the last poke:
+	// a stale entry is re-verified after 5s plus three 1s probes.
+	lanSettle = 10 * time.Second
+	// lanListen is how long mDNS and SSDP answers are collected.
+	lanListen = 3 * time.Second
+	// lanMissedSweeps sweeps without an answer mark a device disappeared,
+	// so a phone that sleeps through one sweep does not flap.
+	lanMissedSweeps = 3
+	// lanForgetAfter drops the series of devices gone this long.
+	lanForgetAfter = 24 * time.Hour
+	lanMaxNameLen  = 64
+	// pokePort is the discard port; no answer is expected, only ARP.
+	pokePort = 9
+)
+
+// LAN device events, the event label of lan_device_events_total.
+const (
+	lanEventNew         = "new"
+	lanEventDisappeared = "disappeared"
+	lanEventReturned    = "returned"
+)
+
+// lanDevice is one device seen on LAN_SUBNET, keyed by its MAC address,
+// or by its IP address when the neighbour table has no MAC for it.
+type lanDevice struct {
+	id       string
+	ip       netip.Addr
+	name     string
+	up       bool
+	missed   int
+	lastSeen time.Time
+}
+
+// parseLANSubnet validates LAN_SUBNET.
+func parseLANSubnet(v string) (netip.Prefix, error) {
+	p, err := netip.ParsePrefix(strings.TrimSpace(v))
+	if err != nil || !p.Addr().Is4() {
+		return netip.Prefix{}, errors.New("LAN_SUBNET must be an IPv4 CIDR such as 192.168.1.0/24")
+	}
+	if p.Bits() < lanMinBits {
+		return netip.Prefix{}, errors.New("LAN_SUBNET may be at most a /22")
+	}
+	return p.Masked(), nil
+}
+
+// runLANSweep sweeps LAN_SUBNET at start and then every lanInterval until
+// ctx is cancelled. A sweep takes a dozen seconds of waiting for the
+// kernel's neighbour resolution, so it runs apart from the reachability
+// loop.
+func (s *Service) runLANSweep(ctx context.Context) {
+	ticker := time.NewTicker(s.lanInterval)
+	defer ticker.Stop()
+
+	for {
+		if !pause.Active("gateway-monitor") {
+			s.sweepLAN(ctx)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweepLAN sends one datagram to every address of the subnet so the kernel
+// ARPs for it, asks mDNS and SSDP responders to announce themselves, then
+// reads which addresses resolved from the neighbour table. No raw sockets
+// are needed.
+func (s *Service) sweepLAN(ctx context.Context) {
+	start := time.Now()
+	subnet := s.lanSubnet.String()
+
+	local, ok := localAddrIn(s.lanSubnet)
+	if !ok {
+		lanSweepErrors.WithLabelValues("interface").Inc()
+		slog.Warn("lan sweep skipped: no local address in subnet", "subnet", subnet)
+		return
+	}
+
+	if err := pokeSubnet(ctx, s.lanSubnet, local); err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		lanSweepErrors.WithLabelValues("poke").Inc()
+		slog.Warn("lan sweep failed", "subnet", subnet, "stage", "poke", "error", err)
+		return
+	}
+	settled := time.Now().Add(lanSettle)
+
+	found := make(map[netip.Addr]string)
+	var unnamed []netip.Addr
+	for _, d := range s.lanDevices {
+		if d.name == "" {
+			unnamed = append(unnamed, d.ip)
+		}
+	}
+	if err := mdnsDiscover(ctx, local, s.lanSubnet, unnamed, found); err != nil && ctx.Err() == nil {
+		lanSweepErrors.WithLabelValues("mdns").Inc()
+		slog.Warn("lan sweep failed", "subnet", subnet, "stage", "mdns", "error", err)
+	}
+	if err := ssdpDiscover(ctx, local, s.lanSubnet, found); err != nil && ctx.Err() == nil {
+		lanSweepErrors.WithLabelValues("ssdp").Inc()
+		slog.Warn("lan sweep failed", "subnet", subnet, "stage", "ssdp", "error", err)
+	}
+
+	timer := time.NewTimer(time.Until(settled))
+	select {
+	case <-ctx.Done():
+		timer.Stop()
+		return
+	case <-timer.C:
+	}
+
+	neighbours, err := readARPTable(s.lanSubnet)
+	if err != nil {
+		lanSweepErrors.WithLabelValues("arp").Inc()
+		slog.Warn("lan sweep failed", "subnet", subnet, "stage", "arp", "error", err)
+		if len(found) == 0 {
+			return
+		}
+	}
+	delete(neighbours, local)
+	delete(found, local)
+
+	s.updateLAN(neighbours, found, time.Now())
+	lanSweepDuration.WithLabelValues(subnet).Set(time.Since(start).Seconds())
+}
+
+// updateLAN applies one sweep's answers: neighbours maps resolved addresses
+// to MACs, found maps mDNS/SSDP responders to their names (possibly empty).
+func (s *Service) updateLAN(neighbours map[netip.Addr]string, found map[netip.Addr]string, now time.Time) {
+	type sighting struct {
+		ip   netip.Addr
+		name string
+	}
+	seen := make(map[string]sighting)
+	for ip, mac := range neighbours {
+		seen[mac] = sighting{ip: ip, name: found[ip]}
+	}
+	for ip, name := range found {
+		if _, ok := neighbours[ip]; ok {
+			continue
+		}
+		// A responder missing from the neighbour table keeps the identity
+		// it had when its MAC was known.
+		id := ip.String()
+		for _, d := range s.lanDevices {
+			if d.ip == ip {
+				id = d.id
+				break
+			}
+		}
+		seen[id] = sighting{ip: ip, name: name}
+	}
+
+	for id, o := range seen {
+		d, ok := s.lanDevices[id]
+		if !ok {
+			if len(s.lanDevices) >= lanMaxDevices {
+				if !s.lanFull {
+					s.lanFull = true
+					slog.Warn("lan device limit reached; ignoring new devices", "subnet", s.lanSubnet.String(), "limit", lanMaxDevices)
+				}
+				continue
+			}
+			d = &lanDevice{id: id}
+			s.lanDevices[id] = d
+			if s.lanSwept {
+				lanDeviceEvents.WithLabelValues(lanEventNew).Inc()
+				slog.Info("lan device appeared", "device", id, "ip", o.ip.String(), "name", o.name)
+			}
+		} else if !d.up {
+			lanDeviceEvents.WithLabelValues(lanEventReturned).Inc()
+			slog.Info("lan device returned", "device", id, "ip", o.ip.String(), "name", d.name,
+				"absent", now.Sub(d.lastSeen).Round(time.Second).String())
+		}
+
+		name := d.name
+		if o.name != "" {
+			name = o.name
+		}
+		if d.ip != o.ip || d.name != name {
+			lanDeviceInfo.DeleteLabelValues(id, d.ip.String(), d.name)
+		}
+		d.ip, d.name = o.ip, name
+		d.up, d.missed, d.lastSeen = true, 0, now
+		lanDeviceInfo.WithLabelValues(id, d.ip.String(), d.name).Set(1)
+		lanDeviceUp.WithLabelValues(id).Set(1)
+		lanDeviceLastSeen.WithLabelValues(id).Set(float64(now.Unix()))
+	}
+
+	up := 0
+	for id, d := range s.lanDevices {
+		if _, ok := seen[id]; ok {
+			up++
+			continue
+		}
+		if d.up {
+			if d.missed++; d.missed < lanMissedSweeps {
+				up++
+				continue
+			}
+			d.up = false
+			lanDeviceUp.WithLabelValues(id).Set(0)
+			lanDeviceEvents.WithLabelValues(lanEventDisappeared).Inc()
+			slog.Warn("lan device disappeared", "device", id, "ip", d.ip.String(), "name", d.name,
+				"last_seen", d.lastSeen.UTC().Format(time.RFC3339))
+		}
+		if now.Sub(d.lastSeen) > lanForgetAfter {
+			lanDeviceUp.DeleteLabelValues(id)
+			lanDeviceInfo.DeleteLabelValues(id, d.ip.String(), d.name)
+			lanDeviceLastSeen.DeleteLabelValues(id)
+			delete(s.lanDevices, id)
+			s.lanFull = false
+		}
+	}
+	lanDevices.WithLabelValues(s.lanSubnet.String()).Set(float64(up))
+
+	if !s.lanSwept {
+		s.lanSwept = true
+		slog.Info("lan sweep baseline", "subnet", s.lanSubnet.String(), "devices", up)
+	}
+}
+
+// localAddrIn returns this host's address inside subnet.
+func localAddrIn(subnet netip.Prefix) (netip.Addr, bool) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ip, ok := netip.AddrFromSlice(ipnet.IP.To4()); ok && subnet.Contains(ip) {
+			return ip, true
+		}
+	}
+	return netip.Addr{}, false
+}
+
+// pokeSubnet sends a one-byte UDP datagram to the discard port of every
+// host address in subnet. The packets themselves are ignored; sending them
+// makes the kernel resolve (or re-verify) each neighbour's MAC.
+func pokeSubnet(ctx context.Context, subnet netip.Prefix, local netip.Addr) error {
+	conn, err := net.ListenUDP("udp4", net.UDPAddrFromAddrPort(netip.AddrPortFrom(local, 0)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	first, last := subnet.Addr(), lastAddr(subnet)
+	if subnet.Bits() <= 30 {
+		// Skip the network and broadcast addresses.
+		first, last = first.Next(), last.Prev()
+	}
+	payload := []byte{0}
+	for ip := first; ip.IsValid() && ip.Compare(last) <= 0; ip = ip.Next() {
+		if ip == local {
+			continue
+		}
+		// Per-address errors (e.g. a neighbour that already failed) are
+		// expected and carry no information the neighbour table lacks.
+		_, _ = conn.WriteToUDPAddrPort(payload, netip.AddrPortFrom(ip, pokePort))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lanPokeGap):
+		}
+	}
+	return nil
+}
+
+// lastAddr returns the highest address of an IPv4 prefix.
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Addr().As4()
+	host := uint32(1)<<(32-p.Bits()) - 1
+	n := (uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])) | host
+	return netip.AddrFrom4([4]byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)})
+}
+
+// arpComplete is ATF_COM: the entry holds a resolved MAC (the neighbour is
+// reachable, stale, or being re-verified). Failed entries lack it.
+const arpComplete = 0x2
+
+// readARPTable returns the resolved neighbours in subnet from the kernel's
+// IPv4 neighbour table, keyed by address with the MAC as value. It is
+// Linux-only; elsewhere it fails and only mDNS/SSDP responders are seen.
+func readARPTable(subnet netip.Prefix) (map[netip.Addr]string, error) {
+	f, err := os.Open("/proc/net/arp")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	out := make(map[netip.Addr]string)
+	sc := bufio.NewScanner(f)
+	sc.Scan() // header
+	for sc.Scan() {
+		// IP address  HW type  Flags  HW address  Mask  Device
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 6 {
+			continue
+		}
+		ip, err := netip.ParseAddr(fields[0])
+		if err != nil || !subnet.Contains(ip) {
+			continue
+		}
+		flags, err := strconv.ParseUint(strings.TrimPrefix(fields[2], "0x"), 16, 32)
+		if err != nil || flags&arpComplete == 0 || fields[3] == "00:00:00:00:00:00" {
+			continue
+		}
+		out[ip] = strings.ToLower(fields[3])
+	}
+	return out, sc.Err()
+}
@@ -0,0 +1,261 @@
+package gatewaymonitor
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// Multicast discovery: devices that answer mDNS (RFC 6762) or SSDP count as
+// seen, and mDNS supplies their host names.
+var (
+	mdnsGroup = netip.MustParseAddrPort("224.0.0.251:5353")
+	ssdpGroup = netip.MustParseAddrPort("239.255.255.250:1900")
+)
+
+const (
+	dnsTypeA   = 1
+	dnsTypePTR = 12
+	dnsClassIN = 1
+	// mdnsMaxQuestions bounds the reverse lookups packed into one query.
+	mdnsMaxQuestions = 16
+	// mdnsServices enumerates the service types on the link; every
+	// responder answers it.
+	mdnsServices = "_services._dns-sd._udp.local"
+)
+
+var ssdpSearch = []byte("M-SEARCH * HTTP/1.1\r\n" +
+	"HOST: 239.255.255.250:1900\r\n" +
+	"MAN: \"ssdp:discover\"\r\n" +
+	"MX: 2\r\n" +
+	"ST: ssdp:all\r\n\r\n")
+
+// mdnsDiscover sends the service enumeration query and reverse lookups for
+// unnamed, then adds every responder in subnet to found along with any
+// host name the answers map to it. The queries leave from a port other
+// than 5353, so responders answer by unicast (RFC 6762 6.7).
+func mdnsDiscover(ctx context.Context, local netip.Addr, subnet netip.Prefix, unnamed []netip.Addr, found map[netip.Addr]string) error {
+	conn, err := net.ListenUDP("udp4", net.UDPAddrFromAddrPort(netip.AddrPortFrom(local, 0)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	queries := [][]string{{mdnsServices}}
+	for len(unnamed) > 0 {
+		n := min(len(unnamed), mdnsMaxQuestions)
+		names := make([]string, n)
+		for i, ip := range unnamed[:n] {
+			names[i] = reverseName(ip)
+		}
+		queries = append(queries, names)
+		unnamed = unnamed[n:]
+	}
+	for _, names := range queries {
+		if _, err := conn.WriteToUDPAddrPort(mdnsQuery(names), mdnsGroup); err != nil {
+			return err
+		}
+	}
+
+	return collect(ctx, conn, func(from netip.Addr, msg []byte) {
+		if !subnet.Contains(from) {
+			return
+		}
+		if _, ok := found[from]; !ok {
+			found[from] = ""
+		}
+		for ip, name := range parseMDNS(msg) {
+			if subnet.Contains(ip) && name != "" {
+				found[ip] = name
+			}
+		}
+	})
+}
+
+// ssdpDiscover sends an SSDP search for all devices and services and adds
+// every responder in subnet to found.
+func ssdpDiscover(ctx context.Context, local netip.Addr, subnet netip.Prefix, found map[netip.Addr]string) error {
+	conn, err := net.ListenUDP("udp4", net.UDPAddrFromAddrPort(netip.AddrPortFrom(local, 0)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.WriteToUDPAddrPort(ssdpSearch, ssdpGroup); err != nil {
+		return err
+	}
+	return collect(ctx, conn, func(from netip.Addr, msg []byte) {
+		if !subnet.Contains(from) || !strings.HasPrefix(string(msg), "HTTP/1.1 200") {
+			return
+		}
+		if _, ok := found[from]; !ok {
+			found[from] = ""
+		}
+	})
+}
+
+// collect passes every datagram conn receives within lanListen to fn.
+func collect(ctx context.Context, conn *net.UDPConn, fn func(from netip.Addr, msg []byte)) error {
+	_ = conn.SetReadDeadline(time.Now().Add(lanListen))
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			var nerr net.Error
+			if errors.As(err, &nerr) && nerr.Timeout() {
+				return nil
+			}
+			return err
+		}
+		fn(from.Addr().Unmap(), buf[:n])
+	}
+}
+
+// mdnsQuery encodes a PTR query for names. Unparseable names are skipped.
+func mdnsQuery(names []string) []byte {
+	msg := make([]byte, 12)
+	var count uint16
+	for _, name := range names {
+		q, ok := appendDNSName(msg, name)
+		if !ok {
+			continue
+		}
+		msg = binary.BigEndian.AppendUint16(q, dnsTypePTR)
+		msg = binary.BigEndian.AppendUint16(msg, dnsClassIN)
+		count++
+	}
+	binary.BigEndian.PutUint16(msg[4:6], count)
+	return msg
+}
+
+func appendDNSName(b []byte, name string) ([]byte, bool) {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" || len(label) > 63 {
+			return b, false
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0), true
+}
+
+// reverseName returns the in-addr.arpa name of an IPv4 address.
+func reverseName(ip netip.Addr) string {
+	b := ip.As4()
+	return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa", b[3], b[2], b[1], b[0])
+}
+
+// parseMDNS extracts address to host name mappings from the A records and
+// reverse PTR records of an mDNS response. Malformed messages yield what
+// was decoded before the error.
+func parseMDNS(msg []byte) map[netip.Addr]string {
+	out := make(map[netip.Addr]string)
+	if len(msg) < 12 || msg[2]&0x80 == 0 { // not a response
+		return out
+	}
+	qd := int(binary.BigEndian.Uint16(msg[4:6]))
+	records := int(binary.BigEndian.Uint16(msg[6:8])) +
+		int(binary.BigEndian.Uint16(msg[8:10])) +
+		int(binary.BigEndian.Uint16(msg[10:12]))
+
+	off := 12
+	for range qd {
+		_, next, ok := readDNSName(msg, off)
+		if !ok || next+4 > len(msg) {
+			return out
+		}
+		off = next + 4
+	}
+	for range records {
+		owner, next, ok := readDNSName(msg, off)
+		if !ok || next+10 > len(msg) {
+			return out
+		}
+		typ := binary.BigEndian.Uint16(msg[next : next+2])
+		rdlen := int(binary.BigEndian.Uint16(msg[next+8 : next+10]))
+		rdata := next + 10
+		if rdata+rdlen > len(msg) {
+			return out
+		}
+		switch typ {
+		case dnsTypeA:
+			if rdlen == 4 {
+				out[netip.AddrFrom4([4]byte(msg[rdata:rdata+4]))] = hostLabel(owner)
+			}
+		case dnsTypePTR:
+			if ip, ok := parseReverseName(owner); ok {
+				if target, _, ok := readDNSName(msg, rdata); ok {
+					out[ip] = hostLabel(target)
+				}
+			}
+		}
+		off = rdata + rdlen
+	}
+	return out
+}
+
+// readDNSName decodes a possibly compressed name at off and returns the
+// offset just past it.
+func readDNSName(msg []byte, off int) (string, int, bool) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, false
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, "."), end, true
+		case n&0xc0 == 0xc0:
+			if off+1 >= len(msg) || jumps > 16 {
+				return "", 0, false
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:off+2]) & 0x3fff)
+			jumps++
+		case n > 63 || off+1+n > len(msg):
+			return "", 0, false
+		default:
+			labels = append(labels, string(msg[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
+
+// parseReverseName turns d.c.b.a.in-addr.arpa back into a.b.c.d.
+func parseReverseName(name string) (netip.Addr, bool) {
+	rest, ok := strings.CutSuffix(strings.ToLower(name), ".in-addr.arpa")
+	if !ok {
+		return netip.Addr{}, false
+	}
+	parts := strings.Split(rest, ".")
+	if len(parts) != 4 {
+		return netip.Addr{}, false
+	}
+	ip, err := netip.ParseAddr(parts[3] + "." + parts[2] + "." + parts[1] + "." + parts[0])
+	return ip, err == nil && ip.Is4()
+}
+
+// hostLabel shortens an mDNS host name for use as a label value.
+func hostLabel(name string) string {
+	name = strings.TrimSuffix(strings.TrimSuffix(name, "."), ".local")
+	name = strings.ToValidUTF8(name, "")
+	if len(name) > lanMaxNameLen {
+		name = strings.ToValidUTF8(name[:lanMaxNameLen], "")
+	}
+	return name
+}
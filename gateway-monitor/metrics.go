@@ -1,4 +1,4 @@
-package main
+package gatewaymonitor
 
 import "github.com/prometheus/client_golang/prometheus"
 
@@ -24,6 +24,288 @@ var (
 		},
 		[]string{"domain"},
 	)
+
+	pathMTU = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "path_mtu_bytes",
+			Help: "Largest IPv4 packet that reached the target with DF set",
+		},
+		[]string{"target"},
+	)
+
+	pathMTUInterface = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "path_mtu_interface_bytes",
+			Help: "MTU of the interface the path MTU search left through",
+		},
+		[]string{"target"},
+	)
+
+	pathMTUBlackhole = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "path_mtu_blackhole",
+			Help: "1 if packets above the path MTU were dropped without an ICMP Fragmentation Needed reply",
+		},
+		[]string{"target"},
+	)
+
+	pathMTUErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "path_mtu_probe_errors_total",
+			Help: "Path MTU searches that failed (target unreachable or ICMP not permitted)",
+		},
+		[]string{"target"},
+	)
+
+	lanDeviceUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "lan_device_up",
+			Help: "1 if the LAN device answered one of its last sweeps, 0 once it missed three in a row",
+		},
+		[]string{"device"},
+	)
+
+	lanDeviceInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "lan_device_info",
+			Help: "Current IP address and mDNS host name of a LAN device (always 1)",
+		},
+		[]string{"device", "ip", "name"},
+	)
+
+	lanDeviceLastSeen = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "lan_device_last_seen_timestamp_seconds",
+			Help: "Unix time of the last sweep the LAN device answered",
+		},
+		[]string{"device"},
+	)
+
+	lanDevices = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "lan_devices",
+			Help: "LAN devices currently up",
+		},
+		[]string{"subnet"},
+	)
+
+	lanDeviceEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "lan_device_events_total",
+			Help: "LAN devices that appeared, disappeared or returned",
+		},
+		[]string{"event"},
+	)
+
+	lanSweepDuration = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "lan_sweep_duration_seconds",
+			Help: "Duration of the last LAN sweep, including the wait for neighbour resolution",
+		},
+		[]string{"subnet"},
+	)
+
+	lanSweepErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "lan_sweep_errors_total",
+			Help: "LAN sweep failures by stage",
+		},
+		[]string{"stage"},
+	)
+
+	conntrackEntries = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "conntrack_entries",
+			Help: "Connections in the netfilter connection tracking table",
+		},
+	)
+
+	conntrackLimit = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "conntrack_entries_limit",
+			Help: "Size of the connection tracking table (nf_conntrack_max)",
+		},
+	)
+
+	conntrackUsage = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "conntrack_usage_ratio",
+			Help: "Fraction of the connection tracking table in use",
+		},
+	)
+
+	conntrackNATEntries = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "conntrack_nat_entries",
+			Help: "Tracked connections with source or destination NAT applied",
+		},
+	)
+
+	conntrackDrops = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "conntrack_drops_total",
+			Help: "Packets and connections connection tracking dropped or could not track, by reason",
+		},
+		[]string{"reason"},
+	)
+
+	conntrackErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "conntrack_collect_errors_total",
+			Help: "Failed reads of the connection tracking statistics",
+		},
+	)
+
+	trafficReceiveBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "lan_device_receive_bytes_total",
+			Help: "Bytes a LAN device received from outside LAN_SUBNET, from connection tracking accounting",
+		},
+		[]string{"device"},
+	)
+
+	trafficTransmitBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "lan_device_transmit_bytes_total",
+			Help: "Bytes a LAN device sent outside LAN_SUBNET, from connection tracking accounting",
+		},
+		[]string{"device"},
+	)
+
+	trafficConnections = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "lan_device_connections",
+			Help: "Tracked connections between a LAN device and outside LAN_SUBNET",
+		},
+		[]string{"device"},
+	)
+
+	raReceived = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ra_received_total",
+			Help: "IPv6 router advertisements received, by interface",
+		},
+		[]string{"interface"},
+	)
+
+	raLastReceived = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ra_last_received_timestamp_seconds",
+			Help: "Unix time of the poll that first saw the newest IPv6 router advertisement, by interface",
+		},
+		[]string{"interface"},
+	)
+
+	raValidLifetime = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ipv6_prefix_valid_lifetime_seconds",
+			Help: "Remaining valid lifetime of an autoconfigured IPv6 prefix; its addresses are removed at 0",
+		},
+		[]string{"interface", "prefix"},
+	)
+
+	raPreferredLifetime = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ipv6_prefix_preferred_lifetime_seconds",
+			Help: "Remaining preferred lifetime of an autoconfigured IPv6 prefix; new connections avoid it at 0",
+		},
+		[]string{"interface", "prefix"},
+	)
+
+	prefixChanges = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "prefix_changes_total",
+			Help: "Autoconfigured IPv6 prefixes added, deprecated or removed, by interface",
+		},
+		[]string{"interface", "change"},
+	)
+
+	raErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "ra_collect_errors_total",
+			Help: "Failed reads of the IPv6 router advertisement counters and addresses",
+		},
+	)
+
+	igdConnected = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "wan_igd_connected",
+			Help: "1 if the router reports its WAN connection as Connected over UPnP IGD",
+		},
+		[]string{"gateway"},
+	)
+
+	igdUptime = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "wan_igd_uptime_seconds",
+			Help: "WAN connection uptime as reported by the router",
+		},
+		[]string{"gateway"},
+	)
+
+	igdInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "wan_igd_info",
+			Help: "The router's WAN connection status, external IP address and last connection error (always 1)",
+		},
+		[]string{"gateway", "status", "external_ip", "last_error"},
+	)
+
+	igdExternalIPChanges = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "wan_igd_external_ip_changes_total",
+			Help: "Changes of the external IP address the router reports",
+		},
+		[]string{"gateway"},
+	)
+
+	dnsResolution = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gateway_monitor_dns_resolution_seconds",
+			Help: "Time to look up GATEWAY_IP or WAN_TARGET before the last probe when it is a host name, excluded from the probe",
+		},
+		[]string{"target"},
+	)
+
+	dnsResolutionFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_monitor_dns_resolution_failures_total",
+			Help: "Failed lookups of GATEWAY_IP or WAN_TARGET, by error class; each also fails its probe",
+		},
+		[]string{"target", "error_class"},
+	)
+
+	failoverUplinkUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "failover_uplink_up",
+			Help: "1 if any FAILOVER_TARGETS host answered through the uplink's routing table at the last check",
+		},
+		[]string{"uplink", "table"},
+	)
+
+	failoverReachable = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "failover_target_reachable",
+			Help: "1 if the target answered through the uplink at the last check",
+		},
+		[]string{"uplink", "target"},
+	)
+
+	failoverLatency = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "failover_target_latency_seconds",
+			Help: "TCP connect latency to the target through the uplink at the last successful check",
+		},
+		[]string{"uplink", "target"},
+	)
+
+	upnpErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "upnp_errors_total",
+			Help: "Failed UPnP gateway discoveries and status queries",
+		},
+		[]string{"stage"},
+	)
 )
 
 func registerMetrics() {
@@ -31,5 +313,82 @@ func registerMetrics() {
 		gatewayReachable,
 		wanReachable,
 		failureDomainEventsTotal,
+		dnsResolution,
+		dnsResolutionFailures,
+		pathMTU,
+		pathMTUInterface,
+		pathMTUBlackhole,
+		pathMTUErrors,
+		lanDeviceUp,
+		lanDeviceInfo,
+		lanDeviceLastSeen,
+		lanDevices,
+		lanDeviceEvents,
+		lanSweepDuration,
+		lanSweepErrors,
+	)
+}
+
+// registerConntrackMetrics registers the connection tracking metrics, which
+// are only exported where nf_conntrack is loaded; the NAT count also needs
+// the entry listing.
+func registerConntrackMetrics(withNAT bool) {
+	prometheus.MustRegister(
+		conntrackEntries,
+		conntrackLimit,
+		conntrackUsage,
+		conntrackDrops,
+		conntrackErrors,
+	)
+	if withNAT {
+		prometheus.MustRegister(conntrackNATEntries)
+	}
+}
+
+// registerTrafficMetrics registers the per-device traffic accounting,
+// exported with TRAFFIC_ACCOUNTING.
+func registerTrafficMetrics() {
+	prometheus.MustRegister(
+		trafficReceiveBytes,
+		trafficTransmitBytes,
+		trafficConnections,
+	)
+}
+
+// registerRAMetrics registers the IPv6 router advertisement and prefix
+// metrics, exported on hosts with IPv6.
+func registerRAMetrics() {
+	prometheus.MustRegister(
+		raReceived,
+		raLastReceived,
+		raValidLifetime,
+		raPreferredLifetime,
+		prefixChanges,
+		raErrors,
+	)
+}
+
+// registerUPnPMetrics registers the router's view of the WAN, exported
+// once a UPnP gateway answers.
+func registerUPnPMetrics() {
+	prometheus.MustRegister(
+		igdConnected,
+		igdUptime,
+		igdInfo,
+		igdExternalIPChanges,
+		upnpErrors,
+	)
+	for _, stage := range []string{"discover", "query"} {
+		upnpErrors.WithLabelValues(stage).Add(0)
+	}
+}
+
+// registerFailoverMetrics registers the per-uplink reachability, exported
+// with FAILOVER_UPLINKS.
+func registerFailoverMetrics() {
+	prometheus.MustRegister(
+		failoverUplinkUp,
+		failoverReachable,
+		failoverLatency,
 	)
 }
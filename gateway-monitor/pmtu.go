@@ -0,0 +1,72 @@
+package gatewaymonitor
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"edge-monitor-app/internal/metrics"
+	"edge-monitor-app/internal/pause"
+	"edge-monitor-app/internal/probe"
+)
+
+const (
+	// pmtuAttemptTimeout bounds the wait for one echo reply. A size that gets
+	// no reply within pmtuAttempts tries counts as too big.
+	pmtuAttemptTimeout = time.Second
+	pmtuAttempts       = 2
+)
+
+// runPathMTU searches the path MTU toward every PMTU_TARGETS host at start
+// and then every pmtuInterval until ctx is cancelled. A search sends a
+// dozen or so echo requests, so it runs apart from the reachability loop.
+func (s *Service) runPathMTU(ctx context.Context) {
+	ticker := time.NewTicker(s.pmtuInterval)
+	defer ticker.Stop()
+
+	for {
+		if !pause.Active("gateway-monitor") {
+			for _, target := range s.pmtuTargets {
+				s.probePathMTU(ctx, target)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) probePathMTU(ctx context.Context, target string) {
+	res, err := probe.PathMTU(ctx, target, pmtuAttemptTimeout, pmtuAttempts)
+	if err != nil {
+		if ctx.Err() == nil {
+			pathMTUErrors.WithLabelValues(target).Inc()
+			slog.Warn("path mtu probe failed", "target", target, "error", err, "error_class", probe.Classify(err))
+		}
+		return
+	}
+
+	pathMTU.WithLabelValues(target).Set(float64(res.MTU))
+	pathMTUInterface.WithLabelValues(target).Set(float64(res.LocalMTU))
+	pathMTUBlackhole.WithLabelValues(target).Set(metrics.BoolToFloat(res.Blackhole))
+
+	prev, seen := s.lastPathMTU[target]
+	s.lastPathMTU[target] = res
+	switch {
+	case res.Blackhole && (!seen || !prev.Blackhole):
+		slog.Warn("path mtu blackhole detected",
+			"target", target,
+			"path_mtu", res.MTU,
+			"interface_mtu", res.LocalMTU,
+		)
+	case !seen || prev.MTU != res.MTU:
+		slog.Info("path mtu measured",
+			"target", target,
+			"path_mtu", res.MTU,
+			"interface_mtu", res.LocalMTU,
+			"reported_mtu", res.ReportedMTU,
+		)
+	}
+}
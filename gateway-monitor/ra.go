@@ -0,0 +1,266 @@
+package gatewaymonitor
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// raStatsDir holds the kernel's per-interface IPv6 and ICMPv6 counters.
+const raStatsDir = "/proc/net/dev_snmp6"
+
+// Prefix changes, the change label of prefix_changes_total.
+const (
+	prefixAdded      = "added"
+	prefixDeprecated = "deprecated"
+	prefixRemoved    = "removed"
+)
+
+// ipv6Addr is one autoconfigured (SLAAC or DHCPv6) global address. Its
+// lifetimes count down from the values in the last router advertisement
+// or DHCPv6 reply; a router whose delegated prefix was not renewed keeps
+// advertising it with shrinking lifetimes, or stops advertising it.
+type ipv6Addr struct {
+	iface      string
+	prefix     netip.Prefix
+	valid      time.Duration
+	preferred  time.Duration
+	deprecated bool
+}
+
+// raInterface is what an interface's polls carry over to the next one.
+type raInterface struct {
+	received uint64
+	// exported is set once the interface has received an advertisement or
+	// held an autoconfigured address.
+	exported bool
+}
+
+// raPrefix is what a prefix's polls carry over to the next one.
+type raPrefix struct {
+	deprecated bool
+}
+
+// raAvailable reports whether the per-interface ICMPv6 counters exist,
+// i.e. the host has IPv6.
+func raAvailable() bool {
+	_, err := os.Stat(raStatsDir)
+	return err == nil
+}
+
+// runRA reads the router advertisement counters and the autoconfigured
+// IPv6 prefixes at start and then every raInterval until ctx is
+// cancelled. A broken prefix delegation renewal on the router leaves the
+// LAN without working IPv6 for hours while IPv4 carries on, and clients
+// fall back to it only after slow timeouts.
+func (s *Service) runRA(ctx context.Context) {
+	ticker := time.NewTicker(s.raInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.collectRA(time.Now()); err != nil {
+			raErrors.Inc()
+			slog.Warn("ipv6 router advertisement collection failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) collectRA(now time.Time) error {
+	addrs, err := readIPv6Addrs()
+	if err != nil {
+		return err
+	}
+	counts, err := readRACounters()
+	if err != nil {
+		return err
+	}
+
+	// Interfaces that have received an advertisement or hold an
+	// autoconfigured address; the rest (lo, bridges, veths) never will.
+	ifaces := make(map[string]bool)
+	for iface, n := range counts {
+		if n > 0 {
+			ifaces[iface] = true
+		}
+	}
+	for _, a := range addrs {
+		ifaces[a.iface] = true
+	}
+
+	// Every interface's counter is followed so an interface's first
+	// advertisement is counted, but only the relevant ones are exported.
+	for iface, cur := range counts {
+		st, seen := s.raIfaces[iface]
+		if !seen {
+			st = &raInterface{}
+			s.raIfaces[iface] = st
+		}
+		prev := st.received
+		st.received = cur
+		if !ifaces[iface] {
+			continue
+		}
+		if !st.exported {
+			st.exported = true
+			raReceived.WithLabelValues(iface).Add(0)
+			for _, c := range []string{prefixAdded, prefixDeprecated, prefixRemoved} {
+				prefixChanges.WithLabelValues(iface, c).Add(0)
+			}
+		}
+		switch {
+		case !seen:
+		case cur > prev:
+			raReceived.WithLabelValues(iface).Add(float64(cur - prev))
+			raLastReceived.WithLabelValues(iface).Set(float64(now.Unix()))
+		case cur < prev:
+			// The interface was recreated and its counters restarted.
+			raReceived.WithLabelValues(iface).Add(float64(cur))
+			if cur > 0 {
+				raLastReceived.WithLabelValues(iface).Set(float64(now.Unix()))
+			}
+		}
+	}
+	for iface, st := range s.raIfaces {
+		if _, ok := counts[iface]; ok {
+			continue
+		}
+		if st.exported {
+			raReceived.DeleteLabelValues(iface)
+			raLastReceived.DeleteLabelValues(iface)
+		}
+		delete(s.raIfaces, iface)
+	}
+
+	current := make(map[string]map[netip.Prefix]ipv6Addr)
+	for _, a := range addrs {
+		if current[a.iface] == nil {
+			current[a.iface] = make(map[netip.Prefix]ipv6Addr)
+		}
+		// Several addresses can share a prefix (stable and EUI-64, or a
+		// DHCPv6 lease next to SLAAC); the longest lived one counts.
+		if prev, ok := current[a.iface][a.prefix]; !ok || a.valid > prev.valid {
+			current[a.iface][a.prefix] = a
+		}
+	}
+
+	for iface, prefixes := range current {
+		known := s.raPrefixes[iface]
+		if known == nil {
+			known = make(map[netip.Prefix]*raPrefix)
+			s.raPrefixes[iface] = known
+		}
+		for p, a := range prefixes {
+			label := p.String()
+			raValidLifetime.WithLabelValues(iface, label).Set(a.valid.Seconds())
+			raPreferredLifetime.WithLabelValues(iface, label).Set(a.preferred.Seconds())
+
+			st, ok := known[p]
+			if !ok {
+				st = &raPrefix{deprecated: a.deprecated}
+				known[p] = st
+				if s.raSwept {
+					prefixChanges.WithLabelValues(iface, prefixAdded).Inc()
+					slog.Warn("ipv6 prefix added", "interface", iface, "prefix", label,
+						"valid_lifetime", a.valid.String(), "others", otherPrefixes(prefixes, p))
+				}
+				continue
+			}
+			if a.deprecated && !st.deprecated {
+				prefixChanges.WithLabelValues(iface, prefixDeprecated).Inc()
+				slog.Warn("ipv6 prefix deprecated: preferred lifetime ran out without renewal", "interface", iface, "prefix", label,
+					"valid_lifetime", a.valid.String())
+			}
+			st.deprecated = a.deprecated
+		}
+	}
+	for iface, known := range s.raPrefixes {
+		for p := range known {
+			if _, ok := current[iface][p]; ok {
+				continue
+			}
+			label := p.String()
+			prefixChanges.WithLabelValues(iface, prefixRemoved).Inc()
+			slog.Warn("ipv6 prefix removed", "interface", iface, "prefix", label)
+			raValidLifetime.DeleteLabelValues(iface, label)
+			raPreferredLifetime.DeleteLabelValues(iface, label)
+			delete(known, p)
+		}
+	}
+
+	if !s.raSwept {
+		s.raSwept = true
+		n := 0
+		for _, prefixes := range current {
+			n += len(prefixes)
+		}
+		slog.Info("ipv6 prefix baseline", "prefixes", n)
+	}
+	return nil
+}
+
+// otherPrefixes lists an interface's prefixes other than p, to show in
+// the log what a new prefix replaces.
+func otherPrefixes(prefixes map[netip.Prefix]ipv6Addr, p netip.Prefix) []string {
+	var out []string
+	for q := range prefixes {
+		if q != p {
+			out = append(out, q.String())
+		}
+	}
+	slices.Sort(out)
+	return out
+}
+
+// readRACounters returns each interface's Icmp6InRouterAdvertisements.
+func readRACounters() (map[string]uint64, error) {
+	files, err := os.ReadDir(raStatsDir)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]uint64, len(files))
+	for _, f := range files {
+		n, err := readSNMP6Counter(filepath.Join(raStatsDir, f.Name()), "Icmp6InRouterAdvertisements")
+		if errors.Is(err, os.ErrNotExist) {
+			continue // the interface went away
+		}
+		if err != nil {
+			return nil, err
+		}
+		out[f.Name()] = n
+	}
+	return out, nil
+}
+
+// readSNMP6Counter reads one "name value" line of a dev_snmp6 file.
+func readSNMP6Counter(path, name string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 2 && fields[0] == name {
+			return strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return 0, err
+	}
+	return 0, errors.New(path + ": no " + name)
+}
@@ -0,0 +1,92 @@
+//go:build linux
+
+package gatewaymonitor
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Address flags and attributes (linux/if_addr.h).
+const (
+	ifaFTemporary  = 0x01
+	ifaFDeprecated = 0x20
+	ifaFPermanent  = 0x80
+	ifaCacheInfo   = 6
+
+	// lifetimeInfinite is the lifetime of an address that never expires.
+	lifetimeInfinite = 0xffffffff
+)
+
+// readIPv6Addrs dumps the kernel's IPv6 addresses over netlink and returns
+// the global ones it autoconfigured from router advertisements or DHCPv6.
+// Statically configured (permanent) addresses have no lifetime to watch,
+// and privacy (temporary) addresses come and go by design.
+func readIPv6Addrs() ([]ipv6Addr, error) {
+	rib, err := syscall.NetlinkRIB(syscall.RTM_GETADDR, syscall.AF_INET6)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[int]string)
+	var out []ipv6Addr
+	for _, m := range msgs {
+		if m.Header.Type != syscall.RTM_NEWADDR || len(m.Data) < syscall.SizeofIfAddrmsg {
+			continue
+		}
+		ifa := (*syscall.IfAddrmsg)(unsafe.Pointer(&m.Data[0]))
+		if ifa.Scope != syscall.RT_SCOPE_UNIVERSE || ifa.Flags&(ifaFPermanent|ifaFTemporary) != 0 {
+			continue
+		}
+		attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+		if err != nil {
+			return nil, err
+		}
+		var addr netip.Addr
+		var valid, preferred uint32
+		var lifetimes bool
+		for _, a := range attrs {
+			switch a.Attr.Type {
+			case syscall.IFA_ADDRESS:
+				addr, _ = netip.AddrFromSlice(a.Value)
+			case ifaCacheInfo:
+				// struct ifa_cacheinfo starts with ifa_prefered, ifa_valid.
+				if len(a.Value) >= 8 {
+					preferred = binary.NativeEndian.Uint32(a.Value[0:4])
+					valid = binary.NativeEndian.Uint32(a.Value[4:8])
+					lifetimes = true
+				}
+			}
+		}
+		if !addr.Is6() || !lifetimes || valid == lifetimeInfinite {
+			continue
+		}
+
+		index := int(ifa.Index)
+		name, ok := names[index]
+		if !ok {
+			iface, err := net.InterfaceByIndex(index)
+			if err != nil {
+				continue // the interface went away
+			}
+			name = iface.Name
+			names[index] = name
+		}
+		out = append(out, ipv6Addr{
+			iface:      name,
+			prefix:     netip.PrefixFrom(addr, int(ifa.Prefixlen)).Masked(),
+			valid:      time.Duration(valid) * time.Second,
+			preferred:  time.Duration(preferred) * time.Second,
+			deprecated: ifa.Flags&ifaFDeprecated != 0 || preferred == 0,
+		})
+	}
+	return out, nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package gatewaymonitor
+
+import "errors"
+
+func readIPv6Addrs() ([]ipv6Addr, error) {
+	return nil, errors.New("IPv6 address lifetimes are only readable on Linux")
+}
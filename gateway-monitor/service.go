@@ -0,0 +1,439 @@
+// Package gatewaymonitor implements the gateway-monitor LAN vs WAN failure
+// domain isolator. It runs standalone via cmd/gateway-monitor or inside the
+// combined edge-monitor binary.
+package gatewaymonitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"edge-monitor-app/internal/buildinfo"
+	"edge-monitor-app/internal/capture"
+	"edge-monitor-app/internal/config"
+	"edge-monitor-app/internal/health"
+	"edge-monitor-app/internal/inventory"
+	"edge-monitor-app/internal/maintenance"
+	"edge-monitor-app/internal/metrics"
+	"edge-monitor-app/internal/pause"
+	"edge-monitor-app/internal/probe"
+	"edge-monitor-app/internal/selfcheck"
+	"edge-monitor-app/internal/statebus"
+)
+
+// DefaultAddr is the standalone metrics listen address.
+const DefaultAddr = ":9093"
+
+// Service is a configured gateway-monitor instance.
+type Service struct {
+	gatewayIP      string
+	wanTarget      string
+	interval       time.Duration
+	probePorts     []string
+	probeTimeout   time.Duration
+	resolveTimeout time.Duration
+
+	prevGatewayUp bool
+	prevWanUp     bool
+
+	pmtuTargets  []string
+	pmtuInterval time.Duration
+	lastPathMTU  map[string]probe.PathMTUResult
+
+	lanSubnet   netip.Prefix
+	lanInterval time.Duration
+	lanDevices  map[string]*lanDevice
+	lanSwept    bool // the first sweep is the baseline, not new devices
+	lanFull     bool
+
+	conntrack         bool
+	conntrackInterval time.Duration
+	conntrackStats    map[string]uint64
+	conntrackFull     bool
+	// traffic accounts LAN devices' uplink bytes from the conntrack table
+	// when TRAFFIC_ACCOUNTING is set.
+	traffic *trafficAccount
+
+	ra         bool
+	raInterval time.Duration
+	raIfaces   map[string]*raInterface
+	raPrefixes map[string]map[netip.Prefix]*raPrefix
+	raSwept    bool // the first read is the baseline, not added prefixes
+
+	// upnp polls the router's WAN status; upnpLocation is its description
+	// URL when set rather than discovered.
+	upnp         bool
+	upnpLocation string
+	upnpInterval time.Duration
+	igd          *igd
+	igdStatus    igdStatus
+
+	// uplinks are the FAILOVER_UPLINKS lines the failoverTargets are
+	// probed through.
+	uplinks          []*uplink
+	failoverTargets  []string
+	failoverInterval time.Duration
+
+	maintenance *maintenance.Schedule
+	capture     *capture.Capturer
+	selfcheck   *selfcheck.Checker
+
+	health *health.Tracker
+}
+
+// New reads configuration from the environment and registers metrics with
+// the default Prometheus registry.
+func New() (*Service, error) {
+	registerMetrics()
+
+	gatewayIP, err := inventory.First("GATEWAY_IP", inventory.RoleGateway, "192.168.1.1")
+	if err != nil {
+		return nil, err
+	}
+	wanTarget, err := inventory.First("WAN_TARGET", inventory.RoleWAN, "1.1.1.1")
+	if err != nil {
+		return nil, err
+	}
+	s := &Service{
+		gatewayIP:      gatewayIP,
+		wanTarget:      wanTarget,
+		interval:       config.Seconds("INTERVAL_SECONDS", 2*time.Second),
+		probePorts:     []string{"443", "80"},
+		probeTimeout:   probe.DefaultTimeout,
+		resolveTimeout: config.Seconds("PROBE_RESOLVE_TIMEOUT_SECONDS", probe.DefaultResolveTimeout),
+		prevGatewayUp:  true,
+		prevWanUp:      true,
+		pmtuTargets:    config.List("PMTU_TARGETS"),
+		pmtuInterval:   config.Seconds("PMTU_INTERVAL_SECONDS", 5*time.Minute),
+		lastPathMTU:    make(map[string]probe.PathMTUResult),
+		lanInterval:    config.Seconds("LAN_SWEEP_INTERVAL_SECONDS", time.Minute),
+		lanDevices:     make(map[string]*lanDevice),
+
+		conntrackInterval: config.Seconds("CONNTRACK_INTERVAL_SECONDS", 15*time.Second),
+		conntrackStats:    make(map[string]uint64),
+		raInterval:        config.Seconds("RA_INTERVAL_SECONDS", 30*time.Second),
+		raIfaces:          make(map[string]*raInterface),
+		raPrefixes:        make(map[string]map[netip.Prefix]*raPrefix),
+		upnpInterval:      config.Seconds("UPNP_INTERVAL_SECONDS", time.Minute),
+	}
+	for _, t := range s.pmtuTargets {
+		pathMTUErrors.WithLabelValues(t).Add(0)
+	}
+	if v := config.String("LAN_SUBNET", ""); v != "" {
+		subnet, err := parseLANSubnet(v)
+		if err != nil {
+			return nil, err
+		}
+		s.lanSubnet = subnet
+		for _, e := range []string{lanEventNew, lanEventDisappeared, lanEventReturned} {
+			lanDeviceEvents.WithLabelValues(e).Add(0)
+		}
+	}
+	maint, err := maintenance.Load("gateway-monitor")
+	if err != nil {
+		return nil, err
+	}
+	s.maintenance = maint
+	if s.capture, err = capture.Load("gateway-monitor"); err != nil {
+		return nil, err
+	}
+	if s.selfcheck, err = selfcheck.Load("gateway-monitor", s.canaryProbe); err != nil {
+		return nil, err
+	}
+	switch mode := config.String("CONNTRACK_MONITOR", "auto"); mode {
+	case "auto":
+		if s.conntrack = conntrackAvailable(); s.conntrack {
+			_, err := os.Stat(conntrackTablePath)
+			registerConntrackMetrics(err == nil)
+		}
+	case "off":
+	default:
+		return nil, fmt.Errorf("CONNTRACK_MONITOR must be auto or off, not %q", mode)
+	}
+	if config.Bool("TRAFFIC_ACCOUNTING", false) {
+		if !s.lanSubnet.IsValid() {
+			return nil, errors.New("TRAFFIC_ACCOUNTING needs LAN_SUBNET")
+		}
+		if _, err := os.Stat(conntrackTablePath); !s.conntrack || err != nil {
+			return nil, errors.New("TRAFFIC_ACCOUNTING needs the connection tracking table (" + conntrackTablePath + ") and CONNTRACK_MONITOR=auto")
+		}
+		s.traffic = newTrafficAccount(s.lanSubnet)
+		registerTrafficMetrics()
+	}
+	switch mode := config.String("RA_MONITOR", "auto"); mode {
+	case "auto":
+		if s.ra = raAvailable(); s.ra {
+			registerRAMetrics()
+		}
+	case "off":
+	default:
+		return nil, fmt.Errorf("RA_MONITOR must be auto or off, not %q", mode)
+	}
+	switch mode := config.String("UPNP_IGD", "auto"); {
+	case mode == "auto":
+		s.upnp = true
+	case mode == "off":
+	case strings.HasPrefix(mode, "http://") || strings.HasPrefix(mode, "https://"):
+		s.upnp, s.upnpLocation = true, mode
+	default:
+		return nil, fmt.Errorf("UPNP_IGD must be auto, off or a device description URL, not %q", mode)
+	}
+	if s.upnp {
+		registerUPnPMetrics()
+	}
+	if s.uplinks, err = parseUplinks(config.List("FAILOVER_UPLINKS")); err != nil {
+		return nil, err
+	}
+	if len(s.uplinks) > 0 {
+		s.failoverTargets = config.List("FAILOVER_TARGETS")
+		if len(s.failoverTargets) == 0 {
+			s.failoverTargets = []string{s.wanTarget}
+		}
+		s.failoverInterval = config.Seconds("FAILOVER_INTERVAL_SECONDS", time.Minute)
+		registerFailoverMetrics()
+	}
+	pause.Track("gateway-monitor")
+	buildinfo.Register("gateway-monitor", map[string]bool{
+		"path_mtu":           len(s.pmtuTargets) > 0,
+		"lan_sweep":          s.lanSubnet.IsValid(),
+		"conntrack":          s.conntrack,
+		"traffic_accounting": s.traffic != nil,
+		"ra_monitor":         s.ra,
+		"upnp_igd":           s.upnp,
+		"failover":           len(s.uplinks) > 0,
+		"packet_capture":     s.capture != nil,
+		"selfcheck":          s.selfcheck != nil,
+	})
+	s.health = health.NewTracker("gateway-monitor", s.interval)
+	return s, nil
+}
+
+// Register adds the service's HTTP handlers (other than /metrics) to mux.
+func (s *Service) Register(mux *http.ServeMux) {}
+
+// Health returns the probe loop tracker behind /healthz and /readyz.
+func (s *Service) Health() *health.Tracker { return s.health }
+
+// Run probes the gateway and WAN target every interval until ctx is
+// cancelled.
+func (s *Service) Run(ctx context.Context) error {
+	var lanSubnet string
+	if s.lanSubnet.IsValid() {
+		lanSubnet = s.lanSubnet.String()
+	}
+	slog.Info("starting gateway-monitor",
+		"gateway_ip", s.gatewayIP,
+		"wan_target", s.wanTarget,
+		"interval", s.interval.String(),
+		"pmtu_targets", s.pmtuTargets,
+		"lan_subnet", lanSubnet,
+		"conntrack", s.conntrack,
+		"traffic_accounting", s.traffic != nil,
+		"ra", s.ra,
+		"upnp", s.upnp,
+		"failover_uplinks", len(s.uplinks),
+	)
+
+	// The slower loops share ctx; Run returns only once they have stopped.
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	start := func(loop func(context.Context)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			loop(ctx)
+		}()
+	}
+	if len(s.pmtuTargets) > 0 {
+		start(s.runPathMTU)
+	}
+	if s.lanSubnet.IsValid() {
+		start(s.runLANSweep)
+	}
+	if s.conntrack {
+		start(s.runConntrack)
+	}
+	if s.ra {
+		start(s.runRA)
+	}
+	if s.upnp {
+		start(s.runUPnP)
+	}
+	if len(s.uplinks) > 0 {
+		start(s.runFailover)
+	}
+	if s.selfcheck != nil {
+		start(s.selfcheck.Run)
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		cycle := time.Now()
+		if !pause.Active("gateway-monitor") {
+			s.probeOnce(ctx)
+		}
+		s.health.Cycle(cycle, s.interval)
+	}
+}
+
+// tcpProbe runs a shared TCP probe against host with its own deadline,
+// through dialer when not nil. A host name is looked up first under
+// PROBE_RESOLVE_TIMEOUT_SECONDS, so a slow resolver cannot use up the
+// connect timeout; a failed lookup fails the probe.
+func (s *Service) tcpProbe(ctx context.Context, dialer *net.Dialer, host string) (time.Duration, error) {
+	addr := host
+	if probe.IsName(host) {
+		var took time.Duration
+		var err error
+		if addr, took, err = probe.Resolve(ctx, nil, host, s.resolveTimeout); err != nil {
+			dnsResolutionFailures.WithLabelValues(host, string(probe.Classify(err))).Inc()
+			return 0, err
+		}
+		dnsResolution.WithLabelValues(host).Set(took.Seconds())
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.probeTimeout)
+	defer cancel()
+	return probe.TCP(ctx, dialer, addr, s.probePorts...)
+}
+
+// canaryProbe probes a CANARY_TARGETS_JSON target like the gateway and WAN
+// target, without recording it.
+func (s *Service) canaryProbe(host string) (selfcheck.Probe, error) {
+	return func(ctx context.Context) error {
+		addr, _, err := probe.Resolve(ctx, nil, host, s.resolveTimeout)
+		if err == nil {
+			_, err = probe.TCP(ctx, nil, addr, s.probePorts...)
+		}
+		return err
+	}, nil
+}
+
+// probeOnce probes both targets and records failure domain transitions.
+// Transitions involving a target in a maintenance window are logged but
+// not counted as failure domain events.
+func (s *Service) probeOnce(ctx context.Context) {
+	now := time.Now()
+	gwMaint := s.maintenance.Active(s.gatewayIP, now)
+	wanMaint := s.maintenance.Active(s.wanTarget, now)
+
+	gwLatency, gwErr := s.tcpProbe(ctx, nil, s.gatewayIP)
+	gwUp := gwErr == nil
+	gatewayReachable.Set(metrics.BoolToFloat(gwUp))
+
+	if gwUp {
+		slog.Debug("gateway probe succeeded", "target", s.gatewayIP, "latency", gwLatency.String())
+	} else {
+		s.probeFailed("gateway", s.gatewayIP, gwErr, gwMaint)
+	}
+
+	wLatency, wErr := s.tcpProbe(ctx, nil, s.wanTarget)
+	wUp := wErr == nil
+	wanReachable.Set(metrics.BoolToFloat(wUp))
+
+	if wUp {
+		slog.Debug("wan probe succeeded", "target", s.wanTarget, "latency", wLatency.String())
+	} else {
+		s.probeFailed("wan", s.wanTarget, wErr, wanMaint)
+	}
+
+	// Detect state transitions into failure
+	gwTransitionDown := s.prevGatewayUp && !gwUp
+	wanTransitionDown := s.prevWanUp && !wUp
+
+	// A WAN outage is worth a look at the uplink's packets; the capture
+	// runs in the background and its file is named in the event.
+	var captureFile string
+	if wanTransitionDown && !wanMaint {
+		captureFile = s.capture.Trigger("wan_down", s.wanTarget)
+	}
+	var captureArgs []any
+	if captureFile != "" {
+		captureArgs = []any{"capture", captureFile}
+	}
+
+	if gwTransitionDown && wanTransitionDown {
+		s.failureDomainEvent("full", gwMaint || wanMaint, "failure domain: full network interruption",
+			append([]any{"gateway", s.gatewayIP, "wan", s.wanTarget}, captureArgs...)...)
+	} else if gwTransitionDown && !wanTransitionDown {
+		// Gateway just went down, WAN was already down or is still up
+		if wUp {
+			s.failureDomainEvent("lan", gwMaint, "failure domain: LAN instability",
+				"gateway", s.gatewayIP)
+		} else {
+			// Both are now down but WAN went down earlier
+			s.failureDomainEvent("full", gwMaint || wanMaint, "failure domain: full network interruption (gateway joined)",
+				"gateway", s.gatewayIP, "wan", s.wanTarget)
+		}
+	} else if wanTransitionDown && !gwTransitionDown {
+		// WAN just went down, gateway was already down or is still up
+		if gwUp {
+			s.failureDomainEvent("wan", wanMaint, "failure domain: WAN instability",
+				append([]any{"wan", s.wanTarget}, captureArgs...)...)
+		} else {
+			// Both are now down but gateway went down earlier
+			s.failureDomainEvent("full", gwMaint || wanMaint, "failure domain: full network interruption (wan joined)",
+				append([]any{"gateway", s.gatewayIP, "wan", s.wanTarget}, captureArgs...)...)
+		}
+	}
+
+	if gwUp != s.prevGatewayUp {
+		s.publish("gateway", s.gatewayIP, gwErr, gwMaint, now, "")
+	}
+	if wUp != s.prevWanUp {
+		s.publish("wan", s.wanTarget, wErr, wanMaint, now, captureFile)
+	}
+	s.prevGatewayUp = gwUp
+	s.prevWanUp = wUp
+}
+
+// publish reports a gateway or WAN state change to in-process subscribers
+// such as edge-monitor's correlator, with the packet capture it triggered.
+func (s *Service) publish(kind, target string, err error, inMaintenance bool, now time.Time, captureFile string) {
+	statebus.Publish(statebus.Change{
+		Time:        now,
+		Service:     "gateway-monitor",
+		Probe:       kind,
+		Target:      target,
+		Up:          err == nil,
+		ErrorClass:  string(probe.Classify(err)),
+		Maintenance: inMaintenance,
+		Capture:     captureFile,
+	})
+}
+
+func (s *Service) probeFailed(kind, target string, err error, inMaintenance bool) {
+	if inMaintenance {
+		s.maintenance.Failure(target)
+		slog.Info(kind+" probe failed during maintenance", "target", target, "error", err, "error_class", probe.Classify(err))
+		return
+	}
+	slog.Warn(kind+" probe failed", "target", target, "error", err, "error_class", probe.Classify(err))
+}
+
+// failureDomainEvent counts and logs a failure domain transition, unless
+// one of the targets involved is in maintenance.
+func (s *Service) failureDomainEvent(domain string, inMaintenance bool, msg string, args ...any) {
+	if inMaintenance {
+		slog.Info(msg+" (maintenance window, not counted)", args...)
+		return
+	}
+	failureDomainEventsTotal.WithLabelValues(domain).Inc()
+	slog.Error(msg, args...)
+}
@@ -0,0 +1,180 @@
+package gatewaymonitor
+
+import (
+	"log/slog"
+	"net/netip"
+	"strconv"
+	"time"
+)
+
+// trafficAccount attributes the byte counters of tracked connections
+// between LAN_SUBNET and the outside to the LAN device on the inside, so
+// a backup job saturating the uplink shows up as one device's counter.
+// Devices are identified like the LAN sweep's, by MAC address from the
+// neighbour table or by IP address when it has none.
+//
+// Counters are read every CONNTRACK_INTERVAL_SECONDS; the bytes a
+// connection carries between its last read and its end are not counted.
+type trafficAccount struct {
+	subnet  netip.Prefix
+	flows   map[string]trafficFlow
+	devices map[string]*trafficDevice
+	// baseline is false until the table has been read once; the bytes
+	// connections carried before the collector started are not counted.
+	baseline bool
+	full     bool
+	// noCounters is set while the table has entries but none carries byte
+	// counters, i.e. nf_conntrack_acct is off.
+	noCounters bool
+
+	// One walk of the table.
+	neighbours map[netip.Addr]string
+	seen       map[string]bool
+	entries    int
+	counted    int
+}
+
+// trafficFlow is a connection's counters at the previous read.
+type trafficFlow struct {
+	rx, tx uint64
+}
+
+type trafficDevice struct {
+	rx, tx     uint64 // bytes since the previous read
+	conns      int
+	lastActive time.Time
+}
+
+func newTrafficAccount(subnet netip.Prefix) *trafficAccount {
+	return &trafficAccount{
+		subnet:  subnet,
+		flows:   make(map[string]trafficFlow),
+		devices: make(map[string]*trafficDevice),
+	}
+}
+
+// begin starts a walk of the table.
+func (t *trafficAccount) begin() {
+	neighbours, err := readARPTable(t.subnet)
+	if err != nil {
+		slog.Debug("traffic accounting: neighbour table not readable; devices keyed by IP", "error", err)
+	}
+	t.neighbours = neighbours
+	t.seen = make(map[string]bool, len(t.flows))
+	t.entries, t.counted = 0, 0
+}
+
+// add accounts one entry. Only connections with one end inside the subnet
+// and the other outside count: outbound ones (the original source is the
+// device) and inbound ones, including port forwards where the reply comes
+// from the device. Traffic between two LAN hosts, hairpinned or not, does
+// not cross the uplink and is skipped.
+func (t *trafficAccount) add(e conntrackEntry) {
+	t.entries++
+	origSrc, err1 := netip.ParseAddr(e.orig["src"])
+	origDst, err2 := netip.ParseAddr(e.orig["dst"])
+	replySrc, err3 := netip.ParseAddr(e.reply["src"])
+	if err1 != nil || err2 != nil || err3 != nil {
+		return
+	}
+	origBytes, ok1 := parseCounter(e.orig["bytes"])
+	replyBytes, ok2 := parseCounter(e.reply["bytes"])
+	if !ok1 || !ok2 {
+		return
+	}
+	t.counted++
+
+	var ip netip.Addr
+	var rx, tx uint64
+	switch in := t.subnet.Contains; {
+	case in(origSrc) && !in(origDst) && !in(replySrc):
+		ip, tx, rx = origSrc, origBytes, replyBytes
+	case !in(origSrc) && in(replySrc):
+		ip, rx, tx = replySrc, origBytes, replyBytes
+	default:
+		return
+	}
+	device := ip.String()
+	if mac, ok := t.neighbours[ip]; ok {
+		device = mac
+	}
+
+	d, ok := t.devices[device]
+	if !ok {
+		if len(t.devices) >= lanMaxDevices {
+			if !t.full {
+				t.full = true
+				slog.Warn("traffic accounting device limit reached; ignoring new devices", "subnet", t.subnet.String(), "limit", lanMaxDevices)
+			}
+			return
+		}
+		d = &trafficDevice{}
+		t.devices[device] = d
+		trafficReceiveBytes.WithLabelValues(device).Add(0)
+		trafficTransmitBytes.WithLabelValues(device).Add(0)
+	}
+	d.conns++
+
+	prev, known := t.flows[e.key]
+	t.seen[e.key] = true
+	t.flows[e.key] = trafficFlow{rx: rx, tx: tx}
+	switch {
+	case !known && !t.baseline:
+		return
+	case !known:
+		// A connection opened since the previous read.
+	case rx < prev.rx || tx < prev.tx:
+		// The tuple was reused by a new connection.
+	default:
+		rx, tx = rx-prev.rx, tx-prev.tx
+	}
+	d.rx += rx
+	d.tx += tx
+}
+
+// end finishes a walk: it exports the bytes each device moved since the
+// previous read and forgets closed connections and long-idle devices.
+func (t *trafficAccount) end(now time.Time) {
+	for key := range t.flows {
+		if !t.seen[key] {
+			delete(t.flows, key)
+		}
+	}
+	for id, d := range t.devices {
+		if d.conns > 0 {
+			d.lastActive = now
+		}
+		trafficReceiveBytes.WithLabelValues(id).Add(float64(d.rx))
+		trafficTransmitBytes.WithLabelValues(id).Add(float64(d.tx))
+		trafficConnections.WithLabelValues(id).Set(float64(d.conns))
+		d.rx, d.tx, d.conns = 0, 0, 0
+		if now.Sub(d.lastActive) > lanForgetAfter {
+			trafficReceiveBytes.DeleteLabelValues(id)
+			trafficTransmitBytes.DeleteLabelValues(id)
+			trafficConnections.DeleteLabelValues(id)
+			delete(t.devices, id)
+			t.full = false
+		}
+	}
+
+	switch noCounters := t.entries > 0 && t.counted == 0; {
+	case noCounters && !t.noCounters:
+		slog.Warn("conntrack entries carry no byte counters; set net.netfilter.nf_conntrack_acct=1 for traffic accounting")
+	case !noCounters && t.noCounters:
+		slog.Info("conntrack byte counters available; traffic accounting resumed")
+	}
+	t.noCounters = t.entries > 0 && t.counted == 0
+	if !t.baseline {
+		t.baseline = true
+		slog.Info("traffic accounting baseline", "subnet", t.subnet.String(), "connections", len(t.flows), "devices", len(t.devices))
+	}
+	t.neighbours, t.seen = nil, nil
+}
+
+func parseCounter(v string) (uint64, bool) {
+	if v == "" {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	return n, err == nil
+}
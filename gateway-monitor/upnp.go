@@ -0,0 +1,353 @@
+package gatewaymonitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"edge-monitor-app/internal/metrics"
+	"edge-monitor-app/internal/pause"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// WAN connection services an Internet Gateway Device may offer, in order
+// of preference. Either answers GetStatusInfo and GetExternalIPAddress.
+var igdServiceTypes = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+var igdSearch = []byte("M-SEARCH * HTTP/1.1\r\n" +
+	"HOST: 239.255.255.250:1900\r\n" +
+	"MAN: \"ssdp:discover\"\r\n" +
+	"MX: 2\r\n" +
+	"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n\r\n")
+
+// upnpTimeout bounds each HTTP request to the router.
+const upnpTimeout = 5 * time.Second
+
+// igd is a discovered WAN connection service.
+type igd struct {
+	host        string // the router, for the metric label
+	controlURL  string
+	serviceType string
+}
+
+// igdStatus is the router's view of its WAN connection.
+type igdStatus struct {
+	connection string // Connected, Disconnected, Connecting, ...
+	lastError  string
+	uptime     time.Duration
+	externalIP string
+}
+
+// runUPnP asks the router for its WAN status at start and then every
+// upnpInterval until ctx is cancelled. The router knows whether it holds
+// a DHCP or PPP lease and which address it got, which the probes can only
+// infer. Without a router that answers UPnP nothing is exported.
+func (s *Service) runUPnP(ctx context.Context) {
+	ticker := time.NewTicker(s.upnpInterval)
+	defer ticker.Stop()
+
+	for {
+		if !pause.Active("gateway-monitor") {
+			s.pollUPnP(ctx)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) pollUPnP(ctx context.Context) {
+	if s.igd == nil {
+		dev, err := s.discoverIGD(ctx)
+		if err != nil {
+			if ctx.Err() == nil && !errors.Is(err, errNoIGD) {
+				upnpErrors.WithLabelValues("discover").Inc()
+				slog.Warn("UPnP gateway discovery failed", "error", err)
+			}
+			return
+		}
+		s.igd = dev
+		slog.Info("found UPnP gateway", "host", dev.host, "service", dev.serviceType, "control_url", dev.controlURL)
+	}
+
+	status, err := s.igd.status(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		upnpErrors.WithLabelValues("query").Inc()
+		slog.Warn("UPnP gateway query failed", "host", s.igd.host, "error", err)
+		// The control URL often moves when the router restarts.
+		s.igd = nil
+		return
+	}
+	s.updateIGD(status)
+}
+
+// updateIGD exports status and logs changes of connection state and
+// external address.
+func (s *Service) updateIGD(status igdStatus) {
+	host := s.igd.host
+	prev := s.igdStatus
+	s.igdStatus = status
+
+	igdConnected.WithLabelValues(host).Set(metrics.BoolToFloat(status.connection == "Connected"))
+	igdUptime.WithLabelValues(host).Set(status.uptime.Seconds())
+	igdInfo.DeletePartialMatch(prometheus.Labels{"gateway": host})
+	igdInfo.WithLabelValues(host, status.connection, status.externalIP, status.lastError).Set(1)
+
+	if prev.connection == "" {
+		igdExternalIPChanges.WithLabelValues(host).Add(0)
+		slog.Info("UPnP gateway WAN status", "host", host, "status", status.connection, "external_ip", status.externalIP, "uptime", status.uptime.String())
+		return
+	}
+	if prev.connection != status.connection {
+		log := slog.Warn
+		if status.connection == "Connected" {
+			log = slog.Info
+		}
+		log("UPnP gateway WAN status changed", "host", host, "from", prev.connection, "to", status.connection, "last_error", status.lastError)
+	}
+	if prev.externalIP != status.externalIP && prev.externalIP != "" && status.externalIP != "" {
+		igdExternalIPChanges.WithLabelValues(host).Inc()
+		slog.Warn("WAN address changed", "host", host, "from", prev.externalIP, "to", status.externalIP)
+	}
+}
+
+var errNoIGD = errors.New("no UPnP gateway answered")
+
+// discoverIGD finds the router's WAN connection service: from the
+// description at UPNP_IGD when it is a URL, otherwise by SSDP search,
+// multicast and to the gateway directly, preferring the gateway's answer.
+func (s *Service) discoverIGD(ctx context.Context) (*igd, error) {
+	if s.upnpLocation != "" {
+		return fetchIGD(ctx, s.upnpLocation)
+	}
+
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	targets := []netip.AddrPort{ssdpGroup}
+	if gw, err := netip.ParseAddr(s.gatewayIP); err == nil && gw.Is4() {
+		targets = append(targets, netip.AddrPortFrom(gw, 1900))
+	}
+	for _, t := range targets {
+		if _, err := conn.WriteToUDPAddrPort(igdSearch, t); err != nil {
+			slog.Debug("UPnP search not sent", "target", t, "error", err)
+		}
+	}
+
+	var locations []string
+	err = collect(ctx, conn, func(from netip.Addr, msg []byte) {
+		loc := ssdpLocation(msg)
+		if loc == "" {
+			return
+		}
+		if from.String() == s.gatewayIP {
+			locations = append([]string{loc}, locations...)
+		} else {
+			locations = append(locations, loc)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(locations) == 0 {
+		return nil, errNoIGD
+	}
+	var errs []error
+	for _, loc := range locations {
+		dev, err := fetchIGD(ctx, loc)
+		if err == nil {
+			return dev, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// ssdpLocation returns the LOCATION header of a successful SSDP answer.
+func ssdpLocation(msg []byte) string {
+	if !bytes.HasPrefix(msg, []byte("HTTP/1.1 200")) {
+		return ""
+	}
+	for _, line := range strings.Split(string(msg), "\r\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(name), "location") {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// igdDevice is the part of a UPnP device description that leads to the
+// WAN connection service, which sits in nested devices.
+type igdDevice struct {
+	Services []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []igdDevice `xml:"deviceList>device"`
+}
+
+// fetchIGD reads the device description at location and returns its WAN
+// connection service.
+func fetchIGD(ctx context.Context, location string) (*igd, error) {
+	base, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("UPnP location %q: %w", location, err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, upnpTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: status %d", location, resp.StatusCode)
+	}
+
+	var desc struct {
+		URLBase string    `xml:"URLBase"`
+		Device  igdDevice `xml:"device"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&desc); err != nil {
+		return nil, fmt.Errorf("%s: %w", location, err)
+	}
+	if desc.URLBase != "" {
+		if u, err := url.Parse(desc.URLBase); err == nil {
+			base = u
+		}
+	}
+	for _, serviceType := range igdServiceTypes {
+		control, ok := findService(desc.Device, serviceType)
+		if !ok {
+			continue
+		}
+		ref, err := url.Parse(control)
+		if err != nil {
+			return nil, fmt.Errorf("%s: control URL %q: %w", location, control, err)
+		}
+		u := base.ResolveReference(ref)
+		return &igd{host: u.Hostname(), controlURL: u.String(), serviceType: serviceType}, nil
+	}
+	return nil, fmt.Errorf("%s: no WAN connection service", location)
+}
+
+func findService(dev igdDevice, serviceType string) (string, bool) {
+	for _, svc := range dev.Services {
+		if svc.ServiceType == serviceType {
+			return svc.ControlURL, true
+		}
+	}
+	for _, child := range dev.Devices {
+		if control, ok := findService(child, serviceType); ok {
+			return control, true
+		}
+	}
+	return "", false
+}
+
+// status calls GetStatusInfo and GetExternalIPAddress.
+func (d *igd) status(ctx context.Context) (igdStatus, error) {
+	info, err := d.call(ctx, "GetStatusInfo")
+	if err != nil {
+		return igdStatus{}, err
+	}
+	addr, err := d.call(ctx, "GetExternalIPAddress")
+	if err != nil {
+		return igdStatus{}, err
+	}
+	status := igdStatus{
+		connection: info["NewConnectionStatus"],
+		lastError:  info["NewLastConnectionError"],
+		externalIP: addr["NewExternalIPAddress"],
+	}
+	if status.connection == "" {
+		return igdStatus{}, errors.New("GetStatusInfo: no NewConnectionStatus")
+	}
+	if v, err := strconv.ParseUint(info["NewUptime"], 10, 32); err == nil {
+		status.uptime = time.Duration(v) * time.Second
+	}
+	return status, nil
+}
+
+// call invokes a SOAP action without arguments and returns the elements
+// of its response.
+func (d *igd) call(ctx context.Context, action string) (map[string]string, error) {
+	body := `<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:` + action + ` xmlns:u="` + d.serviceType + `"/></s:Body></s:Envelope>`
+	ctx, cancel := context.WithTimeout(ctx, upnpTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.controlURL, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+d.serviceType+"#"+action+`"`)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", action, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: status %d", action, resp.StatusCode)
+	}
+
+	// The response is <Envelope><Body><{action}Response> with one element
+	// per output argument; namespaces vary between routers, so only local
+	// names are compared.
+	values := make(map[string]string)
+	dec := xml.NewDecoder(io.LimitReader(resp.Body, 64<<10))
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", action, err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 4 {
+				var v string
+				if err := dec.DecodeElement(&v, &t); err != nil {
+					return nil, fmt.Errorf("%s: %w", action, err)
+				}
+				values[t.Name.Local] = strings.TrimSpace(v)
+				depth--
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,117 @@
+// Package buildinfo describes the running build: its version, the commit it
+// was built from and the Go version, exported with each service's enabled
+// features as build_info, so a fleet running mixed versions or
+// configurations can be audited from Prometheus.
+//
+// The version and commit are stamped at link time:
+//
+//	go build -ldflags "-X edge-monitor-app/internal/buildinfo.Version=v1.4.0 -X edge-monitor-app/internal/buildinfo.Commit=3f9c0a5"
+//
+// Without them the commit falls back to the VCS revision the Go toolchain
+// records when building inside a git checkout.
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+
+	"edge-monitor-app/internal/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Version and Commit are set with -ldflags -X.
+var (
+	Version = "dev"
+	Commit  = ""
+)
+
+var (
+	buildInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "build_info",
+			Help: "Build of the service, always 1; features lists its enabled optional features",
+		},
+		[]string{"service", "version", "commit", "go_version", "features"},
+	)
+
+	featureEnabled = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "feature_enabled",
+			Help: "Whether an optional feature of the service is enabled (1) or not (0)",
+		},
+		[]string{"service", "feature"},
+	)
+
+	registerOnce sync.Once
+	commitOnce   sync.Once
+	commit       string
+)
+
+// Info is the build as reported on /healthz.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the running build.
+func Get() Info {
+	return Info{Version: Version, Commit: revision(), GoVersion: runtime.Version()}
+}
+
+// revision returns Commit, or else the VCS revision recorded by the Go
+// toolchain ("unknown" without either, e.g. in a Docker build without the
+// .git directory).
+func revision() string {
+	commitOnce.Do(func() {
+		commit = Commit
+		if commit != "" {
+			return
+		}
+		commit = "unknown"
+		bi, ok := debug.ReadBuildInfo()
+		if !ok {
+			return
+		}
+		var dirty bool
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				commit = s.Value
+			case "vcs.modified":
+				dirty = s.Value == "true"
+			}
+		}
+		if len(commit) > 12 {
+			commit = commit[:12]
+		}
+		if dirty && commit != "unknown" {
+			commit += "-dirty"
+		}
+	})
+	return commit
+}
+
+// Register exports build_info for service, with its enabled features, and
+// feature_enabled for every feature in features. A service calls it once
+// from its constructor, so a combined binary exports one series per
+// service it runs.
+func Register(service string, features map[string]bool) {
+	registerOnce.Do(func() {
+		prometheus.MustRegister(buildInfo, featureEnabled)
+	})
+	var enabled []string
+	for name, on := range features {
+		featureEnabled.WithLabelValues(service, name).Set(metrics.BoolToFloat(on))
+		if on {
+			enabled = append(enabled, name)
+		}
+	}
+	sort.Strings(enabled)
+	info := Get()
+	buildInfo.WithLabelValues(service, info.Version, info.Commit, info.GoVersion, strings.Join(enabled, ",")).Set(1)
+}
@@ -0,0 +1,228 @@
+// Package capture records short packet captures on the uplink when a probe
+// sees the network degrade, so a loss burst or WAN outage can be looked at
+// packet by packet afterwards. Services trigger a capture on the event and
+// log the file name with it; the capture itself runs in the background.
+//
+// Capturing is off unless CAPTURE_ENABLED is set, Linux only, and needs
+// CAP_NET_RAW; without the capability it logs a warning at startup and
+// stays off. Each capture is bounded by CAPTURE_SECONDS, CAPTURE_MAX_BYTES
+// and a CAPTURE_SNAPLEN of header bytes per packet, at most one runs at a
+// time and not more often than CAPTURE_COOLDOWN, and only the newest
+// CAPTURE_RETAIN files in CAPTURE_DIR are kept.
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"edge-monitor-app/internal/config"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	capturesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "packet_captures_total",
+			Help: "Packet captures triggered by degradation, by reason and result (ok, failed, skipped)",
+		},
+		[]string{"service", "reason", "result"},
+	)
+
+	capturedBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "packet_capture_bytes_total",
+			Help: "Bytes written to packet capture files",
+		},
+		[]string{"service"},
+	)
+
+	registerOnce sync.Once
+)
+
+// errUnsupported is returned where packet capture is not implemented.
+var errUnsupported = errors.New("packet capture is only supported on Linux")
+
+// Capturer takes the captures of one service. A nil Capturer, or one
+// loaded with capturing off, never captures.
+type Capturer struct {
+	service  string
+	iface    string // empty follows the default route
+	dir      string
+	duration time.Duration
+	maxBytes int
+	snaplen  int
+	retain   int
+	cooldown time.Duration
+
+	mu      sync.Mutex
+	running bool
+	last    time.Time
+}
+
+// Load reads the CAPTURE_* settings for service. When capturing is
+// enabled it checks that a capture socket can be opened, returning a
+// disabled Capturer with a warning when it cannot.
+func Load(service string) (*Capturer, error) {
+	if !config.Bool("CAPTURE_ENABLED", false) {
+		return nil, nil
+	}
+	c := &Capturer{
+		service:  service,
+		iface:    config.String("CAPTURE_INTERFACE", ""),
+		dir:      config.String("CAPTURE_DIR", "/var/lib/edge-monitor/captures"),
+		duration: config.Seconds("CAPTURE_SECONDS", 10*time.Second),
+		maxBytes: config.Int("CAPTURE_MAX_BYTES", 5<<20),
+		snaplen:  config.Int("CAPTURE_SNAPLEN", 256),
+		retain:   config.Int("CAPTURE_RETAIN", 20),
+		cooldown: config.Seconds("CAPTURE_COOLDOWN_SECONDS", 5*time.Minute),
+	}
+	switch {
+	case c.duration <= 0:
+		return nil, fmt.Errorf("CAPTURE_SECONDS must be positive")
+	case c.maxBytes < 1024:
+		return nil, fmt.Errorf("CAPTURE_MAX_BYTES must be at least 1024, got %d", c.maxBytes)
+	case c.snaplen < 64 || c.snaplen > 65535:
+		return nil, fmt.Errorf("CAPTURE_SNAPLEN must be 64 to 65535, got %d", c.snaplen)
+	case c.retain < 1:
+		return nil, fmt.Errorf("CAPTURE_RETAIN must be at least 1, got %d", c.retain)
+	}
+	if err := os.MkdirAll(c.dir, 0o750); err != nil {
+		return nil, fmt.Errorf("CAPTURE_DIR: %w", err)
+	}
+	if err := checkCapture(); err != nil {
+		slog.Warn("packet capture disabled", "service", service, "error", err)
+		return nil, nil
+	}
+	registerOnce.Do(func() {
+		prometheus.MustRegister(capturesTotal, capturedBytes)
+	})
+	slog.Info("packet capture on degradation enabled", "service", service, "interface", c.iface, "dir", c.dir,
+		"seconds", c.duration.Seconds(), "max_bytes", c.maxBytes, "retain", c.retain)
+	return c, nil
+}
+
+// Trigger starts a capture for reason (loss_burst, wan_down, ...) seen on
+// target and returns the name of the file it writes in CAPTURE_DIR, or ""
+// when no capture starts: capturing is off, one is running, or the last
+// one was less than CAPTURE_COOLDOWN ago.
+func (c *Capturer) Trigger(reason, target string) string {
+	if c == nil {
+		return ""
+	}
+	now := time.Now()
+	c.mu.Lock()
+	if c.running || (!c.last.IsZero() && now.Sub(c.last) < c.cooldown) {
+		c.mu.Unlock()
+		capturesTotal.WithLabelValues(c.service, reason, "skipped").Inc()
+		return ""
+	}
+	c.running, c.last = true, now
+	c.mu.Unlock()
+
+	iface := c.iface
+	if iface == "" {
+		var err error
+		if iface, err = defaultRouteInterface(); err != nil {
+			c.finish(reason, "", err)
+			return ""
+		}
+	}
+	name := fmt.Sprintf("%s-%s-%s-%s.pcap", c.service, now.UTC().Format("20060102T150405Z"), reason, fileSafe(target))
+	go func() {
+		err := c.capture(iface, filepath.Join(c.dir, name))
+		c.finish(reason, name, err)
+		if err == nil {
+			c.prune()
+		}
+	}()
+	slog.Info("packet capture started", "service", c.service, "reason", reason, "target", target, "interface", iface, "file", name)
+	return name
+}
+
+func (c *Capturer) finish(reason, name string, err error) {
+	c.mu.Lock()
+	c.running = false
+	c.mu.Unlock()
+	if err != nil {
+		capturesTotal.WithLabelValues(c.service, reason, "failed").Inc()
+		slog.Warn("packet capture failed", "service", c.service, "reason", reason, "file", name, "error", err)
+		return
+	}
+	capturesTotal.WithLabelValues(c.service, reason, "ok").Inc()
+}
+
+// capture writes packets seen on iface to path until the duration or the
+// byte limit is reached.
+func (c *Capturer) capture(iface, path string) error {
+	src, err := openCapture(iface)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	w, err := newPcapWriter(f, c.snaplen)
+	if err == nil {
+		err = src.copyTo(w, time.Now().Add(c.duration), c.maxBytes)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	capturedBytes.WithLabelValues(c.service).Add(float64(w.written))
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	slog.Info("packet capture finished", "service", c.service, "file", filepath.Base(path), "packets", w.packets, "bytes", w.written)
+	return nil
+}
+
+// prune deletes the oldest captures in the directory beyond retain. The
+// services share the directory and the limit.
+func (c *Capturer) prune() {
+	files, err := filepath.Glob(filepath.Join(c.dir, "*.pcap"))
+	if err != nil || len(files) <= c.retain {
+		return
+	}
+	type capture struct {
+		path string
+		mod  time.Time
+	}
+	var captures []capture
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil {
+			captures = append(captures, capture{f, info.ModTime()})
+		}
+	}
+	slices.SortFunc(captures, func(a, b capture) int { return b.mod.Compare(a.mod) })
+	for _, old := range captures[min(c.retain, len(captures)):] {
+		if err := os.Remove(old.path); err == nil {
+			slog.Debug("removed old packet capture", "file", filepath.Base(old.path))
+		}
+	}
+}
+
+// fileSafe reduces a target to characters that are safe in a file name.
+func fileSafe(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, s)
+	if len(s) > 64 {
+		s = s[:64]
+	}
+	return s
+}
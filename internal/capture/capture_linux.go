@@ -0,0 +1,146 @@
+//go:build linux
+
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ethPAll is ETH_P_ALL in network byte order, as socket and bind expect.
+var ethPAll = htons(syscall.ETH_P_ALL)
+
+// readTimeout bounds each receive so a quiet link still stops on time.
+const readTimeout = 200 * time.Millisecond
+
+// source is an AF_PACKET socket bound to one interface. SOCK_DGRAM strips
+// the link layer header; the kernel's address says what it was.
+type source struct {
+	fd int
+}
+
+// checkCapture opens and closes a packet socket, which fails without
+// CAP_NET_RAW.
+func checkCapture() error {
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, int(ethPAll))
+	if err != nil {
+		if errors.Is(err, syscall.EPERM) {
+			return fmt.Errorf("needs CAP_NET_RAW: %w", err)
+		}
+		return os.NewSyscallError("socket", err)
+	}
+	return syscall.Close(fd)
+}
+
+func openCapture(iface string) (*source, error) {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, int(ethPAll))
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	s := &source{fd: fd}
+	if err := syscall.Bind(fd, &syscall.SockaddrLinklayer{Protocol: ethPAll, Ifindex: ifi.Index}); err != nil {
+		s.Close()
+		return nil, os.NewSyscallError("bind", err)
+	}
+	tv := syscall.NsecToTimeval(readTimeout.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		s.Close()
+		return nil, os.NewSyscallError("setsockopt SO_RCVTIMEO", err)
+	}
+	return s, nil
+}
+
+// copyTo writes received packets to w until deadline, or until the file
+// would exceed maxBytes.
+func (s *source) copyTo(w *pcapWriter, deadline time.Time, maxBytes int) error {
+	buf := make([]byte, 65536)
+	for time.Now().Before(deadline) {
+		n, from, err := syscall.Recvfrom(s.fd, buf, syscall.MSG_TRUNC)
+		if err != nil {
+			if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) {
+				continue
+			}
+			return os.NewSyscallError("recvfrom", err)
+		}
+		ll, ok := from.(*syscall.SockaddrLinklayer)
+		if !ok {
+			continue
+		}
+		pkt := packet{
+			time:     time.Now(),
+			pktType:  uint16(ll.Pkttype),
+			hatype:   ll.Hatype,
+			addr:     ll.Addr[:min(int(ll.Halen), len(ll.Addr))],
+			protocol: htons(ll.Protocol),
+			data:     buf[:min(n, len(buf))],
+			origLen:  n,
+		}
+		if w.written+32+min(len(pkt.data), w.snaplen) > maxBytes {
+			break
+		}
+		if err := w.write(pkt); err != nil {
+			return err
+		}
+	}
+	return w.flush()
+}
+
+func (s *source) Close() error { return syscall.Close(s.fd) }
+
+// defaultRouteInterface returns the interface of the IPv4 default route
+// with the lowest metric, the uplink.
+func defaultRouteInterface() (string, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	best, bestMetric := "", -1
+	sc := bufio.NewScanner(f)
+	sc.Scan() // header
+	for sc.Scan() {
+		// Iface Destination Gateway Flags RefCnt Use Metric Mask ...
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 8 || fields[1] != "00000000" || fields[7] != "00000000" {
+			continue
+		}
+		flags, err := strconv.ParseUint(fields[3], 16, 16)
+		if err != nil || flags&syscall.RTF_UP == 0 {
+			continue
+		}
+		metric, err := strconv.Atoi(fields[6])
+		if err != nil {
+			continue
+		}
+		if bestMetric < 0 || metric < bestMetric {
+			best, bestMetric = fields[0], metric
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return "", err
+	}
+	if best == "" {
+		return "", errors.New("no default route; set CAPTURE_INTERFACE")
+	}
+	return best, nil
+}
+
+// htons converts between host and network byte order.
+func htons(v uint16) uint16 {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	return binary.NativeEndian.Uint16(b[:])
+}
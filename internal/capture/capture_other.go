@@ -0,0 +1,17 @@
+//go:build !linux
+
+package capture
+
+import "time"
+
+type source struct{}
+
+func checkCapture() error { return errUnsupported }
+
+func openCapture(string) (*source, error) { return nil, errUnsupported }
+
+func defaultRouteInterface() (string, error) { return "", errUnsupported }
+
+func (*source) copyTo(*pcapWriter, time.Time, int) error { return errUnsupported }
+
+func (*source) Close() error { return nil }
@@ -0,0 +1,77 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// linktypeLinuxSLL is the "Linux cooked" link type tcpdump uses for -i any.
+// Capturing below the link layer header works the same on Ethernet, PPP
+// and raw-IP uplinks such as LTE modems.
+const linktypeLinuxSLL = 113
+
+// packet is one captured packet, without its link layer header.
+type packet struct {
+	time     time.Time
+	pktType  uint16 // PACKET_HOST, PACKET_OUTGOING, ...
+	hatype   uint16 // ARPHRD_* of the interface
+	addr     []byte // link layer source address, up to 8 bytes
+	protocol uint16 // EtherType
+	data     []byte
+	origLen  int
+}
+
+// pcapWriter writes the classic libpcap file format.
+type pcapWriter struct {
+	w       *bufio.Writer
+	snaplen int
+	written int
+	packets int
+}
+
+func newPcapWriter(w io.Writer, snaplen int) (*pcapWriter, error) {
+	p := &pcapWriter{w: bufio.NewWriter(w), snaplen: snaplen}
+	var hdr [24]byte
+	binary.LittleEndian.PutUint32(hdr[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(hdr[4:], 2)
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], uint32(snaplen))
+	binary.LittleEndian.PutUint32(hdr[20:], linktypeLinuxSLL)
+	if _, err := p.w.Write(hdr[:]); err != nil {
+		return nil, err
+	}
+	p.written = len(hdr)
+	return p, nil
+}
+
+// write appends pkt, cut to the snap length.
+func (p *pcapWriter) write(pkt packet) error {
+	var sll [16]byte
+	binary.BigEndian.PutUint16(sll[0:], pkt.pktType)
+	binary.BigEndian.PutUint16(sll[2:], pkt.hatype)
+	n := copy(sll[6:14], pkt.addr)
+	binary.BigEndian.PutUint16(sll[4:], uint16(n))
+	binary.BigEndian.PutUint16(sll[14:], pkt.protocol)
+
+	data := pkt.data
+	if len(sll)+len(data) > p.snaplen {
+		data = data[:max(p.snaplen-len(sll), 0)]
+	}
+	var rec [16]byte
+	binary.LittleEndian.PutUint32(rec[0:], uint32(pkt.time.Unix()))
+	binary.LittleEndian.PutUint32(rec[4:], uint32(pkt.time.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(rec[8:], uint32(len(sll)+len(data)))
+	binary.LittleEndian.PutUint32(rec[12:], uint32(len(sll)+pkt.origLen))
+	for _, b := range [][]byte{rec[:], sll[:], data} {
+		if _, err := p.w.Write(b); err != nil {
+			return err
+		}
+	}
+	p.written += len(rec) + len(sll) + len(data)
+	p.packets++
+	return nil
+}
+
+func (p *pcapWriter) flush() error { return p.w.Flush() }
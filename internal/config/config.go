@@ -0,0 +1,308 @@
+// Package config is the single way services read their settings. Every
+// setting is named by its environment variable; a YAML file (CONFIG_FILE or
+// -config) can set the same keys, and the environment overrides the file.
+// Accessors take the default, validate the value and remember what was
+// used, so a binary can report invalid settings together at startup and
+// print its effective configuration with -print-config.
+//
+// The process has one configuration, like the default Prometheus registry:
+// edge-monitor's services read the same keys their standalone binaries do.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Where a setting's value came from.
+const (
+	sourceDefault = "default"
+	sourceFile    = "file"
+	sourceEnv     = "env"
+)
+
+type setting struct {
+	value  string
+	source string
+	secret bool
+}
+
+var (
+	mu       sync.Mutex
+	path     string
+	file     map[string]string
+	settings = make(map[string]setting)
+	errs     []error
+)
+
+// Options are the command-line flags every binary accepts.
+type Options struct {
+	File  string
+	Print bool
+}
+
+// RegisterFlags registers -config and -print-config on fs.
+func RegisterFlags(fs *flag.FlagSet) *Options {
+	o := &Options{}
+	fs.StringVar(&o.File, "config", "", "YAML settings file (default $CONFIG_FILE); the environment overrides it")
+	fs.BoolVar(&o.Print, "print-config", false, "print the effective configuration and exit")
+	return o
+}
+
+// Load reads the settings file at p, or at CONFIG_FILE when p is empty. No
+// file is not an error: the environment and defaults apply.
+func Load(p string) error {
+	if p == "" {
+		p = strings.TrimSpace(os.Getenv("CONFIG_FILE"))
+	}
+	if p == "" {
+		return nil
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	values, err := parse(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", p, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	path, file = p, values
+	return nil
+}
+
+// lookup returns the value of key from the environment or the file.
+func lookup(key string) (string, string, bool) {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v, sourceEnv, true
+	}
+	mu.Lock()
+	v, ok := file[key]
+	mu.Unlock()
+	if ok && v != "" {
+		return v, sourceFile, true
+	}
+	return "", sourceDefault, false
+}
+
+// record remembers the value used for key. The first read wins: services
+// sharing a key in edge-monitor see the same value.
+func record(key, value, source string, secret bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := settings[key]; !ok {
+		settings[key] = setting{value: value, source: source, secret: secret}
+	}
+}
+
+func invalid(key, value, want string) {
+	mu.Lock()
+	defer mu.Unlock()
+	errs = append(errs, fmt.Errorf("%s=%q: %s", key, value, want))
+}
+
+// IsSet reports whether key has a value in the environment or the file.
+func IsSet(key string) bool {
+	_, _, ok := lookup(key)
+	return ok
+}
+
+// String returns the value of key, or def.
+func String(key, def string) string {
+	v, src, ok := lookup(key)
+	if !ok {
+		v = def
+	}
+	record(key, v, src, false)
+	return v
+}
+
+// Secret is String for credentials; -print-config redacts them.
+func Secret(key string) string {
+	v, src, _ := lookup(key)
+	record(key, v, src, true)
+	return v
+}
+
+// List returns the comma-separated values of key, trimmed, without empty
+// entries. A YAML list in the file reads the same.
+func List(key string) []string {
+	v, src, _ := lookup(key)
+	record(key, v, src, false)
+	if v == "" {
+		return nil
+	}
+	parts := strings.Split(v, ",")
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if t := strings.TrimSpace(p); t != "" {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// Int returns key as an integer, or def.
+func Int(key string, def int) int {
+	v, src, ok := lookup(key)
+	if !ok {
+		record(key, strconv.Itoa(def), src, false)
+		return def
+	}
+	record(key, v, src, false)
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		invalid(key, v, "want an integer")
+		return def
+	}
+	return n
+}
+
+// Float returns key as a number, or def.
+func Float(key string, def float64) float64 {
+	v, src, ok := lookup(key)
+	if !ok {
+		record(key, strconv.FormatFloat(def, 'g', -1, 64), src, false)
+		return def
+	}
+	record(key, v, src, false)
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		invalid(key, v, "want a number")
+		return def
+	}
+	return f
+}
+
+// Bool returns key as a boolean (true/false, 1/0, yes/no, on/off), or def.
+func Bool(key string, def bool) bool {
+	v, src, ok := lookup(key)
+	if !ok {
+		record(key, strconv.FormatBool(def), src, false)
+		return def
+	}
+	record(key, v, src, false)
+	switch strings.ToLower(v) {
+	case "yes", "on":
+		return true
+	case "no", "off":
+		return false
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		invalid(key, v, "want true or false")
+		return def
+	}
+	return b
+}
+
+// Duration returns key as a Go duration ("30s", "5m"), or def.
+func Duration(key string, def time.Duration) time.Duration {
+	v, src, ok := lookup(key)
+	if !ok {
+		record(key, def.String(), src, false)
+		return def
+	}
+	record(key, v, src, false)
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		invalid(key, v, "want a duration such as 30s or 5m")
+		return def
+	}
+	return d
+}
+
+// Seconds returns key, a positive number of seconds (fractions allowed),
+// or def.
+func Seconds(key string, def time.Duration) time.Duration {
+	v, src, ok := lookup(key)
+	if !ok {
+		record(key, strconv.FormatFloat(def.Seconds(), 'f', -1, 64), src, false)
+		return def
+	}
+	record(key, v, src, false)
+	d, err := time.ParseDuration(v + "s")
+	if err != nil || d <= 0 {
+		invalid(key, v, "want a positive number of seconds")
+		return def
+	}
+	return d
+}
+
+// Invalid records a setting that parsed but failed a service's own check,
+// so it is reported with the others.
+func Invalid(key, want string) {
+	v, _, _ := lookup(key)
+	invalid(key, v, want)
+}
+
+// Err returns every invalid setting read so far, joined. File keys that no
+// accessor has read are only logged: settings of disabled features are
+// never read.
+func Err() error {
+	mu.Lock()
+	defer mu.Unlock()
+	var unused []string
+	for key := range file {
+		if _, ok := settings[key]; !ok {
+			unused = append(unused, key)
+		}
+	}
+	if len(unused) > 0 {
+		sort.Strings(unused)
+		slog.Warn("config file sets keys nothing read", "file", path, "keys", unused)
+	}
+	return errors.Join(errs...)
+}
+
+// Print writes the effective configuration as YAML that Load accepts, with
+// each value's source as a comment. Secrets are redacted.
+func Print(w io.Writer) error {
+	mu.Lock()
+	defer mu.Unlock()
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	width := 0
+	for _, key := range keys {
+		s := settings[key]
+		width = max(width, len(key)+len(render(s)))
+	}
+	for _, key := range keys {
+		s := settings[key]
+		line := key + ": " + render(s)
+		if _, err := fmt.Fprintf(w, "%-*s  # %s\n", width+2, line, s.source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func render(s setting) string {
+	if s.secret && s.value != "" {
+		return `"<redacted>"`
+	}
+	return strconv.Quote(s.value)
+}
+
+// LogOutput is where a binary should log: stderr while printing the
+// configuration, so stdout carries only the YAML.
+func (o *Options) LogOutput() io.Writer {
+	if o.Print {
+		return os.Stderr
+	}
+	return os.Stdout
+}
@@ -0,0 +1,212 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parse reads the YAML subset a settings file needs: one top-level mapping
+// of keys to scalars (plain, 'single' or "double" quoted) or lists of
+// scalars (block "- item" lines or flow [a, b]), with # comments. Lists
+// become comma-separated values, as in the environment. Keys are matched
+// case-insensitively to the environment variable names. Nested mappings,
+// anchors and block scalars are rejected rather than misread.
+func parse(data []byte) (map[string]string, error) {
+	values := make(map[string]string)
+	var listKey string
+	var items []string
+	flush := func() error {
+		if listKey == "" {
+			return nil
+		}
+		for _, item := range items {
+			if strings.Contains(item, ",") {
+				return fmt.Errorf("%s: list item %q contains a comma", listKey, item)
+			}
+		}
+		values[listKey] = strings.Join(items, ",")
+		listKey, items = "", nil
+		return nil
+	}
+
+	for i, raw := range strings.Split(string(data), "\n") {
+		n := i + 1
+		line := strings.TrimRight(raw, " \t\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if trimmed == "---" && len(values) == 0 && listKey == "" {
+			continue
+		}
+
+		if line[0] == ' ' || line[0] == '\t' || trimmed == "-" || strings.HasPrefix(trimmed, "- ") {
+			if listKey == "" || !strings.HasPrefix(trimmed, "-") {
+				return nil, fmt.Errorf("line %d: nested mappings are not supported; use one key per setting", n)
+			}
+			item, rest, err := scalar(strings.TrimSpace(trimmed[1:]), false)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			if rest != "" {
+				return nil, fmt.Errorf("line %d: unexpected %q after list item", n, rest)
+			}
+			items = append(items, item)
+			continue
+		}
+		if err := flush(); err != nil {
+			return nil, err
+		}
+
+		key, rest, ok := strings.Cut(trimmed, ":")
+		key = strings.TrimSpace(key)
+		if !ok || !validKey(key) {
+			return nil, fmt.Errorf("line %d: want \"KEY: value\", got %q", n, trimmed)
+		}
+		name := strings.ToUpper(key)
+		if _, dup := values[name]; dup {
+			return nil, fmt.Errorf("line %d: %s is set twice", n, name)
+		}
+		rest = strings.TrimSpace(rest)
+		switch {
+		case rest == "" || strings.HasPrefix(rest, "#"):
+			// Empty, or a block list on the following lines.
+			values[name] = ""
+			listKey = name
+		case rest[0] == '[':
+			list, err := flowList(rest)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			listKey, items = name, list
+			if err := flush(); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+		case strings.ContainsRune("{|>&*!", rune(rest[0])):
+			return nil, fmt.Errorf("line %d: %s: only scalars and lists are supported", n, name)
+		default:
+			v, tail, err := scalar(rest, false)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			if tail != "" {
+				return nil, fmt.Errorf("line %d: unexpected %q after value", n, tail)
+			}
+			values[name] = v
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func validKey(k string) bool {
+	if k == "" {
+		return false
+	}
+	for i, c := range k {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// flowList parses "[a, 'b', "c"]" and an optional trailing comment.
+func flowList(s string) ([]string, error) {
+	s = strings.TrimSpace(s[1:])
+	var out []string
+	for {
+		if strings.HasPrefix(s, "]") {
+			break
+		}
+		item, rest, err := scalar(s, true)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, item)
+		s = strings.TrimSpace(rest)
+		switch {
+		case strings.HasPrefix(s, ","):
+			s = strings.TrimSpace(s[1:])
+		case strings.HasPrefix(s, "]"):
+		default:
+			return nil, fmt.Errorf("unterminated list")
+		}
+	}
+	if tail := strings.TrimSpace(s[1:]); tail != "" && !strings.HasPrefix(tail, "#") {
+		return nil, fmt.Errorf("unexpected %q after list", tail)
+	}
+	return out, nil
+}
+
+// scalar parses the value at the start of s and returns what follows it,
+// with a trailing comment dropped. Inside a flow list a plain value ends at
+// the next comma or bracket.
+func scalar(s string, inFlow bool) (string, string, error) {
+	if s == "" {
+		return "", "", nil
+	}
+	switch s[0] {
+	case '"':
+		end := 1
+		for end < len(s) && s[end] != '"' {
+			if s[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(s) {
+			return "", "", fmt.Errorf("unterminated string %s", s)
+		}
+		v, err := strconv.Unquote(s[:end+1])
+		if err != nil {
+			return "", "", fmt.Errorf("invalid string %s", s[:end+1])
+		}
+		return v, dropComment(s[end+1:]), nil
+	case '\'':
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			if s[i] != '\'' {
+				b.WriteByte(s[i])
+				continue
+			}
+			if i+1 < len(s) && s[i+1] == '\'' {
+				b.WriteByte('\'')
+				i++
+				continue
+			}
+			return b.String(), dropComment(s[i+1:]), nil
+		}
+		return "", "", fmt.Errorf("unterminated string %s", s)
+	}
+
+	v := s
+	rest := ""
+	if inFlow {
+		if i := strings.IndexAny(v, ",]"); i >= 0 {
+			v, rest = v[:i], v[i:]
+		}
+	}
+	if i := strings.Index(v, " #"); i >= 0 {
+		v, rest = v[:i], ""
+	}
+	v = strings.TrimSpace(v)
+	if v == "~" || v == "null" {
+		v = ""
+	}
+	return v, rest, nil
+}
+
+func dropComment(s string) string {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "#") {
+		return ""
+	}
+	return s
+}
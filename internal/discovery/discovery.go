@@ -0,0 +1,143 @@
+// Package discovery finds probe targets on the host itself: the default
+// gateways, the DNS servers it was handed and well-known anycast IPs. The
+// probes use it for DISCOVER_TARGETS.
+package discovery
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Discovery sources. Paths are variables so hosts with non-standard layouts
+// (or containers with host paths mounted elsewhere) can be supported.
+var (
+	routeTablePath  = "/proc/net/route"
+	resolvConfPaths = []string{"/etc/resolv.conf", "/run/systemd/resolve/resolv.conf"}
+	dhcpLeaseGlobs  = []string{"/var/lib/dhcp/*.leases", "/var/lib/dhclient/*.leases"}
+)
+
+// DefaultAnycast is the DISCOVERY_ANYCAST_TARGETS default.
+const DefaultAnycast = "1.1.1.1,8.8.8.8,9.9.9.9"
+
+// Targets returns probe targets for the default gateway(s), the DNS
+// servers from resolv.conf and DHCP leases (probed on port 53), and the
+// configured well-known anycast IPs. Sources that cannot be read are skipped.
+func Targets(anycast []string) []string {
+	var out []string
+	seen := make(map[string]bool)
+	add := func(t string) {
+		if t != "" && !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+
+	for _, gw := range defaultGateways() {
+		add(gw)
+	}
+	for _, ns := range nameservers() {
+		add(net.JoinHostPort(ns, "53"))
+	}
+	for _, ip := range anycast {
+		add(ip)
+	}
+	return out
+}
+
+// defaultGateways parses the IPv4 default routes from /proc/net/route.
+func defaultGateways() []string {
+	f, err := os.Open(routeTablePath)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var out []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// Iface Destination Gateway Flags ...
+		if len(fields) < 4 || fields[1] != "00000000" || fields[2] == "00000000" {
+			continue
+		}
+		raw, err := hex.DecodeString(fields[2])
+		if err != nil || len(raw) != 4 {
+			continue
+		}
+		// The kernel prints the address in host (little-endian) byte order.
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, binary.LittleEndian.Uint32(raw))
+		out = append(out, ip.String())
+	}
+	return out
+}
+
+// nameservers collects non-loopback DNS servers from resolv.conf files and
+// DHCP client leases. Loopback stubs (e.g. systemd-resolved) are skipped
+// because the upstream servers are what matter for reachability.
+func nameservers() []string {
+	var out []string
+	for _, path := range resolvConfPaths {
+		out = append(out, scanValues(path, "nameserver")...)
+	}
+	for _, pattern := range dhcpLeaseGlobs {
+		matches, _ := filepath.Glob(pattern)
+		for _, path := range matches {
+			for _, v := range scanValues(path, "option domain-name-servers") {
+				for _, ns := range strings.Split(strings.TrimSuffix(v, ";"), ",") {
+					out = append(out, strings.TrimSpace(ns))
+				}
+			}
+		}
+	}
+
+	filtered := out[:0]
+	for _, ns := range out {
+		if ip := net.ParseIP(ns); ip != nil && !ip.IsLoopback() {
+			filtered = append(filtered, ns)
+		}
+	}
+	return filtered
+}
+
+// scanValues returns the remainder of every line in path that starts with
+// prefix, trimmed of whitespace.
+func scanValues(path, prefix string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var out []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if rest, ok := strings.CutPrefix(line, prefix+" "); ok {
+			out = append(out, strings.TrimSpace(rest))
+		}
+	}
+	return out
+}
+
+// Merge returns the static targets followed by any discovered targets
+// not already present.
+func Merge(static, discovered []string) []string {
+	out := append([]string(nil), static...)
+	seen := make(map[string]bool, len(static))
+	for _, t := range static {
+		seen[t] = true
+	}
+	for _, t := range discovered {
+		if !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	return out
+}
@@ -0,0 +1,165 @@
+// Package health serves liveness and readiness endpoints for probe loops.
+// A loop reports each completed cycle to its Tracker; /healthz fails when a
+// cycle is overdue, so Kubernetes can restart a wedged loop, and /readyz
+// succeeds once every loop has completed its first cycle. Trackers also
+// export each loop's cycle duration and scheduling drift, which show an
+// overloaded host stretching the probe interval.
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"edge-monitor-app/internal/buildinfo"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// minMaxAge keeps short intervals from failing liveness on one slow cycle
+// (a cycle probes its targets sequentially, each with its own timeout).
+const minMaxAge = time.Minute
+
+var (
+	cycleDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "probe_cycle_duration_seconds",
+			Help:    "Duration of each probe cycle",
+			Buckets: []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120},
+		},
+		[]string{"service"},
+	)
+
+	cycleDrift = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "probe_cycle_drift_seconds",
+			Help: "How much later than its interval the last probe cycle started",
+		},
+		[]string{"service"},
+	)
+
+	cycleOverruns = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "probe_cycle_overruns_total",
+			Help: "Probe cycles that took longer than their interval",
+		},
+		[]string{"service"},
+	)
+
+	registerOnce sync.Once
+)
+
+// Tracker records when a probe loop last completed a cycle.
+type Tracker struct {
+	name    string
+	maxAge  time.Duration
+	started time.Time
+	last    atomic.Int64 // unix nanoseconds, 0 before the first cycle
+
+	// prevStart is the start of the previous Cycle, for drift; only the
+	// loop goroutine calls Cycle.
+	prevStart time.Time
+}
+
+// NewTracker returns a tracker for a loop running every interval. The loop
+// counts as wedged after three missed intervals, but never sooner than a
+// minute.
+func NewTracker(name string, interval time.Duration) *Tracker {
+	maxAge := 3 * interval
+	if maxAge < minMaxAge {
+		maxAge = minMaxAge
+	}
+	registerOnce.Do(func() {
+		prometheus.MustRegister(cycleDuration, cycleDrift, cycleOverruns)
+	})
+	cycleDrift.WithLabelValues(name).Set(0)
+	cycleOverruns.WithLabelValues(name).Add(0)
+	return &Tracker{name: name, maxAge: maxAge, started: time.Now()}
+}
+
+// Beat marks the end of a probe cycle.
+func (t *Tracker) Beat() {
+	t.last.Store(time.Now().UnixNano())
+}
+
+// Cycle ends a cycle that began at start on a loop meant to start one every
+// interval: it observes the cycle's duration and drift, then beats.
+func (t *Tracker) Cycle(start time.Time, interval time.Duration) {
+	var drift time.Duration
+	if !t.prevStart.IsZero() {
+		drift = start.Sub(t.prevStart) - interval
+	}
+	t.prevStart = start
+	t.ObserveCycle(time.Since(start), drift, interval)
+	t.Beat()
+}
+
+// ObserveCycle records one cycle's duration and drift (how much later than
+// intended it started) for loops that track their own schedule. A negative
+// drift, a ticker catching up after an overrun, counts as none.
+func (t *Tracker) ObserveCycle(duration, drift, interval time.Duration) {
+	cycleDuration.WithLabelValues(t.name).Observe(duration.Seconds())
+	cycleDrift.WithLabelValues(t.name).Set(max(drift, 0).Seconds())
+	if duration > interval {
+		cycleOverruns.WithLabelValues(t.name).Inc()
+	}
+}
+
+// status is the JSON view of one tracker.
+type status struct {
+	Healthy    bool       `json:"healthy"`
+	Ready      bool       `json:"ready"`
+	LastCycle  *time.Time `json:"last_cycle,omitempty"`
+	AgeSeconds float64    `json:"age_seconds"`
+	MaxAge     string     `json:"max_age"`
+}
+
+// status reports the tracker's state. Before the first cycle the age is
+// measured from start, which gives the loop the same grace period.
+func (t *Tracker) status(now time.Time) status {
+	st := status{MaxAge: t.maxAge.String()}
+	ref := t.started
+	if ns := t.last.Load(); ns != 0 {
+		last := time.Unix(0, ns).UTC()
+		st.LastCycle = &last
+		st.Ready = true
+		ref = last
+	}
+	age := now.Sub(ref)
+	st.AgeSeconds = age.Seconds()
+	st.Healthy = age <= t.maxAge
+	return st
+}
+
+// Register adds /healthz and /readyz to mux, covering every tracker.
+func Register(mux *http.ServeMux, trackers ...*Tracker) {
+	mux.HandleFunc("/healthz", handler(trackers, func(s status) bool { return s.Healthy }))
+	mux.HandleFunc("/readyz", handler(trackers, func(s status) bool { return s.Ready }))
+}
+
+func handler(trackers []*Tracker, ok func(status) bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		now := time.Now()
+		code, state := http.StatusOK, "ok"
+		loops := make(map[string]status, len(trackers))
+		for _, t := range trackers {
+			st := t.status(now)
+			loops[t.name] = st
+			if !ok(st) {
+				code, state = http.StatusServiceUnavailable, "unavailable"
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		info := buildinfo.Get()
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"status":  state,
+			"version": info.Version,
+			"commit":  info.Commit,
+			"loops":   loops,
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"edge-monitor-app/internal/config"
+)
+
+var (
+	localMu sync.Mutex
+	local   *Store
+)
+
+// SetLocal has Lookup read s in process. edge-monitor calls it before
+// constructing its services, which then need no INVENTORY_URL.
+func SetLocal(s *Store) {
+	localMu.Lock()
+	defer localMu.Unlock()
+	local = s
+}
+
+// Lookup returns the inventory targets having any of roles, in inventory
+// order. ok is false when there is no inventory: neither a local one nor
+// INVENTORY_URL.
+func Lookup(roles ...string) (targets []Target, ok bool, err error) {
+	localMu.Lock()
+	s := local
+	localMu.Unlock()
+
+	var all []Target
+	switch base := config.String("INVENTORY_URL", ""); {
+	case s != nil:
+		all = s.List("")
+	case base != "":
+		if all, err = fetch(base, config.Seconds("INVENTORY_TIMEOUT_SECONDS", 5*time.Second)); err != nil {
+			return nil, true, err
+		}
+	default:
+		return nil, false, nil
+	}
+	for _, t := range all {
+		if slices.ContainsFunc(roles, func(role string) bool { return slices.Contains(t.Roles, role) }) {
+			targets = append(targets, t)
+		}
+	}
+	return targets, true, nil
+}
+
+// List returns the list setting key when it is set, and otherwise the
+// inventory targets having any of roles. Without an inventory it returns
+// the (empty) setting.
+func List(key string, roles ...string) ([]string, error) {
+	if config.IsSet(key) {
+		return config.List(key), nil
+	}
+	targets, ok, err := Lookup(roles...)
+	if !ok || err != nil {
+		return config.List(key), err
+	}
+	out := make([]string, 0, len(targets))
+	for _, t := range targets {
+		out = append(out, t.Target)
+	}
+	return out, nil
+}
+
+// First returns the string setting key when it is set, and otherwise the
+// first inventory target having role, or def.
+func First(key, role, def string) (string, error) {
+	if config.IsSet(key) {
+		return config.String(key, def), nil
+	}
+	targets, ok, err := Lookup(role)
+	if !ok || err != nil || len(targets) == 0 {
+		return config.String(key, def), err
+	}
+	return targets[0].Target, nil
+}
+
+func fetch(base string, timeout time.Duration) ([]Target, error) {
+	u, err := url.Parse(strings.TrimRight(base, "/") + "/inventory")
+	if err != nil {
+		return nil, fmt.Errorf("INVENTORY_URL: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch inventory: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch inventory: %s answered %s", u, resp.Status)
+	}
+	var body struct {
+		Targets []Target `json:"targets"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("fetch inventory: %w", err)
+	}
+	return body.Targets, nil
+}
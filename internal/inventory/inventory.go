@@ -0,0 +1,240 @@
+// Package inventory is the shared list of what the probes watch. Targets
+// are registered once with their roles (gateway, wan, dns, lan-device, or
+// any other name), and each probe service takes its target list from the
+// roles it probes instead of its own PING_TARGETS-style setting, so
+// deployments stop carrying copies of the same addresses that drift apart.
+//
+// edge-monitor serves the inventory from INVENTORY_FILE at /inventory:
+//
+//	GET    /inventory[?role=ROLE]   targets, optionally of one role
+//	POST   /inventory               register or replace a target (JSON Target)
+//	DELETE /inventory?target=ADDR   remove a target
+//
+// Changes are written back to the file; with INVENTORY_TOKEN set, they need
+// it as a bearer token. Probe services read it from INVENTORY_URL, or in
+// process when edge-monitor serves it (see Lookup).
+package inventory
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"edge-monitor-app/internal/config"
+)
+
+// Roles the probe services take their targets from.
+const (
+	RoleGateway   = "gateway"
+	RoleWAN       = "wan"
+	RoleDNS       = "dns"
+	RoleLANDevice = "lan-device"
+)
+
+// maxBodyBytes bounds a registered target.
+const maxBodyBytes = 64 << 10
+
+var roleRE = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Target is one inventory entry. Target is written as the probes' target
+// settings take it: an address, host:port, a resolver URL.
+type Target struct {
+	Target string   `json:"target"`
+	Roles  []string `json:"roles"`
+	// Name labels the target where a service names its targets, such as
+	// wifi-probe LAN_TARGETS.
+	Name string `json:"name,omitempty"`
+}
+
+// Validate normalizes t and reports what is wrong with it.
+func (t *Target) Validate() error {
+	t.Target = strings.TrimSpace(t.Target)
+	if t.Target == "" {
+		return errors.New("missing target")
+	}
+	if len(t.Roles) == 0 {
+		return fmt.Errorf("target %s: missing roles", t.Target)
+	}
+	for i, role := range t.Roles {
+		role = strings.ToLower(strings.TrimSpace(role))
+		if !roleRE.MatchString(role) {
+			return fmt.Errorf("target %s: invalid role %q", t.Target, role)
+		}
+		t.Roles[i] = role
+	}
+	return nil
+}
+
+// Store is an inventory backed by a JSON file.
+type Store struct {
+	path  string
+	token string
+
+	mu      sync.Mutex
+	targets []Target
+}
+
+// Open loads the inventory at path. A missing file is an empty inventory,
+// created on the first change.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, token: config.Secret("INVENTORY_TOKEN")}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.targets); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for i := range s.targets {
+		if err := s.targets[i].Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return s, nil
+}
+
+// List returns the targets having role, or every target when role is "".
+func (s *Store) List(role string) []Target {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Target, 0, len(s.targets))
+	for _, t := range s.targets {
+		if role == "" || slices.Contains(t.Roles, role) {
+			t.Roles = slices.Clone(t.Roles)
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// Put registers t, replacing an entry with the same target.
+func (s *Store) Put(t Target) error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	next := slices.Clone(s.targets)
+	if i := slices.IndexFunc(next, func(e Target) bool { return e.Target == t.Target }); i >= 0 {
+		next[i] = t
+	} else {
+		next = append(next, t)
+	}
+	return s.save(next)
+}
+
+// Delete removes target and reports whether it was registered.
+func (s *Store) Delete(target string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.targets, func(e Target) bool { return e.Target == target })
+	if i < 0 {
+		return false, nil
+	}
+	return true, s.save(slices.Delete(slices.Clone(s.targets), i, i+1))
+}
+
+// save writes targets to the file, through a temporary file so a crash
+// does not leave half an inventory, and makes them current. s.mu is held.
+func (s *Store) save(targets []Target) error {
+	data, err := json.MarshalIndent(targets, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".inventory-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	s.targets = targets
+	return nil
+}
+
+// Register adds /inventory to mux.
+func (s *Store) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/inventory", s.handle)
+}
+
+func (s *Store) handle(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"generated_at": time.Now().UTC(),
+			"targets":      s.List(r.URL.Query().Get("role")),
+		})
+		return
+	case http.MethodPost, http.MethodDelete:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.token != "" && !authorized(r, s.token) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="inventory"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		target := r.URL.Query().Get("target")
+		found, err := s.Delete(target)
+		switch {
+		case err != nil:
+			slog.Error("saving inventory failed", "path", s.path, "error", err)
+			http.Error(w, "save inventory: "+err.Error(), http.StatusInternalServerError)
+		case !found:
+			http.Error(w, "target not in inventory", http.StatusNotFound)
+		default:
+			slog.Info("inventory target removed", "target", target)
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+
+	var t Target
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxBodyBytes)).Decode(&t); err != nil {
+		http.Error(w, "invalid json body", http.StatusBadRequest)
+		return
+	}
+	if err := t.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.Put(t); err != nil {
+		slog.Error("saving inventory failed", "path", s.path, "error", err)
+		http.Error(w, "save inventory: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.Info("inventory target registered", "target", t.Target, "roles", t.Roles)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(t)
+}
+
+func authorized(r *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
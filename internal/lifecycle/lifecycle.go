@@ -0,0 +1,134 @@
+// Package lifecycle runs a binary's long-lived loops and HTTP server under
+// one context and stops them together. On SIGINT or SIGTERM, or when a loop
+// fails, the context is cancelled, the server stops accepting requests,
+// the loops get a grace period to return, and then the shutdown hooks run
+// (final metric pushes), so a pod restart does not drop in-flight work or
+// the last interval's counters.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// gracePeriod bounds the server drain and the wait for loops; hooks get
+	// their own hookTimeout. Together they stay within Kubernetes' default
+	// 30s termination grace period.
+	gracePeriod = 15 * time.Second
+	hookTimeout = 10 * time.Second
+)
+
+type loop struct {
+	name string
+	fn   func(context.Context) error
+}
+
+// App is a set of loops, an optional HTTP server and shutdown hooks.
+type App struct {
+	name   string
+	loops  []loop
+	hooks  []func(context.Context)
+	server *http.Server
+}
+
+// New returns an empty App; name identifies the binary in log lines.
+func New(name string) *App {
+	return &App{name: name}
+}
+
+// Go registers a loop that Run starts. A loop returns when its context is
+// cancelled; returning an error before that stops the whole App.
+func (a *App) Go(name string, fn func(context.Context) error) {
+	a.loops = append(a.loops, loop{name: name, fn: fn})
+}
+
+// OnShutdown registers fn to run, in registration order, after the loops
+// have stopped.
+func (a *App) OnShutdown(fn func(context.Context)) {
+	a.hooks = append(a.hooks, fn)
+}
+
+// Serve makes Run serve h on addr.
+func (a *App) Serve(addr string, h http.Handler) {
+	a.server = &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+}
+
+// Run starts the loops and the server and blocks until a signal arrives or
+// one of them fails, then shuts everything down. It returns the failure, or
+// nil after a signal.
+func (a *App) Run() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	failures := make(chan error, len(a.loops)+1)
+	var wg sync.WaitGroup
+	for _, l := range a.loops {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := l.fn(ctx); err != nil && ctx.Err() == nil {
+				failures <- fmt.Errorf("%s: %w", l.name, err)
+			}
+		}()
+	}
+	if a.server != nil {
+		go func() {
+			if err := a.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				failures <- fmt.Errorf("http server: %w", err)
+			}
+		}()
+	}
+
+	var failure error
+	select {
+	case sig := <-signals:
+		slog.Info("shutting down", "service", a.name, "signal", sig.String())
+	case failure = <-failures:
+		slog.Error("shutting down after failure", "service", a.name, "error", failure)
+	}
+	start := time.Now()
+	cancel()
+
+	graceCtx, graceCancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer graceCancel()
+	if a.server != nil {
+		if err := a.server.Shutdown(graceCtx); err != nil {
+			slog.Warn("http server did not drain in time", "service", a.name, "error", err)
+		}
+	}
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-graceCtx.Done():
+		slog.Warn("loops did not stop within the grace period", "service", a.name, "grace_period", gracePeriod.String())
+	}
+
+	for _, hook := range a.hooks {
+		hookCtx, hookCancel := context.WithTimeout(context.Background(), hookTimeout)
+		hook(hookCtx)
+		hookCancel()
+	}
+	slog.Info("shutdown complete", "service", a.name, "duration", time.Since(start).Round(time.Millisecond).String())
+	return failure
+}
@@ -0,0 +1,135 @@
+// Package logging sets up the process's slog logger from LOG_LEVEL and
+// LOG_FORMAT, and serves /loglevel so the level can be raised to debug on a
+// running pod and lowered again without a restart.
+package logging
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"edge-monitor-app/internal/config"
+)
+
+// maxBody bounds a /loglevel request body; it only carries a level name.
+const maxBody = 64
+
+var (
+	level = new(slog.LevelVar)
+
+	mu sync.Mutex
+	// configured is the level from LOG_LEVEL, restored when a temporary
+	// change expires.
+	configured slog.Level
+	revert     *time.Timer
+	// changes counts set calls, so a revert that fires while a newer change
+	// waits for mu does not undo it.
+	changes int
+)
+
+// Setup installs the default logger, writing to w in LOG_FORMAT (json or
+// text) at LOG_LEVEL (debug, info, warn or error). Invalid values are
+// reported through config.Err and fall back to JSON at info.
+func Setup(w io.Writer) {
+	lv, err := parseLevel(config.String("LOG_LEVEL", "info"))
+	if err != nil {
+		config.Invalid("LOG_LEVEL", "want debug, info, warn or error")
+	}
+	mu.Lock()
+	configured = lv
+	mu.Unlock()
+	level.Set(lv)
+
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch format := strings.ToLower(config.String("LOG_FORMAT", "json")); format {
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		config.Invalid("LOG_FORMAT", "want json or text")
+		h = slog.NewJSONHandler(w, opts)
+	}
+	slog.SetDefault(slog.New(h))
+}
+
+func parseLevel(s string) (slog.Level, error) {
+	if strings.EqualFold(s, "warning") {
+		s = "warn"
+	}
+	var lv slog.Level
+	err := lv.UnmarshalText([]byte(s))
+	return lv, err
+}
+
+// Register adds /loglevel to mux. GET returns the current level; PUT or
+// POST sets it from the level query parameter or the request body, for
+// the duration given by the optional for parameter (e.g. for=15m) or
+// until the next change.
+func Register(mux *http.ServeMux) {
+	mux.HandleFunc("/loglevel", handle)
+}
+
+func handle(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		name := r.URL.Query().Get("level")
+		if name == "" {
+			body, _ := io.ReadAll(io.LimitReader(r.Body, maxBody))
+			name = strings.TrimSpace(string(body))
+		}
+		lv, err := parseLevel(name)
+		if err != nil {
+			http.Error(w, "level must be debug, info, warn or error", http.StatusBadRequest)
+			return
+		}
+		var ttl time.Duration
+		if v := r.URL.Query().Get("for"); v != "" {
+			if ttl, err = time.ParseDuration(v); err != nil || ttl <= 0 {
+				http.Error(w, "for must be a positive duration such as 15m", http.StatusBadRequest)
+				return
+			}
+		}
+		set(lv, ttl)
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{"level": level.Level().String()})
+}
+
+// set changes the level, and with a ttl schedules the return to the
+// configured level. Changes are logged at warn so they show at any level.
+func set(lv slog.Level, ttl time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+	if revert != nil {
+		revert.Stop()
+		revert = nil
+	}
+	changes++
+	from := level.Level()
+	level.Set(lv)
+	if ttl > 0 {
+		change := changes
+		revert = time.AfterFunc(ttl, func() {
+			mu.Lock()
+			defer mu.Unlock()
+			if changes != change {
+				return
+			}
+			level.Set(configured)
+			slog.Warn("log level restored", "level", configured.String())
+		})
+	}
+	slog.Warn("log level changed", "from", from.String(), "to", lv.String(), "for", ttl.String())
+}
@@ -0,0 +1,244 @@
+// Package maintenance decides whether a probe target or an alert is in a
+// planned maintenance window. Failures during a window are still recorded,
+// but flagged: services export maintenance_active{service,target} and skip
+// failure-domain events and notifications for targets in maintenance.
+//
+// Windows come from MAINTENANCE_WINDOWS_JSON, a list of recurring windows
+// (a cron start schedule plus a duration) or one-off windows:
+//
+//	[
+//	  {"name":"isp-weekly","targets":["1.1.1.1","wan*"],"cron":"0 3 * * sun","duration":"2h","timezone":"Europe/London"},
+//	  {"name":"router-upgrade","start":"2026-11-02T22:00:00Z","end":"2026-11-02T23:30:00Z"}
+//	]
+//
+// Targets are path.Match globs on the target name; a window without
+// targets covers every target of the service.
+package maintenance
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"edge-monitor-app/internal/config"
+	"edge-monitor-app/internal/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxDuration bounds recurring windows, which are found by scanning back
+// minute by minute from now.
+const maxDuration = 7 * 24 * time.Hour
+
+var (
+	activeGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "maintenance_active",
+			Help: "Target inside a maintenance window (1) or not (0)",
+		},
+		[]string{"service", "target"},
+	)
+
+	failuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "maintenance_failures_total",
+			Help: "Probe failures that happened during a maintenance window",
+		},
+		[]string{"service", "target"},
+	)
+
+	registerOnce sync.Once
+)
+
+// WindowConfig is one maintenance window as configured.
+type WindowConfig struct {
+	Name     string   `json:"name,omitempty"`
+	Targets  []string `json:"targets,omitempty"`
+	Cron     string   `json:"cron,omitempty"`
+	Duration string   `json:"duration,omitempty"`
+	Timezone string   `json:"timezone,omitempty"`
+	Start    string   `json:"start,omitempty"`
+	End      string   `json:"end,omitempty"`
+}
+
+// Window is a parsed maintenance window.
+type Window struct {
+	name     string
+	targets  []string
+	cron     *cronSpec
+	duration time.Duration
+	loc      *time.Location
+	start    time.Time
+	end      time.Time
+
+	// The latest cron start before now only changes once a minute.
+	mu          sync.Mutex
+	cachedAt    time.Time
+	cachedStart time.Time
+}
+
+// ParseWindows parses maintenance windows from their JSON form.
+func ParseWindows(configs []WindowConfig) ([]*Window, error) {
+	windows := make([]*Window, 0, len(configs))
+	for i, c := range configs {
+		w, err := parseWindow(c)
+		if err != nil {
+			name := c.Name
+			if name == "" {
+				name = fmt.Sprintf("#%d", i)
+			}
+			return nil, fmt.Errorf("maintenance window %s: %w", name, err)
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+func parseWindow(c WindowConfig) (*Window, error) {
+	w := &Window{name: c.Name, targets: c.Targets, loc: time.UTC}
+	for _, t := range c.Targets {
+		if _, err := path.Match(t, ""); err != nil {
+			return nil, fmt.Errorf("target pattern %q: %w", t, err)
+		}
+	}
+	if c.Timezone != "" {
+		loc, err := time.LoadLocation(c.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("timezone: %w", err)
+		}
+		w.loc = loc
+	}
+
+	switch {
+	case c.Cron != "" && (c.Start != "" || c.End != ""):
+		return nil, fmt.Errorf("cron and start/end are exclusive")
+	case c.Cron != "":
+		spec, err := parseCron(c.Cron)
+		if err != nil {
+			return nil, err
+		}
+		d, err := time.ParseDuration(c.Duration)
+		if err != nil || d <= 0 || d > maxDuration {
+			return nil, fmt.Errorf("duration %q: want a positive Go duration up to %s", c.Duration, maxDuration)
+		}
+		w.cron, w.duration = spec, d
+	case c.Start != "" && c.End != "":
+		var err error
+		if w.start, err = time.Parse(time.RFC3339, c.Start); err != nil {
+			return nil, fmt.Errorf("start: %w", err)
+		}
+		if w.end, err = time.Parse(time.RFC3339, c.End); err != nil {
+			return nil, fmt.Errorf("end: %w", err)
+		}
+		if !w.end.After(w.start) {
+			return nil, fmt.Errorf("end must be after start")
+		}
+	default:
+		return nil, fmt.Errorf("needs cron and duration, or start and end")
+	}
+	return w, nil
+}
+
+// Name is the window's configured name.
+func (w *Window) Name() string { return w.name }
+
+// Covers reports whether the window applies to target.
+func (w *Window) Covers(target string) bool {
+	if len(w.targets) == 0 {
+		return true
+	}
+	for _, pattern := range w.targets {
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Active reports whether now falls inside the window.
+func (w *Window) Active(now time.Time) bool {
+	if w.cron == nil {
+		return !now.Before(w.start) && now.Before(w.end)
+	}
+	start := w.lastStart(now)
+	return !start.IsZero() && now.Before(start.Add(w.duration))
+}
+
+// lastStart returns the latest cron start within duration before now, or
+// the zero time.
+func (w *Window) lastStart(now time.Time) time.Time {
+	minute := now.In(w.loc).Truncate(time.Minute)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if minute.Equal(w.cachedAt) {
+		return w.cachedStart
+	}
+	w.cachedAt, w.cachedStart = minute, time.Time{}
+	for t := minute; now.Sub(t) < w.duration; t = t.Add(-time.Minute) {
+		if w.cron.matches(t) {
+			w.cachedStart = t
+			break
+		}
+	}
+	return w.cachedStart
+}
+
+// Schedule is the set of maintenance windows of one service.
+type Schedule struct {
+	service string
+	windows []*Window
+}
+
+// Load reads MAINTENANCE_WINDOWS_JSON for service and registers the
+// maintenance metrics. An unset setting yields an empty schedule.
+func Load(service string) (*Schedule, error) {
+	var configs []WindowConfig
+	if err := json.Unmarshal([]byte(config.String("MAINTENANCE_WINDOWS_JSON", "[]")), &configs); err != nil {
+		return nil, fmt.Errorf("parse MAINTENANCE_WINDOWS_JSON: %w", err)
+	}
+	windows, err := ParseWindows(configs)
+	if err != nil {
+		return nil, err
+	}
+	registerOnce.Do(func() {
+		prometheus.MustRegister(activeGauge, failuresTotal)
+	})
+	return &Schedule{service: service, windows: windows}, nil
+}
+
+// Active reports whether target is in a maintenance window at now and
+// exports the result as maintenance_active. A nil or empty schedule is
+// never active and exports nothing.
+func (s *Schedule) Active(target string, now time.Time) bool {
+	if s == nil || len(s.windows) == 0 {
+		return false
+	}
+	active := false
+	for _, w := range s.windows {
+		if w.Covers(target) && w.Active(now) {
+			active = true
+			break
+		}
+	}
+	activeGauge.WithLabelValues(s.service, target).Set(metrics.BoolToFloat(active))
+	return active
+}
+
+// Failure counts a probe failure of target that happened in maintenance.
+func (s *Schedule) Failure(target string) {
+	if s == nil {
+		return
+	}
+	failuresTotal.WithLabelValues(s.service, target).Inc()
+}
+
+// Forget drops the series of a target that is no longer probed.
+func (s *Schedule) Forget(target string) {
+	if s == nil || len(s.windows) == 0 {
+		return
+	}
+	activeGauge.DeleteLabelValues(s.service, target)
+	failuresTotal.DeleteLabelValues(s.service, target)
+}
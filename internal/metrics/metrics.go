@@ -0,0 +1,11 @@
+// Package metrics holds small helpers shared by the services' Prometheus
+// instrumentation.
+package metrics
+
+// BoolToFloat is the value of a 0/1 gauge such as up or reachable.
+func BoolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,224 @@
+package otlp
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+type attribute struct {
+	key, value string
+}
+
+func sortedAttributes(m map[string]string) []attribute {
+	out := make([]attribute, 0, len(m))
+	for k, v := range m {
+		out = append(out, attribute{k, v})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].key < out[j].key })
+	return out
+}
+
+// Protobuf wire types and the field numbers of the OTLP metrics messages
+// (opentelemetry/proto/metrics/v1/metrics.proto and the collector's
+// metrics_service.proto).
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+
+	fieldRequestResourceMetrics = 1
+	fieldResourceMetricsRes     = 1
+	fieldResourceMetricsScope   = 2
+	fieldResourceAttributes     = 1
+	fieldScopeMetricsScope      = 1
+	fieldScopeMetricsMetrics    = 2
+	fieldScopeName              = 1
+	fieldKeyValueKey            = 1
+	fieldKeyValueValue          = 2
+	fieldAnyValueString         = 1
+
+	fieldMetricName        = 1
+	fieldMetricDescription = 2
+	fieldMetricGauge       = 5
+	fieldMetricSum         = 7
+	fieldMetricHistogram   = 9
+	fieldMetricSummary     = 11
+
+	fieldDataPoints       = 1 // in Gauge, Sum, Histogram and Summary
+	fieldTemporality      = 2 // in Sum and Histogram
+	fieldSumMonotonic     = 3
+	temporalityCumulative = 2
+
+	fieldPointStart = 2 // start_time_unix_nano in every data point
+	fieldPointTime  = 3 // time_unix_nano in every data point
+
+	fieldNumberDouble     = 4
+	fieldNumberAttributes = 7
+
+	fieldHistogramCount      = 4
+	fieldHistogramSum        = 5
+	fieldHistogramBuckets    = 6
+	fieldHistogramBounds     = 7
+	fieldHistogramAttributes = 9
+
+	fieldSummaryCount      = 4
+	fieldSummarySum        = 5
+	fieldSummaryQuantiles  = 6
+	fieldSummaryAttributes = 7
+	fieldQuantileQuantile  = 1
+	fieldQuantileValue     = 2
+)
+
+// encodeRequest converts metric families into an ExportMetricsServiceRequest
+// with cumulative points: counters become monotonic sums, gauges and
+// untyped metrics gauges, histograms and summaries keep their type. Metric
+// names stay in their Prometheus form.
+func encodeRequest(families []*dto.MetricFamily, resource []attribute, start, now time.Time) []byte {
+	startNano, nowNano := uint64(start.UnixNano()), uint64(now.UnixNano())
+
+	var metrics []byte
+	for _, mf := range families {
+		var data []byte
+		var field int
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			field = fieldMetricSum
+			for _, m := range mf.GetMetric() {
+				data = appendBytes(data, fieldDataPoints, numberPoint(m, m.GetCounter().GetValue(), startNano, nowNano))
+			}
+			data = appendVarint(data, fieldTemporality, temporalityCumulative)
+			data = appendVarint(data, fieldSumMonotonic, 1)
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+			field = fieldMetricGauge
+			for _, m := range mf.GetMetric() {
+				v := m.GetGauge().GetValue()
+				if mf.GetType() == dto.MetricType_UNTYPED {
+					v = m.GetUntyped().GetValue()
+				}
+				data = appendBytes(data, fieldDataPoints, numberPoint(m, v, 0, nowNano))
+			}
+		case dto.MetricType_HISTOGRAM:
+			field = fieldMetricHistogram
+			for _, m := range mf.GetMetric() {
+				data = appendBytes(data, fieldDataPoints, histogramPoint(m, startNano, nowNano))
+			}
+			data = appendVarint(data, fieldTemporality, temporalityCumulative)
+		case dto.MetricType_SUMMARY:
+			field = fieldMetricSummary
+			for _, m := range mf.GetMetric() {
+				data = appendBytes(data, fieldDataPoints, summaryPoint(m, startNano, nowNano))
+			}
+		default:
+			continue
+		}
+		var metric []byte
+		metric = appendString(metric, fieldMetricName, mf.GetName())
+		metric = appendString(metric, fieldMetricDescription, mf.GetHelp())
+		metric = appendBytes(metric, field, data)
+		metrics = appendBytes(metrics, fieldScopeMetricsMetrics, metric)
+	}
+
+	var res []byte
+	for _, a := range resource {
+		res = appendBytes(res, fieldResourceAttributes, keyValue(a.key, a.value))
+	}
+	scope := appendBytes(nil, fieldScopeMetricsScope, appendString(nil, fieldScopeName, "edge-monitor-app"))
+	scope = append(scope, metrics...)
+
+	var rm []byte
+	rm = appendBytes(rm, fieldResourceMetricsRes, res)
+	rm = appendBytes(rm, fieldResourceMetricsScope, scope)
+	return appendBytes(nil, fieldRequestResourceMetrics, rm)
+}
+
+func numberPoint(m *dto.Metric, v float64, start, now uint64) []byte {
+	var b []byte
+	if start != 0 {
+		b = appendFixed64(b, fieldPointStart, start)
+	}
+	b = appendFixed64(b, fieldPointTime, now)
+	b = appendFixed64(b, fieldNumberDouble, math.Float64bits(v))
+	return appendAttributes(b, fieldNumberAttributes, m)
+}
+
+// histogramPoint converts Prometheus' cumulative buckets into OTLP's
+// per-bucket counts, with the overflow bucket last.
+func histogramPoint(m *dto.Metric, start, now uint64) []byte {
+	h := m.GetHistogram()
+	var bounds, counts []byte
+	var prev uint64
+	for _, bk := range h.GetBucket() {
+		if math.IsInf(bk.GetUpperBound(), 1) {
+			continue
+		}
+		bounds = binary.LittleEndian.AppendUint64(bounds, math.Float64bits(bk.GetUpperBound()))
+		counts = binary.LittleEndian.AppendUint64(counts, bk.GetCumulativeCount()-prev)
+		prev = bk.GetCumulativeCount()
+	}
+	counts = binary.LittleEndian.AppendUint64(counts, h.GetSampleCount()-prev)
+
+	var b []byte
+	b = appendFixed64(b, fieldPointStart, start)
+	b = appendFixed64(b, fieldPointTime, now)
+	b = appendFixed64(b, fieldHistogramCount, h.GetSampleCount())
+	b = appendFixed64(b, fieldHistogramSum, math.Float64bits(h.GetSampleSum()))
+	b = appendBytes(b, fieldHistogramBuckets, counts)
+	b = appendBytes(b, fieldHistogramBounds, bounds)
+	return appendAttributes(b, fieldHistogramAttributes, m)
+}
+
+func summaryPoint(m *dto.Metric, start, now uint64) []byte {
+	s := m.GetSummary()
+	var b []byte
+	b = appendFixed64(b, fieldPointStart, start)
+	b = appendFixed64(b, fieldPointTime, now)
+	b = appendFixed64(b, fieldSummaryCount, s.GetSampleCount())
+	b = appendFixed64(b, fieldSummarySum, math.Float64bits(s.GetSampleSum()))
+	for _, q := range s.GetQuantile() {
+		var qv []byte
+		qv = appendFixed64(qv, fieldQuantileQuantile, math.Float64bits(q.GetQuantile()))
+		qv = appendFixed64(qv, fieldQuantileValue, math.Float64bits(q.GetValue()))
+		b = appendBytes(b, fieldSummaryQuantiles, qv)
+	}
+	return appendAttributes(b, fieldSummaryAttributes, m)
+}
+
+func appendAttributes(b []byte, field int, m *dto.Metric) []byte {
+	for _, lp := range m.GetLabel() {
+		b = appendBytes(b, field, keyValue(lp.GetName(), lp.GetValue()))
+	}
+	return b
+}
+
+func keyValue(k, v string) []byte {
+	b := appendString(nil, fieldKeyValueKey, k)
+	return appendBytes(b, fieldKeyValueValue, appendString(nil, fieldAnyValueString, v))
+}
+
+func appendTag(b []byte, field, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wire))
+}
+
+func appendVarint(b []byte, field int, v uint64) []byte {
+	return binary.AppendUvarint(appendTag(b, field, wireVarint), v)
+}
+
+func appendFixed64(b []byte, field int, v uint64) []byte {
+	return binary.LittleEndian.AppendUint64(appendTag(b, field, wireFixed64), v)
+}
+
+func appendBytes(b []byte, field int, v []byte) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendString(b []byte, field int, s string) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
@@ -0,0 +1,298 @@
+// Package otlp exports a process's metrics to an OpenTelemetry collector
+// over OTLP, alongside the Prometheus /metrics endpoint. It is configured
+// with the standard OTEL_* environment variables and speaks OTLP/HTTP
+// (protobuf) or, toward https endpoints, OTLP/gRPC, with the standard
+// library alone.
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"edge-monitor-app/internal/config"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	protocolHTTP = "http/protobuf"
+	protocolGRPC = "grpc"
+
+	defaultHTTPEndpoint = "http://localhost:4318"
+	defaultInterval     = 60 * time.Second
+	defaultTimeout      = 10 * time.Second
+	grpcExportPath      = "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export"
+	// maxErrorBody bounds how much of a rejected export's response is
+	// logged.
+	maxErrorBody = 512
+)
+
+var (
+	exportFailures = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "otlp_export_failures_total",
+			Help: "OTLP metric exports that failed or were rejected",
+		},
+	)
+
+	lastSuccess = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "otlp_export_last_success_timestamp_seconds",
+			Help: "Unix time of the last accepted OTLP metric export",
+		},
+	)
+)
+
+// Exporter periodically exports everything in the default Prometheus
+// registry as cumulative OTLP metrics.
+type Exporter struct {
+	endpoint string
+	protocol string
+	headers  http.Header
+	interval time.Duration
+	resource []attribute
+	// start is the start time of every cumulative point: the process's
+	// counters begin at zero when it starts.
+	start    time.Time
+	gatherer prometheus.Gatherer
+	client   *http.Client
+}
+
+// New reads the OTEL_* environment. It returns nil unless OTEL_METRICS_EXPORTER
+// lists otlp. service is the default service.name resource attribute.
+func New(service string) (*Exporter, error) {
+	if !listed(config.String("OTEL_METRICS_EXPORTER", ""), "otlp") {
+		return nil, nil
+	}
+
+	e := &Exporter{
+		protocol: firstEnv("OTEL_EXPORTER_OTLP_METRICS_PROTOCOL", "OTEL_EXPORTER_OTLP_PROTOCOL"),
+		headers:  make(http.Header),
+		interval: defaultInterval,
+		start:    time.Now(),
+		gatherer: prometheus.DefaultGatherer,
+	}
+	if e.protocol == "" {
+		e.protocol = protocolHTTP
+	}
+
+	// A signal-specific endpoint is used as is; the generic one is a base
+	// URL that OTLP/HTTP appends the metrics path to.
+	endpoint := config.String("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT", "")
+	if endpoint == "" {
+		base := config.String("OTEL_EXPORTER_OTLP_ENDPOINT", defaultHTTPEndpoint)
+		endpoint = strings.TrimSuffix(base, "/")
+		if e.protocol == protocolHTTP {
+			endpoint += "/v1/metrics"
+		}
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("OTLP endpoint %q is not an http(s) URL", endpoint)
+	}
+
+	switch e.protocol {
+	case protocolHTTP:
+		e.endpoint = endpoint
+	case protocolGRPC:
+		// The standard library speaks HTTP/2 only over TLS; plaintext gRPC
+		// (h2c) would need the grpc-go dependency this repo avoids.
+		if u.Scheme != "https" {
+			return nil, fmt.Errorf("OTLP/gRPC needs an https endpoint, not %q; use OTEL_EXPORTER_OTLP_PROTOCOL=http/protobuf with the collector's OTLP/HTTP receiver (port 4318) for plaintext", endpoint)
+		}
+		u.Path = grpcExportPath
+		e.endpoint = u.String()
+	default:
+		return nil, fmt.Errorf("unsupported OTLP protocol %q (want http/protobuf or grpc)", e.protocol)
+	}
+
+	if ms := config.Int("OTEL_METRIC_EXPORT_INTERVAL", int(defaultInterval/time.Millisecond)); ms > 0 {
+		e.interval = time.Duration(ms) * time.Millisecond
+	} else {
+		config.Invalid("OTEL_METRIC_EXPORT_INTERVAL", "want a positive number of milliseconds")
+	}
+	timeout := defaultTimeout
+	if v := firstEnv("OTEL_EXPORTER_OTLP_METRICS_TIMEOUT", "OTEL_EXPORTER_OTLP_TIMEOUT"); v != "" {
+		if ms, err := strconv.Atoi(v); err == nil && ms > 0 {
+			timeout = time.Duration(ms) * time.Millisecond
+		} else {
+			return nil, fmt.Errorf("OTLP timeout %q is not a positive number of milliseconds", v)
+		}
+	}
+	e.client = &http.Client{Timeout: timeout}
+
+	for _, env := range []string{"OTEL_EXPORTER_OTLP_HEADERS", "OTEL_EXPORTER_OTLP_METRICS_HEADERS"} {
+		pairs, err := keyValues(config.Secret(env))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", env, err)
+		}
+		for _, kv := range pairs {
+			e.headers.Set(kv[0], kv[1])
+		}
+	}
+
+	attrs, err := keyValues(config.String("OTEL_RESOURCE_ATTRIBUTES", ""))
+	if err != nil {
+		return nil, fmt.Errorf("OTEL_RESOURCE_ATTRIBUTES: %w", err)
+	}
+	resource := map[string]string{"service.name": service}
+	if host, err := os.Hostname(); err == nil {
+		resource["host.name"] = host
+	}
+	for _, kv := range attrs {
+		resource[kv[0]] = kv[1]
+	}
+	if name := config.String("OTEL_SERVICE_NAME", ""); name != "" {
+		resource["service.name"] = name
+	}
+	e.resource = sortedAttributes(resource)
+
+	prometheus.MustRegister(exportFailures, lastSuccess)
+	return e, nil
+}
+
+// Run exports every interval until ctx is cancelled. A failed export is not
+// retried: points are cumulative, so the next export carries the current
+// values.
+func (e *Exporter) Run(ctx context.Context) error {
+	slog.Info("otlp metric export enabled",
+		"endpoint", e.endpoint,
+		"protocol", e.protocol,
+		"interval", e.interval.String(),
+	)
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		e.export(ctx)
+	}
+}
+
+// Flush exports the current values once, for a final export at shutdown.
+func (e *Exporter) Flush(ctx context.Context) {
+	e.export(ctx)
+}
+
+func (e *Exporter) export(ctx context.Context) {
+	families, err := e.gatherer.Gather()
+	if err != nil {
+		// Gather returns what it could collect along with the error.
+		slog.Warn("otlp export: gathering metrics failed", "error", err)
+	}
+	if len(families) == 0 {
+		return
+	}
+	msg := encodeRequest(families, e.resource, e.start, time.Now())
+	if err := e.send(ctx, msg); err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		exportFailures.Inc()
+		slog.Warn("otlp export failed", "endpoint", e.endpoint, "protocol", e.protocol, "error", err)
+		return
+	}
+	lastSuccess.SetToCurrentTime()
+}
+
+func (e *Exporter) send(ctx context.Context, msg []byte) error {
+	body, contentType := msg, "application/x-protobuf"
+	if e.protocol == protocolGRPC {
+		// Length-prefixed message: compressed flag, then big-endian length.
+		framed := make([]byte, 5, 5+len(msg))
+		binary.BigEndian.PutUint32(framed[1:], uint32(len(msg)))
+		body, contentType = append(framed, msg...), "application/grpc"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range e.headers {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "edge-monitor-app")
+	if e.protocol == protocolGRPC {
+		req.Header.Set("TE", "trailers")
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	text, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	_, _ = io.Copy(io.Discard, resp.Body) // trailers arrive after the body
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector answered %s: %s", resp.Status, strings.TrimSpace(string(text)))
+	}
+	if e.protocol == protocolGRPC {
+		// Errors come as grpc-status in the trailers, or in the headers of
+		// a trailers-only response.
+		status, message := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+		if status == "" {
+			status, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+		}
+		if status != "" && status != "0" {
+			return fmt.Errorf("collector answered gRPC status %s: %s", status, message)
+		}
+	}
+	return nil
+}
+
+// listed reports whether the comma-separated list contains name.
+func listed(list, name string) bool {
+	for _, v := range strings.Split(list, ",") {
+		if strings.EqualFold(strings.TrimSpace(v), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// firstEnv returns the first of keys that is set: the signal-specific
+// variable before the generic one.
+func firstEnv(keys ...string) string {
+	for _, k := range keys {
+		if v := config.String(k, ""); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// keyValues parses the OTEL list format: comma-separated key=value pairs
+// with URL-encoded values.
+func keyValues(s string) ([][2]string, error) {
+	var out [][2]string
+	for _, kv := range strings.Split(s, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(kv, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid entry %q (want key=value)", kv)
+		}
+		if dec, err := url.QueryUnescape(strings.TrimSpace(v)); err == nil {
+			v = dec
+		}
+		out = append(out, [2]string{k, v})
+	}
+	return out, nil
+}
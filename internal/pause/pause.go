@@ -0,0 +1,345 @@
+// Package pause stops a binary's probes for a while on request, so planned
+// work on the router (a firmware upgrade, a reboot, recabling) does not
+// turn into a wall of loss metrics, failure events and the alert analyses
+// they set off. A maintenance window keeps probing and flags the failures;
+// a paused service does not probe at all, its loops keep cycling so
+// liveness holds, and its metrics keep their last values.
+//
+//	POST /pause?duration=30m[&start=RFC3339][&service=NAME][&reason=TEXT]
+//	POST /resume[?service=NAME][&reason=TEXT]
+//	GET  /pause
+//
+// Without service, the pause covers every service of the process (all the
+// probes edge-monitor runs). With start, the pause is scheduled for later,
+// such as the night of a planned upgrade. A pause always ends: duration
+// defaults to an hour and a longer one than a day is rejected. /resume
+// ends or cancels pauses; without service, all of them.
+//
+// The endpoints are served only with PAUSE_ENABLED or PAUSE_TOKEN set;
+// with PAUSE_TOKEN, POSTs need it as a bearer token. Every request that
+// pauses or resumes is logged with its remote address and reason, and
+// rejected ones are logged and counted in probe_pause_requests_total.
+// probe_paused{service} is 1 while a service is paused, for alert rules to
+// stay quiet on.
+package pause
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"edge-monitor-app/internal/config"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// DefaultDuration is how long a pause without duration lasts.
+	DefaultDuration = time.Hour
+	// MaxDuration caps a pause, so a forgotten one does not blind the
+	// monitoring for good.
+	MaxDuration = 24 * time.Hour
+	// maxAhead bounds how far ahead a pause can be scheduled.
+	maxAhead = 7 * 24 * time.Hour
+	// maxReason bounds the characters of a pause reason.
+	maxReason = 200
+
+	// all is the key of a pause covering every service.
+	all = ""
+)
+
+var paused = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "probe_paused",
+		Help: "Whether the service's probes are paused through /pause (1) or running (0)",
+	},
+	[]string{"service"},
+)
+
+var requestsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "probe_pause_requests_total",
+		Help: "POST /pause and /resume requests by path and result (ok, unauthorized, invalid)",
+	},
+	[]string{"path", "result"},
+)
+
+// state is one pause, in effect or scheduled.
+type state struct {
+	Service string    `json:"service"`
+	Start   time.Time `json:"start"`
+	Until   time.Time `json:"until"`
+	Reason  string    `json:"reason,omitempty"`
+	// timer starts a scheduled pause, then ends it.
+	timer *time.Timer
+}
+
+// activeAt reports whether the pause is in effect at t.
+func (p *state) activeAt(t time.Time) bool {
+	return !t.Before(p.Start) && t.Before(p.Until)
+}
+
+var (
+	mu       sync.Mutex
+	services []string
+	pauses   = make(map[string]*state)
+
+	registerOnce sync.Once
+)
+
+// Track declares a service of the process that can be paused and exports
+// its probe_paused series. Services call it from their constructor.
+func Track(service string) {
+	register()
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Contains(services, service) {
+		services = append(services, service)
+	}
+	updateLocked()
+}
+
+// Active reports whether service is paused now. Probe loops check it every
+// cycle and skip their probes while it holds.
+func Active(service string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	now := time.Now()
+	for _, key := range []string{all, service} {
+		if p, ok := pauses[key]; ok && p.activeAt(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// Pause pauses service, or every service when it is "", for d (capped at
+// MaxDuration) from start, or from now when start has passed. It replaces
+// an earlier pause of the same service.
+func Pause(service string, start time.Time, d time.Duration, reason string) {
+	d = min(d, MaxDuration)
+	mu.Lock()
+	defer mu.Unlock()
+	if p, ok := pauses[service]; ok {
+		p.timer.Stop()
+	}
+	now := time.Now()
+	if start.Before(now) {
+		start = now
+	}
+	p := &state{Service: service, Start: start, Until: start.Add(d), Reason: reason}
+	pauses[service] = p
+	if start.After(now) {
+		p.timer = time.AfterFunc(start.Sub(now), func() { begin(p) })
+		slog.Info("probe pause scheduled", "service", label(service), "start", p.Start, "until", p.Until, "reason", reason)
+		return
+	}
+	p.timer = time.AfterFunc(d, func() { expire(p) })
+	updateLocked()
+	slog.Warn("probes paused", "service", label(service), "duration", d.String(), "until", p.Until, "reason", reason)
+}
+
+// begin starts the scheduled pause p, unless it was replaced or cancelled.
+func begin(p *state) {
+	mu.Lock()
+	defer mu.Unlock()
+	if pauses[p.Service] != p {
+		return
+	}
+	p.timer = time.AfterFunc(time.Until(p.Until), func() { expire(p) })
+	updateLocked()
+	slog.Warn("probes paused", "service", label(p.Service), "until", p.Until, "reason", p.Reason, "scheduled", true)
+}
+
+// Resume ends or cancels the pause of service, or every pause when it is
+// "". It reports whether there was one.
+func Resume(service string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	var ended bool
+	for key, p := range pauses {
+		if service == all || key == service {
+			p.timer.Stop()
+			delete(pauses, key)
+			ended = true
+			if p.activeAt(time.Now()) {
+				slog.Warn("probes resumed", "service", label(key), "paused_for", time.Since(p.Start).Round(time.Second).String())
+			} else {
+				slog.Info("scheduled probe pause cancelled", "service", label(key), "start", p.Start)
+			}
+		}
+	}
+	updateLocked()
+	return ended
+}
+
+// expire ends p once its time is up, unless a later pause replaced it.
+func expire(p *state) {
+	mu.Lock()
+	defer mu.Unlock()
+	if pauses[p.Service] != p {
+		return
+	}
+	delete(pauses, p.Service)
+	updateLocked()
+	slog.Warn("pause expired; probes resumed", "service", label(p.Service), "reason", p.Reason)
+}
+
+// updateLocked sets probe_paused of every tracked service. mu is held.
+func updateLocked() {
+	now := time.Now()
+	for _, svc := range services {
+		v := 0.0
+		for _, key := range []string{all, svc} {
+			if p, ok := pauses[key]; ok && p.activeAt(now) {
+				v = 1
+			}
+		}
+		paused.WithLabelValues(svc).Set(v)
+	}
+}
+
+func label(service string) string {
+	if service == all {
+		return "all"
+	}
+	return service
+}
+
+func register() {
+	registerOnce.Do(func() { prometheus.MustRegister(paused, requestsTotal) })
+}
+
+// Register adds /pause and /resume to mux when PAUSE_ENABLED is true or
+// PAUSE_TOKEN is set. It adds nothing otherwise.
+func Register(mux *http.ServeMux) {
+	token := config.Secret("PAUSE_TOKEN")
+	if !config.Bool("PAUSE_ENABLED", false) && token == "" {
+		return
+	}
+	register()
+	mux.HandleFunc("/pause", func(w http.ResponseWriter, r *http.Request) { handle(w, r, token, true) })
+	mux.HandleFunc("/resume", func(w http.ResponseWriter, r *http.Request) { handle(w, r, token, false) })
+
+	if token == "" {
+		slog.Warn("pause endpoints enabled without PAUSE_TOKEN", "path", "/pause")
+	} else {
+		slog.Info("pause endpoints enabled", "path", "/pause")
+	}
+}
+
+func handle(w http.ResponseWriter, r *http.Request, token string, pause bool) {
+	switch {
+	case r.Method == http.MethodGet && pause:
+		writeStatus(w)
+		return
+	case r.Method != http.MethodPost:
+		if pause {
+			w.Header().Set("Allow", "GET, POST")
+		} else {
+			w.Header().Set("Allow", "POST")
+		}
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if token != "" && !authorized(r, token) {
+		requestsTotal.WithLabelValues(r.URL.Path, "unauthorized").Inc()
+		slog.Warn("unauthorized pause request", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="pause"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	q := r.URL.Query()
+	service := q.Get("service")
+	if service != all && !tracked(service) {
+		reject(w, r, http.StatusNotFound, "unknown service "+service)
+		return
+	}
+	reason := strings.ToValidUTF8(q.Get("reason"), "")
+	if runes := []rune(reason); len(runes) > maxReason {
+		reason = string(runes[:maxReason])
+	}
+	reason = strings.TrimSpace(reason)
+	if !pause {
+		requestsTotal.WithLabelValues(r.URL.Path, "ok").Inc()
+		slog.Warn("probe resume requested", "service", label(service), "reason", reason, "remote_addr", r.RemoteAddr)
+		Resume(service)
+		writeStatus(w)
+		return
+	}
+	d := DefaultDuration
+	if v := q.Get("duration"); v != "" {
+		var err error
+		if d, err = time.ParseDuration(v); err != nil || d <= 0 {
+			reject(w, r, http.StatusBadRequest, "duration must be a positive duration such as 30m")
+			return
+		}
+		if d > MaxDuration {
+			reject(w, r, http.StatusBadRequest, "duration must be at most 24h")
+			return
+		}
+	}
+	var start time.Time
+	if v := q.Get("start"); v != "" {
+		var err error
+		if start, err = time.Parse(time.RFC3339, v); err != nil {
+			reject(w, r, http.StatusBadRequest, "start must be an RFC 3339 time such as 2024-05-01T02:00:00Z")
+			return
+		}
+		if time.Until(start) > maxAhead {
+			reject(w, r, http.StatusBadRequest, "start must be within 7 days")
+			return
+		}
+	}
+	requestsTotal.WithLabelValues(r.URL.Path, "ok").Inc()
+	attrs := []any{"service", label(service), "duration", d.String(), "reason", reason, "remote_addr", r.RemoteAddr}
+	if !start.IsZero() {
+		attrs = append(attrs, "start", start)
+	}
+	slog.Warn("probe pause requested", attrs...)
+	Pause(service, start, d, reason)
+	writeStatus(w)
+}
+
+// reject answers an invalid POST with status and msg, and logs and counts
+// it.
+func reject(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	requestsTotal.WithLabelValues(r.URL.Path, "invalid").Inc()
+	slog.Warn("invalid pause request", "path", r.URL.Path, "error", msg, "remote_addr", r.RemoteAddr)
+	http.Error(w, msg, status)
+}
+
+func tracked(service string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return slices.Contains(services, service)
+}
+
+// writeStatus answers with the pauses in effect and scheduled.
+func writeStatus(w http.ResponseWriter) {
+	mu.Lock()
+	list := make([]state, 0, len(pauses))
+	for _, p := range pauses {
+		s := *p
+		s.Service = label(s.Service)
+		s.timer = nil
+		list = append(list, s)
+	}
+	mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Start.Before(list[j].Start) })
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"paused": list})
+}
+
+func authorized(r *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
@@ -0,0 +1,93 @@
+package probe
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// Binding names the uplink a probe should leave through on a multi-homed
+// host. The zero value lets the kernel pick the route.
+type Binding struct {
+	Interface string // network interface name, e.g. "wlan0"
+	Source    string // local source IP
+	// Mark is a firewall mark (SO_MARK, Linux only) that an "ip rule
+	// fwmark" maps to a policy routing table, for uplinks reached through
+	// their own table rather than an interface.
+	Mark uint32
+}
+
+// ParseBinding splits an "@iface" or "@source-ip" suffix off a host target,
+// e.g. "1.1.1.1@wlan0" or "1.1.1.1:443@192.168.8.2". It must not be used on
+// URLs, whose userinfo may contain '@'.
+func ParseBinding(raw string) (string, Binding) {
+	i := strings.LastIndex(raw, "@")
+	if i < 0 {
+		return raw, Binding{}
+	}
+	target, spec := raw[:i], strings.TrimSpace(raw[i+1:])
+	if net.ParseIP(spec) != nil {
+		return target, Binding{Source: spec}
+	}
+	return target, Binding{Interface: spec}
+}
+
+// IsZero reports whether b leaves routing to the kernel.
+func (b Binding) IsZero() bool {
+	return b == Binding{}
+}
+
+// PrefersIPv6 reports whether b's source address is IPv6, so names should
+// resolve to IPv6 addresses for it.
+func (b Binding) PrefersIPv6() bool {
+	ip := net.ParseIP(b.Source)
+	return ip != nil && ip.To4() == nil
+}
+
+// Label is the value for an "interface" metric label: the interface name,
+// else the source address, else empty.
+func (b Binding) Label() string {
+	if b.Interface != "" {
+		return b.Interface
+	}
+	return b.Source
+}
+
+// Apply configures dialer to use the binding. The interface is bound with
+// SO_BINDTODEVICE on Linux; on other platforms its first address is used as
+// source instead. Any existing dialer Control function still runs.
+func (b Binding) Apply(dialer *net.Dialer) error {
+	if b.Mark != 0 {
+		if err := markSocket(dialer, b.Mark); err != nil {
+			return err
+		}
+	}
+	if b.Source != "" {
+		ip := net.ParseIP(b.Source)
+		if ip == nil {
+			return fmt.Errorf("invalid source address %q", b.Source)
+		}
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+	if b.Interface == "" {
+		return nil
+	}
+	if _, err := net.InterfaceByName(b.Interface); err != nil {
+		return fmt.Errorf("interface %q: %w", b.Interface, err)
+	}
+	return bindInterface(dialer, b.Interface)
+}
+
+// chainControl runs prev (if any) and then next on the raw socket.
+func chainControl(prev, next func(network, address string, c syscall.RawConn) error) func(network, address string, c syscall.RawConn) error {
+	if prev == nil {
+		return next
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		if err := prev(network, address, c); err != nil {
+			return err
+		}
+		return next(network, address, c)
+	}
+}
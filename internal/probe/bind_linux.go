@@ -0,0 +1,41 @@
+//go:build linux
+
+package probe
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// bindInterface pins the socket to iface so the probe cannot be rerouted
+// over another uplink.
+func bindInterface(dialer *net.Dialer, iface string) error {
+	dialer.Control = chainControl(dialer.Control, func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			sockErr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface)
+		})
+		if err != nil {
+			return err
+		}
+		return os.NewSyscallError("setsockopt SO_BINDTODEVICE", sockErr)
+	})
+	return nil
+}
+
+// markSocket sets the firewall mark that selects the socket's policy
+// routing table. It needs CAP_NET_ADMIN.
+func markSocket(dialer *net.Dialer, mark uint32) error {
+	dialer.Control = chainControl(dialer.Control, func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK, int(mark))
+		})
+		if err != nil {
+			return err
+		}
+		return os.NewSyscallError("setsockopt SO_MARK", sockErr)
+	})
+	return nil
+}
@@ -0,0 +1,50 @@
+//go:build !linux
+
+package probe
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// bindInterface uses the interface's first address as the source address.
+// This selects the uplink on hosts with per-source routing but, unlike
+// SO_BINDTODEVICE on Linux, does not pin the route.
+func bindInterface(dialer *net.Dialer, iface string) error {
+	if dialer.LocalAddr != nil {
+		return nil
+	}
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return err
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return err
+	}
+	var fallback net.IP
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ipnet.IP.To4() != nil {
+			dialer.LocalAddr = &net.TCPAddr{IP: ipnet.IP}
+			return nil
+		}
+		if fallback == nil {
+			fallback = ipnet.IP
+		}
+	}
+	if fallback == nil {
+		return fmt.Errorf("interface %q has no usable address", iface)
+	}
+	dialer.LocalAddr = &net.TCPAddr{IP: fallback}
+	return nil
+}
+
+// markSocket fails: firewall marks and policy routing tables are Linux's.
+func markSocket(*net.Dialer, uint32) error {
+	return errors.New("firewall marks are only supported on Linux")
+}
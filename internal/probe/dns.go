@@ -0,0 +1,274 @@
+package probe
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// resolvConfPath is read for the system nameserver.
+const resolvConfPath = "/etc/resolv.conf"
+
+// dnsMessageType is the DoH media type.
+const dnsMessageType = "application/dns-message"
+
+// DNSRcodeError reports a response with an error rcode such as SERVFAIL
+// or NXDOMAIN.
+type DNSRcodeError struct {
+	Rcode int
+}
+
+func (e *DNSRcodeError) Error() string {
+	return "server answered " + RcodeName(e.Rcode)
+}
+
+// DNSNoDataError reports a successful response without records of the
+// queried type (NODATA).
+type DNSNoDataError struct {
+	Type DNSType
+}
+
+func (e *DNSNoDataError) Error() string {
+	return "no " + e.Type.String() + " records in answer"
+}
+
+// DNSTransport selects how DNSResolver reaches its server.
+type DNSTransport string
+
+const (
+	// DNSOverUDP sends queries over UDP and retries truncated answers over
+	// TCP, like a stub resolver. It is the zero value's transport.
+	DNSOverUDP DNSTransport = "udp"
+	// DNSOverTCP sends queries over TCP (RFC 7766).
+	DNSOverTCP DNSTransport = "tcp"
+	// DNSOverTLS sends queries over TLS on port 853 (RFC 7858).
+	DNSOverTLS DNSTransport = "dot"
+	// DNSOverHTTPS POSTs queries to a URL (RFC 8484).
+	DNSOverHTTPS DNSTransport = "doh"
+)
+
+// DNSResolver sends queries straight to one DNS server. Unlike
+// net.Resolver it can ask for any record type and exposes the rcode and
+// TTLs, and it does not consult /etc/hosts. Every query opens a new
+// connection, so TCP, TLS and HTTPS latencies include the handshakes.
+type DNSResolver struct {
+	Transport DNSTransport
+	// Server is the nameserver as host or host:port for UDP, TCP and TLS
+	// (default port 53, or 853 for TLS), or the query URL for HTTPS. Empty
+	// uses the first nameserver in /etc/resolv.conf.
+	Server string
+	// ServerName is verified against the TLS certificate of DoT and DoH
+	// servers. It defaults to the host in Server.
+	ServerName string
+	// DNSSEC sets the DO bit so a DNSSEC-aware resolver returns RRSIG
+	// records along with the answer.
+	DNSSEC bool
+}
+
+// Query sends a recursive query for name and qtype and returns the
+// response and the latency. A response with an error rcode or without
+// records of qtype is returned together with an *Error of class ClassDNS.
+func (r DNSResolver) Query(ctx context.Context, name string, qtype DNSType) (*DNSResponse, time.Duration, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	// DoH uses ID 0 so HTTP caches can match identical queries (RFC 8484).
+	var id uint16
+	if r.Transport != DNSOverHTTPS {
+		id = uint16(rand.Uint32())
+	}
+	query, err := buildQuery(id, name, qtype, r.DNSSEC)
+	if err != nil {
+		return nil, 0, &Error{Op: "dns query", Target: name, Class: ClassOther, Err: err}
+	}
+
+	start := time.Now()
+	var resp *DNSResponse
+	switch r.Transport {
+	case DNSOverUDP, "":
+		server := r.address("53")
+		resp, err = exchangeUDP(ctx, server, query, id, name, qtype)
+		if err == nil && resp.Truncated {
+			resp, err = exchangeStream(ctx, tcpDialer(server), query, id, name, qtype)
+		}
+	case DNSOverTCP:
+		resp, err = exchangeStream(ctx, tcpDialer(r.address("53")), query, id, name, qtype)
+	case DNSOverTLS:
+		resp, err = exchangeStream(ctx, r.tlsDialer(), query, id, name, qtype)
+	case DNSOverHTTPS:
+		resp, err = r.exchangeHTTPS(ctx, query, id, name, qtype)
+	default:
+		err = fmt.Errorf("unknown DNS transport %q", r.Transport)
+	}
+	latency := time.Since(start)
+
+	if err != nil {
+		var perr *Error
+		if errors.As(err, &perr) {
+			return nil, latency, perr
+		}
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, latency, newError("dns query", name, err)
+	}
+	if resp.Rcode != RcodeSuccess {
+		return resp, latency, &Error{Op: "dns query", Target: name, Class: ClassDNS, Err: &DNSRcodeError{Rcode: resp.Rcode}}
+	}
+	if len(resp.Records(qtype)) == 0 {
+		return resp, latency, &Error{Op: "dns query", Target: name, Class: ClassDNS, Err: &DNSNoDataError{Type: qtype}}
+	}
+	return resp, latency, nil
+}
+
+func exchangeUDP(ctx context.Context, server string, query []byte, id uint16, name string, qtype DNSType) (*DNSResponse, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, ednsUDPSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// Late answers to earlier queries and spoofed datagrams do not
+		// match; keep waiting for ours.
+		if resp, err := parseResponse(buf[:n], id, name, qtype); err == nil {
+			return resp, nil
+		}
+	}
+}
+
+// address returns Server with defaultPort added when it has none, or the
+// system nameserver when Server is empty.
+func (r DNSResolver) address(defaultPort string) string {
+	server := r.Server
+	if server == "" {
+		server = systemNameserver()
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(strings.Trim(server, "[]"), defaultPort)
+	}
+	return server
+}
+
+type streamDialer func(ctx context.Context) (net.Conn, error)
+
+func tcpDialer(server string) streamDialer {
+	return func(ctx context.Context) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", server)
+	}
+}
+
+func (r DNSResolver) tlsDialer() streamDialer {
+	server := r.address("853")
+	serverName := r.ServerName
+	if serverName == "" {
+		serverName, _, _ = net.SplitHostPort(server)
+	}
+	return func(ctx context.Context) (net.Conn, error) {
+		d := tls.Dialer{Config: &tls.Config{ServerName: serverName, MinVersion: tls.VersionTLS12}}
+		return d.DialContext(ctx, "tcp", server)
+	}
+}
+
+// exchangeStream sends a length-prefixed query over a stream connection
+// (TCP or TLS).
+func exchangeStream(ctx context.Context, dial streamDialer, query []byte, id uint16, name string, qtype DNSType) (*DNSResponse, error) {
+	conn, err := dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	framed := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(query)), uint16(len(query)))
+	if _, err := conn.Write(append(framed, query...)); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, msg); err != nil {
+		return nil, err
+	}
+	return parseResponse(msg, id, name, qtype)
+}
+
+// exchangeHTTPS POSTs the query to the DoH URL.
+func (r DNSResolver) exchangeHTTPS(ctx context.Context, query []byte, id uint16, name string, qtype DNSType) (*DNSResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.Server, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dnsMessageType)
+	req.Header.Set("Accept", dnsMessageType)
+
+	tlsConfig := &tls.Config{ServerName: r.ServerName, MinVersion: tls.VersionTLS12}
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:             http.ProxyFromEnvironment,
+		TLSClientConfig:   tlsConfig,
+		DisableKeepAlives: true,
+		ForceAttemptHTTP2: true,
+	}}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &Error{Op: "dns query", Target: name, Class: ClassHTTPStatus, Err: &StatusError{StatusCode: resp.StatusCode}}
+	}
+	msg, err := io.ReadAll(io.LimitReader(resp.Body, maxBodyBytes))
+	if err != nil {
+		return nil, err
+	}
+	return parseResponse(msg, id, name, qtype)
+}
+
+// systemNameserver returns the first nameserver in /etc/resolv.conf, or
+// the loopback address the C library falls back to.
+func systemNameserver() string {
+	f, err := os.Open(resolvConfPath)
+	if err != nil {
+		return "127.0.0.1"
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			if net.ParseIP(fields[1]) != nil {
+				return fields[1]
+			}
+		}
+	}
+	return "127.0.0.1"
+}
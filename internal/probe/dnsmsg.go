@@ -0,0 +1,351 @@
+package probe
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// DNSType is a DNS resource record type.
+type DNSType uint16
+
+const (
+	TypeA     DNSType = 1
+	TypeNS    DNSType = 2
+	TypeCNAME DNSType = 5
+	TypeSOA   DNSType = 6
+	TypeMX    DNSType = 15
+	TypeTXT   DNSType = 16
+	TypeAAAA  DNSType = 28
+	typeOPT   DNSType = 41
+	TypeRRSIG DNSType = 46
+)
+
+var dnsTypeNames = map[DNSType]string{
+	TypeA:     "A",
+	TypeNS:    "NS",
+	TypeCNAME: "CNAME",
+	TypeSOA:   "SOA",
+	TypeMX:    "MX",
+	TypeTXT:   "TXT",
+	TypeAAAA:  "AAAA",
+	TypeRRSIG: "RRSIG",
+}
+
+func (t DNSType) String() string {
+	if s, ok := dnsTypeNames[t]; ok {
+		return s
+	}
+	return "TYPE" + strconv.Itoa(int(t))
+}
+
+// ParseDNSType parses a record type name such as "AAAA" (case-insensitive).
+func ParseDNSType(s string) (DNSType, error) {
+	for t, name := range dnsTypeNames {
+		if strings.EqualFold(s, name) {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported DNS record type %q", s)
+}
+
+// DNS response codes (RFC 1035, RFC 6895).
+const (
+	RcodeSuccess  = 0
+	RcodeFormErr  = 1
+	RcodeServFail = 2
+	RcodeNXDomain = 3
+	RcodeNotImp   = 4
+	RcodeRefused  = 5
+)
+
+var rcodeNames = map[int]string{
+	RcodeSuccess:  "NOERROR",
+	RcodeFormErr:  "FORMERR",
+	RcodeServFail: "SERVFAIL",
+	RcodeNXDomain: "NXDOMAIN",
+	RcodeNotImp:   "NOTIMP",
+	RcodeRefused:  "REFUSED",
+}
+
+// RcodeName returns the mnemonic of a response code, e.g. "SERVFAIL".
+func RcodeName(rcode int) string {
+	if s, ok := rcodeNames[rcode]; ok {
+		return s
+	}
+	return "RCODE" + strconv.Itoa(rcode)
+}
+
+// ParseRcode parses a response code mnemonic such as "NXDOMAIN"
+// (case-insensitive).
+func ParseRcode(s string) (int, bool) {
+	for rcode, name := range rcodeNames {
+		if strings.EqualFold(s, name) {
+			return rcode, true
+		}
+	}
+	return 0, false
+}
+
+// DNSRecord is one resource record of a response. Data is the record's
+// presentation form: an address for A/AAAA, a name for NS/CNAME,
+// "preference exchange" for MX, the joined strings for TXT and
+// "mname rname serial refresh retry expire minimum" for SOA.
+type DNSRecord struct {
+	Name string
+	Type DNSType
+	TTL  uint32
+	Data string
+}
+
+// DNSResponse is a parsed DNS response.
+type DNSResponse struct {
+	Rcode     int
+	Truncated bool
+	// AuthenticatedData is the AD flag: the resolver validated the answer
+	// with DNSSEC.
+	AuthenticatedData bool
+	Answers           []DNSRecord
+	Authority         []DNSRecord
+}
+
+// Records returns the answers of type t.
+func (r *DNSResponse) Records(t DNSType) []DNSRecord {
+	var out []DNSRecord
+	for _, rr := range r.Answers {
+		if rr.Type == t {
+			out = append(out, rr)
+		}
+	}
+	return out
+}
+
+const (
+	dnsHeaderLen = 12
+	// ednsUDPSize is the EDNS(0) buffer size advertised in queries, the
+	// DNS flag day 2020 recommendation that avoids IP fragmentation.
+	ednsUDPSize = 1232
+
+	flagQR = 1 << 15
+	flagTC = 1 << 9
+	flagRD = 1 << 8
+	flagAD = 1 << 5
+
+	// ednsDO is the DNSSEC OK bit in the OPT record's flags.
+	ednsDO = 1 << 15
+)
+
+var errMalformed = errors.New("malformed DNS message")
+
+// buildQuery encodes a recursive query for name/qtype with an EDNS(0) OPT
+// record. dnssec sets the DO bit, asking for signatures.
+func buildQuery(id uint16, name string, qtype DNSType, dnssec bool) ([]byte, error) {
+	msg := make([]byte, dnsHeaderLen, 512)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], flagRD)
+	binary.BigEndian.PutUint16(msg[4:], 1)  // QDCOUNT
+	binary.BigEndian.PutUint16(msg[10:], 1) // ARCOUNT
+
+	msg, err := appendName(msg, name)
+	if err != nil {
+		return nil, err
+	}
+	msg = binary.BigEndian.AppendUint16(msg, uint16(qtype))
+	msg = binary.BigEndian.AppendUint16(msg, 1) // class IN
+
+	// OPT pseudo-record: root name, type, UDP size as class, TTL holds the
+	// extended rcode and flags, no options.
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, uint16(typeOPT))
+	msg = binary.BigEndian.AppendUint16(msg, ednsUDPSize)
+	var ednsFlags uint32
+	if dnssec {
+		ednsFlags = ednsDO
+	}
+	msg = binary.BigEndian.AppendUint32(msg, ednsFlags)
+	msg = binary.BigEndian.AppendUint16(msg, 0)
+	return msg, nil
+}
+
+func appendName(msg []byte, name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if label == "" || len(label) > 63 {
+				return nil, fmt.Errorf("invalid domain name %q", name)
+			}
+			msg = append(msg, byte(len(label)))
+			msg = append(msg, label...)
+		}
+	}
+	return append(msg, 0), nil
+}
+
+// parseResponse decodes a response to the query with the given id, name
+// and type.
+func parseResponse(msg []byte, id uint16, name string, qtype DNSType) (*DNSResponse, error) {
+	if len(msg) < dnsHeaderLen {
+		return nil, errMalformed
+	}
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if binary.BigEndian.Uint16(msg) != id || flags&flagQR == 0 {
+		return nil, errors.New("DNS response does not match the query")
+	}
+	resp := &DNSResponse{
+		Rcode:             int(flags & 0x0f),
+		Truncated:         flags&flagTC != 0,
+		AuthenticatedData: flags&flagAD != 0,
+	}
+	qd := int(binary.BigEndian.Uint16(msg[4:]))
+	an := int(binary.BigEndian.Uint16(msg[6:]))
+	ns := int(binary.BigEndian.Uint16(msg[8:]))
+
+	off := dnsHeaderLen
+	for i := 0; i < qd; i++ {
+		qname, next, err := readName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		if next+4 > len(msg) {
+			return nil, errMalformed
+		}
+		if !strings.EqualFold(qname, strings.TrimSuffix(name, ".")) || DNSType(binary.BigEndian.Uint16(msg[next:])) != qtype {
+			return nil, errors.New("DNS response does not match the query")
+		}
+		off = next + 4
+	}
+
+	var err error
+	if resp.Answers, off, err = readRecords(msg, off, an); err != nil {
+		return nil, err
+	}
+	if resp.Authority, _, err = readRecords(msg, off, ns); err != nil {
+		// A truncated response may stop anywhere after the answers.
+		if !resp.Truncated {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+func readRecords(msg []byte, off, count int) ([]DNSRecord, int, error) {
+	var out []DNSRecord
+	for i := 0; i < count; i++ {
+		name, next, err := readName(msg, off)
+		if err != nil {
+			return out, off, err
+		}
+		if next+10 > len(msg) {
+			return out, off, errMalformed
+		}
+		rr := DNSRecord{
+			Name: name,
+			Type: DNSType(binary.BigEndian.Uint16(msg[next:])),
+			TTL:  binary.BigEndian.Uint32(msg[next+4:]),
+		}
+		rdlen := int(binary.BigEndian.Uint16(msg[next+8:]))
+		start := next + 10
+		if start+rdlen > len(msg) {
+			return out, off, errMalformed
+		}
+		if rr.Data, err = formatRData(msg, start, rdlen, rr.Type); err != nil {
+			return out, off, err
+		}
+		out = append(out, rr)
+		off = start + rdlen
+	}
+	return out, off, nil
+}
+
+// formatRData renders the record data at msg[off:off+n]. Names inside the
+// data may be compressed, so the whole message is needed.
+func formatRData(msg []byte, off, n int, t DNSType) (string, error) {
+	rdata := msg[off : off+n]
+	switch t {
+	case TypeA, TypeAAAA:
+		if (t == TypeA && n != net.IPv4len) || (t == TypeAAAA && n != net.IPv6len) {
+			return "", errMalformed
+		}
+		return net.IP(rdata).String(), nil
+	case TypeNS, TypeCNAME:
+		name, _, err := readName(msg, off)
+		return name, err
+	case TypeMX:
+		if n < 3 {
+			return "", errMalformed
+		}
+		name, _, err := readName(msg, off+2)
+		return strconv.Itoa(int(binary.BigEndian.Uint16(rdata))) + " " + name, err
+	case TypeTXT:
+		var b strings.Builder
+		for i := 0; i < n; {
+			l := int(rdata[i])
+			if i+1+l > n {
+				return "", errMalformed
+			}
+			b.Write(rdata[i+1 : i+1+l])
+			i += 1 + l
+		}
+		return b.String(), nil
+	case TypeSOA:
+		mname, next, err := readName(msg, off)
+		if err != nil {
+			return "", err
+		}
+		rname, next, err := readName(msg, next)
+		if err != nil {
+			return "", err
+		}
+		if next+20 > off+n {
+			return "", errMalformed
+		}
+		fields := []string{mname, rname}
+		for i := 0; i < 5; i++ {
+			fields = append(fields, strconv.FormatUint(uint64(binary.BigEndian.Uint32(msg[next+4*i:])), 10))
+		}
+		return strings.Join(fields, " "), nil
+	}
+	return fmt.Sprintf("%x", rdata), nil
+}
+
+// readName decodes a possibly compressed name at off and returns it without
+// the trailing dot ("." for the root) and the offset after it.
+func readName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for hops := 0; ; hops++ {
+		if off >= len(msg) || hops > 127 {
+			return "", 0, errMalformed
+		}
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			if len(labels) == 0 {
+				return ".", end, nil
+			}
+			return strings.Join(labels, "."), end, nil
+		case l&0xc0 == 0xc0:
+			if off+1 >= len(msg) {
+				return "", 0, errMalformed
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+		case l&0xc0 != 0:
+			return "", 0, errMalformed
+		default:
+			if off+1+l > len(msg) {
+				return "", 0, errMalformed
+			}
+			labels = append(labels, string(msg[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"time"
+)
+
+// maxBodyBytes bounds how much of a response body is read.
+const maxBodyBytes = 64 << 10
+
+// StatusError reports an HTTP response outside the accepted status range.
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d", e.StatusCode)
+}
+
+// HTTPExpect describes a healthy response beyond its status class. It is
+// used to spot captive portals and transparent proxies that answer in place
+// of the real endpoint. The zero value accepts any 200-399 response.
+type HTTPExpect struct {
+	// Status is the exact status required (e.g. 204 for a connectivity
+	// check endpoint); 0 accepts 200-399.
+	Status int
+	// BodyContains must appear in the first 64 KiB of the body.
+	BodyContains string
+	// CertSubject must appear in the leaf certificate's common name or one
+	// of its DNS names. Plain HTTP responses never match.
+	CertSubject string
+}
+
+// IsZero reports whether e places no expectations beyond the status class.
+func (e HTTPExpect) IsZero() bool {
+	return e == HTTPExpect{}
+}
+
+// InterceptError reports a response that did not come from the expected
+// endpoint. Reason is one of "redirect", "status", "body" or "cert".
+type InterceptError struct {
+	Reason string
+	Detail string
+}
+
+func (e *InterceptError) Error() string {
+	return fmt.Sprintf("response intercepted (%s): %s", e.Reason, e.Detail)
+}
+
+// RedirectPolicy says which redirects an HTTP probe follows. A redirect
+// that is not followed is the probe's response: a 3xx is a success and
+// expectations are checked against it.
+type RedirectPolicy string
+
+const (
+	// FollowRedirects follows redirects up to the client's limit.
+	FollowRedirects RedirectPolicy = ""
+	// NoRedirects follows none, e.g. to expect the 302 of a login page.
+	NoRedirects RedirectPolicy = "none"
+	// SameHostRedirects follows redirects within the target's host only.
+	SameHostRedirects RedirectPolicy = "same_host"
+)
+
+// ParseRedirectPolicy parses "follow", "none" or "same_host".
+func ParseRedirectPolicy(v string) (RedirectPolicy, error) {
+	switch p := RedirectPolicy(strings.ToLower(v)); p {
+	case "follow":
+		return FollowRedirects, nil
+	case NoRedirects, SameHostRedirects:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid redirect policy %q (valid: follow, none, same_host)", v)
+}
+
+// HTTPRequest shapes the request an HTTP probe sends. The zero value is a
+// GET without extra headers that follows redirects.
+type HTTPRequest struct {
+	// Method defaults to GET. A HEAD response has no body to check.
+	Method string
+	// Header is added to the request. A Host entry overrides the Host
+	// header, for virtual hosts reached by IP or through a load balancer,
+	// and over HTTPS also the TLS server name sent and verified, so the
+	// certificate is checked against it instead of the address in the URL;
+	// credentials go in Authorization.
+	Header    http.Header
+	Redirects RedirectPolicy
+}
+
+// HTTPResult is the outcome of an HTTP probe. Phase durations are zero for
+// phases that did not happen, e.g. DNS for an IP literal or connect and TLS
+// on a reused connection. With redirects, the phases of the last request
+// are reported.
+type HTTPResult struct {
+	StatusCode int
+	DNS        time.Duration // name resolution
+	Connect    time.Duration // TCP connect
+	TLS        time.Duration // TLS handshake
+	TTFB       time.Duration // request written until first response byte
+	Total      time.Duration // start until response headers were received
+}
+
+// HTTP issues a GET to url and returns the time until response headers were
+// received together with the status code. Statuses outside 200-399 are
+// failures classified as ClassHTTPStatus. A nil client uses
+// http.DefaultClient.
+func HTTP(ctx context.Context, client *http.Client, url string) (time.Duration, int, error) {
+	res, err := HTTPCheck(ctx, client, url, HTTPExpect{})
+	return res.Total, res.StatusCode, err
+}
+
+// HTTPCheck is HTTP with response expectations and a per-phase timing
+// breakdown. A response that arrives but fails an expectation is classified
+// as ClassIntercepted: a redirect to a different host, a success status
+// other than the expected one, a missing body marker or an unexpected
+// certificate all indicate that something other than the target answered.
+func HTTPCheck(ctx context.Context, client *http.Client, url string, expect HTTPExpect) (HTTPResult, error) {
+	return HTTPCheckRequest(ctx, client, url, HTTPRequest{}, expect)
+}
+
+// HTTPCheckRequest is HTTPCheck sending the request r describes.
+func HTTPCheckRequest(ctx context.Context, client *http.Client, url string, r HTTPRequest, expect HTTPExpect) (HTTPResult, error) {
+	var res HTTPResult
+	if client == nil {
+		client = http.DefaultClient
+	}
+	method := r.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	op := "http " + strings.ToLower(method)
+	if r.Redirects != FollowRedirects {
+		c := *client
+		c.CheckRedirect = checkRedirect(r.Redirects)
+		client = &c
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var dnsStart, connectStart, tlsStart, wrote time.Time
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone:  func(httptrace.DNSDoneInfo) { res.DNS = time.Since(dnsStart) },
+		ConnectStart: func(string, string) {
+			if connectStart.IsZero() {
+				connectStart = time.Now()
+			}
+		},
+		ConnectDone:          func(string, string, error) { res.Connect = time.Since(connectStart) },
+		TLSHandshakeStart:    func() { tlsStart = time.Now() },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { res.TLS = time.Since(tlsStart) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { wrote = time.Now() },
+		GotFirstResponseByte: func() { res.TTFB = time.Since(wrote) },
+		GotConn: func(httptrace.GotConnInfo) {
+			// Reset per hop so redirects report the final request only.
+			connectStart = time.Time{}
+		},
+	}
+
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), method, url, nil)
+	if err != nil {
+		return res, &Error{Op: op, Target: url, Class: ClassOther, Err: err}
+	}
+	for k, vs := range r.Header {
+		if http.CanonicalHeaderKey(k) == "Host" {
+			req.Host = vs[0]
+			continue
+		}
+		req.Header[http.CanonicalHeaderKey(k)] = vs
+	}
+	if req.Host != "" && req.URL.Scheme == "https" {
+		var closeIdle func()
+		client, closeIdle = withServerName(client, req.Host)
+		defer closeIdle()
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return res, newError(op, url, err)
+	}
+	res.Total = time.Since(start)
+	res.StatusCode = resp.StatusCode
+	defer resp.Body.Close()
+	// Read a bounded amount so the connection can be reused.
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxBodyBytes))
+
+	statusOK := resp.StatusCode >= 200 && resp.StatusCode < 400
+	if !statusOK {
+		return res, &Error{
+			Op:     op,
+			Target: url,
+			Class:  ClassHTTPStatus,
+			Err:    &StatusError{StatusCode: resp.StatusCode},
+		}
+	}
+	if expect.IsZero() {
+		return res, nil
+	}
+
+	if ierr := checkExpect(req, resp, body, expect); ierr != nil {
+		return res, &Error{Op: op, Target: url, Class: ClassIntercepted, Err: ierr}
+	}
+	return res, nil
+}
+
+// withServerName returns client with a transport that sends and verifies
+// the TLS server name of host instead of the URL's, and a func that closes
+// the copy's idle connections. A transport other than *http.Transport is
+// kept as it is.
+func withServerName(client *http.Client, host string) (*http.Client, func()) {
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	t, ok := base.(*http.Transport)
+	if !ok {
+		return client, func() {}
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	t = t.Clone()
+	if t.TLSClientConfig == nil {
+		t.TLSClientConfig = &tls.Config{}
+	}
+	t.TLSClientConfig.ServerName = host
+	c := *client
+	c.Transport = t
+	return &c, t.CloseIdleConnections
+}
+
+// checkRedirect returns the client redirect check of policy p.
+func checkRedirect(p RedirectPolicy) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if p == NoRedirects || req.URL.Hostname() != via[0].URL.Hostname() {
+			return http.ErrUseLastResponse
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+}
+
+func checkExpect(req *http.Request, resp *http.Response, body []byte, expect HTTPExpect) *InterceptError {
+	if final := resp.Request.URL; final.Hostname() != req.URL.Hostname() {
+		return &InterceptError{Reason: "redirect", Detail: "redirected to " + final.Host}
+	}
+	if expect.Status != 0 && resp.StatusCode != expect.Status {
+		return &InterceptError{Reason: "status", Detail: fmt.Sprintf("got %d, want %d", resp.StatusCode, expect.Status)}
+	}
+	if expect.BodyContains != "" && !bytes.Contains(body, []byte(expect.BodyContains)) {
+		return &InterceptError{Reason: "body", Detail: fmt.Sprintf("body does not contain %q", expect.BodyContains)}
+	}
+	if expect.CertSubject != "" {
+		if resp.TLS == nil || len(resp.TLS.PeerCertificates) == 0 {
+			return &InterceptError{Reason: "cert", Detail: "no TLS certificate"}
+		}
+		leaf := resp.TLS.PeerCertificates[0]
+		names := append([]string{leaf.Subject.CommonName}, leaf.DNSNames...)
+		matched := false
+		for _, n := range names {
+			if strings.Contains(n, expect.CertSubject) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return &InterceptError{Reason: "cert", Detail: fmt.Sprintf("certificate %q does not match %q", leaf.Subject.CommonName, expect.CertSubject)}
+		}
+	}
+	return nil
+}
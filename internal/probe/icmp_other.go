@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package probe
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ICMP is not supported on this platform.
+func ICMP(ctx context.Context, host string) (time.Duration, error) {
+	return 0, &Error{Op: "icmp echo", Target: host, Class: ClassOther, Err: errors.New("unprivileged ICMP is not supported on this platform")}
+}
@@ -0,0 +1,15 @@
+package probe
+
+const (
+	minPathMTU    = 68 // every IPv4 link must carry 68-byte packets
+	ipv4HeaderLen = 20
+)
+
+// PathMTUResult is the outcome of a path MTU search. Sizes are IPv4 packet
+// lengths including headers, as in an interface MTU.
+type PathMTUResult struct {
+	MTU         int  // largest packet answered with DF set
+	LocalMTU    int  // MTU of the outgoing interface
+	ReportedMTU int  // next-hop MTU from a Fragmentation Needed message, 0 if none
+	Blackhole   bool // larger packets were dropped without Fragmentation Needed
+}
@@ -0,0 +1,239 @@
+//go:build linux
+
+package probe
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// ipPMTUDiscProbe sets DF on every packet but ignores the kernel's cached
+// path MTU, so the search can send sizes above a previously reported limit.
+const ipPMTUDiscProbe = 3
+
+// PathMTU searches for the largest IPv4 packet that reaches host without
+// fragmentation. It sends DF-set ICMP echo requests on an unprivileged ping
+// socket and bisects between the IPv4 minimum and the route MTU. Sizes are
+// retried up to attempts times so one lost packet is not mistaken for an
+// MTU limit; each attempt waits at most attemptTimeout for the reply.
+func PathMTU(ctx context.Context, host string, attemptTimeout time.Duration, attempts int) (PathMTUResult, error) {
+	var res PathMTUResult
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	ip, err := resolveIPv4(ctx, host)
+	if err != nil {
+		return res, newError("path mtu", host, err)
+	}
+	sock, err := newPMTUSocket(ip)
+	if err != nil {
+		return res, &Error{Op: "path mtu", Target: host, Class: ClassOther, Err: err}
+	}
+	defer sock.close()
+
+	// Start from the interface MTU rather than the route MTU, which may
+	// still hold a limit the kernel learned earlier.
+	res.LocalMTU = sock.interfaceMTU()
+	if res.LocalMTU <= minPathMTU {
+		res.LocalMTU = sock.routeMTU()
+	}
+	if res.LocalMTU <= minPathMTU {
+		res.LocalMTU = 1500
+	}
+
+	try := func(size int) (pmtuOutcome, error) {
+		for i := 0; i < attempts; i++ {
+			if err := ctx.Err(); err != nil {
+				return pmtuLost, err
+			}
+			out, err := sock.echo(ctx, size, attemptTimeout)
+			if err != nil || out != pmtuLost {
+				return out, err
+			}
+		}
+		return pmtuLost, nil
+	}
+
+	// A minimum-size echo must succeed, otherwise the target is simply
+	// unreachable and no MTU can be inferred.
+	out, err := try(minPathMTU)
+	if err == nil && out != pmtuOK {
+		err = errors.New("no echo reply")
+		if out != pmtuLost {
+			err = syscall.EMSGSIZE
+		}
+	}
+	if err != nil {
+		return res, newError("path mtu", host, err)
+	}
+
+	// Invariant: lo got a reply, hi did not (or is one past the route MTU).
+	lo, hi := minPathMTU, res.LocalMTU+1
+	dropped, fragNeeded := false, false
+	for next := res.LocalMTU; hi-lo > 1; next = lo + (hi-lo)/2 {
+		if res.ReportedMTU > lo && res.ReportedMTU < hi {
+			next = res.ReportedMTU
+		}
+		out, err := try(next)
+		if err != nil {
+			return res, newError("path mtu", host, err)
+		}
+		switch out {
+		case pmtuOK:
+			lo = next
+		case pmtuTooBigLocal:
+			hi = next
+		case pmtuTooBig:
+			hi, fragNeeded = next, true
+			if mtu := sock.routeMTU(); mtu >= minPathMTU && mtu < next {
+				res.ReportedMTU = mtu
+			}
+		case pmtuLost:
+			hi, dropped = next, true
+		}
+	}
+
+	res.MTU = lo
+	res.Blackhole = dropped && !fragNeeded
+	return res, nil
+}
+
+type pmtuOutcome int
+
+const (
+	pmtuOK          pmtuOutcome = iota
+	pmtuTooBig                  // a router answered Fragmentation Needed
+	pmtuTooBigLocal             // the kernel refused to send it
+	pmtuLost
+)
+
+// pmtuSocket is a ping socket connected to the target, so the kernel
+// reports Fragmentation Needed messages to it as EMSGSIZE.
+type pmtuSocket struct {
+	conn *net.UDPConn
+	raw  syscall.RawConn
+}
+
+func newPMTUSocket(ip net.IP) (*pmtuSocket, error) {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, syscall.IPPROTO_ICMP)
+	if err != nil {
+		return nil, fmt.Errorf("open ping socket: %w", err)
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, ipPMTUDiscProbe); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("setsockopt", err)
+	}
+	sa := &syscall.SockaddrInet4{}
+	copy(sa.Addr[:], ip.To4())
+	if err := syscall.Connect(fd, sa); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("connect", err)
+	}
+
+	f := os.NewFile(uintptr(fd), "icmp")
+	c, err := net.FileConn(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	conn, ok := c.(*net.UDPConn)
+	if !ok {
+		c.Close()
+		return nil, fmt.Errorf("unexpected ping socket type %T", c)
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &pmtuSocket{conn: conn, raw: raw}, nil
+}
+
+func (s *pmtuSocket) close() { s.conn.Close() }
+
+// interfaceMTU returns the MTU of the interface that owns the socket's
+// local address, or 0 if it cannot be found.
+func (s *pmtuSocket) interfaceMTU() int {
+	local, ok := s.conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return 0
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return 0
+	}
+	for _, ifi := range ifaces {
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok && n.IP.Equal(local.IP) {
+				return ifi.MTU
+			}
+		}
+	}
+	return 0
+}
+
+// routeMTU returns the kernel's current path MTU toward the target, which
+// starts at the interface MTU and drops when a router reports a smaller
+// next hop. It returns 0 if unknown.
+func (s *pmtuSocket) routeMTU() int {
+	mtu := 0
+	_ = s.raw.Control(func(fd uintptr) {
+		if v, err := syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU); err == nil {
+			mtu = v
+		}
+	})
+	return mtu
+}
+
+// echo sends one echo request whose IP packet is size bytes long.
+func (s *pmtuSocket) echo(ctx context.Context, size int, timeout time.Duration) (pmtuOutcome, error) {
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = s.conn.SetDeadline(deadline)
+
+	seq := uint16(icmpSeq.Add(1))
+	msg := make([]byte, size-ipv4HeaderLen)
+	msg[0] = 8 // echo request
+	binary.BigEndian.PutUint16(msg[6:], seq)
+	binary.BigEndian.PutUint16(msg[2:], checksum(msg))
+
+	if _, err := s.conn.Write(msg); err != nil {
+		if errors.Is(err, syscall.EMSGSIZE) {
+			return pmtuTooBigLocal, nil
+		}
+		return pmtuLost, err
+	}
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := s.conn.Read(buf)
+		switch {
+		case errors.Is(err, syscall.EMSGSIZE):
+			return pmtuTooBig, nil
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			if ctx.Err() != nil {
+				return pmtuLost, ctx.Err()
+			}
+			return pmtuLost, nil
+		case err != nil:
+			return pmtuLost, err
+		}
+		// Replies to earlier, timed-out attempts are skipped by sequence.
+		if n >= 8 && buf[0] == 0 && binary.BigEndian.Uint16(buf[6:]) == seq {
+			return pmtuOK, nil
+		}
+	}
+}
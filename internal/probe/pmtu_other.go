@@ -0,0 +1,14 @@
+//go:build !linux
+
+package probe
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// PathMTU is not supported on this platform.
+func PathMTU(ctx context.Context, host string, attemptTimeout time.Duration, attempts int) (PathMTUResult, error) {
+	return PathMTUResult{}, &Error{Op: "path mtu", Target: host, Class: ClassOther, Err: errors.New("path MTU discovery is not supported on this platform")}
+}
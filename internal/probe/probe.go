@@ -0,0 +1,125 @@
+// Package probe provides the network probers shared by the edge-monitor
+// services: TCP connect, HTTP GET, TLS handshake, DNS lookup, unprivileged
+// ICMP echo, path MTU discovery and traceroute.
+//
+// Every prober takes a context for cancellation and deadlines, returns the
+// measured latency on success, and returns an *Error carrying an ErrorClass
+// on failure so services classify failures the same way.
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// DefaultTimeout is the per-probe timeout used when a service does not
+// configure one.
+const DefaultTimeout = 2 * time.Second
+
+// ErrorClass is a coarse, bounded failure category suitable for logs and
+// metric labels.
+type ErrorClass string
+
+const (
+	ClassTimeout     ErrorClass = "timeout"
+	ClassRefused     ErrorClass = "refused"
+	ClassUnreachable ErrorClass = "unreachable"
+	ClassDNS         ErrorClass = "dns"
+	ClassTLS         ErrorClass = "tls"
+	ClassHTTPStatus  ErrorClass = "http_status"
+	ClassIntercepted ErrorClass = "intercepted"
+	ClassAssertion   ErrorClass = "assertion" // scripted check failed on the right endpoint
+	ClassCanceled    ErrorClass = "canceled"
+	ClassOther       ErrorClass = "other"
+)
+
+// Error describes a failed probe.
+type Error struct {
+	Op     string
+	Target string
+	Class  ErrorClass
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.Op, e.Target, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// newError wraps err for op/target and classifies it.
+func newError(op, target string, err error) *Error {
+	return &Error{Op: op, Target: target, Class: Classify(err), Err: err}
+}
+
+// Classify maps an error to an ErrorClass. A nil error has an empty class.
+func Classify(err error) ErrorClass {
+	if err == nil {
+		return ""
+	}
+
+	var perr *Error
+	if errors.As(err, &perr) && perr.Class != "" {
+		return perr.Class
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return ClassCanceled
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) {
+		return ClassTimeout
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		if dnsErr.IsTimeout {
+			return ClassTimeout
+		}
+		return ClassDNS
+	}
+
+	var certErr *tls.CertificateVerificationError
+	var unknownAuth x509.UnknownAuthorityError
+	var hostErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var recordErr tls.RecordHeaderError
+	if errors.As(err, &certErr) || errors.As(err, &unknownAuth) || errors.As(err, &hostErr) ||
+		errors.As(err, &invalidErr) || errors.As(err, &recordErr) {
+		return ClassTLS
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return ClassRefused
+	}
+	if errors.Is(err, syscall.ENETUNREACH) || errors.Is(err, syscall.EHOSTUNREACH) {
+		return ClassUnreachable
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ClassTimeout
+	}
+	return ClassOther
+}
+
+// IsTimeout reports whether err is a probe timeout.
+func IsTimeout(err error) bool {
+	return Classify(err) == ClassTimeout
+}
+
+// withTimeout applies DefaultTimeout when ctx has no deadline.
+func withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, DefaultTimeout)
+}
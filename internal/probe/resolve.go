@@ -0,0 +1,87 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// DefaultResolveTimeout bounds the name lookup made before a probe when a
+// service does not configure one.
+const DefaultResolveTimeout = time.Second
+
+var errNoAddress = errors.New("no addresses")
+
+// IsName reports whether the host of addr, which may carry a port, is a
+// name to look up rather than an IP literal.
+func IsName(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if host == "" {
+		return false
+	}
+	_, err = netip.ParseAddr(host)
+	return err != nil
+}
+
+// Resolve looks up the host of addr ("example.com", "example.com:443")
+// under its own timeout and returns addr with the host replaced by one of
+// its addresses, IPv4 first, and how long the lookup took. An addr whose
+// host is an IP literal is returned unchanged without a lookup.
+//
+// The connect probers resolve a name inside their own deadline, so a slow
+// resolver is counted as connect latency and can use up the probe timeout.
+// Resolving first keeps the two apart: the lookup gets timeout, the probe
+// its full budget. A nil resolver uses net.DefaultResolver; a timeout of
+// zero uses DefaultResolveTimeout.
+func Resolve(ctx context.Context, resolver *net.Resolver, addr string, timeout time.Duration) (string, time.Duration, error) {
+	return ResolveFrom(ctx, resolver, addr, timeout, Binding{})
+}
+
+// ResolveFrom is Resolve for a probe that leaves through b. When b's source
+// address is IPv6, IPv6 addresses are preferred instead, since the socket
+// could not reach an IPv4 one.
+func ResolveFrom(ctx context.Context, resolver *net.Resolver, addr string, timeout time.Duration, b Binding) (string, time.Duration, error) {
+	if !IsName(addr) {
+		return addr, 0, nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = addr, ""
+	}
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	if timeout <= 0 {
+		timeout = DefaultResolveTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	ips, err := resolver.LookupNetIP(ctx, "ip", host)
+	took := time.Since(start)
+	if err != nil {
+		return "", took, newError("resolve", host, err)
+	}
+	if len(ips) == 0 {
+		return "", took, &Error{Op: "resolve", Target: host, Class: ClassDNS, Err: errNoAddress}
+	}
+
+	ip := ips[0].Unmap()
+	v6 := b.PrefersIPv6()
+	for _, a := range ips {
+		if a.Unmap().Is4() != v6 {
+			ip = a.Unmap()
+			break
+		}
+	}
+	if port == "" {
+		return ip.String(), took, nil
+	}
+	return net.JoinHostPort(ip.String(), port), took, nil
+}
@@ -0,0 +1,49 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+var errNoPorts = errors.New("no ports to probe")
+
+// TCP dials host on each port in order and returns the connect latency of
+// the first port that accepts. If host already includes a port
+// ("192.168.1.1:53", "[::1]:443"), only that port is tried. A nil dialer
+// uses a zero net.Dialer; set Dialer.Control for socket options such as
+// DSCP marking. A host name is resolved within ctx's deadline; callers that
+// time resolution apart from the connect run Resolve first.
+func TCP(ctx context.Context, dialer *net.Dialer, host string, ports ...string) (time.Duration, error) {
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		host, ports = h, []string{p}
+	}
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var lastErr error
+	for _, port := range ports {
+		addr := net.JoinHostPort(host, port)
+		start := time.Now()
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		latency := time.Since(start)
+
+		if err == nil {
+			conn.Close()
+			return latency, nil
+		}
+		lastErr = newError("tcp dial", addr, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if lastErr == nil {
+		lastErr = &Error{Op: "tcp dial", Target: host, Class: ClassOther, Err: errNoPorts}
+	}
+	return 0, lastErr
+}
@@ -0,0 +1,104 @@
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"time"
+)
+
+// TLSResult is the outcome of a TLS handshake probe. Certificate fields are
+// filled whenever the server presented a certificate, even if it failed
+// verification, so expiry stays observable for broken endpoints.
+type TLSResult struct {
+	Connect   time.Duration // TCP connect
+	Handshake time.Duration // TLS handshake after connect
+	NotAfter  time.Time     // leaf certificate expiry
+	Subject   string        // leaf certificate common name
+	Version   uint16
+}
+
+// TLS connects to addr ("host:port"), performs a TLS handshake and verifies
+// the peer chain against the system roots for serverName (the host part of
+// addr when empty). Verification failures are returned as ClassTLS errors
+// together with a populated result; see TLSVerifyReason. A nil dialer uses
+// a zero net.Dialer.
+func TLS(ctx context.Context, dialer *net.Dialer, addr, serverName string) (TLSResult, error) {
+	var res TLSResult
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return res, &Error{Op: "tls", Target: addr, Class: ClassOther, Err: err}
+	}
+	if serverName == "" {
+		serverName = host
+	}
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	start := time.Now()
+	raw, err := dialer.DialContext(ctx, "tcp", addr)
+	res.Connect = time.Since(start)
+	if err != nil {
+		return res, newError("tcp dial", addr, err)
+	}
+	defer raw.Close()
+
+	// Verification is done after the handshake so the certificate can be
+	// inspected even when it is not trusted.
+	conn := tls.Client(raw, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	start = time.Now()
+	err = conn.HandshakeContext(ctx)
+	res.Handshake = time.Since(start)
+	if err != nil {
+		return res, newError("tls handshake", addr, err)
+	}
+
+	state := conn.ConnectionState()
+	res.Version = state.Version
+	if len(state.PeerCertificates) == 0 {
+		return res, &Error{Op: "tls verify", Target: addr, Class: ClassTLS, Err: errors.New("no peer certificate")}
+	}
+	leaf := state.PeerCertificates[0]
+	res.NotAfter = leaf.NotAfter
+	res.Subject = leaf.Subject.CommonName
+
+	intermediates := x509.NewCertPool()
+	for _, c := range state.PeerCertificates[1:] {
+		intermediates.AddCert(c)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{DNSName: serverName, Intermediates: intermediates}); err != nil {
+		return res, &Error{Op: "tls verify", Target: addr, Class: ClassTLS, Err: err}
+	}
+	return res, nil
+}
+
+// TLSVerifyReason maps a certificate verification error to a bounded label:
+// "expired", "unknown_authority", "hostname" or "invalid". It returns "" for
+// errors that are not verification failures.
+func TLSVerifyReason(err error) string {
+	var invalid x509.CertificateInvalidError
+	var unknown x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	switch {
+	case errors.As(err, &invalid):
+		if invalid.Reason == x509.Expired {
+			return "expired"
+		}
+		return "invalid"
+	case errors.As(err, &unknown):
+		return "unknown_authority"
+	case errors.As(err, &hostname):
+		return "hostname"
+	}
+	var perr *Error
+	if errors.As(err, &perr) && perr.Op == "tls verify" {
+		return "invalid"
+	}
+	return ""
+}
@@ -0,0 +1,39 @@
+package probe
+
+import "time"
+
+// Hop is one TTL step of a traceroute.
+type Hop struct {
+	TTL     int
+	Addr    string        // responding router, empty if the hop did not answer
+	RTT     time.Duration // zero if the hop did not answer
+	Reached bool          // the answer came from the destination itself
+}
+
+// TracerouteOptions bounds a traceroute.
+type TracerouteOptions struct {
+	MaxHops    int           // highest TTL tried (default 30)
+	HopTimeout time.Duration // wait per probe (default 1s)
+	Attempts   int           // probes per silent hop before giving up on it (default 1)
+	MaxSilent  int           // stop after this many silent hops in a row (default 5)
+	Port       int           // UDP destination port (default 33434)
+}
+
+func (o TracerouteOptions) withDefaults() TracerouteOptions {
+	if o.MaxHops <= 0 {
+		o.MaxHops = 30
+	}
+	if o.HopTimeout <= 0 {
+		o.HopTimeout = time.Second
+	}
+	if o.Attempts <= 0 {
+		o.Attempts = 1
+	}
+	if o.MaxSilent <= 0 {
+		o.MaxSilent = 5
+	}
+	if o.Port <= 0 {
+		o.Port = 33434
+	}
+	return o
+}
@@ -0,0 +1,205 @@
+//go:build linux
+
+package probe
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	soEEOriginICMP    = 2 // linux/errqueue.h SO_EE_ORIGIN_ICMP
+	icmpDestUnreach   = 3
+	icmpTimeExceeded  = 11
+	icmpPortUnreach   = 3
+	sockExtendedErrSz = 16
+)
+
+// Traceroute sends UDP datagrams with increasing TTL toward host and reads
+// the ICMP answers from the socket error queue (IP_RECVERR), as tracepath
+// does, so it needs neither a raw socket nor a capability. Every probe uses
+// the same source and destination port, so routers that balance per flow
+// (ECMP) keep the trace on one path and a different hop sequence means the
+// route itself changed. The trace ends at the destination, at an ICMP
+// Destination Unreachable, at MaxHops, or after MaxSilent silent hops;
+// trailing silent hops are dropped.
+func Traceroute(ctx context.Context, host string, opts TracerouteOptions) ([]Hop, error) {
+	opts = opts.withDefaults()
+
+	ip, err := resolveIPv4(ctx, host)
+	if err != nil {
+		return nil, newError("traceroute", host, err)
+	}
+
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, newError("traceroute", host, err)
+	}
+	defer conn.Close()
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, newError("traceroute", host, err)
+	}
+	if err := setsockoptInt(raw, syscall.IP_RECVERR, 1); err != nil {
+		return nil, &Error{Op: "traceroute", Target: host, Class: ClassOther, Err: err}
+	}
+
+	dst := &net.UDPAddr{IP: ip, Port: opts.Port}
+	var hops []Hop
+	silent := 0
+	for ttl := 1; ttl <= opts.MaxHops; ttl++ {
+		hop, final := Hop{TTL: ttl}, false
+		for i := 0; i < opts.Attempts && hop.Addr == ""; i++ {
+			if err := ctx.Err(); err != nil {
+				return hops, newError("traceroute", host, err)
+			}
+			hop, final, err = traceHop(ctx, conn, raw, dst, ttl, opts.HopTimeout)
+			if err != nil {
+				return hops, newError("traceroute", host, err)
+			}
+		}
+		hops = append(hops, hop)
+		if final {
+			break
+		}
+		if hop.Addr != "" {
+			silent = 0
+		} else if silent++; silent >= opts.MaxSilent {
+			break
+		}
+	}
+
+	for len(hops) > 0 && hops[len(hops)-1].Addr == "" {
+		hops = hops[:len(hops)-1]
+	}
+	return hops, nil
+}
+
+// traceHop sends one probe with the given TTL and waits for its ICMP
+// answer. final reports that the trace cannot go further (the destination
+// answered, or a router reported it unreachable).
+func traceHop(ctx context.Context, conn *net.UDPConn, raw syscall.RawConn, dst *net.UDPAddr, ttl int, timeout time.Duration) (hop Hop, final bool, err error) {
+	hop = Hop{TTL: ttl}
+
+	if err := setsockoptInt(raw, syscall.IP_TTL, ttl); err != nil {
+		return hop, false, err
+	}
+
+	seq := uint16(icmpSeq.Add(1))
+	payload := make([]byte, 8)
+	binary.BigEndian.PutUint16(payload, seq)
+
+	start := time.Now()
+	// With IP_RECVERR the error of an earlier probe may still be pending on
+	// the socket and surface from the next send; it is already in the error
+	// queue, so send again.
+	for i := 0; i < 2; i++ {
+		if _, err = conn.WriteToUDP(payload, dst); err == nil || !isICMPSendError(err) {
+			break
+		}
+	}
+	if err != nil {
+		return hop, false, err
+	}
+
+	deadline := start.Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetReadDeadline(deadline)
+
+	buf := make([]byte, 512)
+	oob := make([]byte, 512)
+	for {
+		var (
+			matched bool
+			icmp    [2]byte // type, code
+			from    net.IP
+			rerr    error
+		)
+		err := raw.Read(func(fd uintptr) bool {
+			n, oobn, _, _, err := syscall.Recvmsg(int(fd), buf, oob, syscall.MSG_ERRQUEUE)
+			if err == syscall.EAGAIN {
+				// Readable without a queued error: discard the datagram.
+				_, _, _ = syscall.Recvfrom(int(fd), buf, syscall.MSG_DONTWAIT)
+				return false
+			}
+			if err != nil {
+				rerr = err
+				return true
+			}
+			if n < 2 || binary.BigEndian.Uint16(buf) != seq {
+				return true // answer to an earlier, timed-out probe
+			}
+			icmp, from, matched = parseRecvErr(oob[:oobn])
+			return true
+		})
+		switch {
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			if ctx.Err() != nil {
+				return hop, false, ctx.Err()
+			}
+			return hop, false, nil
+		case err != nil:
+			return hop, false, err
+		case rerr != nil:
+			return hop, false, rerr
+		case !matched:
+			continue
+		}
+
+		hop.Addr = from.String()
+		hop.RTT = time.Since(start)
+		switch icmp[0] {
+		case icmpTimeExceeded:
+			return hop, false, nil
+		case icmpDestUnreach:
+			hop.Reached = icmp[1] == icmpPortUnreach && from.Equal(dst.IP)
+			return hop, true, nil
+		}
+		return hop, false, nil
+	}
+}
+
+// parseRecvErr extracts the ICMP type/code and the reporting router from an
+// IP_RECVERR control message.
+func parseRecvErr(oob []byte) (icmp [2]byte, from net.IP, ok bool) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return icmp, nil, false
+	}
+	for _, m := range msgs {
+		if m.Header.Level != syscall.IPPROTO_IP || m.Header.Type != syscall.IP_RECVERR {
+			continue
+		}
+		// struct sock_extended_err followed by the offender's sockaddr_in.
+		if len(m.Data) < sockExtendedErrSz+8 || m.Data[4] != soEEOriginICMP {
+			continue
+		}
+		icmp = [2]byte{m.Data[5], m.Data[6]}
+		from = net.IPv4(m.Data[sockExtendedErrSz+4], m.Data[sockExtendedErrSz+5], m.Data[sockExtendedErrSz+6], m.Data[sockExtendedErrSz+7])
+		return icmp, from, true
+	}
+	return icmp, nil, false
+}
+
+// setsockoptInt sets an IPPROTO_IP option on the socket behind raw.
+func setsockoptInt(raw syscall.RawConn, opt, value int) error {
+	var serr error
+	if err := raw.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, opt, value)
+	}); err != nil {
+		return err
+	}
+	return os.NewSyscallError("setsockopt", serr)
+}
+
+func isICMPSendError(err error) bool {
+	return errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETUNREACH) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package probe
+
+import (
+	"context"
+	"errors"
+)
+
+// Traceroute is not supported on this platform.
+func Traceroute(ctx context.Context, host string, opts TracerouteOptions) ([]Hop, error) {
+	return nil, &Error{Op: "traceroute", Target: host, Class: ClassOther, Err: errors.New("traceroute is not supported on this platform")}
+}
@@ -0,0 +1,160 @@
+package remotewrite
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+	"strconv"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+type label struct {
+	name, value string
+}
+
+// Protobuf wire types and the field numbers of the remote write 1.0
+// messages (prometheus/prompb).
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+
+	fieldWriteRequestTimeseries = 1
+	fieldTimeSeriesLabels       = 1
+	fieldTimeSeriesSamples      = 2
+	fieldLabelName              = 1
+	fieldLabelValue             = 2
+	fieldSampleValue            = 1
+	fieldSampleTimestamp        = 2
+)
+
+// encodeWriteRequest flattens metric families into series the way a
+// Prometheus scrape would (histograms into _bucket/_sum/_count, summaries
+// into quantiles/_sum/_count), all stamped with ts in milliseconds, and
+// encodes them as a WriteRequest. It returns the message and its number of
+// samples.
+func encodeWriteRequest(families []*dto.MetricFamily, external []label, ts int64) ([]byte, int) {
+	var msg, series []byte
+	n := 0
+	emit := func(name string, m *dto.Metric, v float64, extra ...label) {
+		labels := make([]label, 0, 1+len(m.GetLabel())+len(extra)+len(external))
+		labels = append(labels, label{"__name__", name})
+		for _, lp := range m.GetLabel() {
+			labels = append(labels, label{lp.GetName(), lp.GetValue()})
+		}
+		labels = append(labels, extra...)
+		for _, e := range external {
+			if !hasLabel(labels, e.name) {
+				labels = append(labels, e)
+			}
+		}
+		sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+
+		series = series[:0]
+		for _, l := range labels {
+			var lb []byte
+			lb = appendString(lb, fieldLabelName, l.name)
+			lb = appendString(lb, fieldLabelValue, l.value)
+			series = appendBytes(series, fieldTimeSeriesLabels, lb)
+		}
+		var sample []byte
+		sample = appendTag(sample, fieldSampleValue, wireFixed64)
+		sample = binary.LittleEndian.AppendUint64(sample, math.Float64bits(v))
+		sample = appendTag(sample, fieldSampleTimestamp, wireVarint)
+		sample = binary.AppendUvarint(sample, uint64(ts))
+		series = appendBytes(series, fieldTimeSeriesSamples, sample)
+
+		msg = appendBytes(msg, fieldWriteRequestTimeseries, series)
+		n++
+	}
+
+	for _, mf := range families {
+		name := mf.GetName()
+		for _, m := range mf.GetMetric() {
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				emit(name, m, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				emit(name, m, m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				emit(name, m, m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					emit(name, m, q.GetValue(), label{"quantile", formatFloat(q.GetQuantile())})
+				}
+				emit(name+"_sum", m, s.GetSampleSum())
+				emit(name+"_count", m, float64(s.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				infSeen := false
+				for _, b := range h.GetBucket() {
+					infSeen = infSeen || math.IsInf(b.GetUpperBound(), 1)
+					emit(name+"_bucket", m, float64(b.GetCumulativeCount()), label{"le", formatFloat(b.GetUpperBound())})
+				}
+				if !infSeen {
+					emit(name+"_bucket", m, float64(h.GetSampleCount()), label{"le", "+Inf"})
+				}
+				emit(name+"_sum", m, h.GetSampleSum())
+				emit(name+"_count", m, float64(h.GetSampleCount()))
+			}
+		}
+	}
+	return msg, n
+}
+
+func hasLabel(labels []label, name string) bool {
+	for _, l := range labels {
+		if l.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// formatFloat renders le and quantile values as the text exposition does.
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func appendTag(b []byte, field, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wire))
+}
+
+func appendBytes(b []byte, field int, v []byte) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendString(b []byte, field int, s string) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// snappyEncode frames src in the snappy block format using literals only.
+// That forgoes compression, but it is valid snappy that every remote write
+// receiver decodes, and it needs no compression library.
+func snappyEncode(src []byte) []byte {
+	const chunk = 1 << 16
+	dst := binary.AppendUvarint(make([]byte, 0, len(src)+len(src)/chunk*3+16), uint64(len(src)))
+	for len(src) > 0 {
+		n := min(len(src), chunk)
+		switch m := n - 1; {
+		case m < 60:
+			dst = append(dst, byte(m)<<2)
+		case m < 1<<8:
+			dst = append(dst, 60<<2, byte(m))
+		default:
+			dst = append(dst, 61<<2, byte(m), byte(m>>8))
+		}
+		dst = append(dst, src[:n]...)
+		src = src[n:]
+	}
+	return dst
+}
@@ -0,0 +1,215 @@
+// Package remotewrite pushes a process's metrics to a Prometheus remote
+// write endpoint (Prometheus, Mimir, VictoriaMetrics, Grafana Cloud), for
+// edge boxes behind NAT that nothing can scrape. It speaks remote write
+// 1.0 (snappy-compressed protobuf) with the standard library alone.
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"edge-monitor-app/internal/config"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultInterval = 30 * time.Second
+	pushTimeout     = 10 * time.Second
+	// maxErrorBody bounds how much of a rejected push's response is logged.
+	maxErrorBody = 512
+)
+
+var labelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+var (
+	pushFailures = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "remote_write_failures_total",
+			Help: "Remote write pushes that failed or were rejected",
+		},
+	)
+
+	lastSuccess = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "remote_write_last_success_timestamp_seconds",
+			Help: "Unix time of the last accepted remote write push",
+		},
+	)
+
+	pushSamples = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "remote_write_samples",
+			Help: "Samples in the last remote write push",
+		},
+	)
+)
+
+// Writer periodically pushes everything in the default Prometheus registry.
+type Writer struct {
+	url      string
+	interval time.Duration
+	username string
+	password string
+	bearer   string
+	// labels are added to every series that does not carry them already,
+	// like Prometheus external labels; sorted by name.
+	labels   []label
+	gatherer prometheus.Gatherer
+	client   *http.Client
+}
+
+// New reads the REMOTE_WRITE_* environment. It returns nil when
+// REMOTE_WRITE_URL is unset. job becomes the job label of every series,
+// and the host name the instance label, unless REMOTE_WRITE_LABELS sets
+// them.
+func New(job string) (*Writer, error) {
+	raw := config.String("REMOTE_WRITE_URL", "")
+	if raw == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("REMOTE_WRITE_URL %q is not an http(s) URL", raw)
+	}
+
+	w := &Writer{
+		url:      raw,
+		interval: config.Seconds("REMOTE_WRITE_INTERVAL_SECONDS", defaultInterval),
+		username: config.String("REMOTE_WRITE_USERNAME", ""),
+		password: config.Secret("REMOTE_WRITE_PASSWORD"),
+		bearer:   config.Secret("REMOTE_WRITE_BEARER_TOKEN"),
+		gatherer: prometheus.DefaultGatherer,
+		client:   &http.Client{Timeout: pushTimeout},
+	}
+
+	external := map[string]string{"job": job}
+	if host, err := os.Hostname(); err == nil {
+		external["instance"] = host
+	}
+	for _, kv := range strings.Split(config.String("REMOTE_WRITE_LABELS", ""), ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(kv, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !labelName.MatchString(name) || strings.HasPrefix(name, "__") {
+			return nil, fmt.Errorf("REMOTE_WRITE_LABELS: invalid label %q (want name=value)", kv)
+		}
+		external[name] = strings.TrimSpace(value)
+	}
+	for name, value := range external {
+		if value != "" {
+			w.labels = append(w.labels, label{name, value})
+		}
+	}
+	sort.Slice(w.labels, func(i, j int) bool { return w.labels[i].name < w.labels[j].name })
+
+	prometheus.MustRegister(pushFailures, lastSuccess, pushSamples)
+	return w, nil
+}
+
+// Run pushes every interval until ctx is cancelled. A failed push is not
+// retried: counters and gauges are cumulative, so the next push carries the
+// current values and only the resolution in between is lost.
+func (w *Writer) Run(ctx context.Context) error {
+	slog.Info("remote write enabled",
+		"url", redact(w.url),
+		"interval", w.interval.String(),
+		"labels", w.labelAttr(),
+	)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		w.push(ctx)
+	}
+}
+
+// Flush pushes the current values once, for a final push at shutdown.
+func (w *Writer) Flush(ctx context.Context) {
+	w.push(ctx)
+}
+
+func (w *Writer) push(ctx context.Context) {
+	families, err := w.gatherer.Gather()
+	if err != nil {
+		// Gather returns what it could collect along with the error.
+		slog.Warn("remote write: gathering metrics failed", "error", err)
+	}
+	req, samples := encodeWriteRequest(families, w.labels, time.Now().UnixMilli())
+	if samples == 0 {
+		return
+	}
+	if err := w.send(ctx, snappyEncode(req)); err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		pushFailures.Inc()
+		slog.Warn("remote write failed", "url", redact(w.url), "samples", samples, "error", err)
+		return
+	}
+	lastSuccess.SetToCurrentTime()
+	pushSamples.Set(float64(samples))
+}
+
+func (w *Writer) send(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("User-Agent", "edge-monitor-app")
+	switch {
+	case w.bearer != "":
+		req.Header.Set("Authorization", "Bearer "+w.bearer)
+	case w.username != "":
+		req.SetBasicAuth(w.username, w.password)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("endpoint answered %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (w *Writer) labelAttr() []string {
+	out := make([]string, len(w.labels))
+	for i, l := range w.labels {
+		out[i] = l.name + "=" + l.value
+	}
+	return out
+}
+
+// redact drops credentials embedded in the URL before it is logged.
+func redact(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	return u.Redacted()
+}
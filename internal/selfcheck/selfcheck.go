@@ -0,0 +1,185 @@
+// Package selfcheck validates a probe service's own machinery with canary
+// targets whose outcome is known: ones that must fail, such as an
+// unroutable address or a .invalid name, and ones that must succeed. A
+// probe that stops detecting failures (a transparent proxy accepting every
+// connection, a resolver rewriting NXDOMAIN) or reports them for everything
+// is caught by probe_selfcheck_ok{service} dropping to 0.
+//
+// Canaries come from CANARY_TARGETS_JSON:
+//
+//	[
+//	  {"name":"unroutable","target":"192.0.2.1:443","expect":"fail","services":["wifi-probe","jitter-probe","gateway-monitor"]},
+//	  {"name":"nxdomain","target":"canary.invalid","expect":"fail","services":["dns-probe"]},
+//	  {"name":"gateway","target":"192.168.1.1:80","expect":"ok"}
+//	]
+//
+// A canary without services applies to every service that runs canaries;
+// each service probes the target as it probes its own targets.
+package selfcheck
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"edge-monitor-app/internal/config"
+	"edge-monitor-app/internal/metrics"
+	"edge-monitor-app/internal/pause"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Canary outcomes.
+const (
+	ExpectOK   = "ok"
+	ExpectFail = "fail"
+)
+
+var (
+	selfcheckOK = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "probe_selfcheck_ok",
+			Help: "Whether every canary of the service had its expected outcome in the last check (1) or not (0)",
+		},
+		[]string{"service"},
+	)
+
+	canaryAsExpected = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "probe_canary_as_expected",
+			Help: "Whether the canary had its expected outcome (ok or fail) in the last check",
+		},
+		[]string{"service", "canary", "expect"},
+	)
+
+	canaryLastCheck = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "probe_selfcheck_last_run_timestamp_seconds",
+			Help: "Unix time of the service's last canary check",
+		},
+		[]string{"service"},
+	)
+
+	registerOnce sync.Once
+)
+
+// CanaryConfig is one canary as configured.
+type CanaryConfig struct {
+	Name     string   `json:"name,omitempty"`
+	Target   string   `json:"target"`
+	Expect   string   `json:"expect"`
+	Services []string `json:"services,omitempty"`
+}
+
+// Probe checks one canary and returns the error the service's probe saw.
+type Probe func(ctx context.Context) error
+
+// Prepare turns a canary target into a Probe using the service's own probe
+// code, or rejects a target the service cannot parse.
+type Prepare func(target string) (Probe, error)
+
+type canary struct {
+	name   string
+	expect string
+	probe  Probe
+}
+
+// Checker runs a service's canaries every interval.
+type Checker struct {
+	service  string
+	canaries []canary
+	interval time.Duration
+	timeout  time.Duration
+}
+
+// Load reads CANARY_TARGETS_JSON and prepares the canaries that apply to
+// service. It returns nil without error when none do.
+func Load(service string, prepare Prepare) (*Checker, error) {
+	var configs []CanaryConfig
+	if err := json.Unmarshal([]byte(config.String("CANARY_TARGETS_JSON", "[]")), &configs); err != nil {
+		return nil, fmt.Errorf("parse CANARY_TARGETS_JSON: %w", err)
+	}
+	c := &Checker{
+		service:  service,
+		interval: config.Seconds("CANARY_INTERVAL_SECONDS", time.Minute),
+		timeout:  config.Seconds("CANARY_TIMEOUT_SECONDS", 5*time.Second),
+	}
+	for i, cfg := range configs {
+		if cfg.Name == "" {
+			cfg.Name = cfg.Target
+		}
+		if cfg.Target == "" {
+			return nil, fmt.Errorf("canary #%d: missing target", i)
+		}
+		if cfg.Expect != ExpectOK && cfg.Expect != ExpectFail {
+			return nil, fmt.Errorf("canary %s: expect must be %q or %q", cfg.Name, ExpectOK, ExpectFail)
+		}
+		if len(cfg.Services) > 0 && !slices.Contains(cfg.Services, service) {
+			continue
+		}
+		probe, err := prepare(cfg.Target)
+		if err != nil {
+			return nil, fmt.Errorf("canary %s: %w", cfg.Name, err)
+		}
+		c.canaries = append(c.canaries, canary{name: cfg.Name, expect: cfg.Expect, probe: probe})
+	}
+	if len(c.canaries) == 0 {
+		return nil, nil
+	}
+	registerOnce.Do(func() {
+		prometheus.MustRegister(selfcheckOK, canaryAsExpected, canaryLastCheck)
+	})
+	return c, nil
+}
+
+// Run checks the canaries at once and then every interval until ctx is
+// cancelled, skipping checks while the service is paused.
+func (c *Checker) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		if !pause.Active(c.service) {
+			c.check(ctx)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check probes every canary and exports whether each, and the service as a
+// whole, behaved as expected.
+func (c *Checker) check(ctx context.Context) {
+	ok := true
+	for _, cn := range c.canaries {
+		pctx, cancel := context.WithTimeout(ctx, c.timeout)
+		err := cn.probe(pctx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		got := ExpectOK
+		if err != nil {
+			got = ExpectFail
+		}
+		canaryAsExpected.WithLabelValues(c.service, cn.name, cn.expect).Set(metrics.BoolToFloat(got == cn.expect))
+		if got == cn.expect {
+			slog.Debug("canary as expected", "service", c.service, "canary", cn.name, "expect", cn.expect, "error", err)
+			continue
+		}
+		ok = false
+		if err != nil {
+			slog.Warn("probe self-check failed: canary expected to succeed failed", "service", c.service, "canary", cn.name, "error", err)
+		} else {
+			slog.Warn("probe self-check failed: canary expected to fail succeeded; failures may go undetected", "service", c.service, "canary", cn.name)
+		}
+	}
+	selfcheckOK.WithLabelValues(c.service).Set(metrics.BoolToFloat(ok))
+	canaryLastCheck.WithLabelValues(c.service).SetToCurrentTime()
+}
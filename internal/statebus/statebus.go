@@ -0,0 +1,71 @@
+// Package statebus carries probe state changes between services running in
+// one process. Probes publish a Change when a target goes down or comes
+// back; edge-monitor's correlator subscribes to classify outages across
+// probes. A standalone probe has no subscribers and publishing is a no-op.
+package statebus
+
+import (
+	"sync"
+	"time"
+)
+
+// Change is one probe target going down or coming back up.
+type Change struct {
+	Time    time.Time `json:"time"`
+	Service string    `json:"service"`
+	// Probe is the kind of check: tcp, http, tls, script, dns, gateway,
+	// wan or wifi_link.
+	Probe  string `json:"probe"`
+	Target string `json:"target"`
+	// Via is the resolver or interface the probe went through, if any.
+	Via        string `json:"via,omitempty"`
+	Up         bool   `json:"up"`
+	ErrorClass string `json:"error_class,omitempty"`
+	// Maintenance is set for changes inside a maintenance window.
+	Maintenance bool `json:"maintenance,omitempty"`
+	// Capture names the packet capture file the change triggered, if any.
+	Capture string `json:"capture,omitempty"`
+}
+
+// Key identifies the target a change is about.
+func (c Change) Key() string {
+	return c.Service + "|" + c.Probe + "|" + c.Target + "|" + c.Via
+}
+
+var (
+	mu          sync.Mutex
+	subscribers = make(map[chan Change]struct{})
+)
+
+// Publish delivers c to every subscriber without blocking: a subscriber
+// whose buffer is full misses it.
+func Publish(c Change) {
+	if c.Time.IsZero() {
+		c.Time = time.Now()
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for ch := range subscribers {
+		select {
+		case ch <- c:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving every published change and a
+// function that unsubscribes and closes it.
+func Subscribe(buffer int) (<-chan Change, func()) {
+	ch := make(chan Change, buffer)
+	mu.Lock()
+	subscribers[ch] = struct{}{}
+	mu.Unlock()
+	return ch, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if _, ok := subscribers[ch]; ok {
+			delete(subscribers, ch)
+			close(ch)
+		}
+	}
+}
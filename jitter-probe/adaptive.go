@@ -0,0 +1,79 @@
+package jitterprobe
+
+import (
+	"log/slog"
+	"time"
+)
+
+// adaptiveRate tightens the sampling interval while any target shows loss or
+// jitter above threshold, and relaxes back to the base interval once every
+// target has stayed below threshold for the cooldown period.
+type adaptiveRate struct {
+	base              time.Duration
+	fast              time.Duration
+	lossThreshold     float64
+	jitterThresholdMs float64
+	burstThreshold    int
+	cooldown          time.Duration
+
+	active    bool
+	calmSince time.Time
+}
+
+// enabled reports whether adaptive sampling is configured.
+func (a *adaptiveRate) enabled() bool {
+	return a.fast > 0 && a.fast < a.base
+}
+
+// current returns the interval that should be used right now.
+func (a *adaptiveRate) current() time.Duration {
+	if a.active {
+		return a.fast
+	}
+	return a.base
+}
+
+// update evaluates the target states and returns the interval to use for the
+// next sample cycle.
+func (a *adaptiveRate) update(states map[string]*targetState, now time.Time) time.Duration {
+	if !a.enabled() {
+		return a.base
+	}
+
+	degraded := ""
+	for target, st := range states {
+		st.mu.Lock()
+		bad := st.consecutiveFails >= a.burstThreshold ||
+			st.window.LossRatio() >= a.lossThreshold ||
+			st.window.StdDev() >= a.jitterThresholdMs
+		st.mu.Unlock()
+		if bad {
+			degraded = target
+			break
+		}
+	}
+
+	switch {
+	case degraded != "":
+		a.calmSince = time.Time{}
+		if !a.active {
+			a.active = true
+			slog.Warn("adaptive sampling engaged",
+				"target", degraded,
+				"interval_ms", a.fast.Milliseconds(),
+			)
+		}
+	case a.active:
+		if a.calmSince.IsZero() {
+			a.calmSince = now
+		} else if now.Sub(a.calmSince) >= a.cooldown {
+			a.active = false
+			a.calmSince = time.Time{}
+			slog.Info("adaptive sampling relaxed",
+				"interval_ms", a.base.Milliseconds(),
+			)
+		}
+	}
+
+	return a.current()
+}
@@ -0,0 +1,149 @@
+package jitterprobe
+
+import "math"
+
+const (
+	// anomalyMinSamples is the number of successful samples required before
+	// the window baseline is trusted for anomaly detection.
+	anomalyMinSamples = 10
+
+	// anomalyMinSigmaMs floors the robust standard deviation so that very
+	// stable LAN targets do not flag sub-millisecond noise as anomalies.
+	anomalyMinSigmaMs = 1.0
+
+	// cusumMaxZ caps each sample's contribution to the CUSUM sums so a single
+	// outlier cannot register as a regime change on its own.
+	cusumMaxZ = 3.0
+)
+
+// anomalyKind identifies what the detector observed for a sample.
+type anomalyKind string
+
+const (
+	anomalyNone     anomalyKind = ""
+	anomalyOutlier  anomalyKind = "outlier"
+	anomalyStepUp   anomalyKind = "step_up"
+	anomalyStepDown anomalyKind = "step_down"
+)
+
+// anomalyKinds lists the kinds exported as metric label values.
+var anomalyKinds = []anomalyKind{anomalyOutlier, anomalyStepUp, anomalyStepDown}
+
+// anomalyDetector performs online MAD-based outlier detection and two-sided
+// CUSUM step detection against the window's robust baseline.
+//
+// After a step the window median lags behind the new level until the window
+// has refilled, and measuring against it would report the same step again
+// every few samples. So after a step the detector keeps a second, small
+// window of the samples from the step on and takes the median and MAD from
+// it until every sample in the main window is from after the step. The
+// samples that set off the step seed it; since they were picked for being
+// far from the old level, CUSUM only resumes once anomalyMinSamples more
+// have arrived; outliers are flagged throughout.
+type anomalyDetector struct {
+	madThreshold float64
+	cusumK       float64
+	cusumH       float64
+
+	pos float64
+	neg float64
+
+	// posRun and negRun hold the latest samples since each sum last left
+	// zero, the ones a detected step is seeded from.
+	posRun []float64
+	negRun []float64
+
+	// post is the post-step window while shifted is set; since counts the
+	// samples observed after the step.
+	post    *Window
+	since   int
+	shifted bool
+}
+
+// maxStepSamples bounds the samples kept for a post-step baseline.
+const maxStepSamples = 64
+
+// observe evaluates a latency sample against the baseline and returns what
+// it found and the median it compared the sample with. It must be called
+// before the sample is added to the window. A step change takes precedence
+// over an outlier for the same sample.
+func (d *anomalyDetector) observe(latencyMs float64, w *Window) (anomalyKind, float64) {
+	if w.Successes() < anomalyMinSamples {
+		return anomalyNone, 0
+	}
+	base := w
+	settled := true
+	if d.shifted {
+		if d.since >= w.Successes() {
+			d.shifted = false
+		} else {
+			base = d.post
+			settled = d.since >= anomalyMinSamples
+			d.since++
+		}
+	}
+	median, mad := base.MedianMAD()
+	if !settled {
+		// A handful of samples understates the spread; the window's,
+		// still mostly from before the step, bounds it from below.
+		_, wmad := w.MedianMAD()
+		mad = math.Max(mad, wmad)
+	}
+	if d.shifted {
+		d.post.Add(latencyMs)
+	}
+
+	sigma := math.Max(1.4826*mad, anomalyMinSigmaMs)
+	z := (latencyMs - median) / sigma
+
+	if settled {
+		clamped := math.Max(-cusumMaxZ, math.Min(cusumMaxZ, z))
+		d.pos = math.Max(0, d.pos+clamped-d.cusumK)
+		d.neg = math.Max(0, d.neg-clamped-d.cusumK)
+		d.posRun = extendRun(d.posRun, d.pos, latencyMs)
+		d.negRun = extendRun(d.negRun, d.neg, latencyMs)
+	}
+
+	switch {
+	case d.pos > d.cusumH:
+		d.shift(d.posRun)
+		return anomalyStepUp, median
+	case d.neg > d.cusumH:
+		d.shift(d.negRun)
+		return anomalyStepDown, median
+	case math.Abs(z) > d.madThreshold:
+		return anomalyOutlier, median
+	}
+	return anomalyNone, median
+}
+
+// shift starts a post-step window from the samples that led to a detected
+// step and starts the sums over.
+func (d *anomalyDetector) shift(run []float64) {
+	d.post = NewWindow(maxStepSamples, 0)
+	for _, v := range run {
+		d.post.Add(v)
+	}
+	d.since = 0
+	d.shifted = true
+	d.reset()
+}
+
+// extendRun adds latencyMs to run while sum is above zero, keeping the
+// latest maxStepSamples, and empties run once sum is back at zero.
+func extendRun(run []float64, sum, latencyMs float64) []float64 {
+	if sum == 0 {
+		return run[:0]
+	}
+	if len(run) == maxStepSamples {
+		run = append(run[:0], run[1:]...)
+	}
+	return append(run, latencyMs)
+}
+
+func (d *anomalyDetector) reset() {
+	d.pos = 0
+	d.neg = 0
+	d.posRun = d.posRun[:0]
+	d.negRun = d.negRun[:0]
+}
@@ -0,0 +1,84 @@
+package jitterprobe
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// targetStatus is the JSON view of a target's current window statistics.
+type targetStatus struct {
+	Target              string     `json:"target"`
+	Groups              []string   `json:"groups,omitempty"`
+	Samples             int        `json:"samples"`
+	WarmingUp           bool       `json:"warming_up,omitempty"`
+	LatencyP50Ms        float64    `json:"latency_p50_ms"`
+	LatencyP95Ms        float64    `json:"latency_p95_ms"`
+	LatencyP99Ms        float64    `json:"latency_p99_ms"`
+	JitterMs            float64    `json:"jitter_ms"`
+	LossRatio           float64    `json:"loss_ratio"`
+	MOS                 float64    `json:"mos"`
+	Grade               string     `json:"grade,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastErrorAt         *time.Time `json:"last_error_at,omitempty"`
+	LastSuccessAt       *time.Time `json:"last_success_at,omitempty"`
+	Maintenance         bool       `json:"maintenance,omitempty"`
+}
+
+// status returns a consistent snapshot of the target's state.
+func (st *targetState) status(target string) targetStatus {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	jitter := st.window.StdDev()
+	lossRatio := st.window.LossRatio()
+	out := targetStatus{
+		Target:              target,
+		Samples:             st.window.Len(),
+		WarmingUp:           !st.warm,
+		LatencyP50Ms:        st.window.Percentile(50),
+		LatencyP95Ms:        st.window.Percentile(95),
+		LatencyP99Ms:        st.window.Percentile(99),
+		JitterMs:            jitter,
+		LossRatio:           lossRatio,
+		MOS:                 mosFromR(rFactor(st.window.Mean(), jitter, lossRatio)),
+		Grade:               st.grade,
+		ConsecutiveFailures: st.consecutiveFails,
+		LastError:           st.lastError,
+		Maintenance:         st.maintenance,
+	}
+	if !st.lastErrorAt.IsZero() {
+		t := st.lastErrorAt
+		out.LastErrorAt = &t
+	}
+	if !st.lastSuccessAt.IsZero() {
+		t := st.lastSuccessAt
+		out.LastSuccessAt = &t
+	}
+	return out
+}
+
+// targetsHandler serves GET /targets with the current per-target window stats.
+func targetsHandler(registry *targetRegistry, groups []targetGroup) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		targets, states := registry.snapshot()
+		items := make([]targetStatus, 0, len(targets))
+		for _, t := range targets {
+			item := states[t].status(t)
+			item.Groups = groupsOf(groups, t, states[t].probe)
+			items = append(items, item)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"generated_at": time.Now().UTC(),
+			"targets":      items,
+		})
+	}
+}
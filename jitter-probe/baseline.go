@@ -0,0 +1,186 @@
+package jitterprobe
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// baselineMinSamples is the number of successful samples an hour needs
+	// before its mean latency is folded into the baseline, so an hour the
+	// probe was down for, or started late in, does not skew it.
+	baselineMinSamples = 60
+
+	// baselineMinHours is the number of hours a bucket must have learned
+	// (one per day) before the baseline and deviation are exported.
+	baselineMinHours = 3
+
+	// baselineForgetAfter drops the baseline of a target that has not been
+	// probed for this long from the file.
+	baselineForgetAfter = 30 * 24 * time.Hour
+
+	// baselineRetryMin and baselineRetryMax bound the wait before a failed
+	// save is retried; it doubles with every failure in between.
+	baselineRetryMin = time.Minute
+	baselineRetryMax = time.Hour
+)
+
+// baselineBucket is the learned latency of one hour of the day.
+type baselineBucket struct {
+	MeanMs float64 `json:"mean_ms"`
+	Hours  int     `json:"hours"` // hours folded in so far
+}
+
+// targetBaseline is a target's latency by hour of the day, in the
+// process's time zone (TZ).
+type targetBaseline struct {
+	Buckets [24]baselineBucket `json:"buckets"`
+	Updated time.Time          `json:"updated"`
+}
+
+// baselineHour accumulates the current hour's successful samples of a
+// target until the hour ends.
+type baselineHour struct {
+	start time.Time
+	sum   float64
+	count int
+}
+
+// baselineStore learns each target's normal latency for every hour of the
+// day as an EWMA over the hourly means, so a link that is always slower
+// in the evening is compared with its evenings. Only the probe loop
+// touches it.
+type baselineStore struct {
+	path    string
+	alpha   float64
+	targets map[string]*targetBaseline
+	dirty   bool
+
+	// retryAt is when a save may be tried again after retryWait following
+	// a failed one.
+	retryAt   time.Time
+	retryWait time.Duration
+}
+
+// openBaselineStore returns a store persisted to path, loading the
+// baselines already there. An empty path keeps them in memory only, so
+// they are relearned after every restart.
+func openBaselineStore(path string, alpha float64) (*baselineStore, error) {
+	s := &baselineStore{path: path, alpha: alpha, targets: make(map[string]*targetBaseline)}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.targets); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// observe adds a successful sample of the target to its current hour,
+// folding the previous hour into the baseline once the hour has ended.
+func (s *baselineStore) observe(target string, h *baselineHour, now time.Time, latencyMs float64) {
+	now = now.Local()
+	start := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 0, 0, now.Location())
+	if !h.start.Equal(start) {
+		if h.count >= baselineMinSamples {
+			s.fold(target, h.start.Hour(), h.sum/float64(h.count), now)
+		}
+		*h = baselineHour{start: start}
+	}
+	h.sum += latencyMs
+	h.count++
+}
+
+func (s *baselineStore) fold(target string, hour int, meanMs float64, now time.Time) {
+	tb, ok := s.targets[target]
+	if !ok {
+		tb = &targetBaseline{}
+		s.targets[target] = tb
+	}
+	b := &tb.Buckets[hour]
+	if b.Hours == 0 {
+		b.MeanMs = meanMs
+	} else {
+		b.MeanMs += s.alpha * (meanMs - b.MeanMs)
+	}
+	b.Hours++
+	tb.Updated = now
+	s.dirty = true
+}
+
+// expected returns the target's learned latency for the hour of now, and
+// false while that hour has not been learned on enough days.
+func (s *baselineStore) expected(target string, now time.Time) (float64, bool) {
+	tb, ok := s.targets[target]
+	if !ok {
+		return 0, false
+	}
+	b := tb.Buckets[now.Local().Hour()]
+	if b.Hours < baselineMinHours || b.MeanMs <= 0 {
+		return 0, false
+	}
+	return b.MeanMs, true
+}
+
+// save writes the baselines if an hour was folded in since the last
+// successful save. The probe loop calls it every cycle, but the store only
+// changes when an hour ends. After a failure, such as a read-only or full
+// disk, the store stays dirty and save does nothing until the retry wait
+// has passed, starting at baselineRetryMin and doubling up to
+// baselineRetryMax.
+func (s *baselineStore) save(now time.Time) error {
+	if s.path == "" || !s.dirty || now.Before(s.retryAt) {
+		return nil
+	}
+	if err := s.write(now); err != nil {
+		s.retryWait = min(max(2*s.retryWait, baselineRetryMin), baselineRetryMax)
+		s.retryAt = now.Add(s.retryWait)
+		return err
+	}
+	s.dirty = false
+	s.retryAt, s.retryWait = time.Time{}, 0
+	return nil
+}
+
+// write writes the baselines to a temporary file and renames it over the
+// store, dropping targets not probed for baselineForgetAfter.
+func (s *baselineStore) write(now time.Time) error {
+	for target, tb := range s.targets {
+		if now.Sub(tb.Updated) > baselineForgetAfter {
+			delete(s.targets, target)
+		}
+	}
+	data, err := json.Marshal(s.targets)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
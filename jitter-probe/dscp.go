@@ -0,0 +1,71 @@
+package jitterprobe
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"edge-monitor-app/internal/probe"
+)
+
+// dscpClasses maps DSCP per-hop-behaviour names to code points.
+var dscpClasses = map[string]int{
+	"be": 0, "cs0": 0,
+	"cs1": 8, "af11": 10, "af12": 12, "af13": 14,
+	"cs2": 16, "af21": 18, "af22": 20, "af23": 22,
+	"cs3": 24, "af31": 26, "af32": 28, "af33": 30,
+	"cs4": 32, "af41": 34, "af42": 36, "af43": 38,
+	"cs5": 40, "va": 44, "ef": 46,
+	"cs6": 48, "cs7": 56,
+}
+
+// defaultProbePort is used when a target does not specify a port.
+const defaultProbePort = "443"
+
+// probeTarget is a parsed PING_TARGETS entry. An entry may specify a port
+// ("192.168.1.1:53", "[2606:4700::1111]:53"), may be bound to an uplink
+// with an "@iface" or "@source-ip" suffix, and may carry a DSCP class after
+// a slash (e.g. "1.1.1.1/ef") so the same host can be probed with and
+// without marking side by side.
+type probeTarget struct {
+	name string // entry without the binding, used as the target label
+	host string
+	port string
+	dscp int
+	bind probe.Binding
+}
+
+// labels returns the target and interface label values.
+func (t probeTarget) labels() []string {
+	return []string{t.name, t.bind.Label()}
+}
+
+// parseTarget parses "host[:port][@iface][/dscp]" where dscp is a class
+// name or a code point between 0 and 63.
+func parseTarget(raw string) (probeTarget, error) {
+	hostPort, class, marked := strings.Cut(raw, "/")
+	hostPort, bind := probe.ParseBinding(hostPort)
+	t := probeTarget{name: hostPort, host: hostPort, port: defaultProbePort, dscp: -1, bind: bind}
+	if marked {
+		t.name += "/" + class
+	}
+	if host, port, err := net.SplitHostPort(hostPort); err == nil {
+		t.host, t.port = host, port
+	}
+	if !marked {
+		return t, nil
+	}
+
+	class = strings.ToLower(strings.TrimSpace(class))
+	if v, ok := dscpClasses[class]; ok {
+		t.dscp = v
+		return t, nil
+	}
+	n, err := strconv.Atoi(class)
+	if err != nil || n < 0 || n > 63 {
+		return probeTarget{}, fmt.Errorf("target %q: invalid DSCP %q", raw, class)
+	}
+	t.dscp = n
+	return t, nil
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package jitterprobe
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// dscpControl is unsupported on this platform; marked targets fail to dial.
+func dscpControl(dscp int) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		return fmt.Errorf("DSCP marking is not supported on this platform")
+	}
+}
@@ -0,0 +1,36 @@
+//go:build unix
+
+package jitterprobe
+
+import (
+	"net"
+	"syscall"
+)
+
+// dscpControl returns a dialer Control function that sets the DSCP bits on
+// the socket before connecting, so the SYN is marked as well.
+func dscpControl(dscp int) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		tos := dscp << 2
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			if ip := net.ParseIP(hostOnly(address)); ip != nil && ip.To4() == nil {
+				sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+				return
+			}
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+		})
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}
+}
+
+func hostOnly(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return host
+}
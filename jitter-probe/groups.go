@@ -0,0 +1,120 @@
+package jitterprobe
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"path"
+	"sort"
+
+	"edge-monitor-app/internal/config"
+)
+
+// targetGroup is a named set of targets, such as lan, wan or vpn, whose
+// window statistics are also exported as one aggregate.
+type targetGroup struct {
+	name     string
+	patterns []string
+}
+
+// parseGroups reads TARGET_GROUPS_JSON, a map of group name to target
+// patterns:
+//
+//	{"lan":["192.168.1.1"],"wan":["1.1.1.1","8.8.*"],"vpn":["10.8.*@wg0"]}
+//
+// Patterns are path.Match globs on the PING_TARGETS entry or on its host
+// alone, so "1.1.1.1" also covers "1.1.1.1:53@wan0". A target may belong to
+// several groups.
+func parseGroups() ([]targetGroup, error) {
+	var raw map[string][]string
+	if err := json.Unmarshal([]byte(config.String("TARGET_GROUPS_JSON", "{}")), &raw); err != nil {
+		return nil, fmt.Errorf("parse TARGET_GROUPS_JSON: %w", err)
+	}
+	groups := make([]targetGroup, 0, len(raw))
+	for name, patterns := range raw {
+		if name == "" {
+			return nil, fmt.Errorf("TARGET_GROUPS_JSON: empty group name")
+		}
+		if len(patterns) == 0 {
+			return nil, fmt.Errorf("TARGET_GROUPS_JSON: group %s has no targets", name)
+		}
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("TARGET_GROUPS_JSON: group %s: pattern %q: %w", name, p, err)
+			}
+		}
+		groups = append(groups, targetGroup{name: name, patterns: patterns})
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].name < groups[j].name })
+	return groups, nil
+}
+
+// matches reports whether the target entry (or its host) is in the group.
+func (g targetGroup) matches(entry string, t probeTarget) bool {
+	for _, p := range g.patterns {
+		if ok, _ := path.Match(p, entry); ok {
+			return true
+		}
+		if ok, _ := path.Match(p, t.host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// groupStats aggregates the windows of a group's targets.
+type groupStats struct {
+	targets   int
+	latencyMs float64 // worst latest latency
+	jitterMs  float64 // largest window jitter among warmed-up targets
+	lost      int
+	samples   int
+}
+
+// lossRatio is the share of all the group's window samples that were lost,
+// so a busy target weighs as much as its samples.
+func (g groupStats) lossRatio() float64 {
+	if g.samples == 0 {
+		return 0
+	}
+	return float64(g.lost) / float64(g.samples)
+}
+
+// updateGroupMetrics recomputes the aggregate gauges of every group from
+// the current target states.
+func updateGroupMetrics(groups []targetGroup, names []string, states map[string]*targetState) {
+	for _, g := range groups {
+		var agg groupStats
+		for _, name := range names {
+			st := states[name]
+			if !g.matches(name, st.probe) {
+				continue
+			}
+			st.mu.Lock()
+			agg.targets++
+			agg.latencyMs = math.Max(agg.latencyMs, st.latencyMs)
+			if st.warm {
+				agg.jitterMs = math.Max(agg.jitterMs, st.window.StdDev())
+			}
+			n := st.window.Len()
+			agg.samples += n
+			agg.lost += n - st.window.Successes()
+			st.mu.Unlock()
+		}
+		groupTargets.WithLabelValues(g.name).Set(float64(agg.targets))
+		groupLatencyMax.WithLabelValues(g.name).Set(agg.latencyMs)
+		groupJitterMax.WithLabelValues(g.name).Set(agg.jitterMs)
+		groupLossRatio.WithLabelValues(g.name).Set(agg.lossRatio())
+	}
+}
+
+// groupsOf returns the names of the groups the target belongs to.
+func groupsOf(groups []targetGroup, entry string, t probeTarget) []string {
+	var out []string
+	for _, g := range groups {
+		if g.matches(entry, t) {
+			out = append(out, g.name)
+		}
+	}
+	return out
+}
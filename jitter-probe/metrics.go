@@ -1,4 +1,4 @@
-package main
+package jitterprobe
 
 import "github.com/prometheus/client_golang/prometheus"
 
@@ -8,7 +8,7 @@ var (
 			Name: "network_latency_ms",
 			Help: "Latest TCP probe latency in milliseconds",
 		},
-		[]string{"target"},
+		[]string{"target", "interface"},
 	)
 
 	networkJitter = prometheus.NewGaugeVec(
@@ -16,7 +16,7 @@ var (
 			Name: "network_jitter_ms",
 			Help: "Standard deviation of latencies in sliding window (ms)",
 		},
-		[]string{"target"},
+		[]string{"target", "interface"},
 	)
 
 	packetLossTotal = prometheus.NewCounterVec(
@@ -24,15 +24,23 @@ var (
 			Name: "packet_loss_total",
 			Help: "Total number of failed TCP probes",
 		},
-		[]string{"target"},
+		[]string{"target", "interface"},
 	)
 
 	packetLossBurstTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "packet_loss_burst_total",
-			Help: "Total number of packet loss bursts (2+ consecutive failures)",
+			Help: "Total number of packet loss bursts (BURST_THRESHOLD+ consecutive failures, default 2)",
 		},
-		[]string{"target"},
+		[]string{"target", "interface"},
+	)
+
+	packetLossRatio = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "packet_loss_ratio",
+			Help: "Fraction (0-1) of failed TCP probes in sliding window",
+		},
+		[]string{"target", "interface"},
 	)
 
 	latencyP95 = prometheus.NewGaugeVec(
@@ -40,7 +48,7 @@ var (
 			Name: "latency_p95",
 			Help: "95th percentile latency in sliding window (ms)",
 		},
-		[]string{"target"},
+		[]string{"target", "interface"},
 	)
 
 	latencyP99 = prometheus.NewGaugeVec(
@@ -48,7 +56,181 @@ var (
 			Name: "latency_p99",
 			Help: "99th percentile latency in sliding window (ms)",
 		},
-		[]string{"target"},
+		[]string{"target", "interface"},
+	)
+
+	sampleInterval = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "sample_interval_ms",
+			Help: "Current sampling interval in milliseconds (lower while adaptive sampling is engaged)",
+		},
+	)
+
+	latencyAnomalyTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "latency_anomaly_total",
+			Help: "Latency anomalies detected against the sliding window baseline (kind: outlier, step_up, step_down)",
+		},
+		[]string{"target", "interface", "kind"},
+	)
+
+	latencyChangepoint = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "latency_changepoint_timestamp_seconds",
+			Help: "Unix timestamp of the most recent CUSUM-detected latency regime change (0 if none)",
+		},
+		[]string{"target", "interface"},
+	)
+
+	mosScore = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "network_mos_score",
+			Help: "Estimated call-quality Mean Opinion Score (1-4.5) from sliding window latency, jitter and loss",
+		},
+		[]string{"target", "interface"},
+	)
+
+	rFactorScore = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "network_r_factor",
+			Help: "Estimated E-model R-factor (0-100) from sliding window latency, jitter and loss",
+		},
+		[]string{"target", "interface"},
+	)
+
+	consecutiveFailures = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "network_consecutive_failures",
+			Help: "Failed probes in a row since the last success (0 while up)",
+		},
+		[]string{"target", "interface"},
+	)
+
+	sinceSuccess = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "network_seconds_since_success",
+			Help: "Seconds since the last successful probe (or since the target was added, if none succeeded)",
+		},
+		[]string{"target", "interface"},
+	)
+
+	latencyBaseline = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "latency_baseline_ms",
+			Help: "Learned normal latency for the current hour of the day (ms)",
+		},
+		[]string{"target", "interface"},
+	)
+
+	latencyDeviation = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "latency_baseline_deviation_ratio",
+			Help: "Sliding window mean latency relative to the learned baseline for the hour, minus 1 (0.5 is 50% slower than normal)",
+		},
+		[]string{"target", "interface"},
+	)
+
+	groupTargets = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "network_group_targets",
+			Help: "Number of probed targets in the TARGET_GROUPS_JSON group",
+		},
+		[]string{"group"},
+	)
+
+	groupLatencyMax = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "network_group_latency_max_ms",
+			Help: "Worst latest TCP probe latency among the group's targets (ms)",
+		},
+		[]string{"group"},
+	)
+
+	groupJitterMax = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "network_group_jitter_max_ms",
+			Help: "Largest sliding window jitter among the group's targets (ms)",
+		},
+		[]string{"group"},
+	)
+
+	groupLossRatio = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "network_group_packet_loss_ratio",
+			Help: "Fraction (0-1) of lost probes across the sliding windows of the group's targets",
+		},
+		[]string{"group"},
+	)
+
+	dnsResolution = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "network_dns_resolution_ms",
+			Help: "Time to look up the target's host name before the last probe (ms), excluded from network_latency_ms",
+		},
+		[]string{"target", "interface"},
+	)
+
+	dnsResolutionFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "network_dns_resolution_failures_total",
+			Help: "Failed lookups of the target's host name, by error class; each also fails its probe",
+		},
+		[]string{"target", "interface", "error_class"},
+	)
+
+	rtpJitter = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rtp_jitter_ms",
+			Help: "RFC 3550 interarrival jitter of the last RTP stream (ms) per direction (forward, return, round_trip)",
+		},
+		[]string{"target", "interface", "direction"},
+	)
+
+	rtpLossRatio = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rtp_packet_loss_ratio",
+			Help: "Fraction (0-1) of the last RTP stream's packets lost per direction (forward, return, round_trip)",
+		},
+		[]string{"target", "interface", "direction"},
+	)
+
+	rtpReordered = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rtp_packets_reordered",
+			Help: "Packets of the last RTP stream that arrived after a later-sent one, per direction (forward, return, round_trip)",
+		},
+		[]string{"target", "interface", "direction"},
+	)
+
+	rtpRoundTrip = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rtp_round_trip_ms",
+			Help: "Mean round-trip time of the last RTP stream's packets (ms)",
+		},
+		[]string{"target", "interface"},
+	)
+
+	rtpMOS = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rtp_mos_score",
+			Help: "Estimated call-quality Mean Opinion Score (1-4.5) of the last RTP stream",
+		},
+		[]string{"target", "interface"},
+	)
+
+	rtpStreams = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rtp_streams_total",
+			Help: "RTP streams sent (result: ok, no_reply, error)",
+		},
+		[]string{"target", "interface", "result"},
+	)
+
+	rtpReflected = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "rtp_reflector_packets_total",
+			Help: "RTP stream packets answered by the RTP_REFLECTOR_PORT reflector",
+		},
 	)
 )
 
@@ -58,7 +240,85 @@ func registerMetrics() {
 		networkJitter,
 		packetLossTotal,
 		packetLossBurstTotal,
+		packetLossRatio,
 		latencyP95,
 		latencyP99,
+		mosScore,
+		rFactorScore,
+		sampleInterval,
+		latencyAnomalyTotal,
+		latencyChangepoint,
+		latencyBaseline,
+		latencyDeviation,
+		consecutiveFailures,
+		sinceSuccess,
+		groupTargets,
+		groupLatencyMax,
+		groupJitterMax,
+		groupLossRatio,
+		dnsResolution,
+		dnsResolutionFailures,
+	)
+}
+
+// registerRTPMetrics registers the RTP stream metrics, exported with
+// RTP_TARGETS.
+func registerRTPMetrics() {
+	prometheus.MustRegister(
+		rtpJitter,
+		rtpLossRatio,
+		rtpReordered,
+		rtpRoundTrip,
+		rtpMOS,
+		rtpStreams,
 	)
 }
+
+// registerReflectorMetrics registers the RTP reflector's counter, exported
+// with RTP_REFLECTOR_PORT.
+func registerReflectorMetrics() {
+	prometheus.MustRegister(rtpReflected)
+}
+
+// initTargetMetrics pre-initializes per-target series so zero-value counters
+// appear in Prometheus before the first loss or burst event. The window
+// statistics (jitter, percentiles, MOS, R-factor) are left absent until
+// the target has warmed up, so alert rules do not fire on a handful of
+// samples and *_over_time queries are not skewed by placeholder zeros.
+func initTargetMetrics(t probeTarget) {
+	l := t.labels()
+	networkLatency.WithLabelValues(l...).Set(0)
+	packetLossTotal.WithLabelValues(l...).Add(0)
+	packetLossBurstTotal.WithLabelValues(l...).Add(0)
+	packetLossRatio.WithLabelValues(l...).Set(0)
+	consecutiveFailures.WithLabelValues(l...).Set(0)
+	sinceSuccess.WithLabelValues(l...).Set(0)
+	latencyChangepoint.WithLabelValues(l...).Set(0)
+	for _, kind := range anomalyKinds {
+		latencyAnomalyTotal.WithLabelValues(append(l, string(kind))...).Add(0)
+	}
+}
+
+// deleteTargetMetrics removes the series of a target that is no longer probed.
+func deleteTargetMetrics(t probeTarget) {
+	l := t.labels()
+	networkLatency.DeleteLabelValues(l...)
+	networkJitter.DeleteLabelValues(l...)
+	packetLossTotal.DeleteLabelValues(l...)
+	packetLossBurstTotal.DeleteLabelValues(l...)
+	latencyP95.DeleteLabelValues(l...)
+	latencyP99.DeleteLabelValues(l...)
+	packetLossRatio.DeleteLabelValues(l...)
+	mosScore.DeleteLabelValues(l...)
+	rFactorScore.DeleteLabelValues(l...)
+	latencyChangepoint.DeleteLabelValues(l...)
+	latencyBaseline.DeleteLabelValues(l...)
+	consecutiveFailures.DeleteLabelValues(l...)
+	sinceSuccess.DeleteLabelValues(l...)
+	latencyDeviation.DeleteLabelValues(l...)
+	dnsResolution.DeleteLabelValues(l...)
+	dnsResolutionFailures.DeletePartialMatch(prometheus.Labels{"target": l[0], "interface": l[1]})
+	for _, kind := range anomalyKinds {
+		latencyAnomalyTotal.DeleteLabelValues(append(l, string(kind))...)
+	}
+}
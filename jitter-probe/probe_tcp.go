@@ -1,20 +1,60 @@
-package main
+package jitterprobe
 
 import (
-	"fmt"
+	"context"
 	"net"
 	"time"
+
+	"edge-monitor-app/internal/probe"
+	"edge-monitor-app/internal/selfcheck"
 )
 
-func tcpProbe(host string, timeout time.Duration) (bool, time.Duration, error) {
-	addr := fmt.Sprintf("%s:%d", host, 443)
-	start := time.Now()
-	conn, err := net.DialTimeout("tcp", addr, timeout)
-	latency := time.Since(start)
+// tcpProbe runs a shared TCP probe against the target, applying its DSCP
+// marking and uplink binding if configured. host is the target's host, or
+// the address it was resolved to.
+func tcpProbe(ctx context.Context, target probeTarget, host string, timeout time.Duration) (time.Duration, error) {
+	dialer := &net.Dialer{}
+	if target.dscp >= 0 {
+		dialer.Control = dscpControl(target.dscp)
+	}
+	if err := target.bind.Apply(dialer); err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return probe.TCP(ctx, dialer, net.JoinHostPort(host, target.port))
+}
+
+// resolve looks up the target's host name before a probe, under
+// PROBE_RESOLVE_TIMEOUT_SECONDS rather than the probe timeout, so a slow
+// resolver neither inflates the latency samples nor turns into loss. A
+// failed lookup fails the probe, as it did when the dial resolved the name.
+func (s *Service) resolve(ctx context.Context, target probeTarget) (string, error) {
+	if !probe.IsName(target.host) {
+		return target.host, nil
+	}
+	host, took, err := probe.ResolveFrom(ctx, nil, target.host, s.resolveTimeout, target.bind)
+	if err != nil {
+		dnsResolutionFailures.WithLabelValues(append(target.labels(), string(probe.Classify(err)))...).Inc()
+		return "", err
+	}
+	dnsResolution.WithLabelValues(target.labels()...).Set(float64(took.Nanoseconds()) / 1e6)
+	return host, nil
+}
 
+// canaryProbe probes a CANARY_TARGETS_JSON target like a PING_TARGETS
+// entry, without sampling it into a window.
+func (s *Service) canaryProbe(raw string) (selfcheck.Probe, error) {
+	target, err := parseTarget(raw)
 	if err != nil {
-		return false, 0, fmt.Errorf("tcp dial %s: %w", addr, err)
+		return nil, err
 	}
-	conn.Close()
-	return true, latency, nil
+	return func(ctx context.Context) error {
+		host, _, err := probe.ResolveFrom(ctx, nil, target.host, s.resolveTimeout, target.bind)
+		if err == nil {
+			_, err = tcpProbe(ctx, target, host, s.timeout)
+		}
+		return err
+	}, nil
 }
@@ -0,0 +1,54 @@
+package jitterprobe
+
+// Call-quality estimation using the simplified ITU-T G.107 E-model that is
+// commonly applied to VoIP monitoring. It is an estimate for a G.711-like
+// codec, not a replacement for a real media-path measurement.
+
+// rFactor estimates the E-model transmission rating (0-100) from mean
+// latency, jitter (both in ms) and loss ratio (0-1).
+func rFactor(latencyMs, jitterMs, lossRatio float64) float64 {
+	// Jitter is weighted double because de-jitter buffers add delay, and a
+	// fixed 10ms accounts for codec processing.
+	effective := latencyMs + 2*jitterMs + 10
+
+	r := 93.2 - effective/40
+	if effective >= 160 {
+		r = 93.2 - (effective-120)/10
+	}
+	r -= 2.5 * lossRatio * 100
+
+	if r < 0 {
+		return 0
+	}
+	if r > 100 {
+		return 100
+	}
+	return r
+}
+
+// mosFromR converts an R-factor to an estimated Mean Opinion Score (1-4.5).
+func mosFromR(r float64) float64 {
+	if r <= 0 {
+		return 1
+	}
+	if r >= 100 {
+		return 4.5
+	}
+	return 1 + 0.035*r + 0.000007*r*(r-60)*(100-r)
+}
+
+// qualityGrade maps a MOS value to a coarse grade for logging.
+func qualityGrade(mos float64) string {
+	switch {
+	case mos >= 4.3:
+		return "excellent"
+	case mos >= 4.0:
+		return "good"
+	case mos >= 3.6:
+		return "fair"
+	case mos >= 3.1:
+		return "poor"
+	default:
+		return "bad"
+	}
+}
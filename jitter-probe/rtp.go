@@ -0,0 +1,449 @@
+package jitterprobe
+
+// RTP streams measure what a call sees rather than what a TCP handshake
+// sees: every RTP_INTERVAL_SECONDS, a burst of RTP packets at a voice
+// codec's pace (G.711, one packet per 20ms) goes to a UDP endpoint that
+// sends them back. A plain UDP echo server yields round-trip jitter, loss
+// and reordering; another jitter-probe's RTP_REFLECTOR_PORT stamps every
+// packet with its arrival time and order, which splits them into the
+// forward and return directions. One-way jitter needs no synchronized
+// clocks: RFC 3550 jitter compares transit times with each other, so a
+// constant clock offset cancels out.
+
+import (
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"edge-monitor-app/internal/config"
+	"edge-monitor-app/internal/pause"
+	"edge-monitor-app/internal/probe"
+)
+
+const (
+	// defaultRTPPort is used when an RTP_TARGETS entry has no port.
+	defaultRTPPort = "5004"
+
+	rtpHeaderLen = 12
+	// rtpProbeLen is the probe data at the start of the payload: magic,
+	// flags, send time, reflector arrival time and reflector arrival index.
+	rtpProbeLen    = 28
+	rtpVersion     = 2
+	rtpPayloadPCMU = 0
+	rtpClockRate   = 8000
+
+	rtpFlagReflected = 1
+
+	// rtpReplyWait is how long replies are awaited after the last packet.
+	rtpReplyWait = time.Second
+)
+
+// RTP stream directions, the direction label values.
+const (
+	directionForward   = "forward"
+	directionReturn    = "return"
+	directionRoundTrip = "round_trip"
+)
+
+var rtpMagic = []byte("EMRT")
+
+// rtpPacket is the probe data of a stream packet.
+type rtpPacket struct {
+	seq  uint16
+	ssrc uint32
+	sent int64 // sender clock, Unix ns
+	// Set by an RTP_REFLECTOR_PORT reflector: its clock at arrival, Unix ns,
+	// and the packet's 1-based arrival order within the stream.
+	reflected      bool
+	reflectedAt    int64
+	reflectorIndex uint32
+}
+
+// marshalRTP writes a stream packet into buf, which holds the RTP header
+// and the payload.
+func marshalRTP(buf []byte, seq uint16, timestamp, ssrc uint32, sent time.Time) {
+	clear(buf)
+	buf[0] = rtpVersion << 6
+	buf[1] = rtpPayloadPCMU
+	binary.BigEndian.PutUint16(buf[2:], seq)
+	binary.BigEndian.PutUint32(buf[4:], timestamp)
+	binary.BigEndian.PutUint32(buf[8:], ssrc)
+	copy(buf[12:], rtpMagic)
+	binary.BigEndian.PutUint64(buf[20:], uint64(sent.UnixNano()))
+}
+
+// parseRTP reads the probe data of a stream packet; ok is false for any
+// other datagram.
+func parseRTP(b []byte) (p rtpPacket, ok bool) {
+	if len(b) < rtpHeaderLen+rtpProbeLen || b[0]>>6 != rtpVersion || string(b[12:16]) != string(rtpMagic) {
+		return rtpPacket{}, false
+	}
+	return rtpPacket{
+		seq:            binary.BigEndian.Uint16(b[2:]),
+		ssrc:           binary.BigEndian.Uint32(b[8:]),
+		sent:           int64(binary.BigEndian.Uint64(b[20:])),
+		reflected:      b[16]&rtpFlagReflected != 0,
+		reflectedAt:    int64(binary.BigEndian.Uint64(b[28:])),
+		reflectorIndex: binary.BigEndian.Uint32(b[36:]),
+	}, true
+}
+
+// stampRTP marks a stream packet as reflected at t, the index-th packet of
+// its stream to arrive.
+func stampRTP(b []byte, t time.Time, index uint32) {
+	b[16] |= rtpFlagReflected
+	binary.BigEndian.PutUint64(b[28:], uint64(t.UnixNano()))
+	binary.BigEndian.PutUint32(b[36:], index)
+}
+
+// parseRTPTarget parses an RTP_TARGETS entry like a PING_TARGETS one,
+// "host[:port][@iface][/dscp]", with the RTP port as default.
+func parseRTPTarget(raw string) (probeTarget, error) {
+	t, err := parseTarget(raw)
+	if err != nil {
+		return probeTarget{}, err
+	}
+	hostPort, _, _ := strings.Cut(raw, "/")
+	hostPort, _ = probe.ParseBinding(hostPort)
+	if _, _, err := net.SplitHostPort(hostPort); err != nil {
+		t.port = defaultRTPPort
+	}
+	return t, nil
+}
+
+// rtpReply is a stream packet that came back, with the local arrival time.
+type rtpReply struct {
+	rtpPacket
+	at int64 // Unix ns
+}
+
+// rtpDirection is what one direction of a stream saw.
+type rtpDirection struct {
+	jitterMs  float64
+	lossRatio float64
+	reordered int
+}
+
+// rtpStats summarizes a stream. forward and reverse are set only when a
+// reflector stamped the replies.
+type rtpStats struct {
+	sent        int
+	received    int
+	roundTripMs float64
+	roundTrip   rtpDirection
+	forward     *rtpDirection
+	reverse     *rtpDirection
+}
+
+// mos estimates the call quality of the stream, taking half the round trip
+// as the one-way delay and the worse direction's jitter and loss.
+func (st rtpStats) mos() float64 {
+	jitter, loss := st.roundTrip.jitterMs, st.roundTrip.lossRatio
+	if st.forward != nil {
+		jitter = max(st.forward.jitterMs, st.reverse.jitterMs)
+		loss = max(st.forward.lossRatio, st.reverse.lossRatio)
+	}
+	return mosFromR(rFactor(st.roundTripMs/2, jitter, loss))
+}
+
+// computeRTPStats summarizes the replies, in local arrival order, to a
+// stream of sent packets. Duplicates count once.
+func computeRTPStats(sent int, replies []rtpReply) rtpStats {
+	seen := make(map[uint16]bool, len(replies))
+	unique := make([]rtpReply, 0, len(replies))
+	reflected := len(replies) > 0
+	for _, r := range replies {
+		if seen[r.seq] {
+			continue
+		}
+		seen[r.seq] = true
+		unique = append(unique, r)
+		reflected = reflected && r.reflected
+	}
+
+	st := rtpStats{sent: sent, received: len(unique)}
+	if sent > 0 {
+		st.roundTrip.lossRatio = float64(sent-len(unique)) / float64(sent)
+	}
+	if len(unique) == 0 {
+		return st
+	}
+
+	transits := make([]int64, len(unique))
+	var total int64
+	for i, r := range unique {
+		transits[i] = r.at - r.sent
+		total += transits[i]
+	}
+	st.roundTripMs = float64(total) / float64(len(unique)) / 1e6
+	st.roundTrip.jitterMs = rfc3550Jitter(transits)
+	st.roundTrip.reordered = reordered(unique, func(r rtpReply) uint32 { return uint32(r.seq) })
+	if !reflected {
+		return st
+	}
+
+	// The forward direction in the order the reflector saw the packets.
+	byArrival := slices.Clone(unique)
+	slices.SortFunc(byArrival, func(a, b rtpReply) int { return cmp.Compare(a.reflectorIndex, b.reflectorIndex) })
+	arrived := byArrival[len(byArrival)-1].reflectorIndex
+	forward := &rtpDirection{reordered: reordered(byArrival, func(r rtpReply) uint32 { return uint32(r.seq) })}
+	for i, r := range byArrival {
+		transits[i] = r.reflectedAt - r.sent
+	}
+	forward.jitterMs = rfc3550Jitter(transits)
+	if lost := sent - int(arrived); lost > 0 {
+		forward.lossRatio = float64(lost) / float64(sent)
+	}
+
+	reverse := &rtpDirection{reordered: reordered(unique, func(r rtpReply) uint32 { return r.reflectorIndex })}
+	for i, r := range unique {
+		transits[i] = r.at - r.reflectedAt
+	}
+	reverse.jitterMs = rfc3550Jitter(transits)
+	if lost := int(arrived) - len(unique); lost > 0 {
+		reverse.lossRatio = float64(lost) / float64(arrived)
+	}
+	st.forward, st.reverse = forward, reverse
+	return st
+}
+
+// rfc3550Jitter returns the interarrival jitter (ms) of transit times (ns)
+// in arrival order, smoothed as RFC 3550 section 6.4.1 does:
+// J += (|D| - J) / 16.
+func rfc3550Jitter(transits []int64) float64 {
+	var j float64
+	for i := 1; i < len(transits); i++ {
+		d := float64(transits[i] - transits[i-1])
+		if d < 0 {
+			d = -d
+		}
+		j += (d - j) / 16
+	}
+	return j / 1e6
+}
+
+// reordered counts the replies whose order key is below one that arrived
+// before them.
+func reordered(replies []rtpReply, key func(rtpReply) uint32) int {
+	var n int
+	var highest uint32
+	for i, r := range replies {
+		k := key(r)
+		if i > 0 && k < highest {
+			n++
+			continue
+		}
+		highest = k
+	}
+	return n
+}
+
+// rtpProber sends the RTP_TARGETS streams.
+type rtpProber struct {
+	targets        []probeTarget
+	interval       time.Duration
+	packets        int
+	packetInterval time.Duration
+	payloadBytes   int
+}
+
+// loadRTPProber reads the RTP stream settings. It returns nil without error
+// when RTP_TARGETS is unset.
+func loadRTPProber() (*rtpProber, error) {
+	raw := config.List("RTP_TARGETS")
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	p := &rtpProber{
+		interval:       config.Seconds("RTP_INTERVAL_SECONDS", time.Minute),
+		packetInterval: time.Duration(config.Int("RTP_PACKET_INTERVAL_MS", 20)) * time.Millisecond,
+		payloadBytes:   config.Int("RTP_PAYLOAD_BYTES", 160),
+	}
+	streamLen := config.Seconds("RTP_STREAM_SECONDS", 5*time.Second)
+	if p.packetInterval <= 0 {
+		return nil, fmt.Errorf("RTP_PACKET_INTERVAL_MS must be at least 1, got %d", p.packetInterval.Milliseconds())
+	}
+	if p.packets = int(streamLen / p.packetInterval); p.packets < 2 || p.packets > 65535 {
+		return nil, fmt.Errorf("RTP_STREAM_SECONDS must hold between 2 and 65535 packets, got %d", p.packets)
+	}
+	if p.payloadBytes < rtpProbeLen || p.payloadBytes > 1400 {
+		return nil, fmt.Errorf("RTP_PAYLOAD_BYTES must be between %d and 1400, got %d", rtpProbeLen, p.payloadBytes)
+	}
+	for _, entry := range raw {
+		t, err := parseRTPTarget(entry)
+		if err != nil {
+			return nil, fmt.Errorf("RTP_TARGETS: %w", err)
+		}
+		p.targets = append(p.targets, t)
+	}
+	registerRTPMetrics()
+	return p, nil
+}
+
+// names returns the target names, for logging.
+func (p *rtpProber) names() []string {
+	names := make([]string, len(p.targets))
+	for i, t := range p.targets {
+		names[i] = t.name
+	}
+	return names
+}
+
+// run streams to every target at once, then every interval, until ctx is
+// cancelled. No streams are sent while jitter-probe is paused.
+func (p *rtpProber) run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		if !pause.Active("jitter-probe") {
+			var wg sync.WaitGroup
+			for _, t := range p.targets {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					p.probe(ctx, t)
+				}()
+			}
+			wg.Wait()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe sends one stream to t and exports what it saw.
+func (p *rtpProber) probe(ctx context.Context, t probeTarget) {
+	st, err := p.stream(ctx, t)
+	if ctx.Err() != nil {
+		return
+	}
+	l := t.labels()
+	result := "ok"
+	switch {
+	case err != nil:
+		result = "error"
+		slog.Warn("rtp stream failed", "target", t.name, "error", err, "error_class", probe.Classify(err))
+	case st.received == 0:
+		result = "no_reply"
+		slog.Warn("rtp stream got no replies", "target", t.name, "sent", st.sent)
+	}
+	rtpStreams.WithLabelValues(append(l, result)...).Inc()
+
+	for _, dir := range []string{directionForward, directionReturn} {
+		rtpJitter.DeleteLabelValues(append(l, dir)...)
+		rtpLossRatio.DeleteLabelValues(append(l, dir)...)
+		rtpReordered.DeleteLabelValues(append(l, dir)...)
+	}
+	if result != "ok" {
+		rtpLossRatio.WithLabelValues(append(l, directionRoundTrip)...).Set(1)
+		rtpJitter.DeleteLabelValues(append(l, directionRoundTrip)...)
+		rtpReordered.DeleteLabelValues(append(l, directionRoundTrip)...)
+		rtpRoundTrip.DeleteLabelValues(l...)
+		rtpMOS.DeleteLabelValues(l...)
+		return
+	}
+
+	export := func(dir string, d rtpDirection) {
+		rtpJitter.WithLabelValues(append(l, dir)...).Set(d.jitterMs)
+		rtpLossRatio.WithLabelValues(append(l, dir)...).Set(d.lossRatio)
+		rtpReordered.WithLabelValues(append(l, dir)...).Set(float64(d.reordered))
+	}
+	export(directionRoundTrip, st.roundTrip)
+	args := []any{"target", t.name, "sent", st.sent, "received", st.received,
+		"round_trip_ms", st.roundTripMs, "jitter_ms", st.roundTrip.jitterMs}
+	if st.forward != nil {
+		export(directionForward, *st.forward)
+		export(directionReturn, *st.reverse)
+		args = append(args, "forward_jitter_ms", st.forward.jitterMs, "return_jitter_ms", st.reverse.jitterMs,
+			"forward_loss_ratio", st.forward.lossRatio, "return_loss_ratio", st.reverse.lossRatio)
+	}
+	rtpRoundTrip.WithLabelValues(l...).Set(st.roundTripMs)
+	rtpMOS.WithLabelValues(l...).Set(st.mos())
+	slog.Debug("rtp stream", args...)
+}
+
+// stream sends p.packets packets to t at the packet interval and collects
+// the replies until rtpReplyWait after the last one.
+func (p *rtpProber) stream(ctx context.Context, t probeTarget) (rtpStats, error) {
+	dialer := &net.Dialer{}
+	if t.dscp >= 0 {
+		dialer.Control = dscpControl(t.dscp)
+	}
+	if err := t.bind.Apply(dialer); err != nil {
+		return rtpStats{}, err
+	}
+	conn, err := dialer.DialContext(ctx, "udp", net.JoinHostPort(t.host, t.port))
+	if err != nil {
+		return rtpStats{}, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	ssrc := rand.Uint32()
+	repliesCh := make(chan []rtpReply, 1)
+	go func() {
+		var replies []rtpReply
+		buf := make([]byte, 2048)
+		for {
+			n, err := conn.Read(buf)
+			if errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, net.ErrClosed) {
+				break
+			}
+			if err != nil {
+				// An ICMP error for an earlier packet, such as port
+				// unreachable; later packets may still get through.
+				continue
+			}
+			if pkt, ok := parseRTP(buf[:n]); ok && pkt.ssrc == ssrc {
+				replies = append(replies, rtpReply{rtpPacket: pkt, at: time.Now().UnixNano()})
+			}
+		}
+		repliesCh <- replies
+	}()
+
+	buf := make([]byte, rtpHeaderLen+p.payloadBytes)
+	samples := uint32(p.packetInterval * rtpClockRate / time.Second)
+	ticker := time.NewTicker(p.packetInterval)
+	defer ticker.Stop()
+	var sent int
+	var writeErr error
+	for i := 0; i < p.packets && ctx.Err() == nil; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+			case <-ticker.C:
+			}
+		}
+		marshalRTP(buf, uint16(i), uint32(i)*samples, ssrc, time.Now())
+		if _, err := conn.Write(buf); err != nil {
+			writeErr = err
+			continue
+		}
+		sent++
+	}
+	deadline := time.Now().Add(rtpReplyWait)
+	if ctx.Err() != nil {
+		deadline = time.Now()
+	}
+	conn.SetReadDeadline(deadline)
+	replies := <-repliesCh
+	if sent == 0 {
+		return rtpStats{}, writeErr
+	}
+	// Packets that could not be written were lost too.
+	return computeRTPStats(p.packets, replies), nil
+}
@@ -0,0 +1,97 @@
+package jitterprobe
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"time"
+)
+
+const (
+	// reflectorStreamTTL is how long a stream's arrival count is kept after
+	// its last packet.
+	reflectorStreamTTL = time.Minute
+	// reflectorMaxStreams bounds the streams counted at once.
+	reflectorMaxStreams = 4096
+)
+
+// reflectorStream identifies a stream by its sender and SSRC.
+type reflectorStream struct {
+	addr string
+	ssrc uint32
+}
+
+type reflectorCount struct {
+	arrived uint32
+	last    time.Time
+}
+
+// rtpReflector answers RTP_TARGETS streams on RTP_REFLECTOR_PORT, stamping
+// each packet with its arrival time and its arrival order within the
+// stream so the sender can tell the directions apart. Only stream packets
+// are answered, at their own size, so the reflector cannot amplify
+// traffic, and packets already reflected are dropped so two reflectors
+// cannot bounce one between them.
+type rtpReflector struct {
+	streams map[reflectorStream]*reflectorCount
+}
+
+// run answers packets on conn until ctx is cancelled.
+func (r *rtpReflector) run(ctx context.Context, conn net.PacketConn) {
+	r.streams = make(map[reflectorStream]*reflectorCount)
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	defer conn.Close()
+
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			slog.Debug("rtp reflector read failed", "error", err)
+			continue
+		}
+		pkt, ok := parseRTP(buf[:n])
+		if !ok || pkt.reflected {
+			continue
+		}
+		now := time.Now()
+		stampRTP(buf[:n], now, r.arrived(reflectorStream{addr: addr.String(), ssrc: pkt.ssrc}, now))
+		if _, err := conn.WriteTo(buf[:n], addr); err != nil {
+			slog.Debug("rtp reflector write failed", "peer", addr.String(), "error", err)
+			continue
+		}
+		rtpReflected.Inc()
+	}
+}
+
+// arrived counts a packet of stream and returns its arrival order.
+func (r *rtpReflector) arrived(stream reflectorStream, now time.Time) uint32 {
+	c, ok := r.streams[stream]
+	if !ok {
+		if len(r.streams) >= reflectorMaxStreams {
+			r.prune(now)
+		}
+		c = &reflectorCount{}
+		r.streams[stream] = c
+	}
+	c.arrived++
+	c.last = now
+	return c.arrived
+}
+
+// prune forgets streams idle for reflectorStreamTTL, or every stream when
+// that leaves too many.
+func (r *rtpReflector) prune(now time.Time) {
+	for k, c := range r.streams {
+		if now.Sub(c.last) > reflectorStreamTTL {
+			delete(r.streams, k)
+		}
+	}
+	if len(r.streams) >= reflectorMaxStreams {
+		clear(r.streams)
+	}
+}
@@ -0,0 +1,550 @@
+// Package jitterprobe implements the jitter-probe high-frequency latency and
+// jitter sampler. It runs standalone via cmd/jitter-probe or inside the
+// combined edge-monitor binary.
+package jitterprobe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"edge-monitor-app/internal/buildinfo"
+	"edge-monitor-app/internal/capture"
+	"edge-monitor-app/internal/config"
+	"edge-monitor-app/internal/discovery"
+	"edge-monitor-app/internal/health"
+	"edge-monitor-app/internal/inventory"
+	"edge-monitor-app/internal/maintenance"
+	"edge-monitor-app/internal/pause"
+	"edge-monitor-app/internal/probe"
+	"edge-monitor-app/internal/selfcheck"
+	"edge-monitor-app/internal/statebus"
+)
+
+// DefaultAddr is the standalone metrics listen address.
+const DefaultAddr = ":9092"
+
+// maxWindowSamples bounds the ring buffer derived from WINDOW_DURATION.
+const maxWindowSamples = 6000
+
+// durationWindowSize derives a ring buffer capacity large enough to hold
+// WINDOW_DURATION worth of samples at the fastest configured interval.
+func durationWindowSize(d time.Duration, sampleIntervalMs, adaptiveIntervalMs int) int {
+	fastest := sampleIntervalMs
+	if adaptiveIntervalMs > 0 && adaptiveIntervalMs < fastest {
+		fastest = adaptiveIntervalMs
+	}
+	if fastest <= 0 {
+		return maxWindowSamples
+	}
+	n := int(d/time.Millisecond)/fastest + 1
+	if n > maxWindowSamples {
+		return maxWindowSamples
+	}
+	return n
+}
+
+// targetState tracks per-target probe state for burst detection. The probe
+// loop owns all writes; mu guards reads from the HTTP API.
+type targetState struct {
+	mu               sync.Mutex
+	probe            probeTarget
+	window           *Window
+	detector         *anomalyDetector
+	consecutiveFails int
+	grade            string
+	lastError        string
+	lastErrorAt      time.Time
+	lastSuccessAt    time.Time
+	addedAt          time.Time
+	maintenance      bool    // target in a maintenance window at the last sample
+	latencyMs        float64 // latest successful probe latency
+	// hour accumulates the samples of the current hour for the baseline.
+	hour baselineHour
+	// warm is set once the window holds WARMUP_SAMPLES successful samples;
+	// until then the window statistics are not exported.
+	warm bool
+}
+
+// updateQuality recomputes the estimated call quality for a target from its
+// current window and logs grade transitions.
+func updateQuality(target string, st *targetState) {
+	if !st.warm {
+		return
+	}
+	r := rFactor(st.window.Mean(), st.window.StdDev(), st.window.LossRatio())
+	mos := mosFromR(r)
+
+	l := st.probe.labels()
+	rFactorScore.WithLabelValues(l...).Set(r)
+	mosScore.WithLabelValues(l...).Set(mos)
+
+	grade := qualityGrade(mos)
+	if grade != st.grade {
+		if st.grade != "" {
+			slog.Info("link quality grade changed",
+				"target", target,
+				"from", st.grade,
+				"to", grade,
+				"mos", mos,
+			)
+		}
+		st.grade = grade
+	}
+}
+
+// updateDeviation exports how the target's window compares with its
+// learned latency for the hour. Both gauges are absent until the hour has
+// been learned and the target has warmed up.
+func (s *Service) updateDeviation(target string, st *targetState) {
+	l := st.probe.labels()
+	expected, ok := s.baseline.expected(target, time.Now())
+	if !ok || !st.warm {
+		latencyBaseline.DeleteLabelValues(l...)
+		latencyDeviation.DeleteLabelValues(l...)
+		return
+	}
+	latencyBaseline.WithLabelValues(l...).Set(expected)
+	if st.window.Successes() == 0 {
+		// Nothing to compare during an outage; loss covers it.
+		latencyDeviation.DeleteLabelValues(l...)
+		return
+	}
+	latencyDeviation.WithLabelValues(l...).Set(st.window.Mean()/expected - 1)
+}
+
+// recordAnomaly runs the target's anomaly detector on a new sample and
+// exports any outlier or latency regime change.
+func recordAnomaly(target string, latencyMs float64, st *targetState) {
+	kind, median := st.detector.observe(latencyMs, st.window)
+	if kind == anomalyNone {
+		return
+	}
+
+	l := st.probe.labels()
+	latencyAnomalyTotal.WithLabelValues(append(l, string(kind))...).Inc()
+
+	if kind == anomalyOutlier {
+		slog.Debug("latency outlier",
+			"target", target,
+			"latency_ms", latencyMs,
+			"median_ms", median,
+		)
+		return
+	}
+
+	latencyChangepoint.WithLabelValues(l...).Set(float64(time.Now().Unix()))
+	slog.Warn("latency regime changed",
+		"target", target,
+		"direction", string(kind),
+		"latency_ms", latencyMs,
+		"previous_median_ms", median,
+	)
+}
+
+// Service is a configured jitter-probe instance.
+type Service struct {
+	targets          []string
+	discover         bool
+	discoveryRefresh time.Duration
+	anycast          []string
+	burstThreshold   int
+	warmupSamples    int
+	timeout          time.Duration
+	resolveTimeout   time.Duration
+	interval         time.Duration
+
+	rate        *adaptiveRate
+	registry    *targetRegistry
+	maintenance *maintenance.Schedule
+	capture     *capture.Capturer
+	selfcheck   *selfcheck.Checker
+	rtp         *rtpProber
+	groups      []targetGroup
+	baseline    *baselineStore
+	// reflectorAddr is the RTP_REFLECTOR_PORT listen address, empty when
+	// the reflector is off.
+	reflectorAddr string
+
+	// logged at startup
+	windowSize     int
+	windowDuration time.Duration
+
+	health *health.Tracker
+}
+
+// New reads configuration from the environment, validates it, and registers
+// metrics with the default Prometheus registry.
+func New() (*Service, error) {
+	targets, err := inventory.List("PING_TARGETS", inventory.RoleGateway, inventory.RoleWAN)
+	if err != nil {
+		return nil, err
+	}
+	sampleIntervalMs := config.Int("SAMPLE_INTERVAL_MS", 500)
+	windowSize := config.Int("WINDOW_SIZE", 60)
+	windowDuration := config.Duration("WINDOW_DURATION", 0)
+	burstThreshold := config.Int("BURST_THRESHOLD", 2)
+	warmupSamples := config.Int("WARMUP_SAMPLES", anomalyMinSamples)
+	adaptiveIntervalMs := config.Int("ADAPTIVE_INTERVAL_MS", 0)
+	madThreshold := config.Float("ANOMALY_MAD_THRESHOLD", 5)
+	cusumK := config.Float("CUSUM_K", 0.5)
+	cusumH := config.Float("CUSUM_H", 5)
+	baselineAlpha := config.Float("BASELINE_ALPHA", 0.2)
+
+	discover := config.Bool("DISCOVER_TARGETS", false)
+	anycast := config.List("DISCOVERY_ANYCAST_TARGETS")
+	if len(anycast) == 0 {
+		anycast = strings.Split(discovery.DefaultAnycast, ",")
+	}
+
+	if len(targets) == 0 && !discover {
+		return nil, errors.New("PING_TARGETS (or gateway and wan targets in the inventory) is required unless DISCOVER_TARGETS is enabled")
+	}
+	if windowDuration > 0 && !config.IsSet("WINDOW_SIZE") {
+		windowSize = durationWindowSize(windowDuration, sampleIntervalMs, adaptiveIntervalMs)
+	}
+	if windowSize < 1 {
+		return nil, fmt.Errorf("WINDOW_SIZE must be at least 1, got %d", windowSize)
+	}
+	if burstThreshold < 1 {
+		return nil, fmt.Errorf("BURST_THRESHOLD must be at least 1, got %d", burstThreshold)
+	}
+	if !config.IsSet("WARMUP_SAMPLES") {
+		// The default must not outgrow a small window, or a WINDOW_SIZE
+		// or WINDOW_DURATION that used to start would be rejected.
+		warmupSamples = min(warmupSamples, windowSize)
+	}
+	if warmupSamples < 0 || warmupSamples > windowSize {
+		return nil, fmt.Errorf("WARMUP_SAMPLES must be between 0 and the window size (%d), got %d", windowSize, warmupSamples)
+	}
+
+	if baselineAlpha <= 0 || baselineAlpha > 1 {
+		return nil, fmt.Errorf("BASELINE_ALPHA must be in (0, 1], got %g", baselineAlpha)
+	}
+
+	registerMetrics()
+
+	maint, err := maintenance.Load("jitter-probe")
+	if err != nil {
+		return nil, err
+	}
+	capturer, err := capture.Load("jitter-probe")
+	if err != nil {
+		return nil, err
+	}
+	groups, err := parseGroups()
+	if err != nil {
+		return nil, err
+	}
+	baseline, err := openBaselineStore(config.String("BASELINE_FILE", ""), baselineAlpha)
+	if err != nil {
+		return nil, err
+	}
+
+	interval := time.Duration(sampleIntervalMs) * time.Millisecond
+	s := &Service{
+		targets:          targets,
+		discover:         discover,
+		discoveryRefresh: config.Seconds("DISCOVERY_REFRESH_SECONDS", 30*time.Second),
+		anycast:          anycast,
+		burstThreshold:   burstThreshold,
+		warmupSamples:    warmupSamples,
+		timeout:          probe.DefaultTimeout,
+		resolveTimeout:   config.Seconds("PROBE_RESOLVE_TIMEOUT_SECONDS", probe.DefaultResolveTimeout),
+		interval:         interval,
+		maintenance:      maint,
+		capture:          capturer,
+		groups:           groups,
+		baseline:         baseline,
+		windowSize:       windowSize,
+		windowDuration:   windowDuration,
+		rate: &adaptiveRate{
+			base:              interval,
+			fast:              time.Duration(adaptiveIntervalMs) * time.Millisecond,
+			lossThreshold:     config.Float("ADAPTIVE_LOSS_RATIO", 0.05),
+			jitterThresholdMs: config.Float("ADAPTIVE_JITTER_MS", 50),
+			burstThreshold:    burstThreshold,
+			cooldown:          config.Seconds("ADAPTIVE_COOLDOWN_SECONDS", 30*time.Second),
+		},
+	}
+	sampleInterval.Set(float64(interval.Milliseconds()))
+	if s.selfcheck, err = selfcheck.Load("jitter-probe", s.canaryProbe); err != nil {
+		return nil, err
+	}
+	if s.rtp, err = loadRTPProber(); err != nil {
+		return nil, err
+	}
+	if port := config.Int("RTP_REFLECTOR_PORT", 0); port != 0 {
+		if port < 1 || port > 65535 {
+			return nil, fmt.Errorf("RTP_REFLECTOR_PORT must be between 1 and 65535, got %d", port)
+		}
+		s.reflectorAddr = ":" + strconv.Itoa(port)
+		registerReflectorMetrics()
+	}
+
+	s.registry = newTargetRegistry(func(name string) (*targetState, error) {
+		probe, err := parseTarget(name)
+		if err != nil {
+			return nil, err
+		}
+		return &targetState{
+			probe:   probe,
+			addedAt: time.Now(),
+			window:  NewWindow(windowSize, windowDuration),
+			detector: &anomalyDetector{
+				madThreshold: madThreshold,
+				cusumK:       cusumK,
+				cusumH:       cusumH,
+			},
+		}, nil
+	})
+
+	s.refreshTargets()
+	if names, _ := s.registry.snapshot(); len(names) == 0 {
+		return nil, errors.New("no valid probe targets")
+	}
+	pause.Track("jitter-probe")
+	buildinfo.Register("jitter-probe", map[string]bool{
+		"discovery":       s.discover,
+		"adaptive_rate":   s.rate.enabled(),
+		"target_groups":   len(s.groups) > 0,
+		"baseline_file":   s.baseline.path != "",
+		"packet_capture":  s.capture != nil,
+		"window_duration": s.windowDuration > 0,
+		"selfcheck":       s.selfcheck != nil,
+		"rtp_streams":     s.rtp != nil,
+		"rtp_reflector":   s.reflectorAddr != "",
+	})
+	s.health = health.NewTracker("jitter-probe", s.interval)
+	return s, nil
+}
+
+// refreshTargets applies the static targets plus, when enabled, the
+// currently discovered targets.
+func (s *Service) refreshTargets() {
+	if !s.discover {
+		s.registry.set(s.targets)
+		return
+	}
+	s.registry.set(discovery.Merge(s.targets, discovery.Targets(s.anycast)))
+}
+
+// Register adds the service's HTTP handlers (other than /metrics) to mux.
+func (s *Service) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/targets", targetsHandler(s.registry, s.groups))
+}
+
+// Health returns the probe loop tracker behind /healthz and /readyz.
+func (s *Service) Health() *health.Tracker { return s.health }
+
+// Run samples all targets every interval until ctx is cancelled, alongside
+// the canaries, RTP streams and RTP reflector when configured.
+func (s *Service) Run(ctx context.Context) error {
+	var reflector net.PacketConn
+	if s.reflectorAddr != "" {
+		var err error
+		if reflector, err = net.ListenPacket("udp", s.reflectorAddr); err != nil {
+			return fmt.Errorf("RTP reflector: %w", err)
+		}
+	}
+
+	names, _ := s.registry.snapshot()
+	var rtpTargets []string
+	if s.rtp != nil {
+		rtpTargets = s.rtp.names()
+	}
+	slog.Info("starting jitter-probe",
+		"targets", names,
+		"sample_interval_ms", s.interval.Milliseconds(),
+		"window_size", s.windowSize,
+		"window_duration", s.windowDuration.String(),
+		"burst_threshold", s.burstThreshold,
+		"warmup_samples", s.warmupSamples,
+		"adaptive_interval_ms", s.rate.fast.Milliseconds(),
+		"discover_targets", s.discover,
+		"groups", len(s.groups),
+		"baseline_file", s.baseline.path,
+		"rtp_targets", rtpTargets,
+		"rtp_reflector", s.reflectorAddr,
+	)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	if s.selfcheck != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.selfcheck.Run(ctx)
+		}()
+	}
+	if s.rtp != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.rtp.run(ctx)
+		}()
+	}
+	if reflector != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			(&rtpReflector{}).run(ctx, reflector)
+		}()
+	}
+
+	interval := s.interval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastDiscovery := time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		start := time.Now()
+		if pause.Active("jitter-probe") {
+			s.health.Cycle(start, interval)
+			continue
+		}
+
+		if s.discover && time.Since(lastDiscovery) >= s.discoveryRefresh {
+			s.refreshTargets()
+			lastDiscovery = time.Now()
+		}
+
+		states := s.sampleOnce(ctx)
+		if err := s.baseline.save(time.Now()); err != nil {
+			slog.Warn("saving latency baseline failed", "path", s.baseline.path, "error", err, "retry_in", s.baseline.retryWait.String())
+		}
+		s.health.Cycle(start, interval)
+
+		if next := s.rate.update(states, time.Now()); next != interval {
+			interval = next
+			ticker.Reset(interval)
+			sampleInterval.Set(float64(interval.Milliseconds()))
+		}
+	}
+}
+
+// publish reports a target entering a loss burst (BURST_THRESHOLD
+// consecutive failures) or leaving it to in-process subscribers, with the
+// packet capture the burst triggered.
+func (s *Service) publish(st *targetState, err error, inMaintenance bool, captureFile string) {
+	statebus.Publish(statebus.Change{
+		Service:     "jitter-probe",
+		Probe:       "tcp",
+		Target:      st.probe.name,
+		Via:         st.probe.bind.Label(),
+		Up:          err == nil,
+		ErrorClass:  string(probe.Classify(err)),
+		Maintenance: inMaintenance,
+		Capture:     captureFile,
+	})
+}
+
+// sampleOnce probes every target once and returns the states it updated.
+func (s *Service) sampleOnce(ctx context.Context) map[string]*targetState {
+	names, states := s.registry.snapshot()
+	for _, target := range names {
+		st := states[target]
+		l := st.probe.labels()
+		var latency time.Duration
+		host, err := s.resolve(ctx, st.probe)
+		if err == nil {
+			latency, err = tcpProbe(ctx, st.probe, host, s.timeout)
+		}
+		ok := err == nil
+		inMaintenance := s.maintenance.Active(st.probe.name, time.Now())
+
+		st.mu.Lock()
+		st.maintenance = inMaintenance
+
+		if ok {
+			latencyMs := float64(latency.Nanoseconds()) / 1e6
+
+			// If we were in a burst (BURST_THRESHOLD+ consecutive failures), record it.
+			if st.consecutiveFails >= s.burstThreshold {
+				packetLossBurstTotal.WithLabelValues(l...).Inc()
+				slog.Warn("packet loss burst ended",
+					"target", target,
+					"consecutive_failures", st.consecutiveFails,
+				)
+				s.publish(st, nil, inMaintenance, "")
+			}
+			st.consecutiveFails = 0
+			st.lastSuccessAt = time.Now().UTC()
+			st.latencyMs = latencyMs
+
+			recordAnomaly(target, latencyMs, st)
+			st.window.Add(latencyMs)
+
+			networkLatency.WithLabelValues(l...).Set(latencyMs)
+			if !st.warm && st.window.Successes() >= s.warmupSamples {
+				st.warm = true
+				slog.Debug("target warmed up; exporting window statistics", "target", target, "samples", st.window.Len())
+			}
+			if st.warm {
+				networkJitter.WithLabelValues(l...).Set(st.window.StdDev())
+				latencyP95.WithLabelValues(l...).Set(st.window.Percentile(95))
+				latencyP99.WithLabelValues(l...).Set(st.window.Percentile(99))
+			}
+			// Maintenance work is not what normal looks like.
+			if !inMaintenance {
+				s.baseline.observe(target, &st.hour, time.Now(), latencyMs)
+			}
+		} else {
+			packetLossTotal.WithLabelValues(l...).Inc()
+			st.consecutiveFails++
+			st.window.AddLoss()
+			st.lastErrorAt = time.Now().UTC()
+			st.lastError = err.Error()
+
+			level := slog.LevelWarn
+			if inMaintenance {
+				s.maintenance.Failure(st.probe.name)
+				level = slog.LevelInfo
+			}
+			slog.Log(ctx, level, "tcp probe failed",
+				"target", target,
+				"error", err,
+				"error_class", probe.Classify(err),
+				"consecutive_failures", st.consecutiveFails,
+				"maintenance", inMaintenance,
+			)
+			if st.consecutiveFails == s.burstThreshold {
+				args := []any{"target", target, "consecutive_failures", st.consecutiveFails}
+				var captureFile string
+				if !inMaintenance {
+					if captureFile = s.capture.Trigger("loss_burst", target); captureFile != "" {
+						args = append(args, "capture", captureFile)
+					}
+				}
+				slog.Log(ctx, level, "packet loss burst started", args...)
+				s.publish(st, err, inMaintenance, captureFile)
+			}
+		}
+
+		packetLossRatio.WithLabelValues(l...).Set(st.window.LossRatio())
+		consecutiveFailures.WithLabelValues(l...).Set(float64(st.consecutiveFails))
+		lastSuccess := st.lastSuccessAt
+		if lastSuccess.IsZero() {
+			lastSuccess = st.addedAt
+		}
+		sinceSuccess.WithLabelValues(l...).Set(time.Since(lastSuccess).Seconds())
+		updateQuality(target, st)
+		s.updateDeviation(target, st)
+		st.mu.Unlock()
+	}
+	updateGroupMetrics(s.groups, names, states)
+	return states
+}
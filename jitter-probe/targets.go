@@ -0,0 +1,75 @@
+package jitterprobe
+
+import (
+	"log/slog"
+	"sync"
+)
+
+// targetRegistry holds the active probe targets in probe order. The probe
+// loop is the only writer; the HTTP API reads it concurrently.
+type targetRegistry struct {
+	mu     sync.RWMutex
+	names  []string
+	states map[string]*targetState
+	build  func(name string) (*targetState, error)
+}
+
+func newTargetRegistry(build func(name string) (*targetState, error)) *targetRegistry {
+	return &targetRegistry{
+		states: make(map[string]*targetState),
+		build:  build,
+	}
+}
+
+// snapshot returns the current target names and a copy of the state map.
+func (r *targetRegistry) snapshot() ([]string, map[string]*targetState) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := append([]string(nil), r.names...)
+	states := make(map[string]*targetState, len(r.states))
+	for k, v := range r.states {
+		states[k] = v
+	}
+	return names, states
+}
+
+// set replaces the active target list, keeping the state of targets that
+// remain and dropping the metric series of targets that were removed.
+// Invalid entries are logged and skipped.
+func (r *targetRegistry) set(names []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next := make(map[string]*targetState, len(names))
+	order := make([]string, 0, len(names))
+	for _, name := range names {
+		if _, dup := next[name]; dup {
+			continue
+		}
+		if st, ok := r.states[name]; ok {
+			next[name] = st
+			order = append(order, name)
+			continue
+		}
+		st, err := r.build(name)
+		if err != nil {
+			slog.Error("invalid target", "target", name, "error", err)
+			continue
+		}
+		initTargetMetrics(st.probe)
+		next[name] = st
+		order = append(order, name)
+		slog.Info("target added", "target", name)
+	}
+
+	for name, st := range r.states {
+		if _, ok := next[name]; !ok {
+			deleteTargetMetrics(st.probe)
+			slog.Info("target removed", "target", name)
+		}
+	}
+
+	r.names = order
+	r.states = next
+}
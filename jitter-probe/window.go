@@ -1,32 +1,107 @@
-package main
+package jitterprobe
 
 import (
 	"math"
+	"slices"
 	"sort"
+	"time"
 )
 
-// Window is a fixed-size ring buffer for latency samples in milliseconds.
+// sample is a single probe outcome. Lost samples carry no latency.
+type sample struct {
+	at        time.Time
+	latencyMs float64
+	lost      bool
+}
+
+// Window is a fixed-size ring buffer of probe outcomes. Latency statistics
+// are computed over successful samples; loss is computed over all samples.
+//
+// The capacity always bounds memory. When maxAge is non-zero, samples older
+// than maxAge are also evicted as new ones arrive, so the window covers a
+// fixed duration regardless of the sampling interval. Between samples it
+// covers maxAge up to the latest one.
+//
+// Next to the ring, the latencies of the successful samples are kept in
+// ascending order, updated as samples enter and leave the window, so the
+// percentiles, median and MAD read it directly instead of copying and
+// sorting the window on every call. Reads do not modify the window, but a
+// Window is not safe for concurrent use: the caller serializes Add and
+// AddLoss with reads.
 type Window struct {
-	data  []float64
-	pos   int
-	count int
-	cap   int
+	data   []sample
+	pos    int // next slot to write
+	count  int
+	cap    int
+	maxAge time.Duration
+
+	sorted []float64 // latencies of the successful samples, ascending
+	lost   int       // lost samples
 }
 
-// NewWindow creates a ring buffer with the given capacity.
-func NewWindow(capacity int) *Window {
+// NewWindow creates a ring buffer with the given capacity. A non-zero maxAge
+// additionally evicts samples by timestamp.
+func NewWindow(capacity int, maxAge time.Duration) *Window {
 	return &Window{
-		data: make([]float64, capacity),
-		cap:  capacity,
+		data:   make([]sample, capacity),
+		cap:    capacity,
+		maxAge: maxAge,
+		sorted: make([]float64, 0, capacity),
 	}
 }
 
 // Add inserts a latency sample (in milliseconds) into the ring buffer.
 func (w *Window) Add(latencyMs float64) {
-	w.data[w.pos] = latencyMs
+	w.push(sample{at: time.Now(), latencyMs: latencyMs})
+}
+
+// AddLoss records a failed probe in the ring buffer.
+func (w *Window) AddLoss() {
+	w.push(sample{at: time.Now(), lost: true})
+}
+
+func (w *Window) push(s sample) {
+	w.expire(s.at)
+	if w.count == w.cap {
+		w.evictOldest()
+	}
+	w.data[w.pos] = s
 	w.pos = (w.pos + 1) % w.cap
-	if w.count < w.cap {
-		w.count++
+	w.count++
+	if s.lost {
+		w.lost++
+		return
+	}
+	i, _ := slices.BinarySearch(w.sorted, s.latencyMs)
+	w.sorted = slices.Insert(w.sorted, i, s.latencyMs)
+}
+
+// expire evicts the samples older than maxAge at now, oldest first.
+func (w *Window) expire(now time.Time) {
+	if w.maxAge <= 0 {
+		return
+	}
+	cutoff := now.Add(-w.maxAge)
+	for w.count > 0 && w.data[w.oldest()].at.Before(cutoff) {
+		w.evictOldest()
+	}
+}
+
+// oldest returns the ring index of the oldest sample.
+func (w *Window) oldest() int {
+	return (w.pos - w.count + w.cap) % w.cap
+}
+
+// evictOldest drops the oldest sample from the window.
+func (w *Window) evictOldest() {
+	s := w.data[w.oldest()]
+	w.count--
+	if s.lost {
+		w.lost--
+		return
+	}
+	if i, found := slices.BinarySearch(w.sorted, s.latencyMs); found {
+		w.sorted = slices.Delete(w.sorted, i, i+1)
 	}
 }
 
@@ -35,28 +110,29 @@ func (w *Window) Len() int {
 	return w.count
 }
 
-// values returns a copy of the current samples.
-func (w *Window) values() []float64 {
-	if w.count == 0 {
-		return nil
+// Successes returns the number of successful samples in the window.
+func (w *Window) Successes() int {
+	return len(w.sorted)
+}
+
+// Mean calculates the mean latency of the successful samples.
+func (w *Window) Mean() float64 {
+	if len(w.sorted) == 0 {
+		return 0
 	}
-	out := make([]float64, w.count)
-	if w.count < w.cap {
-		copy(out, w.data[:w.count])
-	} else {
-		// Ring buffer is full; read from pos (oldest) to end, then start to pos.
-		n := copy(out, w.data[w.pos:])
-		copy(out[n:], w.data[:w.pos])
+	sum := 0.0
+	for _, v := range w.sorted {
+		sum += v
 	}
-	return out
+	return sum / float64(len(w.sorted))
 }
 
 // StdDev calculates the population standard deviation of the samples.
 func (w *Window) StdDev() float64 {
-	if w.count < 2 {
+	vals := w.sorted
+	if len(vals) < 2 {
 		return 0
 	}
-	vals := w.values()
 	mean := 0.0
 	for _, v := range vals {
 		mean += v
@@ -74,11 +150,10 @@ func (w *Window) StdDev() float64 {
 
 // Percentile calculates the p-th percentile (0-100) using nearest-rank method.
 func (w *Window) Percentile(p float64) float64 {
-	if w.count == 0 {
+	vals := w.sorted
+	if len(vals) == 0 {
 		return 0
 	}
-	vals := w.values()
-	sort.Float64s(vals)
 
 	rank := (p / 100.0) * float64(len(vals))
 	idx := int(math.Ceil(rank)) - 1
@@ -90,3 +165,58 @@ func (w *Window) Percentile(p float64) float64 {
 	}
 	return vals[idx]
 }
+
+// LossRatio returns the fraction (0-1) of samples in the window that were lost.
+func (w *Window) LossRatio() float64 {
+	if w.count == 0 {
+		return 0
+	}
+	return float64(w.lost) / float64(w.count)
+}
+
+// MedianMAD returns the median and the median absolute deviation of the
+// successful samples in the window.
+func (w *Window) MedianMAD() (float64, float64) {
+	vals := w.sorted
+	if len(vals) == 0 {
+		return 0, 0
+	}
+	median := medianSorted(vals)
+	n := len(vals)
+	if n%2 == 1 {
+		return median, kthDeviation(vals, median, n/2)
+	}
+	return median, (kthDeviation(vals, median, n/2-1) + kthDeviation(vals, median, n/2)) / 2
+}
+
+// kthDeviation returns the k-th smallest (0-based) absolute deviation of
+// the sorted vals from m. The deviations of the values below m grow
+// leftwards from m and those of the rest rightwards, so merging the two
+// runs finds it without sorting.
+func kthDeviation(vals []float64, m float64, k int) float64 {
+	right := sort.SearchFloat64s(vals, m)
+	left := right - 1
+	var d float64
+	for i := 0; i <= k; i++ {
+		switch {
+		case left < 0:
+			d = vals[right] - m
+			right++
+		case right >= len(vals) || m-vals[left] <= vals[right]-m:
+			d = m - vals[left]
+			left--
+		default:
+			d = vals[right] - m
+			right++
+		}
+	}
+	return d
+}
+
+func medianSorted(vals []float64) float64 {
+	n := len(vals)
+	if n%2 == 1 {
+		return vals[n/2]
+	}
+	return (vals[n/2-1] + vals[n/2]) / 2
+}
@@ -0,0 +1,165 @@
+package jitterprobe
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+// refWindow recomputes the window statistics by sorting a copy of the
+// samples on every call, as Window did before it kept them sorted.
+type refWindow struct {
+	samples []sample
+	cap     int
+	maxAge  time.Duration
+}
+
+func (r *refWindow) push(s sample) {
+	if r.maxAge > 0 {
+		cutoff := s.at.Add(-r.maxAge)
+		for len(r.samples) > 0 && r.samples[0].at.Before(cutoff) {
+			r.samples = r.samples[1:]
+		}
+	}
+	if len(r.samples) == r.cap {
+		r.samples = r.samples[1:]
+	}
+	r.samples = append(r.samples, s)
+}
+
+func (r *refWindow) values() []float64 {
+	var out []float64
+	for _, s := range r.samples {
+		if !s.lost {
+			out = append(out, s.latencyMs)
+		}
+	}
+	sort.Float64s(out)
+	return out
+}
+
+func (r *refWindow) percentile(p float64) float64 {
+	vals := r.values()
+	if len(vals) == 0 {
+		return 0
+	}
+	idx := int(math.Ceil(p/100*float64(len(vals)))) - 1
+	return vals[max(0, min(idx, len(vals)-1))]
+}
+
+func (r *refWindow) medianMAD() (float64, float64) {
+	vals := r.values()
+	if len(vals) == 0 {
+		return 0, 0
+	}
+	median := medianSorted(vals)
+	devs := make([]float64, len(vals))
+	for i, v := range vals {
+		devs[i] = math.Abs(v - median)
+	}
+	sort.Float64s(devs)
+	return median, medianSorted(devs)
+}
+
+func TestWindowMatchesFullSort(t *testing.T) {
+	for _, tc := range []struct {
+		capacity int
+		maxAge   time.Duration
+	}{
+		{1, 0},
+		{7, 0},
+		{60, 0},
+		{60, 5 * time.Second},
+		{600, 30 * time.Second},
+	} {
+		t.Run(fmt.Sprintf("cap=%d/maxAge=%s", tc.capacity, tc.maxAge), func(t *testing.T) {
+			rng := rand.New(rand.NewSource(int64(tc.capacity)))
+			w := NewWindow(tc.capacity, tc.maxAge)
+			ref := &refWindow{cap: tc.capacity, maxAge: tc.maxAge}
+			at := time.Now()
+			for i := 0; i < 5000; i++ {
+				// Irregular intervals, including gaps that age out the
+				// whole window, and repeated latencies.
+				at = at.Add(time.Duration(rng.Intn(1500)) * time.Millisecond)
+				if rng.Intn(200) == 0 {
+					at = at.Add(time.Minute)
+				}
+				s := sample{at: at, latencyMs: float64(rng.Intn(50)) + rng.Float64()*float64(rng.Intn(2))}
+				if rng.Intn(10) == 0 {
+					s = sample{at: at, lost: true}
+				}
+				w.push(s)
+				ref.push(s)
+
+				if got, want := w.Len(), len(ref.samples); got != want {
+					t.Fatalf("step %d: Len = %d, want %d", i, got, want)
+				}
+				if got, want := w.Successes(), len(ref.values()); got != want {
+					t.Fatalf("step %d: Successes = %d, want %d", i, got, want)
+				}
+				for _, p := range []float64{0, 50, 95, 99, 100} {
+					if got, want := w.Percentile(p), ref.percentile(p); got != want {
+						t.Fatalf("step %d: Percentile(%v) = %v, want %v", i, p, got, want)
+					}
+				}
+				gm, gd := w.MedianMAD()
+				wm, wd := ref.medianMAD()
+				if gm != wm || gd != wd {
+					t.Fatalf("step %d: MedianMAD = %v, %v, want %v, %v", i, gm, gd, wm, wd)
+				}
+			}
+		})
+	}
+}
+
+func fillWindow(size int) *Window {
+	rng := rand.New(rand.NewSource(1))
+	w := NewWindow(size, 0)
+	for i := 0; i < size; i++ {
+		w.Add(10 + rng.Float64()*20)
+	}
+	return w
+}
+
+func BenchmarkPercentile(b *testing.B) {
+	for _, size := range []int{60, 600, 6000} {
+		w := fillWindow(size)
+		ref := &refWindow{cap: size}
+		for i := 0; i < size; i++ {
+			ref.push(w.data[i])
+		}
+		b.Run(fmt.Sprintf("incremental/%d", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				w.Percentile(95)
+				w.Percentile(99)
+				w.MedianMAD()
+			}
+		})
+		b.Run(fmt.Sprintf("fullsort/%d", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				ref.percentile(95)
+				ref.percentile(99)
+				ref.medianMAD()
+			}
+		})
+	}
+}
+
+func BenchmarkPush(b *testing.B) {
+	for _, size := range []int{60, 600, 6000} {
+		b.Run(fmt.Sprint(size), func(b *testing.B) {
+			w := fillWindow(size)
+			rng := rand.New(rand.NewSource(2))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				w.Add(10 + rng.Float64()*20)
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package modemcollector
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// atTimeout bounds one AT command; modems answer within milliseconds
+// unless they are wedged.
+const atTimeout = 3 * time.Second
+
+// atTechnologies maps the <AcT> of +COPS (3GPP TS 27.007) to the names
+// ModemManager uses. 13 is LTE with an NR secondary cell, 5G NSA.
+var atTechnologies = map[int]string{
+	0:  "gsm",
+	1:  "gsm_compact",
+	2:  "umts",
+	3:  "edge",
+	4:  "hsdpa",
+	5:  "hsupa",
+	6:  "hspa",
+	7:  "lte",
+	8:  "gsm",
+	9:  "lte_nb_iot",
+	10: "lte",
+	11: "5gnr",
+	12: "5gnr",
+	13: "5gnr",
+}
+
+// atRegistration maps the <stat> of +CREG, +CEREG and +C5GREG.
+var atRegistration = map[int]string{
+	0:  regIdle,
+	1:  regHome,
+	2:  regSearching,
+	3:  regDenied,
+	4:  regUnknown,
+	5:  regRoaming,
+	6:  regHome,
+	7:  regRoaming,
+	9:  regHome,
+	10: regRoaming,
+}
+
+// atModem talks to one modem over its AT command port, for hosts that do
+// not run ModemManager. Only standard 3GPP commands are used, so RSRP and
+// RSRQ come from +CESQ and there is no SINR; the network interface is
+// MODEM_INTERFACE.
+type atModem struct {
+	port string
+	f    *os.File
+	r    *bufio.Reader
+	// identity is read once per open port.
+	manufacturer, model, revision string
+}
+
+func (a *atModem) name() string { return sourceAT }
+
+func (a *atModem) close() {
+	if a.f != nil {
+		a.f.Close()
+		a.f, a.r = nil, nil
+	}
+}
+
+// read queries the modem. The port is closed on any error and reopened
+// at the next poll, as it disappears when the modem re-enumerates.
+func (a *atModem) read(ctx context.Context) ([]reading, error) {
+	r, err := a.query(ctx)
+	if err != nil {
+		a.close()
+		return nil, fmt.Errorf("%s: %w", a.port, err)
+	}
+	return []reading{r}, nil
+}
+
+func (a *atModem) query(ctx context.Context) (reading, error) {
+	if a.f == nil {
+		f, err := openPort(a.port)
+		if err != nil {
+			return reading{}, err
+		}
+		a.f, a.r = f, bufio.NewReader(f)
+		if _, err := a.command(ctx, "ATE0"); err != nil {
+			return reading{}, err
+		}
+		for _, id := range []struct {
+			cmd string
+			dst *string
+		}{
+			{"AT+CGMI", &a.manufacturer},
+			{"AT+CGMM", &a.model},
+			{"AT+CGMR", &a.revision},
+		} {
+			lines, err := a.command(ctx, id.cmd)
+			if err != nil {
+				return reading{}, err
+			}
+			*id.dst = atIdentity(lines, strings.TrimPrefix(id.cmd, "AT"))
+		}
+	}
+
+	r := reading{
+		modem:        filepath.Base(a.port),
+		manufacturer: a.manufacturer,
+		model:        a.model,
+		revision:     a.revision,
+		registration: regUnknown,
+		quality:      math.NaN(),
+	}
+
+	lines, err := a.command(ctx, "AT+COPS?")
+	if err != nil {
+		return reading{}, err
+	}
+	if fields := atFields(lines, "+COPS:"); len(fields) >= 4 {
+		r.operator = fields[2]
+		if act, err := strconv.Atoi(fields[3]); err == nil {
+			r.technology = atTechnologies[act]
+		}
+	}
+
+	// The circuit-switched, LTE and 5G registrations are separate; the
+	// first that is registered counts. Modems without LTE or 5G answer
+	// ERROR to the newer commands.
+	for _, cmd := range []string{"AT+C5GREG?", "AT+CEREG?", "AT+CREG?"} {
+		lines, err := a.command(ctx, cmd)
+		var atErr *atError
+		if errors.As(err, &atErr) {
+			continue
+		}
+		if err != nil {
+			return reading{}, err
+		}
+		fields := atFields(lines, strings.TrimSuffix(strings.TrimPrefix(cmd, "AT"), "?")+":")
+		if len(fields) < 2 {
+			continue
+		}
+		stat, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+		state, ok := atRegistration[stat]
+		if !ok {
+			state = regUnknown
+		}
+		if r.registration == regUnknown || state == regHome || state == regRoaming {
+			r.registration = state
+		}
+		if state == regHome || state == regRoaming {
+			break
+		}
+	}
+
+	sig := newSignal(signalFamily(r.technology))
+	lines, err = a.command(ctx, "AT+CSQ")
+	if err != nil {
+		return reading{}, err
+	}
+	if fields := atFields(lines, "+CSQ:"); len(fields) >= 1 {
+		if v, err := strconv.Atoi(fields[0]); err == nil && v >= 0 && v <= 31 {
+			r.quality = math.Round(float64(v) * 100 / 31)
+			sig.rssi = float64(-113 + 2*v)
+		}
+	}
+	lines, err = a.command(ctx, "AT+CESQ")
+	var atErr *atError
+	if err != nil && !errors.As(err, &atErr) {
+		return reading{}, err
+	}
+	if fields := atFields(lines, "+CESQ:"); len(fields) >= 6 && sig.technology == "lte" {
+		if v, err := strconv.Atoi(fields[4]); err == nil && v <= 34 {
+			sig.rsrq = -20 + float64(v)/2
+		}
+		if v, err := strconv.Atoi(fields[5]); err == nil && v <= 97 {
+			sig.rsrp = float64(-141 + v)
+		}
+	}
+	if sig.technology != "" && !(math.IsNaN(sig.rssi) && math.IsNaN(sig.rsrp) && math.IsNaN(sig.rsrq)) {
+		r.signals = []signal{sig}
+	}
+	return r, nil
+}
+
+// signalFamily returns the radio a technology's signal is measured on.
+// +CESQ reports the LTE anchor of a 5G NSA connection.
+func signalFamily(technology string) string {
+	switch technology {
+	case "5gnr", "lte", "lte_nb_iot":
+		return "lte"
+	case "umts", "hsdpa", "hsupa", "hspa":
+		return "umts"
+	case "gsm", "gsm_compact", "edge":
+		return "gsm"
+	}
+	return ""
+}
+
+// atError is a final ERROR or +CME ERROR result.
+type atError struct {
+	cmd, result string
+}
+
+func (e *atError) Error() string { return e.cmd + ": " + e.result }
+
+// command sends cmd and returns the information lines of its response up
+// to the final OK.
+func (a *atModem) command(ctx context.Context, cmd string) ([]string, error) {
+	deadline := time.Now().Add(atTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := a.f.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	if _, err := a.f.WriteString(cmd + "\r"); err != nil {
+		return nil, err
+	}
+	var lines []string
+	for {
+		line, err := a.r.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", cmd, err)
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || line == cmd:
+			// blank separators, or the echo before ATE0
+		case line == "OK":
+			return lines, nil
+		case line == "ERROR", strings.HasPrefix(line, "+CME ERROR"), strings.HasPrefix(line, "+CMS ERROR"):
+			return nil, &atError{cmd: cmd, result: line}
+		default:
+			lines = append(lines, line)
+		}
+	}
+}
+
+// atFields returns the comma-separated values of the first line starting
+// with prefix, unquoted.
+func atFields(lines []string, prefix string) []string {
+	for _, line := range lines {
+		rest, ok := strings.CutPrefix(line, prefix)
+		if !ok {
+			continue
+		}
+		fields := strings.Split(rest, ",")
+		for i, f := range fields {
+			fields[i] = strings.Trim(strings.TrimSpace(f), `"`)
+		}
+		return fields
+	}
+	return nil
+}
+
+// atIdentity returns the answer to an identification command, without
+// the "+CGMR:" or "Revision:" style prefix some modems add.
+func atIdentity(lines []string, name string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	v := strings.TrimPrefix(lines[0], "+"+name+":")
+	v = strings.TrimPrefix(v, "Revision:")
+	return strings.TrimSpace(v)
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package modemcollector
+
+import (
+	"errors"
+	"os"
+)
+
+func openPort(path string) (*os.File, error) {
+	return nil, errors.New("MODEM_SOURCE=at is only supported on Linux")
+}
@@ -0,0 +1,7 @@
+{{- define "modem-collector.name" -}}
+modem-collector
+{{- end -}}
+
+{{- define "modem-collector.fullname" -}}
+{{ include "modem-collector.name" . }}
+{{- end -}}
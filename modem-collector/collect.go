@@ -0,0 +1,245 @@
+package modemcollector
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"edge-monitor-app/internal/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Registration states, as ModemManager names them.
+const (
+	regIdle      = "idle"
+	regHome      = "home"
+	regSearching = "searching"
+	regDenied    = "denied"
+	regRoaming   = "roaming"
+	regUnknown   = "unknown"
+)
+
+// source reads the modems.
+type source interface {
+	name() string
+	read(ctx context.Context) ([]reading, error)
+	close()
+}
+
+// reading is one modem at one poll. Values a modem does not report are
+// empty strings or NaN.
+type reading struct {
+	// modem labels the modem's series: its primary control port, such
+	// as cdc-wdm0 or ttyUSB2, which stays the same across polls.
+	modem        string
+	manufacturer string
+	model        string
+	revision     string
+	operator     string
+	technology   string
+	registration string
+	quality      float64
+	signals      []signal
+	// netdev is the modem's network interface, when the source knows it.
+	netdev string
+}
+
+// signal is the signal of one radio access technology. A 5G NSA modem
+// reports lte and 5gnr side by side.
+type signal struct {
+	technology string
+	rssi       float64
+	rsrp       float64
+	rsrq       float64
+	sinr       float64
+}
+
+func newSignal(technology string) signal {
+	nan := math.NaN()
+	return signal{technology: technology, rssi: nan, rsrp: nan, rsrq: nan, sinr: nan}
+}
+
+// modemState is what a modem's polls carry over to the next one.
+type modemState struct {
+	present      bool
+	info         []string
+	registration string
+	signals      []string
+	// counters holds the interface byte counters at the previous poll.
+	iface    string
+	counters [2]uint64
+}
+
+// poll reads every modem and updates its metrics. A modem that stops
+// answering keeps its series with modem_up 0.
+func (s *Service) poll(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, pollTimeout)
+	defer cancel()
+
+	start := time.Now()
+	readings, err := s.source.read(ctx)
+	pollDuration.Set(time.Since(start).Seconds())
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		pollErrors.Inc()
+		slog.Warn("modem poll failed", "source", s.source.name(), "error", err)
+		readings = nil
+	}
+
+	seen := make(map[string]bool, len(readings))
+	for _, r := range readings {
+		seen[r.modem] = true
+		st, ok := s.states[r.modem]
+		if !ok {
+			st = &modemState{}
+			s.states[r.modem] = st
+		}
+		if !st.present {
+			slog.Info("modem found", "modem", r.modem, "manufacturer", r.manufacturer, "model", r.model, "netdev", r.netdev)
+			st.present = true
+		}
+		s.update(r, st)
+	}
+	for name, st := range s.states {
+		if seen[name] {
+			continue
+		}
+		modemUp.WithLabelValues(name).Set(0)
+		modemRegistered.WithLabelValues(name).Set(0)
+		if st.present {
+			slog.Warn("modem not answering", "modem", name)
+			st.present = false
+		}
+	}
+	if err == nil && len(readings) == 0 && len(s.states) == 0 {
+		slog.Debug("no modem found", "source", s.source.name())
+	}
+}
+
+func (s *Service) update(r reading, st *modemState) {
+	m := r.modem
+	modemUp.WithLabelValues(m).Set(1)
+
+	info := []string{m, r.manufacturer, r.model, r.revision, r.operator, r.technology}
+	if !slices.Equal(info, st.info) {
+		modemInfo.DeletePartialMatch(prometheus.Labels{"modem": m})
+		modemInfo.WithLabelValues(info...).Set(1)
+		if st.info == nil {
+			technologyChanges.WithLabelValues(m).Add(0)
+		} else if prev := st.info[5]; prev != r.technology {
+			technologyChanges.WithLabelValues(m).Inc()
+			slog.Warn("modem access technology changed", "modem", m, "from", prev, "to", r.technology, "operator", r.operator)
+		}
+		st.info = info
+	}
+
+	registered := r.registration == regHome || r.registration == regRoaming
+	modemRegistered.WithLabelValues(m).Set(metrics.BoolToFloat(registered))
+	modemRoaming.WithLabelValues(m).Set(metrics.BoolToFloat(r.registration == regRoaming))
+	switch {
+	case st.registration == "":
+		registrationChanges.WithLabelValues(m).Add(0)
+		slog.Info("modem registration", "modem", m, "state", r.registration, "operator", r.operator, "technology", r.technology)
+	case st.registration != r.registration:
+		registrationChanges.WithLabelValues(m).Inc()
+		log := slog.Warn
+		if registered {
+			log = slog.Info
+		}
+		log("modem registration changed", "modem", m, "from", st.registration, "to", r.registration, "operator", r.operator)
+	}
+	st.registration = r.registration
+
+	setOrDelete(signalQuality.WithLabelValues(m), r.quality, func() { signalQuality.DeleteLabelValues(m) })
+
+	techs := make([]string, 0, len(r.signals))
+	for _, sig := range r.signals {
+		techs = append(techs, sig.technology)
+		for _, g := range []struct {
+			vec   *prometheus.GaugeVec
+			value float64
+		}{
+			{signalRSSI, sig.rssi},
+			{signalRSRP, sig.rsrp},
+			{signalRSRQ, sig.rsrq},
+			{signalSINR, sig.sinr},
+		} {
+			vec := g.vec
+			setOrDelete(vec.WithLabelValues(m, sig.technology), g.value, func() { vec.DeleteLabelValues(m, sig.technology) })
+		}
+	}
+	for _, old := range st.signals {
+		if !slices.Contains(techs, old) {
+			for _, vec := range []*prometheus.GaugeVec{signalRSSI, signalRSRP, signalRSRQ, signalSINR} {
+				vec.DeleteLabelValues(m, old)
+			}
+		}
+	}
+	st.signals = techs
+
+	iface := s.iface
+	if iface == "" {
+		iface = r.netdev
+	}
+	if iface != "" {
+		updateUsage(m, iface, st)
+	}
+}
+
+// setOrDelete sets g to v, or deletes the series when v is unknown so a
+// stale value does not linger.
+func setOrDelete(g prometheus.Gauge, v float64, del func()) {
+	if math.IsNaN(v) {
+		del()
+		return
+	}
+	g.Set(v)
+}
+
+// updateUsage adds the interface's traffic since the previous poll to the
+// byte counters. The kernel's counters restart when the interface is
+// recreated, e.g. when the modem re-enumerates; the new value then counts
+// from zero.
+func updateUsage(modem, iface string, st *modemState) {
+	var cur [2]uint64
+	for i, name := range []string{"rx_bytes", "tx_bytes"} {
+		v, err := readCounter(iface, name)
+		if err != nil {
+			slog.Debug("modem interface counters not readable", "modem", modem, "interface", iface, "error", err)
+			return
+		}
+		cur[i] = v
+	}
+	if st.iface != iface {
+		receiveBytes.WithLabelValues(modem, iface).Add(0)
+		transmitBytes.WithLabelValues(modem, iface).Add(0)
+		st.iface, st.counters = iface, cur
+		return
+	}
+	for i, c := range []*prometheus.CounterVec{receiveBytes, transmitBytes} {
+		delta := cur[i] - st.counters[i]
+		if cur[i] < st.counters[i] {
+			delta = cur[i]
+		}
+		c.WithLabelValues(modem, iface).Add(float64(delta))
+	}
+	st.counters = cur
+}
+
+func readCounter(iface, name string) (uint64, error) {
+	b, err := os.ReadFile(filepath.Join("/sys/class/net", iface, "statistics", name))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+}
@@ -0,0 +1,21 @@
+module edge-monitor-app/modem-collector
+
+go 1.22
+
+require (
+	edge-monitor-app/internal v0.0.0
+	github.com/godbus/dbus/v5 v5.1.0
+	github.com/prometheus/client_golang v1.19.0
+	golang.org/x/sys v0.16.0
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/prometheus/client_model v0.5.0 // indirect
+	github.com/prometheus/common v0.48.0 // indirect
+	github.com/prometheus/procfs v0.12.0 // indirect
+	google.golang.org/protobuf v1.32.0 // indirect
+)
+
+replace edge-monitor-app/internal => ../internal
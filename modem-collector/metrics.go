@@ -0,0 +1,143 @@
+package modemcollector
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	modemUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "modem_up",
+			Help: "1 if the modem answered the last poll",
+		},
+		[]string{"modem"},
+	)
+
+	pollDuration = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "modem_poll_duration_seconds",
+			Help: "Duration of the last poll of the modems",
+		},
+	)
+
+	pollErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "modem_poll_errors_total",
+			Help: "Polls that failed to read the modems",
+		},
+	)
+
+	modemInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "modem_info",
+			Help: "Modem identity, network operator and access technology (5gnr, lte, umts, ...), always 1",
+		},
+		[]string{"modem", "manufacturer", "model", "revision", "operator", "technology"},
+	)
+
+	modemRegistered = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "modem_registered",
+			Help: "1 if the modem is registered on its home network or roaming",
+		},
+		[]string{"modem"},
+	)
+
+	modemRoaming = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "modem_roaming",
+			Help: "1 if the modem is registered on a roaming network",
+		},
+		[]string{"modem"},
+	)
+
+	registrationChanges = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "modem_registration_changes_total",
+			Help: "Changes of the modem's registration state between polls",
+		},
+		[]string{"modem"},
+	)
+
+	technologyChanges = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "modem_access_technology_changes_total",
+			Help: "Changes of the modem's access technology between polls, e.g. LTE falling back to UMTS",
+		},
+		[]string{"modem"},
+	)
+
+	signalQuality = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "modem_signal_quality_percent",
+			Help: "Signal quality the modem reports, 0 to 100",
+		},
+		[]string{"modem"},
+	)
+
+	signalRSSI = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "modem_signal_rssi_dbm",
+			Help: "Received signal strength indicator",
+		},
+		[]string{"modem", "technology"},
+	)
+
+	signalRSRP = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "modem_signal_rsrp_dbm",
+			Help: "Reference signal received power (LTE, 5G)",
+		},
+		[]string{"modem", "technology"},
+	)
+
+	signalRSRQ = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "modem_signal_rsrq_db",
+			Help: "Reference signal received quality (LTE, 5G)",
+		},
+		[]string{"modem", "technology"},
+	)
+
+	signalSINR = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "modem_signal_sinr_db",
+			Help: "Signal to interference plus noise ratio (LTE, 5G)",
+		},
+		[]string{"modem", "technology"},
+	)
+
+	receiveBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "modem_receive_bytes_total",
+			Help: "Bytes received on the modem's network interface",
+		},
+		[]string{"modem", "interface"},
+	)
+
+	transmitBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "modem_transmit_bytes_total",
+			Help: "Bytes sent on the modem's network interface",
+		},
+		[]string{"modem", "interface"},
+	)
+)
+
+func registerMetrics() {
+	prometheus.MustRegister(
+		modemUp,
+		pollDuration,
+		pollErrors,
+		modemInfo,
+		modemRegistered,
+		modemRoaming,
+		registrationChanges,
+		technologyChanges,
+		signalQuality,
+		signalRSSI,
+		signalRSRP,
+		signalRSRQ,
+		signalSINR,
+		receiveBytes,
+		transmitBytes,
+	)
+}
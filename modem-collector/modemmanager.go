@@ -0,0 +1,250 @@
+package modemcollector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// ModemManager's D-Bus names (ModemManager1 API, 1.x).
+const (
+	mmService     = "org.freedesktop.ModemManager1"
+	mmPath        = "/org/freedesktop/ModemManager1"
+	mmModem       = "org.freedesktop.ModemManager1.Modem"
+	mmModem3gpp   = "org.freedesktop.ModemManager1.Modem.Modem3gpp"
+	mmModemSignal = "org.freedesktop.ModemManager1.Modem.Signal"
+)
+
+// mmPortNet is MM_MODEM_PORT_TYPE_NET.
+const mmPortNet = 2
+
+// mmRegistration maps MMModem3gppRegistrationState to the names used in
+// logs. The SMS-only and CSFB variants register for data all the same.
+var mmRegistration = map[uint32]string{
+	0:  regIdle,
+	1:  regHome,
+	2:  regSearching,
+	3:  regDenied,
+	4:  regUnknown,
+	5:  regRoaming,
+	6:  regHome,
+	7:  regRoaming,
+	9:  regHome,
+	10: regRoaming,
+}
+
+// mmTechnologies lists MMModemAccessTechnology bits from the best down;
+// a modem reports the highest it is using.
+var mmTechnologies = []struct {
+	bit  uint32
+	name string
+}{
+	{1 << 15, "5gnr"},
+	{1 << 14, "lte"},
+	{1 << 16, "lte_cat_m"},
+	{1 << 17, "lte_nb_iot"},
+	{1 << 9, "hspa_plus"},
+	{1 << 8, "hspa"},
+	{1 << 7, "hsupa"},
+	{1 << 6, "hsdpa"},
+	{1 << 5, "umts"},
+	{1 << 13, "evdob"},
+	{1 << 12, "evdoa"},
+	{1 << 11, "evdo0"},
+	{1 << 10, "1xrtt"},
+	{1 << 4, "edge"},
+	{1 << 3, "gprs"},
+	{1 << 2, "gsm_compact"},
+	{1 << 1, "gsm"},
+}
+
+// mmSignals maps the Signal interface's per-technology properties to the
+// technology label.
+var mmSignals = []struct {
+	property string
+	name     string
+}{
+	{"Nr5g", "5gnr"},
+	{"Lte", "lte"},
+	{"Umts", "umts"},
+	{"Gsm", "gsm"},
+}
+
+// modemManager reads every modem ModemManager manages from the system bus
+// (DBUS_SYSTEM_BUS_ADDRESS, or the standard socket).
+type modemManager struct {
+	// signalRate is the refresh rate requested from the Signal interface,
+	// which reports nothing until a rate is set.
+	signalRate time.Duration
+	conn       *dbus.Conn
+	// setupFailed remembers modems whose Signal.Setup was refused, so the
+	// warning is logged once.
+	setupFailed map[dbus.ObjectPath]bool
+}
+
+func (m *modemManager) name() string { return sourceModemManager }
+
+func (m *modemManager) close() {
+	if m.conn != nil {
+		m.conn.Close()
+	}
+}
+
+// read lists the modems with their properties in one GetManagedObjects
+// call. A lost bus connection is reopened at the next poll.
+func (m *modemManager) read(ctx context.Context) ([]reading, error) {
+	if m.conn == nil {
+		conn, err := dbus.ConnectSystemBus()
+		if err != nil {
+			return nil, fmt.Errorf("system bus: %w", err)
+		}
+		m.conn = conn
+	}
+	var objects map[dbus.ObjectPath]map[string]map[string]dbus.Variant
+	err := m.conn.Object(mmService, mmPath).CallWithContext(ctx, "org.freedesktop.DBus.ObjectManager.GetManagedObjects", 0).Store(&objects)
+	if err != nil {
+		if !m.conn.Connected() {
+			m.conn.Close()
+			m.conn = nil
+		}
+		return nil, fmt.Errorf("ModemManager: %w", err)
+	}
+
+	var readings []reading
+	for path, ifaces := range objects {
+		modem, ok := ifaces[mmModem]
+		if !ok {
+			continue
+		}
+		r := reading{
+			modem:        variantString(modem["PrimaryPort"]),
+			manufacturer: variantString(modem["Manufacturer"]),
+			model:        variantString(modem["Model"]),
+			revision:     variantString(modem["Revision"]),
+			technology:   mmTechnology(variantUint32(modem["AccessTechnologies"])),
+			registration: regUnknown,
+			quality:      math.NaN(),
+			netdev:       mmNetPort(modem["Ports"]),
+		}
+		if r.modem == "" {
+			r.modem = string(path)
+		}
+		var q struct {
+			Percent uint32
+			Recent  bool
+		}
+		if err := storeVariant(modem["SignalQuality"], &q); err == nil {
+			r.quality = float64(q.Percent)
+		}
+		if g, ok := ifaces[mmModem3gpp]; ok {
+			if state, ok := mmRegistration[variantUint32(g["RegistrationState"])]; ok {
+				r.registration = state
+			}
+			r.operator = variantString(g["OperatorName"])
+		}
+		if sig, ok := ifaces[mmModemSignal]; ok {
+			m.setupSignal(ctx, path, sig)
+			r.signals = mmSignalValues(sig)
+		}
+		readings = append(readings, r)
+	}
+	return readings, nil
+}
+
+// setupSignal asks the modem to refresh its extended signal values at the
+// poll interval, unless it already does.
+func (m *modemManager) setupSignal(ctx context.Context, path dbus.ObjectPath, sig map[string]dbus.Variant) {
+	rate := uint32(max(m.signalRate/time.Second, 1))
+	if variantUint32(sig["Rate"]) == rate || m.setupFailed[path] {
+		return
+	}
+	err := m.conn.Object(mmService, path).CallWithContext(ctx, mmModemSignal+".Setup", 0, rate).Err
+	if err != nil {
+		if m.setupFailed == nil {
+			m.setupFailed = make(map[dbus.ObjectPath]bool)
+		}
+		m.setupFailed[path] = true
+		slog.Warn("modem signal refresh not enabled; RSRP, RSRQ and SINR are unavailable", "modem", path, "error", err)
+	}
+}
+
+// mmSignalValues reads the Signal interface's per-technology dictionaries.
+// ModemManager leaves out, or reports -inf for, values the modem does not
+// give.
+func mmSignalValues(sig map[string]dbus.Variant) []signal {
+	var signals []signal
+	for _, t := range mmSignals {
+		var values map[string]dbus.Variant
+		if err := storeVariant(sig[t.property], &values); err != nil || len(values) == 0 {
+			continue
+		}
+		s := newSignal(t.name)
+		s.rssi = variantFloat(values["rssi"])
+		s.rsrp = variantFloat(values["rsrp"])
+		s.rsrq = variantFloat(values["rsrq"])
+		s.sinr = variantFloat(values["snr"])
+		if math.IsNaN(s.rssi) && math.IsNaN(s.rsrp) && math.IsNaN(s.rsrq) && math.IsNaN(s.sinr) {
+			continue
+		}
+		signals = append(signals, s)
+	}
+	return signals
+}
+
+func mmTechnology(bits uint32) string {
+	for _, t := range mmTechnologies {
+		if bits&t.bit != 0 {
+			return t.name
+		}
+	}
+	return ""
+}
+
+// mmNetPort returns the modem's network interface from its Ports, a(su).
+func mmNetPort(v dbus.Variant) string {
+	var ports []struct {
+		Name string
+		Type uint32
+	}
+	if err := storeVariant(v, &ports); err != nil {
+		return ""
+	}
+	for _, p := range ports {
+		if p.Type == mmPortNet {
+			return p.Name
+		}
+	}
+	return ""
+}
+
+// storeVariant is Variant.Store for properties that may be missing, which
+// godbus cannot store from.
+func storeVariant(v dbus.Variant, dst any) error {
+	if v.Value() == nil {
+		return errors.New("property not set")
+	}
+	return v.Store(dst)
+}
+
+func variantString(v dbus.Variant) string {
+	s, _ := v.Value().(string)
+	return s
+}
+
+func variantUint32(v dbus.Variant) uint32 {
+	u, _ := v.Value().(uint32)
+	return u
+}
+
+func variantFloat(v dbus.Variant) float64 {
+	f, ok := v.Value().(float64)
+	if !ok || math.IsInf(f, 0) {
+		return math.NaN()
+	}
+	return f
+}
@@ -0,0 +1,116 @@
+// Package modemcollector implements the modem-collector service. It reads
+// signal strength, network registration and data usage from the LTE or 5G
+// modem a site fails over to, through ModemManager on the system bus or AT
+// commands on the modem's serial port, so a failover can be judged by the
+// quality of the link it moved to. It runs standalone via
+// cmd/modem-collector or inside the combined edge-monitor binary.
+package modemcollector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"edge-monitor-app/internal/buildinfo"
+	"edge-monitor-app/internal/config"
+	"edge-monitor-app/internal/health"
+	"edge-monitor-app/internal/pause"
+)
+
+// DefaultAddr is the standalone metrics listen address.
+const DefaultAddr = ":9098"
+
+// Modem sources, MODEM_SOURCE.
+const (
+	sourceModemManager = "modemmanager"
+	sourceAT           = "at"
+)
+
+// pollTimeout bounds one poll of every modem.
+const pollTimeout = 10 * time.Second
+
+// Service is a configured modem-collector instance.
+type Service struct {
+	source   source
+	interval time.Duration
+	// iface overrides the network interface data usage is read from.
+	iface  string
+	states map[string]*modemState
+
+	health *health.Tracker
+}
+
+// New reads configuration from the environment and registers metrics with
+// the default Prometheus registry.
+func New() (*Service, error) {
+	registerMetrics()
+
+	s := &Service{
+		interval: config.Seconds("MODEM_INTERVAL_SECONDS", 15*time.Second),
+		iface:    config.String("MODEM_INTERFACE", ""),
+		states:   make(map[string]*modemState),
+	}
+	if s.interval <= 0 {
+		return nil, errors.New("MODEM_INTERVAL_SECONDS must be positive")
+	}
+	switch name := config.String("MODEM_SOURCE", sourceModemManager); name {
+	case sourceModemManager:
+		s.source = &modemManager{signalRate: s.interval}
+	case sourceAT:
+		port := config.String("MODEM_AT_PORT", "/dev/ttyUSB2")
+		if port == "" {
+			return nil, errors.New("MODEM_AT_PORT is required with MODEM_SOURCE=at")
+		}
+		s.source = &atModem{port: port}
+		if s.iface == "" {
+			s.iface = "wwan0"
+		}
+	default:
+		return nil, fmt.Errorf("MODEM_SOURCE must be %s or %s, not %q", sourceModemManager, sourceAT, name)
+	}
+
+	_, at := s.source.(*atModem)
+	pause.Track("modem-collector")
+	buildinfo.Register("modem-collector", map[string]bool{
+		"at_commands": at,
+	})
+	s.health = health.NewTracker("modem-collector", s.interval)
+	return s, nil
+}
+
+// Register adds the service's HTTP handlers (other than /metrics) to mux.
+func (s *Service) Register(mux *http.ServeMux) {}
+
+// Health returns the poll loop tracker behind /healthz and /readyz.
+func (s *Service) Health() *health.Tracker { return s.health }
+
+// Run polls the modems at start and then every interval until ctx is
+// cancelled.
+func (s *Service) Run(ctx context.Context) error {
+	slog.Info("starting modem-collector",
+		"source", s.source.name(),
+		"interface", s.iface,
+		"interval", s.interval.String(),
+	)
+	defer s.source.close()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		start := time.Now()
+		if !pause.Active("modem-collector") {
+			s.poll(ctx)
+		}
+		s.health.Cycle(start, s.interval)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,7 @@
+{{- define "path-monitor.name" -}}
+path-monitor
+{{- end -}}
+
+{{- define "path-monitor.fullname" -}}
+{{ include "path-monitor.name" . }}
+{{- end -}}
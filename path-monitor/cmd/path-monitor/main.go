@@ -0,0 +1,85 @@
+// Command path-monitor runs the path-monitor service standalone.
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"edge-monitor-app/internal/config"
+	"edge-monitor-app/internal/health"
+	"edge-monitor-app/internal/lifecycle"
+	"edge-monitor-app/internal/logging"
+	"edge-monitor-app/internal/otlp"
+	"edge-monitor-app/internal/pause"
+	"edge-monitor-app/internal/profiling"
+	"edge-monitor-app/internal/remotewrite"
+	pathmonitor "edge-monitor-app/path-monitor"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func main() {
+	opts := config.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(opts.LogOutput(), nil)))
+	if err := config.Load(opts.File); err != nil {
+		slog.Error("failed to load config file", "error", err)
+		os.Exit(1)
+	}
+	logging.Setup(opts.LogOutput())
+
+	svc, err := pathmonitor.New()
+	if err != nil {
+		slog.Error("failed to configure path-monitor", "error", err)
+		os.Exit(1)
+	}
+
+	app := lifecycle.New("path-monitor")
+	app.Go("probe loop", svc.Run)
+
+	rw, err := remotewrite.New("path-monitor")
+	if err != nil {
+		slog.Error("failed to configure remote write", "error", err)
+		os.Exit(1)
+	}
+	if rw != nil {
+		app.Go("remote write", rw.Run)
+		app.OnShutdown(rw.Flush)
+	}
+
+	ex, err := otlp.New("path-monitor")
+	if err != nil {
+		slog.Error("failed to configure otlp export", "error", err)
+		os.Exit(1)
+	}
+	if ex != nil {
+		app.Go("otlp export", ex.Run)
+		app.OnShutdown(ex.Flush)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	svc.Register(mux)
+	health.Register(mux, svc.Health())
+	logging.Register(mux)
+	pause.Register(mux)
+	profiling.Register(mux)
+
+	addr := config.String("LISTEN_ADDR", pathmonitor.DefaultAddr)
+	if err := config.Err(); err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	if opts.Print {
+		config.Print(os.Stdout)
+		return
+	}
+
+	app.Serve(addr, mux)
+	slog.Info("metrics server listening", "addr", addr, "path", "/metrics")
+	if err := app.Run(); err != nil {
+		os.Exit(1)
+	}
+}
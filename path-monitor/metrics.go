@@ -0,0 +1,82 @@
+package pathmonitor
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	routeChanges = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "route_changes_total",
+			Help: "Total number of hop sequence changes toward the target",
+		},
+		[]string{"target"},
+	)
+
+	pathHash = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "path_hash",
+			Help: "FNV-1a hash of the current hop sequence; changes when the route changes",
+		},
+		[]string{"target"},
+	)
+
+	pathHops = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "path_hop_count",
+			Help: "Number of hops in the current path",
+		},
+		[]string{"target"},
+	)
+
+	pathReached = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "path_reached",
+			Help: "1 if the last traceroute reached the target, 0 if it ended early",
+		},
+		[]string{"target"},
+	)
+
+	pathLastChange = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "path_last_change_timestamp_seconds",
+			Help: "Unix time of the last route change toward the target",
+		},
+		[]string{"target"},
+	)
+
+	hopLatency = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "path_hop_latency_seconds",
+			Help: "Round-trip time to each hop (TTL) of the path in seconds",
+		},
+		[]string{"target", "hop"},
+	)
+
+	hopInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "path_hop_info",
+			Help: "Router address at each hop of the current path, always 1",
+		},
+		[]string{"target", "hop", "address"},
+	)
+
+	traceErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "path_trace_errors_total",
+			Help: "Total number of traceroutes that failed to run",
+		},
+		[]string{"target"},
+	)
+)
+
+func registerMetrics() {
+	prometheus.MustRegister(
+		routeChanges,
+		pathHash,
+		pathHops,
+		pathReached,
+		pathLastChange,
+		hopLatency,
+		hopInfo,
+		traceErrors,
+	)
+}
@@ -0,0 +1,141 @@
+package pathmonitor
+
+import (
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"time"
+
+	"edge-monitor-app/internal/metrics"
+	"edge-monitor-app/internal/probe"
+)
+
+// routePath is the hop sequence currently known for a target: one router
+// address per TTL, empty where no hop has answered yet.
+type routePath struct {
+	hops    []string
+	reached bool
+}
+
+func pathFromTrace(trace []probe.Hop) routePath {
+	p := routePath{hops: make([]string, len(trace))}
+	for i, h := range trace {
+		p.hops[i] = h.Addr
+		p.reached = p.reached || h.Reached
+	}
+	return p
+}
+
+// merge folds a new trace into the known path. Silent hops match any
+// address, so a router that skips an answer (ICMP rate limiting) is not a
+// route change; its address is kept from the known path instead. changed
+// reports a different router at some hop, or a different distance to the
+// destination.
+func (p routePath) merge(next routePath) (merged routePath, changed bool) {
+	if p.hops == nil {
+		return next, false
+	}
+	for i := 0; i < len(p.hops) && i < len(next.hops); i++ {
+		if p.hops[i] != "" && next.hops[i] != "" && p.hops[i] != next.hops[i] {
+			return next, true
+		}
+	}
+	// Traces drop trailing silent hops, so a path that goes past where the
+	// other one reached the destination answered at a different distance.
+	if (p.reached && len(next.hops) > len(p.hops)) || (next.reached && len(p.hops) > len(next.hops)) {
+		return next, true
+	}
+
+	n := len(next.hops)
+	if !next.reached && len(p.hops) > n {
+		n = len(p.hops)
+	}
+	merged = routePath{hops: make([]string, n), reached: next.reached}
+	for i := range merged.hops {
+		if i < len(next.hops) && next.hops[i] != "" {
+			merged.hops[i] = next.hops[i]
+		} else if i < len(p.hops) {
+			merged.hops[i] = p.hops[i]
+		}
+	}
+	return merged, false
+}
+
+// hash identifies the hop sequence; silent hops hash as "*".
+func (p routePath) hash() uint32 {
+	h := fnv.New32a()
+	for i, addr := range p.hops {
+		if addr == "" {
+			addr = "*"
+		}
+		if i > 0 {
+			h.Write([]byte{','})
+		}
+		h.Write([]byte(addr))
+	}
+	return h.Sum32()
+}
+
+func (p routePath) String() string {
+	hops := make([]string, len(p.hops))
+	for i, addr := range p.hops {
+		if addr == "" {
+			addr = "*"
+		}
+		hops[i] = addr
+	}
+	return strings.Join(hops, " > ")
+}
+
+// targetState tracks one target. The trace loop owns all writes; mu in
+// Service guards reads from the HTTP API.
+type targetState struct {
+	path       routePath
+	lastTrace  []probe.Hop
+	lastTraced time.Time
+	lastChange time.Time
+	changes    int
+	lastError  string
+}
+
+// observe records a trace and updates the target's metrics. It returns
+// the previous path when the route changed.
+func (st *targetState) observe(target string, trace []probe.Hop, now time.Time) (prev routePath, changed bool) {
+	prev = st.path
+	merged, changed := prev.merge(pathFromTrace(trace))
+	st.path = merged
+	st.lastTrace = trace
+	st.lastTraced = now
+	st.lastError = ""
+	if changed {
+		st.changes++
+		st.lastChange = now
+		routeChanges.WithLabelValues(target).Inc()
+		pathLastChange.WithLabelValues(target).Set(float64(now.Unix()))
+	}
+
+	pathHash.WithLabelValues(target).Set(float64(merged.hash()))
+	pathHops.WithLabelValues(target).Set(float64(len(merged.hops)))
+	pathReached.WithLabelValues(target).Set(metrics.BoolToFloat(merged.reached))
+
+	for i, addr := range prev.hops {
+		hop := strconv.Itoa(i + 1)
+		if i >= len(merged.hops) {
+			hopLatency.DeleteLabelValues(target, hop)
+		}
+		if addr != "" && (i >= len(merged.hops) || merged.hops[i] != addr) {
+			hopInfo.DeleteLabelValues(target, hop, addr)
+		}
+	}
+	for i, addr := range merged.hops {
+		if addr != "" {
+			hopInfo.WithLabelValues(target, strconv.Itoa(i+1), addr).Set(1)
+		}
+	}
+	for _, h := range trace {
+		if h.Addr != "" {
+			hopLatency.WithLabelValues(target, strconv.Itoa(h.TTL)).Set(h.RTT.Seconds())
+		}
+	}
+	return prev, changed
+}
@@ -0,0 +1,207 @@
+// Package pathmonitor implements the path-monitor route change detector. It
+// runs periodic low-rate traceroutes and reports when the hop sequence
+// toward a target changes. It runs standalone via cmd/path-monitor or inside
+// the combined edge-monitor binary.
+package pathmonitor
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"edge-monitor-app/internal/buildinfo"
+	"edge-monitor-app/internal/config"
+	"edge-monitor-app/internal/health"
+	"edge-monitor-app/internal/inventory"
+	"edge-monitor-app/internal/pause"
+	"edge-monitor-app/internal/probe"
+)
+
+// DefaultAddr is the standalone metrics listen address.
+const DefaultAddr = ":9096"
+
+// hopTimeout is the wait for each hop's answer. A trace sends one probe
+// per hop and stops after a few silent hops, so it stays low-rate.
+const hopTimeout = time.Second
+
+// Service is a configured path-monitor instance.
+type Service struct {
+	targets  []string
+	interval time.Duration
+	maxHops  int
+
+	mu     sync.Mutex
+	states map[string]*targetState
+
+	health *health.Tracker
+}
+
+// New reads configuration from the environment and registers metrics with
+// the default Prometheus registry.
+func New() (*Service, error) {
+	registerMetrics()
+
+	targets, err := inventory.List("PATH_TARGETS", inventory.RoleWAN)
+	if err != nil {
+		return nil, err
+	}
+	s := &Service{
+		targets:  targets,
+		interval: config.Seconds("PATH_INTERVAL_SECONDS", 60*time.Second),
+		maxHops:  config.Int("PATH_MAX_HOPS", 30),
+		states:   make(map[string]*targetState),
+	}
+	if len(s.targets) == 0 {
+		s.targets = []string{"1.1.1.1", "8.8.8.8"}
+	}
+
+	for _, t := range s.targets {
+		s.states[t] = &targetState{}
+		routeChanges.WithLabelValues(t).Add(0)
+		traceErrors.WithLabelValues(t).Add(0)
+	}
+	pause.Track("path-monitor")
+	buildinfo.Register("path-monitor", nil)
+	s.health = health.NewTracker("path-monitor", s.interval)
+	return s, nil
+}
+
+// Register adds the service's HTTP handlers (other than /metrics) to mux.
+func (s *Service) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/paths", s.handlePaths)
+}
+
+// Health returns the probe loop tracker behind /healthz and /readyz.
+func (s *Service) Health() *health.Tracker { return s.health }
+
+// Run traces every target at start and then every interval until ctx is
+// cancelled.
+func (s *Service) Run(ctx context.Context) error {
+	slog.Info("starting path-monitor",
+		"path_targets", s.targets,
+		"interval", s.interval.String(),
+		"max_hops", s.maxHops,
+	)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		start := time.Now()
+		if !pause.Active("path-monitor") {
+			s.traceOnce(ctx)
+		}
+		s.health.Cycle(start, s.interval)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// traceOnce traces every target once, one after another.
+func (s *Service) traceOnce(ctx context.Context) {
+	for _, target := range s.targets {
+		trace, err := probe.Traceroute(ctx, target, probe.TracerouteOptions{
+			MaxHops:    s.maxHops,
+			HopTimeout: hopTimeout,
+		})
+		if ctx.Err() != nil {
+			return
+		}
+
+		s.mu.Lock()
+		st := s.states[target]
+		if err != nil {
+			st.lastError = err.Error()
+			s.mu.Unlock()
+			traceErrors.WithLabelValues(target).Inc()
+			slog.Warn("traceroute failed", "target", target, "error", err, "error_class", probe.Classify(err))
+			continue
+		}
+		prev, changed := st.observe(target, trace, time.Now())
+		path := st.path
+		s.mu.Unlock()
+
+		if changed {
+			slog.Warn("route changed",
+				"target", target,
+				"previous_path", prev.String(),
+				"path", path.String(),
+			)
+		} else if prev.hops == nil {
+			slog.Info("route discovered", "target", target, "path", path.String(), "reached", path.reached)
+		}
+	}
+}
+
+// hopStatus is the JSON view of one hop of the last trace.
+type hopStatus struct {
+	TTL     int     `json:"ttl"`
+	Address string  `json:"address,omitempty"`
+	RTTMs   float64 `json:"rtt_ms,omitempty"`
+}
+
+// pathStatus is the JSON view of a target's path.
+type pathStatus struct {
+	Target       string      `json:"target"`
+	Path         []string    `json:"path"`
+	Reached      bool        `json:"reached"`
+	Hash         uint32      `json:"hash"`
+	Changes      int         `json:"changes"`
+	LastTrace    []hopStatus `json:"last_trace"`
+	LastTracedAt *time.Time  `json:"last_traced_at,omitempty"`
+	LastChangeAt *time.Time  `json:"last_change_at,omitempty"`
+	LastError    string      `json:"last_error,omitempty"`
+}
+
+// handlePaths serves GET /paths with the current path of every target.
+func (s *Service) handlePaths(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.Lock()
+	items := make([]pathStatus, 0, len(s.targets))
+	for _, t := range s.targets {
+		st := s.states[t]
+		item := pathStatus{
+			Target:    t,
+			Path:      st.path.hops,
+			Reached:   st.path.reached,
+			Hash:      st.path.hash(),
+			Changes:   st.changes,
+			LastTrace: make([]hopStatus, 0, len(st.lastTrace)),
+			LastError: st.lastError,
+		}
+		for _, h := range st.lastTrace {
+			item.LastTrace = append(item.LastTrace, hopStatus{
+				TTL:     h.TTL,
+				Address: h.Addr,
+				RTTMs:   float64(h.RTT.Microseconds()) / 1000,
+			})
+		}
+		if !st.lastTraced.IsZero() {
+			at := st.lastTraced.UTC()
+			item.LastTracedAt = &at
+		}
+		if !st.lastChange.IsZero() {
+			at := st.lastChange.UTC()
+			item.LastChangeAt = &at
+		}
+		items = append(items, item)
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"generated_at": time.Now().UTC(),
+		"targets":      items,
+	})
+}
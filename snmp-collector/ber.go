@@ -0,0 +1,366 @@
+package snmpcollector
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BER tags used by SNMP (RFC 3416).
+const (
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagNull        = 0x05
+	tagOID         = 0x06
+	tagSequence    = 0x30
+
+	tagIPAddress = 0x40
+	tagCounter32 = 0x41
+	tagGauge32   = 0x42
+	tagTimeTicks = 0x43
+	tagOpaque    = 0x44
+	tagCounter64 = 0x46
+
+	tagNoSuchObject   = 0x80
+	tagNoSuchInstance = 0x81
+	tagEndOfMibView   = 0x82
+
+	pduGetRequest = 0xa0
+	pduGetNext    = 0xa1
+	pduResponse   = 0xa2
+	pduGetBulk    = 0xa5
+	pduReport     = 0xa8
+)
+
+var errMalformed = errors.New("malformed SNMP message")
+
+// oid is a parsed object identifier.
+type oid []uint32
+
+func parseOID(s string) (oid, error) {
+	parts := strings.Split(strings.TrimPrefix(s, "."), ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid OID %q", s)
+	}
+	out := make(oid, 0, len(parts))
+	for _, p := range parts {
+		n, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OID %q", s)
+		}
+		out = append(out, uint32(n))
+	}
+	if out[0] > 2 || (out[0] < 2 && out[1] >= 40) {
+		return nil, fmt.Errorf("invalid OID %q", s)
+	}
+	return out, nil
+}
+
+// mustOID parses a constant OID.
+func mustOID(s string) oid {
+	o, err := parseOID(s)
+	if err != nil {
+		panic(err)
+	}
+	return o
+}
+
+func (o oid) String() string {
+	parts := make([]string, len(o))
+	for i, n := range o {
+		parts[i] = strconv.FormatUint(uint64(n), 10)
+	}
+	return strings.Join(parts, ".")
+}
+
+// hasPrefix reports whether o lies in the subtree rooted at prefix.
+func (o oid) hasPrefix(prefix oid) bool {
+	if len(o) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if o[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// compare orders OIDs lexicographically, as agents walk them.
+func (o oid) compare(p oid) int {
+	for i := 0; i < len(o) && i < len(p); i++ {
+		switch {
+		case o[i] < p[i]:
+			return -1
+		case o[i] > p[i]:
+			return 1
+		}
+	}
+	return len(o) - len(p)
+}
+
+// varbind is a decoded variable binding. Integer-like values are held in
+// num, strings and addresses in str.
+type varbind struct {
+	oid oid
+	tag byte
+	num uint64
+	str string
+}
+
+// isException reports a noSuchObject, noSuchInstance or endOfMibView
+// value in place of data.
+func (v varbind) isException() bool {
+	return v.tag == tagNoSuchObject || v.tag == tagNoSuchInstance || v.tag == tagEndOfMibView
+}
+
+// --- encoding ---
+
+func appendLength(b []byte, n int) []byte {
+	switch {
+	case n < 0x80:
+		return append(b, byte(n))
+	case n <= 0xff:
+		return append(b, 0x81, byte(n))
+	case n <= 0xffff:
+		return append(b, 0x82, byte(n>>8), byte(n))
+	default:
+		return append(b, 0x83, byte(n>>16), byte(n>>8), byte(n))
+	}
+}
+
+func appendTLV(b []byte, tag byte, value []byte) []byte {
+	b = append(b, tag)
+	b = appendLength(b, len(value))
+	return append(b, value...)
+}
+
+func appendInt(b []byte, n int64) []byte {
+	// Minimal two's complement encoding.
+	var v []byte
+	for {
+		v = append([]byte{byte(n)}, v...)
+		if (n >= -128 && n < 128) || len(v) == 8 {
+			break
+		}
+		n >>= 8
+	}
+	return appendTLV(b, tagInteger, v)
+}
+
+func appendOctets(b []byte, s []byte) []byte {
+	return appendTLV(b, tagOctetString, s)
+}
+
+func appendOID(b []byte, o oid) []byte {
+	v := []byte{byte(o[0]*40 + o[1])}
+	for _, n := range o[2:] {
+		var sub []byte
+		sub = append(sub, byte(n&0x7f))
+		for n >>= 7; n > 0; n >>= 7 {
+			sub = append([]byte{byte(n&0x7f) | 0x80}, sub...)
+		}
+		v = append(v, sub...)
+	}
+	return appendTLV(b, tagOID, v)
+}
+
+// appendPDU encodes a request PDU with NULL values for oids. f1 and f2 are
+// the error status and index fields, which GetBulk uses for non-repeaters
+// and max-repetitions; other requests send zeros.
+func appendPDU(b []byte, pduType byte, requestID int32, f1, f2 int, oids []oid) []byte {
+	var vbs []byte
+	for _, o := range oids {
+		vbs = appendTLV(vbs, tagSequence, appendTLV(appendOID(nil, o), tagNull, nil))
+	}
+	var body []byte
+	body = appendInt(body, int64(requestID))
+	body = appendInt(body, int64(f1))
+	body = appendInt(body, int64(f2))
+	body = appendTLV(body, tagSequence, vbs)
+	return appendTLV(b, pduType, body)
+}
+
+// --- decoding ---
+
+// readTLV splits the first TLV off b.
+func readTLV(b []byte) (tag byte, value, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errMalformed
+	}
+	tag = b[0]
+	n := int(b[1])
+	off := 2
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 3 || len(b) < 2+size {
+			return 0, nil, nil, errMalformed
+		}
+		n = 0
+		for _, c := range b[2 : 2+size] {
+			n = n<<8 | int(c)
+		}
+		off += size
+	}
+	if len(b) < off+n {
+		return 0, nil, nil, errMalformed
+	}
+	return tag, b[off : off+n], b[off+n:], nil
+}
+
+// expectTLV reads a TLV that must carry tag.
+func expectTLV(b []byte, tag byte) (value, rest []byte, err error) {
+	t, value, rest, err := readTLV(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if t != tag {
+		return nil, nil, errMalformed
+	}
+	return value, rest, nil
+}
+
+func decodeInt(v []byte) (int64, error) {
+	if len(v) == 0 || len(v) > 8 {
+		return 0, errMalformed
+	}
+	n := int64(int8(v[0]))
+	for _, c := range v[1:] {
+		n = n<<8 | int64(c)
+	}
+	return n, nil
+}
+
+func decodeUint(v []byte) (uint64, error) {
+	// Counter64 values may carry a leading zero byte, so up to 9 bytes.
+	if len(v) == 0 || len(v) > 9 || (len(v) == 9 && v[0] != 0) {
+		return 0, errMalformed
+	}
+	var n uint64
+	for _, c := range v {
+		n = n<<8 | uint64(c)
+	}
+	return n, nil
+}
+
+func readInt(b []byte) (int64, []byte, error) {
+	v, rest, err := expectTLV(b, tagInteger)
+	if err != nil {
+		return 0, nil, err
+	}
+	n, err := decodeInt(v)
+	return n, rest, err
+}
+
+func decodeOID(v []byte) (oid, error) {
+	if len(v) == 0 {
+		return nil, errMalformed
+	}
+	var out oid
+	var n uint32
+	for i, c := range v {
+		if n > 1<<25 {
+			return nil, errMalformed
+		}
+		n = n<<7 | uint32(c&0x7f)
+		if c&0x80 != 0 {
+			if i == len(v)-1 {
+				return nil, errMalformed
+			}
+			continue
+		}
+		if out == nil {
+			first := min(n/40, 2)
+			out = oid{first, n - first*40}
+		} else {
+			out = append(out, n)
+		}
+		n = 0
+	}
+	return out, nil
+}
+
+// pdu is a decoded response or report PDU.
+type pdu struct {
+	typ         byte
+	requestID   int32
+	errorStatus int
+	errorIndex  int
+	varbinds    []varbind
+}
+
+func decodePDU(b []byte) (*pdu, error) {
+	if len(b) == 0 {
+		return nil, errMalformed
+	}
+	typ, body, _, err := readTLV(b)
+	if err != nil {
+		return nil, err
+	}
+	p := &pdu{typ: typ}
+	var n int64
+	if n, body, err = readInt(body); err != nil {
+		return nil, err
+	}
+	p.requestID = int32(n)
+	if n, body, err = readInt(body); err != nil {
+		return nil, err
+	}
+	p.errorStatus = int(n)
+	if n, body, err = readInt(body); err != nil {
+		return nil, err
+	}
+	p.errorIndex = int(n)
+
+	vbs, _, err := expectTLV(body, tagSequence)
+	if err != nil {
+		return nil, err
+	}
+	for len(vbs) > 0 {
+		var vb []byte
+		if vb, vbs, err = expectTLV(vbs, tagSequence); err != nil {
+			return nil, err
+		}
+		ov, rest, err := expectTLV(vb, tagOID)
+		if err != nil {
+			return nil, err
+		}
+		o, err := decodeOID(ov)
+		if err != nil {
+			return nil, err
+		}
+		tag, value, _, err := readTLV(rest)
+		if err != nil {
+			return nil, err
+		}
+		v := varbind{oid: o, tag: tag}
+		switch tag {
+		case tagInteger:
+			i, err := decodeInt(value)
+			if err != nil {
+				return nil, err
+			}
+			v.num = uint64(i)
+		case tagCounter32, tagGauge32, tagTimeTicks, tagCounter64:
+			if v.num, err = decodeUint(value); err != nil {
+				return nil, err
+			}
+		case tagOctetString, tagOpaque:
+			v.str = string(value)
+		case tagIPAddress:
+			if len(value) != 4 {
+				return nil, errMalformed
+			}
+			v.str = fmt.Sprintf("%d.%d.%d.%d", value[0], value[1], value[2], value[3])
+		case tagOID:
+			o, err := decodeOID(value)
+			if err != nil {
+				return nil, err
+			}
+			v.str = o.String()
+		}
+		p.varbinds = append(p.varbinds, v)
+	}
+	return p, nil
+}
@@ -0,0 +1,7 @@
+{{- define "snmp-collector.name" -}}
+snmp-collector
+{{- end -}}
+
+{{- define "snmp-collector.fullname" -}}
+{{ include "snmp-collector.name" . }}
+{{- end -}}
@@ -0,0 +1,291 @@
+package snmpcollector
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"edge-monitor-app/internal/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MIB-II and IF-MIB objects (RFC 1213, RFC 2863).
+var (
+	oidSysUpTime    = mustOID("1.3.6.1.2.1.1.3.0")
+	oidIfDescr      = mustOID("1.3.6.1.2.1.2.2.1.2")
+	oidIfOperStatus = mustOID("1.3.6.1.2.1.2.2.1.8")
+	oidIfName       = mustOID("1.3.6.1.2.1.31.1.1.1.1")
+)
+
+// ifOperStatus up(1); everything else (down, testing, dormant, …) is not.
+const operUp = 1
+
+// ifCounter is an interface counter column. The 64-bit column, when there
+// is one, is preferred; 32-bit octet counters wrap within minutes on a
+// gigabit link.
+type ifCounter struct {
+	metric *prometheus.CounterVec
+	oid32  oid
+	oid64  oid
+}
+
+var ifCounters = []ifCounter{
+	{metric: interfaceReceiveBytes, oid32: mustOID("1.3.6.1.2.1.2.2.1.10"), oid64: mustOID("1.3.6.1.2.1.31.1.1.1.6")},
+	{metric: interfaceTransmitBytes, oid32: mustOID("1.3.6.1.2.1.2.2.1.16"), oid64: mustOID("1.3.6.1.2.1.31.1.1.1.10")},
+	{metric: interfaceReceiveErrors, oid32: mustOID("1.3.6.1.2.1.2.2.1.14")},
+	{metric: interfaceTransmitErrors, oid32: mustOID("1.3.6.1.2.1.2.2.1.20")},
+	{metric: interfaceReceiveDiscards, oid32: mustOID("1.3.6.1.2.1.2.2.1.13")},
+	{metric: interfaceTransmitDiscards, oid32: mustOID("1.3.6.1.2.1.2.2.1.19")},
+}
+
+// counterKey is one counter of one interface.
+type counterKey struct {
+	ifIndex uint32
+	counter int // index into ifCounters
+}
+
+// counterValue is the raw device value seen at the previous poll.
+type counterValue struct {
+	value uint64
+	wide  bool // from a 64-bit column
+}
+
+// deviceState is what a device's polls carry over to the next one.
+type deviceState struct {
+	client   *client
+	polled   bool
+	uptime   uint64 // sysUpTime in hundredths of a second
+	counters map[counterKey]counterValue
+	oper     map[uint32]int
+	// names holds the exported interface name per ifIndex, so series of
+	// interfaces that disappear can be deleted.
+	names map[uint32]string
+}
+
+func newDeviceState(c *client) *deviceState {
+	return &deviceState{
+		client:   c,
+		counters: make(map[counterKey]counterValue),
+		oper:     make(map[uint32]int),
+		names:    make(map[uint32]string),
+	}
+}
+
+// poll reads one device and updates its metrics. Device counters are
+// exported as Prometheus counters that start at zero: each poll adds the
+// increase since the previous one, so a device reboot or counter wrap
+// never shows up as a negative step.
+func (s *Service) poll(ctx context.Context, d device, st *deviceState) error {
+	c := st.client
+
+	vbs, err := c.get(ctx, oidSysUpTime)
+	if err != nil {
+		return err
+	}
+	if len(vbs) != 1 || vbs[0].tag != tagTimeTicks {
+		return &snmpError{class: classSNMP, msg: "agent did not return sysUpTime"}
+	}
+	uptime := vbs[0].num
+	rebooted := st.polled && uptime < st.uptime
+	if rebooted {
+		deviceReboots.WithLabelValues(d.name).Inc()
+		slog.Warn("snmp device rebooted", "device", d.name, "uptime_seconds", uptime/100, "previous_uptime_seconds", st.uptime/100)
+	}
+	st.uptime = uptime
+	deviceUptime.WithLabelValues(d.name).Set(float64(uptime) / 100)
+
+	names, err := s.interfaceNames(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	oper, err := columnValues(ctx, c, oidIfOperStatus)
+	if err != nil {
+		return err
+	}
+	for idx, name := range names {
+		status, ok := oper[idx]
+		if !ok {
+			continue
+		}
+		interfaceUp.WithLabelValues(d.name, name).Set(metrics.BoolToFloat(status == operUp))
+		if prev, seen := st.oper[idx]; seen && prev != int(status) {
+			interfaceStatusChanges.WithLabelValues(d.name, name).Inc()
+			slog.Warn("snmp interface status changed", "device", d.name, "interface", name, "up", status == operUp)
+		} else if !seen {
+			interfaceStatusChanges.WithLabelValues(d.name, name).Add(0)
+		}
+		st.oper[idx] = int(status)
+	}
+
+	for i, col := range ifCounters {
+		var wide map[uint32]uint64
+		if col.oid64 != nil {
+			if wide, err = columnValues(ctx, c, col.oid64); err != nil {
+				return err
+			}
+		}
+		var narrow map[uint32]uint64
+		for idx := range names {
+			if _, ok := wide[idx]; !ok {
+				if narrow, err = columnValues(ctx, c, col.oid32); err != nil {
+					return err
+				}
+				break
+			}
+		}
+		for idx, name := range names {
+			cur := counterValue{wide: true}
+			var ok bool
+			if cur.value, ok = wide[idx]; !ok {
+				if cur.value, ok = narrow[idx]; !ok {
+					continue
+				}
+				cur.wide = false
+			}
+			key := counterKey{ifIndex: idx, counter: i}
+			prev, seen := st.counters[key]
+			st.counters[key] = cur
+			m := col.metric.WithLabelValues(d.name, name)
+			if seen && prev.wide == cur.wide {
+				m.Add(float64(increase(prev, cur, rebooted)))
+			} else {
+				m.Add(0)
+			}
+		}
+	}
+
+	// Drop series of interfaces that are gone or were renamed.
+	for idx, name := range st.names {
+		if names[idx] != name {
+			deleteInterface(d.name, name)
+			delete(st.oper, idx)
+			for i := range ifCounters {
+				delete(st.counters, counterKey{ifIndex: idx, counter: i})
+			}
+		}
+	}
+	st.names = names
+
+	if d.clientsOID != nil {
+		n, err := countClients(ctx, c, d.clientsOID, d.clientsSum)
+		if err != nil {
+			return err
+		}
+		wirelessClients.WithLabelValues(d.name).Set(float64(n))
+	}
+
+	st.polled = true
+	return nil
+}
+
+// increase returns how much a device counter grew between polls. A drop
+// is a reset after a reboot, or a 32-bit wrap when the device did not
+// reboot.
+func increase(prev, cur counterValue, rebooted bool) uint64 {
+	switch {
+	case rebooted:
+		return cur.value
+	case cur.value >= prev.value:
+		return cur.value - prev.value
+	case !cur.wide:
+		return cur.value + 1<<32 - prev.value
+	default:
+		return cur.value
+	}
+}
+
+// interfaceNames maps ifIndex to ifName, falling back to ifDescr on
+// agents without IF-MIB's ifXTable, limited to SNMP_INTERFACES when set.
+func (s *Service) interfaceNames(ctx context.Context, c *client) (map[uint32]string, error) {
+	names := make(map[uint32]string)
+	for _, col := range []oid{oidIfName, oidIfDescr} {
+		err := c.walk(ctx, col, func(vb varbind) {
+			if len(vb.oid) == len(col)+1 && vb.tag == tagOctetString && vb.str != "" {
+				names[vb.oid[len(col)]] = vb.str
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(names) > 0 {
+			break
+		}
+	}
+	for idx, name := range names {
+		if len(s.interfaces) > 0 && !s.interfaces[name] {
+			delete(names, idx)
+		} else if len(name) > maxInterfaceLabel {
+			names[idx] = name[:maxInterfaceLabel]
+		}
+	}
+	return names, nil
+}
+
+// maxInterfaceLabel truncates free-form ifDescr strings used as labels.
+const maxInterfaceLabel = 64
+
+// columnValues walks an ifTable-style column into ifIndex -> value.
+func columnValues(ctx context.Context, c *client, col oid) (map[uint32]uint64, error) {
+	out := make(map[uint32]uint64)
+	err := c.walk(ctx, col, func(vb varbind) {
+		if len(vb.oid) != len(col)+1 {
+			return
+		}
+		switch vb.tag {
+		case tagInteger, tagCounter32, tagGauge32, tagCounter64:
+			out[vb.oid[len(col)]] = vb.num
+		}
+	})
+	return out, err
+}
+
+// countClients counts the rows under oid (a per-client table column) or
+// sums its values (per-radio station counts).
+func countClients(ctx context.Context, c *client, root oid, sum bool) (uint64, error) {
+	var n uint64
+	err := c.walk(ctx, root, func(vb varbind) {
+		if vb.isException() {
+			return
+		}
+		if !sum {
+			n++
+			return
+		}
+		switch vb.tag {
+		case tagInteger, tagCounter32, tagGauge32, tagCounter64:
+			n += vb.num
+		}
+	})
+	if err != nil {
+		return 0, fmt.Errorf("clients OID %s: %w", root, err)
+	}
+	return n, nil
+}
+
+func deleteInterface(device, name string) {
+	interfaceUp.DeleteLabelValues(device, name)
+	interfaceStatusChanges.DeleteLabelValues(device, name)
+	for _, col := range ifCounters {
+		col.metric.DeleteLabelValues(device, name)
+	}
+}
+
+// pollDevice polls one device and records the outcome.
+func (s *Service) pollDevice(ctx context.Context, d device, st *deviceState) {
+	start := time.Now()
+	err := s.poll(ctx, d, st)
+	pollDuration.WithLabelValues(d.name).Set(time.Since(start).Seconds())
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		deviceUp.WithLabelValues(d.name).Set(0)
+		pollErrors.WithLabelValues(d.name, errorClass(err)).Inc()
+		slog.Warn("snmp poll failed", "device", d.name, "addr", d.addr, "version", d.version, "error", err, "error_class", errorClass(err))
+		return
+	}
+	deviceUp.WithLabelValues(d.name).Set(1)
+}
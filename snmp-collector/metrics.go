@@ -0,0 +1,136 @@
+package snmpcollector
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	deviceUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "snmp_up",
+			Help: "1 if the last SNMP poll of the device succeeded",
+		},
+		[]string{"device"},
+	)
+
+	pollDuration = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "snmp_poll_duration_seconds",
+			Help: "Duration of the last SNMP poll of the device",
+		},
+		[]string{"device"},
+	)
+
+	pollErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "snmp_poll_errors_total",
+			Help: "Failed SNMP polls by error class",
+		},
+		[]string{"device", "error_class"},
+	)
+
+	deviceUptime = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "snmp_device_uptime_seconds",
+			Help: "Device uptime from sysUpTime",
+		},
+		[]string{"device"},
+	)
+
+	deviceReboots = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "snmp_device_reboots_total",
+			Help: "Device restarts seen as sysUpTime going backwards",
+		},
+		[]string{"device"},
+	)
+
+	interfaceUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "snmp_interface_up",
+			Help: "1 if the interface's ifOperStatus is up",
+		},
+		[]string{"device", "interface"},
+	)
+
+	interfaceStatusChanges = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "snmp_interface_status_changes_total",
+			Help: "ifOperStatus changes between polls",
+		},
+		[]string{"device", "interface"},
+	)
+
+	interfaceReceiveBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "snmp_interface_receive_bytes_total",
+			Help: "Bytes received on the interface (ifHCInOctets, or ifInOctets)",
+		},
+		[]string{"device", "interface"},
+	)
+
+	interfaceTransmitBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "snmp_interface_transmit_bytes_total",
+			Help: "Bytes sent on the interface (ifHCOutOctets, or ifOutOctets)",
+		},
+		[]string{"device", "interface"},
+	)
+
+	interfaceReceiveErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "snmp_interface_receive_errors_total",
+			Help: "Inbound packets with errors (ifInErrors)",
+		},
+		[]string{"device", "interface"},
+	)
+
+	interfaceTransmitErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "snmp_interface_transmit_errors_total",
+			Help: "Outbound packets that could not be sent because of errors (ifOutErrors)",
+		},
+		[]string{"device", "interface"},
+	)
+
+	interfaceReceiveDiscards = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "snmp_interface_receive_discards_total",
+			Help: "Inbound packets discarded without errors, e.g. for lack of buffers (ifInDiscards)",
+		},
+		[]string{"device", "interface"},
+	)
+
+	interfaceTransmitDiscards = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "snmp_interface_transmit_discards_total",
+			Help: "Outbound packets discarded without errors, e.g. for lack of buffers (ifOutDiscards)",
+		},
+		[]string{"device", "interface"},
+	)
+
+	wirelessClients = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "snmp_wireless_clients",
+			Help: "Wireless clients associated with the device, from the configured clients OID",
+		},
+		[]string{"device"},
+	)
+)
+
+func registerMetrics() {
+	prometheus.MustRegister(
+		deviceUp,
+		pollDuration,
+		pollErrors,
+		deviceUptime,
+		deviceReboots,
+		interfaceUp,
+		interfaceStatusChanges,
+		interfaceReceiveBytes,
+		interfaceTransmitBytes,
+		interfaceReceiveErrors,
+		interfaceTransmitErrors,
+		interfaceReceiveDiscards,
+		interfaceTransmitDiscards,
+		wirelessClients,
+	)
+}
@@ -0,0 +1,150 @@
+// Package snmpcollector implements the snmp-collector service. It polls
+// interface, error and wireless client counters from the router, switch
+// or access point over SNMP v2c or v3, the device-side evidence the host
+// probes cannot see. It runs standalone via cmd/snmp-collector or inside
+// the combined edge-monitor binary.
+package snmpcollector
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"edge-monitor-app/internal/buildinfo"
+	"edge-monitor-app/internal/config"
+	"edge-monitor-app/internal/health"
+	"edge-monitor-app/internal/pause"
+)
+
+// DefaultAddr is the standalone metrics listen address.
+const DefaultAddr = ":9097"
+
+// requestTimeout bounds each SNMP request; a poll is several requests.
+const requestTimeout = 2 * time.Second
+
+// Service is a configured snmp-collector instance.
+type Service struct {
+	devices  []device
+	states   map[string]*deviceState
+	interval time.Duration
+	// interfaces limits the exported interfaces by name; empty exports
+	// all of them.
+	interfaces map[string]bool
+
+	health *health.Tracker
+}
+
+// New reads configuration from the environment and registers metrics with
+// the default Prometheus registry.
+func New() (*Service, error) {
+	registerMetrics()
+
+	s := &Service{
+		states:   make(map[string]*deviceState),
+		interval: config.Seconds("SNMP_INTERVAL_SECONDS", 15*time.Second),
+	}
+	if names := config.List("SNMP_INTERFACES"); len(names) > 0 {
+		s.interfaces = make(map[string]bool, len(names))
+		for _, n := range names {
+			s.interfaces[n] = true
+		}
+	}
+
+	version := config.String("SNMP_VERSION", "2c")
+	if version != "2c" && version != "3" {
+		return nil, errors.New("SNMP_VERSION must be 2c or 3")
+	}
+	entries := config.List("SNMP_TARGETS")
+	if len(entries) == 0 {
+		entries = []string{"192.168.1.1"}
+	}
+	devices, errs := parseDevices(entries, version)
+	for _, err := range errs {
+		slog.Warn("ignoring SNMP target", "error", err)
+	}
+	community := config.Secret("SNMP_COMMUNITY")
+	if community == "" {
+		community = "public"
+	}
+
+	for _, d := range devices {
+		c := &client{addr: d.addr, community: community, timeout: requestTimeout}
+		if d.community != "" {
+			c.community = d.community
+		}
+		if d.version == "3" {
+			u, err := newUSM(
+				config.String("SNMP_V3_USER", ""),
+				config.String("SNMP_V3_AUTH_PROTOCOL", ""),
+				config.Secret("SNMP_V3_AUTH_PASSWORD"),
+				config.String("SNMP_V3_PRIV_PROTOCOL", ""),
+				config.Secret("SNMP_V3_PRIV_PASSWORD"),
+			)
+			if err != nil {
+				slog.Warn("ignoring SNMP target", "device", d.name, "error", err)
+				continue
+			}
+			c.usm = u
+		}
+		s.devices = append(s.devices, d)
+		s.states[d.name] = newDeviceState(c)
+
+		deviceUp.WithLabelValues(d.name).Set(0)
+		deviceReboots.WithLabelValues(d.name).Add(0)
+	}
+	if len(s.devices) == 0 {
+		return nil, errors.New("no valid SNMP targets")
+	}
+
+	v3 := false
+	for _, d := range s.devices {
+		v3 = v3 || d.version == "3"
+	}
+	pause.Track("snmp-collector")
+	buildinfo.Register("snmp-collector", map[string]bool{
+		"snmpv3":           v3,
+		"interface_filter": s.interfaces != nil,
+	})
+	s.health = health.NewTracker("snmp-collector", s.interval)
+	return s, nil
+}
+
+// Register adds the service's HTTP handlers (other than /metrics) to mux.
+func (s *Service) Register(mux *http.ServeMux) {}
+
+// Health returns the poll loop tracker behind /healthz and /readyz.
+func (s *Service) Health() *health.Tracker { return s.health }
+
+// Run polls every device at start and then every interval until ctx is
+// cancelled.
+func (s *Service) Run(ctx context.Context) error {
+	devices := make([]string, 0, len(s.devices))
+	for _, d := range s.devices {
+		devices = append(devices, d.name+"="+d.addr+"/v"+d.version)
+	}
+	slog.Info("starting snmp-collector",
+		"snmp_targets", devices,
+		"interval", s.interval.String(),
+	)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		start := time.Now()
+		if !pause.Active("snmp-collector") {
+			for _, d := range s.devices {
+				s.pollDevice(ctx, d, s.states[d.name])
+			}
+		}
+		s.health.Cycle(start, s.interval)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package snmpcollector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"time"
+
+	"edge-monitor-app/internal/probe"
+)
+
+// Error classes beyond the probe package's, used as the error_class label.
+const (
+	classAuth = "auth" // SNMPv3 authentication, privacy or engine errors
+	classSNMP = "snmp" // error-status responses and malformed messages
+)
+
+// snmpError is a protocol-level failure.
+type snmpError struct {
+	class string
+	msg   string
+}
+
+func (e *snmpError) Error() string { return e.msg }
+
+// errorClass labels a poll failure: auth or snmp for protocol errors,
+// otherwise the probe package's class (timeout, refused, unreachable, …).
+func errorClass(err error) string {
+	var serr *snmpError
+	if errors.As(err, &serr) {
+		return serr.class
+	}
+	if errors.Is(err, errMalformed) {
+		return classSNMP
+	}
+	return string(probe.Classify(err))
+}
+
+// usmStats report OIDs (RFC 3414), answered by agents in Report PDUs.
+var (
+	usmStatsPrefix           = mustOID("1.3.6.1.6.3.15.1.1")
+	usmStatsNotInTimeWindows = mustOID("1.3.6.1.6.3.15.1.1.2.0")
+	usmStatsUnknownEngineIDs = mustOID("1.3.6.1.6.3.15.1.1.4.0")
+)
+
+var usmStatsNames = map[uint32]string{
+	1: "unsupported security level",
+	2: "not in time window",
+	3: "unknown user name",
+	4: "unknown engine ID",
+	5: "wrong digest (check the auth password)",
+	6: "decryption error (check the privacy password)",
+}
+
+const (
+	// maxRepetitions is the GetBulk batch size; small enough for the
+	// answer to fit one unfragmented datagram on most agents.
+	maxRepetitions = 20
+	// maxWalkRows bounds a walk so a misbehaving agent cannot loop it.
+	maxWalkRows = 10000
+	snmpRetries = 1
+)
+
+// client talks to one agent. It is not safe for concurrent use.
+type client struct {
+	addr      string
+	community string // SNMPv2c
+	usm       *usm   // SNMPv3, nil for v2c
+	timeout   time.Duration
+}
+
+// get fetches single instances.
+func (c *client) get(ctx context.Context, oids ...oid) ([]varbind, error) {
+	p, err := c.request(ctx, pduGetRequest, 0, 0, oids)
+	if err != nil {
+		return nil, err
+	}
+	return p.varbinds, nil
+}
+
+// walk calls fn for every instance in the subtree under root, in order,
+// using GetBulk.
+func (c *client) walk(ctx context.Context, root oid, fn func(varbind)) error {
+	cur := root
+	for rows := 0; ; {
+		p, err := c.request(ctx, pduGetBulk, 0, maxRepetitions, []oid{cur})
+		if err != nil {
+			return err
+		}
+		if len(p.varbinds) == 0 {
+			return nil
+		}
+		for _, vb := range p.varbinds {
+			if vb.tag == tagEndOfMibView || !vb.oid.hasPrefix(root) {
+				return nil
+			}
+			if vb.oid.compare(cur) <= 0 {
+				return &snmpError{class: classSNMP, msg: "agent returned OIDs out of order under " + root.String()}
+			}
+			fn(vb)
+			cur = vb.oid
+			if rows++; rows >= maxWalkRows {
+				return &snmpError{class: classSNMP, msg: "walk of " + root.String() + " exceeded " + fmt.Sprint(maxWalkRows) + " rows"}
+			}
+		}
+	}
+}
+
+// request sends one PDU, retrying once on timeout, and returns the
+// response PDU. SNMPv3 requests discover the agent's engine first and
+// resynchronize engine time when the agent reports it out of window.
+func (c *client) request(ctx context.Context, pduType byte, f1, f2 int, oids []oid) (*pdu, error) {
+	if c.usm != nil && !c.usm.discovered() {
+		if err := c.discover(ctx); err != nil {
+			return nil, err
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		p, err := c.exchange(ctx, pduType, f1, f2, oids, false)
+		if err != nil {
+			if attempt < snmpRetries && probe.IsTimeout(err) && ctx.Err() == nil {
+				continue
+			}
+			return nil, err
+		}
+		if p.typ == pduReport {
+			rerr := reportError(p)
+			if attempt < snmpRetries && len(p.varbinds) > 0 &&
+				(p.varbinds[0].oid.compare(usmStatsNotInTimeWindows) == 0 || p.varbinds[0].oid.compare(usmStatsUnknownEngineIDs) == 0) {
+				// exchange updated the engine boots/time from the report.
+				continue
+			}
+			return nil, rerr
+		}
+		if p.typ != pduResponse {
+			return nil, &snmpError{class: classSNMP, msg: fmt.Sprintf("unexpected PDU type 0x%x", p.typ)}
+		}
+		if p.errorStatus != 0 {
+			return nil, &snmpError{class: classSNMP, msg: fmt.Sprintf("agent answered error status %d (index %d)", p.errorStatus, p.errorIndex)}
+		}
+		return p, nil
+	}
+}
+
+// discover learns the agent's engine ID, boots and time (RFC 3414 4).
+func (c *client) discover(ctx context.Context) error {
+	var err error
+	for attempt := 0; attempt <= snmpRetries; attempt++ {
+		if _, err = c.exchange(ctx, pduGetRequest, 0, 0, nil, true); err == nil || !probe.IsTimeout(err) || ctx.Err() != nil {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if !c.usm.discovered() {
+		return &snmpError{class: classAuth, msg: "agent did not report its engine ID"}
+	}
+	return nil
+}
+
+func reportError(p *pdu) error {
+	if len(p.varbinds) > 0 && p.varbinds[0].oid.hasPrefix(usmStatsPrefix) && len(p.varbinds[0].oid) > len(usmStatsPrefix) {
+		if name, ok := usmStatsNames[p.varbinds[0].oid[len(usmStatsPrefix)]]; ok {
+			return &snmpError{class: classAuth, msg: "agent reported " + name}
+		}
+	}
+	return &snmpError{class: classSNMP, msg: "agent sent a report"}
+}
+
+// exchange sends one message over a fresh UDP socket and waits for the
+// matching response.
+func (c *client) exchange(ctx context.Context, pduType byte, f1, f2 int, oids []oid, discovery bool) (*pdu, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	requestID := rand.Int32()
+	pduBytes := appendPDU(nil, pduType, requestID, f1, f2, oids)
+	var msg []byte
+	if c.usm != nil {
+		var err error
+		if msg, err = c.usm.encode(requestID, pduBytes, discovery); err != nil {
+			return nil, err
+		}
+	} else {
+		body := appendInt(nil, 1) // version 2c
+		body = appendOctets(body, []byte(c.community))
+		msg = appendTLV(nil, tagSequence, append(body, pduBytes...))
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+		// Late answers to earlier requests do not match; keep waiting.
+		p, err := c.decode(buf[:n], requestID)
+		if errors.Is(err, errMismatch) {
+			continue
+		}
+		return p, err
+	}
+}
+
+var errMismatch = errors.New("response does not match the request")
+
+func (c *client) decode(msg []byte, requestID int32) (*pdu, error) {
+	if c.usm == nil {
+		body, _, err := expectTLV(msg, tagSequence)
+		if err != nil {
+			return nil, err
+		}
+		if _, body, err = readInt(body); err != nil { // version
+			return nil, err
+		}
+		if _, body, err = expectTLV(body, tagOctetString); err != nil { // community
+			return nil, err
+		}
+		p, err := decodePDU(body)
+		if err != nil {
+			return nil, err
+		}
+		if p.requestID != requestID {
+			return nil, errMismatch
+		}
+		return p, nil
+	}
+
+	r, err := c.usm.decode(msg)
+	if err != nil {
+		return nil, err
+	}
+	if r.msgID != requestID {
+		return nil, errMismatch
+	}
+	p, err := decodePDU(r.pdu)
+	if err != nil {
+		return nil, err
+	}
+	// Reports about authentication failures come back unauthenticated;
+	// anything else must be authenticated when we authenticate.
+	if c.usm.hash != nil && !r.authenticated && p.typ != pduReport {
+		return nil, &snmpError{class: classAuth, msg: "unauthenticated response"}
+	}
+	if p.typ == pduReport || r.authenticated {
+		c.usm.setEngine(r.engineID, r.boots, r.time)
+	}
+	return p, nil
+}
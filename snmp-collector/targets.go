@@ -0,0 +1,96 @@
+package snmpcollector
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// device is one SNMP agent from SNMP_TARGETS.
+type device struct {
+	// name is the device metric label: name= or the host.
+	name    string
+	addr    string
+	version string // "2c" or "3"
+	// community overrides SNMP_COMMUNITY for v2c.
+	community string
+	// clientsOID counts wireless clients: rows under it (clientsCount) or
+	// the sum of its integer values (clientsSum).
+	clientsOID oid
+	clientsSum bool
+}
+
+// parseDevice parses an SNMP_TARGETS entry of the form
+//
+//	HOST[:PORT] [name=NAME] [version=2c|3] [community=COMMUNITY] [clients_count_oid=OID | clients_sum_oid=OID]
+//
+// e.g. "192.168.1.1 name=router". The port defaults to 161 and the
+// version to defaultVersion.
+func parseDevice(entry, defaultVersion string) (device, error) {
+	fields := strings.Fields(entry)
+	if len(fields) == 0 {
+		return device{}, errors.New("empty target")
+	}
+	host, port, err := net.SplitHostPort(fields[0])
+	if err != nil {
+		host, port = strings.Trim(fields[0], "[]"), "161"
+	}
+	if host == "" {
+		return device{}, errors.New("missing host")
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return device{}, fmt.Errorf("invalid port %q", port)
+	}
+	d := device{name: host, addr: net.JoinHostPort(host, port), version: defaultVersion}
+
+	for _, opt := range fields[1:] {
+		key, value, ok := strings.Cut(opt, "=")
+		if !ok || value == "" {
+			return device{}, fmt.Errorf("invalid option %q", opt)
+		}
+		switch key {
+		case "name":
+			d.name = value
+		case "version":
+			if value != "2c" && value != "3" {
+				return device{}, fmt.Errorf("unsupported version %q (valid: 2c, 3)", value)
+			}
+			d.version = value
+		case "community":
+			d.community = value
+		case "clients_count_oid", "clients_sum_oid":
+			o, err := parseOID(value)
+			if err != nil {
+				return device{}, err
+			}
+			d.clientsOID, d.clientsSum = o, key == "clients_sum_oid"
+		default:
+			return device{}, fmt.Errorf("unknown option %q", key)
+		}
+	}
+	return d, nil
+}
+
+// parseDevices parses SNMP_TARGETS, skipping invalid entries and
+// duplicate names.
+func parseDevices(entries []string, defaultVersion string) ([]device, []error) {
+	var (
+		out  []device
+		errs []error
+	)
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		d, err := parseDevice(entry, defaultVersion)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("SNMP_TARGETS entry %q: %w", entry, err))
+			continue
+		}
+		if !seen[d.name] {
+			seen[d.name] = true
+			out = append(out, d)
+		}
+	}
+	return out, errs
+}
@@ -0,0 +1,417 @@
+package snmpcollector
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+// SNMPv3 msgFlags.
+const (
+	flagAuth       = 0x01
+	flagPriv       = 0x02
+	flagReportable = 0x04
+)
+
+const (
+	securityModelUSM = 3
+	maxMessageSize   = 65507
+	authParamsLen    = 12 // HMAC-MD5-96 and HMAC-SHA-96 truncate to 96 bits
+)
+
+// usm holds one device's SNMPv3 user-based security state (RFC 3414): the
+// credentials, the keys localized to the agent's engine ID and the engine
+// boots/time learned through discovery.
+type usm struct {
+	user string
+	// hash is the authentication hash (nil for noAuthNoPriv); privacy is
+	// "des" or "aes" ("" for no privacy).
+	hash    func() hash.Hash
+	privacy string
+	// authKu and privKu are the password-derived master keys; the
+	// localized keys depend on the engine ID as well.
+	authKu, privKu   []byte
+	authKey, privKey []byte
+
+	engineID []byte
+	boots    int32
+	time     int32
+	timeAt   time.Time
+
+	salt uint64
+}
+
+// newUSM validates the SNMPv3 credentials. An auth password without a
+// protocol uses SHA, and a privacy password without a protocol AES.
+func newUSM(user, authProto, authPass, privProto, privPass string) (*usm, error) {
+	if user == "" {
+		return nil, errors.New("SNMP_V3_USER is required for SNMPv3")
+	}
+	u := &usm{user: user, salt: rand.Uint64()}
+	if authPass != "" {
+		switch strings.ToLower(authProto) {
+		case "", "sha":
+			u.hash = sha1.New
+		case "md5":
+			u.hash = md5.New
+		default:
+			return nil, fmt.Errorf("unsupported SNMPv3 auth protocol %q (valid: md5, sha)", authProto)
+		}
+		if len(authPass) < 8 {
+			return nil, errors.New("SNMPv3 auth password must be at least 8 characters")
+		}
+		u.authKu = passwordToKey(u.hash, authPass)
+	}
+	if privPass != "" {
+		if u.hash == nil {
+			return nil, errors.New("SNMPv3 privacy needs an auth password")
+		}
+		switch strings.ToLower(privProto) {
+		case "", "aes":
+			u.privacy = "aes"
+		case "des":
+			u.privacy = "des"
+		default:
+			return nil, fmt.Errorf("unsupported SNMPv3 privacy protocol %q (valid: aes, des)", privProto)
+		}
+		if len(privPass) < 8 {
+			return nil, errors.New("SNMPv3 privacy password must be at least 8 characters")
+		}
+		u.privKu = passwordToKey(u.hash, privPass)
+	}
+	return u, nil
+}
+
+// passwordToKey derives the master key Ku from a password by hashing it
+// repeated to one megabyte (RFC 3414 A.2).
+func passwordToKey(newHash func() hash.Hash, password string) []byte {
+	h := newHash()
+	buf := make([]byte, 64)
+	pw := []byte(password)
+	for i, n := 0, 0; n < 1<<20; n += len(buf) {
+		for j := range buf {
+			buf[j] = pw[i%len(pw)]
+			i++
+		}
+		h.Write(buf)
+	}
+	return h.Sum(nil)
+}
+
+// localizeKey binds a master key to an engine ID: H(Ku | engineID | Ku).
+func localizeKey(newHash func() hash.Hash, ku, engineID []byte) []byte {
+	h := newHash()
+	h.Write(ku)
+	h.Write(engineID)
+	h.Write(ku)
+	return h.Sum(nil)
+}
+
+func (u *usm) flags() byte {
+	f := byte(flagReportable)
+	if u.hash != nil {
+		f |= flagAuth
+	}
+	if u.privacy != "" {
+		f |= flagPriv
+	}
+	return f
+}
+
+func (u *usm) discovered() bool { return u.engineID != nil }
+
+// setEngine records the agent's engine ID, boots and time, localizing the
+// keys when the engine ID is new.
+func (u *usm) setEngine(engineID []byte, boots, engineTime int32) {
+	if string(engineID) != string(u.engineID) {
+		u.engineID = append([]byte(nil), engineID...)
+		if u.hash != nil {
+			u.authKey = localizeKey(u.hash, u.authKu, engineID)
+		}
+		if u.privacy != "" {
+			u.privKey = localizeKey(u.hash, u.privKu, engineID)
+		}
+	}
+	u.boots, u.time, u.timeAt = boots, engineTime, time.Now()
+}
+
+// engineTime estimates the agent's current engine time.
+func (u *usm) engineTime() int32 {
+	return u.time + int32(time.Since(u.timeAt)/time.Second)
+}
+
+// encode wraps a PDU in an SNMPv3 message. discovery sends an
+// unauthenticated probe with an empty engine ID and user, which agents
+// answer with a report carrying their engine ID, boots and time.
+func (u *usm) encode(msgID int32, pduBytes []byte, discovery bool) ([]byte, error) {
+	flags := u.flags()
+	engineID, user := u.engineID, u.user
+	var boots, engineTime int32
+	if discovery {
+		flags = flagReportable
+		engineID, user = nil, ""
+	} else {
+		boots, engineTime = u.boots, u.engineTime()
+	}
+
+	scoped := appendOctets(nil, engineID) // contextEngineID
+	scoped = appendOctets(scoped, nil)    // contextName
+	scoped = appendTLV(nil, tagSequence, append(scoped, pduBytes...))
+
+	data := scoped
+	var privParams []byte
+	if flags&flagPriv != 0 {
+		enc, salt, err := u.encrypt(scoped, boots, engineTime)
+		if err != nil {
+			return nil, err
+		}
+		data, privParams = appendOctets(nil, enc), salt
+	}
+	var authParams []byte
+	if flags&flagAuth != 0 {
+		authParams = make([]byte, authParamsLen)
+	}
+
+	// Build the security parameters and track where the auth parameters
+	// land in the final message, so the MAC can be written in place.
+	sp := appendOctets(nil, engineID)
+	sp = appendInt(sp, int64(boots))
+	sp = appendInt(sp, int64(engineTime))
+	sp = appendOctets(sp, []byte(user))
+	authOff := len(sp) + 2 // tag and one-byte length
+	sp = appendOctets(sp, authParams)
+	sp = appendOctets(sp, privParams)
+	spSeq := appendTLV(nil, tagSequence, sp)
+	authOff += len(spSeq) - len(sp)
+	spOctets := appendOctets(nil, spSeq)
+	authOff += len(spOctets) - len(spSeq)
+
+	var global []byte
+	global = appendInt(global, int64(msgID))
+	global = appendInt(global, maxMessageSize)
+	global = appendOctets(global, []byte{flags})
+	global = appendInt(global, securityModelUSM)
+
+	body := appendInt(nil, 3)
+	body = appendTLV(body, tagSequence, global)
+	authOff += len(body)
+	body = append(body, spOctets...)
+	body = append(body, data...)
+	msg := appendTLV(nil, tagSequence, body)
+	authOff += len(msg) - len(body)
+
+	if flags&flagAuth != 0 {
+		copy(msg[authOff:authOff+authParamsLen], u.mac(msg))
+	}
+	return msg, nil
+}
+
+func (u *usm) mac(msg []byte) []byte {
+	m := hmac.New(u.hash, u.authKey)
+	m.Write(msg)
+	return m.Sum(nil)[:authParamsLen]
+}
+
+// v3Response is a decoded SNMPv3 message.
+type v3Response struct {
+	msgID         int32
+	authenticated bool
+	engineID      []byte
+	boots, time   int32
+	pdu           []byte
+}
+
+// decode unwraps an SNMPv3 message, verifying its MAC and decrypting it
+// when the flags say so.
+func (u *usm) decode(msg []byte) (*v3Response, error) {
+	body, _, err := expectTLV(msg, tagSequence)
+	if err != nil {
+		return nil, err
+	}
+	version, body, err := readInt(body)
+	if err != nil {
+		return nil, err
+	}
+	if version != 3 {
+		return nil, errMalformed
+	}
+	global, body, err := expectTLV(body, tagSequence)
+	if err != nil {
+		return nil, err
+	}
+	r := &v3Response{}
+	msgID, global, err := readInt(global)
+	if err != nil {
+		return nil, err
+	}
+	r.msgID = int32(msgID)
+	if _, global, err = readInt(global); err != nil { // msgMaxSize
+		return nil, err
+	}
+	flagBytes, _, err := expectTLV(global, tagOctetString)
+	if err != nil || len(flagBytes) != 1 {
+		return nil, errMalformed
+	}
+	flags := flagBytes[0]
+
+	spOctets, data, err := expectTLV(body, tagOctetString)
+	if err != nil {
+		return nil, err
+	}
+	sp, _, err := expectTLV(spOctets, tagSequence)
+	if err != nil {
+		return nil, err
+	}
+	if r.engineID, sp, err = expectTLV(sp, tagOctetString); err != nil {
+		return nil, err
+	}
+	var n int64
+	if n, sp, err = readInt(sp); err != nil {
+		return nil, err
+	}
+	r.boots = int32(n)
+	if n, sp, err = readInt(sp); err != nil {
+		return nil, err
+	}
+	r.time = int32(n)
+	if _, sp, err = expectTLV(sp, tagOctetString); err != nil { // user name
+		return nil, err
+	}
+	authParams, sp, err := expectTLV(sp, tagOctetString)
+	if err != nil {
+		return nil, err
+	}
+	privParams, _, err := expectTLV(sp, tagOctetString)
+	if err != nil {
+		return nil, err
+	}
+
+	if flags&flagAuth != 0 {
+		if u.hash == nil || len(authParams) != authParamsLen {
+			return nil, &snmpError{class: classAuth, msg: "unexpected authenticated response"}
+		}
+		// authParams is a slice of msg; zero it in a copy and recompute.
+		off := cap(msg) - cap(authParams)
+		check := append([]byte(nil), msg...)
+		clear(check[off : off+authParamsLen])
+		if !hmac.Equal(u.mac(check), authParams) {
+			return nil, &snmpError{class: classAuth, msg: "response failed authentication"}
+		}
+		r.authenticated = true
+	}
+
+	var scoped []byte
+	if flags&flagPriv != 0 {
+		enc, _, err := expectTLV(data, tagOctetString)
+		if err != nil {
+			return nil, err
+		}
+		plain, err := u.decrypt(enc, privParams, r.boots, r.time)
+		if err != nil {
+			return nil, err
+		}
+		// DES padding may trail the scoped PDU.
+		if scoped, _, err = expectTLV(plain, tagSequence); err != nil {
+			return nil, &snmpError{class: classAuth, msg: "response failed decryption"}
+		}
+	} else if scoped, _, err = expectTLV(data, tagSequence); err != nil {
+		return nil, err
+	}
+	if _, scoped, err = expectTLV(scoped, tagOctetString); err != nil { // contextEngineID
+		return nil, err
+	}
+	if _, scoped, err = expectTLV(scoped, tagOctetString); err != nil { // contextName
+		return nil, err
+	}
+	r.pdu = scoped
+	return r, nil
+}
+
+// encrypt encrypts a scoped PDU and returns it with the salt sent as
+// msgPrivacyParameters.
+func (u *usm) encrypt(plain []byte, boots, engineTime int32) ([]byte, []byte, error) {
+	u.salt++
+	salt := make([]byte, 8)
+	switch u.privacy {
+	case "des":
+		// CBC-DES (RFC 3414 8.1.1.1): the salt is engine boots and a local
+		// counter, XORed into the pre-IV from the key.
+		binary.BigEndian.PutUint32(salt, uint32(boots))
+		binary.BigEndian.PutUint32(salt[4:], uint32(u.salt))
+		block, err := des.NewCipher(u.privKey[:8])
+		if err != nil {
+			return nil, nil, err
+		}
+		iv := make([]byte, des.BlockSize)
+		for i := range iv {
+			iv[i] = u.privKey[8+i] ^ salt[i]
+		}
+		padded := append([]byte(nil), plain...)
+		if r := len(padded) % des.BlockSize; r != 0 {
+			padded = append(padded, make([]byte, des.BlockSize-r)...)
+		}
+		out := make([]byte, len(padded))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, padded)
+		return out, salt, nil
+	default:
+		// CFB128-AES-128 (RFC 3826): the IV is engine boots, engine time
+		// and a 64-bit local salt.
+		binary.BigEndian.PutUint64(salt, u.salt)
+		block, err := aes.NewCipher(u.privKey[:16])
+		if err != nil {
+			return nil, nil, err
+		}
+		out := make([]byte, len(plain))
+		cipher.NewCFBEncrypter(block, aesIV(boots, engineTime, salt)).XORKeyStream(out, plain)
+		return out, salt, nil
+	}
+}
+
+func (u *usm) decrypt(enc, salt []byte, boots, engineTime int32) ([]byte, error) {
+	if len(salt) != 8 {
+		return nil, &snmpError{class: classAuth, msg: "invalid privacy parameters"}
+	}
+	switch u.privacy {
+	case "des":
+		if len(enc)%des.BlockSize != 0 {
+			return nil, &snmpError{class: classAuth, msg: "response failed decryption"}
+		}
+		block, err := des.NewCipher(u.privKey[:8])
+		if err != nil {
+			return nil, err
+		}
+		iv := make([]byte, des.BlockSize)
+		for i := range iv {
+			iv[i] = u.privKey[8+i] ^ salt[i]
+		}
+		out := make([]byte, len(enc))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, enc)
+		return out, nil
+	case "aes":
+		block, err := aes.NewCipher(u.privKey[:16])
+		if err != nil {
+			return nil, err
+		}
+		out := make([]byte, len(enc))
+		cipher.NewCFBDecrypter(block, aesIV(boots, engineTime, salt)).XORKeyStream(out, enc)
+		return out, nil
+	}
+	return nil, &snmpError{class: classAuth, msg: "unexpected encrypted response"}
+}
+
+func aesIV(boots, engineTime int32, salt []byte) []byte {
+	iv := make([]byte, 0, aes.BlockSize)
+	iv = binary.BigEndian.AppendUint32(iv, uint32(boots))
+	iv = binary.BigEndian.AppendUint32(iv, uint32(engineTime))
+	return append(iv, salt...)
+}
@@ -0,0 +1,7 @@
+{{- define "starlink-collector.name" -}}
+starlink-collector
+{{- end -}}
+
+{{- define "starlink-collector.fullname" -}}
+{{ include "starlink-collector.name" . }}
+{{- end -}}
@@ -0,0 +1,149 @@
+package starlinkcollector
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"time"
+
+	"edge-monitor-app/internal/metrics"
+)
+
+// dishState is what the dish's polls carry over to the next one.
+type dishState struct {
+	up         bool
+	info       []string
+	obstructed bool
+	alerts     map[string]bool
+	// outage is the cause of the outage in progress, or "" between outages.
+	outage string
+	// lastOutage is the start of the newest outage counted; outages in the
+	// history buffer that start later are new. seenOutage is false until
+	// the history has been read once, so outages from before the collector
+	// started are not counted.
+	lastOutage int64
+	seenOutage bool
+}
+
+// poll reads the dish and updates its metrics. A dish that stops
+// answering keeps its last values with starlink_up 0.
+func (s *Service) poll(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, pollTimeout)
+	defer cancel()
+
+	start := time.Now()
+	st, err := s.dish.status(ctx)
+	var history []dishOutage
+	var historyErr error
+	if err == nil {
+		history, historyErr = s.dish.outages(ctx)
+	}
+	pollDuration.Set(time.Since(start).Seconds())
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		pollErrors.Inc()
+		dishUp.Set(0)
+		s.state.up = false
+		slog.Warn("starlink poll failed", "addr", s.dish.addr, "error", err)
+		return
+	}
+	dishUp.Set(1)
+	if !s.state.up {
+		slog.Info("starlink dish answering", "addr", s.dish.addr, "id", st.id, "software_version", st.software)
+		s.state.up = true
+	}
+	s.update(st)
+
+	switch {
+	case errors.Is(historyErr, context.Canceled):
+	case historyErr != nil:
+		pollErrors.Inc()
+		slog.Warn("starlink history not readable", "addr", s.dish.addr, "error", historyErr)
+	default:
+		s.countOutages(history)
+	}
+}
+
+func (s *Service) update(st dishStatus) {
+	info := []string{st.id, st.hardware, st.software, st.country}
+	if !slices.Equal(info, s.state.info) {
+		dishInfo.Reset()
+		dishInfo.WithLabelValues(info...).Set(1)
+		if s.state.info != nil && s.state.info[2] != st.software {
+			slog.Info("starlink dish software changed", "from", s.state.info[2], "to", st.software)
+		}
+		s.state.info = info
+	}
+	dishUptime.Set(st.uptime.Seconds())
+
+	obstructionFraction.Set(st.obstructionFraction)
+	obstructed.Set(metrics.BoolToFloat(st.obstructed))
+	if st.obstructed != s.state.obstructed {
+		if st.obstructed {
+			slog.Warn("starlink dish obstructed", "fraction_obstructed", st.obstructionFraction)
+		} else {
+			slog.Info("starlink dish no longer obstructed", "fraction_obstructed", st.obstructionFraction)
+		}
+		s.state.obstructed = st.obstructed
+	}
+
+	popPingDropRate.Set(st.popPingDropRate)
+	popPingLatency.Set(st.popPingLatency.Seconds())
+	downlinkThroughput.Set(st.downlinkBps)
+	uplinkThroughput.Set(st.uplinkBps)
+
+	for name, on := range st.alerts {
+		dishAlert.WithLabelValues(name).Set(metrics.BoolToFloat(on))
+		if on == s.state.alerts[name] {
+			continue
+		}
+		if on {
+			slog.Warn("starlink dish alert raised", "alert", name)
+		} else if s.state.alerts != nil {
+			slog.Info("starlink dish alert cleared", "alert", name)
+		}
+	}
+	s.state.alerts = st.alerts
+
+	cause := ""
+	if st.outage != nil {
+		cause = st.outage.cause
+	}
+	outageActive.Set(metrics.BoolToFloat(cause != ""))
+	switch {
+	case cause == s.state.outage:
+	case cause != "":
+		slog.Warn("starlink outage started", "cause", cause)
+	default:
+		slog.Info("starlink outage ended", "cause", s.state.outage)
+	}
+	s.state.outage = cause
+}
+
+// countOutages counts the outages in the dish's history that started
+// since the previous poll. The history records outages the poll interval
+// is too coarse to see in progress.
+func (s *Service) countOutages(history []dishOutage) {
+	newest := s.state.lastOutage
+	for _, o := range history {
+		if o.start > newest {
+			newest = o.start
+		}
+		if !s.state.seenOutage || o.start <= s.state.lastOutage {
+			continue
+		}
+		outages.WithLabelValues(o.cause).Inc()
+		outageSeconds.WithLabelValues(o.cause).Add(o.duration.Seconds())
+		slog.Warn("starlink outage", "cause", o.cause, "duration", o.duration.String(), "did_switch", o.didSwitch)
+	}
+	if !s.state.seenOutage {
+		for _, cause := range outageCauses {
+			outages.WithLabelValues(cause).Add(0)
+			outageSeconds.WithLabelValues(cause).Add(0)
+		}
+	}
+	s.state.lastOutage, s.state.seenOutage = newest, true
+}
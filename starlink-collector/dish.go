@@ -0,0 +1,405 @@
+package starlinkcollector
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// The dish serves the SpaceX.API.Device.Device service over plaintext
+// HTTP/2 (h2c). Its protobuf schema is not published; the field numbers
+// below are the ones the dish's server reflection reports
+// (spacex/api/device/device.proto and dish.proto).
+const handlePath = "/SpaceX.API.Device.Device/Handle"
+
+// Protobuf wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// Field numbers of Request, Response and the dish messages.
+const (
+	fieldRequestGetStatus  = 1004
+	fieldRequestGetHistory = 1007
+
+	fieldResponseDishStatus  = 2004
+	fieldResponseDishHistory = 2006
+
+	fieldStatusDeviceInfo    = 1
+	fieldStatusDeviceState   = 2
+	fieldStatusPopDropRate   = 1003
+	fieldStatusObstruction   = 1004
+	fieldStatusAlerts        = 1005
+	fieldStatusDownlinkBps   = 1007
+	fieldStatusUplinkBps     = 1008
+	fieldStatusPopLatencyMs  = 1009
+	fieldStatusOutage        = 1014
+	fieldDeviceInfoID        = 1
+	fieldDeviceInfoHardware  = 2
+	fieldDeviceInfoSoftware  = 3
+	fieldDeviceInfoCountry   = 4
+	fieldDeviceStateUptime   = 1
+	fieldObstructionFraction = 1
+	fieldObstructionCurrent  = 5
+
+	fieldHistoryOutages = 1009
+
+	fieldOutageCause    = 1
+	fieldOutageStart    = 2
+	fieldOutageDuration = 3
+	fieldOutageSwitch   = 4
+)
+
+// dishAlerts names the boolean fields of DishAlerts. Alerts a newer
+// firmware adds are ignored until they are named here.
+var dishAlerts = map[int]string{
+	1:  "motors_stuck",
+	2:  "thermal_shutdown",
+	3:  "thermal_throttle",
+	4:  "unexpected_location",
+	5:  "mast_not_near_vertical",
+	6:  "slow_ethernet_speeds",
+	7:  "roaming",
+	8:  "install_pending",
+	9:  "is_heating",
+	10: "power_supply_thermal_throttle",
+	11: "is_power_save_idle",
+}
+
+// outageCauses names the values of DishOutage.Cause.
+var outageCauses = []string{
+	"unknown",
+	"booting",
+	"stowed",
+	"thermal_shutdown",
+	"no_schedule",
+	"no_sats",
+	"obstructed",
+	"no_downlink",
+	"no_pings",
+	"actuator_activity",
+	"cable_test",
+	"sleeping",
+}
+
+func outageCause(v uint64) string {
+	if v < uint64(len(outageCauses)) {
+		return outageCauses[v]
+	}
+	return fmt.Sprintf("cause_%d", v)
+}
+
+// dishStatus is the part of the dish's status the collector exports.
+// Protobuf omits zero values, so a missing field reads as zero.
+type dishStatus struct {
+	id, hardware, software, country string
+	uptime                          time.Duration
+	obstructionFraction             float64
+	obstructed                      bool
+	popPingDropRate                 float64
+	popPingLatency                  time.Duration
+	downlinkBps, uplinkBps          float64
+	alerts                          map[string]bool
+	// outage is the outage in progress, if any.
+	outage *dishOutage
+}
+
+// dishOutage is one outage of the satellite link. start is the dish's
+// own timestamp, only used to tell outages apart.
+type dishOutage struct {
+	cause     string
+	start     int64
+	duration  time.Duration
+	didSwitch bool
+}
+
+// dishClient calls the dish's gRPC API.
+type dishClient struct {
+	addr   string
+	client *http.Client
+}
+
+func newDishClient(addr string) *dishClient {
+	return &dishClient{
+		addr: addr,
+		client: &http.Client{
+			Transport: &http2.Transport{
+				AllowHTTP: true,
+				DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, network, addr)
+				},
+			},
+		},
+	}
+}
+
+func (c *dishClient) close() { c.client.CloseIdleConnections() }
+
+// status asks the dish for its status.
+func (c *dishClient) status(ctx context.Context) (dishStatus, error) {
+	msg, err := c.handle(ctx, fieldRequestGetStatus, fieldResponseDishStatus)
+	if err != nil {
+		return dishStatus{}, fmt.Errorf("get_status: %w", err)
+	}
+	st, err := decodeStatus(msg)
+	if err != nil {
+		return dishStatus{}, fmt.Errorf("get_status: %w", err)
+	}
+	return st, nil
+}
+
+// outages asks the dish for the outages in its history buffer, which
+// holds the last several hours.
+func (c *dishClient) outages(ctx context.Context) ([]dishOutage, error) {
+	msg, err := c.handle(ctx, fieldRequestGetHistory, fieldResponseDishHistory)
+	if err != nil {
+		return nil, fmt.Errorf("get_history: %w", err)
+	}
+	var list []dishOutage
+	err = protoFields(msg, func(field, wire int, _ uint64, data []byte) error {
+		if field != fieldHistoryOutages || wire != wireBytes {
+			return nil
+		}
+		o, err := decodeOutage(data)
+		if err != nil {
+			return err
+		}
+		list = append(list, o)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get_history: %w", err)
+	}
+	return list, nil
+}
+
+// handle sends a Request carrying the empty request message in field
+// request and returns the message in field response of the Response.
+func (c *dishClient) handle(ctx context.Context, request, response int) ([]byte, error) {
+	msg := appendTag(nil, request, wireBytes)
+	msg = append(msg, 0)
+	// Length-prefixed message: compressed flag, then big-endian length.
+	framed := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(framed[1:], uint32(len(msg)))
+	framed = append(framed, msg...)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+c.addr+handlePath, bytes.NewReader(framed))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("User-Agent", "edge-monitor-app")
+	req.Header.Set("TE", "trailers")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("dish answered %s", resp.Status)
+	}
+	// Errors come as grpc-status in the trailers, or in the headers of a
+	// trailers-only response.
+	status, message := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	if status != "" && status != "0" {
+		return nil, fmt.Errorf("dish answered gRPC status %s: %s", status, message)
+	}
+	if len(body) < 5 {
+		return nil, errors.New("dish answered without a message")
+	}
+	if body[0] != 0 {
+		return nil, errors.New("dish answered with a compressed message")
+	}
+	n := binary.BigEndian.Uint32(body[1:5])
+	if uint64(len(body)-5) < uint64(n) {
+		return nil, errors.New("dish answered with a truncated message")
+	}
+
+	var out []byte
+	found := false
+	err = protoFields(body[5:5+n], func(field, wire int, _ uint64, data []byte) error {
+		if field == response && wire == wireBytes {
+			out, found = data, true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("dish answered without field %d; not a dish?", response)
+	}
+	return out, nil
+}
+
+func decodeStatus(msg []byte) (dishStatus, error) {
+	st := dishStatus{alerts: make(map[string]bool, len(dishAlerts))}
+	for _, name := range dishAlerts {
+		st.alerts[name] = false
+	}
+	err := protoFields(msg, func(field, wire int, v uint64, data []byte) error {
+		switch field {
+		case fieldStatusDeviceInfo:
+			return protoFields(data, func(field, wire int, _ uint64, data []byte) error {
+				switch field {
+				case fieldDeviceInfoID:
+					st.id = string(data)
+				case fieldDeviceInfoHardware:
+					st.hardware = string(data)
+				case fieldDeviceInfoSoftware:
+					st.software = string(data)
+				case fieldDeviceInfoCountry:
+					st.country = string(data)
+				}
+				return nil
+			})
+		case fieldStatusDeviceState:
+			return protoFields(data, func(field, wire int, v uint64, _ []byte) error {
+				if field == fieldDeviceStateUptime && wire == wireVarint {
+					st.uptime = time.Duration(v) * time.Second
+				}
+				return nil
+			})
+		case fieldStatusPopDropRate:
+			st.popPingDropRate = protoFloat(wire, v)
+		case fieldStatusPopLatencyMs:
+			st.popPingLatency = time.Duration(protoFloat(wire, v) * float64(time.Millisecond))
+		case fieldStatusDownlinkBps:
+			st.downlinkBps = protoFloat(wire, v)
+		case fieldStatusUplinkBps:
+			st.uplinkBps = protoFloat(wire, v)
+		case fieldStatusObstruction:
+			return protoFields(data, func(field, wire int, v uint64, _ []byte) error {
+				switch field {
+				case fieldObstructionFraction:
+					st.obstructionFraction = protoFloat(wire, v)
+				case fieldObstructionCurrent:
+					st.obstructed = v != 0
+				}
+				return nil
+			})
+		case fieldStatusAlerts:
+			return protoFields(data, func(field, wire int, v uint64, _ []byte) error {
+				if name, ok := dishAlerts[field]; ok && wire == wireVarint {
+					st.alerts[name] = v != 0
+				}
+				return nil
+			})
+		case fieldStatusOutage:
+			o, err := decodeOutage(data)
+			if err != nil {
+				return err
+			}
+			st.outage = &o
+		}
+		return nil
+	})
+	return st, err
+}
+
+func decodeOutage(msg []byte) (dishOutage, error) {
+	o := dishOutage{cause: outageCause(0)}
+	err := protoFields(msg, func(field, wire int, v uint64, _ []byte) error {
+		if wire != wireVarint {
+			return nil
+		}
+		switch field {
+		case fieldOutageCause:
+			o.cause = outageCause(v)
+		case fieldOutageStart:
+			o.start = int64(v)
+		case fieldOutageDuration:
+			o.duration = time.Duration(v)
+		case fieldOutageSwitch:
+			o.didSwitch = v != 0
+		}
+		return nil
+	})
+	return o, err
+}
+
+// protoFields calls fn for each field of a protobuf message: v holds a
+// varint or fixed-width value, data a length-delimited one.
+func protoFields(b []byte, fn func(field, wire int, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errors.New("malformed protobuf tag")
+		}
+		b = b[n:]
+		field, wire := int(tag>>3), int(tag&7)
+		var v uint64
+		var data []byte
+		switch wire {
+		case wireVarint:
+			v, n = binary.Uvarint(b)
+			if n <= 0 {
+				return fmt.Errorf("malformed varint in field %d", field)
+			}
+			b = b[n:]
+		case wireFixed64:
+			if len(b) < 8 {
+				return fmt.Errorf("truncated field %d", field)
+			}
+			v, b = binary.LittleEndian.Uint64(b), b[8:]
+		case wireFixed32:
+			if len(b) < 4 {
+				return fmt.Errorf("truncated field %d", field)
+			}
+			v, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return fmt.Errorf("truncated field %d", field)
+			}
+			data, b = b[n:n+int(l)], b[n+int(l):]
+		default:
+			return fmt.Errorf("unsupported wire type %d in field %d", wire, field)
+		}
+		if err := fn(field, wire, v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// protoFloat reads a float field, which the dish encodes as fixed32. The
+// float32 is widened through its shortest decimal form so 0.02 exports as
+// 0.02 rather than 0.019999999552965164.
+func protoFloat(wire int, v uint64) float64 {
+	switch wire {
+	case wireFixed32:
+		f, _ := strconv.ParseFloat(strconv.FormatFloat(float64(math.Float32frombits(uint32(v))), 'g', -1, 32), 64)
+		return f
+	case wireFixed64:
+		return math.Float64frombits(v)
+	}
+	return 0
+}
+
+func appendTag(b []byte, field, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wire))
+}
@@ -0,0 +1,134 @@
+package starlinkcollector
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	dishUp = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "starlink_up",
+			Help: "1 if the dish answered the last poll",
+		},
+	)
+
+	pollDuration = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "starlink_poll_duration_seconds",
+			Help: "Duration of the last poll of the dish",
+		},
+	)
+
+	pollErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "starlink_poll_errors_total",
+			Help: "Polls that failed to read the dish",
+		},
+	)
+
+	dishInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "starlink_dish_info",
+			Help: "Dish identity and software version, always 1",
+		},
+		[]string{"id", "hardware_version", "software_version", "country"},
+	)
+
+	dishUptime = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "starlink_dish_uptime_seconds",
+			Help: "Time since the dish booted",
+		},
+	)
+
+	obstructionFraction = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "starlink_obstruction_fraction",
+			Help: "Fraction of the dish's view of the sky it has found obstructed, 0 to 1",
+		},
+	)
+
+	obstructed = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "starlink_obstructed",
+			Help: "1 if an obstruction currently blocks the dish",
+		},
+	)
+
+	popPingDropRate = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "starlink_pop_ping_drop_rate",
+			Help: "Fraction of the dish's pings to its Starlink point of presence that were lost, 0 to 1",
+		},
+	)
+
+	popPingLatency = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "starlink_pop_ping_latency_seconds",
+			Help: "Round-trip time of the dish's pings to its Starlink point of presence",
+		},
+	)
+
+	downlinkThroughput = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "starlink_downlink_throughput_bits_per_second",
+			Help: "Downlink throughput the dish measures",
+		},
+	)
+
+	uplinkThroughput = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "starlink_uplink_throughput_bits_per_second",
+			Help: "Uplink throughput the dish measures",
+		},
+	)
+
+	dishAlert = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "starlink_alert",
+			Help: "1 while the dish raises the alert (motors_stuck, thermal_throttle, ...)",
+		},
+		[]string{"alert"},
+	)
+
+	outageActive = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "starlink_outage_active",
+			Help: "1 while the dish reports an outage of the satellite link",
+		},
+	)
+
+	outages = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "starlink_outages_total",
+			Help: "Outages of the satellite link the dish recorded, by cause (obstructed, no_sats, ...)",
+		},
+		[]string{"cause"},
+	)
+
+	outageSeconds = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "starlink_outage_seconds_total",
+			Help: "Total duration of the outages the dish recorded, by cause",
+		},
+		[]string{"cause"},
+	)
+)
+
+func registerMetrics() {
+	prometheus.MustRegister(
+		dishUp,
+		pollDuration,
+		pollErrors,
+		dishInfo,
+		dishUptime,
+		obstructionFraction,
+		obstructed,
+		popPingDropRate,
+		popPingLatency,
+		downlinkThroughput,
+		uplinkThroughput,
+		dishAlert,
+		outageActive,
+		outages,
+		outageSeconds,
+	)
+}
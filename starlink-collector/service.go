@@ -0,0 +1,90 @@
+// Package starlinkcollector implements the starlink-collector service. It
+// polls the Starlink dish's local gRPC API for the state of the satellite
+// link (sky obstruction, ping drop to the point of presence, latency and
+// throughput, alerts and outages) so a Starlink uplink has the same
+// upstream visibility as a wired one. It runs standalone via
+// cmd/starlink-collector or inside the combined edge-monitor binary.
+package starlinkcollector
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"edge-monitor-app/internal/buildinfo"
+	"edge-monitor-app/internal/config"
+	"edge-monitor-app/internal/health"
+	"edge-monitor-app/internal/pause"
+)
+
+// DefaultAddr is the standalone metrics listen address.
+const DefaultAddr = ":9099"
+
+// pollTimeout bounds one poll of the dish.
+const pollTimeout = 10 * time.Second
+
+// Service is a configured starlink-collector instance.
+type Service struct {
+	dish     *dishClient
+	interval time.Duration
+	state    dishState
+
+	health *health.Tracker
+}
+
+// New reads configuration from the environment and registers metrics with
+// the default Prometheus registry.
+func New() (*Service, error) {
+	registerMetrics()
+
+	s := &Service{
+		dish:     newDishClient(config.String("STARLINK_ADDR", "192.168.100.1:9200")),
+		interval: config.Seconds("STARLINK_INTERVAL_SECONDS", 10*time.Second),
+	}
+	if s.dish.addr == "" {
+		return nil, errors.New("STARLINK_ADDR is required")
+	}
+	if s.interval <= 0 {
+		return nil, errors.New("STARLINK_INTERVAL_SECONDS must be positive")
+	}
+
+	pause.Track("starlink-collector")
+	buildinfo.Register("starlink-collector", nil)
+	s.health = health.NewTracker("starlink-collector", s.interval)
+	return s, nil
+}
+
+// Register adds the service's HTTP handlers (other than /metrics) to mux.
+func (s *Service) Register(mux *http.ServeMux) {}
+
+// Health returns the poll loop tracker behind /healthz and /readyz.
+func (s *Service) Health() *health.Tracker { return s.health }
+
+// Run polls the dish at start and then every interval until ctx is
+// cancelled.
+func (s *Service) Run(ctx context.Context) error {
+	slog.Info("starting starlink-collector",
+		"addr", s.dish.addr,
+		"interval", s.interval.String(),
+	)
+	defer s.dish.close()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		start := time.Now()
+		if !pause.Active("starlink-collector") {
+			s.poll(ctx)
+		}
+		s.health.Cycle(start, s.interval)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
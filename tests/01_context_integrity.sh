@@ -17,6 +17,10 @@ required_files=(
   "$ROOT_DIR/tests/13_jitter_probe_metrics.sh"
   "$ROOT_DIR/tests/14_gateway_monitor_metrics.sh"
   "$ROOT_DIR/tests/15_alert_receiver_metrics.sh"
+  "$ROOT_DIR/tests/16_path_monitor_metrics.sh"
+  "$ROOT_DIR/tests/17_snmp_collector_metrics.sh"
+  "$ROOT_DIR/tests/18_modem_collector_metrics.sh"
+  "$ROOT_DIR/tests/19_starlink_collector_metrics.sh"
 )
 
 services=(
@@ -24,6 +28,10 @@ services=(
   dns-probe
   jitter-probe
   gateway-monitor
+  path-monitor
+  snmp-collector
+  modem-collector
+  starlink-collector
   alert-receiver
 )
 
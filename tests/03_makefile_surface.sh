@@ -2,7 +2,7 @@
 set -euo pipefail
 
 ROOT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")/.." && pwd)"
-services=(wifi-probe dns-probe jitter-probe gateway-monitor alert-receiver)
+services=(wifi-probe dns-probe jitter-probe gateway-monitor path-monitor snmp-collector modem-collector starlink-collector alert-receiver edge-monitor)
 
 required_make_vars=(
   "IMAGE_TAG"
@@ -2,7 +2,7 @@
 set -euo pipefail
 
 ROOT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")/.." && pwd)"
-services=(wifi-probe dns-probe jitter-probe gateway-monitor alert-receiver)
+services=(wifi-probe dns-probe jitter-probe gateway-monitor path-monitor snmp-collector modem-collector starlink-collector alert-receiver edge-monitor)
 
 for svc in "${services[@]}"; do
   values="$ROOT_DIR/$svc/charts/$svc/values.yaml"
@@ -36,7 +36,7 @@ grep -qF 'sigv4 {' "$ROOT_DIR/plans/examples/edge-metrics-forwarder.alloy" || {
   exit 1
 }
 
-for svc in wifi-probe dns-probe jitter-probe gateway-monitor alert-receiver; do
+for svc in wifi-probe dns-probe jitter-probe gateway-monitor path-monitor snmp-collector modem-collector starlink-collector alert-receiver; do
   grep -qF "$svc.$svc.svc.cluster.local" "$ROOT_DIR/plans/examples/edge-metrics-forwarder.alloy" || {
     printf "Alloy example missing scrape target for %s\n" "$svc" >&2
     exit 1
@@ -0,0 +1,18 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+ROOT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")/.." && pwd)"
+# shellcheck source=tests/lib/cluster_common.sh
+source "$ROOT_DIR/tests/lib/cluster_common.sh"
+
+skip_unless_cluster_tests "path-monitor metrics test"
+init_kubectl
+
+wait_for_deployment "path-monitor" "path-monitor"
+svc="$(resolve_service_name "path-monitor" "path-monitor")"
+payload="$(fetch_metrics_payload "path-monitor" "$svc" "9096")"
+
+assert_metric_present "$payload" "route_changes_total"
+assert_metric_present "$payload" "path_trace_errors_total"
+
+printf "path-monitor metrics test passed.\n"
@@ -0,0 +1,18 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+ROOT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")/.." && pwd)"
+# shellcheck source=tests/lib/cluster_common.sh
+source "$ROOT_DIR/tests/lib/cluster_common.sh"
+
+skip_unless_cluster_tests "snmp-collector metrics test"
+init_kubectl
+
+wait_for_deployment "snmp-collector" "snmp-collector"
+svc="$(resolve_service_name "snmp-collector" "snmp-collector")"
+payload="$(fetch_metrics_payload "snmp-collector" "$svc" "9097")"
+
+assert_metric_present "$payload" "snmp_up"
+assert_metric_present "$payload" "snmp_device_reboots_total"
+
+printf "snmp-collector metrics test passed.\n"
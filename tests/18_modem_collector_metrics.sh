@@ -0,0 +1,18 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+ROOT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")/.." && pwd)"
+# shellcheck source=tests/lib/cluster_common.sh
+source "$ROOT_DIR/tests/lib/cluster_common.sh"
+
+skip_unless_cluster_tests "modem-collector metrics test"
+init_kubectl
+
+wait_for_deployment "modem-collector" "modem-collector"
+svc="$(resolve_service_name "modem-collector" "modem-collector")"
+payload="$(fetch_metrics_payload "modem-collector" "$svc" "9098")"
+
+assert_metric_present "$payload" "modem_poll_errors_total"
+assert_metric_present "$payload" "modem_poll_duration_seconds"
+
+printf "modem-collector metrics test passed.\n"
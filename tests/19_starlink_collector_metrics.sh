@@ -0,0 +1,18 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+ROOT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")/.." && pwd)"
+# shellcheck source=tests/lib/cluster_common.sh
+source "$ROOT_DIR/tests/lib/cluster_common.sh"
+
+skip_unless_cluster_tests "starlink-collector metrics test"
+init_kubectl
+
+wait_for_deployment "starlink-collector" "starlink-collector"
+svc="$(resolve_service_name "starlink-collector" "starlink-collector")"
+payload="$(fetch_metrics_payload "starlink-collector" "$svc" "9099")"
+
+assert_metric_present "$payload" "starlink_poll_errors_total"
+assert_metric_present "$payload" "starlink_poll_duration_seconds"
+
+printf "starlink-collector metrics test passed.\n"
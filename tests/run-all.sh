@@ -16,6 +16,10 @@ TEST_SCRIPTS=(
   "$TEST_DIR/13_jitter_probe_metrics.sh"
   "$TEST_DIR/14_gateway_monitor_metrics.sh"
   "$TEST_DIR/15_alert_receiver_metrics.sh"
+  "$TEST_DIR/16_path_monitor_metrics.sh"
+  "$TEST_DIR/17_snmp_collector_metrics.sh"
+  "$TEST_DIR/18_modem_collector_metrics.sh"
+  "$TEST_DIR/19_starlink_collector_metrics.sh"
 )
 
 printf "Running repository verification tests...\n"
@@ -0,0 +1,176 @@
+package wifiprobe
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"edge-monitor-app/internal/probe"
+	"edge-monitor-app/internal/statebus"
+)
+
+// defaultEventLogSize bounds the /events log when EVENT_LOG_SIZE is unset.
+const defaultEventLogSize = 512
+
+// event is one probe state transition. An "up" event carries how long the
+// target was down.
+type event struct {
+	ID              uint64           `json:"id"`
+	Time            time.Time        `json:"time"`
+	Probe           string           `json:"probe"`
+	Target          string           `json:"target"`
+	Interface       string           `json:"interface,omitempty"`
+	State           string           `json:"state"`
+	Error           string           `json:"error,omitempty"`
+	ErrorClass      probe.ErrorClass `json:"error_class,omitempty"`
+	DownSince       *time.Time       `json:"down_since,omitempty"`
+	DowntimeSeconds float64          `json:"downtime_seconds,omitempty"`
+	// Maintenance marks a transition inside a maintenance window.
+	Maintenance bool `json:"maintenance,omitempty"`
+}
+
+// targetEventState is the last known state of one target.
+type targetEventState struct {
+	up        bool
+	downSince time.Time
+}
+
+// eventLog keeps the most recent probe state transitions in a ring buffer.
+// Probes only add an event when a target changes state, so the log covers
+// long periods without growing with the probe rate.
+type eventLog struct {
+	mu     sync.Mutex
+	events []event // ring buffer, oldest at next once full
+	next   int
+	full   bool
+	seq    uint64
+	states map[string]targetEventState
+}
+
+func newEventLog(size int) *eventLog {
+	if size <= 0 {
+		size = defaultEventLogSize
+	}
+	return &eventLog{
+		events: make([]event, size),
+		states: make(map[string]targetEventState),
+	}
+}
+
+// observe records a transition if the probe result changes the target's
+// state. The first result for a target only records an event if it failed.
+func (l *eventLog) observe(t target, err error, now time.Time, inMaintenance bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	prev, known := l.states[t.key()]
+	up := err == nil
+	if known && prev.up == up {
+		return
+	}
+	if !known && up {
+		l.states[t.key()] = targetEventState{up: true}
+		return
+	}
+
+	e := event{
+		Time:      now.UTC(),
+		Probe:     t.kind,
+		Target:    t.name,
+		Interface: t.iface(),
+		State:     "up",
+
+		Maintenance: inMaintenance,
+	}
+	if up {
+		since := prev.downSince
+		e.DownSince = &since
+		e.DowntimeSeconds = now.Sub(prev.downSince).Seconds()
+		l.states[t.key()] = targetEventState{up: true}
+	} else {
+		e.State = "down"
+		e.Error = err.Error()
+		e.ErrorClass = probe.Classify(err)
+		l.states[t.key()] = targetEventState{downSince: e.Time}
+	}
+	l.add(e)
+	statebus.Publish(statebus.Change{
+		Time:        e.Time,
+		Service:     "wifi-probe",
+		Probe:       t.kind,
+		Target:      t.name,
+		Via:         t.iface(),
+		Up:          up,
+		ErrorClass:  string(e.ErrorClass),
+		Maintenance: inMaintenance,
+	})
+}
+
+// forget drops the state of a target that is no longer probed. Its events
+// stay in the log.
+func (l *eventLog) forget(key string) {
+	l.mu.Lock()
+	delete(l.states, key)
+	l.mu.Unlock()
+}
+
+func (l *eventLog) add(e event) {
+	l.seq++
+	e.ID = l.seq
+	l.events[l.next] = e
+	l.next = (l.next + 1) % len(l.events)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// list returns events in [since, until] (zero bounds are open) oldest first.
+func (l *eventLog) list(since, until time.Time) []event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ordered := l.events[:l.next]
+	if l.full {
+		ordered = append(append([]event(nil), l.events[l.next:]...), l.events[:l.next]...)
+	}
+	out := make([]event, 0, len(ordered))
+	for _, e := range ordered {
+		if (!since.IsZero() && e.Time.Before(since)) || (!until.IsZero() && e.Time.After(until)) {
+			continue
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// eventsHandler serves GET /events with the probe state transitions,
+// optionally limited to the RFC 3339 "since" and "until" query parameters.
+func eventsHandler(log *eventLog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var bounds [2]time.Time
+		for i, key := range []string{"since", "until"} {
+			v := r.URL.Query().Get(key)
+			if v == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, key+": expected RFC 3339 time", http.StatusBadRequest)
+				return
+			}
+			bounds[i] = t
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"generated_at": time.Now().UTC(),
+			"events":       log.list(bounds[0], bounds[1]),
+		})
+	}
+}
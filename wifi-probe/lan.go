@@ -0,0 +1,193 @@
+package wifiprobe
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"edge-monitor-app/internal/config"
+	"edge-monitor-app/internal/inventory"
+	"edge-monitor-app/internal/metrics"
+	"edge-monitor-app/internal/probe"
+)
+
+// lanTarget is a piece of LAN infrastructure (access point, switch
+// management address, NAS) probed apart from the internet targets.
+type lanTarget struct {
+	name string // the "name" label, e.g. "ap"
+	host string
+	port string // probed over TCP when set, otherwise with an ICMP echo
+}
+
+// label is the "target" metric label: the LAN_TARGETS address.
+func (t lanTarget) label() string {
+	if t.port == "" {
+		return t.host
+	}
+	return net.JoinHostPort(t.host, t.port)
+}
+
+func (t lanTarget) kind() string {
+	if t.port == "" {
+		return "icmp"
+	}
+	return kindTCP
+}
+
+// lanEntries returns LAN_TARGETS or, when it is unset, the inventory's
+// lan-device targets, named by their inventory name.
+func lanEntries() ([]string, error) {
+	if config.IsSet("LAN_TARGETS") {
+		return config.List("LAN_TARGETS"), nil
+	}
+	targets, _, err := inventory.Lookup(inventory.RoleLANDevice)
+	if err != nil {
+		return nil, err
+	}
+	var entries []string
+	for _, t := range targets {
+		if t.Name != "" {
+			entries = append(entries, t.Name+"="+t.Target)
+		} else {
+			entries = append(entries, t.Target)
+		}
+	}
+	return entries, nil
+}
+
+// parseLANTargets parses LAN_TARGETS entries of the form [name=]host[:port],
+// e.g. "ap=192.168.1.2,switch=192.168.1.3,nas=192.168.1.10:445". Without a
+// name the host is the name. Switches and access points often answer
+// nothing but ping; give a port for devices that should also accept
+// connections.
+func parseLANTargets(entries []string) ([]lanTarget, error) {
+	var out []lanTarget
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		name, addr, ok := strings.Cut(e, "=")
+		if !ok {
+			addr = name
+		}
+		t := lanTarget{name: name, host: addr}
+		if host, port, err := net.SplitHostPort(addr); err == nil {
+			t.host, t.port = host, port
+		}
+		if !ok {
+			t.name = t.host
+		}
+		if t.name == "" || t.host == "" {
+			return nil, fmt.Errorf("LAN_TARGETS: invalid entry %q", e)
+		}
+		if seen[t.name] {
+			return nil, fmt.Errorf("LAN_TARGETS: duplicate name %q", t.name)
+		}
+		seen[t.name] = true
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+// lanResult is one LAN target's probe outcome.
+type lanResult struct {
+	latency time.Duration
+	err     error
+}
+
+// probeLAN probes every LAN target at once, so one dead device does not
+// hold up the cycle by its timeout, and exports lan_health, the fraction
+// of them that answered. With the internet probes next to it, it tells a
+// failure inside the house from one outside it.
+func (s *Service) probeLAN(ctx context.Context) {
+	results := make([]lanResult, len(s.lanTargets))
+	var wg sync.WaitGroup
+	for i, t := range s.lanTargets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if t.port == "" {
+				pctx, cancel := context.WithTimeout(ctx, tcpTimeout)
+				defer cancel()
+				results[i].latency, results[i].err = probe.ICMP(pctx, t.host)
+				return
+			}
+			addr, err := s.resolveLAN(ctx, t)
+			if err != nil {
+				results[i].err = err
+				return
+			}
+			pctx, cancel := context.WithTimeout(ctx, tcpTimeout)
+			defer cancel()
+			results[i].latency, results[i].err = probe.TCP(pctx, nil, addr, t.port)
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	now := time.Now()
+	var down []string
+	for i, t := range s.lanTargets {
+		r := results[i]
+		up := r.err == nil
+		lanTargetUp.WithLabelValues(t.name, t.label()).Set(metrics.BoolToFloat(up))
+		if up {
+			lanTargetLatency.WithLabelValues(t.name, t.label()).Set(r.latency.Seconds())
+		} else {
+			down = append(down, t.name)
+		}
+
+		inMaintenance := s.maintenance.Active(t.host, now)
+		if !up && inMaintenance {
+			s.maintenance.Failure(t.host)
+		}
+		prev, known := s.lanUp[t.name]
+		s.lanUp[t.name] = up
+		switch {
+		case known && prev == up:
+		case up && known:
+			slog.Info("lan device reachable again", "name", t.name, "target", t.label(), "latency", r.latency.String())
+		case !up:
+			level := slog.LevelWarn
+			if inMaintenance {
+				level = slog.LevelInfo
+			}
+			slog.Log(ctx, level, "lan device unreachable", "name", t.name, "target", t.label(), "probe", t.kind(),
+				"error", r.err, "error_class", probe.Classify(r.err), "maintenance", inMaintenance)
+		}
+	}
+	lanHealth.Set(float64(len(s.lanTargets)-len(down)) / float64(len(s.lanTargets)))
+	if slices.Equal(down, s.lanDown) && (s.lanProbed || len(down) == 0) {
+		s.lanProbed = true
+		return
+	}
+	switch {
+	case len(down) == 0:
+		slog.Info("lan infrastructure healthy", "targets", len(s.lanTargets))
+	case len(down) == len(s.lanTargets):
+		slog.Warn("lan infrastructure unreachable: the failure is inside the house", "down", down)
+	default:
+		slog.Warn("lan infrastructure degraded", "down", down, "targets", len(s.lanTargets))
+	}
+	s.lanDown, s.lanProbed = down, true
+}
+
+// resolveLAN looks up the host of a TCP LAN target named by host name,
+// timed apart from the connect like the PING_TARGETS.
+func (s *Service) resolveLAN(ctx context.Context, t lanTarget) (string, error) {
+	if !probe.IsName(t.host) {
+		return t.host, nil
+	}
+	addr, took, err := probe.Resolve(ctx, nil, t.host, s.resolveTimeout)
+	if err != nil {
+		dnsResolutionFailures.WithLabelValues("lan", t.label(), "", string(probe.Classify(err))).Inc()
+		return "", err
+	}
+	dnsResolution.WithLabelValues("lan", t.label(), "").Set(took.Seconds())
+	return addr, nil
+}
@@ -1,4 +1,4 @@
-package main
+package wifiprobe
 
 import "github.com/prometheus/client_golang/prometheus"
 
@@ -8,7 +8,7 @@ var (
             Name: "wifi_probe_up",
             Help: "Probe success (1) or failure (0)",
         },
-        []string{"probe", "target"},
+        []string{"probe", "target", "interface"},
     )
 
     probeLatency = prometheus.NewGaugeVec(
@@ -16,7 +16,7 @@ var (
             Name: "wifi_probe_latency_seconds",
             Help: "Probe latency in seconds",
         },
-        []string{"probe", "target"},
+        []string{"probe", "target", "interface"},
     )
 
     probeRuns = prometheus.NewCounterVec(
@@ -24,7 +24,7 @@ var (
             Name: "wifi_probe_runs_total",
             Help: "Total number of probe executions",
         },
-        []string{"probe", "target"},
+        []string{"probe", "target", "interface"},
     )
 
     probeErrors = prometheus.NewCounterVec(
@@ -32,7 +32,270 @@ var (
             Name: "wifi_probe_errors_total",
             Help: "Total number of probe errors",
         },
-        []string{"probe", "target"},
+        []string{"probe", "target", "interface"},
+    )
+
+    probeConsecutiveFailures = prometheus.NewGaugeVec(
+        prometheus.GaugeOpts{
+            Name: "wifi_probe_consecutive_failures",
+            Help: "Failed probes in a row since the last success (0 while up)",
+        },
+        []string{"probe", "target", "interface"},
+    )
+
+    probeSinceSuccess = prometheus.NewGaugeVec(
+        prometheus.GaugeOpts{
+            Name: "wifi_probe_seconds_since_success",
+            Help: "Seconds from the last successful probe (or the first probe, if none succeeded) to the latest one",
+        },
+        []string{"probe", "target", "interface"},
+    )
+
+    lanTargetUp = prometheus.NewGaugeVec(
+        prometheus.GaugeOpts{
+            Name: "lan_target_up",
+            Help: "LAN_TARGETS device answered (1) or not (0)",
+        },
+        []string{"name", "target"},
+    )
+
+    lanTargetLatency = prometheus.NewGaugeVec(
+        prometheus.GaugeOpts{
+            Name: "lan_target_latency_seconds",
+            Help: "ICMP echo or TCP connect latency of a LAN_TARGETS device in seconds",
+        },
+        []string{"name", "target"},
+    )
+
+    lanHealth = prometheus.NewGauge(
+        prometheus.GaugeOpts{
+            Name: "lan_health",
+            Help: "Fraction (0-1) of LAN_TARGETS devices that answered in the last cycle",
+        },
+    )
+
+    httpPhase = prometheus.NewGaugeVec(
+        prometheus.GaugeOpts{
+            Name: "wifi_probe_http_phase_seconds",
+            Help: "HTTP probe latency by phase (dns, connect, tls, ttfb, total) in seconds",
+        },
+        []string{"target", "interface", "phase"},
+    )
+
+    httpResponses = prometheus.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "wifi_probe_http_responses_total",
+            Help: "HTTP probe responses by status code",
+        },
+        []string{"target", "interface", "code"},
+    )
+
+    tlsConnectSeconds = prometheus.NewGaugeVec(
+        prometheus.GaugeOpts{
+            Name: "wifi_probe_tls_connect_seconds",
+            Help: "TCP connect time of the TLS probe in seconds",
+        },
+        []string{"target", "interface"},
+    )
+
+    tlsHandshakeSeconds = prometheus.NewGaugeVec(
+        prometheus.GaugeOpts{
+            Name: "wifi_probe_tls_handshake_seconds",
+            Help: "TLS handshake time after connect in seconds",
+        },
+        []string{"target", "interface"},
+    )
+
+    tlsCertExpiryDays = prometheus.NewGaugeVec(
+        prometheus.GaugeOpts{
+            Name: "tls_cert_expiry_days",
+            Help: "Days until the leaf certificate expires (negative once expired)",
+        },
+        []string{"target", "interface"},
+    )
+
+    tlsVerifyFailures = prometheus.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "tls_verify_failures_total",
+            Help: "Certificate verification failures by reason (expired, unknown_authority, hostname, invalid)",
+        },
+        []string{"target", "interface", "reason"},
+    )
+
+    scriptStepSeconds = prometheus.NewGaugeVec(
+        prometheus.GaugeOpts{
+            Name: "wifi_probe_script_step_seconds",
+            Help: "Duration of the last run of each script step, from request until the body was read, in seconds",
+        },
+        []string{"target", "interface", "step"},
+    )
+
+    scriptStepFailures = prometheus.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "wifi_probe_script_step_failures_total",
+            Help: "Script runs that failed at a step, by error class (http_status, assertion, timeout, ...)",
+        },
+        []string{"target", "interface", "step", "error_class"},
+    )
+
+    dnsResolution = prometheus.NewGaugeVec(
+        prometheus.GaugeOpts{
+            Name: "wifi_probe_dns_resolution_seconds",
+            Help: "Time to pre-resolve the target's host with its resolver, excluded from the probe latency",
+        },
+        []string{"probe", "target", "interface"},
+    )
+
+    dnsResolutionFailures = prometheus.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "wifi_probe_dns_resolution_failures_total",
+            Help: "Failed pre-resolutions of the target's host, by error class",
+        },
+        []string{"probe", "target", "interface", "error_class"},
+    )
+
+    captivePortalDetected = prometheus.NewGaugeVec(
+        prometheus.GaugeOpts{
+            Name: "captive_portal_detected",
+            Help: "HTTP target with expectations answered by something else, e.g. a captive portal (1) or not (0)",
+        },
+        []string{"target", "interface"},
+    )
+
+    wifiConnected = prometheus.NewGaugeVec(
+        prometheus.GaugeOpts{
+            Name: "wifi_connected",
+            Help: "Wireless interface associated with an access point (1) or not (0)",
+        },
+        []string{"interface"},
+    )
+
+    wifiLinkInfo = prometheus.NewGaugeVec(
+        prometheus.GaugeOpts{
+            Name: "wifi_link_info",
+            Help: "Current association of a wireless interface (always 1)",
+        },
+        []string{"interface", "bssid", "ssid", "channel"},
+    )
+
+    wifiSignal = prometheus.NewGaugeVec(
+        prometheus.GaugeOpts{
+            Name: "wifi_signal_dbm",
+            Help: "Received signal strength (RSSI) of the associated AP in dBm",
+        },
+        []string{"interface"},
+    )
+
+    wifiNoise = prometheus.NewGaugeVec(
+        prometheus.GaugeOpts{
+            Name: "wifi_noise_dbm",
+            Help: "Noise floor of the channel in use in dBm",
+        },
+        []string{"interface"},
+    )
+
+    wifiSNR = prometheus.NewGaugeVec(
+        prometheus.GaugeOpts{
+            Name: "wifi_snr_db",
+            Help: "Signal-to-noise ratio in dB",
+        },
+        []string{"interface"},
+    )
+
+    wifiTxBitrate = prometheus.NewGaugeVec(
+        prometheus.GaugeOpts{
+            Name: "wifi_tx_bitrate_mbps",
+            Help: "Last transmit bitrate to the AP in Mbit/s",
+        },
+        []string{"interface"},
+    )
+
+    wifiRxBitrate = prometheus.NewGaugeVec(
+        prometheus.GaugeOpts{
+            Name: "wifi_rx_bitrate_mbps",
+            Help: "Last receive bitrate from the AP in Mbit/s",
+        },
+        []string{"interface"},
+    )
+
+    wifiFrequency = prometheus.NewGaugeVec(
+        prometheus.GaugeOpts{
+            Name: "wifi_frequency_mhz",
+            Help: "Center frequency of the channel in use in MHz",
+        },
+        []string{"interface"},
+    )
+
+    wifiChannel = prometheus.NewGaugeVec(
+        prometheus.GaugeOpts{
+            Name: "wifi_channel",
+            Help: "IEEE channel number in use",
+        },
+        []string{"interface"},
+    )
+
+    wifiTxRetries = prometheus.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "wifi_tx_retries_total",
+            Help: "Frames retransmitted to the AP",
+        },
+        []string{"interface"},
+    )
+
+    wifiTxFailed = prometheus.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "wifi_tx_failed_total",
+            Help: "Frames that failed to reach the AP after all retries",
+        },
+        []string{"interface"},
+    )
+
+    wifiRoamEvents = prometheus.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "wifi_roam_events_total",
+            Help: "Changes of the associated BSSID without a disassociation in between",
+        },
+        []string{"interface"},
+    )
+
+    wifiAssociationSeconds = prometheus.NewGaugeVec(
+        prometheus.GaugeOpts{
+            Name: "wifi_association_duration_seconds",
+            Help: "Time since the interface associated with the current BSSID",
+        },
+        []string{"interface"},
+    )
+
+    wifiBSSIDConnectedSeconds = prometheus.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "wifi_bssid_connected_seconds_total",
+            Help: "Cumulative time associated with each BSSID",
+        },
+        []string{"interface", "bssid"},
+    )
+
+    wifiLastRoam = prometheus.NewGaugeVec(
+        prometheus.GaugeOpts{
+            Name: "wifi_last_roam_timestamp_seconds",
+            Help: "Unix time of the most recent roam",
+        },
+        []string{"interface"},
+    )
+
+    wifiRoamProbeErrors = prometheus.NewCounterVec(
+        prometheus.CounterOpts{
+            Name: "wifi_roam_probe_errors_total",
+            Help: "Probe failures within WIFI_ROAM_WINDOW_SECONDS after a roam",
+        },
+        []string{"interface"},
+    )
+
+    wifiRoamProbeLatency = prometheus.NewGaugeVec(
+        prometheus.GaugeOpts{
+            Name: "wifi_roam_probe_latency_max_seconds",
+            Help: "Worst probe latency within WIFI_ROAM_WINDOW_SECONDS after the most recent roam",
+        },
+        []string{"interface"},
     )
 )
 
@@ -42,5 +305,82 @@ func registerMetrics() {
         probeLatency,
         probeRuns,
         probeErrors,
+        probeConsecutiveFailures,
+        probeSinceSuccess,
+        httpPhase,
+        httpResponses,
+        tlsConnectSeconds,
+        tlsHandshakeSeconds,
+        tlsCertExpiryDays,
+        tlsVerifyFailures,
+        scriptStepSeconds,
+        scriptStepFailures,
+        dnsResolution,
+        dnsResolutionFailures,
+        captivePortalDetected,
+        wifiConnected,
+        wifiLinkInfo,
+        wifiSignal,
+        wifiNoise,
+        wifiSNR,
+        wifiTxBitrate,
+        wifiRxBitrate,
+        wifiFrequency,
+        wifiChannel,
+        wifiTxRetries,
+        wifiTxFailed,
+        wifiRoamEvents,
+        wifiAssociationSeconds,
+        wifiBSSIDConnectedSeconds,
+        wifiLastRoam,
+        wifiRoamProbeErrors,
+        wifiRoamProbeLatency,
     )
 }
+
+// registerLANMetrics registers the LAN infrastructure group, exported
+// with LAN_TARGETS.
+func registerLANMetrics() {
+    prometheus.MustRegister(
+        lanTargetUp,
+        lanTargetLatency,
+        lanHealth,
+    )
+}
+
+// deleteTargetMetrics removes the series of a target that is no longer probed.
+func deleteTargetMetrics(probe, target, iface string) {
+    probeUp.DeleteLabelValues(probe, target, iface)
+    probeLatency.DeleteLabelValues(probe, target, iface)
+    probeRuns.DeleteLabelValues(probe, target, iface)
+    probeErrors.DeleteLabelValues(probe, target, iface)
+    probeConsecutiveFailures.DeleteLabelValues(probe, target, iface)
+    probeSinceSuccess.DeleteLabelValues(probe, target, iface)
+    dnsResolution.DeleteLabelValues(probe, target, iface)
+    dnsResolutionFailures.DeletePartialMatch(prometheus.Labels{"probe": probe, "target": target, "interface": iface})
+}
+
+// deleteWiFiMetrics removes the series of a wireless interface that has gone away.
+func deleteWiFiMetrics(iface string, st *ifaceState) {
+    if st.bssid != "" {
+        wifiLinkInfo.DeleteLabelValues(iface, st.bssid, st.ssid, st.channel)
+    }
+    for bssid := range st.bssidSeen {
+        wifiBSSIDConnectedSeconds.DeleteLabelValues(iface, bssid)
+    }
+    wifiConnected.DeleteLabelValues(iface)
+    wifiLastRoam.DeleteLabelValues(iface)
+    wifiRoamProbeLatency.DeleteLabelValues(iface)
+    deleteWiFiLinkGauges(iface)
+    for _, v := range []*prometheus.CounterVec{wifiTxRetries, wifiTxFailed, wifiRoamEvents, wifiRoamProbeErrors} {
+        v.DeleteLabelValues(iface)
+    }
+}
+
+// deleteWiFiLinkGauges removes the radio gauges of an interface that is not
+// associated, so stale signal and bitrate values are not reported.
+func deleteWiFiLinkGauges(iface string) {
+    for _, v := range []*prometheus.GaugeVec{wifiSignal, wifiNoise, wifiSNR, wifiTxBitrate, wifiRxBitrate, wifiFrequency, wifiChannel, wifiAssociationSeconds} {
+        v.DeleteLabelValues(iface)
+    }
+}
@@ -0,0 +1,289 @@
+//go:build linux
+
+package wifiprobe
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Generic netlink and nl80211 constants (linux/genetlink.h, linux/nl80211.h).
+const (
+	genlIDCtrl           = 0x10
+	ctrlCmdGetFamily     = 3
+	ctrlAttrFamilyID     = 1
+	ctrlAttrFamilyName   = 2
+	nlmFRequest          = 0x1
+	nlmFDump             = 0x300
+	nlaTypeMask          = 0x3fff
+	nl80211CmdGetIface   = 5
+	nl80211CmdGetStation = 17
+	nl80211CmdGetSurvey  = 50
+
+	nl80211AttrIfindex    = 3
+	nl80211AttrIfname     = 4
+	nl80211AttrIftype     = 5
+	nl80211AttrMAC        = 6
+	nl80211AttrStaInfo    = 21
+	nl80211AttrWiphyFreq  = 38
+	nl80211AttrSSID       = 52
+	nl80211AttrSurveyInfo = 84
+
+	nl80211IftypeStation = 2
+
+	staInfoSignal    = 7
+	staInfoTxBitrate = 8
+	staInfoTxRetries = 11
+	staInfoTxFailed  = 12
+	staInfoRxBitrate = 14
+
+	rateInfoBitrate   = 1 // u16, 100 kbit/s
+	rateInfoBitrate32 = 5 // u32, 100 kbit/s
+
+	surveyInfoNoise = 2
+	surveyInfoInUse = 3
+
+	netlinkTimeout = 2 * time.Second
+)
+
+// nl80211Source reads link state from the kernel over generic netlink.
+type nl80211Source struct {
+	mu     sync.Mutex
+	family uint16
+	seq    uint32
+}
+
+func newNL80211Source() (linkSource, error) {
+	s := &nl80211Source{}
+	msgs, err := s.request(genlIDCtrl, ctrlCmdGetFamily, 0, attr(ctrlAttrFamilyName, append([]byte("nl80211"), 0)))
+	if err != nil {
+		return nil, fmt.Errorf("resolve nl80211 family: %w", err)
+	}
+	for _, m := range msgs {
+		if v, ok := parseAttrs(m)[ctrlAttrFamilyID]; ok && len(v) >= 2 {
+			s.family = binary.NativeEndian.Uint16(v)
+			return s, nil
+		}
+	}
+	return nil, errors.New("resolve nl80211 family: no family id in reply")
+}
+
+func (s *nl80211Source) name() string { return "netlink" }
+
+func (s *nl80211Source) interfaces() ([]string, error) {
+	msgs, err := s.request(s.family, nl80211CmdGetIface, nlmFDump)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, m := range msgs {
+		attrs := parseAttrs(m)
+		if t, ok := attrs[nl80211AttrIftype]; !ok || len(t) < 4 || binary.NativeEndian.Uint32(t) != nl80211IftypeStation {
+			continue
+		}
+		if name, ok := attrs[nl80211AttrIfname]; ok {
+			out = append(out, strings.TrimRight(string(name), "\x00"))
+		}
+	}
+	return out, nil
+}
+
+func (s *nl80211Source) link(ctx context.Context, iface string) (linkInfo, error) {
+	info := linkInfo{iface: iface}
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return info, err
+	}
+	idx := attrU32(nl80211AttrIfindex, uint32(ifi.Index))
+
+	msgs, err := s.request(s.family, nl80211CmdGetIface, 0, idx)
+	if err != nil {
+		return info, fmt.Errorf("get interface: %w", err)
+	}
+	for _, m := range msgs {
+		attrs := parseAttrs(m)
+		if v, ok := attrs[nl80211AttrWiphyFreq]; ok && len(v) >= 4 {
+			info.freqMHz = int(binary.NativeEndian.Uint32(v))
+		}
+		if v, ok := attrs[nl80211AttrSSID]; ok {
+			info.ssid = string(v)
+		}
+	}
+
+	msgs, err = s.request(s.family, nl80211CmdGetStation, nlmFDump, idx)
+	if err != nil {
+		return info, fmt.Errorf("get station: %w", err)
+	}
+	for _, m := range msgs {
+		attrs := parseAttrs(m)
+		mac, ok := attrs[nl80211AttrMAC]
+		if !ok || len(mac) != 6 {
+			continue
+		}
+		info.connected = true
+		info.bssid = fmt.Sprintf("%02x:%02x:%02x:%02x:%02x:%02x", mac[0], mac[1], mac[2], mac[3], mac[4], mac[5])
+		sta := parseAttrs(attrs[nl80211AttrStaInfo])
+		if v, ok := sta[staInfoSignal]; ok && len(v) >= 1 {
+			info.signalDbm, info.hasSignal = int(int8(v[0])), true
+		}
+		info.txBitrate = rateMbps(sta[staInfoTxBitrate])
+		info.rxBitrate = rateMbps(sta[staInfoRxBitrate])
+		if v, ok := sta[staInfoTxRetries]; ok && len(v) >= 4 {
+			info.txRetries, info.hasCounters = uint64(binary.NativeEndian.Uint32(v)), true
+		}
+		if v, ok := sta[staInfoTxFailed]; ok && len(v) >= 4 {
+			info.txFailed, info.hasCounters = uint64(binary.NativeEndian.Uint32(v)), true
+		}
+		break
+	}
+	if !info.connected {
+		return info, nil
+	}
+
+	// Noise comes from the survey entry of the channel in use; not every
+	// driver reports it.
+	if msgs, err := s.request(s.family, nl80211CmdGetSurvey, nlmFDump, idx); err == nil {
+		for _, m := range msgs {
+			survey := parseAttrs(parseAttrs(m)[nl80211AttrSurveyInfo])
+			if _, inUse := survey[surveyInfoInUse]; !inUse {
+				continue
+			}
+			if v, ok := survey[surveyInfoNoise]; ok && len(v) >= 1 {
+				info.noiseDbm, info.hasNoise = int(int8(v[0])), true
+			}
+		}
+	}
+	if !info.hasNoise {
+		info.noiseDbm, info.hasNoise = procWirelessNoise(iface)
+	}
+	return info, nil
+}
+
+// rateMbps decodes a nested nl80211 rate_info attribute.
+func rateMbps(b []byte) float64 {
+	rate := parseAttrs(b)
+	if v, ok := rate[rateInfoBitrate32]; ok && len(v) >= 4 {
+		return float64(binary.NativeEndian.Uint32(v)) / 10
+	}
+	if v, ok := rate[rateInfoBitrate]; ok && len(v) >= 2 {
+		return float64(binary.NativeEndian.Uint16(v)) / 10
+	}
+	return 0
+}
+
+// request sends one generic netlink request and collects the payloads
+// (after the genl header) of every reply message.
+func (s *nl80211Source) request(family uint16, cmd uint8, flags uint16, attrs ...[]byte) ([][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_GENERIC)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	defer syscall.Close(fd)
+
+	tv := syscall.NsecToTimeval(netlinkTimeout.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		return nil, os.NewSyscallError("setsockopt", err)
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return nil, os.NewSyscallError("bind", err)
+	}
+
+	s.seq++
+	seq := s.seq
+	payload := []byte{cmd, 1, 0, 0}
+	for _, a := range attrs {
+		payload = append(payload, a...)
+	}
+	msg := make([]byte, syscall.NLMSG_HDRLEN, syscall.NLMSG_HDRLEN+len(payload))
+	binary.NativeEndian.PutUint32(msg[0:4], uint32(syscall.NLMSG_HDRLEN+len(payload)))
+	binary.NativeEndian.PutUint16(msg[4:6], family)
+	binary.NativeEndian.PutUint16(msg[6:8], nlmFRequest|flags)
+	binary.NativeEndian.PutUint32(msg[8:12], seq)
+	msg = append(msg, payload...)
+
+	if err := syscall.Sendto(fd, msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return nil, os.NewSyscallError("sendto", err)
+	}
+
+	var out [][]byte
+	buf := make([]byte, 64*1024)
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return nil, os.NewSyscallError("recvfrom", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range msgs {
+			if m.Header.Seq != seq {
+				continue
+			}
+			switch m.Header.Type {
+			case syscall.NLMSG_DONE:
+				return out, nil
+			case syscall.NLMSG_ERROR:
+				if len(m.Data) >= 4 {
+					if errno := -int32(binary.NativeEndian.Uint32(m.Data[:4])); errno != 0 {
+						return nil, syscall.Errno(errno)
+					}
+				}
+				return out, nil
+			}
+			if len(m.Data) >= 4 {
+				out = append(out, m.Data[4:])
+			}
+			if m.Header.Flags&syscall.NLM_F_MULTI == 0 {
+				return out, nil
+			}
+		}
+	}
+}
+
+// attr encodes one netlink attribute, padded to 4 bytes.
+func attr(typ uint16, value []byte) []byte {
+	l := syscall.SizeofNlAttr + len(value)
+	b := make([]byte, (l+3)&^3)
+	binary.NativeEndian.PutUint16(b[0:2], uint16(l))
+	binary.NativeEndian.PutUint16(b[2:4], typ)
+	copy(b[syscall.SizeofNlAttr:], value)
+	return b
+}
+
+func attrU32(typ uint16, v uint32) []byte {
+	b := make([]byte, 4)
+	binary.NativeEndian.PutUint32(b, v)
+	return attr(typ, b)
+}
+
+// parseAttrs decodes a flat run of netlink attributes. Nested attributes
+// are returned as raw bytes for the caller to parse again.
+func parseAttrs(b []byte) map[uint16][]byte {
+	out := make(map[uint16][]byte)
+	for len(b) >= syscall.SizeofNlAttr {
+		l := int(binary.NativeEndian.Uint16(b[0:2]))
+		typ := binary.NativeEndian.Uint16(b[2:4]) & nlaTypeMask
+		if l < syscall.SizeofNlAttr || l > len(b) {
+			break
+		}
+		out[typ] = b[syscall.SizeofNlAttr:l]
+		next := (l + 3) &^ 3
+		if next > len(b) {
+			break
+		}
+		b = b[next:]
+	}
+	return out
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package wifiprobe
+
+import "errors"
+
+// newNL80211Source is only implemented on Linux; other platforms use iw.
+func newNL80211Source() (linkSource, error) {
+	return nil, errors.New("nl80211 is only available on linux")
+}
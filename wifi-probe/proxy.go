@@ -0,0 +1,61 @@
+package wifiprobe
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// proxySetting is a target's proxy override. The zero value follows the
+// environment (HTTPS_PROXY, HTTP_PROXY and NO_PROXY).
+type proxySetting struct {
+	direct bool     // bypass any environment proxy
+	url    *url.URL // proxy to use instead of the environment's
+}
+
+func (p proxySetting) isZero() bool { return !p.direct && p.url == nil }
+
+// parseProxy parses a proxy= option: "direct" to connect without a proxy,
+// or an http://, https:// or socks5:// proxy URL, optionally with
+// user:password.
+func parseProxy(v string) (proxySetting, error) {
+	switch v {
+	case "direct", "off", "none":
+		return proxySetting{direct: true}, nil
+	}
+	u, err := url.Parse(v)
+	if err != nil || u.Host == "" {
+		return proxySetting{}, fmt.Errorf("proxy %q: want direct or a proxy URL", v)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return proxySetting{}, fmt.Errorf("proxy %q: unsupported scheme %q (valid: http, https, socks5)", v, u.Scheme)
+	}
+	return proxySetting{url: u}, nil
+}
+
+// proxyKey carries a target's proxy override in its request context.
+type proxyKey struct{}
+
+// withProxy makes HTTP requests within ctx use p instead of the
+// environment's proxy.
+func withProxy(ctx context.Context, p proxySetting) context.Context {
+	if p.isZero() {
+		return ctx
+	}
+	return context.WithValue(ctx, proxyKey{}, p)
+}
+
+// proxyFor is the Proxy function of the probe transports: a target's
+// override from the request context, else the environment.
+func proxyFor(req *http.Request) (*url.URL, error) {
+	if p, ok := req.Context().Value(proxyKey{}).(proxySetting); ok {
+		if p.direct {
+			return nil, nil
+		}
+		return p.url, nil
+	}
+	return http.ProxyFromEnvironment(req)
+}
@@ -0,0 +1,114 @@
+package wifiprobe
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"edge-monitor-app/internal/config"
+	"edge-monitor-app/internal/probe"
+)
+
+// httpMethods are the methods an HTTP target may send. Probes carry no
+// request body, so nothing that changes state on the endpoint is offered.
+var httpMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+}
+
+// requestSpec holds an HTTP target's request settings as written in
+// HTTP_TARGETS options or TARGETS_FILE fields.
+type requestSpec struct {
+	method    string
+	headers   [][2]string // name, value
+	bearer    string
+	basic     string // user:password
+	redirects string
+}
+
+// build validates the settings into the request the probe sends. Header
+// values and credentials may reference secrets from the environment as
+// ${NAME}, read with config.Secret at startup like script secrets, so
+// tokens stay out of the target list.
+func (r requestSpec) build() (probe.HTTPRequest, error) {
+	var req probe.HTTPRequest
+	if r.method != "" {
+		req.Method = strings.ToUpper(r.method)
+		if !httpMethods[req.Method] {
+			return probe.HTTPRequest{}, fmt.Errorf("method %q not allowed (valid: GET, HEAD, OPTIONS)", r.method)
+		}
+	}
+
+	if len(r.headers) > 0 {
+		req.Header = make(http.Header, len(r.headers))
+	}
+	for _, h := range r.headers {
+		name, value := strings.TrimSpace(h[0]), strings.TrimSpace(h[1])
+		if name == "" || strings.ContainsAny(name, " \t:") {
+			return probe.HTTPRequest{}, fmt.Errorf("invalid header name %q", name)
+		}
+		v, err := expandSecrets(value)
+		if err != nil {
+			return probe.HTTPRequest{}, fmt.Errorf("header %s: %w", name, err)
+		}
+		req.Header.Add(name, v)
+	}
+
+	if r.bearer != "" || r.basic != "" {
+		switch {
+		case r.bearer != "" && r.basic != "":
+			return probe.HTTPRequest{}, fmt.Errorf("bearer and basic auth are exclusive")
+		case req.Header.Get("Authorization") != "":
+			return probe.HTTPRequest{}, fmt.Errorf("auth and an Authorization header are exclusive")
+		}
+		var auth string
+		if r.bearer != "" {
+			token, err := expandSecrets(r.bearer)
+			if err != nil {
+				return probe.HTTPRequest{}, fmt.Errorf("bearer: %w", err)
+			}
+			auth = "Bearer " + token
+		} else {
+			cred, err := expandSecrets(r.basic)
+			if err != nil {
+				return probe.HTTPRequest{}, fmt.Errorf("basic: %w", err)
+			}
+			if !strings.Contains(cred, ":") {
+				return probe.HTTPRequest{}, fmt.Errorf("basic auth must be user:password")
+			}
+			auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(cred))
+		}
+		if req.Header == nil {
+			req.Header = make(http.Header, 1)
+		}
+		req.Header.Set("Authorization", auth)
+	}
+
+	if r.redirects != "" {
+		p, err := probe.ParseRedirectPolicy(r.redirects)
+		if err != nil {
+			return probe.HTTPRequest{}, err
+		}
+		req.Redirects = p
+	}
+	return req, nil
+}
+
+// expandSecrets replaces ${NAME} references in v with the secret NAME.
+func expandSecrets(v string) (string, error) {
+	var missing string
+	out := varRef.ReplaceAllStringFunc(v, func(ref string) string {
+		name := varRef.FindStringSubmatch(ref)[1]
+		secret := config.Secret(name)
+		if secret == "" && missing == "" {
+			missing = name
+		}
+		return secret
+	})
+	if missing != "" {
+		return "", fmt.Errorf("${%s} is not set", missing)
+	}
+	return out, nil
+}
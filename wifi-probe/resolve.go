@@ -0,0 +1,241 @@
+package wifiprobe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"edge-monitor-app/internal/probe"
+)
+
+// systemResolver pre-resolves with the operating system's resolver.
+const systemResolver = "system"
+
+// resolveSpec says how a target's host name is resolved. The zero value
+// leaves resolution to the probe itself, so its time is part of the probe
+// latency and a DNS outage fails the probe; TCP probes still look the name
+// up first, under its own timeout, so it cannot use up the connect budget.
+// A pinned address skips DNS; a resolver resolves before the probe, times
+// it separately and falls back to the last address it returned while it
+// fails.
+type resolveSpec struct {
+	pin      string // IP address to dial instead of resolving
+	resolver string // "system", or the label of server
+	server   probe.DNSResolver
+}
+
+func (r resolveSpec) isZero() bool { return r.pin == "" && r.resolver == "" }
+
+// resolverSchemes maps resolver URL schemes to transports.
+var resolverSchemes = map[string]probe.DNSTransport{
+	"udp":   probe.DNSOverUDP,
+	"tcp":   probe.DNSOverTCP,
+	"tls":   probe.DNSOverTLS,
+	"https": probe.DNSOverHTTPS,
+}
+
+// parsePin validates a pin= option.
+func parsePin(v string) (resolveSpec, error) {
+	if net.ParseIP(v) == nil {
+		return resolveSpec{}, fmt.Errorf("pin %q is not an IP address", v)
+	}
+	return resolveSpec{pin: v}, nil
+}
+
+// parseResolverSpec parses a resolver= option or PROBE_RESOLVER: "system",
+// a nameserver IP with an optional port ("1.1.1.1", "[2606:4700::1111]:53"),
+// the same with a udp:// or tcp:// scheme, "tls://host[:port]" or an
+// https:// DoH URL.
+func parseResolverSpec(v string) (resolveSpec, error) {
+	if v == systemResolver {
+		return resolveSpec{resolver: v}, nil
+	}
+	scheme, addr, hasScheme := strings.Cut(v, "://")
+	if !hasScheme {
+		scheme, addr = "udp", v
+	}
+	transport, ok := resolverSchemes[scheme]
+	if !ok {
+		return resolveSpec{}, fmt.Errorf("resolver %q: unsupported scheme %q", v, scheme)
+	}
+	spec := resolveSpec{resolver: v, server: probe.DNSResolver{Transport: transport, Server: addr}}
+	switch transport {
+	case probe.DNSOverHTTPS:
+		if u, err := url.Parse(v); err != nil || u.Host == "" {
+			return resolveSpec{}, fmt.Errorf("resolver %q: invalid DoH URL", v)
+		}
+		spec.server.Server = v
+		return spec, nil
+	case probe.DNSOverTLS:
+		if host, _, err := net.SplitHostPort(addr); (err == nil && host == "") || addr == "" {
+			return resolveSpec{}, fmt.Errorf("resolver %q: missing DoT server", v)
+		}
+		return spec, nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = addr, ""
+	}
+	if net.ParseIP(host) == nil {
+		return resolveSpec{}, fmt.Errorf("resolver %q: must be an IP address, a URL or \"system\"", v)
+	}
+	if port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return resolveSpec{}, fmt.Errorf("resolver %q: invalid port %q", v, port)
+		}
+	}
+	return spec, nil
+}
+
+// host returns the name the target resolves, or "" when it is an IP
+// literal or not a host (script targets).
+func (t target) host() string {
+	var h string
+	switch t.kind {
+	case kindHTTP:
+		u, err := url.Parse(t.name)
+		if err != nil {
+			return ""
+		}
+		h = u.Hostname()
+	case kindTCP, kindTLS:
+		h = t.name
+		if host, _, err := net.SplitHostPort(t.name); err == nil {
+			h = host
+		}
+	default:
+		return ""
+	}
+	if !probe.IsName(h) {
+		return ""
+	}
+	return h
+}
+
+// withAddress returns the target's host:port with the host replaced by ip,
+// for TCP and TLS probes.
+func (t target) withAddress(ip string) string {
+	if _, port, err := net.SplitHostPort(t.name); err == nil {
+		return net.JoinHostPort(ip, port)
+	}
+	return ip
+}
+
+// resolve returns the address to probe t at, or "" to let the probe
+// resolve the name itself. Resolver lookups are timed and counted apart
+// from the probe; when one fails, the last address it returned is used so
+// connectivity is still measured during a DNS outage.
+func (s *Service) resolve(ctx context.Context, t target) (string, error) {
+	spec := t.resolve
+	if spec.isZero() {
+		spec = s.resolver
+	}
+	host := t.host()
+	if host == "" || (spec.isZero() && t.kind != kindTCP) {
+		return "", nil
+	}
+	if spec.isZero() {
+		return s.resolveTCP(ctx, t, host)
+	}
+	if spec.pin != "" {
+		return spec.pin, nil
+	}
+
+	start := time.Now()
+	ip, err := lookup(ctx, spec, host, s.resolveTimeout, t.bind)
+	if err == nil {
+		dnsResolution.WithLabelValues(t.kind, t.name, t.iface()).Set(time.Since(start).Seconds())
+		s.resolved[t.key()] = ip
+		return ip, nil
+	}
+
+	dnsResolutionFailures.WithLabelValues(t.kind, t.name, t.iface(), string(probe.Classify(err))).Inc()
+	last, ok := s.resolved[t.key()]
+	if !ok {
+		return "", err
+	}
+	slog.Warn("name resolution failed, probing last resolved address",
+		"target", t.name, "resolver", spec.resolver, "address", last, "error", err)
+	return last, nil
+}
+
+// resolveTCP looks up the host of a TCP target without a pin or resolver
+// with the system resolver, timed apart from the connect. Without a
+// resolver configured there is no last address to fall back to: a failed
+// lookup fails the probe, as it did when the dial resolved the name.
+func (s *Service) resolveTCP(ctx context.Context, t target, host string) (string, error) {
+	ip, took, err := probe.ResolveFrom(ctx, nil, host, s.resolveTimeout, t.bind)
+	if err != nil {
+		dnsResolutionFailures.WithLabelValues(t.kind, t.name, t.iface(), string(probe.Classify(err))).Inc()
+		return "", err
+	}
+	dnsResolution.WithLabelValues(t.kind, t.name, t.iface()).Set(took.Seconds())
+	return ip, nil
+}
+
+// lookup resolves host to one address within timeout, preferring IPv4
+// unless b has an IPv6 source address.
+func lookup(ctx context.Context, spec resolveSpec, host string, timeout time.Duration, b probe.Binding) (string, error) {
+	if spec.resolver == systemResolver {
+		ip, _, err := probe.ResolveFrom(ctx, nil, host, timeout, b)
+		return ip, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	qtypes := []probe.DNSType{probe.TypeA, probe.TypeAAAA}
+	if b.PrefersIPv6() {
+		qtypes[0], qtypes[1] = qtypes[1], qtypes[0]
+	}
+	var lastErr error
+	for _, qtype := range qtypes {
+		resp, _, err := spec.server.Query(ctx, host, qtype)
+		if err != nil {
+			lastErr = err
+			var nodata *probe.DNSNoDataError
+			if errors.As(err, &nodata) {
+				continue
+			}
+			return "", err
+		}
+		return resp.Records(qtype)[0].Data, nil
+	}
+	return "", lastErr
+}
+
+// pinKey carries the address an HTTP probe dials in its request context.
+type pinKey struct{}
+
+type pinnedHost struct {
+	host string
+	ip   string
+}
+
+// withPinnedHost makes dials to host within ctx go to ip. Redirects to
+// other hosts resolve normally.
+func withPinnedHost(ctx context.Context, host, ip string) context.Context {
+	if ip == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, pinKey{}, pinnedHost{host: host, ip: ip})
+}
+
+// pinnedDial wraps d so that dials honour withPinnedHost. The TLS server
+// name still comes from the URL, so certificates are verified as usual.
+func pinnedDial(d *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if p, ok := ctx.Value(pinKey{}).(pinnedHost); ok {
+			if host, port, err := net.SplitHostPort(addr); err == nil && strings.EqualFold(host, p.host) {
+				addr = net.JoinHostPort(p.ip, port)
+			}
+		}
+		return d.DialContext(ctx, network, addr)
+	}
+}
@@ -0,0 +1,240 @@
+package wifiprobe
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"edge-monitor-app/internal/config"
+	"edge-monitor-app/internal/probe"
+)
+
+// scriptTimeout bounds a whole script run when the target sets no timeout.
+const scriptTimeout = 10 * time.Second
+
+// maxScriptBodyBytes bounds how much of each step's response is read for
+// assertions and extraction.
+const maxScriptBodyBytes = 256 << 10
+
+// varRef matches ${name} references in step URLs, headers and bodies.
+var varRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// fileStep is one step of a TARGETS_FILE script entry.
+type fileStep struct {
+	Name         string            `json:"name"`
+	Method       string            `json:"method,omitempty"`
+	URL          string            `json:"url"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	Body         string            `json:"body,omitempty"`
+	Form         map[string]string `json:"form,omitempty"`
+	ExpectStatus int               `json:"expect_status,omitempty"`
+	ExpectBody   string            `json:"expect_body,omitempty"`
+	Extract      map[string]string `json:"extract,omitempty"`
+}
+
+// scriptStep is a parsed step. Values may reference variables extracted
+// by earlier steps or secrets from the environment as ${name}.
+type scriptStep struct {
+	name         string
+	method       string
+	url          string
+	headers      map[string]string
+	body         string
+	form         url.Values
+	expectStatus int
+	expectBody   string
+	extract      map[string]*regexp.Regexp
+}
+
+// parseScript validates the steps of a script target. A ${name} that no
+// earlier step extracts is read with config.Secret at startup, so
+// passwords come from the secret rather than the targets file.
+func parseScript(steps []fileStep) ([]scriptStep, map[string]string, error) {
+	if len(steps) == 0 {
+		return nil, nil, fmt.Errorf("script has no steps")
+	}
+	secrets := make(map[string]string)
+	extracted := make(map[string]bool)
+	seen := make(map[string]bool, len(steps))
+	out := make([]scriptStep, 0, len(steps))
+	for i, fs := range steps {
+		if fs.Name == "" {
+			fs.Name = fmt.Sprintf("step%d", i+1)
+		}
+		if seen[fs.Name] {
+			return nil, nil, fmt.Errorf("duplicate step name %q", fs.Name)
+		}
+		seen[fs.Name] = true
+		if fs.URL == "" {
+			return nil, nil, fmt.Errorf("step %s: missing url", fs.Name)
+		}
+		if fs.Body != "" && len(fs.Form) > 0 {
+			return nil, nil, fmt.Errorf("step %s: body and form are exclusive", fs.Name)
+		}
+		if fs.ExpectStatus != 0 && (fs.ExpectStatus < 100 || fs.ExpectStatus > 599) {
+			return nil, nil, fmt.Errorf("step %s: invalid expect_status %d", fs.Name, fs.ExpectStatus)
+		}
+
+		st := scriptStep{
+			name:         fs.Name,
+			method:       strings.ToUpper(fs.Method),
+			url:          fs.URL,
+			headers:      fs.Headers,
+			body:         fs.Body,
+			expectStatus: fs.ExpectStatus,
+			expectBody:   fs.ExpectBody,
+			extract:      make(map[string]*regexp.Regexp, len(fs.Extract)),
+		}
+		if st.method == "" {
+			st.method = http.MethodGet
+			if fs.Body != "" || len(fs.Form) > 0 {
+				st.method = http.MethodPost
+			}
+		}
+		if len(fs.Form) > 0 {
+			st.form = url.Values{}
+			for k, v := range fs.Form {
+				st.form.Set(k, v)
+			}
+		}
+
+		values := []string{fs.URL, fs.Body}
+		for _, v := range fs.Headers {
+			values = append(values, v)
+		}
+		for _, v := range fs.Form {
+			values = append(values, v)
+		}
+		for _, v := range values {
+			for _, m := range varRef.FindAllStringSubmatch(v, -1) {
+				name := m[1]
+				if extracted[name] || secrets[name] != "" {
+					continue
+				}
+				secret := config.Secret(name)
+				if secret == "" {
+					return nil, nil, fmt.Errorf("step %s: ${%s} is neither extracted by an earlier step nor set", fs.Name, name)
+				}
+				secrets[name] = secret
+			}
+		}
+
+		for name, expr := range fs.Extract {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, nil, fmt.Errorf("step %s: extract %s: %w", fs.Name, name, err)
+			}
+			if re.NumSubexp() < 1 {
+				return nil, nil, fmt.Errorf("step %s: extract %s: pattern needs a capture group", fs.Name, name)
+			}
+			st.extract[name] = re
+			extracted[name] = true
+		}
+		out = append(out, st)
+	}
+	return out, secrets, nil
+}
+
+// stepResult is the outcome of one script step.
+type stepResult struct {
+	name     string
+	duration time.Duration
+	err      error
+}
+
+// runScript runs the steps in order with a fresh cookie jar, so sessions
+// set by a login step carry to the next, and stops at the first failure.
+// Every step run is returned; the error is the failing step's.
+func runScript(ctx context.Context, base *http.Client, steps []scriptStep, secrets map[string]string) ([]stepResult, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Transport: base.Transport, Jar: jar}
+
+	vars := make(map[string]string, len(secrets))
+	for k, v := range secrets {
+		vars[k] = v
+	}
+	expand := func(s string) string {
+		return varRef.ReplaceAllStringFunc(s, func(ref string) string {
+			return vars[varRef.FindStringSubmatch(ref)[1]]
+		})
+	}
+
+	results := make([]stepResult, 0, len(steps))
+	for _, st := range steps {
+		start := time.Now()
+		err := runStep(ctx, client, st, expand, vars)
+		results = append(results, stepResult{name: st.name, duration: time.Since(start), err: err})
+		if err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+func runStep(ctx context.Context, client *http.Client, st scriptStep, expand func(string) string, vars map[string]string) error {
+	op := "script step " + st.name
+	target := expand(st.url)
+
+	var body io.Reader
+	contentType := ""
+	switch {
+	case st.form != nil:
+		form := url.Values{}
+		for k, vs := range st.form {
+			form.Set(k, expand(vs[0]))
+		}
+		body = strings.NewReader(form.Encode())
+		contentType = "application/x-www-form-urlencoded"
+	case st.body != "":
+		body = strings.NewReader(expand(st.body))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, st.method, target, body)
+	if err != nil {
+		return &probe.Error{Op: op, Target: st.url, Class: probe.ClassOther, Err: err}
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for k, v := range st.headers {
+		req.Header.Set(k, expand(v))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return &probe.Error{Op: op, Target: st.url, Class: probe.Classify(err), Err: err}
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxScriptBodyBytes))
+	if err != nil {
+		return &probe.Error{Op: op, Target: st.url, Class: probe.Classify(err), Err: err}
+	}
+
+	statusOK := resp.StatusCode >= 200 && resp.StatusCode < 400
+	if st.expectStatus != 0 {
+		statusOK = resp.StatusCode == st.expectStatus
+	}
+	if !statusOK {
+		return &probe.Error{Op: op, Target: st.url, Class: probe.ClassHTTPStatus, Err: &probe.StatusError{StatusCode: resp.StatusCode}}
+	}
+	if st.expectBody != "" && !strings.Contains(string(data), st.expectBody) {
+		return &probe.Error{Op: op, Target: st.url, Class: probe.ClassAssertion, Err: fmt.Errorf("body does not contain %q", st.expectBody)}
+	}
+	for name, re := range st.extract {
+		m := re.FindSubmatch(data)
+		if m == nil {
+			return &probe.Error{Op: op, Target: st.url, Class: probe.ClassAssertion, Err: fmt.Errorf("extract %s: no match for %q", name, re)}
+		}
+		vars[name] = string(m[1])
+	}
+	return nil
+}
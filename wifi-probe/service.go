@@ -0,0 +1,628 @@
+// Package wifiprobe implements the wifi-probe TCP and HTTP reachability
+// prober. It runs standalone via cmd/wifi-probe or inside the combined
+// edge-monitor binary.
+package wifiprobe
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"edge-monitor-app/internal/buildinfo"
+	"edge-monitor-app/internal/config"
+	"edge-monitor-app/internal/discovery"
+	"edge-monitor-app/internal/health"
+	"edge-monitor-app/internal/inventory"
+	"edge-monitor-app/internal/maintenance"
+	"edge-monitor-app/internal/metrics"
+	"edge-monitor-app/internal/pause"
+	"edge-monitor-app/internal/probe"
+	"edge-monitor-app/internal/selfcheck"
+)
+
+// DefaultAddr is the standalone metrics listen address.
+const DefaultAddr = ":9090"
+
+// tcpPorts are tried in order for TCP targets without an explicit port.
+var tcpPorts = []string{"443", "80"}
+
+const (
+	tcpTimeout  = 2 * time.Second
+	httpTimeout = 3 * time.Second
+)
+
+// httpClient opens a fresh connection per probe so every sample includes
+// the DNS, connect and TLS phases instead of reusing a pooled connection.
+var httpClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:             proxyFor,
+		DialContext:       pinnedDial(&net.Dialer{}),
+		DisableKeepAlives: true,
+	},
+}
+
+// Service is a configured wifi-probe instance.
+type Service struct {
+	interval         time.Duration
+	staticTCPTargets []string
+	discover         bool
+	anycast          []string
+
+	// tcpTargets are the PING_TARGETS plus discovered targets, probed with
+	// default settings. targets holds HTTP/TLS targets and TARGETS_FILE
+	// entries, which may carry their own timeout and interval.
+	tcpTargets []string
+	targets    []target
+	tick       time.Duration
+	lastProbe  map[string]time.Time
+
+	// httpClients holds one client per uplink binding.
+	httpClients map[probe.Binding]*http.Client
+
+	// resolver applies to targets without their own pin or resolver;
+	// resolved keeps the last address it returned per target key.
+	// resolveTimeout bounds every lookup made before a probe.
+	resolver       resolveSpec
+	resolved       map[string]string
+	resolveTimeout time.Duration
+
+	// lanTargets is the LAN infrastructure probed as one group; lanUp and
+	// lanDown are their states at the previous cycle.
+	lanTargets []lanTarget
+	lanUp      map[string]bool
+	lanDown    []string
+	lanProbed  bool
+
+	// streaks tracks each target's failures since its last success.
+	streaks map[string]*failureStreak
+
+	wifi        *wifiCollector
+	events      *eventLog
+	maintenance *maintenance.Schedule
+	selfcheck   *selfcheck.Checker
+
+	health *health.Tracker
+}
+
+// New reads configuration from the environment and registers metrics with
+// the default Prometheus registry.
+func New() (*Service, error) {
+	registerMetrics()
+
+	pingTargets, err := inventory.List("PING_TARGETS", inventory.RoleGateway, inventory.RoleWAN)
+	if err != nil {
+		return nil, err
+	}
+	s := &Service{
+		interval:         config.Seconds("INTERVAL_SECONDS", 5*time.Second),
+		staticTCPTargets: pingTargets,
+		discover:         config.Bool("DISCOVER_TARGETS", false),
+		anycast:          config.List("DISCOVERY_ANYCAST_TARGETS"),
+		lastProbe:        make(map[string]time.Time),
+		httpClients:      make(map[probe.Binding]*http.Client),
+		resolved:         make(map[string]string),
+		resolveTimeout:   config.Seconds("PROBE_RESOLVE_TIMEOUT_SECONDS", probe.DefaultResolveTimeout),
+		streaks:          make(map[string]*failureStreak),
+		lanUp:            make(map[string]bool),
+		events:           newEventLog(config.Int("EVENT_LOG_SIZE", defaultEventLogSize)),
+	}
+	if len(s.anycast) == 0 {
+		s.anycast = strings.Split(discovery.DefaultAnycast, ",")
+	}
+	if v := config.String("PROBE_RESOLVER", ""); v != "" {
+		spec, err := parseResolverSpec(v)
+		if err != nil {
+			return nil, fmt.Errorf("PROBE_RESOLVER: %w", err)
+		}
+		s.resolver = spec
+	}
+
+	for _, raw := range config.List("HTTP_TARGETS") {
+		t, err := parseHTTPTarget(raw)
+		if err != nil {
+			return nil, err
+		}
+		s.targets = append(s.targets, t)
+	}
+	captive, ok, err := captivePortalTarget(config.String("CAPTIVE_PORTAL_URL", ""))
+	if err != nil {
+		return nil, fmt.Errorf("CAPTIVE_PORTAL_URL: %w", err)
+	}
+	if ok {
+		s.targets = append(s.targets, captive)
+	}
+	for _, t := range config.List("TLS_TARGETS") {
+		s.targets = append(s.targets, tlsTarget(t))
+	}
+	lanList, err := lanEntries()
+	if err != nil {
+		return nil, err
+	}
+	lanTargets, err := parseLANTargets(lanList)
+	if err != nil {
+		return nil, err
+	}
+	if s.lanTargets = lanTargets; len(lanTargets) > 0 {
+		registerLANMetrics()
+	}
+	if path := config.String("TARGETS_FILE", ""); path != "" {
+		fileTargets, err := loadTargetsFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("TARGETS_FILE: %w", err)
+		}
+		s.targets = append(s.targets, fileTargets...)
+	}
+
+	// Tick at the shortest configured interval; slower targets are skipped
+	// until they are due.
+	s.tick = s.interval
+	for _, t := range s.targets {
+		if t.interval > 0 && t.interval < s.tick {
+			s.tick = t.interval
+		}
+		if t.kind == kindHTTP && !t.expect.IsZero() {
+			captivePortalDetected.WithLabelValues(t.name, t.iface()).Set(0)
+		}
+	}
+
+	roamWindow := config.Seconds("WIFI_ROAM_WINDOW_SECONDS", 10*time.Second)
+	if mode := strings.ToLower(config.String("WIFI_COLLECTOR", "auto")); mode != "off" {
+		src, err := newLinkSource(mode)
+		switch {
+		case err == nil:
+			s.wifi = newWiFiCollector(src, config.List("WIFI_INTERFACES"), roamWindow)
+		case mode == "" || mode == "auto":
+			slog.Info("wifi link metrics disabled", "reason", err)
+		default:
+			return nil, err
+		}
+	}
+
+	if s.maintenance, err = maintenance.Load("wifi-probe"); err != nil {
+		return nil, err
+	}
+	if s.selfcheck, err = selfcheck.Load("wifi-probe", s.canaryProbe); err != nil {
+		return nil, err
+	}
+
+	s.tcpTargets = s.staticTCPTargets
+	if s.discover {
+		s.tcpTargets = discovery.Merge(s.staticTCPTargets, discovery.Targets(s.anycast))
+	}
+	pause.Track("wifi-probe")
+	buildinfo.Register("wifi-probe", map[string]bool{
+		"discovery":      s.discover,
+		"probe_resolver": !s.resolver.isZero(),
+		"captive_portal": ok,
+		"lan_targets":    len(s.lanTargets) > 0,
+		"targets_file":   config.IsSet("TARGETS_FILE"),
+		"wifi_link":      s.wifi != nil,
+		"selfcheck":      s.selfcheck != nil,
+	})
+	s.health = health.NewTracker("wifi-probe", s.interval)
+	return s, nil
+}
+
+// Register adds the service's HTTP handlers (other than /metrics) to mux.
+func (s *Service) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/events", eventsHandler(s.events))
+}
+
+// Health returns the probe loop tracker behind /healthz and /readyz.
+func (s *Service) Health() *health.Tracker { return s.health }
+
+// Run probes all targets every interval until ctx is cancelled.
+func (s *Service) Run(ctx context.Context) error {
+	slog.Info("starting wifi-probe",
+		"tcp_targets", s.tcpTargets,
+		"targets", s.targetNames(),
+		"interval", s.interval.String(),
+		"discover_targets", s.discover,
+		"wifi_collector", s.wifiSourceName(),
+		"lan_targets", len(s.lanTargets),
+	)
+
+	if s.selfcheck != nil {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.selfcheck.Run(ctx)
+		}()
+		defer wg.Wait()
+	}
+
+	ticker := time.NewTicker(s.tick)
+	defer ticker.Stop()
+
+	lastCycle := time.Time{}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		start := time.Now()
+		if pause.Active("wifi-probe") {
+			s.health.Cycle(start, s.tick)
+			continue
+		}
+
+		// Discovery only reads a few local files, so compare it every tick:
+		// a roam that changes the gateway or DNS servers is picked up on the
+		// next cycle rather than after a refresh timer.
+		if s.discover {
+			if next := discovery.Merge(s.staticTCPTargets, discovery.Targets(s.anycast)); !slices.Equal(next, s.tcpTargets) {
+				s.tcpTargets = s.refreshTCPTargets(next)
+			}
+		}
+
+		now := time.Now()
+		if s.due(now, lastCycle, s.interval) {
+			lastCycle = now
+			s.probeOnce(ctx, now)
+		} else {
+			s.probeDue(ctx, now, false)
+		}
+		s.health.Cycle(start, s.tick)
+	}
+}
+
+func (s *Service) targetNames() []string {
+	names := make([]string, 0, len(s.targets))
+	for _, t := range s.targets {
+		names = append(names, t.kind+":"+t.name)
+	}
+	return names
+}
+
+func (s *Service) wifiSourceName() string {
+	if s.wifi == nil {
+		return "off"
+	}
+	return s.wifi.src.name()
+}
+
+// due reports whether something last run at last with the given interval
+// should run at now. Half a tick of slack absorbs ticker jitter.
+func (s *Service) due(now, last time.Time, interval time.Duration) bool {
+	return last.IsZero() || now.Sub(last) >= interval-s.tick/2
+}
+
+// probeOnce samples WiFi link state and runs one probe cycle over the
+// default-interval targets plus any other targets that are due.
+func (s *Service) probeOnce(ctx context.Context, now time.Time) {
+	if s.wifi != nil {
+		s.wifi.collect(ctx)
+	}
+	if len(s.lanTargets) > 0 {
+		s.probeLAN(ctx)
+	}
+	s.probeDue(ctx, now, true)
+}
+
+// probeDue probes the targets whose interval has elapsed. Targets without
+// their own interval only run on full cycles (cycle true).
+func (s *Service) probeDue(ctx context.Context, now time.Time, cycle bool) {
+	configured := make(map[string]bool, len(s.targets))
+	for _, t := range s.targets {
+		configured[t.key()] = true
+	}
+
+	if cycle {
+		for _, name := range s.tcpTargets {
+			t := tcpTarget(name)
+			// A TARGETS_FILE entry for the same host takes precedence.
+			if !configured[t.key()] {
+				s.probeTarget(ctx, t)
+			}
+		}
+	}
+	for _, t := range s.targets {
+		if t.interval == 0 {
+			if cycle {
+				s.probeTarget(ctx, t)
+			}
+			continue
+		}
+		if s.due(now, s.lastProbe[t.key()], t.interval) {
+			s.lastProbe[t.key()] = now
+			s.probeTarget(ctx, t)
+		}
+	}
+}
+
+func (s *Service) probeTarget(ctx context.Context, t target) {
+	switch t.kind {
+	case kindTCP:
+		s.probeTCP(ctx, t)
+	case kindHTTP:
+		s.probeHTTP(ctx, t)
+	case kindTLS:
+		s.probeTLS(ctx, t)
+	case kindScript:
+		s.probeScript(ctx, t)
+	}
+}
+
+// httpClientFor returns the HTTP client for an uplink binding. Bound
+// clients are created on first use, so an interface that appears later
+// (e.g. an LTE modem) is picked up without a restart.
+func (s *Service) httpClientFor(b probe.Binding) (*http.Client, error) {
+	if b.IsZero() {
+		return httpClient, nil
+	}
+	if c, ok := s.httpClients[b]; ok {
+		return c, nil
+	}
+	dialer := &net.Dialer{}
+	if err := b.Apply(dialer); err != nil {
+		return nil, err
+	}
+	c := &http.Client{
+		Transport: &http.Transport{
+			Proxy:             proxyFor,
+			DialContext:       pinnedDial(dialer),
+			DisableKeepAlives: true,
+		},
+	}
+	s.httpClients[b] = c
+	return c, nil
+}
+
+func (s *Service) probeTCP(ctx context.Context, t target) {
+	probeRuns.WithLabelValues(kindTCP, t.name, t.iface()).Inc()
+
+	var latency time.Duration
+	dialer, err := t.dialer()
+	addr := t.name
+	if err == nil {
+		var ip string
+		if ip, err = s.resolve(ctx, t); ip != "" {
+			addr = t.withAddress(ip)
+		}
+	}
+	if err == nil {
+		pctx, cancel := context.WithTimeout(ctx, t.timeoutOr(tcpTimeout))
+		latency, err = probe.TCP(pctx, dialer, addr, t.ports...)
+		cancel()
+	}
+	probeUp.WithLabelValues(kindTCP, t.name, t.iface()).Set(metrics.BoolToFloat(err == nil))
+
+	if err == nil {
+		probeLatency.WithLabelValues(kindTCP, t.name, t.iface()).Set(latency.Seconds())
+		slog.Debug("tcp probe succeeded", "target", t.name, "latency", latency.String())
+	} else {
+		probeErrors.WithLabelValues(kindTCP, t.name, t.iface()).Inc()
+		slog.Warn("tcp probe failed", "target", t.name, "error", err, "error_class", probe.Classify(err))
+	}
+	s.observe(t, err)
+	s.observeRoam(kindTCP, t.name, latency, err)
+}
+
+// canaryProbe probes a CANARY_TARGETS_JSON target like a PING_TARGETS
+// entry, without recording it as a target.
+func (s *Service) canaryProbe(raw string) (selfcheck.Probe, error) {
+	t := tcpTarget(raw)
+	dialer, err := t.dialer()
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context) error {
+		addr, _, err := probe.ResolveFrom(ctx, nil, t.name, s.resolveTimeout, t.bind)
+		if err == nil {
+			_, err = probe.TCP(ctx, dialer, addr, t.ports...)
+		}
+		return err
+	}, nil
+}
+
+func (s *Service) probeHTTP(ctx context.Context, t target) {
+	u := t.name
+	probeRuns.WithLabelValues(kindHTTP, u, t.iface()).Inc()
+
+	var res probe.HTTPResult
+	client, err := s.httpClientFor(t.bind)
+	var ip string
+	if err == nil {
+		ip, err = s.resolve(ctx, t)
+	}
+	if err == nil {
+		pctx := withProxy(withPinnedHost(ctx, t.host(), ip), t.proxy)
+		pctx, cancel := context.WithTimeout(pctx, t.timeoutOr(httpTimeout))
+		res, err = probe.HTTPCheckRequest(pctx, client, u, t.request, t.expect)
+		cancel()
+	}
+	latency := res.Total
+	probeUp.WithLabelValues(kindHTTP, u, t.iface()).Set(metrics.BoolToFloat(err == nil))
+	if res.StatusCode != 0 {
+		httpResponses.WithLabelValues(u, t.iface(), strconv.Itoa(res.StatusCode)).Inc()
+		httpPhase.WithLabelValues(u, t.iface(), "dns").Set(res.DNS.Seconds())
+		httpPhase.WithLabelValues(u, t.iface(), "connect").Set(res.Connect.Seconds())
+		httpPhase.WithLabelValues(u, t.iface(), "tls").Set(res.TLS.Seconds())
+		httpPhase.WithLabelValues(u, t.iface(), "ttfb").Set(res.TTFB.Seconds())
+		httpPhase.WithLabelValues(u, t.iface(), "total").Set(res.Total.Seconds())
+	}
+	if !t.expect.IsZero() {
+		intercepted := probe.Classify(err) == probe.ClassIntercepted
+		captivePortalDetected.WithLabelValues(u, t.iface()).Set(metrics.BoolToFloat(intercepted))
+		if intercepted {
+			slog.Warn("captive portal or interception detected", "target", u, "error", err)
+		}
+	}
+
+	if err == nil {
+		probeLatency.WithLabelValues(kindHTTP, u, t.iface()).Set(latency.Seconds())
+		slog.Debug("http probe succeeded", "target", u, "latency", latency.String())
+	} else {
+		probeErrors.WithLabelValues(kindHTTP, u, t.iface()).Inc()
+		slog.Warn("http probe failed", "target", u, "error", err, "error_class", probe.Classify(err))
+	}
+	s.observe(t, err)
+	s.observeRoam(kindHTTP, u, latency, err)
+}
+
+// probeTLS records connect and handshake latency separately, the days left
+// on the leaf certificate, and verification failures by reason.
+func (s *Service) probeTLS(ctx context.Context, tt target) {
+	t := tt.name
+	probeRuns.WithLabelValues(kindTLS, t, tt.iface()).Inc()
+
+	var res probe.TLSResult
+	dialer, err := tt.dialer()
+	addr, serverName := t, ""
+	if err == nil {
+		var ip string
+		if ip, err = s.resolve(ctx, tt); ip != "" {
+			addr, serverName = tt.withAddress(ip), tt.host()
+		}
+	}
+	if err == nil {
+		pctx, cancel := context.WithTimeout(ctx, tt.timeoutOr(httpTimeout))
+		res, err = probe.TLS(pctx, dialer, addr, serverName)
+		cancel()
+	}
+	probeUp.WithLabelValues(kindTLS, t, tt.iface()).Set(metrics.BoolToFloat(err == nil))
+
+	if res.Handshake > 0 {
+		tlsConnectSeconds.WithLabelValues(t, tt.iface()).Set(res.Connect.Seconds())
+		tlsHandshakeSeconds.WithLabelValues(t, tt.iface()).Set(res.Handshake.Seconds())
+		probeLatency.WithLabelValues(kindTLS, t, tt.iface()).Set((res.Connect + res.Handshake).Seconds())
+	}
+	if !res.NotAfter.IsZero() {
+		tlsCertExpiryDays.WithLabelValues(t, tt.iface()).Set(time.Until(res.NotAfter).Hours() / 24)
+	}
+	if err == nil {
+		slog.Debug("tls probe succeeded", "target", t, "connect", res.Connect.String(), "handshake", res.Handshake.String())
+	} else {
+		probeErrors.WithLabelValues(kindTLS, t, tt.iface()).Inc()
+		if reason := probe.TLSVerifyReason(err); reason != "" {
+			tlsVerifyFailures.WithLabelValues(t, tt.iface(), reason).Inc()
+		}
+		slog.Warn("tls probe failed", "target", t, "error", err, "error_class", probe.Classify(err))
+	}
+	s.observe(tt, err)
+	s.observeRoam(kindTLS, t, res.Connect+res.Handshake, err)
+}
+
+// probeScript runs a multi-step HTTP script. Each step's duration is
+// recorded; the first failing step ends the run and is counted by error
+// class. wifi_probe_latency_seconds is the duration of the whole run.
+func (s *Service) probeScript(ctx context.Context, t target) {
+	probeRuns.WithLabelValues(kindScript, t.name, t.iface()).Inc()
+
+	var results []stepResult
+	start := time.Now()
+	client, err := s.httpClientFor(t.bind)
+	if err == nil {
+		pctx, cancel := context.WithTimeout(withProxy(ctx, t.proxy), t.timeoutOr(scriptTimeout))
+		results, err = runScript(pctx, client, t.steps, t.secrets)
+		cancel()
+	}
+	latency := time.Since(start)
+	probeUp.WithLabelValues(kindScript, t.name, t.iface()).Set(metrics.BoolToFloat(err == nil))
+
+	for _, r := range results {
+		scriptStepSeconds.WithLabelValues(t.name, t.iface(), r.name).Set(r.duration.Seconds())
+		if r.err != nil {
+			scriptStepFailures.WithLabelValues(t.name, t.iface(), r.name, string(probe.Classify(r.err))).Inc()
+		}
+	}
+	if err == nil {
+		probeLatency.WithLabelValues(kindScript, t.name, t.iface()).Set(latency.Seconds())
+		slog.Debug("script probe succeeded", "target", t.name, "latency", latency.String())
+	} else {
+		probeErrors.WithLabelValues(kindScript, t.name, t.iface()).Inc()
+		slog.Warn("script probe failed", "target", t.name, "error", err, "error_class", probe.Classify(err))
+	}
+	s.observe(t, err)
+	s.observeRoam(kindScript, t.name, latency, err)
+}
+
+// failureStreak is a target's run of failed probes.
+type failureStreak struct {
+	fails int
+	// lastSuccess is the last successful probe, or the first probe while
+	// none has succeeded.
+	lastSuccess time.Time
+}
+
+// observe logs a probe result's state transition, flagged when the target
+// is in a maintenance window, and counts failures during maintenance.
+func (s *Service) observe(t target, err error) {
+	now := time.Now()
+	inMaintenance := s.maintenance.Active(t.name, now)
+	if err != nil && inMaintenance {
+		s.maintenance.Failure(t.name)
+	}
+	s.events.observe(t, err, now, inMaintenance)
+	s.observeStreak(t, err, now)
+}
+
+// observeStreak exports the target's failure streak and the time since it
+// last succeeded, for rules such as "down for more than 30s" that the
+// probe interval would otherwise blur.
+func (s *Service) observeStreak(t target, err error, now time.Time) {
+	st, ok := s.streaks[t.key()]
+	if !ok {
+		st = &failureStreak{lastSuccess: now}
+		s.streaks[t.key()] = st
+	}
+	if err == nil {
+		st.fails = 0
+		st.lastSuccess = now
+	} else {
+		st.fails++
+	}
+	probeConsecutiveFailures.WithLabelValues(t.kind, t.name, t.iface()).Set(float64(st.fails))
+	probeSinceSuccess.WithLabelValues(t.kind, t.name, t.iface()).Set(now.Sub(st.lastSuccess).Seconds())
+}
+
+// observeRoam feeds a probe result to the roam correlation and logs failures
+// that happened right after a roam.
+func (s *Service) observeRoam(kind, target string, latency time.Duration, err error) {
+	if s.wifi == nil {
+		return
+	}
+	roamed := s.wifi.observeProbe(latency, err)
+	if err != nil && len(roamed) > 0 {
+		slog.Warn("probe failed shortly after wifi roam", "probe", kind, "target", target, "interfaces", roamed)
+	}
+}
+
+// refreshTCPTargets logs target set changes and drops the metric series of
+// targets that are no longer probed.
+func (s *Service) refreshTCPTargets(next []string) []string {
+	current := s.tcpTargets
+	keep := make(map[string]bool, len(next))
+	for _, t := range next {
+		keep[t] = true
+	}
+	known := make(map[string]bool, len(current))
+	for _, t := range current {
+		known[t] = true
+		if !keep[t] {
+			name, bind := probe.ParseBinding(t)
+			deleteTargetMetrics(kindTCP, name, bind.Label())
+			s.maintenance.Forget(name)
+			s.events.forget(tcpTarget(t).key())
+			delete(s.resolved, tcpTarget(t).key())
+			delete(s.streaks, tcpTarget(t).key())
+			slog.Info("target removed", "target", t)
+		}
+	}
+	for _, t := range next {
+		if !known[t] {
+			slog.Info("target added", "target", t)
+		}
+	}
+	return next
+}
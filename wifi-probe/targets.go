@@ -0,0 +1,380 @@
+package wifiprobe
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"edge-monitor-app/internal/probe"
+)
+
+// defaultCaptivePortalURL is a well-known endpoint that answers 204 with an
+// empty body; captive portals answer it with their login page instead.
+const defaultCaptivePortalURL = "http://connectivitycheck.gstatic.com/generate_204"
+
+// Probe kinds, used as the "probe" metric label.
+const (
+	kindTCP    = "tcp"
+	kindHTTP   = "http"
+	kindTLS    = "tls"
+	kindScript = "script"
+)
+
+// target is one probe target. A zero timeout or interval falls back to the
+// per-kind default timeout and the service interval.
+type target struct {
+	kind     string
+	name     string            // host[:port] for tcp/tls, URL for http, script name; the metric label
+	ports    []string          // tcp: ports tried in order when name has no port
+	expect   probe.HTTPExpect  // http only
+	request  probe.HTTPRequest // http only: method, headers, auth, redirects
+	steps    []scriptStep      // script only
+	secrets  map[string]string
+	bind     probe.Binding // uplink to probe through
+	resolve  resolveSpec   // tcp, http, tls: how the host name is resolved
+	proxy    proxySetting  // http, script: proxy override
+	timeout  time.Duration
+	interval time.Duration
+}
+
+func (t target) key() string { return t.kind + "|" + t.name + "|" + t.iface() }
+
+// iface is the "interface" metric label: the bound interface or source
+// address, empty when the kernel picks the route.
+func (t target) iface() string { return t.bind.Label() }
+
+// dialer returns a dialer bound to the target's uplink, or nil for the
+// default route.
+func (t target) dialer() (*net.Dialer, error) {
+	if t.bind.IsZero() {
+		return nil, nil
+	}
+	d := &net.Dialer{}
+	if err := t.bind.Apply(d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (t target) timeoutOr(def time.Duration) time.Duration {
+	if t.timeout > 0 {
+		return t.timeout
+	}
+	return def
+}
+
+// tcpTarget returns an env/discovery TCP target with default settings. An
+// "@iface" or "@source-ip" suffix binds it to an uplink.
+func tcpTarget(raw string) target {
+	name, bind := probe.ParseBinding(raw)
+	return target{kind: kindTCP, name: name, ports: tcpPorts, bind: bind}
+}
+
+// tlsTarget returns a TLS target, adding port 443 when none is given. An
+// "@iface" or "@source-ip" suffix binds it to an uplink.
+func tlsTarget(raw string) target {
+	name, bind := probe.ParseBinding(raw)
+	if _, _, err := net.SplitHostPort(name); err != nil {
+		name = net.JoinHostPort(name, "443")
+	}
+	return target{kind: kindTLS, name: name, bind: bind}
+}
+
+// parseHTTPTarget parses an HTTP_TARGETS entry of the form
+//
+//	URL [expect_status=N] [expect_body=TEXT] [expect_cert=NAME] [interface=IFACE] [source=IP]
+//	    [pin=IP | resolver=RESOLVER] [proxy=direct|URL] [method=HEAD] [header=NAME:VALUE]...
+//	    [bearer=TOKEN | basic=USER:PASSWORD] [redirects=follow|none|same_host]
+//
+// Options are separated by spaces, so body markers and header values
+// cannot contain spaces. Tokens and passwords are best given as ${NAME}
+// references to secrets (see requestSpec.build).
+func parseHTTPTarget(raw string) (target, error) {
+	fields := strings.Fields(raw)
+	if len(fields) == 0 {
+		return target{}, fmt.Errorf("empty http target")
+	}
+	t := target{kind: kindHTTP, name: fields[0]}
+	var req requestSpec
+	for _, opt := range fields[1:] {
+		key, value, ok := strings.Cut(opt, "=")
+		if !ok || value == "" {
+			return target{}, fmt.Errorf("http target %s: invalid option %q", t.name, opt)
+		}
+		switch key {
+		case "expect_status":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 100 || n > 599 {
+				return target{}, fmt.Errorf("http target %s: invalid expect_status %q", t.name, value)
+			}
+			t.expect.Status = n
+		case "expect_body":
+			t.expect.BodyContains = value
+		case "expect_cert":
+			t.expect.CertSubject = value
+		case "interface":
+			t.bind.Interface = value
+		case "source":
+			t.bind.Source = value
+		case "pin":
+			spec, err := parsePin(value)
+			if err != nil {
+				return target{}, fmt.Errorf("http target %s: %w", t.name, err)
+			}
+			t.resolve.pin = spec.pin
+		case "resolver":
+			spec, err := parseResolverSpec(value)
+			if err != nil {
+				return target{}, fmt.Errorf("http target %s: %w", t.name, err)
+			}
+			t.resolve.resolver, t.resolve.server = spec.resolver, spec.server
+		case "proxy":
+			p, err := parseProxy(value)
+			if err != nil {
+				return target{}, fmt.Errorf("http target %s: %w", t.name, err)
+			}
+			t.proxy = p
+		case "method":
+			req.method = value
+		case "header":
+			name, v, ok := strings.Cut(value, ":")
+			if !ok {
+				return target{}, fmt.Errorf("http target %s: header %q: want NAME:VALUE", t.name, value)
+			}
+			req.headers = append(req.headers, [2]string{name, v})
+		case "bearer":
+			req.bearer = value
+		case "basic":
+			req.basic = value
+		case "redirects":
+			req.redirects = value
+		default:
+			return target{}, fmt.Errorf("http target %s: unknown option %q", t.name, key)
+		}
+	}
+	if t.resolve.pin != "" && t.resolve.resolver != "" {
+		return target{}, fmt.Errorf("http target %s: pin and resolver are exclusive", t.name)
+	}
+	if err := t.setRequest(req); err != nil {
+		return target{}, fmt.Errorf("http target %s: %w", t.name, err)
+	}
+	return t, nil
+}
+
+// setRequest builds the target's request settings and checks them against
+// its expectations.
+func (t *target) setRequest(req requestSpec) error {
+	r, err := req.build()
+	if err != nil {
+		return err
+	}
+	if r.Method == http.MethodHead && t.expect.BodyContains != "" {
+		return fmt.Errorf("expect_body needs a response body, which HEAD does not get")
+	}
+	t.request = r
+	return nil
+}
+
+// captivePortalTarget returns the connectivity-check target configured by
+// CAPTIVE_PORTAL_URL (same syntax as HTTP_TARGETS). Without expectations it
+// requires a 204, matching the default endpoint. ok is false when the check
+// is disabled with "off".
+func captivePortalTarget(raw string) (t target, ok bool, err error) {
+	switch strings.TrimSpace(raw) {
+	case "off", "false", "none":
+		return target{}, false, nil
+	case "":
+		raw = defaultCaptivePortalURL
+	}
+	t, err = parseHTTPTarget(raw)
+	if err != nil {
+		return target{}, false, err
+	}
+	if t.expect.IsZero() {
+		t.expect.Status = 204
+	}
+	return t, true, nil
+}
+
+// fileTarget is one entry of the TARGETS_FILE JSON document.
+type fileTarget struct {
+	Type         string     `json:"type"`
+	Target       string     `json:"target"`
+	Ports        []string   `json:"ports,omitempty"`
+	Timeout      string     `json:"timeout,omitempty"`
+	Interval     string     `json:"interval,omitempty"`
+	ExpectStatus int        `json:"expect_status,omitempty"`
+	ExpectBody   string     `json:"expect_body,omitempty"`
+	ExpectCert   string     `json:"expect_cert,omitempty"`
+	Interface    string     `json:"interface,omitempty"`
+	Source       string     `json:"source,omitempty"`
+	Pin          string     `json:"pin,omitempty"`
+	Resolver     string     `json:"resolver,omitempty"`
+	Proxy        string     `json:"proxy,omitempty"`
+	Steps        []fileStep `json:"steps,omitempty"`
+
+	// HTTP request settings; header values and credentials may be ${NAME}.
+	Method      string            `json:"method,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	BearerToken string            `json:"bearer_token,omitempty"`
+	BasicAuth   string            `json:"basic_auth,omitempty"`
+	Redirects   string            `json:"redirects,omitempty"`
+}
+
+// loadTargetsFile reads per-target settings from a JSON file, either a list
+// of targets or an object with a "targets" list:
+//
+//	{"targets": [
+//	  {"type": "tcp", "target": "192.168.1.50", "ports": ["9100"], "timeout": "500ms"},
+//	  {"type": "tcp", "target": "1.1.1.1", "interface": "wwan0"},
+//	  {"type": "tls", "target": "nas.lan:443", "pin": "192.168.1.20"},
+//	  {"type": "http", "target": "https://example.com/health", "timeout": "8s",
+//	   "interval": "30s", "expect_status": 200},
+//	  {"type": "http", "target": "https://example.com/", "proxy": "socks5://10.0.0.2:1080"},
+//	  {"type": "http", "target": "https://10.0.0.5/healthz", "method": "HEAD",
+//	   "headers": {"Host": "api.example.com"}, "bearer_token": "${API_TOKEN}", "redirects": "none"},
+//	  {"type": "script", "target": "nas-login", "steps": [
+//	    {"name": "login_page", "url": "https://nas.lan/login", "extract": {"csrf": "name=\"csrf\" value=\"([^\"]+)\""}},
+//	    {"name": "login", "url": "https://nas.lan/login", "form": {"csrf": "${csrf}", "password": "${NAS_PASSWORD}"},
+//	     "expect_body": "Dashboard"}
+//	  ]}
+//	]}
+func loadTargetsFile(path string) ([]target, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []fileTarget
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "[") {
+		err = json.Unmarshal(data, &entries)
+	} else {
+		var doc struct {
+			Targets []fileTarget `json:"targets"`
+		}
+		err = json.Unmarshal(data, &doc)
+		entries = doc.Targets
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	out := make([]target, 0, len(entries))
+	for i, e := range entries {
+		t, err := e.target()
+		if err != nil {
+			return nil, fmt.Errorf("%s: target %d: %w", path, i, err)
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+func (e fileTarget) target() (target, error) {
+	if e.Target == "" {
+		return target{}, fmt.Errorf("missing target")
+	}
+
+	var (
+		t   target
+		err error
+	)
+	switch strings.ToLower(e.Type) {
+	case kindTCP, "":
+		t = tcpTarget(e.Target)
+		if len(e.Ports) > 0 {
+			t.ports = e.Ports
+		}
+	case kindHTTP:
+		t = target{kind: kindHTTP, name: e.Target}
+		t.expect = probe.HTTPExpect{Status: e.ExpectStatus, BodyContains: e.ExpectBody, CertSubject: e.ExpectCert}
+		req := requestSpec{method: e.Method, bearer: e.BearerToken, basic: e.BasicAuth, redirects: e.Redirects}
+		for name, v := range e.Headers {
+			req.headers = append(req.headers, [2]string{name, v})
+		}
+		if err = t.setRequest(req); err != nil {
+			return target{}, fmt.Errorf("%s: %w", e.Target, err)
+		}
+	case kindTLS:
+		t = tlsTarget(e.Target)
+	case kindScript:
+		var (
+			steps   []scriptStep
+			secrets map[string]string
+		)
+		steps, secrets, err = parseScript(e.Steps)
+		if err != nil {
+			return target{}, fmt.Errorf("%s: %w", e.Target, err)
+		}
+		t = target{kind: kindScript, name: e.Target, steps: steps, secrets: secrets}
+	default:
+		return target{}, fmt.Errorf("unknown type %q (valid: tcp, http, tls, script)", e.Type)
+	}
+	if t.kind != kindScript && len(e.Steps) > 0 {
+		return target{}, fmt.Errorf("%s: steps apply to script targets only", e.Target)
+	}
+	if t.kind != kindHTTP && (e.ExpectStatus != 0 || e.ExpectBody != "" || e.ExpectCert != "") {
+		return target{}, fmt.Errorf("%s: expect_* options apply to http targets only", e.Target)
+	}
+	if t.kind != kindHTTP && (e.Method != "" || len(e.Headers) > 0 || e.BearerToken != "" || e.BasicAuth != "" || e.Redirects != "") {
+		return target{}, fmt.Errorf("%s: method, headers, auth and redirects apply to http targets only (script steps set their own)", e.Target)
+	}
+
+	switch {
+	case e.Pin != "" && e.Resolver != "":
+		return target{}, fmt.Errorf("%s: pin and resolver are exclusive", e.Target)
+	case (e.Pin != "" || e.Resolver != "") && t.kind == kindScript:
+		return target{}, fmt.Errorf("%s: pin and resolver do not apply to script targets", e.Target)
+	case e.Pin != "":
+		if t.resolve, err = parsePin(e.Pin); err != nil {
+			return target{}, fmt.Errorf("%s: %w", e.Target, err)
+		}
+	case e.Resolver != "":
+		if t.resolve, err = parseResolverSpec(e.Resolver); err != nil {
+			return target{}, fmt.Errorf("%s: %w", e.Target, err)
+		}
+	}
+
+	if e.Proxy != "" {
+		if t.kind != kindHTTP && t.kind != kindScript {
+			return target{}, fmt.Errorf("%s: proxy applies to http and script targets only", e.Target)
+		}
+		if t.proxy, err = parseProxy(e.Proxy); err != nil {
+			return target{}, fmt.Errorf("%s: %w", e.Target, err)
+		}
+	}
+
+	if e.Interface != "" || e.Source != "" {
+		t.bind = probe.Binding{Interface: e.Interface, Source: e.Source}
+	}
+	if t.bind.Source != "" && net.ParseIP(t.bind.Source) == nil {
+		return target{}, fmt.Errorf("%s: invalid source address %q", e.Target, t.bind.Source)
+	}
+
+	if t.timeout, err = parseOptionalDuration(e.Timeout); err != nil {
+		return target{}, fmt.Errorf("%s: timeout: %w", e.Target, err)
+	}
+	if t.interval, err = parseOptionalDuration(e.Interval); err != nil {
+		return target{}, fmt.Errorf("%s: interval: %w", e.Target, err)
+	}
+	return t, nil
+}
+
+func parseOptionalDuration(v string) (time.Duration, error) {
+	if v == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("must be positive, got %s", v)
+	}
+	return d, nil
+}
@@ -0,0 +1,226 @@
+//go:build darwin
+
+package wifiprobe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// airportPath is the private Apple80211 tool that prints the current
+// association. macOS 14.4 removed it; system_profiler then takes over.
+var airportPath = "/System/Library/PrivateFrameworks/Apple80211.framework/Versions/Current/Resources/airport"
+
+// systemProfilerTimeout is longer than iwTimeout: system_profiler takes a
+// second or two even when it only reports the AirPort data type.
+const systemProfilerTimeout = 10 * time.Second
+
+// airportSource reads link state on macOS from airport -I, or from
+// system_profiler where airport is gone. CoreWLAN itself would need cgo.
+// Both tools describe the active Wi-Fi interface only, so every interface
+// reads the same association; laptops have one.
+type airportSource struct {
+	airport bool
+}
+
+func newPlatformSource() (linkSource, error) {
+	if _, err := exec.LookPath(airportPath); err == nil {
+		return &airportSource{airport: true}, nil
+	}
+	if _, err := exec.LookPath("system_profiler"); err != nil {
+		return nil, errors.New("neither airport nor system_profiler is available")
+	}
+	return &airportSource{}, nil
+}
+
+func (s *airportSource) name() string { return "airport" }
+
+// runTool runs a macOS tool with a timeout and returns its output.
+func runTool(ctx context.Context, timeout time.Duration, path string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, path, args...).Output()
+	if err != nil {
+		return "", fmt.Errorf("%s %s: %w", path, strings.Join(args, " "), err)
+	}
+	return string(out), nil
+}
+
+// interfaces lists the Wi-Fi hardware ports from networksetup.
+func (s *airportSource) interfaces() ([]string, error) {
+	out, err := runTool(context.Background(), iwTimeout, "networksetup", "-listallhardwareports")
+	if err != nil {
+		return nil, err
+	}
+	return parseHardwarePorts(out), nil
+}
+
+func (s *airportSource) link(ctx context.Context, iface string) (linkInfo, error) {
+	if s.airport {
+		out, err := runTool(ctx, iwTimeout, airportPath, "-I")
+		if err != nil {
+			return linkInfo{}, err
+		}
+		return parseAirportInfo(iface, out), nil
+	}
+	out, err := runTool(ctx, systemProfilerTimeout, "system_profiler", "-json", "SPAirPortDataType")
+	if err != nil {
+		return linkInfo{}, err
+	}
+	return parseSystemProfiler(iface, []byte(out))
+}
+
+// parseHardwarePorts picks the Wi-Fi devices from
+// `networksetup -listallhardwareports`:
+//
+//	Hardware Port: Wi-Fi
+//	Device: en0
+//	Ethernet Address: aa:bb:cc:dd:ee:ff
+func parseHardwarePorts(out string) []string {
+	var ifaces []string
+	wifi := false
+	for _, line := range strings.Split(out, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "Hardware Port":
+			wifi = value == "Wi-Fi" || value == "AirPort"
+		case "Device":
+			if wifi && value != "" {
+				ifaces = append(ifaces, value)
+			}
+			wifi = false
+		}
+	}
+	return ifaces
+}
+
+// parseAirportInfo parses `airport -I` output:
+//
+//	 agrCtlRSSI: -55
+//	agrCtlNoise: -94
+//	      state: running
+//	 lastTxRate: 867
+//	      BSSID: aa:bb:cc:dd:ee:ff
+//	       SSID: home
+//	    channel: 36,80
+//
+// A disassociated interface reports state init, or "AirPort: Off".
+func parseAirportInfo(iface, out string) linkInfo {
+	info := linkInfo{iface: iface}
+	state := ""
+	for _, line := range strings.Split(out, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "state":
+			state = value
+		case "BSSID":
+			info.bssid = normalizeBSSID(value)
+		case "SSID":
+			info.ssid = value
+		case "agrCtlRSSI":
+			if n, err := strconv.Atoi(value); err == nil && n < 0 {
+				info.signalDbm, info.hasSignal = n, true
+			}
+		case "agrCtlNoise":
+			if n, err := strconv.Atoi(value); err == nil && n < 0 {
+				info.noiseDbm, info.hasNoise = n, true
+			}
+		case "lastTxRate":
+			info.txBitrate, _ = strconv.ParseFloat(value, 64)
+		case "channel":
+			ch, _, _ := strings.Cut(value, ",")
+			if n, err := strconv.Atoi(ch); err == nil {
+				info.freqMHz = freqFromChannel(n, "")
+			}
+		}
+	}
+	info.connected = state == "running" && info.ssid != ""
+	return info
+}
+
+// systemProfiler is the part of `system_profiler -json SPAirPortDataType`
+// that describes the current association.
+type systemProfiler struct {
+	AirPort []struct {
+		Interfaces []struct {
+			Name    string `json:"_name"`
+			Current *struct {
+				SSID    string `json:"_name"`
+				BSSID   string `json:"spairport_network_bssid"`
+				Channel string `json:"spairport_network_channel"`
+				Rate    any    `json:"spairport_network_rate"`
+				// "-55 dBm / -94 dBm"
+				SignalNoise string `json:"spairport_signal_noise"`
+			} `json:"spairport_current_network_information"`
+		} `json:"spairport_airport_interfaces"`
+	} `json:"SPAirPortDataType"`
+}
+
+// parseSystemProfiler reads iface's association from system_profiler's
+// JSON. Without location permission macOS omits the BSSID and the SSID.
+func parseSystemProfiler(iface string, data []byte) (linkInfo, error) {
+	var doc systemProfiler
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return linkInfo{}, fmt.Errorf("system_profiler: %w", err)
+	}
+	info := linkInfo{iface: iface}
+	for _, ap := range doc.AirPort {
+		for _, i := range ap.Interfaces {
+			if i.Name != iface || i.Current == nil {
+				continue
+			}
+			cur := i.Current
+			info.connected = true
+			info.ssid = cur.SSID
+			info.bssid = normalizeBSSID(cur.BSSID)
+			// "36 (5GHz, 80MHz)"
+			ch, band, _ := strings.Cut(cur.Channel, "(")
+			if n, err := strconv.Atoi(strings.TrimSpace(ch)); err == nil {
+				info.freqMHz = freqFromChannel(n, strings.TrimSpace(band))
+			}
+			switch r := cur.Rate.(type) {
+			case float64:
+				info.txBitrate = r
+			case string:
+				info.txBitrate, _ = strconv.ParseFloat(firstField(r), 64)
+			}
+			signal, noise, _ := strings.Cut(cur.SignalNoise, "/")
+			if n, err := strconv.Atoi(firstField(signal)); err == nil {
+				info.signalDbm, info.hasSignal = n, true
+			}
+			if n, err := strconv.Atoi(firstField(noise)); err == nil {
+				info.noiseDbm, info.hasNoise = n, true
+			}
+		}
+	}
+	return info, nil
+}
+
+// normalizeBSSID lower-cases a BSSID and pads each octet to two digits;
+// macOS prints "a:b:c:d:e:f" for leading zeros.
+func normalizeBSSID(s string) string {
+	parts := strings.Split(strings.ToLower(strings.TrimSpace(s)), ":")
+	if len(parts) != 6 {
+		return strings.ToLower(strings.TrimSpace(s))
+	}
+	for i, p := range parts {
+		if len(p) == 1 {
+			parts[i] = "0" + p
+		}
+	}
+	return strings.Join(parts, ":")
+}
@@ -0,0 +1,534 @@
+package wifiprobe
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"edge-monitor-app/internal/metrics"
+	"edge-monitor-app/internal/statebus"
+)
+
+// WiFi link sources. Paths are variables so hosts with non-standard layouts
+// (or containers with host paths mounted elsewhere) can be supported.
+var (
+	sysClassNetPath  = "/sys/class/net"
+	procWirelessPath = "/proc/net/wireless"
+)
+
+const iwTimeout = 2 * time.Second
+
+// maxTrackedBSSIDs bounds the per-BSSID connection-time series kept per
+// interface; the least recently used BSSID is dropped beyond it.
+const maxTrackedBSSIDs = 16
+
+// linkInfo is one sample of a wireless interface's association state.
+// Optional fields are only exported when their has* flag is set.
+type linkInfo struct {
+	iface     string
+	connected bool
+	bssid     string
+	ssid      string
+	freqMHz   int
+
+	signalDbm   int
+	hasSignal   bool
+	noiseDbm    int
+	hasNoise    bool
+	txBitrate   float64 // Mbit/s
+	rxBitrate   float64 // Mbit/s
+	txRetries   uint64
+	txFailed    uint64
+	hasCounters bool
+}
+
+// linkSource reads the current state of the given wireless interfaces.
+type linkSource interface {
+	name() string
+	interfaces() ([]string, error)
+	link(ctx context.Context, iface string) (linkInfo, error)
+}
+
+var errNoLinkSource = errors.New("no wifi link source available")
+
+// newLinkSource picks the WiFi data source for mode: "netlink" (nl80211),
+// "iw" (parse iw output), "airport" (macOS), "netsh" (Windows), or "auto"
+// (netlink, then iw, then the platform's own tool).
+func newLinkSource(mode string) (linkSource, error) {
+	switch mode {
+	case "netlink":
+		return newNL80211Source()
+	case "iw":
+		return newIWSource()
+	case "airport", "netsh":
+		src, err := newPlatformSource()
+		if err != nil {
+			return nil, err
+		}
+		if src.name() != mode {
+			return nil, fmt.Errorf("WIFI_COLLECTOR %q is not available on this platform (use %q)", mode, src.name())
+		}
+		return src, nil
+	case "auto", "":
+		if src, err := newNL80211Source(); err == nil {
+			return src, nil
+		}
+		if src, err := newIWSource(); err == nil {
+			return src, nil
+		}
+		if src, err := newPlatformSource(); err == nil {
+			return src, nil
+		}
+		return nil, errNoLinkSource
+	default:
+		return nil, fmt.Errorf("unknown WIFI_COLLECTOR %q (valid: auto, netlink, iw, airport, netsh, off)", mode)
+	}
+}
+
+// ifaceState carries what is needed between samples to turn the kernel's
+// cumulative counters into Prometheus counters and to spot roams.
+type ifaceState struct {
+	bssid     string
+	ssid      string
+	channel   string
+	txRetries uint64
+	txFailed  uint64
+	seen      bool
+
+	associatedAt time.Time
+	lastSample   time.Time
+	lastRoamAt   time.Time
+	// roamMaxLatency is the worst probe latency seen inside the roam window.
+	roamMaxLatency time.Duration
+	// bssidSeen is the last time each tracked BSSID was associated.
+	bssidSeen map[string]time.Time
+}
+
+// wifiCollector samples link state for the configured (or discovered)
+// wireless interfaces and exports it as wifi_* metrics.
+type wifiCollector struct {
+	src        linkSource
+	configured []string
+	roamWindow time.Duration
+
+	mu     sync.Mutex
+	states map[string]*ifaceState
+}
+
+func newWiFiCollector(src linkSource, ifaces []string, roamWindow time.Duration) *wifiCollector {
+	return &wifiCollector{
+		src:        src,
+		configured: ifaces,
+		roamWindow: roamWindow,
+		states:     make(map[string]*ifaceState),
+	}
+}
+
+// collect samples every interface once. Errors are logged per interface so
+// one broken radio does not hide the others.
+func (c *wifiCollector) collect(ctx context.Context) {
+	ifaces := c.configured
+	if len(ifaces) == 0 {
+		var err error
+		ifaces, err = c.src.interfaces()
+		if err != nil {
+			slog.Warn("wifi interface listing failed", "source", c.src.name(), "error", err)
+			return
+		}
+	}
+
+	infos := make([]linkInfo, 0, len(ifaces))
+	for _, iface := range ifaces {
+		info, err := c.src.link(ctx, iface)
+		if err != nil {
+			slog.Warn("wifi link read failed", "interface", iface, "source", c.src.name(), "error", err)
+			continue
+		}
+		infos = append(infos, info)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	present := make(map[string]bool, len(ifaces))
+	for _, iface := range ifaces {
+		present[iface] = true
+	}
+	for _, info := range infos {
+		c.record(info, now)
+	}
+
+	for iface, st := range c.states {
+		if !present[iface] {
+			deleteWiFiMetrics(iface, st)
+			delete(c.states, iface)
+		}
+	}
+}
+
+func (c *wifiCollector) record(info linkInfo, now time.Time) {
+	st := c.states[info.iface]
+	if st == nil {
+		st = &ifaceState{bssidSeen: make(map[string]time.Time)}
+		c.states[info.iface] = st
+		wifiRoamEvents.WithLabelValues(info.iface).Add(0)
+		wifiRoamProbeErrors.WithLabelValues(info.iface).Add(0)
+	}
+
+	// Credit the time since the previous sample to the BSSID that was
+	// associated throughout it.
+	if st.bssid != "" && info.connected && info.bssid == st.bssid && !st.lastSample.IsZero() {
+		wifiBSSIDConnectedSeconds.WithLabelValues(info.iface, st.bssid).Add(now.Sub(st.lastSample).Seconds())
+	}
+	st.lastSample = now
+
+	wifiConnected.WithLabelValues(info.iface).Set(metrics.BoolToFloat(info.connected))
+	if !info.connected {
+		if st.bssid != "" {
+			wifiLinkInfo.DeleteLabelValues(info.iface, st.bssid, st.ssid, st.channel)
+			slog.Warn("wifi disassociated", "interface", info.iface, "bssid", st.bssid,
+				"associated_seconds", now.Sub(st.associatedAt).Seconds())
+			statebus.Publish(statebus.Change{Time: now, Service: "wifi-probe", Probe: "wifi_link", Target: info.iface, Up: false})
+		}
+		st.bssid, st.ssid, st.channel = "", "", ""
+		st.seen = false
+		st.associatedAt = time.Time{}
+		deleteWiFiLinkGauges(info.iface)
+		return
+	}
+
+	channel := ""
+	if ch := channelFromFreq(info.freqMHz); ch > 0 {
+		channel = strconv.Itoa(ch)
+	}
+	if info.bssid != st.bssid || info.ssid != st.ssid || channel != st.channel {
+		if st.bssid != "" {
+			wifiLinkInfo.DeleteLabelValues(info.iface, st.bssid, st.ssid, st.channel)
+		}
+		if st.bssid != "" && info.bssid != st.bssid {
+			wifiRoamEvents.WithLabelValues(info.iface).Inc()
+			wifiLastRoam.WithLabelValues(info.iface).Set(float64(now.Unix()))
+			wifiRoamProbeLatency.WithLabelValues(info.iface).Set(0)
+			st.lastRoamAt = now
+			st.roamMaxLatency = 0
+			slog.Warn("wifi roam", "interface", info.iface, "from_bssid", st.bssid, "to_bssid", info.bssid, "ssid", info.ssid,
+				"previous_associated_seconds", now.Sub(st.associatedAt).Seconds())
+		}
+		wifiLinkInfo.WithLabelValues(info.iface, info.bssid, info.ssid, channel).Set(1)
+		if st.bssid == "" {
+			statebus.Publish(statebus.Change{Time: now, Service: "wifi-probe", Probe: "wifi_link", Target: info.iface, Up: true})
+		}
+		if info.bssid != st.bssid {
+			// Station counters restart with a new association.
+			st.seen = false
+			st.associatedAt = now
+			c.trackBSSID(info.iface, st, info.bssid, now)
+		}
+		st.bssid, st.ssid, st.channel = info.bssid, info.ssid, channel
+	}
+	st.bssidSeen[info.bssid] = now
+	wifiAssociationSeconds.WithLabelValues(info.iface).Set(now.Sub(st.associatedAt).Seconds())
+
+	if info.freqMHz > 0 {
+		wifiFrequency.WithLabelValues(info.iface).Set(float64(info.freqMHz))
+	}
+	if ch := channelFromFreq(info.freqMHz); ch > 0 {
+		wifiChannel.WithLabelValues(info.iface).Set(float64(ch))
+	}
+	if info.hasSignal {
+		wifiSignal.WithLabelValues(info.iface).Set(float64(info.signalDbm))
+	}
+	if info.hasNoise {
+		wifiNoise.WithLabelValues(info.iface).Set(float64(info.noiseDbm))
+	}
+	if info.hasSignal && info.hasNoise {
+		wifiSNR.WithLabelValues(info.iface).Set(float64(info.signalDbm - info.noiseDbm))
+	}
+	if info.txBitrate > 0 {
+		wifiTxBitrate.WithLabelValues(info.iface).Set(info.txBitrate)
+	}
+	if info.rxBitrate > 0 {
+		wifiRxBitrate.WithLabelValues(info.iface).Set(info.rxBitrate)
+	}
+
+	if info.hasCounters {
+		if st.seen {
+			wifiTxRetries.WithLabelValues(info.iface).Add(float64(counterDelta(st.txRetries, info.txRetries)))
+			wifiTxFailed.WithLabelValues(info.iface).Add(float64(counterDelta(st.txFailed, info.txFailed)))
+		} else {
+			wifiTxRetries.WithLabelValues(info.iface).Add(0)
+			wifiTxFailed.WithLabelValues(info.iface).Add(0)
+		}
+		st.txRetries, st.txFailed, st.seen = info.txRetries, info.txFailed, true
+	}
+}
+
+// trackBSSID registers bssid for per-BSSID connection time, evicting the
+// least recently associated BSSID once maxTrackedBSSIDs is reached.
+func (c *wifiCollector) trackBSSID(iface string, st *ifaceState, bssid string, now time.Time) {
+	if _, ok := st.bssidSeen[bssid]; ok {
+		return
+	}
+	if len(st.bssidSeen) >= maxTrackedBSSIDs {
+		oldest, oldestAt := "", now
+		for b, at := range st.bssidSeen {
+			if at.Before(oldestAt) {
+				oldest, oldestAt = b, at
+			}
+		}
+		delete(st.bssidSeen, oldest)
+		wifiBSSIDConnectedSeconds.DeleteLabelValues(iface, oldest)
+	}
+	st.bssidSeen[bssid] = now
+	wifiBSSIDConnectedSeconds.WithLabelValues(iface, bssid).Add(0)
+}
+
+// observeProbe attributes a probe result to any interface that roamed within
+// the roam window, so latency spikes and failures caused by the handover
+// show up next to the roam itself. It returns the interfaces that matched.
+func (c *wifiCollector) observeProbe(latency time.Duration, err error) []string {
+	if c.roamWindow <= 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	var roamed []string
+	for iface, st := range c.states {
+		if st.lastRoamAt.IsZero() || now.Sub(st.lastRoamAt) > c.roamWindow {
+			continue
+		}
+		roamed = append(roamed, iface)
+		if err != nil {
+			wifiRoamProbeErrors.WithLabelValues(iface).Inc()
+			continue
+		}
+		if latency > st.roamMaxLatency {
+			st.roamMaxLatency = latency
+			wifiRoamProbeLatency.WithLabelValues(iface).Set(latency.Seconds())
+		}
+	}
+	return roamed
+}
+
+// counterDelta returns the increase of a cumulative kernel counter, treating
+// a decrease as a reset.
+func counterDelta(prev, cur uint64) uint64 {
+	if cur >= prev {
+		return cur - prev
+	}
+	return cur
+}
+
+// freqFromChannel maps an IEEE channel number to its center frequency.
+// Tools that report only a channel give the band separately, if at all;
+// without one, channels above 14 are taken as 5 GHz.
+func freqFromChannel(ch int, band string) int {
+	switch {
+	case ch <= 0:
+		return 0
+	case strings.HasPrefix(band, "6"):
+		return 5950 + 5*ch
+	case ch == 14:
+		return 2484
+	case ch < 14:
+		return 2407 + 5*ch
+	}
+	return 5000 + 5*ch
+}
+
+// channelFromFreq maps a center frequency to its IEEE channel number, or 0
+// if the band is unknown.
+func channelFromFreq(mhz int) int {
+	switch {
+	case mhz == 2484:
+		return 14
+	case mhz >= 2412 && mhz < 2484:
+		return (mhz - 2407) / 5
+	case mhz >= 5955 && mhz <= 7115:
+		return (mhz - 5950) / 5
+	case mhz >= 5000 && mhz < 5955:
+		return (mhz - 5000) / 5
+	}
+	return 0
+}
+
+// sysfsWirelessInterfaces lists interfaces with a sysfs wireless directory.
+func sysfsWirelessInterfaces() []string {
+	matches, _ := filepath.Glob(filepath.Join(sysClassNetPath, "*", "wireless"))
+	out := make([]string, 0, len(matches))
+	for _, m := range matches {
+		out = append(out, filepath.Base(filepath.Dir(m)))
+	}
+	return out
+}
+
+// procWirelessNoise reads the noise level for iface from /proc/net/wireless.
+// Drivers that do not report noise use -256, which is treated as missing.
+func procWirelessNoise(iface string) (int, bool) {
+	f, err := os.Open(procWirelessPath)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Inter-| sta-|   Quality        |   Discarded packets ...
+		//  face | tus | link level noise |  nwid  crypt ...
+		// wlan0: 0000   60.  -50.  -256  ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || strings.TrimSuffix(fields[0], ":") != iface {
+			continue
+		}
+		n, err := strconv.ParseFloat(strings.TrimSuffix(fields[4], "."), 64)
+		if err != nil || n <= -256 || n >= 0 {
+			return 0, false
+		}
+		return int(n), true
+	}
+	return 0, false
+}
+
+// iwSource reads link state by running iw. It is the fallback for hosts or
+// containers where the nl80211 netlink family is not reachable.
+type iwSource struct {
+	path string
+}
+
+func newIWSource() (*iwSource, error) {
+	path, err := exec.LookPath("iw")
+	if err != nil {
+		return nil, err
+	}
+	return &iwSource{path: path}, nil
+}
+
+func (s *iwSource) name() string { return "iw" }
+
+func (s *iwSource) run(ctx context.Context, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, iwTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, s.path, args...).Output()
+	if err != nil {
+		return "", fmt.Errorf("iw %s: %w", strings.Join(args, " "), err)
+	}
+	return string(out), nil
+}
+
+func (s *iwSource) interfaces() ([]string, error) {
+	out, err := s.run(context.Background(), "dev")
+	if err != nil {
+		if ifaces := sysfsWirelessInterfaces(); len(ifaces) > 0 {
+			return ifaces, nil
+		}
+		return nil, err
+	}
+	var ifaces []string
+	for _, line := range strings.Split(out, "\n") {
+		if name, ok := strings.CutPrefix(strings.TrimSpace(line), "Interface "); ok {
+			ifaces = append(ifaces, strings.TrimSpace(name))
+		}
+	}
+	return ifaces, nil
+}
+
+func (s *iwSource) link(ctx context.Context, iface string) (linkInfo, error) {
+	out, err := s.run(ctx, "dev", iface, "link")
+	if err != nil {
+		return linkInfo{}, err
+	}
+	info := parseIWLink(iface, out)
+	if !info.connected {
+		return info, nil
+	}
+	if dump, err := s.run(ctx, "dev", iface, "station", "dump"); err == nil {
+		parseIWStationDump(&info, dump)
+	}
+	info.noiseDbm, info.hasNoise = procWirelessNoise(iface)
+	return info, nil
+}
+
+// parseIWLink parses `iw dev <iface> link` output:
+//
+//	Connected to aa:bb:cc:dd:ee:ff (on wlan0)
+//		SSID: home
+//		freq: 5180
+//		signal: -52 dBm
+//		rx bitrate: 433.3 MBit/s VHT-MCS 9 80MHz short GI VHT-NSS 1
+//		tx bitrate: 390.0 MBit/s VHT-MCS 8 80MHz short GI VHT-NSS 1
+func parseIWLink(iface, out string) linkInfo {
+	info := linkInfo{iface: iface}
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if rest, ok := strings.CutPrefix(line, "Connected to "); ok {
+			info.connected = true
+			if f := strings.Fields(rest); len(f) > 0 {
+				info.bssid = strings.ToLower(f[0])
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "SSID":
+			info.ssid = value
+		case "freq":
+			// Newer iw prints fractional MHz ("5180.0").
+			if f, err := strconv.ParseFloat(firstField(value), 64); err == nil {
+				info.freqMHz = int(f)
+			}
+		case "signal":
+			if n, err := strconv.Atoi(firstField(value)); err == nil {
+				info.signalDbm, info.hasSignal = n, true
+			}
+		case "rx bitrate":
+			info.rxBitrate, _ = strconv.ParseFloat(firstField(value), 64)
+		case "tx bitrate":
+			info.txBitrate, _ = strconv.ParseFloat(firstField(value), 64)
+		}
+	}
+	return info
+}
+
+// parseIWStationDump fills the retry/failure counters from
+// `iw dev <iface> station dump`. In station mode the only entry is the AP.
+func parseIWStationDump(info *linkInfo, out string) {
+	for _, line := range strings.Split(out, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseUint(firstField(value), 10, 64)
+		if err != nil {
+			continue
+		}
+		switch key {
+		case "tx retries":
+			info.txRetries, info.hasCounters = n, true
+		case "tx failed":
+			info.txFailed, info.hasCounters = n, true
+		}
+	}
+}
+
+func firstField(s string) string {
+	if f := strings.Fields(s); len(f) > 0 {
+		return f[0]
+	}
+	return ""
+}
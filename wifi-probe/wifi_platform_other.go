@@ -0,0 +1,11 @@
+//go:build !darwin && !windows
+
+package wifiprobe
+
+import "errors"
+
+// newPlatformSource is the macOS or Windows tool-based source; Linux and
+// other platforms use nl80211 or iw.
+func newPlatformSource() (linkSource, error) {
+	return nil, errors.New("no platform wifi tool on this platform")
+}
@@ -0,0 +1,136 @@
+//go:build windows
+
+package wifiprobe
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// netshSource reads link state on Windows from `netsh wlan show
+// interfaces`. netsh localizes its output; the parser knows the English
+// field names, so other display languages report interfaces without
+// association details.
+type netshSource struct {
+	path string
+}
+
+func newPlatformSource() (linkSource, error) {
+	path, err := exec.LookPath("netsh")
+	if err != nil {
+		return nil, err
+	}
+	return &netshSource{path: path}, nil
+}
+
+func (s *netshSource) name() string { return "netsh" }
+
+func (s *netshSource) show(ctx context.Context) (map[string]linkInfo, []string, error) {
+	ctx, cancel := context.WithTimeout(ctx, iwTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, s.path, "wlan", "show", "interfaces").Output()
+	if err != nil {
+		return nil, nil, fmt.Errorf("netsh wlan show interfaces: %w", err)
+	}
+	infos, names := parseNetshInterfaces(string(out))
+	return infos, names, nil
+}
+
+func (s *netshSource) interfaces() ([]string, error) {
+	_, names, err := s.show(context.Background())
+	return names, err
+}
+
+func (s *netshSource) link(ctx context.Context, iface string) (linkInfo, error) {
+	infos, _, err := s.show(ctx)
+	if err != nil {
+		return linkInfo{}, err
+	}
+	info, ok := infos[iface]
+	if !ok {
+		return linkInfo{}, fmt.Errorf("netsh does not list interface %q", iface)
+	}
+	return info, nil
+}
+
+// parseNetshInterfaces parses `netsh wlan show interfaces` output, one
+// block per interface:
+//
+//	Name                   : Wi-Fi
+//	State                  : connected
+//	SSID                   : home
+//	BSSID                  : aa:bb:cc:dd:ee:ff
+//	Band                   : 5 GHz
+//	Channel                : 36
+//	Receive rate (Mbps)    : 1201
+//	Transmit rate (Mbps)   : 1201
+//	Signal                 : 92%
+//	Rssi                   : -48
+//
+// Band and Rssi only appear on recent Windows 11 builds; without Rssi the
+// signal quality percentage is converted to dBm the way Windows derives
+// it (quality = 2 * (dBm + 100)).
+func parseNetshInterfaces(out string) (map[string]linkInfo, []string) {
+	infos := make(map[string]linkInfo)
+	var names []string
+	var cur *linkInfo
+	var band string
+	channel := 0
+	finish := func() {
+		if cur == nil {
+			return
+		}
+		cur.freqMHz = freqFromChannel(channel, band)
+		infos[cur.iface] = *cur
+		cur, band, channel = nil, "", 0
+	}
+
+	for _, line := range strings.Split(out, "\n") {
+		key, value, ok := strings.Cut(line, " : ")
+		if !ok {
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if key == "Name" {
+			finish()
+			cur = &linkInfo{iface: value}
+			names = append(names, value)
+			continue
+		}
+		if cur == nil {
+			continue
+		}
+		switch key {
+		case "State":
+			cur.connected = strings.EqualFold(value, "connected")
+		case "SSID":
+			cur.ssid = value
+		case "BSSID":
+			cur.bssid = strings.ToLower(value)
+		case "Band":
+			band = value
+		case "Channel":
+			channel, _ = strconv.Atoi(value)
+		case "Receive rate (Mbps)":
+			cur.rxBitrate, _ = strconv.ParseFloat(value, 64)
+		case "Transmit rate (Mbps)":
+			cur.txBitrate, _ = strconv.ParseFloat(value, 64)
+		case "Rssi":
+			if n, err := strconv.Atoi(value); err == nil {
+				cur.signalDbm, cur.hasSignal = n, true
+			}
+		case "Signal":
+			if cur.hasSignal {
+				continue
+			}
+			if pct, err := strconv.Atoi(strings.TrimSuffix(value, "%")); err == nil {
+				cur.signalDbm, cur.hasSignal = pct/2-100, true
+			}
+		}
+	}
+	finish()
+	return infos, names
+}